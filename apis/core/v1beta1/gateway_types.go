@@ -54,12 +54,24 @@ type GatewaySpec struct {
 	//+kubebuilder:default=etcd
 	StorageType cfgv1beta1.StorageType `json:"storageType,omitempty"`
 
-	NodeSelector      map[string]string           `json:"nodeSelector,omitempty"`
-	Tolerations       []corev1.Toleration         `json:"tolerations,omitempty"`
-	Affinity          *corev1.Affinity            `json:"affinity,omitempty"`
-	ExtraVolumeMounts []opnimeta.ExtraVolumeMount `json:"extraVolumeMounts,omitempty"`
-	ExtraEnvVars      []corev1.EnvVar             `json:"extraEnvVars,omitempty"`
-	Profiling         cfgv1beta1.ProfilingSpec    `json:"profiling,omitempty"`
+	NodeSelector      map[string]string            `json:"nodeSelector,omitempty"`
+	Tolerations       []corev1.Toleration          `json:"tolerations,omitempty"`
+	Affinity          *corev1.Affinity             `json:"affinity,omitempty"`
+	ExtraVolumeMounts []opnimeta.ExtraVolumeMount  `json:"extraVolumeMounts,omitempty"`
+	ExtraEnvVars      []corev1.EnvVar              `json:"extraEnvVars,omitempty"`
+	Profiling         cfgv1beta1.ProfilingSpec     `json:"profiling,omitempty"`
+	StaleClusters     cfgv1beta1.StaleClustersSpec `json:"staleClusters,omitempty"`
+
+	// Number of gateway replicas. Running more than one replica requires
+	// high availability mode to be enabled.
+	//+kubebuilder:default=1
+	Replicas *int32            `json:"replicas,omitempty"`
+	HA       cfgv1beta1.HASpec `json:"ha,omitempty"`
+
+	HealthHistory cfgv1beta1.HealthHistorySpec `json:"healthHistory,omitempty"`
+
+	RemoteWriteBuffer cfgv1beta1.RemoteWriteBufferSpec `json:"remoteWriteBuffer,omitempty"`
+	MetricsImport     cfgv1beta1.MetricsImportSpec     `json:"metricsImport,omitempty"`
 }
 
 func (g *GatewaySpec) GetServiceType() corev1.ServiceType {
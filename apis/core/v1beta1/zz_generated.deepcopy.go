@@ -557,6 +557,21 @@ func (in *GatewaySpec) DeepCopyInto(out *GatewaySpec) {
 		}
 	}
 	out.Profiling = in.Profiling
+	out.StaleClusters = in.StaleClusters
+	if in.StaleClusters.ExemptLabels != nil {
+		in, out := &in.StaleClusters.ExemptLabels, &out.StaleClusters.ExemptLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	out.HA = in.HA
+	out.HealthHistory = in.HealthHistory
+	out.RemoteWriteBuffer = in.RemoteWriteBuffer
+	out.MetricsImport = in.MetricsImport
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewaySpec.
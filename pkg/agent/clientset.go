@@ -10,6 +10,8 @@ import (
 type ClientSet interface {
 	controlv1.HealthClient
 	capabilityv1.NodeClient
+	controlv1.KeyRotationClient
+	controlv1.DiagnosticsClient
 	ClientConn() grpc.ClientConnInterface
 }
 
@@ -17,6 +19,8 @@ type clientSet struct {
 	cc grpc.ClientConnInterface
 	controlv1.HealthClient
 	capabilityv1.NodeClient
+	controlv1.KeyRotationClient
+	controlv1.DiagnosticsClient
 }
 
 func (c *clientSet) ClientConn() grpc.ClientConnInterface {
@@ -25,8 +29,10 @@ func (c *clientSet) ClientConn() grpc.ClientConnInterface {
 
 func NewClientSet(cc grpc.ClientConnInterface) ClientSet {
 	return &clientSet{
-		cc:           cc,
-		HealthClient: controlv1.NewHealthClient(cc),
-		NodeClient:   capabilityv1.NewNodeClient(cc),
+		cc:                cc,
+		HealthClient:      controlv1.NewHealthClient(cc),
+		NodeClient:        capabilityv1.NewNodeClient(cc),
+		KeyRotationClient: controlv1.NewKeyRotationClient(cc),
+		DiagnosticsClient: controlv1.NewDiagnosticsClient(cc),
 	}
 }
@@ -4,20 +4,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
+	gsync "github.com/kralicky/gpkg/sync"
 	"github.com/rancher/opni/pkg/ident/identserver"
 	"github.com/rancher/opni/pkg/patch"
 	"github.com/rancher/opni/pkg/versions"
 	"github.com/samber/lo"
 	"github.com/spf13/afero"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -29,6 +33,7 @@ import (
 	"github.com/rancher/opni/pkg/bootstrap"
 	"github.com/rancher/opni/pkg/clients"
 	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/diagnostics"
 	"github.com/rancher/opni/pkg/health"
 	"github.com/rancher/opni/pkg/health/annotations"
 	"github.com/rancher/opni/pkg/ident"
@@ -73,6 +78,8 @@ type Agent struct {
 	keyringStore     storage.KeyringStore
 	gatewayClient    clients.GatewayClient
 	trust            trust.Strategy
+	pluginVerifier   *patch.PluginVerifier
+	pluginManifest   *atomic.Pointer[controlv1.PluginManifest]
 
 	healthzMu *sync.Mutex
 	healthz   *uint32
@@ -123,9 +130,23 @@ func New(ctx context.Context, conf *v1beta1.AgentConfig, opts ...AgentOption) (*
 		}
 		level = l.Level()
 	}
-	lg := logger.New(logger.WithLogLevel(level)).Named("agent")
+	logs := diagnostics.NewLogBuffer(diagnosticsLogLines)
+	lg := logger.New(
+		logger.WithLogLevel(level),
+		logger.WithZapOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewTee(core, logs.Core(level))
+		})),
+	).Named("agent")
 	lg.Debugf("using log level: %s", level)
 
+	pluginVerifier, err := patch.NewPluginVerifier(conf.Spec.Plugins.Verification, lg.Named("verify"))
+	if err != nil {
+		return nil, fmt.Errorf("error configuring plugin verification: %w", err)
+	}
+	if !pluginVerifier.Enabled() {
+		lg.Warn("plugin signature verification is disabled; plugins received from the gateway will not be verified (set plugins.verification.policy to enable it)")
+	}
+
 	var pl *plugins.PluginLoader
 	if options.unmanagedPluginLoader != nil {
 		pl = options.unmanagedPluginLoader
@@ -146,15 +167,20 @@ func New(ctx context.Context, conf *v1beta1.AgentConfig, opts ...AgentOption) (*
 	*healthz = (1 << len(healthzConditions)) - 1
 	healthzMu := &sync.Mutex{}
 
-	router.GET("/healthz", func(c *gin.Context) {
+	healthzMessages := func() []string {
 		healthzMu.Lock()
+		defer healthzMu.Unlock()
 		messages := []string{}
 		for k, v := range healthzConditions {
 			if *healthz&k != 0 {
 				messages = append(messages, v)
 			}
 		}
-		healthzMu.Unlock()
+		return messages
+	}
+
+	router.GET("/healthz", func(c *gin.Context) {
+		messages := healthzMessages()
 		if len(messages) > 0 {
 			c.String(http.StatusServiceUnavailable, strings.Join(messages, ", "))
 			return
@@ -278,6 +304,11 @@ func New(ctx context.Context, conf *v1beta1.AgentConfig, opts ...AgentOption) (*
 		return nil, fmt.Errorf("error configuring gateway client: %w", err)
 	}
 	controlv1.RegisterIdentityServer(gatewayClient, identserver.NewFromProvider(ip))
+	controlv1.RegisterKeyRotationServer(gatewayClient, &keyRotationServer{
+		logger:        lg.Named("keyrotation"),
+		keyringStore:  ks,
+		gatewayClient: gatewayClient,
+	})
 
 	hm := health.NewAggregator(health.WithStaticAnnotations(map[string]string{
 		annotations.AgentVersion: annotations.Version2,
@@ -289,6 +320,25 @@ func New(ctx context.Context, conf *v1beta1.AgentConfig, opts ...AgentOption) (*
 		hm.AddClient(m.Filename(), client)
 	}))
 
+	pluginManifest := &atomic.Pointer[controlv1.PluginManifest]{}
+	nodePlugins := &gsync.Map[string, controlv1.HealthClient]{}
+	pl.Hook(hooks.OnLoadMC(func(p types.CapabilityNodePlugin, m meta.PluginMeta, cc *grpc.ClientConn) {
+		nodePlugins.Store(m.Module, controlv1.NewHealthClient(cc))
+	}))
+	diagnosticsServer := newDiagnosticsServer(diagnosticsSources{
+		config:      conf.Spec,
+		logs:        logs,
+		health:      hm,
+		healthz:     healthzMessages,
+		manifest:    pluginManifest,
+		nodePlugins: nodePlugins,
+	}, lg.Named("diagnostics"))
+	controlv1.RegisterDiagnosticsServer(gatewayClient, diagnosticsServer)
+	// allows collecting a support bundle while the agent is disconnected
+	routerMutex.Lock()
+	router.GET("/debug/support-bundle", supportBundleHandler(diagnosticsServer))
+	routerMutex.Unlock()
+
 	pl.Hook(hooks.OnLoadMC(func(ext types.StreamAPIExtensionPlugin, md meta.PluginMeta, cc *grpc.ClientConn) {
 		lg.With(
 			zap.String("plugin", md.Module),
@@ -308,6 +358,8 @@ func New(ctx context.Context, conf *v1beta1.AgentConfig, opts ...AgentOption) (*
 		keyringStore:     ks,
 		trust:            trust,
 		gatewayClient:    gatewayClient,
+		pluginVerifier:   pluginVerifier,
+		pluginManifest:   pluginManifest,
 
 		healthzMu: healthzMu,
 		healthz:   healthz,
@@ -347,6 +399,7 @@ func (a *Agent) ListenAndServe(ctx context.Context) error {
 			}
 			break
 		}
+		a.pluginManifest.Store(manifests)
 		// eventually passed to runGatewayClient
 		buildInfo, ok := versions.ReadBuildInfo()
 		if !ok {
@@ -360,6 +413,7 @@ func (a *Agent) ListenAndServe(ctx context.Context) error {
 		ctx = metadata.AppendToOutgoingContext(ctx,
 			controlv1.ManifestDigestKey, manifests.Digest(),
 			controlv1.AgentBuildInfoKey, string(buildInfoData),
+			controlv1.AgentIDKey, a.tenantID,
 		)
 
 		done := make(chan struct{})
@@ -513,12 +567,21 @@ func (a *Agent) syncPlugins(ctx context.Context) (_ *controlv1.PluginManifest, r
 		return nil, err
 	}
 
-	syncResp, err := manifestClient.SyncPluginManifest(ctx, archive.ToManifest(), grpc.UseCompressor("zstd"))
+	// the authenticated sync allows the gateway to choose which plugins to
+	// sync if a staged rollout is in progress
+	syncResp, err := syncAgentPluginManifest(ctx, manifestClient, archive.ToManifest())
+	if status.Code(err) == codes.Unimplemented {
+		syncResp, err = manifestClient.SyncPluginManifest(ctx, archive.ToManifest(), grpc.UseCompressor("zstd"))
+	}
 	if err != nil {
 		return nil, err
 	}
 	a.Logger.Info("received patch manifest from gateway")
 
+	if err := a.pluginVerifier.Verify(syncResp.DesiredState, syncResp.RequiredPatches); err != nil {
+		return nil, err
+	}
+
 	patchClient, err := patch.NewPatchClient(a.config.Plugins, a.Logger)
 	if err != nil {
 		return nil, err
@@ -530,3 +593,17 @@ func (a *Agent) syncPlugins(ctx context.Context) (_ *controlv1.PluginManifest, r
 
 	return syncResp.DesiredState, nil
 }
+
+func syncAgentPluginManifest(ctx context.Context, client controlv1.PluginSyncClient, manifest *controlv1.PluginManifest) (*controlv1.SyncResults, error) {
+	stream, err := client.SyncAgentPluginManifest(ctx, grpc.UseCompressor("zstd"))
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(manifest); err != nil {
+		// the error is returned by CloseAndRecv
+		if !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	}
+	return stream.CloseAndRecv()
+}
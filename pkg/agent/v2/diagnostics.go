@@ -0,0 +1,118 @@
+package v2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	gsync "github.com/kralicky/gpkg/sync"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/diagnostics"
+	"github.com/rancher/opni/pkg/health"
+	"github.com/rancher/opni/pkg/versions"
+)
+
+// Number of recent log lines retained for support bundles
+const diagnosticsLogLines = 2000
+
+type nodePluginStatus struct {
+	Module     string   `json:"module"`
+	Ready      bool     `json:"ready"`
+	Conditions []string `json:"conditions,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+type diagnosticsSources struct {
+	config      v1beta1.AgentConfigSpec
+	logs        *diagnostics.LogBuffer
+	health      *health.Aggregator
+	healthz     func() []string
+	manifest    *atomic.Pointer[controlv1.PluginManifest]
+	nodePlugins *gsync.Map[string, controlv1.HealthClient]
+}
+
+func newDiagnosticsServer(src diagnosticsSources, lg *zap.SugaredLogger) *diagnostics.Server {
+	return diagnostics.NewServer([]diagnostics.Source{
+		diagnostics.JSONSource("config.json", func(context.Context) (any, error) {
+			return diagnostics.RedactSecrets(src.config)
+		}),
+		diagnostics.JSONSource("version.json", func(context.Context) (any, error) {
+			buildInfo, ok := versions.ReadBuildInfo()
+			if !ok {
+				return nil, errors.New("build info not available")
+			}
+			return buildInfo, nil
+		}),
+		diagnostics.JSONSource("healthz.json", func(context.Context) (any, error) {
+			return map[string][]string{
+				"conditions": src.healthz(),
+			}, nil
+		}),
+		diagnostics.JSONSource("health.json", func(ctx context.Context) (any, error) {
+			return src.health.GetHealth(ctx, &emptypb.Empty{})
+		}),
+		diagnostics.JSONSource("capabilities.json", func(ctx context.Context) (any, error) {
+			statuses := []nodePluginStatus{}
+			src.nodePlugins.Range(func(module string, client controlv1.HealthClient) bool {
+				status := nodePluginStatus{
+					Module: module,
+				}
+				if h, err := client.GetHealth(ctx, &emptypb.Empty{}); err != nil {
+					status.Error = err.Error()
+				} else {
+					status.Ready = h.GetReady()
+					status.Conditions = h.GetConditions()
+				}
+				statuses = append(statuses, status)
+				return true
+			})
+			sort.Slice(statuses, func(i, j int) bool {
+				return statuses[i].Module < statuses[j].Module
+			})
+			return statuses, nil
+		}),
+		diagnostics.JSONSource("plugins.json", func(context.Context) (any, error) {
+			manifest := src.manifest.Load()
+			if manifest == nil {
+				return nil, errors.New("plugins have not been synced")
+			}
+			return manifest, nil
+		}),
+		diagnostics.LogSource("logs.txt", src.logs),
+		diagnostics.ProfileSource("pprof/goroutine.txt", "goroutine", 2),
+		diagnostics.ProfileSource("pprof/heap.pb.gz", "heap", 0),
+	}, lg)
+}
+
+// supportBundleHandler serves a support bundle built on request. The number of
+// log lines to include can be set with the logLines query parameter.
+func supportBundleHandler(srv *diagnostics.Server) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var logLines int
+		if value := c.Query("logLines"); value != "" {
+			var err error
+			logLines, err = strconv.Atoi(value)
+			if err != nil || logLines < 0 {
+				c.String(http.StatusBadRequest, "invalid logLines parameter")
+				return
+			}
+		}
+		var buf bytes.Buffer
+		if err := srv.WriteBundle(c.Request.Context(), &controlv1.DiagnosticsRequest{
+			LogLines: int32(logLines),
+		}, &buf); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Data(http.StatusOK, "application/gzip", buf.Bytes())
+	}
+}
@@ -0,0 +1,96 @@
+package v2
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	"github.com/rancher/opni/pkg/clients"
+	"github.com/rancher/opni/pkg/ecdh"
+	"github.com/rancher/opni/pkg/keyring"
+	"github.com/rancher/opni/pkg/storage"
+)
+
+type pendingKeyExchange struct {
+	id   string
+	keys *keyring.SharedKeys
+}
+
+type keyRotationServer struct {
+	controlv1.UnsafeKeyRotationServer
+	logger        *zap.SugaredLogger
+	keyringStore  storage.KeyringStore
+	gatewayClient clients.GatewayClient
+
+	mu      sync.Mutex
+	pending *pendingKeyExchange
+}
+
+var _ controlv1.KeyRotationServer = (*keyRotationServer)(nil)
+
+func (s *keyRotationServer) ExchangeKeys(_ context.Context, req *controlv1.KeyExchangeRequest) (*controlv1.KeyExchangeResponse, error) {
+	if req.GetId() == "" || len(req.GetServerPublicKey()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "missing exchange id or server public key")
+	}
+	ekp := ecdh.NewEphemeralKeyPair()
+	secret, err := ecdh.DeriveSharedSecret(ekp, ecdh.PeerPublicKey{
+		PublicKey: req.GetServerPublicKey(),
+		PeerType:  ecdh.PeerTypeServer,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to derive shared secret: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = &pendingKeyExchange{
+		id:   req.GetId(),
+		keys: keyring.NewSharedKeys(secret),
+	}
+	s.logger.With(
+		"id", req.GetId(),
+	).Info("key exchange started")
+	return &controlv1.KeyExchangeResponse{
+		ClientPublicKey: ekp.PublicKey,
+	}, nil
+}
+
+func (s *keyRotationServer) CommitKeys(ctx context.Context, req *controlv1.KeyCommitRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil || s.pending.id != req.GetId() {
+		return nil, status.Error(codes.FailedPrecondition, "no matching key exchange in progress")
+	}
+
+	current, err := s.keyringStore.Get(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get keyring: %v", err)
+	}
+	keys := []any{s.pending.keys}
+	current.ForEach(func(key any) {
+		if _, ok := key.(*keyring.SharedKeys); !ok {
+			keys = append(keys, key)
+		}
+	})
+	updated := keyring.New(keys...)
+	// The new keys are stored before they are used. If the commit fails
+	// after this point, the gateway keeps accepting both the previous and new
+	// keys until the agent reconnects using one of them.
+	if err := s.keyringStore.Put(ctx, updated); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store keyring: %v", err)
+	}
+	if err := s.gatewayClient.UpdateKeyring(updated); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update gateway client keys: %v", err)
+	}
+	s.pending = nil
+
+	s.logger.With(
+		"id", req.GetId(),
+	).Info("shared keys rotated successfully")
+	return &emptypb.Empty{}, nil
+}
@@ -120,6 +120,13 @@ var CortexComponents = []string{
 	CortexQuerier,
 }
 
+// ingestion quota limits enforced by the gateway
+var IngestionQuotaLimits = []string{
+	"samplesPerSecond",
+	"bytesPerSecond",
+	"activeSeries",
+}
+
 // Storage types
 
 const AgentDisconnectStorageType = "agent-disconnect"
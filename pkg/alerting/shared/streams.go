@@ -14,6 +14,10 @@ const (
 	AgentHealthStreamSubjects     = "opni_alerting_health.*"
 	CortexStatusStream            = "opni_alerting_cortex_status"
 	CortexStatusStreamSubjects    = "opni_alerting_cortex_status.*"
+	IngestionQuotaStream          = "opni_alerting_ingestion_quota"
+	IngestionQuotaStreamSubjects  = "opni_alerting_ingestion_quota.*"
+	StaleClusterStream            = "opni_alerting_stale_cluster"
+	StaleClusterStreamSubjects    = "opni_alerting_stale_cluster.*"
 	// buckets
 	AlertingConditionBucket            = "opni-alerting-condition-bucket"
 	AlertingEndpointBucket             = "opni-alerting-endpoint-bucket"
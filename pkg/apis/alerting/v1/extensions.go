@@ -43,7 +43,9 @@ func (r *RoutingRelationships) InvolvedConditionsForEndpoint(endpointId string)
 func IsInternalCondition(cond *AlertCondition) bool {
 	if cond.GetAlertType().GetSystem() != nil ||
 		cond.GetAlertType().GetDownstreamCapability() != nil ||
-		cond.GetAlertType().GetMonitoringBackend() != nil {
+		cond.GetAlertType().GetMonitoringBackend() != nil ||
+		cond.GetAlertType().GetIngestionQuota() != nil ||
+		cond.GetAlertType().GetStaleCluster() != nil {
 		return true
 	}
 	return false
@@ -145,6 +147,12 @@ func (a *AlertTypeDetails) body() string {
 	if a.GetMonitoringBackend() != nil {
 		return "Monitoring backend"
 	}
+	if a.GetIngestionQuota() != nil {
+		return "Ingestion quota"
+	}
+	if a.GetStaleCluster() != nil {
+		return "Stale cluster"
+	}
 	if a.GetPrometheusQuery() != nil {
 		return "Prometheus query"
 	}
@@ -188,6 +196,12 @@ func (a *AlertCondition) GetClusterId() *corev1.Reference {
 	if a.GetAlertType().GetMonitoringBackend() != nil {
 		return a.GetAlertType().GetMonitoringBackend().GetClusterId()
 	}
+	if a.GetAlertType().GetIngestionQuota() != nil {
+		return a.GetAlertType().GetIngestionQuota().GetClusterId()
+	}
+	if a.GetAlertType().GetStaleCluster() != nil {
+		return a.GetAlertType().GetStaleCluster().GetClusterId()
+	}
 	if a.GetAlertType().GetPrometheusQuery() != nil {
 		return a.GetAlertType().GetPrometheusQuery().GetClusterId()
 	}
@@ -214,6 +228,10 @@ func (a *AlertCondition) IsType(typVal AlertType) bool {
 		return a.GetAlertType().GetDownstreamCapability() != nil
 	case AlertType_MonitoringBackend:
 		return a.GetAlertType().GetMonitoringBackend() != nil
+	case AlertType_IngestionQuota:
+		return a.GetAlertType().GetIngestionQuota() != nil
+	case AlertType_StaleCluster:
+		return a.GetAlertType().GetStaleCluster() != nil
 	case AlertType_PrometheusQuery:
 		return a.GetAlertType().GetPrometheusQuery() != nil
 	case AlertType_KubeState:
@@ -339,6 +357,12 @@ func (a *AlertCondition) Namespace() string {
 	if a.GetAlertType().GetMonitoringBackend() != nil {
 		return "monitoring-backend"
 	}
+	if a.GetAlertType().GetIngestionQuota() != nil {
+		return "ingestion-quota"
+	}
+	if a.GetAlertType().GetStaleCluster() != nil {
+		return "stale-cluster"
+	}
 	return "default"
 }
 
@@ -164,6 +164,31 @@ func (m *AlertConditionMonitoringBackend) Validate() error {
 	return nil
 }
 
+func (q *AlertConditionIngestionQuota) Validate() error {
+	if q.GetClusterId().GetId() == "" {
+		return validation.Error("clusterId must be set")
+	}
+	for _, limit := range q.GetLimits() {
+		if !slices.Contains(shared.IngestionQuotaLimits, limit) {
+			return validation.Errorf("unknown ingestion quota limit %q", limit)
+		}
+	}
+	if !(q.GetThreshold() > 0 && q.GetThreshold() <= 1) {
+		return validation.Error("threshold must be greater than 0 and at most 1")
+	}
+	if q.GetFor().AsDuration() <= 0 {
+		return validation.Error("positive \"for\" duration must be set")
+	}
+	return nil
+}
+
+func (s *AlertConditionStaleCluster) Validate() error {
+	if s.GetClusterId().GetId() == "" {
+		return validation.Error("clusterId must be set")
+	}
+	return nil
+}
+
 func (d *AlertTypeDetails) Validate() error {
 	if d.GetSystem() != nil {
 		return d.GetSystem().Validate()
@@ -195,6 +220,12 @@ func (d *AlertTypeDetails) Validate() error {
 	if d.GetMonitoringBackend() != nil {
 		return d.GetMonitoringBackend().Validate()
 	}
+	if d.GetIngestionQuota() != nil {
+		return d.GetIngestionQuota().Validate()
+	}
+	if d.GetStaleCluster() != nil {
+		return d.GetStaleCluster().Validate()
+	}
 	return validation.Errorf("Backend does not handle alert type provided %v", d)
 }
 
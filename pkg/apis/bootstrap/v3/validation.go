@@ -0,0 +1,17 @@
+package v3
+
+import (
+	"encoding/hex"
+
+	"github.com/rancher/opni/pkg/validation"
+)
+
+func (r *BootstrapJoinRequest) Validate() error {
+	if r.TokenID == "" {
+		return validation.Errorf("%w: %s", validation.ErrMissingRequiredField, "TokenID")
+	}
+	if id, err := hex.DecodeString(r.TokenID); err != nil || len(id) != 6 {
+		return validation.Errorf("%w: %s", validation.ErrInvalidValue, "TokenID")
+	}
+	return nil
+}
@@ -73,6 +73,9 @@ func (l *PatchList) Sort() {
 const (
 	ManifestDigestKey = "manifest-digest"
 	AgentBuildInfoKey = "agent-build-info"
+	// Sent by agents which support staged plugin rollouts, allowing the
+	// gateway to choose the plugins to sync based on the agent's cluster.
+	AgentIDKey = "agent-id"
 )
 
 // Returns a hash of the manifest metadata list. This can be used to compare
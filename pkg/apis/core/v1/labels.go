@@ -17,6 +17,16 @@ const (
 
 	NameLabel       = "opni.io/name"
 	LegacyNameLabel = "kubernetes.io/metadata.name"
+	// Set on clusters which have been marked as stale. The value is the time
+	// the cluster was marked, in unix seconds.
+	StaleSinceLabel = "opni.io/stale-since"
+	// Set on clusters whose agents have been repeatedly connecting and
+	// disconnecting. The value is the time the cluster was marked, in unix
+	// seconds.
+	FlappingSinceLabel = "opni.io/flapping-since"
+	// Set on clusters created with a token that requires approval, until the
+	// cluster is approved.
+	PendingApprovalLabel = "opni.io/pending-approval"
 )
 
 var (
@@ -0,0 +1,115 @@
+package v1
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/samber/lo"
+)
+
+// Verbs which can be used in policy rules.
+const (
+	VerbGet    = "get"
+	VerbList   = "list"
+	VerbCreate = "create"
+	VerbUpdate = "update"
+	VerbDelete = "delete"
+)
+
+// Built-in resource kinds which can be used in policy rules. Plugins may
+// define additional resource kinds for their API extensions.
+const (
+	ResourceClusters        = "clusters"
+	ResourceTokens          = "tokens"
+	ResourceRoles           = "roles"
+	ResourceRoleBindings    = "rolebindings"
+	ResourceCapabilities    = "capabilities"
+	ResourceConfig          = "config"
+	ResourceAuditEvents     = "auditevents"
+	ResourceAlertConditions = "alertconditions"
+	ResourceAlertEndpoints  = "alertendpoints"
+	ResourceSLOs            = "slos"
+	ResourceAPIExtensions   = "apiextensions"
+)
+
+// Wildcard matches any verb, resource, or capability in a policy rule.
+const Wildcard = "*"
+
+var AllVerbs = []string{VerbGet, VerbList, VerbCreate, VerbUpdate, VerbDelete}
+
+// GroupSubjectPrefix is the prefix used for role binding subjects which refer
+// to groups instead of individual users.
+const GroupSubjectPrefix = "group:"
+
+// GroupSubject returns the role binding subject referring to the given group.
+func GroupSubject(group string) string {
+	return GroupSubjectPrefix + group
+}
+
+// IsClusterScopedResource returns true if permissions for the given resource
+// kind are limited to the clusters selected by a role.
+func IsClusterScopedResource(resource string) bool {
+	switch resource {
+	case ResourceClusters, ResourceCapabilities:
+		return true
+	default:
+		return false
+	}
+}
+
+func matchesOrWildcard(values []string, value string) bool {
+	return lo.Contains(values, Wildcard) || lo.Contains(values, value)
+}
+
+// Matches returns true if the rule allows the given verb on the given
+// resource. The capability may be empty if the resource does not belong to
+// a capability, in which case rules scoped to specific capabilities will not
+// match.
+func (r *PolicyRule) Matches(verb, resource, capability string) bool {
+	if !matchesOrWildcard(r.GetVerbs(), verb) || !matchesOrWildcard(r.GetResources(), resource) {
+		return false
+	}
+	if len(r.GetCapabilities()) == 0 {
+		return true
+	}
+	return capability != "" && matchesOrWildcard(r.GetCapabilities(), capability)
+}
+
+// Allows returns true if any of the role's rules match the given verb,
+// resource, and capability. It does not take the clusters selected by the
+// role into account.
+func (r *Role) Allows(verb, resource, capability string) bool {
+	for _, rule := range r.GetRules() {
+		if rule.Matches(verb, resource, capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectsClusters returns true if the role explicitly selects a subset of
+// clusters, either by ID or by label.
+func (r *Role) SelectsClusters() bool {
+	return len(r.GetClusterIDs()) > 0 ||
+		len(r.GetMatchLabels().GetMatchLabels()) > 0 ||
+		len(r.GetMatchLabels().GetMatchExpressions()) > 0
+}
+
+// ParseMetricsLabelMatchers parses the role's metrics label matchers. Each
+// matcher is written the same way as inside a PromQL selector, for example
+// `namespace=~"team-a-.*"`.
+func (r *Role) ParseMetricsLabelMatchers() ([]*labels.Matcher, error) {
+	matchers := make([]*labels.Matcher, 0, len(r.GetMetricsLabelMatchers()))
+	for _, str := range r.GetMetricsLabelMatchers() {
+		parsed, err := parser.ParseMetricSelector("{" + str + "}")
+		if err != nil {
+			return nil, fmt.Errorf("invalid label matcher %q: %w", str, err)
+		}
+		if len(parsed) != 1 {
+			return nil, fmt.Errorf("invalid label matcher %q: expected a single matcher", str)
+		}
+		matchers = append(matchers, parsed[0])
+	}
+	return matchers, nil
+}
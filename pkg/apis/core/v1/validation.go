@@ -123,6 +123,43 @@ func (r *Role) Validate() error {
 			return err
 		}
 	}
+	for _, rule := range r.Rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := r.ParseMetricsLabelMatchers(); err != nil {
+		return fmt.Errorf("%w: %s", validation.ErrInvalidValue, err)
+	}
+	return nil
+}
+
+func (r *PolicyRule) Validate() error {
+	if len(r.Verbs) == 0 {
+		return fmt.Errorf("%w: %s", validation.ErrMissingRequiredField, "verbs")
+	}
+	if len(r.Resources) == 0 {
+		return fmt.Errorf("%w: %s", validation.ErrMissingRequiredField, "resources")
+	}
+	for _, verb := range r.Verbs {
+		if verb != Wildcard && !lo.Contains(AllVerbs, verb) {
+			return fmt.Errorf("%w: unknown verb %q", validation.ErrInvalidValue, verb)
+		}
+	}
+	for _, resource := range r.Resources {
+		if resource != Wildcard {
+			if err := validation.ValidateName(resource); err != nil {
+				return fmt.Errorf("%w: %q", err, resource)
+			}
+		}
+	}
+	for _, capability := range r.Capabilities {
+		if capability != Wildcard {
+			if err := validation.ValidateName(capability); err != nil {
+				return fmt.Errorf("%w: %q", err, capability)
+			}
+		}
+	}
 	return nil
 }
 
@@ -143,6 +180,9 @@ func (rb *RoleBinding) Validate() error {
 		if err := validation.ValidateSubject(subject); err != nil {
 			return err
 		}
+		if subject == GroupSubjectPrefix {
+			return fmt.Errorf("%w: missing group name", validation.ErrInvalidSubjectName)
+		}
 	}
 	return nil
 }
@@ -164,6 +204,31 @@ func (sar *SubjectAccessRequest) Validate() error {
 	if err := validation.ValidateSubject(sar.Subject); err != nil {
 		return err
 	}
+	if sar.Verb == "" {
+		if sar.Resource != "" || sar.Capability != "" || sar.Cluster != nil {
+			return fmt.Errorf("%w: %s", validation.ErrMissingRequiredField, "verb")
+		}
+		return nil
+	}
+	if !lo.Contains(AllVerbs, sar.Verb) {
+		return fmt.Errorf("%w: unknown verb %q", validation.ErrInvalidValue, sar.Verb)
+	}
+	if sar.Resource == "" {
+		return fmt.Errorf("%w: %s", validation.ErrMissingRequiredField, "resource")
+	}
+	if err := validation.ValidateName(sar.Resource); err != nil {
+		return err
+	}
+	if sar.Capability != "" {
+		if err := validation.ValidateName(sar.Capability); err != nil {
+			return err
+		}
+	}
+	if sar.Cluster != nil {
+		if err := sar.Cluster.Validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -95,6 +95,39 @@ var _ = Describe("Validation", Label("unit"), func() {
 				},
 			},
 		}, nil),
+		Entry(nil, &corev1.Role{
+			Id:    "foo",
+			Rules: []*corev1.PolicyRule{{Verbs: []string{"get"}}},
+		}, validation.ErrMissingRequiredField),
+		Entry(nil, &corev1.Role{
+			Id: "foo",
+			Rules: []*corev1.PolicyRule{{
+				Verbs:     []string{"get", "list"},
+				Resources: []string{"clusters"},
+			}},
+		}, nil),
+		Entry(nil, &corev1.Role{
+			Id:                   "foo",
+			MetricsLabelMatchers: []string{`namespace=~"team-a-.*"`, `job!="secret"`},
+		}, nil),
+		Entry(nil, &corev1.Role{
+			Id:                   "foo",
+			MetricsLabelMatchers: []string{`namespace=~"team-a-.*`},
+		}, validation.ErrInvalidValue),
+		Entry(nil, &corev1.Role{
+			Id:                   "foo",
+			MetricsLabelMatchers: []string{`namespace="a", job="b"`},
+		}, validation.ErrInvalidValue),
+	)
+	DescribeTable("PolicyRule", validateEntry,
+		Entry(nil, &corev1.PolicyRule{}, validation.ErrMissingRequiredField),
+		Entry(nil, &corev1.PolicyRule{Verbs: []string{"get"}}, validation.ErrMissingRequiredField),
+		Entry(nil, &corev1.PolicyRule{Resources: []string{"clusters"}}, validation.ErrMissingRequiredField),
+		Entry(nil, &corev1.PolicyRule{Verbs: []string{"patch"}, Resources: []string{"clusters"}}, validation.ErrInvalidValue),
+		Entry(nil, &corev1.PolicyRule{Verbs: []string{"get"}, Resources: []string{"\\"}}, validation.ErrInvalidName),
+		Entry(nil, &corev1.PolicyRule{Verbs: []string{"get"}, Resources: []string{"capabilities"}, Capabilities: []string{"\\"}}, validation.ErrInvalidName),
+		Entry(nil, &corev1.PolicyRule{Verbs: []string{"*"}, Resources: []string{"*"}}, nil),
+		Entry(nil, &corev1.PolicyRule{Verbs: []string{"get"}, Resources: []string{"capabilities"}, Capabilities: []string{"metrics", "*"}}, nil),
 	)
 	DescribeTable("RoleBinding", validateEntry,
 		Entry(nil, &corev1.RoleBinding{}, validation.ErrMissingRequiredField),
@@ -111,6 +144,16 @@ var _ = Describe("Validation", Label("unit"), func() {
 			RoleId:   "bar",
 			Subjects: []string{"bar"},
 		}, nil),
+		Entry(nil, &corev1.RoleBinding{
+			Id:       "foo",
+			RoleId:   "bar",
+			Subjects: []string{"group:"},
+		}, validation.ErrInvalidSubjectName),
+		Entry(nil, &corev1.RoleBinding{
+			Id:       "foo",
+			RoleId:   "bar",
+			Subjects: []string{"bar", "group:baz"},
+		}, nil),
 	)
 	DescribeTable("Reference", validateEntry,
 		Entry(nil, &corev1.Reference{}, validation.ErrMissingRequiredField),
@@ -121,6 +164,11 @@ var _ = Describe("Validation", Label("unit"), func() {
 		Entry(nil, &corev1.SubjectAccessRequest{}, validation.ErrMissingRequiredField),
 		Entry(nil, &corev1.SubjectAccessRequest{Subject: "\\"}, validation.ErrInvalidSubjectName),
 		Entry(nil, &corev1.SubjectAccessRequest{Subject: "foo"}, nil),
+		Entry(nil, &corev1.SubjectAccessRequest{Subject: "foo", Resource: "clusters"}, validation.ErrMissingRequiredField),
+		Entry(nil, &corev1.SubjectAccessRequest{Subject: "foo", Verb: "get"}, validation.ErrMissingRequiredField),
+		Entry(nil, &corev1.SubjectAccessRequest{Subject: "foo", Verb: "patch", Resource: "clusters"}, validation.ErrInvalidValue),
+		Entry(nil, &corev1.SubjectAccessRequest{Subject: "foo", Verb: "get", Resource: "clusters", Cluster: &corev1.Reference{Id: "\\"}}, validation.ErrInvalidID),
+		Entry(nil, &corev1.SubjectAccessRequest{Subject: "foo", Verb: "get", Resource: "capabilities", Capability: "metrics", Cluster: &corev1.Reference{Id: "c1"}}, nil),
 	)
 	DescribeTable("MatchOptions", validateEntry,
 		Entry(nil, corev1.MatchOptions_Default, nil),
@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 
 	"google.golang.org/grpc"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
 )
 
 func DefaultManagementSocket() string {
@@ -22,3 +24,32 @@ func DefaultManagementSocket() string {
 func UnderlyingConn(client ManagementClient) grpc.ClientConnInterface {
 	return client.(*managementClient).cc
 }
+
+// FilterClusters implements rbac.ClusterFilterer.
+func (r *StaleClusterReport) FilterClusters(allowed func(id string) bool) {
+	items := r.Items[:0]
+	for _, item := range r.Items {
+		if allowed(item.GetCluster().GetId()) {
+			items = append(items, item)
+		}
+	}
+	r.Items = items
+}
+
+// FilterClusters implements rbac.ClusterFilterer.
+func (s *PluginRolloutStatus) FilterClusters(allowed func(id string) bool) {
+	filter := func(refs []*corev1.Reference) []*corev1.Reference {
+		filtered := refs[:0]
+		for _, ref := range refs {
+			if allowed(ref.GetId()) {
+				filtered = append(filtered, ref)
+			}
+		}
+		return filtered
+	}
+	for _, wave := range s.Waves {
+		wave.Clusters = filter(wave.Clusters)
+		wave.HealthyAtStart = filter(wave.HealthyAtStart)
+		wave.Degraded = filter(wave.Degraded)
+	}
+}
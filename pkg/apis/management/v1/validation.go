@@ -3,6 +3,7 @@ package v1
 import (
 	"encoding/json"
 	"fmt"
+	"net/netip"
 
 	"github.com/rancher/opni/pkg/validation"
 )
@@ -22,6 +23,19 @@ func (r *CreateBootstrapTokenRequest) Validate() error {
 			return err
 		}
 	}
+	if r.GetMaxUsages() < 0 {
+		return fmt.Errorf("%w: %s", validation.ErrInvalidValue, "maxUsages cannot be negative")
+	}
+	for _, cidr := range r.GetAllowedSourceCIDRs() {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("%w (allowedSourceCIDRs): %s", validation.ErrInvalidValue, err.Error())
+		}
+	}
+	if id := r.GetExpectedClusterID(); id != "" {
+		if err := validation.ValidateID(id); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -110,3 +124,66 @@ func (r *CapabilityUninstallCancelRequest) Validate() error {
 	}
 	return nil
 }
+
+func (r *RotateClusterKeysRequest) Validate() error {
+	if r.Cluster == nil {
+		return fmt.Errorf("%w: %s", validation.ErrMissingRequiredField, "cluster")
+	}
+	if err := validation.Validate(r.Cluster); err != nil {
+		return err
+	}
+	if r.GracePeriod != nil {
+		if err := r.GracePeriod.CheckValid(); err != nil {
+			return fmt.Errorf("%w (gracePeriod): %s", validation.ErrInvalidValue, err.Error())
+		}
+		if r.GracePeriod.AsDuration() <= 0 {
+			return fmt.Errorf("%w: %s", validation.ErrInvalidValue, "gracePeriod must be positive")
+		}
+	}
+	return nil
+}
+
+func (r *CollectClusterDiagnosticsRequest) Validate() error {
+	if r.Cluster == nil {
+		return fmt.Errorf("%w: %s", validation.ErrMissingRequiredField, "cluster")
+	}
+	if err := validation.Validate(r.Cluster); err != nil {
+		return err
+	}
+	if r.LogLines < 0 {
+		return fmt.Errorf("%w: %s", validation.ErrInvalidValue, "logLines cannot be negative")
+	}
+	return nil
+}
+
+func (r *ClusterHealthHistoryRequest) Validate() error {
+	if r.Cluster == nil {
+		return fmt.Errorf("%w: %s", validation.ErrMissingRequiredField, "cluster")
+	}
+	if err := validation.Validate(r.Cluster); err != nil {
+		return err
+	}
+	if r.Since != nil && r.Until != nil && r.Since.AsTime().After(r.Until.AsTime()) {
+		return fmt.Errorf("%w: %s", validation.ErrInvalidValue, "since must be before until")
+	}
+	return nil
+}
+
+const (
+	DefaultAuditEventsPageSize = 100
+	MaxAuditEventsPageSize     = 1000
+)
+
+func (r *ListAuditEventsRequest) Validate() error {
+	if r.Limit < 0 {
+		return fmt.Errorf("%w: %s", validation.ErrInvalidValue, "limit cannot be negative")
+	} else if r.Limit == 0 {
+		r.Limit = DefaultAuditEventsPageSize
+	} else if r.Limit > MaxAuditEventsPageSize {
+		return fmt.Errorf("%w: limit cannot be greater than %d", validation.ErrInvalidValue, MaxAuditEventsPageSize)
+	}
+	if r.Since != nil && r.Until != nil && r.Since.AsTime().After(r.Until.AsTime()) {
+		return fmt.Errorf("%w: %s", validation.ErrInvalidValue, "since must not be after until")
+	}
+	return nil
+}
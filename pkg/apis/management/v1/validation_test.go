@@ -1,6 +1,8 @@
 package v1_test
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"google.golang.org/protobuf/types/known/durationpb"
@@ -136,4 +138,32 @@ var _ = Describe("Validation", Label("unit"), func() {
 			},
 		}, nil),
 	)
+	DescribeTable("RotateClusterKeysRequest",
+		validateEntry[*v1.RotateClusterKeysRequest],
+		Entry(nil, &v1.RotateClusterKeysRequest{}, validation.ErrMissingRequiredField),
+		Entry(nil, &v1.RotateClusterKeysRequest{
+			Cluster: &corev1.Reference{
+				Id: "foo",
+			},
+		}, nil),
+		Entry(nil, &v1.RotateClusterKeysRequest{
+			Cluster:     &corev1.Reference{Id: "foo"},
+			GracePeriod: durationpb.New(time.Minute),
+		}, nil),
+		Entry(nil, &v1.RotateClusterKeysRequest{
+			Cluster:     &corev1.Reference{Id: "foo"},
+			GracePeriod: durationpb.New(0),
+		}, validation.ErrInvalidValue),
+		Entry(nil, &v1.RotateClusterKeysRequest{
+			Cluster:     &corev1.Reference{Id: "foo"},
+			GracePeriod: durationpb.New(-time.Minute),
+		}, validation.ErrInvalidValue),
+	)
+	DescribeTable("ListAuditEventsRequest",
+		validateEntry[*v1.ListAuditEventsRequest],
+		Entry(nil, &v1.ListAuditEventsRequest{}, nil),
+		Entry(nil, &v1.ListAuditEventsRequest{Limit: v1.MaxAuditEventsPageSize}, nil),
+		Entry(nil, &v1.ListAuditEventsRequest{Limit: -1}, validation.ErrInvalidValue),
+		Entry(nil, &v1.ListAuditEventsRequest{Limit: v1.MaxAuditEventsPageSize + 1}, validation.ErrInvalidValue),
+	)
 })
@@ -0,0 +1,240 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/logger"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/util"
+)
+
+const (
+	eventKeyPrefix = "events/"
+
+	// How often events older than the retention period are removed.
+	pruneInterval = 1 * time.Hour
+	// Maximum number of events read from the store to fill a single page.
+	// Pages may contain fewer events than requested if most events do not
+	// match the filters.
+	maxScannedEventsPerPage = 10000
+)
+
+// Log stores audit events in a key-value store, ordered by time.
+type Log struct {
+	LogOptions
+	kv     storage.KeyValueStore
+	logger *zap.SugaredLogger
+}
+
+type LogOptions struct {
+	retention      time.Duration
+	trustedProxies []netip.Prefix
+}
+
+type LogOption func(*LogOptions)
+
+func (o *LogOptions) apply(opts ...LogOption) {
+	for _, op := range opts {
+		op(o)
+	}
+}
+
+// WithRetention sets the maximum age of stored events. Older events are
+// removed periodically by Run. A value of 0 disables retention.
+func WithRetention(retention time.Duration) LogOption {
+	return func(o *LogOptions) {
+		o.retention = retention
+	}
+}
+
+// WithTrustedProxies sets the addresses (or CIDRs) of proxies which are
+// trusted to report the address of the client which sent a request. The
+// X-Forwarded-For metadata of requests from other peers is ignored.
+func WithTrustedProxies(cidrs ...string) LogOption {
+	return func(o *LogOptions) {
+		o.trustedProxies = util.ParsePrefixes(cidrs)
+	}
+}
+
+func NewLog(kv storage.KeyValueStore, opts ...LogOption) *Log {
+	options := LogOptions{}
+	options.apply(opts...)
+	return &Log{
+		LogOptions: options,
+		kv:         kv,
+		logger:     logger.New().Named("audit"),
+	}
+}
+
+// Keys are of the form events/<unix nano timestamp>-<random suffix>, where
+// the timestamp is zero-padded so that keys sort in chronological order.
+func eventKey(ts time.Time) string {
+	return fmt.Sprintf("%s%020d-%s", eventKeyPrefix, ts.UnixNano(), uuid.NewString()[:8])
+}
+
+func eventKeyTime(key string) (time.Time, bool) {
+	ts, _, ok := strings.Cut(strings.TrimPrefix(key, eventKeyPrefix), "-")
+	if !ok {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+func (l *Log) Record(ctx context.Context, event *managementv1.AuditEvent) error {
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return l.kv.Put(ctx, eventKey(event.GetTimestamp().AsTime()), data)
+}
+
+// Run periodically removes events older than the retention period. Blocks
+// until ctx is done. Does nothing if retention is disabled.
+func (l *Log) Run(ctx context.Context) {
+	if l.retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		if err := l.Prune(ctx); err != nil {
+			l.logger.With(
+				zap.Error(err),
+			).Warn("failed to prune audit log")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune removes events older than the retention period.
+func (l *Log) Prune(ctx context.Context) error {
+	if l.retention <= 0 {
+		return nil
+	}
+	keys, err := l.kv.ListKeys(ctx, eventKeyPrefix)
+	if err != nil {
+		return err
+	}
+	before := time.Now().Add(-l.retention)
+	pruned := 0
+	var errs []error
+	for _, key := range keys {
+		if ts, ok := eventKeyTime(key); ok && ts.Before(before) {
+			if err := l.kv.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				errs = append(errs, err)
+				continue
+			}
+			pruned++
+		}
+	}
+	if pruned > 0 {
+		l.logger.With(
+			"count", pruned,
+		).Debug("removed expired audit events")
+	}
+	return errors.Join(errs...)
+}
+
+// List returns a page of stored events matching the filters in req, newest
+// first. Only the keys within the requested time range and after the page
+// token are read, and at most maxScannedEventsPerPage events are read per
+// page. If the page ends before the last matching key, the returned list
+// contains a token for the next page.
+func (l *Log) List(ctx context.Context, req *managementv1.ListAuditEventsRequest) (*managementv1.AuditEventList, error) {
+	keys, err := l.kv.ListKeys(ctx, eventKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+	list := &managementv1.AuditEventList{}
+	var scanned int
+	var lastKey string
+	for _, key := range keys {
+		if req.GetPageToken() != "" && key >= req.GetPageToken() {
+			continue
+		}
+		if ts, ok := eventKeyTime(key); ok {
+			if req.Until != nil && ts.After(req.Until.AsTime()) {
+				continue
+			}
+			if req.Since != nil && ts.Before(req.Since.AsTime()) {
+				// keys are sorted, so all remaining events are older
+				break
+			}
+		}
+		full := (req.GetLimit() > 0 && len(list.Items) >= int(req.GetLimit())) ||
+			scanned >= maxScannedEventsPerPage
+		if full {
+			list.NextPageToken = lastKey
+			break
+		}
+		scanned++
+		lastKey = key
+		data, err := l.kv.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		event := &managementv1.AuditEvent{}
+		if err := proto.Unmarshal(data, event); err != nil {
+			l.logger.With(
+				zap.Error(err),
+				"key", key,
+			).Warn("skipping malformed audit event")
+			continue
+		}
+		if !matches(event, req) {
+			continue
+		}
+		list.Items = append(list.Items, event)
+	}
+	return list, nil
+}
+
+func matches(event *managementv1.AuditEvent, req *managementv1.ListAuditEventsRequest) bool {
+	if req.GetSubject() != "" && event.GetSubject() != req.GetSubject() {
+		return false
+	}
+	if req.GetMethod() != "" && !strings.Contains(event.GetMethod(), req.GetMethod()) {
+		return false
+	}
+	if req.GetErrorsOnly() && event.GetStatus().GetCode() == 0 {
+		return false
+	}
+	if req.GetTarget() != "" {
+		found := false
+		for _, ref := range event.GetTargets() {
+			if ref.GetId() == req.GetTarget() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
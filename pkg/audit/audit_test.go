@@ -0,0 +1,285 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	alertingv1 "github.com/rancher/opni/pkg/apis/alerting/v1"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	storagev1 "github.com/rancher/opni/pkg/apis/storage/v1"
+	"github.com/rancher/opni/pkg/audit"
+	"github.com/rancher/opni/pkg/rbac"
+	"github.com/rancher/opni/pkg/test"
+)
+
+func methodDescriptor(name string) protoreflect.MethodDescriptor {
+	return managementv1.File_github_com_rancher_opni_pkg_apis_management_v1_management_proto.
+		Services().ByName("Management").Methods().ByName(protoreflect.Name(name))
+}
+
+var _ = Describe("Audit Log", Label("unit"), func() {
+	var log *audit.Log
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		log = audit.NewLog(test.NewTestKeyValueStore(ctrl, func(b []byte) []byte {
+			return append([]byte(nil), b...)
+		}))
+	})
+
+	newEvent := func(ts time.Time, subject, method string, code codes.Code, targets ...string) *managementv1.AuditEvent {
+		e := &managementv1.AuditEvent{
+			Timestamp: timestamppb.New(ts),
+			Subject:   subject,
+			Method:    method,
+			Status:    status.New(code, "").Proto(),
+		}
+		for _, t := range targets {
+			e.Targets = append(e.Targets, &corev1.Reference{Id: t})
+		}
+		return e
+	}
+
+	Context("recording and listing events", func() {
+		now := time.Now()
+		BeforeEach(func() {
+			events := []*managementv1.AuditEvent{
+				newEvent(now.Add(-3*time.Hour), "alice", "/management.Management/CreateBootstrapToken", codes.OK),
+				newEvent(now.Add(-2*time.Hour), "bob", "/management.Management/DeleteCluster", codes.OK, "cluster-1"),
+				newEvent(now.Add(-1*time.Hour), "alice", "/management.Management/DeleteCluster", codes.NotFound, "cluster-2"),
+				newEvent(now, "bob", "/management.Management/UpdateConfig", codes.OK),
+			}
+			for _, e := range events {
+				Expect(log.Record(context.Background(), e)).To(Succeed())
+			}
+		})
+		methods := func(list *managementv1.AuditEventList) []string {
+			var names []string
+			for _, e := range list.Items {
+				names = append(names, e.Method[strings.LastIndex(e.Method, "/")+1:]+":"+e.Subject)
+			}
+			return names
+		}
+		It("should list events newest first", func() {
+			list, err := log.List(context.Background(), &managementv1.ListAuditEventsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(methods(list)).To(Equal([]string{
+				"UpdateConfig:bob",
+				"DeleteCluster:alice",
+				"DeleteCluster:bob",
+				"CreateBootstrapToken:alice",
+			}))
+		})
+		It("should filter by subject", func() {
+			list, err := log.List(context.Background(), &managementv1.ListAuditEventsRequest{
+				Subject: "alice",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(methods(list)).To(Equal([]string{"DeleteCluster:alice", "CreateBootstrapToken:alice"}))
+		})
+		It("should filter by method substring", func() {
+			list, err := log.List(context.Background(), &managementv1.ListAuditEventsRequest{
+				Method: "Delete",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(methods(list)).To(Equal([]string{"DeleteCluster:alice", "DeleteCluster:bob"}))
+		})
+		It("should filter by target", func() {
+			list, err := log.List(context.Background(), &managementv1.ListAuditEventsRequest{
+				Target: "cluster-1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(methods(list)).To(Equal([]string{"DeleteCluster:bob"}))
+		})
+		It("should filter by status", func() {
+			list, err := log.List(context.Background(), &managementv1.ListAuditEventsRequest{
+				ErrorsOnly: true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(methods(list)).To(Equal([]string{"DeleteCluster:alice"}))
+		})
+		It("should filter by time range", func() {
+			list, err := log.List(context.Background(), &managementv1.ListAuditEventsRequest{
+				Since: timestamppb.New(now.Add(-150 * time.Minute)),
+				Until: timestamppb.New(now.Add(-30 * time.Minute)),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(methods(list)).To(Equal([]string{"DeleteCluster:alice", "DeleteCluster:bob"}))
+		})
+		It("should apply the limit", func() {
+			list, err := log.List(context.Background(), &managementv1.ListAuditEventsRequest{
+				Limit: 1,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(methods(list)).To(Equal([]string{"UpdateConfig:bob"}))
+		})
+	})
+
+	It("should remove events older than the retention period", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		log := audit.NewLog(test.NewTestKeyValueStore(ctrl, func(b []byte) []byte {
+			return append([]byte(nil), b...)
+		}), audit.WithRetention(time.Hour))
+		now := time.Now()
+		Expect(log.Record(context.Background(), newEvent(now.Add(-2*time.Hour), "", "old", codes.OK))).To(Succeed())
+		Expect(log.Record(context.Background(), newEvent(now, "", "new", codes.OK))).To(Succeed())
+		list, err := log.List(context.Background(), &managementv1.ListAuditEventsRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list.Items).To(HaveLen(2))
+
+		Expect(log.Prune(context.Background())).To(Succeed())
+		list, err = log.List(context.Background(), &managementv1.ListAuditEventsRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list.Items).To(HaveLen(1))
+		Expect(list.Items[0].Method).To(Equal("new"))
+	})
+
+	It("should list events in pages", func() {
+		now := time.Now()
+		for i := 0; i < 5; i++ {
+			Expect(log.Record(context.Background(), newEvent(now.Add(-time.Duration(i)*time.Minute), "", fmt.Sprint(i), codes.OK))).To(Succeed())
+		}
+		req := &managementv1.ListAuditEventsRequest{Limit: 2}
+		var pages [][]string
+		for {
+			list, err := log.List(context.Background(), req)
+			Expect(err).NotTo(HaveOccurred())
+			var page []string
+			for _, e := range list.Items {
+				page = append(page, e.Method)
+			}
+			pages = append(pages, page)
+			if list.NextPageToken == "" {
+				break
+			}
+			req.PageToken = list.NextPageToken
+		}
+		Expect(pages).To(Equal([][]string{{"0", "1"}, {"2", "3"}, {"4"}}))
+	})
+})
+
+var _ = Describe("Audit Events", Label("unit"), func() {
+	It("should record the subject, targets, and status of a request", func() {
+		ctx := rbac.ContextWithAuthorizedUserID(context.Background(), "alice")
+		event := audit.NewEvent(ctx, "/management.Management/EditCluster", &managementv1.EditClusterRequest{
+			Cluster: &corev1.Reference{Id: "cluster-1"},
+			Labels:  map[string]string{"foo": "bar"},
+		}, status.Error(codes.NotFound, "not found"))
+		Expect(event.Subject).To(Equal("alice"))
+		Expect(event.Method).To(Equal("/management.Management/EditCluster"))
+		Expect(event.Targets).To(HaveLen(1))
+		Expect(event.Targets[0].Id).To(Equal("cluster-1"))
+		Expect(codes.Code(event.Status.GetCode())).To(Equal(codes.NotFound))
+		Expect(event.Request).To(ContainSubstring(`"foo":"bar"`))
+		Expect(event.Timestamp).NotTo(BeNil())
+	})
+	It("should collect references in repeated fields", func() {
+		event := audit.NewEvent(context.Background(), "/test", &corev1.RoleBinding{
+			Id:       "rb",
+			Subjects: []string{"alice"},
+		}, nil)
+		Expect(event.Targets).To(BeEmpty())
+
+		event = audit.NewEvent(context.Background(), "/test", &corev1.ReferenceList{
+			Items: []*corev1.Reference{{Id: "a"}, {Id: "b"}},
+		}, nil)
+		Expect(event.Targets).To(HaveLen(2))
+		Expect(codes.Code(event.Status.GetCode())).To(Equal(codes.OK))
+	})
+	It("should omit bytes fields from the request summary", func() {
+		event := audit.NewEvent(context.Background(), "/management.Management/UpdateConfig", &managementv1.UpdateConfigRequest{
+			Documents: []*managementv1.ConfigDocument{
+				{Json: []byte(`{"secret":"value"}`)},
+			},
+		}, nil)
+		Expect(event.Request).NotTo(ContainSubstring("secret"))
+		Expect(event.Request).NotTo(ContainSubstring(`"json"`))
+	})
+	It("should only trust forwarded client addresses from trusted proxies", func() {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234},
+		})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "192.0.2.1, 10.0.0.2"))
+
+		event := audit.NewEvent(ctx, "/test", nil, nil)
+		Expect(event.Peer).To(Equal("10.0.0.1:1234"))
+
+		event = audit.NewEvent(ctx, "/test", nil, nil, netip.MustParsePrefix("10.0.0.0/8"))
+		Expect(event.Peer).To(Equal("192.0.2.1"))
+	})
+	It("should redact secrets in the request summary", func() {
+		event := audit.NewEvent(context.Background(), "/test", &storagev1.StorageSpec{
+			S3: &storagev1.S3StorageSpec{
+				AccessKeyID:     "access-key",
+				SecretAccessKey: "secret-key",
+			},
+		}, nil)
+		Expect(event.Request).To(ContainSubstring("access-key"))
+		Expect(event.Request).NotTo(ContainSubstring("secret-key"))
+
+		// requests to API extensions are decoded as dynamic messages
+		data, err := proto.Marshal(&alertingv1.AlertEndpoint{
+			Name: "slack",
+			Endpoint: &alertingv1.AlertEndpoint_Slack{
+				Slack: &alertingv1.SlackEndpoint{
+					WebhookUrl: "https://hooks.example.com/secret-path",
+					Channel:    "#alerts",
+				},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		msg := dynamicpb.NewMessage((&alertingv1.AlertEndpoint{}).ProtoReflect().Descriptor())
+		Expect(proto.Unmarshal(data, msg)).To(Succeed())
+		event = audit.NewEvent(context.Background(), "/test", msg, nil)
+		Expect(event.Request).To(ContainSubstring("#alerts"))
+		Expect(event.Request).NotTo(ContainSubstring("secret-path"))
+	})
+	It("should truncate long request summaries", func() {
+		event := audit.NewEvent(context.Background(), "/test", &corev1.Reference{
+			Id: strings.Repeat("x", 2*audit.MaxRequestSummaryLength),
+		}, nil)
+		Expect(len(event.Request)).To(BeNumerically("<=", audit.MaxRequestSummaryLength+3))
+	})
+	It("should record a nil request", func() {
+		event := audit.NewEvent(context.Background(), "/test", nil, errors.New("test"))
+		Expect(event.Request).To(BeEmpty())
+		Expect(codes.Code(event.Status.GetCode())).To(Equal(codes.Unknown))
+	})
+})
+
+var _ = Describe("IsMutating", Label("unit"), func() {
+	DescribeTable("management methods",
+		func(name string, mutating bool) {
+			md := methodDescriptor(name)
+			Expect(md).NotTo(BeNil())
+			Expect(audit.IsMutating(md)).To(Equal(mutating))
+		},
+		Entry(nil, "CreateBootstrapToken", true),
+		Entry(nil, "DeleteCluster", true),
+		Entry(nil, "UpdateConfig", true),
+		Entry(nil, "RotateClusterKeys", true),
+		Entry(nil, "ListClusters", false),
+		Entry(nil, "GetCluster", false),
+		Entry(nil, "WatchClusters", false),
+		Entry(nil, "ListAuditEvents", false),
+		Entry(nil, "CertsInfo", false),
+		Entry(nil, "APIExtensions", false),
+	)
+})
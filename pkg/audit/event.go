@@ -0,0 +1,197 @@
+package audit
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	storagev1 "github.com/rancher/opni/pkg/apis/storage/v1"
+	"github.com/rancher/opni/pkg/rbac"
+	"github.com/rancher/opni/pkg/util"
+)
+
+// MaxRequestSummaryLength is the maximum length of the request summary
+// stored in an audit event.
+const MaxRequestSummaryLength = 4096
+
+var referenceName = (&corev1.Reference{}).ProtoReflect().Descriptor().FullName()
+
+// Fields containing secrets, which are redacted in request summaries.
+// Requests to API extensions are decoded using descriptors provided by
+// plugins, so fields are identified by name instead of by the RedactSecrets
+// methods of their messages.
+var secretFields = map[protoreflect.FullName]struct{}{
+	"remoteread.BasicAuth.password":              {},
+	"remoteread.TargetAuth.bearerToken":          {},
+	"remoteread.TargetTLS.keyData":               {},
+	"alerting.SlackEndpoint.webhookUrl":          {},
+	"alerting.EmailEndpoint.smtpAuthPassword":    {},
+	"alerting.PagerDutyEndpoint.integrationKey":  {},
+	"alerting.BasicAuth.password":                {},
+	"alerting.Authorization.credentials":         {},
+	"alerting.OAuth2.clientSecret":               {},
+	"storage.S3StorageSpec.secretAccessKey":      {},
+	"storage.SSEConfig.kmsEncryptionContext":     {},
+	"storage.GCSStorageSpec.serviceAccount":      {},
+	"storage.AzureStorageSpec.storageAccountKey": {},
+	"storage.AzureStorageSpec.msiResource":       {},
+	"storage.SwiftStorageSpec.password":          {},
+}
+
+// NewEvent builds an audit event for a call to the given method. The
+// request may be nil if it is not available (for example, a streaming call
+// which failed before the first message was received). The client address is
+// taken from the request's forwarding metadata only if the peer is one of the
+// trusted proxies.
+func NewEvent(ctx context.Context, method string, req proto.Message, err error, trustedProxies ...netip.Prefix) *managementv1.AuditEvent {
+	event := &managementv1.AuditEvent{
+		Timestamp: timestamppb.New(time.Now()),
+		Method:    method,
+		Peer:      peerAddress(ctx, trustedProxies),
+		Status:    status.Convert(err).Proto(),
+	}
+	if subject, ok := rbac.AuthorizedUserIDFromContext(ctx); ok {
+		event.Subject = subject
+	}
+	if req != nil {
+		event.Request = summarize(req)
+		event.Targets = collectReferences(req.ProtoReflect())
+	}
+	return event
+}
+
+func peerAddress(ctx context.Context, trustedProxies []netip.Prefix) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	// requests from trusted proxies, such as the grpc-gateway, carry the
+	// original client address
+	if peerAddr, ok := util.PeerAddr(ctx); ok && util.PrefixesContain(trustedProxies, peerAddr) {
+		if addr, ok := util.ClientAddr(ctx, trustedProxies); ok && addr != peerAddr {
+			return addr.String()
+		}
+	}
+	return p.Addr.String()
+}
+
+// summarize returns a JSON representation of msg with all bytes fields
+// cleared, since these commonly contain secrets or large opaque payloads, and
+// all known secret fields redacted.
+func summarize(msg proto.Message) string {
+	clone := proto.Clone(msg)
+	redactFields(clone.ProtoReflect())
+	data, err := protojson.MarshalOptions{}.Marshal(clone)
+	if err != nil {
+		return ""
+	}
+	summary := string(data)
+	if len(summary) > MaxRequestSummaryLength {
+		summary = summary[:MaxRequestSummaryLength] + "..."
+	}
+	return summary
+}
+
+func redactFields(msg protoreflect.Message) {
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Kind() == protoreflect.BytesKind:
+			msg.Clear(fd)
+		case isSecretField(fd):
+			msg.Set(fd, protoreflect.ValueOfString(storagev1.Redacted))
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					redactFields(mv.Message())
+					return true
+				})
+			}
+		case fd.IsList():
+			if fd.Message() != nil {
+				list := v.List()
+				for i := 0; i < list.Len(); i++ {
+					redactFields(list.Get(i).Message())
+				}
+			}
+		case fd.Message() != nil:
+			redactFields(v.Message())
+		}
+		return true
+	})
+}
+
+func isSecretField(fd protoreflect.FieldDescriptor) bool {
+	if fd.Kind() != protoreflect.StringKind || fd.IsList() || fd.IsMap() {
+		return false
+	}
+	_, ok := secretFields[fd.FullName()]
+	return ok
+}
+
+// collectReferences returns all core.Reference messages contained in msg.
+func collectReferences(msg protoreflect.Message) []*corev1.Reference {
+	var refs []*corev1.Reference
+	var walk func(protoreflect.Message)
+	walk = func(m protoreflect.Message) {
+		if m.Descriptor().FullName() == referenceName {
+			if fd := m.Descriptor().Fields().ByName("id"); fd != nil {
+				if id := m.Get(fd).String(); id != "" {
+					refs = append(refs, &corev1.Reference{Id: id})
+				}
+			}
+			return
+		}
+		m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			switch {
+			case fd.IsMap():
+				if fd.MapValue().Message() != nil {
+					v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+						walk(mv.Message())
+						return true
+					})
+				}
+			case fd.IsList():
+				if fd.Message() != nil {
+					list := v.List()
+					for i := 0; i < list.Len(); i++ {
+						walk(list.Get(i).Message())
+					}
+				}
+			case fd.Message() != nil:
+				walk(v.Message())
+			}
+			return true
+		})
+	}
+	walk(msg)
+	return refs
+}
+
+// IsMutating reports whether the given method may modify state. Methods
+// whose names begin with Get, List, or Watch, and methods mapped to an HTTP
+// GET route, are considered read-only.
+func IsMutating(md protoreflect.MethodDescriptor) bool {
+	name := string(md.Name())
+	for _, prefix := range []string{"Get", "List", "Watch"} {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	if opts := md.Options(); opts != nil && proto.HasExtension(opts, annotations.E_Http) {
+		if rule, ok := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule); ok && rule.GetGet() != "" {
+			return false
+		}
+	}
+	return true
+}
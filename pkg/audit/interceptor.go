@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/rancher/opni/pkg/util"
+)
+
+// MethodResolver looks up the descriptor for a full gRPC method name of the
+// form /package.Service/Method.
+type MethodResolver func(fullMethod string) (protoreflect.MethodDescriptor, bool)
+
+// UnaryServerInterceptor returns an interceptor which records an audit event
+// for each call to a mutating method. Methods which cannot be resolved are
+// assumed to be mutating.
+func (l *Log) UnaryServerInterceptor(resolve MethodResolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, ok := resolve(info.FullMethod)
+		if ok && !IsMutating(md) {
+			return handler(ctx, req)
+		}
+		resp, err := handler(ctx, req)
+		l.record(ctx, info.FullMethod, requestMessage(req, md), err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns an interceptor which records an audit event
+// for each call to a mutating streaming method. The first message received
+// from the client is used as the request.
+func (l *Log) StreamServerInterceptor(resolve MethodResolver) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, ok := resolve(info.FullMethod)
+		if ok && !IsMutating(md) {
+			return handler(srv, ss)
+		}
+		wrapped := &recordingServerStream{ServerStream: ss}
+		err := handler(srv, wrapped)
+		l.record(ss.Context(), info.FullMethod, requestMessage(wrapped.firstMessage(), md), err)
+		return err
+	}
+}
+
+func requestMessage(req any, md protoreflect.MethodDescriptor) proto.Message {
+	if md == nil {
+		return util.ProtoMessage(req, nil)
+	}
+	return util.ProtoMessage(req, md.Input())
+}
+
+func (l *Log) record(ctx context.Context, method string, req proto.Message, err error) {
+	event := NewEvent(ctx, method, req, err, l.trustedProxies...)
+	// the request context may already be canceled at this point
+	recordCtx, ca := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ca()
+	if recordErr := l.Record(recordCtx, event); recordErr != nil {
+		l.logger.With(
+			zap.Error(recordErr),
+			"method", method,
+		).Error("failed to record audit event")
+	}
+}
+
+type recordingServerStream struct {
+	grpc.ServerStream
+	mu    sync.Mutex
+	first any
+}
+
+func (s *recordingServerStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.mu.Lock()
+		if s.first == nil {
+			s.first = m
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *recordingServerStream) firstMessage() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.first
+}
@@ -204,7 +204,11 @@ func (m *ClusterMiddleware) VerifyKeyring(authHeader string, expectedNonce uuid.
 	if kr, err := ks.Get(context.Background()); err == nil {
 		authorized := false
 		var sharedKeys *keyring.SharedKeys
+		now := time.Now()
 		if ok := kr.Try(func(shared *keyring.SharedKeys) {
+			if authorized || shared.Expired(now) {
+				return
+			}
 			if err := b2mac.Verify(mac, clusterID, expectedNonce, msgBody, shared.ClientKey); err == nil {
 				authorized = true
 				sharedKeys = shared
@@ -231,6 +235,16 @@ func (m *ClusterMiddleware) VerifyKeyring(authHeader string, expectedNonce uuid.
 }
 
 func NewClientStreamInterceptor(id string, sharedKeys *keyring.SharedKeys) grpc.StreamClientInterceptor {
+	return NewClientStreamInterceptorWithKeyFunc(id, func() *keyring.SharedKeys {
+		return sharedKeys
+	})
+}
+
+// NewClientStreamInterceptorWithKeyFunc is like NewClientStreamInterceptor,
+// but obtains the shared keys from keyFn each time a new stream is opened.
+// This allows the keys to be replaced (e.g. after a key rotation) without
+// needing to re-dial the connection.
+func NewClientStreamInterceptorWithKeyFunc(id string, keyFn func() *keyring.SharedKeys) grpc.StreamClientInterceptor {
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
 		clientStream, err := streamer(ctx, desc, cc, method, opts...)
 		if err != nil {
@@ -248,7 +262,7 @@ func NewClientStreamInterceptor(id string, sharedKeys *keyring.SharedKeys) grpc.
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "server sent an invalid challenge header")
 		}
-		mac, err := b2mac.New512([]byte(id), nonce, []byte(method), sharedKeys.ClientKey)
+		mac, err := b2mac.New512([]byte(id), nonce, []byte(method), keyFn().ClientKey)
 		if err != nil {
 			return nil, err
 		}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/google/uuid"
@@ -14,6 +15,8 @@ import (
 	v1 "github.com/rancher/opni/pkg/apis/core/v1"
 	"github.com/rancher/opni/pkg/auth/cluster"
 	"github.com/rancher/opni/pkg/b2mac"
+	"github.com/rancher/opni/pkg/ecdh"
+	"github.com/rancher/opni/pkg/keyring"
 	"github.com/rancher/opni/pkg/storage"
 	"github.com/rancher/opni/pkg/test"
 	"github.com/rancher/opni/pkg/test/testgrpc"
@@ -230,4 +233,77 @@ var _ = Describe("Cluster Auth", Ordered, Label("unit"), func() {
 			Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
 		})
 	})
+	When("the keyring contains multiple shared keys", func() {
+		dial := func(keys *keyring.SharedKeys) (*grpc.ClientConn, error) {
+			return grpc.Dial("bufconn", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return listener.Dial()
+			}), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithStreamInterceptor(cluster.NewClientStreamInterceptor("foo", keys)))
+		}
+		newSharedKeys := func() *keyring.SharedKeys {
+			kp1 := ecdh.NewEphemeralKeyPair()
+			kp2 := ecdh.NewEphemeralKeyPair()
+			sec, err := ecdh.DeriveSharedSecret(kp1, ecdh.PeerPublicKey{
+				PublicKey: kp2.PublicKey,
+				PeerType:  ecdh.PeerTypeClient,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return keyring.NewSharedKeys(sec)
+		}
+		It("should accept any of the unexpired keys", func() {
+			newKeys := newSharedKeys()
+			expiresAt := time.Now().Add(1 * time.Hour)
+			oldKeys := &keyring.SharedKeys{
+				ClientKey: testSharedKeys.ClientKey,
+				ServerKey: testSharedKeys.ServerKey,
+				ExpiresAt: &expiresAt,
+			}
+			broker.KeyringStore("gateway", &v1.Reference{Id: "foo"}).Put(context.Background(), keyring.New(newKeys, oldKeys))
+
+			for _, keys := range []*keyring.SharedKeys{newKeys, testSharedKeys} {
+				cc, err := dial(keys)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(doPingPong(testgrpc.NewStreamServiceClient(cc))).To(Succeed())
+				cc.Close()
+			}
+		})
+		It("should reject expired keys", func() {
+			newKeys := newSharedKeys()
+			expiresAt := time.Now().Add(-1 * time.Minute)
+			oldKeys := &keyring.SharedKeys{
+				ClientKey: testSharedKeys.ClientKey,
+				ServerKey: testSharedKeys.ServerKey,
+				ExpiresAt: &expiresAt,
+			}
+			broker.KeyringStore("gateway", &v1.Reference{Id: "foo"}).Put(context.Background(), keyring.New(newKeys, oldKeys))
+
+			cc, err := dial(testSharedKeys)
+			Expect(err).NotTo(HaveOccurred())
+			defer cc.Close()
+			err = doPingPong(testgrpc.NewStreamServiceClient(cc))
+			Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+
+			cc2, err := dial(newKeys)
+			Expect(err).NotTo(HaveOccurred())
+			defer cc2.Close()
+			Expect(doPingPong(testgrpc.NewStreamServiceClient(cc2))).To(Succeed())
+		})
+		It("should use the keys returned by the key func for each new stream", func() {
+			newKeys := newSharedKeys()
+			broker.KeyringStore("gateway", &v1.Reference{Id: "foo"}).Put(context.Background(), keyring.New(newKeys))
+
+			current := testSharedKeys
+			cc, err := grpc.Dial("bufconn", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return listener.Dial()
+			}), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithStreamInterceptor(cluster.NewClientStreamInterceptorWithKeyFunc("foo", func() *keyring.SharedKeys {
+				return current
+			})))
+			Expect(err).NotTo(HaveOccurred())
+			defer cc.Close()
+
+			client := testgrpc.NewStreamServiceClient(cc)
+			Expect(status.Code(doPingPong(client))).To(Equal(codes.Unauthenticated))
+			current = newKeys
+			Expect(doPingPong(client)).To(Succeed())
+		})
+	})
 })
@@ -40,13 +40,21 @@ type HTTPMiddleware interface {
 }
 
 type UnaryGRPCMiddleware interface {
-	UnaryServerInterceptor() grpc.UnaryClientInterceptor
+	UnaryServerInterceptor() grpc.UnaryServerInterceptor
 }
 
 type StreamGRPCMiddleware interface {
 	StreamServerInterceptor() grpc.StreamServerInterceptor
 }
 
+// GroupResolver can optionally be implemented by middlewares which know which
+// groups a user is a member of.
+type GroupResolver interface {
+	// UserGroups returns the groups the given user is a member of. If the
+	// user's groups are not known, it returns false.
+	UserGroups(userID string) ([]string, bool)
+}
+
 var (
 	ErrInvalidMiddlewareName   = errors.New("invalid or empty auth middleware name")
 	ErrMiddlewareAlreadyExists = errors.New("auth middleware already exists")
@@ -15,7 +15,7 @@ func (*testHttp) Handle(ctx *gin.Context) {
 
 type testUnaryGrpc struct{}
 
-func (*testUnaryGrpc) UnaryServerInterceptor() grpc.UnaryClientInterceptor {
+func (*testUnaryGrpc) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
 	return nil
 }
 
@@ -11,10 +11,11 @@ import (
 	"github.com/rancher/opni/pkg/noauth"
 	"github.com/rancher/opni/pkg/util"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 type NoauthMiddleware struct {
-	openidMiddleware auth.HTTPMiddleware
+	openidMiddleware *openid.OpenidMiddleware
 	noauthConfig     *noauth.ServerConfig
 	logger           *zap.SugaredLogger
 }
@@ -52,6 +53,14 @@ func (m *NoauthMiddleware) Handle(c *gin.Context) {
 	m.openidMiddleware.Handle(c)
 }
 
+func (m *NoauthMiddleware) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return m.openidMiddleware.UnaryServerInterceptor()
+}
+
+func (m *NoauthMiddleware) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return m.openidMiddleware.StreamServerInterceptor()
+}
+
 func (m *NoauthMiddleware) ServerConfig() *noauth.ServerConfig {
 	return m.noauthConfig
 }
@@ -11,6 +11,7 @@ import (
 type UserInfo struct {
 	raw              map[string]interface{}
 	identifyingClaim string
+	groupsClaim      string
 }
 
 func (uid *UserInfo) UserID() (string, error) {
@@ -20,6 +21,39 @@ func (uid *UserInfo) UserID() (string, error) {
 	return "", fmt.Errorf("identifying claim %q not found in user info", uid.identifyingClaim)
 }
 
+// Groups returns the groups the user is a member of, or nil if no groups
+// claim is configured or the claim is not present.
+func (uid *UserInfo) Groups() []string {
+	if uid.groupsClaim == "" {
+		return nil
+	}
+	return groupsFromClaim(uid.raw[uid.groupsClaim])
+}
+
+// groupsFromClaim converts the value of a groups claim, which is usually a
+// list of strings but may also be a single string, to a list of group names.
+func groupsFromClaim(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			if s := fmt.Sprint(g); s != "" {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	default:
+		return nil
+	}
+}
+
 type UserInfoCache struct {
 	ClientOptions
 	cache      map[string]*UserInfo // key=access token
@@ -74,15 +108,52 @@ func (c *UserInfoCache) Get(accessToken string) (*UserInfo, error) {
 		).Error("failed to fetch user info")
 		return nil, err
 	}
+	info, err := c.add(accessToken, rawUserInfo)
+	if err != nil {
+		lg.With(
+			zap.Error(err),
+		).Error("user info is invalid")
+		return nil, err
+	}
+	return info, nil
+}
+
+// Add stores the claims of an ID token in the cache, so that the user's
+// groups can be looked up later by user ID.
+func (c *UserInfoCache) Add(idToken string, claims map[string]interface{}) (*UserInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if info, ok := c.cache[idToken]; ok {
+		return info, nil
+	}
+	return c.add(idToken, claims)
+}
+
+// UserGroups returns the groups of a user with a cached access or ID token.
+func (c *UserInfoCache) UserGroups(userID string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	token, ok := c.knownUsers[userID]
+	if !ok {
+		return nil, false
+	}
+	info, ok := c.cache[token]
+	if !ok {
+		return nil, false
+	}
+	return info.Groups(), true
+}
+
+// add must be called with c.mu held.
+func (c *UserInfoCache) add(accessToken string, raw map[string]interface{}) (*UserInfo, error) {
+	lg := c.logger
 	info := &UserInfo{
-		raw:              rawUserInfo,
+		raw:              raw,
 		identifyingClaim: c.config.IdentifyingClaim,
+		groupsClaim:      c.config.GroupsClaim,
 	}
 	id, err := info.UserID()
 	if err != nil {
-		lg.With(
-			zap.Error(err),
-		).Error("user info is invalid")
 		return nil, err
 	}
 	if previousAccessToken, ok := c.knownUsers[id]; ok {
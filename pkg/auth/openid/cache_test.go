@@ -1,6 +1,7 @@
 package openid_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
@@ -19,7 +20,8 @@ var _ = Describe("User Info Cache", Ordered, Label("unit"), func() {
 	lg := test.Log
 	var addr string
 	requestCount := atomic.NewInt32(0)
-	userMap := map[string]string{} // token: sub
+	userMap := map[string]string{}    // token: sub
+	groupMap := map[string][]string{} // token: groups
 
 	BeforeAll(func() {
 		port, err := freeport.GetFreePort()
@@ -29,7 +31,11 @@ var _ = Describe("User Info Cache", Ordered, Label("unit"), func() {
 		mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
 			requestCount.Inc()
 			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-			w.Write([]byte(fmt.Sprintf(`{"sub":%q}`, userMap[token])))
+			info := map[string]any{"sub": userMap[token]}
+			if groups, ok := groupMap[token]; ok {
+				info["groups"] = groups
+			}
+			json.NewEncoder(w).Encode(info)
 		})
 		srv := http.Server{
 			Addr:    addr,
@@ -41,6 +47,7 @@ var _ = Describe("User Info Cache", Ordered, Label("unit"), func() {
 
 	BeforeEach(func() {
 		userMap = map[string]string{}
+		groupMap = map[string][]string{}
 		requestCount.Store(0)
 		cache, _ = openid.NewUserInfoCache(&openid.OpenidConfig{
 			WellKnownConfiguration: &openid.WellKnownConfiguration{
@@ -128,6 +135,70 @@ var _ = Describe("User Info Cache", Ordered, Label("unit"), func() {
 		})
 	})
 
+	Context("groups", func() {
+		var groupsCache *openid.UserInfoCache
+		BeforeEach(func() {
+			var err error
+			groupsCache, err = openid.NewUserInfoCache(&openid.OpenidConfig{
+				WellKnownConfiguration: &openid.WellKnownConfiguration{
+					UserinfoEndpoint: "http://" + addr + "/userinfo",
+				},
+				IdentifyingClaim: "sub",
+				GroupsClaim:      "groups",
+			}, lg)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should read groups from the groups claim", func() {
+			userMap["foo"] = "test"
+			groupMap["foo"] = []string{"admins", "devs"}
+			info, err := groupsCache.Get("foo")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Groups()).To(ConsistOf("admins", "devs"))
+
+			groups, ok := groupsCache.UserGroups("test")
+			Expect(ok).To(BeTrue())
+			Expect(groups).To(ConsistOf("admins", "devs"))
+		})
+		It("should not return groups if no groups claim is configured", func() {
+			userMap["foo"] = "test"
+			groupMap["foo"] = []string{"admins"}
+			info, err := cache.Get("foo")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Groups()).To(BeEmpty())
+		})
+		It("should cache groups from ID token claims", func() {
+			info, err := groupsCache.Add("id-token", map[string]any{
+				"sub":    "test2",
+				"groups": "admins",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Groups()).To(Equal([]string{"admins"}))
+			groups, ok := groupsCache.UserGroups("test2")
+			Expect(ok).To(BeTrue())
+			Expect(groups).To(Equal([]string{"admins"}))
+			Expect(requestCount.Load()).To(Equal(int32(0)))
+		})
+		It("should update groups when the user's token is refreshed", func() {
+			userMap["foo"] = "test"
+			groupMap["foo"] = []string{"devs"}
+			_, err := groupsCache.Get("foo")
+			Expect(err).NotTo(HaveOccurred())
+
+			userMap["bar"] = "test"
+			groupMap["bar"] = []string{"admins"}
+			_, err = groupsCache.Get("bar")
+			Expect(err).NotTo(HaveOccurred())
+
+			groups, ok := groupsCache.UserGroups("test")
+			Expect(ok).To(BeTrue())
+			Expect(groups).To(Equal([]string{"admins"}))
+		})
+		It("should not return groups for unknown users", func() {
+			_, ok := groupsCache.UserGroups("unknown")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
 	Context("error handling", func() {
 		When("the userinfo endpoint returns an error", func() {
 			It("should return an error", func() {
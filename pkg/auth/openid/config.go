@@ -36,6 +36,11 @@ type OpenidConfig struct {
 	// (e.g. "sub", "email", etc). Defaults to "sub".
 	//+kubebuilder:default=sub
 	IdentifyingClaim string `json:"identifyingClaim,omitempty"`
+
+	// GroupsClaim is the claim containing the list of groups the user is a
+	// member of (e.g. "groups"). If set, role bindings can refer to these
+	// groups using subjects of the form "group:<name>".
+	GroupsClaim string `json:"groupsClaim,omitempty"`
 }
 
 var ErrMissingRequiredField = errors.New("openid configuration missing required field")
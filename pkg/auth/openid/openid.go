@@ -23,6 +23,10 @@ import (
 	"github.com/rancher/opni/pkg/util"
 	"go.uber.org/zap"
 	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -46,7 +50,10 @@ type OpenidMiddleware struct {
 	configId string
 }
 
-var _ auth.Middleware = (*OpenidMiddleware)(nil)
+var (
+	_ auth.Middleware    = (*OpenidMiddleware)(nil)
+	_ auth.GroupResolver = (*OpenidMiddleware)(nil)
+)
 
 func New(ctx context.Context, config v1beta1.AuthProviderSpec) (*OpenidMiddleware, error) {
 	conf, err := util.DecodeStruct[OpenidConfig](config.Options)
@@ -71,71 +78,145 @@ func New(ctx context.Context, config v1beta1.AuthProviderSpec) (*OpenidMiddlewar
 }
 
 func (m *OpenidMiddleware) Handle(c *gin.Context) {
+	userID, groups, code := m.authenticate(c.Request.Context(), c.GetHeader("Authorization"))
+	if code != http.StatusOK {
+		c.AbortWithStatus(code)
+		return
+	}
+	c.Header("Authorization", "")
+	c.Set(rbac.UserIDKey, userID)
+	c.Set(rbac.GroupsKey, groups)
+}
+
+// UserGroups returns the groups of a user who has recently authenticated, as
+// found in the groups claim of their most recent token.
+func (m *OpenidMiddleware) UserGroups(userID string) ([]string, bool) {
+	m.lock.Lock()
+	cache := m.cache
+	m.lock.Unlock()
+	if cache == nil {
+		return nil, false
+	}
+	return cache.UserGroups(userID)
+}
+
+func (m *OpenidMiddleware) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := m.authenticateIncomingContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (m *OpenidMiddleware) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := m.authenticateIncomingContext(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &util.ServerStreamWithContext{
+			Stream: ss,
+			Ctx:    ctx,
+		})
+	}
+}
+
+func (m *OpenidMiddleware) authenticateIncomingContext(ctx context.Context) (context.Context, error) {
+	var authHeader string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(auth.AuthorizationKey); len(values) > 0 {
+			authHeader = values[0]
+		}
+	}
+	userID, groups, code := m.authenticate(ctx, authHeader)
+	switch code {
+	case http.StatusOK:
+		ctx = rbac.ContextWithAuthorizedUserID(ctx, userID)
+		return rbac.ContextWithAuthorizedGroups(ctx, groups), nil
+	case http.StatusServiceUnavailable:
+		return nil, status.Error(codes.Unavailable, "auth provider is not ready")
+	default:
+		return nil, status.Error(codes.Unauthenticated, http.StatusText(code))
+	}
+}
+
+// authenticate validates the bearer token in the given authorization header
+// and returns the user ID it identifies, along with the user's groups if a
+// groups claim is configured. The returned status code will be
+// http.StatusOK if the token is valid.
+func (m *OpenidMiddleware) authenticate(ctx context.Context, authHeader string) (string, []string, int) {
 	lg := m.logger
 	m.lock.Lock()
 	if m.wellKnownConfig == nil {
 		m.lock.Unlock()
 		lg.Debug("error handling request: auth provider is not ready")
-		c.AbortWithStatus(http.StatusServiceUnavailable)
-		return
+		return "", nil, http.StatusServiceUnavailable
 	}
+	cache := m.cache
 	m.lock.Unlock()
 
 	lg.Debug("handling auth request")
 	// Some providers serve their JWKS URI at `/.well-known/jwks.json`, which is
 	// not a registered well-known URI. openid-configuration is, however.
-	ctx, ca := context.WithTimeout(c.Request.Context(), time.Second*5)
+	ctx, ca := context.WithTimeout(ctx, time.Second*5)
 	defer ca()
 	set, err := m.keyRefresher.Fetch(ctx, m.wellKnownConfig.JwksUri)
 	if err != nil {
 		lg.Errorf("failed to fetch JWK set: %v", err)
-		c.AbortWithStatus(http.StatusServiceUnavailable)
-		return
+		return "", nil, http.StatusServiceUnavailable
 	}
-	authHeader := c.GetHeader("Authorization")
 	if authHeader == "" {
 		lg.Error("no authorization header in request")
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
+		return "", nil, http.StatusUnauthorized
 	}
 	bearerToken := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer"))
 	var userID string
+	var groups []string
 	switch GetTokenType(bearerToken) {
 	case IDToken:
 		idt, err := ValidateIDToken(bearerToken, set)
 		if err != nil {
 			lg.Errorf("failed to validate ID token: %v", err)
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
+			return "", nil, http.StatusUnauthorized
 		}
 		claim, ok := idt.Get(m.conf.IdentifyingClaim)
 		if !ok {
 			lg.Errorf("identifying claim %q not found in ID token", m.conf.IdentifyingClaim)
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
+			return "", nil, http.StatusUnauthorized
 		}
 		userID = fmt.Sprint(claim)
+		if m.conf.GroupsClaim != "" {
+			if claim, ok := idt.Get(m.conf.GroupsClaim); ok {
+				groups = groupsFromClaim(claim)
+			}
+			if cache != nil {
+				// cache the token's claims so that the user's groups can be
+				// looked up by user ID
+				if claims, err := idt.AsMap(ctx); err == nil {
+					cache.Add(bearerToken, claims)
+				}
+			}
+		}
 	case Opaque:
-		userInfo, err := m.cache.Get(bearerToken)
+		userInfo, err := cache.Get(bearerToken)
 		if err != nil {
 			lg.Errorf("failed to get user info: %v", err)
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
+			return "", nil, http.StatusUnauthorized
 		}
 		uid, err := userInfo.UserID()
 		if err != nil {
 			lg.Errorf("failed to get user id: %v", err)
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
+			return "", nil, http.StatusUnauthorized
 		}
 		userID = uid
+		groups = userInfo.Groups()
 	default:
 		lg.Error("could not determine token type")
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
+		return "", nil, http.StatusUnauthorized
 	}
-	c.Header("Authorization", "")
-	c.Set(rbac.UserIDKey, userID)
+	return userID, groups, http.StatusOK
 }
 
 func (m *OpenidMiddleware) tryConfigureKeyRefresher(ctx context.Context) {
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -10,6 +11,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type AuthStrategy string
@@ -21,6 +23,23 @@ const (
 
 type TestAuthMiddleware struct {
 	Strategy AuthStrategy
+	// Groups optionally maps user IDs to the groups they are members of.
+	Groups map[string][]string
+}
+
+var _ auth.GroupResolver = (*TestAuthMiddleware)(nil)
+
+func (m *TestAuthMiddleware) UserGroups(userID string) ([]string, bool) {
+	groups, ok := m.Groups[userID]
+	return groups, ok
+}
+
+func (m *TestAuthMiddleware) contextWithUser(ctx context.Context, userId string) context.Context {
+	ctx = rbac.ContextWithAuthorizedUserID(ctx, userId)
+	if groups, ok := m.Groups[userId]; ok {
+		ctx = rbac.ContextWithAuthorizedGroups(ctx, groups)
+	}
+	return ctx
 }
 
 func (m *TestAuthMiddleware) Handle(c *gin.Context) {
@@ -35,6 +54,9 @@ func (m *TestAuthMiddleware) Handle(c *gin.Context) {
 		}
 		c.Header("Authorization", "")
 		c.Set(rbac.UserIDKey, userId)
+		if groups, ok := m.Groups[userId]; ok {
+			c.Set(rbac.GroupsKey, groups)
+		}
 	default:
 		panic("unknown auth strategy")
 	}
@@ -51,10 +73,29 @@ func (m *TestAuthMiddleware) StreamServerInterceptor() grpc.StreamServerIntercep
 			return grpc.Errorf(codes.InvalidArgument, "authorization header required")
 		}
 		userId := authHeader[0]
+		ctx := metadata.NewIncomingContext(ss.Context(), metadata.New(map[string]string{auth.AuthorizationKey: userId}))
 		ss = &util.ServerStreamWithContext{
 			Stream: ss,
-			Ctx:    metadata.NewIncomingContext(ss.Context(), metadata.New(map[string]string{auth.AuthorizationKey: userId})),
+			Ctx:    m.contextWithUser(ctx, userId),
 		}
 		return handler(srv, ss)
 	}
 }
+
+func (m *TestAuthMiddleware) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		switch m.Strategy {
+		case AuthStrategyDenyAll:
+			return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+		case AuthStrategyUserIDInAuthHeader:
+			md, _ := metadata.FromIncomingContext(ctx)
+			authHeader := md.Get(auth.AuthorizationKey)
+			if len(authHeader) == 0 || authHeader[0] == "" {
+				return nil, status.Error(codes.Unauthenticated, "authorization header required")
+			}
+			return handler(m.contextWithUser(ctx, authHeader[0]), req)
+		default:
+			panic("unknown auth strategy")
+		}
+	}
+}
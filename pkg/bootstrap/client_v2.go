@@ -2,12 +2,13 @@ package bootstrap
 
 import (
 	"context"
-	"crypto/x509"
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	bootstrapv2 "github.com/rancher/opni/pkg/apis/bootstrap/v2"
+	bootstrapv3 "github.com/rancher/opni/pkg/apis/bootstrap/v3"
 	"github.com/rancher/opni/pkg/auth"
 	"github.com/rancher/opni/pkg/ecdh"
 	"github.com/rancher/opni/pkg/ident"
@@ -16,9 +17,11 @@ import (
 	"github.com/rancher/opni/pkg/trust"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	"k8s.io/client-go/rest"
 )
 
@@ -30,6 +33,16 @@ type ClientConfigV2 struct {
 	K8sNamespace  string
 	TrustStrategy trust.Strategy
 	FriendlyName  *string
+	// How often to repeat the auth request while the cluster is pending
+	// approval. Defaults to 10 seconds.
+	ApprovalPollInterval time.Duration
+}
+
+func (c *ClientConfigV2) approvalPollInterval() time.Duration {
+	if c.ApprovalPollInterval > 0 {
+		return c.ApprovalPollInterval
+	}
+	return 10 * time.Second
 }
 
 func (c *ClientConfigV2) Bootstrap(
@@ -39,13 +52,7 @@ func (c *ClientConfigV2) Bootstrap(
 	if c.Token == nil {
 		return nil, ErrNoToken
 	}
-	response, serverLeafCert, err := c.bootstrapJoin(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	completeJws, err := c.findValidSignature(
-		response.Signatures, serverLeafCert.PublicKey)
+	completeJws, useV3, err := c.bootstrapJoin(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -68,8 +75,6 @@ func (c *ClientConfigV2) Bootstrap(
 	}
 	defer cc.Close()
 
-	client := bootstrapv2.NewBootstrapClient(cc)
-
 	ekp := ecdh.NewEphemeralKeyPair()
 	id, err := ident.UniqueIdentifier(ctx)
 	if err != nil {
@@ -81,9 +86,27 @@ func (c *ClientConfigV2) Bootstrap(
 		FriendlyName: c.FriendlyName,
 	}
 
-	authResp, err := client.Auth(metadata.NewOutgoingContext(ctx, metadata.Pairs(
+	authCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs(
 		auth.AuthorizationKey, "Bearer "+string(completeJws),
-	)), authReq)
+	))
+	var authResp *bootstrapv2.BootstrapAuthResponse
+	for {
+		if useV3 {
+			authResp, err = bootstrapv3.NewBootstrapClient(cc).Auth(authCtx, authReq)
+		} else {
+			authResp, err = bootstrapv2.NewBootstrapClient(cc).Auth(authCtx, authReq)
+		}
+		// if the cluster requires approval, the request is repeated with the
+		// same public key until the cluster is approved
+		if status.Code(err) != codes.FailedPrecondition {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("auth request failed: %w", err)
+		case <-time.After(c.approvalPollInterval()):
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("auth request failed: %w", err)
 	}
@@ -119,10 +142,13 @@ func (c *ClientConfigV2) Finalize(ctx context.Context) error {
 	return eraseBootstrapTokensFromConfig(ctx, c.K8sConfig, ns)
 }
 
-func (c *ClientConfigV2) bootstrapJoin(ctx context.Context) (*bootstrapv2.BootstrapJoinResponse, *x509.Certificate, error) {
+// bootstrapJoin requests a signature for the client's token, and returns the
+// completed JWS. The v3 bootstrap service is used if the server supports it,
+// otherwise the signature is found in the v2 join response.
+func (c *ClientConfigV2) bootstrapJoin(ctx context.Context) (_ []byte, useV3 bool, _ error) {
 	tlsConfig, err := c.TrustStrategy.TLSConfig()
 	if err != nil {
-		return nil, nil, err
+		return nil, false, err
 	}
 	cc, err := grpc.DialContext(ctx, c.Endpoint,
 		append(c.DialOpts,
@@ -134,28 +160,42 @@ func (c *ClientConfigV2) bootstrapJoin(ctx context.Context) (*bootstrapv2.Bootst
 		)...,
 	)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to dial gateway: %w", err)
+		return nil, false, fmt.Errorf("failed to dial gateway: %w", err)
 	}
 	defer cc.Close()
 
-	client := bootstrapv2.NewBootstrapClient(cc)
-
 	var peer peer.Peer
-	resp, err := client.Join(ctx, &bootstrapv2.BootstrapJoinRequest{}, grpc.Peer(&peer), grpc.WaitForReady(true))
-	if err != nil {
-		return nil, nil, fmt.Errorf("join request failed: %w", err)
+	var signatures map[string][]byte
+	resp, err := bootstrapv3.NewBootstrapClient(cc).Join(ctx, &bootstrapv3.BootstrapJoinRequest{
+		TokenID: c.Token.HexID(),
+	}, grpc.Peer(&peer), grpc.WaitForReady(true))
+	switch status.Code(err) {
+	case codes.OK:
+		useV3 = true
+		signatures = map[string][]byte{c.Token.HexID(): resp.Signature}
+	case codes.Unimplemented:
+		v2Resp, err := bootstrapv2.NewBootstrapClient(cc).Join(ctx, &bootstrapv2.BootstrapJoinRequest{}, grpc.Peer(&peer), grpc.WaitForReady(true))
+		if err != nil {
+			return nil, false, fmt.Errorf("join request failed: %w", err)
+		}
+		signatures = v2Resp.Signatures
+	default:
+		return nil, false, fmt.Errorf("join request failed: %w", err)
 	}
 
 	tlsInfo, ok := peer.AuthInfo.(credentials.TLSInfo)
 	if !ok {
-		return nil, nil, fmt.Errorf("unexpected type for peer TLS info: %#T", peer.AuthInfo)
+		return nil, false, fmt.Errorf("unexpected type for peer TLS info: %#T", peer.AuthInfo)
 	}
 
-	return resp, tlsInfo.State.PeerCertificates[0], nil
+	completeJws, err := c.findValidSignature(signatures, tlsInfo.State.PeerCertificates[0].PublicKey)
+	if err != nil {
+		return nil, false, err
+	}
+	return completeJws, useV3, nil
 }
 
-func (c *ClientConfigV2) findValidSignature(
-	signatures map[string][]byte,
+func (c *ClientConfigV2) findValidSignature(signatures map[string][]byte,
 	pubKey interface{},
 ) ([]byte, error) {
 	if sig, ok := signatures[c.Token.HexID()]; ok {
@@ -13,6 +13,8 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	bootstrapv2 "github.com/rancher/opni/pkg/apis/bootstrap/v2"
+	bootstrapv3 "github.com/rancher/opni/pkg/apis/bootstrap/v3"
+	opnicorev1 "github.com/rancher/opni/pkg/apis/core/v1"
 	"github.com/rancher/opni/pkg/bootstrap"
 	"github.com/rancher/opni/pkg/config/meta"
 	"github.com/rancher/opni/pkg/config/v1beta1"
@@ -35,6 +37,7 @@ var _ = Describe("Client V2", Ordered, Label("slow"), func() {
 	var cert *tls.Certificate
 	var store storage.Backend
 	var endpoint string
+	var endpointV3 string
 
 	BeforeAll(func() {
 		if runtime.GOOS != "linux" {
@@ -65,6 +68,22 @@ var _ = Describe("Client V2", Ordered, Label("slow"), func() {
 		DeferCleanup(func() {
 			srv.Stop()
 		})
+
+		srvV3 := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{*cert},
+		})))
+		bootstrapv2.RegisterBootstrapServer(srvV3, server)
+		bootstrapv3.RegisterBootstrapServer(srvV3, bootstrap.NewServerV3(store, cert.PrivateKey.(crypto.Signer)))
+
+		listenerV3, err := net.Listen("tcp4", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		endpointV3 = listenerV3.Addr().String()
+
+		go srvV3.Serve(listenerV3)
+
+		DeferCleanup(func() {
+			srvV3.Stop()
+		})
 	})
 
 	It("should bootstrap with the server", func() {
@@ -79,6 +98,46 @@ var _ = Describe("Client V2", Ordered, Label("slow"), func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("should bootstrap with a server supporting the v3 bootstrap service", func() {
+		token, _ := store.CreateToken(context.Background(), 1*time.Minute)
+		cc := bootstrap.ClientConfigV2{
+			Token:         testutil.Must(tokens.FromBootstrapToken(token)),
+			Endpoint:      endpointV3,
+			TrustStrategy: pkpTrustStrategy(cert.Leaf),
+		}
+
+		_, err := cc.Bootstrap(context.Background(), test.NewTestIdentProvider(ctrl, "bar"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should wait for the cluster to be approved", func() {
+		token, _ := store.CreateToken(context.Background(), 1*time.Minute, storage.WithRequireApproval(true))
+		cc := bootstrap.ClientConfigV2{
+			Token:                testutil.Must(tokens.FromBootstrapToken(token)),
+			Endpoint:             endpoint,
+			TrustStrategy:        pkpTrustStrategy(cert.Leaf),
+			ApprovalPollInterval: 100 * time.Millisecond,
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := cc.Bootstrap(context.Background(), test.NewTestIdentProvider(ctrl, "baz"))
+			done <- err
+		}()
+		ref := &opnicorev1.Reference{Id: "baz"}
+		Eventually(func() error {
+			_, err := store.GetCluster(context.Background(), ref)
+			return err
+		}).Should(Succeed())
+		Consistently(done, 500*time.Millisecond).ShouldNot(Receive())
+
+		_, err := store.UpdateCluster(context.Background(), ref, func(c *opnicorev1.Cluster) {
+			delete(c.GetMetadata().GetLabels(), opnicorev1.PendingApprovalLabel)
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
 	When("the bootstrap process is complete", func() {
 		It("should erase bootstrap tokens from the config secret", func() {
 			if runtime.GOOS != "linux" {
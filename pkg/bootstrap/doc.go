@@ -31,5 +31,10 @@ The bootstrap process is as follows:
     a KDF to create two static ed25519 keys. One is used to generate and verify
     MACs for client->server messages, and the other is used to generate and
     verify MACs for server->client messages.
+
+In the v3 bootstrap service, the client sends the ID of its bootstrap token in
+the join request, and the server responds with a single JWS for that token
+only. Clients fall back to the v2 service if the server does not support v3.
+Requests to the v3 service are rate limited per source address.
 */
 package bootstrap
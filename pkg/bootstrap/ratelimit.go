@@ -0,0 +1,78 @@
+package bootstrap
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/rancher/opni/pkg/util"
+)
+
+// sourceRateLimiter limits the rate of requests from each client address.
+// Requests from clients with an unknown address share a single limiter.
+type sourceRateLimiter struct {
+	limit          rate.Limit
+	burst          int
+	trustedProxies []netip.Prefix
+
+	mu        sync.Mutex
+	limiters  map[netip.Addr]*sourceLimiter
+	lastSweep time.Time
+}
+
+type sourceLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+func newSourceRateLimiter(limit rate.Limit, burst int, trustedProxies []netip.Prefix) *sourceRateLimiter {
+	return &sourceRateLimiter{
+		limit:          limit,
+		burst:          burst,
+		trustedProxies: trustedProxies,
+		limiters:       make(map[netip.Addr]*sourceLimiter),
+		lastSweep:      time.Now(),
+	}
+}
+
+// Allow reports whether a request from the client in the given context may
+// proceed. Requests forwarded by a trusted proxy are limited by the address
+// of the original client.
+func (l *sourceRateLimiter) Allow(ctx context.Context) bool {
+	addr, _ := util.ClientAddr(ctx, l.trustedProxies)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	sl, ok := l.limiters[addr]
+	if !ok {
+		sl = &sourceLimiter{Limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[addr] = sl
+	}
+	sl.lastSeen = now
+	return sl.AllowN(now, 1)
+}
+
+// sweep removes limiters which have been idle long enough to have refilled
+// completely, since a new limiter would behave identically.
+func (l *sourceRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	idle := time.Minute
+	if l.limit > 0 {
+		if refill := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second)); refill > idle {
+			idle = refill
+		}
+	}
+	for addr, sl := range l.limiters {
+		if now.Sub(sl.lastSeen) > idle {
+			delete(l.limiters, addr)
+		}
+	}
+}
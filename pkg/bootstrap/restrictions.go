@@ -0,0 +1,66 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkTokenRestrictions returns an error if the token's restrictions do not
+// allow it to be used by the given cluster from the request's client address.
+// If the request was forwarded by one of the trusted proxies, the client
+// address is taken from the forwarding metadata.
+func checkTokenRestrictions(ctx context.Context, token *corev1.BootstrapToken, clusterId string, trustedProxies []netip.Prefix) error {
+	md := token.GetMetadata()
+	if usageLimitReached(token) {
+		return status.Error(codes.PermissionDenied, "token has reached its usage limit")
+	}
+	if expected := md.GetExpectedClusterID(); expected != "" && expected != clusterId {
+		return status.Error(codes.PermissionDenied, "token cannot be used by this cluster")
+	}
+	if cidrs := md.GetAllowedSourceCIDRs(); len(cidrs) > 0 {
+		// token CIDRs are validated when the token is created
+		addr, ok := util.ClientAddr(ctx, trustedProxies)
+		if !ok || !util.PrefixesContain(util.ParsePrefixes(cidrs), addr) {
+			return status.Error(codes.PermissionDenied, "token cannot be used from this address")
+		}
+	}
+	return nil
+}
+
+// reserveTokenUsage increments the token's usage count, unless the token has
+// reached its usage limit. The check and increment are a single atomic update
+// of the stored token, so concurrent requests, including those handled by
+// other gateway replicas, cannot exceed the limit.
+func reserveTokenUsage(ctx context.Context, store storage.TokenStore, ref *corev1.Reference) error {
+	var reserved bool
+	_, err := store.UpdateToken(ctx, ref, storage.NewLimitedIncrementUsageCountMutator(&reserved))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return status.Error(codes.PermissionDenied, "invalid token")
+		}
+		return status.Error(codes.Internal, fmt.Sprintf("error incrementing usage count: %v", err))
+	}
+	if !reserved {
+		return status.Error(codes.PermissionDenied, "token has reached its usage limit")
+	}
+	return nil
+}
+
+// releaseTokenUsage reverts a usage reserved by reserveTokenUsage, if the
+// token could not be used.
+func releaseTokenUsage(ctx context.Context, store storage.TokenStore, ref *corev1.Reference) {
+	store.UpdateToken(ctx, ref, storage.NewDecrementUsageCountMutator())
+}
+
+func usageLimitReached(token *corev1.BootstrapToken) bool {
+	max := token.GetMetadata().GetMaxUsages()
+	return max > 0 && token.GetMetadata().GetUsageCount() >= max
+}
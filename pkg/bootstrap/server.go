@@ -5,8 +5,8 @@ import (
 	"crypto"
 	"errors"
 	"fmt"
+	"net/netip"
 	"strings"
-	"sync"
 
 	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jws"
@@ -33,15 +33,19 @@ type Server struct {
 	privateKey      crypto.Signer
 	storage         Storage
 	capBackendStore capabilities.BackendStore
-	clusterIdLocks  util.LockMap[string, *sync.Mutex]
+	clusterIdLocks  storage.LockManager
+	trustedProxies  []netip.Prefix
 }
 
-func NewServer(storage Storage, privateKey crypto.Signer, capBackendStore capabilities.BackendStore) *Server {
+func NewServer(store Storage, privateKey crypto.Signer, capBackendStore capabilities.BackendStore, opts ...ServerOption) *Server {
+	options := ServerOptions{}
+	options.apply(opts...)
 	return &Server{
 		privateKey:      privateKey,
-		storage:         storage,
+		storage:         store,
 		capBackendStore: capBackendStore,
-		clusterIdLocks:  util.NewLockMap[string, *sync.Mutex](),
+		clusterIdLocks:  storage.LockManagerFor(store, "bootstrap"),
+		trustedProxies:  util.ParsePrefixes(options.trustedProxies),
 	}
 }
 
@@ -52,6 +56,9 @@ func (h *Server) Join(ctx context.Context, _ *bootstrapv1.BootstrapJoinRequest)
 		return nil, err
 	}
 	for _, token := range tokenList {
+		if usageLimitReached(token) {
+			continue
+		}
 		// Generate a JWS containing the signature of the detached secret token
 		rawToken, err := tokens.FromBootstrapToken(token)
 		if err != nil {
@@ -99,6 +106,7 @@ func (h *Server) Auth(ctx context.Context, authReq *bootstrapv1.BootstrapAuthReq
 	if err != nil {
 		panic("bug: jws.Verify returned a malformed token")
 	}
+
 	bootstrapToken, err := h.storage.GetToken(ctx, token.Reference())
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
@@ -113,10 +121,19 @@ func (h *Server) Auth(ctx context.Context, authReq *bootstrapv1.BootstrapAuthReq
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	// lock the mutex associated with the cluster ID
-	// TODO: when scaling the gateway we need a distributed lock
-	lock := h.clusterIdLocks.Get(authReq.ClientID)
-	lock.Lock()
+	if err := checkTokenRestrictions(ctx, bootstrapToken, authReq.ClientID, h.trustedProxies); err != nil {
+		return nil, err
+	}
+	if bootstrapToken.GetMetadata().GetRequireApproval() {
+		return nil, status.Error(codes.FailedPrecondition, "tokens which require approval cannot be used with this bootstrap version")
+	}
+
+	// lock the mutex associated with the cluster ID. If the storage backend
+	// supports distributed locks, this is shared by all gateway replicas.
+	lock := h.clusterIdLocks.Locker(authReq.ClientID)
+	if err := lock.Lock(ctx); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
 	defer lock.Unlock()
 
 	// If the cluster with the requested ID does not exist, it can be created
@@ -167,8 +184,12 @@ func (h *Server) Auth(ctx context.Context, authReq *bootstrapv1.BootstrapAuthReq
 		return nil, status.Errorf(codes.Unavailable, "capability %q cannot be installed: %v", authReq.Capability, err)
 	}
 
+	if err := reserveTokenUsage(ctx, h.storage, bootstrapToken.Reference()); err != nil {
+		return nil, err
+	}
 	if shouldEditExisting {
-		if err := h.handleEdit(ctx, existing, backendClient, bootstrapToken, kr); err != nil {
+		if err := h.handleEdit(ctx, existing, backendClient, kr); err != nil {
+			releaseTokenUsage(ctx, h.storage, bootstrapToken.Reference())
 			return nil, status.Errorf(codes.Internal, "error installing capability %q: %v", authReq.Capability, err)
 		}
 	} else {
@@ -186,6 +207,7 @@ func (h *Server) Auth(ctx context.Context, authReq *bootstrapv1.BootstrapAuthReq
 			},
 		}
 		if err := h.handleCreate(ctx, newCluster, backendClient, bootstrapToken, kr); err != nil {
+			releaseTokenUsage(ctx, h.storage, bootstrapToken.Reference())
 			return nil, status.Errorf(codes.Internal, "error installing capability %q: %v", authReq.Capability, err)
 		}
 	}
@@ -206,16 +228,13 @@ func (h Server) handleCreate(
 		return fmt.Errorf("error creating cluster: %w", err)
 	}
 	_, err := h.storage.UpdateToken(ctx, token.Reference(),
-		storage.NewCompositeMutator(
-			storage.NewIncrementUsageCountMutator(),
-			storage.NewAddCapabilityMutator[*corev1.BootstrapToken](&corev1.TokenCapability{
-				Type:      string(capabilities.JoinExistingCluster),
-				Reference: newCluster.Reference(),
-			}),
-		),
+		storage.NewAddCapabilityMutator[*corev1.BootstrapToken](&corev1.TokenCapability{
+			Type:      string(capabilities.JoinExistingCluster),
+			Reference: newCluster.Reference(),
+		}),
 	)
 	if err != nil {
-		return fmt.Errorf("error incrementing usage count: %w", err)
+		return fmt.Errorf("error adding token capability: %w", err)
 	}
 	krStore := h.storage.KeyringStore("gateway", newCluster.Reference())
 	if err := krStore.Put(ctx, kr); err != nil {
@@ -231,14 +250,8 @@ func (h Server) handleEdit(
 	ctx context.Context,
 	existingCluster *corev1.Reference,
 	newCapability capabilityv1.BackendClient,
-	token *corev1.BootstrapToken,
 	keyring keyring.Keyring,
 ) error {
-	_, err := h.storage.UpdateToken(ctx, token.Reference(),
-		storage.NewIncrementUsageCountMutator())
-	if err != nil {
-		return fmt.Errorf("error incrementing usage count: %w", err)
-	}
 	krStore := h.storage.KeyringStore("gateway", existingCluster)
 	kr, err := krStore.Get(ctx)
 	if err != nil {
@@ -3,10 +3,11 @@ package bootstrap
 import (
 	"context"
 	"crypto"
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"net/netip"
 	"strings"
-	"sync"
 
 	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jws"
@@ -20,23 +21,33 @@ import (
 	"github.com/rancher/opni/pkg/util"
 	"github.com/rancher/opni/pkg/validation"
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
+// ErrPendingApproval is returned by the Auth method if the cluster requires
+// approval and has not yet been approved. Clients should repeat the request,
+// using the same public key, until the cluster is approved.
+var ErrPendingApproval = status.Error(codes.FailedPrecondition, "cluster is pending approval")
+
 type ServerV2 struct {
 	bootstrapv2.UnsafeBootstrapServer
 	privateKey     crypto.Signer
 	storage        Storage
-	clusterIdLocks util.LockMap[string, *sync.Mutex]
+	clusterIdLocks storage.LockManager
+	trustedProxies []netip.Prefix
 }
 
-func NewServerV2(storage Storage, privateKey crypto.Signer) *ServerV2 {
+func NewServerV2(store Storage, privateKey crypto.Signer, opts ...ServerOption) *ServerV2 {
+	options := ServerOptions{}
+	options.apply(opts...)
 	return &ServerV2{
 		privateKey:     privateKey,
-		storage:        storage,
-		clusterIdLocks: util.NewLockMap[string, *sync.Mutex](),
+		storage:        store,
+		clusterIdLocks: storage.LockManagerFor(store, "bootstrap"),
+		trustedProxies: util.ParsePrefixes(options.trustedProxies),
 	}
 }
 
@@ -47,6 +58,9 @@ func (h *ServerV2) Join(ctx context.Context, _ *bootstrapv2.BootstrapJoinRequest
 		return nil, err
 	}
 	for _, token := range tokenList {
+		if usageLimitReached(token) {
+			continue
+		}
 		// Generate a JWS containing the signature of the detached secret token
 		rawToken, err := tokens.FromBootstrapToken(token)
 		if err != nil {
@@ -94,6 +108,7 @@ func (h *ServerV2) Auth(ctx context.Context, authReq *bootstrapv2.BootstrapAuthR
 	if err != nil {
 		panic("bug: jws.Verify returned a malformed token")
 	}
+
 	bootstrapToken, err := h.storage.GetToken(ctx, token.Reference())
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
@@ -108,10 +123,12 @@ func (h *ServerV2) Auth(ctx context.Context, authReq *bootstrapv2.BootstrapAuthR
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	// lock the mutex associated with the cluster ID
-	// TODO: when scaling the gateway we need a distributed lock
-	lock := h.clusterIdLocks.Get(authReq.ClientId)
-	lock.Lock()
+	// lock the mutex associated with the cluster ID. If the storage backend
+	// supports distributed locks, this is shared by all gateway replicas.
+	lock := h.clusterIdLocks.Locker(authReq.ClientId)
+	if err := lock.Lock(ctx); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
 	defer lock.Unlock()
 
 	existing := &corev1.Reference{
@@ -119,20 +136,24 @@ func (h *ServerV2) Auth(ctx context.Context, authReq *bootstrapv2.BootstrapAuthR
 	}
 
 	if cluster, err := h.storage.GetCluster(ctx, existing); err == nil {
-		return nil, status.Errorf(codes.AlreadyExists, "cluster %s already exists", cluster.Id)
+		return h.completeApprovedAuth(ctx, cluster, authReq)
 	} else if !errors.Is(err, storage.ErrNotFound) {
 		return nil, status.Error(codes.Unavailable, err.Error())
 	}
 
-	ekp := ecdh.NewEphemeralKeyPair()
-	sharedSecret, err := ecdh.DeriveSharedSecret(ekp, ecdh.PeerPublicKey{
-		PublicKey: authReq.ClientPubKey,
-		PeerType:  ecdh.PeerTypeClient,
-	})
+	// restrictions are only checked for new clusters. Agents waiting for
+	// their cluster to be approved repeat their request with the same token,
+	// which may have reached its usage limit in the meantime.
+	if err := checkTokenRestrictions(ctx, bootstrapToken, authReq.ClientId, h.trustedProxies); err != nil {
+		return nil, err
+	}
+
+	// this also validates the client's public key, if the keys will not be
+	// negotiated until the cluster is approved
+	serverPubKey, kr, err := exchangeKeys(authReq.ClientPubKey)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	kr := keyring.New(keyring.NewSharedKeys(sharedSecret))
 
 	tokenLabels := maps.Clone(bootstrapToken.GetMetadata().GetLabels())
 	delete(tokenLabels, corev1.NameLabel)
@@ -143,22 +164,35 @@ func (h *ServerV2) Auth(ctx context.Context, authReq *bootstrapv2.BootstrapAuthR
 	if authReq.FriendlyName != nil {
 		tokenLabels[corev1.NameLabel] = *authReq.FriendlyName
 	}
+	requireApproval := bootstrapToken.GetMetadata().GetRequireApproval()
+	if requireApproval {
+		tokenLabels[corev1.PendingApprovalLabel] = "true"
+	}
 	newCluster := &corev1.Cluster{
 		Id: authReq.ClientId,
 		Metadata: &corev1.ClusterMetadata{
 			Labels: tokenLabels,
 		},
 	}
+	if err := reserveTokenUsage(ctx, h.storage, token.Reference()); err != nil {
+		return nil, err
+	}
 	if err := h.storage.CreateCluster(ctx, newCluster); err != nil {
+		releaseTokenUsage(ctx, h.storage, token.Reference())
 		return nil, status.Error(codes.Internal, fmt.Sprintf("error creating cluster: %v", err))
 	}
-	_, err = h.storage.UpdateToken(ctx, token.Reference(),
-		storage.NewCompositeMutator(
-			storage.NewIncrementUsageCountMutator(),
-		),
-	)
-	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("error incrementing usage count: %v", err))
+	if requireApproval {
+		// The agent's keys are not negotiated until the cluster is approved,
+		// so that it cannot connect before then. Its public key is stored
+		// separately, so that only the same agent can complete the exchange
+		// once the cluster is approved.
+		pending := keyring.New(&keyring.PendingBootstrapKey{
+			ClientPubKey: slices.Clone(authReq.ClientPubKey),
+		})
+		if err := h.storage.KeyringStore("gateway-pending", newCluster.Reference()).Put(ctx, pending); err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("error storing pending keyring: %s", err))
+		}
+		return nil, ErrPendingApproval
 	}
 	krStore := h.storage.KeyringStore("gateway", newCluster.Reference())
 	if err := krStore.Put(ctx, kr); err != nil {
@@ -166,6 +200,64 @@ func (h *ServerV2) Auth(ctx context.Context, authReq *bootstrapv2.BootstrapAuthR
 	}
 
 	return &bootstrapv2.BootstrapAuthResponse{
-		ServerPubKey: ekp.PublicKey,
+		ServerPubKey: serverPubKey,
+	}, nil
+}
+
+// completeApprovedAuth negotiates the keys of an agent whose cluster was
+// created by a token which requires approval. The request must use the same
+// public key as the request which created the cluster, and the cluster must
+// have been approved.
+func (h *ServerV2) completeApprovedAuth(
+	ctx context.Context,
+	cluster *corev1.Cluster,
+	authReq *bootstrapv2.BootstrapAuthRequest,
+) (*bootstrapv2.BootstrapAuthResponse, error) {
+	alreadyExists := status.Errorf(codes.AlreadyExists, "cluster %s already exists", cluster.Id)
+	pendingStore := h.storage.KeyringStore("gateway-pending", cluster.Reference())
+	pending, err := pendingStore.Get(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, alreadyExists
+		}
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	var matched bool
+	pending.Try(func(key *keyring.PendingBootstrapKey) {
+		matched = subtle.ConstantTimeCompare(key.ClientPubKey, authReq.ClientPubKey) == 1
+	})
+	if !matched {
+		return nil, alreadyExists
+	}
+	if _, ok := cluster.GetLabels()[corev1.PendingApprovalLabel]; ok {
+		return nil, ErrPendingApproval
+	}
+
+	serverPubKey, kr, err := exchangeKeys(authReq.ClientPubKey)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := h.storage.KeyringStore("gateway", cluster.Reference()).Put(ctx, kr); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("error storing keyring: %s", err))
+	}
+	if err := pendingStore.Delete(ctx); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("error deleting pending keyring: %s", err))
+	}
+	return &bootstrapv2.BootstrapAuthResponse{
+		ServerPubKey: serverPubKey,
 	}, nil
 }
+
+// exchangeKeys derives a new keyring from the client's public key, returning
+// the server's public key to send back to the client.
+func exchangeKeys(clientPubKey []byte) ([]byte, keyring.Keyring, error) {
+	ekp := ecdh.NewEphemeralKeyPair()
+	sharedSecret, err := ecdh.DeriveSharedSecret(ekp, ecdh.PeerPublicKey{
+		PublicKey: clientPubKey,
+		PeerType:  ecdh.PeerTypeClient,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ekp.PublicKey, keyring.New(keyring.NewSharedKeys(sharedSecret)), nil
+}
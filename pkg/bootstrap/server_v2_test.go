@@ -7,8 +7,11 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lestrrat-go/jwx/jwa"
@@ -27,6 +30,7 @@ import (
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
 	"github.com/rancher/opni/pkg/bootstrap"
 	"github.com/rancher/opni/pkg/ecdh"
+	"github.com/rancher/opni/pkg/keyring"
 	"github.com/rancher/opni/pkg/storage"
 	"github.com/rancher/opni/pkg/test"
 	"github.com/rancher/opni/pkg/tokens"
@@ -261,6 +265,187 @@ var _ = Describe("Server V2", func() {
 					Expect(util.StatusCode(err)).To(Equal(codes.PermissionDenied))
 				})
 			})
+			When("the token has restrictions", func() {
+				authCtx := func(t *corev1.BootstrapToken) context.Context {
+					rawToken, err := tokens.FromBootstrapToken(t)
+					Expect(err).NotTo(HaveOccurred())
+					jsonData, err := json.Marshal(rawToken)
+					Expect(err).NotTo(HaveOccurred())
+					sig, err := jws.Sign(jsonData, jwa.EdDSA, cert.PrivateKey)
+					Expect(err).NotTo(HaveOccurred())
+					return metadata.NewOutgoingContext(context.Background(), metadata.Pairs("Authorization", "Bearer "+string(sig)))
+				}
+				authReq := func(id string) *bootstrapv2.BootstrapAuthRequest {
+					return &bootstrapv2.BootstrapAuthRequest{
+						ClientId:     id,
+						ClientPubKey: ecdh.NewEphemeralKeyPair().PublicKey,
+					}
+				}
+				It("should enforce the usage limit", func() {
+					limited, err := mockTokenStore.CreateToken(context.Background(), 1*time.Hour,
+						storage.WithMaxUsages(1),
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = client.Auth(authCtx(limited), authReq("foo"))
+					Expect(err).NotTo(HaveOccurred())
+					_, err = client.Auth(authCtx(limited), authReq("bar"))
+					Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+					By("checking that the token is no longer offered to joining agents")
+					resp, err := client.Join(context.Background(), &bootstrapv2.BootstrapJoinRequest{})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.Signatures).NotTo(HaveKey(limited.TokenID))
+					Expect(resp.Signatures).To(HaveLen(2))
+				})
+				It("should not exceed the usage limit with concurrent requests", func() {
+					limited, err := mockTokenStore.CreateToken(context.Background(), 1*time.Hour,
+						storage.WithMaxUsages(3),
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					var wg sync.WaitGroup
+					var succeeded atomic.Int32
+					for i := 0; i < 10; i++ {
+						i := i
+						wg.Add(1)
+						go func() {
+							defer GinkgoRecover()
+							defer wg.Done()
+							_, err := client.Auth(authCtx(limited), authReq(fmt.Sprintf("concurrent-%d", i)))
+							if err == nil {
+								succeeded.Add(1)
+							} else {
+								Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+							}
+						}()
+					}
+					wg.Wait()
+					Expect(succeeded.Load()).To(BeEquivalentTo(3))
+
+					token, err := mockTokenStore.GetToken(context.Background(), limited.Reference())
+					Expect(err).NotTo(HaveOccurred())
+					Expect(token.GetMetadata().GetUsageCount()).To(Equal(int64(3)))
+				})
+				It("should only allow the expected cluster ID", func() {
+					pinned, err := mockTokenStore.CreateToken(context.Background(), 1*time.Hour,
+						storage.WithExpectedClusterID("foo"),
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = client.Auth(authCtx(pinned), authReq("bar"))
+					Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+					_, err = client.Auth(authCtx(pinned), authReq("foo"))
+					Expect(err).NotTo(HaveOccurred())
+				})
+				It("should only allow requests from the allowed source CIDRs", func() {
+					restricted, err := mockTokenStore.CreateToken(context.Background(), 1*time.Hour,
+						storage.WithAllowedSourceCIDRs([]string{"127.0.0.0/8"}),
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					// the peer address is unknown when connecting over bufconn
+					_, err = client.Auth(authCtx(restricted), authReq("foo"))
+					Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+					srv := grpc.NewServer(grpc.Creds(insecure.NewCredentials()))
+					bootstrapv2.RegisterBootstrapServer(srv, bootstrap.NewServerV2(bootstrap.StorageConfig{
+						TokenStore:         mockTokenStore,
+						ClusterStore:       mockClusterStore,
+						KeyringStoreBroker: mockKeyringStoreBroker,
+					}, cert.PrivateKey.(crypto.Signer)))
+					listener, err := net.Listen("tcp4", "127.0.0.1:0")
+					Expect(err).NotTo(HaveOccurred())
+					go srv.Serve(listener)
+					defer srv.Stop()
+					cc, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+					Expect(err).NotTo(HaveOccurred())
+					defer cc.Close()
+
+					_, err = bootstrapv2.NewBootstrapClient(cc).Auth(authCtx(restricted), authReq("foo"))
+					Expect(err).NotTo(HaveOccurred())
+				})
+				It("should use the forwarded client address from trusted proxies", func() {
+					restricted, err := mockTokenStore.CreateToken(context.Background(), 1*time.Hour,
+						storage.WithAllowedSourceCIDRs([]string{"10.0.0.0/8"}),
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					dial := func(opts ...bootstrap.ServerOption) bootstrapv2.BootstrapClient {
+						srv := grpc.NewServer(grpc.Creds(insecure.NewCredentials()))
+						bootstrapv2.RegisterBootstrapServer(srv, bootstrap.NewServerV2(bootstrap.StorageConfig{
+							TokenStore:         mockTokenStore,
+							ClusterStore:       mockClusterStore,
+							KeyringStoreBroker: mockKeyringStoreBroker,
+						}, cert.PrivateKey.(crypto.Signer), opts...))
+						listener, err := net.Listen("tcp4", "127.0.0.1:0")
+						Expect(err).NotTo(HaveOccurred())
+						go srv.Serve(listener)
+						DeferCleanup(srv.Stop)
+						cc, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+						Expect(err).NotTo(HaveOccurred())
+						DeferCleanup(cc.Close)
+						return bootstrapv2.NewBootstrapClient(cc)
+					}
+					forwardedCtx := metadata.AppendToOutgoingContext(authCtx(restricted), "x-forwarded-for", "10.1.2.3")
+
+					_, err = dial().Auth(forwardedCtx, authReq("foo"))
+					Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+					_, err = dial(bootstrap.WithTrustedProxies("127.0.0.1/32")).Auth(forwardedCtx, authReq("foo"))
+					Expect(err).NotTo(HaveOccurred())
+				})
+				It("should not negotiate keys until the cluster is approved", func() {
+					approval, err := mockTokenStore.CreateToken(context.Background(), 1*time.Hour,
+						storage.WithRequireApproval(true),
+						storage.WithMaxUsages(1),
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					req := authReq("foo")
+					_, err = client.Auth(authCtx(approval), req)
+					Expect(err).To(MatchError(bootstrap.ErrPendingApproval))
+
+					c, err := mockClusterStore.GetCluster(context.Background(), &corev1.Reference{Id: "foo"})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(c.GetLabels()).To(HaveKeyWithValue(corev1.PendingApprovalLabel, "true"))
+
+					By("checking that only the agent's public key was stored")
+					_, err = mockKeyringStoreBroker.KeyringStore("gateway", c.Reference()).Get(context.Background())
+					Expect(err).To(MatchError(storage.ErrNotFound))
+					kr, err := mockKeyringStoreBroker.KeyringStore("gateway-pending", c.Reference()).Get(context.Background())
+					Expect(err).NotTo(HaveOccurred())
+					Expect(kr.Try(func(*keyring.SharedKeys) {})).To(BeFalse())
+					Expect(kr.Try(func(key *keyring.PendingBootstrapKey) {
+						Expect(key.ClientPubKey).To(Equal(req.ClientPubKey))
+					})).To(BeTrue())
+
+					By("checking that the agent must wait for approval")
+					_, err = client.Auth(authCtx(approval), req)
+					Expect(err).To(MatchError(bootstrap.ErrPendingApproval))
+
+					By("approving the cluster")
+					_, err = mockClusterStore.UpdateCluster(context.Background(), c.Reference(), func(c *corev1.Cluster) {
+						delete(c.GetMetadata().GetLabels(), corev1.PendingApprovalLabel)
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					By("checking that other agents cannot complete the exchange")
+					_, err = client.Auth(authCtx(approval), authReq("foo"))
+					Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+
+					By("checking that the agent can complete the exchange")
+					resp, err := client.Auth(authCtx(approval), req)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.ServerPubKey).NotTo(BeEmpty())
+					_, err = mockKeyringStoreBroker.KeyringStore("gateway", c.Reference()).Get(context.Background())
+					Expect(err).NotTo(HaveOccurred())
+					_, err = mockKeyringStoreBroker.KeyringStore("gateway-pending", c.Reference()).Get(context.Background())
+					Expect(err).To(MatchError(storage.ErrNotFound))
+
+					_, err = client.Auth(authCtx(approval), req)
+					Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+				})
+			})
 			When("the cluster already exists", func() {
 				It("should return codes.AlreadyExists", func() {
 					rawToken, err := tokens.FromBootstrapToken(token)
@@ -0,0 +1,129 @@
+package bootstrap
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	bootstrapv2 "github.com/rancher/opni/pkg/apis/bootstrap/v2"
+	bootstrapv3 "github.com/rancher/opni/pkg/apis/bootstrap/v3"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/tokens"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/pkg/validation"
+)
+
+var errRateLimited = status.Error(codes.ResourceExhausted, "too many bootstrap requests, try again later")
+
+// ServerV3 implements the v3 bootstrap service. Clients must provide the ID of
+// their bootstrap token to receive a signature for it, and requests from each
+// source address are rate limited. Auth requests are otherwise handled the
+// same way as in the v2 service.
+type ServerV3 struct {
+	bootstrapv3.UnsafeBootstrapServer
+	ServerOptions
+	privateKey crypto.Signer
+	storage    Storage
+	v2         *ServerV2
+	limiter    *sourceRateLimiter
+}
+
+// ServerOptions configures the bootstrap servers. Rate limits only apply to
+// the v3 server.
+type ServerOptions struct {
+	limit          rate.Limit
+	burst          int
+	trustedProxies []string
+}
+
+type ServerOption func(*ServerOptions)
+
+func (o *ServerOptions) apply(opts ...ServerOption) {
+	for _, op := range opts {
+		op(o)
+	}
+}
+
+// Sets the maximum sustained rate of requests from a single source address,
+// and the number of requests allowed in a burst. Defaults to 10 requests per
+// minute, with a burst of 5.
+func WithRateLimit(limit rate.Limit, burst int) ServerOption {
+	return func(o *ServerOptions) {
+		o.limit = limit
+		o.burst = burst
+	}
+}
+
+// Sets the addresses or CIDRs of proxies which are trusted to report the
+// address of the client in the X-Forwarded-For or X-Real-IP request metadata.
+// Requests forwarded by these proxies are rate limited, and checked against
+// the allowed source CIDRs of bootstrap tokens, using the client address
+// instead of the proxy address.
+func WithTrustedProxies(cidrs ...string) ServerOption {
+	return func(o *ServerOptions) {
+		o.trustedProxies = cidrs
+	}
+}
+
+func NewServerV3(store Storage, privateKey crypto.Signer, opts ...ServerOption) *ServerV3 {
+	options := ServerOptions{
+		limit: rate.Every(time.Minute / 10),
+		burst: 5,
+	}
+	options.apply(opts...)
+	return &ServerV3{
+		ServerOptions: options,
+		privateKey:    privateKey,
+		storage:       store,
+		v2:            NewServerV2(store, privateKey, opts...),
+		limiter:       newSourceRateLimiter(options.limit, options.burst, util.ParsePrefixes(options.trustedProxies)),
+	}
+}
+
+func (h *ServerV3) Join(ctx context.Context, req *bootstrapv3.BootstrapJoinRequest) (*bootstrapv3.BootstrapJoinResponse, error) {
+	if !h.limiter.Allow(ctx) {
+		return nil, errRateLimited
+	}
+	if err := validation.Validate(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// the same error is returned for unknown and unusable tokens, so that
+	// clients cannot distinguish between them
+	token, err := h.storage.GetToken(ctx, &corev1.Reference{Id: req.TokenID})
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, status.Error(codes.PermissionDenied, "invalid token")
+		}
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if usageLimitReached(token) {
+		return nil, status.Error(codes.PermissionDenied, "invalid token")
+	}
+
+	rawToken, err := tokens.FromBootstrapToken(token)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	sig, err := rawToken.SignDetached(h.privateKey)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("error signing token: %v", err))
+	}
+	return &bootstrapv3.BootstrapJoinResponse{
+		Signature: sig,
+	}, nil
+}
+
+func (h *ServerV3) Auth(ctx context.Context, authReq *bootstrapv2.BootstrapAuthRequest) (*bootstrapv2.BootstrapAuthResponse, error) {
+	if !h.limiter.Allow(ctx) {
+		return nil, errRateLimited
+	}
+	return h.v2.Auth(ctx, authReq)
+}
@@ -0,0 +1,250 @@
+package bootstrap_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jws"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	bootstrapv2 "github.com/rancher/opni/pkg/apis/bootstrap/v2"
+	bootstrapv3 "github.com/rancher/opni/pkg/apis/bootstrap/v3"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/bootstrap"
+	"github.com/rancher/opni/pkg/ecdh"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/test"
+	"github.com/rancher/opni/pkg/tokens"
+)
+
+var _ = Describe("Server V3", Label("unit"), func() {
+	var token *corev1.BootstrapToken
+	var cert *tls.Certificate
+	var client bootstrapv3.BootstrapClient
+	var mockTokenStore storage.TokenStore
+	var mockClusterStore storage.ClusterStore
+	var mockKeyringStoreBroker storage.KeyringStoreBroker
+	var opts []bootstrap.ServerOption
+
+	BeforeEach(func() {
+		ctx, ca := context.WithCancel(context.Background())
+		DeferCleanup(ca)
+		mockTokenStore = test.NewTestTokenStore(ctx, ctrl)
+		mockClusterStore = test.NewTestClusterStore(ctrl)
+		mockKeyringStoreBroker = test.NewTestKeyringStoreBroker(ctrl)
+		opts = []bootstrap.ServerOption{bootstrap.WithRateLimit(rate.Inf, 0)}
+
+		token, _ = mockTokenStore.CreateToken(context.Background(), 1*time.Hour)
+		mockTokenStore.CreateToken(context.Background(), 1*time.Hour)
+	})
+
+	JustBeforeEach(func() {
+		crt, err := tls.X509KeyPair(test.TestData("self_signed_leaf.crt"), test.TestData("self_signed_leaf.key"))
+		Expect(err).NotTo(HaveOccurred())
+		crt.Leaf, err = x509.ParseCertificate(crt.Certificate[0])
+		Expect(err).NotTo(HaveOccurred())
+		cert = &crt
+
+		srv := grpc.NewServer(grpc.Creds(insecure.NewCredentials()))
+		server := bootstrap.NewServerV3(bootstrap.StorageConfig{
+			TokenStore:         mockTokenStore,
+			ClusterStore:       mockClusterStore,
+			KeyringStoreBroker: mockKeyringStoreBroker,
+		}, cert.PrivateKey.(crypto.Signer), opts...)
+		bootstrapv3.RegisterBootstrapServer(srv, server)
+
+		listener := bufconn.Listen(1024 * 1024)
+		go srv.Serve(listener)
+
+		cc, err := grpc.Dial("bufconn", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+		client = bootstrapv3.NewBootstrapClient(cc)
+
+		DeferCleanup(func() {
+			cc.Close()
+			srv.Stop()
+		})
+	})
+
+	When("sending a bootstrap join request", func() {
+		It("should only return a signature for the requested token", func() {
+			rawToken, err := tokens.FromBootstrapToken(token)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := client.Join(context.Background(), &bootstrapv3.BootstrapJoinRequest{
+				TokenID: rawToken.HexID(),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			sig, _ := rawToken.SignDetached(cert.PrivateKey)
+			Expect(resp.Signature).To(Equal(sig))
+
+			_, err = rawToken.VerifyDetached(resp.Signature, cert.Leaf.PublicKey)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		When("the token does not exist", func() {
+			It("should return codes.PermissionDenied", func() {
+				_, err := client.Join(context.Background(), &bootstrapv3.BootstrapJoinRequest{
+					TokenID: tokens.NewToken().HexID(),
+				})
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			})
+		})
+		When("the token has reached its usage limit", func() {
+			It("should return codes.PermissionDenied", func() {
+				limited, err := mockTokenStore.CreateToken(context.Background(), 1*time.Hour,
+					storage.WithMaxUsages(1),
+				)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = mockTokenStore.UpdateToken(context.Background(), limited.Reference(),
+					storage.NewIncrementUsageCountMutator())
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = client.Join(context.Background(), &bootstrapv3.BootstrapJoinRequest{
+					TokenID: limited.TokenID,
+				})
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			})
+		})
+		When("the token ID is invalid", func() {
+			It("should return codes.InvalidArgument", func() {
+				for _, id := range []string{"", "foo", "abcdef", "0123456789abcdef"} {
+					_, err := client.Join(context.Background(), &bootstrapv3.BootstrapJoinRequest{
+						TokenID: id,
+					})
+					Expect(status.Code(err)).To(Equal(codes.InvalidArgument), id)
+				}
+			})
+		})
+	})
+	When("sending a bootstrap auth request", func() {
+		It("should succeed", func() {
+			rawToken, err := tokens.FromBootstrapToken(token)
+			Expect(err).NotTo(HaveOccurred())
+			jsonData, err := json.Marshal(rawToken)
+			Expect(err).NotTo(HaveOccurred())
+			sig, err := jws.Sign(jsonData, jwa.EdDSA, cert.PrivateKey)
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("Authorization", "Bearer "+string(sig)))
+			_, err = client.Auth(ctx, &bootstrapv2.BootstrapAuthRequest{
+				ClientId:     "foo",
+				ClientPubKey: ecdh.NewEphemeralKeyPair().PublicKey,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = mockClusterStore.GetCluster(context.Background(), &corev1.Reference{Id: "foo"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = mockKeyringStoreBroker.KeyringStore("gateway", &corev1.Reference{Id: "foo"}).Get(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+	When("a client sends too many requests", func() {
+		BeforeEach(func() {
+			opts = []bootstrap.ServerOption{bootstrap.WithRateLimit(rate.Every(time.Hour), 3)}
+		})
+		It("should return codes.ResourceExhausted", func() {
+			for i := 0; i < 3; i++ {
+				_, err := client.Join(context.Background(), &bootstrapv3.BootstrapJoinRequest{
+					TokenID: tokens.NewToken().HexID(),
+				})
+				Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			}
+			_, err := client.Join(context.Background(), &bootstrapv3.BootstrapJoinRequest{
+				TokenID: token.TokenID,
+			})
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			_, err = client.Auth(context.Background(), &bootstrapv2.BootstrapAuthRequest{})
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		})
+	})
+})
+
+var _ = Describe("Server V3 rate limiting", Label("unit"), func() {
+	var client bootstrapv3.BootstrapClient
+	var trustedProxies []string
+
+	JustBeforeEach(func() {
+		ctx, ca := context.WithCancel(context.Background())
+		DeferCleanup(ca)
+		crt, err := tls.X509KeyPair(test.TestData("self_signed_leaf.crt"), test.TestData("self_signed_leaf.key"))
+		Expect(err).NotTo(HaveOccurred())
+
+		srv := grpc.NewServer(grpc.Creds(insecure.NewCredentials()))
+		server := bootstrap.NewServerV3(bootstrap.StorageConfig{
+			TokenStore:         test.NewTestTokenStore(ctx, ctrl),
+			ClusterStore:       test.NewTestClusterStore(ctrl),
+			KeyringStoreBroker: test.NewTestKeyringStoreBroker(ctrl),
+		}, crt.PrivateKey.(crypto.Signer),
+			bootstrap.WithRateLimit(rate.Every(time.Hour), 1),
+			bootstrap.WithTrustedProxies(trustedProxies...),
+		)
+		bootstrapv3.RegisterBootstrapServer(srv, server)
+
+		// a tcp listener is used so that requests have a peer address
+		listener, err := net.Listen("tcp4", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go srv.Serve(listener)
+
+		cc, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+		client = bootstrapv3.NewBootstrapClient(cc)
+
+		DeferCleanup(func() {
+			cc.Close()
+			srv.Stop()
+		})
+	})
+
+	join := func(forwardedFor ...string) codes.Code {
+		ctx := context.Background()
+		for _, addr := range forwardedFor {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-forwarded-for", addr)
+		}
+		_, err := client.Join(ctx, &bootstrapv3.BootstrapJoinRequest{
+			TokenID: tokens.NewToken().HexID(),
+		})
+		return status.Code(err)
+	}
+
+	When("the peer is a trusted proxy", func() {
+		BeforeEach(func() {
+			trustedProxies = []string{"127.0.0.1", "10.0.0.0/8"}
+		})
+		It("should limit requests by the forwarded client address", func() {
+			Expect(join("192.0.2.1")).To(Equal(codes.PermissionDenied))
+			Expect(join("192.0.2.2")).To(Equal(codes.PermissionDenied))
+			Expect(join("192.0.2.1")).To(Equal(codes.ResourceExhausted))
+		})
+		It("should skip trusted proxies in the forwarded addresses", func() {
+			Expect(join("198.51.100.1, 192.0.2.1, 10.0.0.1")).To(Equal(codes.PermissionDenied))
+			// the leftmost address is set by the client and cannot be trusted
+			Expect(join("198.51.100.2, 192.0.2.1")).To(Equal(codes.ResourceExhausted))
+		})
+	})
+
+	When("the peer is not a trusted proxy", func() {
+		BeforeEach(func() {
+			trustedProxies = nil
+		})
+		It("should ignore forwarded client addresses", func() {
+			Expect(join("192.0.2.1")).To(Equal(codes.PermissionDenied))
+			Expect(join("192.0.2.2")).To(Equal(codes.ResourceExhausted))
+		})
+	})
+})
@@ -68,6 +68,24 @@ var _ = Describe("Store", Ordered, Label("unit"), func() {
 			Expect(store.Add("capability1", backend1)).To(MatchError(capabilities.ErrBackendAlreadyExists))
 		})
 	})
+	When("removing items from the store", func() {
+		It("should allow the item to be added again", func() {
+			backend1 := test.NewTestCapabilityBackend(ctrl, &test.CapabilityInfo{
+				Name:              "capability1",
+				CanInstall:        true,
+				InstallerTemplate: "foo",
+			})
+			Expect(store.Add("capability1", backend1)).To(Succeed())
+			Expect(store.Remove("capability1")).To(Succeed())
+			Expect(store.List()).To(BeEmpty())
+			_, err := store.Get("capability1")
+			Expect(err).To(MatchError(capabilities.ErrBackendNotFound))
+			Expect(store.Add("capability1", backend1)).To(Succeed())
+		})
+		It("should return an error if the item does not exist", func() {
+			Expect(store.Remove("capability1")).To(MatchError(capabilities.ErrBackendNotFound))
+		})
+	})
 	When("getting items from the store", func() {
 		It("should return an error if the item does not exist", func() {
 			_, err := store.Get("capability1")
@@ -34,6 +34,9 @@ type GatewayClient interface {
 	Connect(context.Context) (grpc.ClientConnInterface, future.Future[error])
 	RegisterSplicedStream(cc grpc.ClientConnInterface, name string)
 	ClientConn() grpc.ClientConnInterface
+	// UpdateKeyring replaces the shared keys used to authenticate new stream
+	// connections. Streams which are already connected are not affected.
+	UpdateKeyring(keyring.Keyring) error
 }
 
 func NewGatewayClient(
@@ -47,45 +50,55 @@ func NewGatewayClient(
 	if err != nil {
 		return nil, err
 	}
-	var sharedKeys *keyring.SharedKeys
-	kr.Try(func(sk *keyring.SharedKeys) {
-		if sharedKeys != nil {
-			err = errors.New("keyring contains multiple shared key sets")
-			return
-		}
-		sharedKeys = sk
-	})
+	sharedKeys, err := sharedKeysFromKeyring(kr)
 	if err != nil {
 		return nil, err
 	}
-	if sharedKeys == nil {
-		return nil, errors.New("keyring is missing shared keys")
-	}
 
 	tlsConfig, err := trustStrategy.TLSConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create TLS config: %w", err)
 	}
 
-	cc, err := dial(ctx, address, id, sharedKeys, tlsConfig)
+	client := &gatewayClient{
+		id:         id,
+		sharedKeys: sharedKeys,
+		logger:     logger.New().Named("gateway-client"),
+	}
+
+	cc, err := dial(ctx, address, id, client.currentSharedKeys, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
+	client.cc = cc
 
 	go func() {
 		<-ctx.Done()
 		cc.Close()
 	}()
 
-	client := &gatewayClient{
-		cc:     cc,
-		id:     id,
-		logger: logger.New().Named("gateway-client"),
-	}
-
 	return client, nil
 }
 
+func sharedKeysFromKeyring(kr keyring.Keyring) (*keyring.SharedKeys, error) {
+	var sharedKeys *keyring.SharedKeys
+	var err error
+	kr.Try(func(sk *keyring.SharedKeys) {
+		if sharedKeys != nil {
+			err = errors.New("keyring contains multiple shared key sets")
+			return
+		}
+		sharedKeys = sk
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sharedKeys == nil {
+		return nil, errors.New("keyring is missing shared keys")
+	}
+	return sharedKeys, nil
+}
+
 type splicedConn struct {
 	name string
 	cc   grpc.ClientConnInterface
@@ -99,6 +112,26 @@ type gatewayClient struct {
 	mu       sync.RWMutex
 	services []util.ServicePack[any]
 	spliced  []*splicedConn
+
+	keysMu     sync.RWMutex
+	sharedKeys *keyring.SharedKeys
+}
+
+func (gc *gatewayClient) currentSharedKeys() *keyring.SharedKeys {
+	gc.keysMu.RLock()
+	defer gc.keysMu.RUnlock()
+	return gc.sharedKeys
+}
+
+func (gc *gatewayClient) UpdateKeyring(kr keyring.Keyring) error {
+	sharedKeys, err := sharedKeysFromKeyring(kr)
+	if err != nil {
+		return err
+	}
+	gc.keysMu.Lock()
+	defer gc.keysMu.Unlock()
+	gc.sharedKeys = sharedKeys
+	return nil
 }
 
 func (gc *gatewayClient) RegisterService(desc *grpc.ServiceDesc, impl any) {
@@ -121,10 +154,10 @@ func (gc *gatewayClient) RegisterSplicedStream(cc grpc.ClientConnInterface, name
 	})
 }
 
-func dial(ctx context.Context, address, id string, sharedKeys *keyring.SharedKeys, tlsConfig *tls.Config) (*grpc.ClientConn, error) {
+func dial(ctx context.Context, address, id string, keyFn func() *keyring.SharedKeys, tlsConfig *tls.Config) (*grpc.ClientConn, error) {
 	return grpc.DialContext(ctx, address,
 		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
-		grpc.WithChainStreamInterceptor(otelgrpc.StreamClientInterceptor(), cluster.NewClientStreamInterceptor(id, sharedKeys)),
+		grpc.WithChainStreamInterceptor(otelgrpc.StreamClientInterceptor(), cluster.NewClientStreamInterceptorWithKeyFunc(id, keyFn)),
 		grpc.WithChainUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
 		grpc.WithDefaultCallOptions(
 			grpc.WaitForReady(true),
@@ -97,6 +97,9 @@ func (s *AgentConfigSpec) SetDefaults() {
 	if s.TrustStrategy == "" {
 		s.TrustStrategy = "pkp"
 	}
+	if s.Plugins.Verification.Policy == "" {
+		s.Plugins.Verification.Policy = PluginTrustPolicyNone
+	}
 }
 
 // type RulesSpec struct {
@@ -18,18 +18,22 @@ type GatewayConfigSpec struct {
 	//+kubebuilder:default=":8086"
 	MetricsListenAddress string `json:"metricsListenAddress,omitempty"`
 	//+kubebuilder:default="localhost"
-	Hostname       string         `json:"hostname,omitempty"`
-	Metrics        MetricsSpec    `json:"metrics,omitempty"`
-	Management     ManagementSpec `json:"management,omitempty"`
-	TrustedProxies []string       `json:"trustedProxies,omitempty"`
-	Cortex         CortexSpec     `json:"cortex,omitempty"`
-	AuthProvider   string         `json:"authProvider,omitempty"`
-	Storage        StorageSpec    `json:"storage,omitempty"`
-	Certs          CertsSpec      `json:"certs,omitempty"`
-	Plugins        PluginsSpec    `json:"plugins,omitempty"`
-	Alerting       AlertingSpec   `json:"alerting,omitempty"`
-	Profiling      ProfilingSpec  `json:"profiling,omitempty"`
-	Keyring        KeyringSpec    `json:"keyring,omitempty"`
+	Hostname       string              `json:"hostname,omitempty"`
+	Metrics        MetricsSpec         `json:"metrics,omitempty"`
+	Management     ManagementSpec      `json:"management,omitempty"`
+	TrustedProxies []string            `json:"trustedProxies,omitempty"`
+	Cortex         CortexSpec          `json:"cortex,omitempty"`
+	AuthProvider   string              `json:"authProvider,omitempty"`
+	Storage        StorageSpec         `json:"storage,omitempty"`
+	Certs          CertsSpec           `json:"certs,omitempty"`
+	Plugins        PluginsSpec         `json:"plugins,omitempty"`
+	Alerting       AlertingSpec        `json:"alerting,omitempty"`
+	Profiling      ProfilingSpec       `json:"profiling,omitempty"`
+	Keyring        KeyringSpec         `json:"keyring,omitempty"`
+	StaleClusters  StaleClustersSpec   `json:"staleClusters,omitempty"`
+	HA             HASpec              `json:"ha,omitempty"`
+	Bootstrap      BootstrapServerSpec `json:"bootstrap,omitempty"`
+	HealthHistory  HealthHistorySpec   `json:"healthHistory,omitempty"`
 }
 
 type AlertingSpec struct {
@@ -59,9 +63,75 @@ type KeyringSpec struct {
 	// Directories to search for files containing ephemeral keys.
 	// All files in these directories will be loaded into the keyring on
 	// startup. Keys loaded in this way will not be persisted across restarts.
+	// On the gateway, keys with the encryption usage type are used to encrypt
+	// secrets stored by plugins, such as metrics import target credentials.
 	EphemeralKeyDirs []string `json:"ephemeralKeyDirs,omitempty"`
 }
 
+// HASpec configures coordination between multiple gateway replicas. All
+// replicas must use the same storage backend, which must support distributed
+// locks (etcd or JetStream).
+type HASpec struct {
+	// Enables running multiple gateway replicas. Agent sessions are tracked
+	// in the storage backend, and requests for agents connected to other
+	// replicas are relayed to the replica holding the agent's session.
+	Enabled bool `json:"enabled,omitempty"`
+	// Address to listen on for requests relayed from other replicas. This
+	// address should only be reachable by other gateway replicas.
+	RelayListenAddress string `json:"relayListenAddress,omitempty"`
+	// Address other replicas use to reach this replica's relay server.
+	// Defaults to the host in the POD_IP environment variable (or the
+	// hostname if unset), and the port of the relay listen address.
+	AdvertiseAddress string `json:"advertiseAddress,omitempty"`
+}
+
+// BootstrapServerSpec configures how new agents are added to the gateway.
+type BootstrapServerSpec struct {
+	// Maximum sustained rate of bootstrap requests allowed from a single
+	// source address, per minute. Defaults to 10.
+	RequestsPerMinute int `json:"requestsPerMinute,omitempty"`
+	// Number of bootstrap requests allowed from a single source address in a
+	// burst. Defaults to 5.
+	Burst int `json:"burst,omitempty"`
+	// Disables the v1 and v2 bootstrap services, which sign every active
+	// bootstrap token on each join request. Agents which do not support the
+	// v3 bootstrap service will no longer be able to join. This should only
+	// be enabled once all agents have been upgraded.
+	DisableLegacyJoin bool `json:"disableLegacyJoin,omitempty"`
+}
+
+// StaleClustersSpec configures the policy for clusters whose agents have
+// stopped connecting to the gateway.
+type StaleClustersSpec struct {
+	// Number of days a cluster's agent must be disconnected before the cluster
+	// is marked as stale. Stale cluster detection is disabled if unset.
+	StaleAfterDays int `json:"staleAfterDays,omitempty"`
+	// Number of days after a cluster has been marked as stale before its
+	// capabilities are uninstalled and the cluster is deleted. Stale clusters
+	// are never deleted if unset.
+	DeleteAfterDays int `json:"deleteAfterDays,omitempty"`
+	// If true, data stored by a stale cluster's capabilities will be deleted
+	// when the capabilities are uninstalled.
+	DeleteStoredData bool `json:"deleteStoredData,omitempty"`
+	// Clusters with any of these labels are exempt from the policy. Each entry
+	// is either a label key, or a key=value pair.
+	ExemptLabels []string `json:"exemptLabels,omitempty"`
+}
+
+// HealthHistorySpec configures the recorded history of agents' connection
+// status and health, and detection of clusters whose agents repeatedly
+// disconnect.
+type HealthHistorySpec struct {
+	// Number of days to retain health history. Defaults to 7.
+	RetentionDays int `json:"retentionDays,omitempty"`
+	// Clusters whose agents disconnect more than this many times within the
+	// flap detection window are marked as flapping. Flap detection is
+	// disabled if unset.
+	FlapThreshold int `json:"flapThreshold,omitempty"`
+	// Length of the flap detection window, in minutes. Defaults to 10.
+	FlapWindowMinutes int `json:"flapWindowMinutes,omitempty"`
+}
+
 func (s MetricsSpec) GetPath() string {
 	if s.Path == "" {
 		return "/metrics"
@@ -83,6 +153,20 @@ type ManagementSpec struct {
 	HTTPListenAddress string `json:"httpListenAddress,omitempty"`
 	//+kubebuilder:default="0.0.0.0:12080"
 	WebListenAddress string `json:"webListenAddress,omitempty"`
+	// Name of the auth provider used to authenticate management API requests.
+	// If unset, requests are not authenticated, and audit events will not
+	// contain a subject.
+	AuthProvider string `json:"authProvider,omitempty"`
+	// Subjects which are allowed to perform any action via the management
+	// API, regardless of their role bindings. Only used if authProvider is set.
+	AdminSubjects []string     `json:"adminSubjects,omitempty"`
+	AuditLog      AuditLogSpec `json:"auditLog,omitempty"`
+}
+
+// AuditLogSpec configures the log of mutating management API requests.
+type AuditLogSpec struct {
+	// Number of days to retain audit events. Defaults to 90.
+	RetentionDays int `json:"retentionDays,omitempty"`
 }
 
 func (m ManagementSpec) GetGRPCListenAddress() string {
@@ -118,6 +202,36 @@ type CortexSpec struct {
 	Querier       QuerierSpec           `json:"querier,omitempty"`
 	Purger        PurgerSpec            `json:"purger,omitempty"`
 	Certs         MTLSSpec              `json:"certs,omitempty"`
+
+	RemoteWriteBuffer RemoteWriteBufferSpec `json:"remoteWriteBuffer,omitempty"`
+	Import            MetricsImportSpec     `json:"import,omitempty"`
+}
+
+// RemoteWriteBufferSpec configures an on-disk buffer in metrics agents, which
+// holds remote-write payloads received while the gateway is unreachable and
+// replays them once the agent reconnects. Unset fields use the agent's
+// defaults.
+type RemoteWriteBufferSpec struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Directory on the agent in which buffered payloads are stored. This
+	// should be backed by a persistent volume for payloads to survive agent
+	// restarts.
+	Dir string `json:"dir,omitempty"`
+	// Maximum total size of buffered payloads, in MiB. Once the buffer is
+	// full, new payloads are rejected so that Prometheus retries them.
+	MaxSizeMiB int `json:"maxSizeMiB,omitempty"`
+	// Buffered payloads older than this many minutes are discarded.
+	MaxAgeMinutes int `json:"maxAgeMinutes,omitempty"`
+}
+
+// MetricsImportSpec limits the size of offline metrics snapshots uploaded to
+// the gateway. Unset fields use the gateway's defaults.
+type MetricsImportSpec struct {
+	// Maximum size of an uploaded snapshot, in MiB.
+	MaxUploadSizeMiB int `json:"maxUploadSizeMiB,omitempty"`
+	// Maximum total size of the files extracted from an uploaded snapshot,
+	// in MiB.
+	MaxExtractedSizeMiB int `json:"maxExtractedSizeMiB,omitempty"`
 }
 
 type ClusterManagementSpec struct {
@@ -212,10 +326,15 @@ type (
 
 const (
 	CacheBackendFilesystem CacheBackend = "filesystem"
+	CacheBackendS3         CacheBackend = "s3"
 )
 
 const (
 	PatchEngineBsdiff PatchEngine = "bsdiff"
+	// Generates patches using zstd with the previous plugin revision as a
+	// dictionary. Much faster than bsdiff for large plugins, but requires
+	// agents which support this patch format.
+	PatchEngineZstd PatchEngine = "zstd"
 )
 
 type PluginsSpec struct {
@@ -223,6 +342,74 @@ type PluginsSpec struct {
 	Dir string `json:"dir,omitempty"`
 	// Options for caching plugins
 	Cache CacheSpec `json:"cache,omitempty"`
+	// Options for rolling out updated plugins to agents
+	Rollout RolloutSpec `json:"rollout,omitempty"`
+	// Options for reloading plugins without restarting the gateway
+	Reload PluginReloadSpec `json:"reload,omitempty"`
+	// Options for verifying plugin signatures (agent only)
+	Verification PluginVerificationSpec `json:"verification,omitempty"`
+}
+
+type PluginTrustPolicy string
+
+const (
+	// Plugin signatures are not checked.
+	PluginTrustPolicyNone PluginTrustPolicy = "none"
+	// Plugins without a valid signature are logged, but still used.
+	PluginTrustPolicyWarn PluginTrustPolicy = "warn"
+	// Plugins without a valid signature are refused.
+	PluginTrustPolicyEnforce PluginTrustPolicy = "enforce"
+)
+
+// PluginVerificationSpec configures how agents verify the signatures of
+// plugins received from the gateway.
+type PluginVerificationSpec struct {
+	// Trust policy for plugin signatures. Defaults to "none".
+	Policy PluginTrustPolicy `json:"policy,omitempty"`
+	// Public key pins (e.g. "b2b256:...", in the same format as the agent's
+	// PKP trust pins) of the ed25519 keys plugins may be signed with. Plugins
+	// signed by any of these keys are trusted.
+	TrustedKeys []string `json:"trustedKeys,omitempty"`
+}
+
+// PluginReloadSpec configures hot reloading of gateway plugins. When enabled,
+// the plugin directory is watched for added, updated, or removed plugins.
+// Changed plugins are drained and replaced without restarting the gateway,
+// and agents are then synced the new plugins.
+type PluginReloadSpec struct {
+	// Enables hot reloading of plugins.
+	Enabled bool `json:"enabled,omitempty"`
+	// Number of seconds between checks of the plugin directory. Defaults
+	// to 10.
+	PollIntervalSeconds int `json:"pollIntervalSeconds,omitempty"`
+	// Number of seconds to wait for in-flight requests to complete before
+	// stopping a plugin which is being replaced. Defaults to 30.
+	DrainTimeoutSeconds int `json:"drainTimeoutSeconds,omitempty"`
+}
+
+// RolloutSpec configures staged rollouts of updated agent plugins. When the
+// gateway starts with plugins that differ from those previously rolled out,
+// agents are updated in waves, starting with a set of canary clusters. After
+// each wave, the health of updated clusters is checked before continuing. If
+// too many clusters become unhealthy, all clusters are reverted to the
+// previous plugins.
+type RolloutSpec struct {
+	// Enables staged rollouts. If disabled, agents are updated to the
+	// gateway's plugins as soon as they reconnect.
+	Enabled bool `json:"enabled,omitempty"`
+	// Clusters with all of these labels are updated in the first (canary)
+	// wave.
+	CanaryLabels map[string]string `json:"canaryLabels,omitempty"`
+	// Cumulative percentages of the remaining clusters to update in each wave
+	// after the canary wave. All remaining clusters are updated in the last
+	// wave. Defaults to [25, 50, 100].
+	Waves []int `json:"waves,omitempty"`
+	// Number of minutes to wait after starting each wave before checking the
+	// health of updated clusters. Defaults to 10.
+	WaveIntervalMinutes int `json:"waveIntervalMinutes,omitempty"`
+	// Percentage of clusters which may become unhealthy after being updated
+	// before the rollout is rolled back. Defaults to 0.
+	MaxUnhealthyPercent int `json:"maxUnhealthyPercent,omitempty"`
 }
 
 type CacheSpec struct {
@@ -230,12 +417,35 @@ type CacheSpec struct {
 
 	Backend    CacheBackend        `json:"backend,omitempty"`
 	Filesystem FilesystemCacheSpec `json:"filesystem,omitempty"`
+	S3         S3CacheSpec         `json:"s3,omitempty"`
 }
 
 type FilesystemCacheSpec struct {
 	Dir string `json:"dir,omitempty"`
 }
 
+// S3CacheSpec configures a plugin cache stored in an S3-compatible bucket,
+// which can be shared by multiple gateway replicas.
+type S3CacheSpec struct {
+	// The S3 endpoint, in hostname:port format. If unset, AWS S3 is used.
+	Endpoint string `json:"endpoint,omitempty"`
+	// S3 region
+	Region string `json:"region,omitempty"`
+	// S3 bucket name
+	BucketName string `json:"bucketName,omitempty"`
+	// Optional prefix for all objects stored in the bucket
+	Prefix string `json:"prefix,omitempty"`
+	// S3 access key ID
+	AccessKeyID string `json:"accessKeyID,omitempty"`
+	// S3 secret access key
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	// If enabled, use http:// for the S3 endpoint instead of https://
+	Insecure bool `json:"insecure,omitempty"`
+	// If enabled, use path-style addressing for buckets. This is required
+	// by most S3-compatible services.
+	ForcePathStyle bool `json:"forcePathStyle,omitempty"`
+}
+
 func (s *GatewayConfigSpec) SetDefaults() {
 	if s == nil {
 		return
@@ -303,12 +513,42 @@ func (s *GatewayConfigSpec) SetDefaults() {
 	if s.Cortex.Purger.HTTPAddress == "" {
 		s.Cortex.Purger.HTTPAddress = "cortex-purger:8080"
 	}
+	if s.HA.RelayListenAddress == "" {
+		s.HA.RelayListenAddress = ":9091"
+	}
+	if s.Bootstrap.RequestsPerMinute == 0 {
+		s.Bootstrap.RequestsPerMinute = 10
+	}
+	if s.Bootstrap.Burst == 0 {
+		s.Bootstrap.Burst = 5
+	}
 	if s.Plugins.Dir == "" {
 		s.Plugins.Dir = "/var/lib/opni/plugins"
 	}
 	if s.Plugins.Cache.PatchEngine == "" {
 		s.Plugins.Cache.PatchEngine = PatchEngineBsdiff
 	}
+	if len(s.Plugins.Rollout.Waves) == 0 {
+		s.Plugins.Rollout.Waves = []int{25, 50, 100}
+	}
+	if s.Plugins.Rollout.WaveIntervalMinutes == 0 {
+		s.Plugins.Rollout.WaveIntervalMinutes = 10
+	}
+	if s.Plugins.Reload.PollIntervalSeconds == 0 {
+		s.Plugins.Reload.PollIntervalSeconds = 10
+	}
+	if s.Plugins.Reload.DrainTimeoutSeconds == 0 {
+		s.Plugins.Reload.DrainTimeoutSeconds = 30
+	}
+	if s.Management.AuditLog.RetentionDays == 0 {
+		s.Management.AuditLog.RetentionDays = 90
+	}
+	if s.HealthHistory.RetentionDays == 0 {
+		s.HealthHistory.RetentionDays = 7
+	}
+	if s.HealthHistory.FlapWindowMinutes == 0 {
+		s.HealthHistory.FlapWindowMinutes = 10
+	}
 	if s.Plugins.Cache.Backend == "" {
 		s.Plugins.Cache.Backend = CacheBackendFilesystem
 	}
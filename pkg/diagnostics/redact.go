@@ -0,0 +1,105 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	storagev1 "github.com/rancher/opni/pkg/apis/storage/v1"
+)
+
+// Fields whose names contain any of these strings (case-insensitive) are
+// assumed to contain secrets.
+var secretFieldNames = []string{"token", "secret", "password", "credential"}
+
+// RedactSecrets returns the json representation of v, in which the values of
+// any fields which appear to contain secrets are replaced. Fields which are
+// unset are left as-is, so that it is still possible to tell whether they
+// were configured.
+func RedactSecrets(v any) (json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var obj any
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redact(obj))
+}
+
+var (
+	// "key": value pairs, as written by the json and console log encoders
+	jsonFieldPattern = regexp.MustCompile(`"([^"\\]*)"(\s*:\s*)("(?:[^"\\]|\\.)*"|[^,}\]\s]+)`)
+	// key=value pairs, as commonly found in urls and formatted messages
+	keyValuePattern = regexp.MustCompile(`([A-Za-z0-9_.-]+)=([^\s&"',]+)`)
+	// credentials in authorization headers
+	authHeaderPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+	// bootstrap tokens in their hex-encoded form
+	bootstrapTokenPattern = regexp.MustCompile(`\b[0-9a-f]{12}\.[0-9a-f]{52}\b`)
+)
+
+// RedactLogLine replaces values in a log line which appear to contain
+// secrets. This includes the values of structured fields and key=value pairs
+// whose names appear to refer to secrets, authorization header credentials,
+// and bootstrap tokens.
+func RedactLogLine(line string) string {
+	line = jsonFieldPattern.ReplaceAllStringFunc(line, func(match string) string {
+		m := jsonFieldPattern.FindStringSubmatch(match)
+		if !isSecretField(m[1]) || m[3] == `""` || m[3] == "null" {
+			return match
+		}
+		return `"` + m[1] + `"` + m[2] + `"` + storagev1.Redacted + `"`
+	})
+	line = keyValuePattern.ReplaceAllStringFunc(line, func(match string) string {
+		m := keyValuePattern.FindStringSubmatch(match)
+		if !isSecretField(m[1]) {
+			return match
+		}
+		return m[1] + "=" + storagev1.Redacted
+	})
+	line = authHeaderPattern.ReplaceAllString(line, "$1 "+storagev1.Redacted)
+	return bootstrapTokenPattern.ReplaceAllString(line, storagev1.Redacted)
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if isSecretField(key) && !isEmpty(value) {
+				v[key] = storagev1.Redacted
+				continue
+			}
+			v[key] = redact(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redact(value)
+		}
+	}
+	return v
+}
+
+func isSecretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretFieldNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isEmpty(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	}
+	return false
+}
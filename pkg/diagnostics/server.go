@@ -0,0 +1,289 @@
+package diagnostics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+)
+
+const (
+	defaultBundleTTL  = 10 * time.Minute
+	defaultChunkSize  = 1 << 20 // 1 MiB
+	maxChunkSize      = 2 << 20 // 2 MiB
+	maxPendingBundles = 4
+	errorsPath        = "errors.txt"
+)
+
+// Source collects a single file to be included in a support bundle.
+type Source struct {
+	// Path of the file within the bundle
+	Path    string
+	Collect func(ctx context.Context, req *controlv1.DiagnosticsRequest) ([]byte, error)
+}
+
+// JSONSource returns a source which writes the value returned by fn as
+// indented json. Protobuf messages are encoded using protojson.
+func JSONSource(path string, fn func(ctx context.Context) (any, error)) Source {
+	return Source{
+		Path: path,
+		Collect: func(ctx context.Context, _ *controlv1.DiagnosticsRequest) ([]byte, error) {
+			v, err := fn(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if msg, ok := v.(proto.Message); ok {
+				return protojson.MarshalOptions{
+					Multiline: true,
+				}.Marshal(msg)
+			}
+			return json.MarshalIndent(v, "", "  ")
+		},
+	}
+}
+
+// ProfileSource returns a source which writes the named runtime profile
+// (e.g. "goroutine" or "heap"). See pprof.Profile.WriteTo for the meaning of
+// the debug parameter.
+func ProfileSource(path string, profile string, debug int) Source {
+	return Source{
+		Path: path,
+		Collect: func(context.Context, *controlv1.DiagnosticsRequest) ([]byte, error) {
+			p := pprof.Lookup(profile)
+			if p == nil {
+				return nil, fmt.Errorf("unknown profile %q", profile)
+			}
+			var buf bytes.Buffer
+			if err := p.WriteTo(&buf, debug); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+	}
+}
+
+// LogSource returns a source which writes the most recent lines in the log
+// buffer, up to the number of lines requested. Secrets are redacted from each
+// line using RedactLogLine.
+func LogSource(path string, logs *LogBuffer) Source {
+	return Source{
+		Path: path,
+		Collect: func(_ context.Context, req *controlv1.DiagnosticsRequest) ([]byte, error) {
+			lines := logs.Lines(int(req.GetLogLines()))
+			if len(lines) == 0 {
+				return nil, nil
+			}
+			for i, line := range lines {
+				lines[i] = RedactLogLine(line)
+			}
+			return []byte(strings.Join(lines, "\n") + "\n"), nil
+		},
+	}
+}
+
+type bundle struct {
+	data   []byte
+	expiry *time.Timer
+}
+
+// Server implements the Diagnostics service. Each call to CollectDiagnostics
+// builds a gzipped tarball containing one file per source, which is held in
+// memory until it has been read in full or its TTL has elapsed. Sources which
+// fail do not prevent the bundle from being built; their errors are listed in
+// errors.txt instead.
+type Server struct {
+	controlv1.UnsafeDiagnosticsServer
+	ServerOptions
+	sources []Source
+	logger  *zap.SugaredLogger
+
+	mu      sync.Mutex
+	bundles map[string]*bundle
+}
+
+var _ controlv1.DiagnosticsServer = (*Server)(nil)
+
+type ServerOptions struct {
+	bundleTTL time.Duration
+}
+
+type ServerOption func(*ServerOptions)
+
+func (o *ServerOptions) apply(opts ...ServerOption) {
+	for _, op := range opts {
+		op(o)
+	}
+}
+
+// WithBundleTTL sets how long a bundle which has not been read in full is
+// kept before it is discarded.
+func WithBundleTTL(ttl time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.bundleTTL = ttl
+	}
+}
+
+func NewServer(sources []Source, lg *zap.SugaredLogger, opts ...ServerOption) *Server {
+	options := ServerOptions{
+		bundleTTL: defaultBundleTTL,
+	}
+	options.apply(opts...)
+	return &Server{
+		ServerOptions: options,
+		sources:       sources,
+		logger:        lg,
+		bundles:       make(map[string]*bundle),
+	}
+}
+
+func (s *Server) CollectDiagnostics(ctx context.Context, req *controlv1.DiagnosticsRequest) (*controlv1.DiagnosticsBundle, error) {
+	s.mu.Lock()
+	pending := len(s.bundles)
+	s.mu.Unlock()
+	if pending >= maxPendingBundles {
+		return nil, status.Error(codes.ResourceExhausted, "too many support bundles pending; try again later")
+	}
+
+	data, err := s.build(ctx, req)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to build support bundle: %v", err)
+	}
+
+	id := uuid.NewString()
+	s.mu.Lock()
+	s.bundles[id] = &bundle{
+		data: data,
+		expiry: time.AfterFunc(s.bundleTTL, func() {
+			s.discard(id)
+		}),
+	}
+	s.mu.Unlock()
+
+	s.logger.With(
+		"id", id,
+		"size", len(data),
+	).Info("support bundle collected")
+	return &controlv1.DiagnosticsBundle{
+		Id:   id,
+		Size: int64(len(data)),
+	}, nil
+}
+
+// WriteBundle builds a support bundle and writes it to w, without retaining
+// it. This can be used to collect a bundle locally, without a connection to
+// the gateway.
+func (s *Server) WriteBundle(ctx context.Context, req *controlv1.DiagnosticsRequest, w io.Writer) error {
+	data, err := s.build(ctx, req)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *Server) ReadDiagnosticsBundle(_ context.Context, req *controlv1.DiagnosticsReadRequest) (*controlv1.DiagnosticsChunk, error) {
+	s.mu.Lock()
+	b, ok := s.bundles[req.GetId()]
+	s.mu.Unlock()
+	if !ok {
+		return nil, status.Error(codes.NotFound, "support bundle not found")
+	}
+	size := int64(len(b.data))
+	if req.GetOffset() < 0 || req.GetOffset() > size {
+		return nil, status.Errorf(codes.OutOfRange, "offset %d is out of range [0, %d]", req.GetOffset(), size)
+	}
+	length := int64(req.GetLength())
+	switch {
+	case length <= 0:
+		length = defaultChunkSize
+	case length > maxChunkSize:
+		length = maxChunkSize
+	}
+	end := req.GetOffset() + length
+	if end > size {
+		end = size
+	}
+	chunk := &controlv1.DiagnosticsChunk{
+		Data: b.data[req.GetOffset():end],
+		Eof:  end == size,
+	}
+	if chunk.Eof {
+		s.discard(req.GetId())
+	}
+	return chunk, nil
+}
+
+func (s *Server) discard(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.bundles[id]; ok {
+		b.expiry.Stop()
+		delete(s.bundles, id)
+	}
+}
+
+func (s *Server) build(ctx context.Context, req *controlv1.DiagnosticsRequest) ([]byte, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	now := time.Now()
+
+	var errs []string
+	for _, src := range s.sources {
+		data, err := src.Collect(ctx, req)
+		if err != nil {
+			s.logger.With(
+				zap.Error(err),
+				"path", src.Path,
+			).Warn("failed to collect diagnostics")
+			errs = append(errs, fmt.Sprintf("%s: %v", src.Path, err))
+			continue
+		}
+		if err := writeFile(tw, src.Path, data, now); err != nil {
+			return nil, err
+		}
+	}
+	if len(errs) > 0 {
+		if err := writeFile(tw, errorsPath, []byte(strings.Join(errs, "\n")+"\n"), now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeFile(tw *tar.Writer, path string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path,
+		Size:     int64(len(data)),
+		Mode:     0644,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
@@ -2,7 +2,9 @@ package gateway
 
 import (
 	"context"
-	"google.golang.org/protobuf/proto"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/kralicky/totem"
@@ -11,11 +13,14 @@ import (
 	streamv1 "github.com/rancher/opni/pkg/apis/stream/v1"
 	"github.com/rancher/opni/pkg/auth/cluster"
 	"github.com/rancher/opni/pkg/storage"
+	"github.com/samber/lo"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type agentInfo struct {
@@ -35,22 +40,52 @@ func (a agentInfo) GetId() string {
 
 type DelegateServer struct {
 	streamv1.UnsafeDelegateServer
+	streamv1.UnsafeRelayServer
+	DelegateServerOptions
 	mu           sync.RWMutex
 	activeAgents map[string]agentInfo
 	logger       *zap.SugaredLogger
 	clusterStore storage.ClusterStore
+
+	relayClientsMu sync.Mutex
+	relayClients   map[string]*grpc.ClientConn
+}
+
+type DelegateServerOptions struct {
+	sessions       *SessionRegistry
+	relayTLSConfig *tls.Config
+}
+
+type DelegateServerOption func(*DelegateServerOptions)
+
+func (o *DelegateServerOptions) apply(opts ...DelegateServerOption) {
+	for _, op := range opts {
+		op(o)
+	}
 }
 
-func NewDelegateServer(clusterStore storage.ClusterStore, lg *zap.SugaredLogger) *DelegateServer {
+// WithRelay enables forwarding requests for agents connected to other
+// gateway replicas, using the given session registry to locate them.
+func WithRelay(sessions *SessionRegistry, tlsConfig *tls.Config) DelegateServerOption {
+	return func(o *DelegateServerOptions) {
+		o.sessions = sessions
+		o.relayTLSConfig = tlsConfig
+	}
+}
+
+func NewDelegateServer(clusterStore storage.ClusterStore, lg *zap.SugaredLogger, opts ...DelegateServerOption) *DelegateServer {
+	options := DelegateServerOptions{}
+	options.apply(opts...)
 	return &DelegateServer{
-		activeAgents: make(map[string]agentInfo),
-		clusterStore: clusterStore,
-		logger:       lg.Named("delegate"),
+		DelegateServerOptions: options,
+		activeAgents:          make(map[string]agentInfo),
+		clusterStore:          clusterStore,
+		logger:                lg.Named("delegate"),
+		relayClients:          make(map[string]*grpc.ClientConn),
 	}
 }
 
 func (d *DelegateServer) HandleAgentConnection(ctx context.Context, clientSet agentv1.ClientSet) {
-	d.mu.Lock()
 	id := cluster.StreamAuthorizedID(ctx)
 
 	cluster, err := d.clusterStore.GetCluster(ctx, &corev1.Reference{Id: id})
@@ -62,6 +97,7 @@ func (d *DelegateServer) HandleAgentConnection(ctx context.Context, clientSet ag
 		return
 	}
 
+	d.mu.Lock()
 	d.activeAgents[id] = agentInfo{
 		ClientConnInterface: clientSet.ClientConn(),
 		labels:              cluster.GetLabels(),
@@ -79,61 +115,106 @@ func (d *DelegateServer) HandleAgentConnection(ctx context.Context, clientSet ag
 }
 
 func (d *DelegateServer) Request(ctx context.Context, req *streamv1.DelegatedMessage) (*totem.RPC, error) {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
-
 	targetId := req.GetTarget().GetId()
 	lg := d.logger.With(
 		"target", targetId,
 		"request", req.GetRequest().QualifiedMethodName(),
 	)
 	lg.Debug("delegating rpc request")
-	target, ok := d.activeAgents[targetId]
-	if ok {
-		fwdResp := &totem.RPC{}
-		err := target.Invoke(ctx, totem.Forward, req.GetRequest(), fwdResp)
-		if err != nil {
-			d.logger.With(
-				zap.Error(err),
-			).Error("delegating rpc request failed")
-			return nil, err
-		}
 
-		resp := &totem.RPC{}
-		err = proto.Unmarshal(fwdResp.GetResponse().GetResponse(), resp)
-		if err != nil {
-			d.logger.With(
-				zap.Error(err),
-			).Error("delegating rpc request failed")
-			return nil, err
-		}
+	resp, err := d.requestLocal(ctx, req)
+	if status.Code(err) == codes.NotFound && d.sessions != nil {
+		resp, err = d.requestRemote(ctx, req)
+	}
+	if err != nil {
+		lg.With(
+			zap.Error(err),
+		).Warn("delegating rpc request failed")
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Implements streamv1.RelayServer
+func (d *DelegateServer) RelayRequest(ctx context.Context, req *streamv1.DelegatedMessage) (*totem.RPC, error) {
+	return d.requestLocal(ctx, req)
+}
+
+func (d *DelegateServer) requestLocal(ctx context.Context, req *streamv1.DelegatedMessage) (*totem.RPC, error) {
+	d.mu.RLock()
+	target, ok := d.activeAgents[req.GetTarget().GetId()]
+	d.mu.RUnlock()
+	if !ok {
+		return nil, status.Error(codes.NotFound, "target not found")
+	}
 
-		return resp, nil
+	fwdResp := &totem.RPC{}
+	if err := target.Invoke(ctx, totem.Forward, req.GetRequest(), fwdResp); err != nil {
+		return nil, err
 	}
 
-	err := status.Error(codes.NotFound, "target not found")
-	lg.With(
-		zap.Error(err),
-	).Warn("delegating rpc request failed")
-	return nil, err
+	resp := &totem.RPC{}
+	if err := proto.Unmarshal(fwdResp.GetResponse().GetResponse(), resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (d *DelegateServer) requestRemote(ctx context.Context, req *streamv1.DelegatedMessage) (*totem.RPC, error) {
+	session, err := d.sessions.Lookup(ctx, req.GetTarget().GetId())
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "target not found")
+		}
+		return nil, status.Errorf(codes.Unavailable, "failed to look up target session: %v", err)
+	}
+	if session.ReplicaId == d.sessions.ReplicaId() {
+		// the session was registered by this replica, but the agent is no
+		// longer connected
+		return nil, status.Error(codes.NotFound, "target not found")
+	}
+	client, err := d.relayClient(session.RelayAddress)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to connect to gateway replica: %v", err)
+	}
+	return client.RelayRequest(ctx, req)
 }
 
 func (d *DelegateServer) Broadcast(ctx context.Context, req *streamv1.BroadcastMessage) (*streamv1.BroadcastReplyList, error) {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+	reply, err := d.broadcastLocal(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if d.sessions != nil {
+		remote, err := d.broadcastRemote(ctx, req)
+		reply.Responses = append(reply.Responses, remote...)
+		if err != nil && len(reply.Responses) == 0 {
+			return nil, status.Errorf(codes.Unavailable, "failed to relay broadcast: %v", err)
+		}
+	}
 
+	if len(reply.Responses) == 0 {
+		return nil, status.Error(codes.NotFound, "no targets found")
+	}
+	return reply, nil
+}
+
+// Implements streamv1.RelayServer
+func (d *DelegateServer) RelayBroadcast(ctx context.Context, req *streamv1.BroadcastMessage) (*streamv1.BroadcastReplyList, error) {
+	return d.broadcastLocal(ctx, req)
+}
+
+func (d *DelegateServer) broadcastLocal(ctx context.Context, req *streamv1.BroadcastMessage) (*streamv1.BroadcastReplyList, error) {
 	sp := storage.NewSelectorPredicate[agentInfo](req.GetTargetSelector())
 
 	var targets []agentInfo
+	d.mu.RLock()
 	for _, aa := range d.activeAgents {
 		if sp(aa) {
 			targets = append(targets, aa)
 		}
 	}
-
-	if len(targets) == 0 {
-		return nil, status.Error(codes.NotFound, "no targets found")
-	}
+	d.mu.RUnlock()
 
 	eg, ctx := errgroup.WithContext(ctx)
 	reply := &streamv1.BroadcastReplyList{
@@ -166,3 +247,57 @@ func (d *DelegateServer) Broadcast(ctx context.Context, req *streamv1.BroadcastM
 
 	return reply, nil
 }
+
+// broadcastRemote relays the broadcast to all other gateway replicas. Replicas
+// which cannot be reached are logged and skipped, so that the replies from the
+// other replicas can still be returned. The returned error joins the errors
+// from all replicas which failed.
+func (d *DelegateServer) broadcastRemote(ctx context.Context, req *streamv1.BroadcastMessage) ([]*streamv1.BroadcastReply, error) {
+	replicas, err := d.sessions.RemoteReplicas(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to list gateway replicas: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	replies := make([][]*streamv1.BroadcastReply, len(replicas))
+	errs := make([]error, len(replicas))
+	for i, replica := range replicas {
+		i, replica := i, replica
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := d.relayClient(replica.GetRelayAddress())
+			if err == nil {
+				var resp *streamv1.BroadcastReplyList
+				resp, err = client.RelayBroadcast(ctx, req)
+				replies[i] = resp.GetResponses()
+			}
+			if err != nil {
+				d.logger.With(
+					"replica", replica.GetId(),
+					"address", replica.GetRelayAddress(),
+					zap.Error(err),
+				).Warn("failed to relay broadcast to gateway replica")
+				errs[i] = fmt.Errorf("replica %s: %w", replica.GetId(), err)
+			}
+		}()
+	}
+	wg.Wait()
+	return lo.Flatten(replies), errors.Join(errs...)
+}
+
+func (d *DelegateServer) relayClient(address string) (streamv1.RelayClient, error) {
+	d.relayClientsMu.Lock()
+	defer d.relayClientsMu.Unlock()
+	if cc, ok := d.relayClients[address]; ok {
+		return streamv1.NewRelayClient(cc), nil
+	}
+	cc, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(credentials.NewTLS(d.relayTLSConfig)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	d.relayClients[address] = cc
+	return streamv1.NewRelayClient(cc), nil
+}
@@ -0,0 +1,110 @@
+package gateway_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/golang/mock/gomock"
+	"github.com/kralicky/totem"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/exp/slices"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	streamv1 "github.com/rancher/opni/pkg/apis/stream/v1"
+	"github.com/rancher/opni/pkg/gateway"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/test"
+)
+
+// testRelay replies to relayed broadcasts on behalf of a single agent
+type testRelay struct {
+	streamv1.UnsafeRelayServer
+	agent string
+}
+
+func (r *testRelay) RelayRequest(context.Context, *streamv1.DelegatedMessage) (*totem.RPC, error) {
+	return nil, status.Error(codes.Unimplemented, "not implemented")
+}
+
+func (r *testRelay) RelayBroadcast(context.Context, *streamv1.BroadcastMessage) (*streamv1.BroadcastReplyList, error) {
+	return &streamv1.BroadcastReplyList{
+		Responses: []*streamv1.BroadcastReply{
+			{Ref: &corev1.Reference{Id: r.agent}},
+		},
+	}, nil
+}
+
+var _ = Describe("Delegate Server", Label("unit"), func() {
+	var (
+		ctx      context.Context
+		kv       storage.KeyValueStore
+		locks    storage.LockManager
+		registry *gateway.SessionRegistry
+		delegate *gateway.DelegateServer
+	)
+	BeforeEach(func() {
+		var ca context.CancelFunc
+		ctx, ca = context.WithCancel(context.Background())
+		DeferCleanup(ca)
+		ctrl := gomock.NewController(GinkgoT())
+		kv = test.NewTestKeyValueStore(ctrl, slices.Clone[[]byte])
+		locks = storage.NewInMemoryLockManager()
+		registry = gateway.NewSessionRegistry(kv, locks, "a", "127.0.0.1:0", test.Log)
+		delegate = gateway.NewDelegateServer(test.NewTestClusterStore(ctrl), test.Log,
+			gateway.WithRelay(registry, &tls.Config{InsecureSkipVerify: true}),
+		)
+	})
+
+	// startReplica registers a replica with the given relay address
+	startReplica := func(id, address string) {
+		go gateway.NewSessionRegistry(kv, locks, id, address, test.Log).Run(ctx)
+	}
+	// startRelay serves the relay service for a replica, and returns its address
+	startRelay := func(agent string) string {
+		cert, err := tls.X509KeyPair(test.TestData("localhost.crt"), test.TestData("localhost.key"))
+		Expect(err).NotTo(HaveOccurred())
+		srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+		})))
+		streamv1.RegisterRelayServer(srv, &testRelay{agent: agent})
+		listener, err := net.Listen("tcp4", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go srv.Serve(listener)
+		DeferCleanup(srv.Stop)
+		return listener.Addr().String()
+	}
+	waitForReplicas := func(n int) {
+		Eventually(func() ([]*streamv1.Replica, error) {
+			return registry.RemoteReplicas(ctx)
+		}).Should(HaveLen(n))
+	}
+
+	It("should return replies from reachable replicas if others are unreachable", func() {
+		startReplica("b", startRelay("agent-b"))
+		startReplica("c", "127.0.0.1:1")
+		waitForReplicas(2)
+
+		reply, err := delegate.Broadcast(ctx, &streamv1.BroadcastMessage{
+			TargetSelector: &corev1.ClusterSelector{},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reply.GetResponses()).To(HaveLen(1))
+		Expect(reply.GetResponses()[0].GetRef().GetId()).To(Equal("agent-b"))
+	})
+
+	It("should return an error if no replicas could be reached", func() {
+		startReplica("c", "127.0.0.1:1")
+		waitForReplicas(1)
+
+		_, err := delegate.Broadcast(ctx, &streamv1.BroadcastMessage{
+			TargetSelector: &corev1.ClusterSelector{},
+		})
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+	})
+})
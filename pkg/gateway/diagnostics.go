@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kralicky/totem"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	streamv1 "github.com/rancher/opni/pkg/apis/stream/v1"
+)
+
+// Size of each chunk requested from the agent while reading a support bundle
+const diagnosticsChunkSize = 1 << 20 // 1 MiB
+
+// AgentRequester sends unary requests to an agent's stream, which may be held
+// by another gateway replica. Implemented by DelegateServer.
+type AgentRequester interface {
+	Request(ctx context.Context, req *streamv1.DelegatedMessage) (*totem.RPC, error)
+}
+
+// DiagnosticsCollector requests support bundles from connected agents. Only
+// unary requests can be made over the agent's stream, so the bundle is read
+// back from the agent in chunks, which are written out as they are received.
+// Requests are sent through the delegate server, so that agents connected to
+// other gateway replicas can be reached through the relay.
+type DiagnosticsCollector struct {
+	requester AgentRequester
+	logger    *zap.SugaredLogger
+}
+
+func NewDiagnosticsCollector(requester AgentRequester, lg *zap.SugaredLogger) *DiagnosticsCollector {
+	return &DiagnosticsCollector{
+		requester: requester,
+		logger:    lg.Named("diagnostics"),
+	}
+}
+
+// CollectDiagnostics requests a support bundle from the agent identified by
+// ref, and writes the gzipped tarball to w.
+func (c *DiagnosticsCollector) CollectDiagnostics(ctx context.Context, ref *corev1.Reference, logLines int32, w io.Writer) error {
+	client := controlv1.NewDiagnosticsClient(&delegatedClientConn{
+		requester: c.requester,
+		target:    ref,
+	})
+
+	bundle, err := client.CollectDiagnostics(ctx, &controlv1.DiagnosticsRequest{
+		LogLines: logLines,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			return status.Error(codes.NotFound, "agent is not connected")
+		case codes.Unimplemented:
+			return status.Error(codes.FailedPrecondition, "agent does not support collecting diagnostics")
+		}
+		return fmt.Errorf("failed to collect diagnostics: %w", err)
+	}
+
+	var offset int64
+	for {
+		chunk, err := client.ReadDiagnosticsBundle(ctx, &controlv1.DiagnosticsReadRequest{
+			Id:     bundle.GetId(),
+			Offset: offset,
+			Length: diagnosticsChunkSize,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read support bundle: %w", err)
+		}
+		if _, err := w.Write(chunk.GetData()); err != nil {
+			return err
+		}
+		offset += int64(len(chunk.GetData()))
+		if chunk.GetEof() {
+			break
+		}
+		if len(chunk.GetData()) == 0 {
+			return status.Error(codes.DataLoss, "agent returned an empty chunk before the end of the support bundle")
+		}
+	}
+	if offset != bundle.GetSize() {
+		return status.Errorf(codes.DataLoss, "support bundle size mismatch (expected %d bytes, got %d)", bundle.GetSize(), offset)
+	}
+
+	c.logger.With(
+		"id", ref.GetId(),
+		"size", offset,
+	).Info("collected support bundle")
+	return nil
+}
+
+// delegatedClientConn sends unary requests to a single agent through an
+// AgentRequester.
+type delegatedClientConn struct {
+	requester AgentRequester
+	target    *corev1.Reference
+}
+
+func (cc *delegatedClientConn) Invoke(ctx context.Context, method string, args any, reply any, _ ...grpc.CallOption) error {
+	// method names are of the form "/service/method"
+	parts := strings.Split(method, "/")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid method name: %s", method)
+	}
+	request, err := proto.Marshal(args.(proto.Message))
+	if err != nil {
+		return err
+	}
+	resp, err := cc.requester.Request(ctx, &streamv1.DelegatedMessage{
+		Target: cc.target,
+		Request: &totem.RPC{
+			ServiceName: parts[1],
+			MethodName:  parts[2],
+			Content: &totem.RPC_Request{
+				Request: request,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if stat := resp.GetResponse().GetStatus(); stat.Code() != codes.OK {
+		return stat.Err()
+	}
+	if err := proto.Unmarshal(resp.GetResponse().GetResponse(), reply.(proto.Message)); err != nil {
+		return status.Errorf(codes.Internal, "failed to unmarshal response: %v", err)
+	}
+	return nil
+}
+
+func (cc *delegatedClientConn) NewStream(context.Context, *grpc.StreamDesc, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Error(codes.Unimplemented, "streams are not supported")
+}
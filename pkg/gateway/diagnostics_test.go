@@ -0,0 +1,128 @@
+package gateway_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/kralicky/totem"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	streamv1 "github.com/rancher/opni/pkg/apis/stream/v1"
+	"github.com/rancher/opni/pkg/diagnostics"
+	"github.com/rancher/opni/pkg/gateway"
+	"github.com/rancher/opni/pkg/test"
+)
+
+// testRequester serves delegated diagnostics requests for a single agent
+type testRequester struct {
+	target    string
+	server    controlv1.DiagnosticsServer
+	reads     int
+	connected bool
+}
+
+func (r *testRequester) Request(ctx context.Context, req *streamv1.DelegatedMessage) (*totem.RPC, error) {
+	if !r.connected || req.GetTarget().GetId() != r.target {
+		return nil, status.Error(codes.NotFound, "target not found")
+	}
+	var resp proto.Message
+	var err error
+	switch req.GetRequest().GetMethodName() {
+	case "CollectDiagnostics":
+		in := &controlv1.DiagnosticsRequest{}
+		Expect(proto.Unmarshal(req.GetRequest().GetRequest(), in)).To(Succeed())
+		resp, err = r.server.CollectDiagnostics(ctx, in)
+	case "ReadDiagnosticsBundle":
+		r.reads++
+		in := &controlv1.DiagnosticsReadRequest{}
+		Expect(proto.Unmarshal(req.GetRequest().GetRequest(), in)).To(Succeed())
+		resp, err = r.server.ReadDiagnosticsBundle(ctx, in)
+	default:
+		err = status.Error(codes.Unimplemented, "unknown method")
+	}
+	if err != nil {
+		return &totem.RPC{
+			Content: &totem.RPC_Response{
+				Response: &totem.Response{
+					StatusProto: status.Convert(err).Proto(),
+				},
+			},
+		}, nil
+	}
+	result := &totem.Response{
+		StatusProto: status.New(codes.OK, "").Proto(),
+	}
+	result.Response, err = proto.Marshal(resp)
+	Expect(err).NotTo(HaveOccurred())
+	return &totem.RPC{
+		Content: &totem.RPC_Response{
+			Response: result,
+		},
+	}, nil
+}
+
+func files(bundle []byte) map[string]int64 {
+	gr, err := gzip.NewReader(bytes.NewReader(bundle))
+	Expect(err).NotTo(HaveOccurred())
+	tr := tar.NewReader(gr)
+	sizes := map[string]int64{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return sizes
+		}
+		Expect(err).NotTo(HaveOccurred())
+		sizes[hdr.Name] = hdr.Size
+	}
+}
+
+var _ = Describe("Diagnostics Collector", Label("unit"), func() {
+	var collector *gateway.DiagnosticsCollector
+	var requester *testRequester
+
+	BeforeEach(func() {
+		// large enough to be read in multiple chunks, even when compressed
+		large := make([]byte, 3<<20)
+		rand.Read(large)
+		requester = &testRequester{
+			target:    "agent-1",
+			connected: true,
+			server: diagnostics.NewServer([]diagnostics.Source{
+				{
+					Path: "large.txt",
+					Collect: func(context.Context, *controlv1.DiagnosticsRequest) ([]byte, error) {
+						return large, nil
+					},
+				},
+			}, test.Log),
+		}
+		collector = gateway.NewDiagnosticsCollector(requester, test.Log)
+	})
+
+	It("should read the bundle from the agent in chunks", func() {
+		var buf bytes.Buffer
+		Expect(collector.CollectDiagnostics(context.Background(), &corev1.Reference{Id: "agent-1"}, 0, &buf)).To(Succeed())
+		Expect(requester.reads).To(BeNumerically(">", 1))
+		Expect(files(buf.Bytes())).To(HaveKeyWithValue("large.txt", BeEquivalentTo(3<<20)))
+	})
+
+	It("should return an error if the agent is not connected", func() {
+		err := collector.CollectDiagnostics(context.Background(), &corev1.Reference{Id: "agent-2"}, 0, &bytes.Buffer{})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+
+		requester.connected = false
+		err = collector.CollectDiagnostics(context.Background(), &corev1.Reference{Id: "agent-1"}, 0, &bytes.Buffer{})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+	})
+})
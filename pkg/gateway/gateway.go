@@ -5,16 +5,21 @@ import (
 	"crypto"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"time"
 
 	"github.com/rancher/opni/pkg/patch"
 
 	"github.com/hashicorp/go-plugin"
+	gsync "github.com/kralicky/gpkg/sync"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/samber/lo"
 	"go.uber.org/zap"
 	"golang.org/x/exp/slices"
 	"golang.org/x/mod/module"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
@@ -23,9 +28,11 @@ import (
 
 	bootstrapv1 "github.com/rancher/opni/pkg/apis/bootstrap/v1"
 	bootstrapv2 "github.com/rancher/opni/pkg/apis/bootstrap/v2"
+	bootstrapv3 "github.com/rancher/opni/pkg/apis/bootstrap/v3"
 	capabilityv1 "github.com/rancher/opni/pkg/apis/capability/v1"
 	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
 	streamv1 "github.com/rancher/opni/pkg/apis/stream/v1"
 	"github.com/rancher/opni/pkg/auth"
 	"github.com/rancher/opni/pkg/auth/cluster"
@@ -59,6 +66,12 @@ type Gateway struct {
 	storageBackend  storage.Backend
 	capBackendStore capabilities.BackendStore
 	syncRequester   *SyncRequester
+	keyRotator      *KeyRotator
+	diagnostics     *DiagnosticsCollector
+	staleClusters   *StaleClusterCollector
+	healthHistory   *HealthHistoryCollector
+	rollouts        *PluginRolloutController
+	relayServer     *RelayServer
 }
 
 type GatewayOptions struct {
@@ -94,6 +107,16 @@ func NewGateway(ctx context.Context, conf *config.GatewayConfig, pl plugins.Load
 			zap.Error(err),
 		).Error("failed to configure storage backend")
 	}
+	if conf.Spec.HA.Enabled {
+		// replicas coordinate through storage locks; in-process locks would
+		// not exclude other replicas
+		if _, err := storage.DistributedLockManagerFor(storageBackend, "gateway"); err != nil {
+			lg.With(
+				zap.String("type", string(conf.Spec.Storage.Type)),
+				zap.Error(err),
+			).Panic("HA mode requires a storage backend which supports distributed locks")
+		}
+	}
 
 	// configure the server-side installer template with the external hostname
 	// and grpc port which agents will connect to
@@ -109,6 +132,7 @@ func NewGateway(ctx context.Context, conf *config.GatewayConfig, pl plugins.Load
 	}, lg)
 
 	// add capabilities from plugins
+	capabilityNames := gsync.Map[string, string]{}
 	pl.Hook(hooks.OnLoadM(func(p types.CapabilityBackendPlugin, md meta.PluginMeta) {
 		info, err := p.Info(ctx, &emptypb.Empty{})
 		if err != nil {
@@ -122,12 +146,31 @@ func NewGateway(ctx context.Context, conf *config.GatewayConfig, pl plugins.Load
 				zap.String("plugin", md.Module),
 				zap.Error(err),
 			).Error("failed to add capability backend")
+			return
 		}
+		capabilityNames.Store(md.Module, info.Name)
 		lg.With(
 			zap.String("plugin", md.Module),
 			zap.String("capability", info.Name),
 		).Info("added capability backend")
 	}))
+	pl.Hook(hooks.OnUnloadM(func(_ types.CapabilityBackendPlugin, md meta.PluginMeta) {
+		name, ok := capabilityNames.LoadAndDelete(md.Module)
+		if !ok {
+			return
+		}
+		if err := capBackendStore.Remove(name); err != nil {
+			lg.With(
+				zap.String("plugin", md.Module),
+				zap.Error(err),
+			).Error("failed to remove capability backend")
+			return
+		}
+		lg.With(
+			zap.String("plugin", md.Module),
+			zap.String("capability", name),
+		).Info("removed capability backend")
+	}))
 
 	// serve system plugin kv stores
 	pl.Hook(hooks.OnLoadM(func(p types.SystemPlugin, md meta.PluginMeta) {
@@ -161,8 +204,10 @@ func NewGateway(ctx context.Context, conf *config.GatewayConfig, pl plugins.Load
 		).Panic("failed to create cluster auth")
 	}
 
+	monitor := health.NewMonitor(health.WithLogger(lg.Named("monitor")))
+
 	// set up plugin sync server
-	syncServer, err := patch.NewFilesystemPluginSyncServer(conf.Spec.Plugins, lg,
+	syncServerOptions := []patch.SyncServerOption{
 		patch.WithPluginSyncFilters(func(pm meta.PluginMeta) bool {
 			if pm.ExtendedMetadata != nil {
 				// only sync plugins that have the agent mode set
@@ -170,7 +215,32 @@ func NewGateway(ctx context.Context, conf *config.GatewayConfig, pl plugins.Load
 			}
 			return true // default to syncing all plugins
 		}),
-	)
+		patch.WithLockManager(storage.LockManagerFor(storageBackend, "gateway")),
+	}
+	if conf.Spec.HA.Enabled {
+		// replicas may share the plugin cache; garbage collection must keep
+		// plugins referenced by any replica
+		replicaId, err := os.Hostname()
+		if err != nil {
+			lg.With(
+				zap.Error(err),
+			).Panic("failed to determine replica id")
+		}
+		syncServerOptions = append(syncServerOptions, patch.WithSharedReferences(
+			storageBackend.KeyValueStore("plugin-cache-references"), replicaId,
+		))
+	}
+	var rollouts *PluginRolloutController
+	if conf.Spec.Plugins.Rollout.Enabled {
+		rollouts = NewPluginRolloutController(conf.Spec.Plugins.Rollout,
+			storageBackend.KeyValueStore("plugin-rollout"),
+			storageBackend,
+			storage.LockManagerFor(storageBackend, "gateway"),
+			monitor, lg,
+		)
+		syncServerOptions = append(syncServerOptions, patch.WithManifestSelector(rollouts))
+	}
+	syncServer, err := patch.NewFilesystemPluginSyncServer(conf.Spec.Plugins, lg, syncServerOptions...)
 	if err != nil {
 		lg.With(
 			zap.Error(err),
@@ -179,31 +249,102 @@ func NewGateway(ctx context.Context, conf *config.GatewayConfig, pl plugins.Load
 
 	httpServer.metricsRegisterer.MustRegister(syncServer.Collectors()...)
 
+	agentPlugins := NewAgentPluginEnforcer(syncServer.DesiredManifest, lg.Named("plugins"))
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		clusterAuth.StreamServerInterceptor(),
+		syncServer.StreamServerInterceptor(),
+		agentPlugins.StreamServerInterceptor(),
+	}
+	if rollouts != nil {
+		// the rollout must be started before garbage collection, so that the
+		// previous plugins are kept in the cache
+		manifest, _ := syncServer.GetPluginManifest(ctx, &emptypb.Empty{})
+		if err := rollouts.Begin(ctx, manifest); err != nil {
+			lg.With(
+				zap.Error(err),
+			).Panic("failed to start plugin rollout")
+		}
+		go rollouts.Run(ctx, agentPlugins)
+	}
+	streamInterceptors = append(streamInterceptors, NewLastKnownDetailsApplier(storageBackend))
+
 	if err := syncServer.RunGarbageCollection(ctx, storageBackend); err != nil {
 		lg.With(
 			zap.Error(err),
 		).Error("failed to run garbage collection")
 	}
+	go syncServer.RunReferenceUpdates(ctx)
 
 	// set up grpc server
 	grpcServer := NewGRPCServer(&conf.Spec, lg,
 		grpc.Creds(credentials.NewTLS(tlsConfig)),
-		grpc.ChainStreamInterceptor(
-			clusterAuth.StreamServerInterceptor(),
-			syncServer.StreamServerInterceptor(),
-			NewLastKnownDetailsApplier(storageBackend),
-		),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
 	)
 
 	// set up stream server
 	listener := health.NewListener()
-	monitor := health.NewMonitor(health.WithLogger(lg.Named("monitor")))
 	sync := NewSyncRequester(lg)
-	delegate := NewDelegateServer(storageBackend, lg)
+
+	var healthUpdater health.HealthStatusUpdater = listener
+	var sessions *SessionRegistry
+	var relayServerTLSConfig *tls.Config
+	var delegateOptions []DelegateServerOption
+	if conf.Spec.HA.Enabled {
+		// share agent sessions and health status with other gateway replicas
+		replicaId, err := os.Hostname()
+		if err != nil {
+			lg.With(
+				zap.Error(err),
+			).Panic("failed to determine replica id")
+		}
+		relayAddress, err := relayAdvertiseAddress(&conf.Spec.HA)
+		if err != nil {
+			lg.With(
+				zap.Error(err),
+			).Panic("failed to determine relay advertise address")
+		}
+		var sharedUpdater *health.SharedUpdater
+		sessions = NewSessionRegistry(
+			storageBackend.KeyValueStore("gateway-sessions"),
+			storage.LockManagerFor(storageBackend, "gateway-sessions"),
+			replicaId, relayAddress, lg,
+			WithExpiredSessionHandler(func(id string) {
+				sharedUpdater.MarkDisconnected(ctx, id)
+			}),
+		)
+		sharedUpdater = health.NewSharedUpdater(listener, storageBackend.KeyValueStore("gateway-health"), sessions,
+			health.WithSharedUpdaterLogger(lg.Named("health")),
+		)
+		healthUpdater = sharedUpdater
+		go sharedUpdater.Run(ctx)
+		go sessions.Run(ctx)
+
+		var relayClientTLSConfig *tls.Config
+		relayServerTLSConfig, relayClientTLSConfig = relayTLSConfigs(tlsConfig, conf.Spec.Hostname)
+		delegateOptions = append(delegateOptions, WithRelay(sessions, relayClientTLSConfig))
+		lg.With(
+			"replica", replicaId,
+			"relayAddress", relayAddress,
+		).Info("high availability mode enabled")
+	}
+	delegate := NewDelegateServer(storageBackend, lg, delegateOptions...)
+	diagnostics := NewDiagnosticsCollector(delegate, lg)
+	keyRotator := NewKeyRotator(storageBackend, delegate, lg)
+
 	// set up agent connection handlers
-	agentHandler := MultiConnectionHandler(listener, sync, delegate)
+	agentHandler := MultiConnectionHandler(listener, sync, delegate, keyRotator)
+	var relayServer *RelayServer
+	if sessions != nil {
+		agentHandler = sessions.Wrap(agentHandler)
+		relayServer = NewRelayServer(&conf.Spec.HA, relayServerTLSConfig, delegate, lg)
+	}
 
-	go monitor.Run(ctx, listener)
+	go monitor.Run(ctx, healthUpdater)
+	staleClusters := NewStaleClusterCollector(conf.Spec.StaleClusters, storageBackend, capBackendStore, monitor, lg)
+	go staleClusters.Run(ctx)
+	healthHistory := NewHealthHistoryCollector(conf.Spec.HealthHistory, storageBackend.KeyValueStore("health-history"), storageBackend, monitor, lg)
+	httpServer.metricsRegisterer.MustRegister(healthHistory.Collectors()...)
+	go healthHistory.Run(ctx)
 	streamSvc := NewStreamServer(agentHandler, storageBackend, lg)
 
 	controlv1.RegisterHealthListenerServer(streamSvc, listener)
@@ -219,12 +360,40 @@ func NewGateway(ctx context.Context, conf *config.GatewayConfig, pl plugins.Load
 			).Error("failed to add plugin remote stream service")
 		}
 	}))
+	pl.Hook(hooks.OnUnloadM(func(_ types.StreamAPIExtensionPlugin, md meta.PluginMeta) {
+		streamSvc.RemoveRemote(md.Filename())
+	}))
+
+	// set up plugin hot reload
+	if conf.Spec.Plugins.Reload.Enabled {
+		if loader, ok := pl.(ReloadablePluginLoader); ok {
+			reloader := NewPluginReloader(conf.Spec.Plugins, plugins.GatewayScheme, loader, syncServer, rollouts, agentPlugins, sync, lg)
+			pl.Hook(hooks.OnLoadingCompleted(func(int) {
+				reloader.Run(ctx)
+			}))
+		} else {
+			lg.Warn("plugin loader does not support reloading plugins, hot reload is disabled")
+		}
+	}
 
 	// set up bootstrap server
-	bootstrapServerV1 := bootstrap.NewServer(storageBackend, pkey, capBackendStore)
-	bootstrapServerV2 := bootstrap.NewServerV2(storageBackend, pkey)
-	bootstrapv1.RegisterBootstrapServer(grpcServer, bootstrapServerV1)
-	bootstrapv2.RegisterBootstrapServer(grpcServer, bootstrapServerV2)
+	bootstrapOptions := []bootstrap.ServerOption{
+		bootstrap.WithRateLimit(
+			rate.Every(time.Minute/time.Duration(conf.Spec.Bootstrap.RequestsPerMinute)),
+			conf.Spec.Bootstrap.Burst,
+		),
+		bootstrap.WithTrustedProxies(conf.Spec.TrustedProxies...),
+	}
+	bootstrapServerV3 := bootstrap.NewServerV3(storageBackend, pkey, bootstrapOptions...)
+	bootstrapv3.RegisterBootstrapServer(grpcServer, bootstrapServerV3)
+	if conf.Spec.Bootstrap.DisableLegacyJoin {
+		lg.Info("legacy bootstrap services are disabled")
+	} else {
+		bootstrapServerV1 := bootstrap.NewServer(storageBackend, pkey, capBackendStore, bootstrapOptions...)
+		bootstrapServerV2 := bootstrap.NewServerV2(storageBackend, pkey, bootstrapOptions...)
+		bootstrapv1.RegisterBootstrapServer(grpcServer, bootstrapServerV1)
+		bootstrapv2.RegisterBootstrapServer(grpcServer, bootstrapServerV2)
+	}
 
 	g := &Gateway{
 		GatewayOptions:  options,
@@ -237,6 +406,12 @@ func NewGateway(ctx context.Context, conf *config.GatewayConfig, pl plugins.Load
 		grpcServer:      grpcServer,
 		statusQuerier:   monitor,
 		syncRequester:   sync,
+		keyRotator:      keyRotator,
+		diagnostics:     diagnostics,
+		staleClusters:   staleClusters,
+		healthHistory:   healthHistory,
+		rollouts:        rollouts,
+		relayServer:     relayServer,
 	}
 
 	waitctx.Go(ctx, func() {
@@ -300,7 +475,23 @@ func (g *Gateway) ListenAndServe(ctx context.Context) error {
 		})
 	}
 
-	return util.WaitAll(ctx, ca, e1, e2, e3)
+	// start relay server
+	e4 := make(chan error)
+	if g.relayServer != nil {
+		e4 = lo.Async(func() error {
+			err := g.relayServer.ListenAndServe(ctx)
+			if err != nil {
+				lg.With(
+					zap.Error(err),
+				).Warn("relay server exited with error")
+			}
+			return err
+		})
+	} else {
+		close(e4)
+	}
+
+	return util.WaitAll(ctx, ca, e1, e2, e3, e4)
 }
 
 // Implements management.CoreDataSource
@@ -337,6 +528,39 @@ func (g *Gateway) WatchClusterHealthStatus(ctx context.Context) <-chan *corev1.C
 	return g.statusQuerier.WatchHealthStatus(ctx)
 }
 
+// Implements management.KeyRotationDataSource
+func (g *Gateway) RotateClusterKeys(ctx context.Context, ref *corev1.Reference, gracePeriod time.Duration) error {
+	return g.keyRotator.RotateKeys(ctx, ref, gracePeriod)
+}
+
+// Implements management.DiagnosticsDataSource
+func (g *Gateway) CollectClusterDiagnostics(ctx context.Context, ref *corev1.Reference, logLines int32, w io.Writer) error {
+	return g.diagnostics.CollectDiagnostics(ctx, ref, logLines, w)
+}
+
+// Implements management.HealthHistoryDataSource
+func (g *Gateway) GetClusterHealthHistory(ctx context.Context, req *managementv1.ClusterHealthHistoryRequest) (*managementv1.ClusterHealthHistory, error) {
+	return g.healthHistory.History(ctx, req)
+}
+
+// Implements management.StaleClusterDataSource
+func (g *Gateway) GetStaleClusterReport(ctx context.Context) (*managementv1.StaleClusterReport, error) {
+	return g.staleClusters.Report(ctx)
+}
+
+// Implements management.StaleClusterDataSource
+func (g *Gateway) SetClusterDeleter(deleter func(ctx context.Context, ref *corev1.Reference) error) {
+	g.staleClusters.SetClusterDeleter(deleter)
+}
+
+// Implements management.PluginRolloutDataSource
+func (g *Gateway) GetPluginRolloutStatus(ctx context.Context) (*managementv1.PluginRolloutStatus, error) {
+	if g.rollouts == nil {
+		return nil, status.Error(codes.Unavailable, "staged plugin rollouts are not enabled")
+	}
+	return g.rollouts.Status(ctx)
+}
+
 func (g *Gateway) MustRegisterCollector(collector prometheus.Collector) {
 	g.httpServer.metricsRegisterer.MustRegister(collector)
 }
@@ -0,0 +1,256 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/health"
+	"github.com/rancher/opni/pkg/storage"
+)
+
+const (
+	flappingCheckInterval      = 1 * time.Minute
+	healthHistoryPruneInterval = 1 * time.Hour
+)
+
+// HealthHistoryCollector records changes to agents' connection status and
+// health, and marks clusters whose agents disconnect more often than the
+// configured flap threshold.
+type HealthHistoryCollector struct {
+	conf    v1beta1.HealthHistorySpec
+	history *health.History
+	storage storage.ClusterStore
+	status  health.HealthStatusQuerier
+	logger  *zap.SugaredLogger
+
+	flappingClusters prometheus.Gauge
+}
+
+func NewHealthHistoryCollector(
+	conf v1beta1.HealthHistorySpec,
+	kv storage.KeyValueStore,
+	clusterStore storage.ClusterStore,
+	status health.HealthStatusQuerier,
+	lg *zap.SugaredLogger,
+) *HealthHistoryCollector {
+	lg = lg.Named("health-history")
+	return &HealthHistoryCollector{
+		conf: conf,
+		history: health.NewHistory(kv,
+			health.WithHistoryLogger(lg),
+			health.WithRetention(days(conf.RetentionDays)),
+		),
+		storage: clusterStore,
+		status:  status,
+		logger:  lg,
+		flappingClusters: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "opni",
+			Subsystem: "gateway",
+			Name:      "flapping_clusters",
+			Help:      "Number of clusters currently marked as flapping",
+		}),
+	}
+}
+
+func (c *HealthHistoryCollector) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.flappingClusters}
+}
+
+func (c *HealthHistoryCollector) flapDetectionEnabled() bool {
+	return c.conf.FlapThreshold > 0
+}
+
+func (c *HealthHistoryCollector) flapWindow() time.Duration {
+	return time.Duration(c.conf.FlapWindowMinutes) * time.Minute
+}
+
+// Run records health status changes until the context is canceled.
+func (c *HealthHistoryCollector) Run(ctx context.Context) {
+	lastStatus := map[string]*corev1.Status{}
+	lastHealth := map[string]*corev1.Health{}
+	updates := c.status.WatchHealthStatus(ctx)
+
+	flapTicker := time.NewTicker(flappingCheckInterval)
+	defer flapTicker.Stop()
+	pruneTicker := time.NewTicker(healthHistoryPruneInterval)
+	defer pruneTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			id := update.GetCluster().GetId()
+			if st := update.GetHealthStatus().GetStatus(); st != nil {
+				if prev, ok := lastStatus[id]; !ok || prev.GetConnected() != st.GetConnected() {
+					c.recordStatus(ctx, id, st)
+				}
+				lastStatus[id] = st
+			}
+			if h := update.GetHealthStatus().GetHealth(); h != nil {
+				if prev, ok := lastHealth[id]; !ok || healthChanged(prev, h) {
+					c.record(ctx, id, health.NewHealthEvent(h))
+				}
+				lastHealth[id] = h
+			}
+		case <-flapTicker.C:
+			if !c.flapDetectionEnabled() {
+				continue
+			}
+			if err := c.updateFlapping(ctx); err != nil {
+				c.logger.With(
+					zap.Error(err),
+				).Warn("failed to update flapping clusters")
+			}
+		case <-pruneTicker.C:
+			if err := c.history.Prune(ctx); err != nil {
+				c.logger.With(
+					zap.Error(err),
+				).Warn("failed to prune health history")
+			}
+		}
+	}
+}
+
+func (c *HealthHistoryCollector) record(ctx context.Context, id string, event *managementv1.HealthEvent) {
+	if err := c.history.Record(ctx, id, event); err != nil {
+		c.logger.With(
+			zap.Error(err),
+			"cluster", id,
+			"type", event.GetType().String(),
+		).Warn("failed to record health event")
+	}
+}
+
+func (c *HealthHistoryCollector) recordStatus(ctx context.Context, id string, st *corev1.Status) {
+	c.record(ctx, id, health.NewStatusEvent(st))
+	if st.GetConnected() || !c.flapDetectionEnabled() {
+		return
+	}
+	flapping, err := c.isFlapping(ctx, id, time.Now())
+	if err != nil {
+		c.logger.With(
+			zap.Error(err),
+			"cluster", id,
+		).Warn("failed to check whether cluster is flapping")
+		return
+	}
+	if flapping {
+		if err := c.markFlapping(ctx, &corev1.Reference{Id: id}, time.Now()); err != nil {
+			c.logger.With(
+				zap.Error(err),
+				"cluster", id,
+			).Warn("failed to mark cluster as flapping")
+		}
+	}
+}
+
+func (c *HealthHistoryCollector) isFlapping(ctx context.Context, id string, now time.Time) (bool, error) {
+	count, err := c.history.Disconnects(ctx, id, now.Add(-c.flapWindow()))
+	if err != nil {
+		return false, err
+	}
+	return count > c.conf.FlapThreshold, nil
+}
+
+func (c *HealthHistoryCollector) markFlapping(ctx context.Context, ref *corev1.Reference, now time.Time) error {
+	cluster, err := c.storage.GetCluster(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if _, ok := cluster.GetLabels()[corev1.FlappingSinceLabel]; ok {
+		return nil
+	}
+	c.logger.With(
+		"cluster", ref.GetId(),
+		"threshold", c.conf.FlapThreshold,
+		"window", c.flapWindow(),
+	).Warn("cluster agent is repeatedly disconnecting and will be marked as flapping")
+	_, err = c.storage.UpdateCluster(ctx, ref, func(cluster *corev1.Cluster) {
+		if cluster.Metadata == nil {
+			cluster.Metadata = &corev1.ClusterMetadata{}
+		}
+		if cluster.Metadata.Labels == nil {
+			cluster.Metadata.Labels = map[string]string{}
+		}
+		cluster.Metadata.Labels[corev1.FlappingSinceLabel] = strconv.FormatInt(now.Unix(), 10)
+	})
+	return err
+}
+
+// updateFlapping removes the flapping label from clusters which have not
+// exceeded the flap threshold within the current window, and updates the
+// flapping cluster count.
+func (c *HealthHistoryCollector) updateFlapping(ctx context.Context) error {
+	clusters, err := c.storage.ListClusters(ctx, &corev1.LabelSelector{
+		MatchExpressions: []*corev1.LabelSelectorRequirement{
+			{
+				Key:      corev1.FlappingSinceLabel,
+				Operator: string(corev1.LabelSelectorOpExists),
+			},
+		},
+	}, corev1.MatchOptions_Default)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	flapping := 0
+	var errs []error
+	for _, cluster := range clusters.Items {
+		ok, err := c.isFlapping(ctx, cluster.Id, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", cluster.Id, err))
+			continue
+		}
+		if ok {
+			flapping++
+			continue
+		}
+		c.logger.With(
+			"cluster", cluster.Id,
+		).Info("cluster is no longer flapping")
+		if _, err := c.storage.UpdateCluster(ctx, cluster.Reference(), func(cluster *corev1.Cluster) {
+			delete(cluster.Metadata.Labels, corev1.FlappingSinceLabel)
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", cluster.Id, err))
+		}
+	}
+	c.flappingClusters.Set(float64(flapping))
+	return errors.Join(errs...)
+}
+
+// History returns the recorded health history for a cluster.
+func (c *HealthHistoryCollector) History(ctx context.Context, req *managementv1.ClusterHealthHistoryRequest) (*managementv1.ClusterHealthHistory, error) {
+	cluster, err := c.storage.GetCluster(ctx, req.GetCluster())
+	if err != nil {
+		return nil, err
+	}
+	history, err := c.history.Query(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if value, ok := cluster.GetLabels()[corev1.FlappingSinceLabel]; ok {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			history.FlappingSince = timestamppb.New(time.Unix(seconds, 0))
+		}
+	}
+	return history, nil
+}
+
+func healthChanged(prev, cur *corev1.Health) bool {
+	return prev.GetReady() != cur.GetReady() ||
+		!slices.Equal(prev.GetConditions(), cur.GetConditions())
+}
@@ -0,0 +1,124 @@
+package gateway_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/gateway"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/test"
+)
+
+type testWatchQuerier chan *corev1.ClusterHealthStatus
+
+func (q testWatchQuerier) GetHealthStatus(string) *corev1.HealthStatus {
+	return &corev1.HealthStatus{}
+}
+
+func (q testWatchQuerier) WatchHealthStatus(context.Context) <-chan *corev1.ClusterHealthStatus {
+	return q
+}
+
+var _ = Describe("Health History Collector", Label("unit"), func() {
+	var (
+		ctx       context.Context
+		clusters  storage.ClusterStore
+		updates   testWatchQuerier
+		collector *gateway.HealthHistoryCollector
+	)
+
+	sendStatus := func(id string, connected bool) {
+		updates <- &corev1.ClusterHealthStatus{
+			Cluster: &corev1.Reference{Id: id},
+			HealthStatus: &corev1.HealthStatus{
+				Status: &corev1.Status{
+					Timestamp: timestamppb.Now(),
+					Connected: connected,
+				},
+			},
+		}
+		// ensure consecutive events have distinct timestamps
+		time.Sleep(time.Millisecond)
+	}
+	history := func(id string) *managementv1.ClusterHealthHistory {
+		h, err := collector.History(ctx, &managementv1.ClusterHealthHistoryRequest{
+			Cluster: &corev1.Reference{Id: id},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return h
+	}
+
+	BeforeEach(func() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		ctrl := gomock.NewController(GinkgoT())
+		clusters = test.NewTestClusterStore(ctrl)
+		for _, id := range []string{"agent-1", "agent-2"} {
+			Expect(clusters.CreateCluster(ctx, &corev1.Cluster{Id: id})).To(Succeed())
+		}
+		updates = make(testWatchQuerier, 10)
+		collector = gateway.NewHealthHistoryCollector(v1beta1.HealthHistorySpec{
+			RetentionDays:     1,
+			FlapThreshold:     2,
+			FlapWindowMinutes: 10,
+		}, test.NewTestKeyValueStore(ctrl, slices.Clone[[]byte]), clusters, updates, test.Log)
+		go collector.Run(ctx)
+	})
+
+	It("should record connection and health changes", func() {
+		sendStatus("agent-1", true)
+		updates <- &corev1.ClusterHealthStatus{
+			Cluster: &corev1.Reference{Id: "agent-1"},
+			HealthStatus: &corev1.HealthStatus{
+				Status: &corev1.Status{Connected: true},
+				Health: &corev1.Health{
+					Timestamp:  timestamppb.Now(),
+					Conditions: []string{"test condition"},
+				},
+			},
+		}
+		Eventually(func() []*managementv1.HealthEvent {
+			return history("agent-1").Events
+		}).Should(HaveLen(2))
+
+		// unchanged status and health are not recorded again
+		sendStatus("agent-1", true)
+		sendStatus("agent-1", false)
+		Eventually(func() []*managementv1.HealthEvent {
+			return history("agent-1").Events
+		}).Should(HaveLen(3))
+		h := history("agent-1")
+		Expect(h.Disconnects).To(BeEquivalentTo(1))
+		Expect(h.FlappingSince).To(BeNil())
+		Expect(history("agent-2").Events).To(BeEmpty())
+	})
+
+	It("should mark clusters which disconnect too often as flapping", func() {
+		for i := 0; i < 3; i++ {
+			sendStatus("agent-1", true)
+			sendStatus("agent-1", false)
+		}
+		sendStatus("agent-2", true)
+		sendStatus("agent-2", false)
+		Eventually(func() map[string]string {
+			cluster, err := clusters.GetCluster(ctx, &corev1.Reference{Id: "agent-1"})
+			Expect(err).NotTo(HaveOccurred())
+			return cluster.GetLabels()
+		}).Should(HaveKey(corev1.FlappingSinceLabel))
+		Expect(history("agent-1").FlappingSince).NotTo(BeNil())
+
+		cluster, err := clusters.GetCluster(ctx, &corev1.Reference{Id: "agent-2"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster.GetLabels()).NotTo(HaveKey(corev1.FlappingSinceLabel))
+	})
+})
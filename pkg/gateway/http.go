@@ -50,6 +50,24 @@ type GatewayHTTPServer struct {
 
 	routesMu             sync.Mutex
 	reservedPrefixRoutes []string
+	// Routes cannot be removed from the router, so plugin routes are
+	// registered once and forward requests to the plugin which currently
+	// owns them. This allows plugins to be replaced while the server is
+	// running.
+	pluginRoutes map[string]*pluginRoute
+}
+
+type pluginRoute struct {
+	module  string
+	handler atomic.Pointer[gin.HandlerFunc]
+}
+
+func (r *pluginRoute) serve(c *gin.Context) {
+	if h := r.handler.Load(); h != nil {
+		(*h)(c)
+		return
+	}
+	c.AbortWithStatus(http.StatusServiceUnavailable)
 }
 
 func NewHTTPServer(
@@ -110,12 +128,16 @@ func NewHTTPServer(
 			cfg.Metrics.GetPath(),
 			"/healthz",
 		},
+		pluginRoutes: map[string]*pluginRoute{},
 	}
 
 	srv.metricsRegisterer.MustRegister(apiCollectors...)
 	pl.Hook(hooks.OnLoad(func(p types.MetricsPlugin) {
 		srv.metricsRegisterer.MustRegister(p)
 	}))
+	pl.Hook(hooks.OnUnload(func(p types.MetricsPlugin) {
+		srv.metricsRegisterer.Unregister(p)
+	}))
 
 	pl.Hook(hooks.OnLoadM(func(p types.HTTPAPIExtensionPlugin, md meta.PluginMeta) {
 		ctx, ca := context.WithTimeout(ctx, 10*time.Second)
@@ -130,6 +152,9 @@ func NewHTTPServer(
 		}
 		srv.setupPluginRoutes(cfg, md)
 	}))
+	pl.Hook(hooks.OnUnloadM(func(_ types.HTTPAPIExtensionPlugin, md meta.PluginMeta) {
+		srv.removePluginRoutes(md)
+	}))
 
 	return srv
 }
@@ -195,10 +220,41 @@ ROUTES:
 				continue ROUTES
 			}
 		}
+		key := route.Method + " " + route.Path
+		pr, ok := s.pluginRoutes[key]
+		if !ok {
+			pr = &pluginRoute{}
+			s.pluginRoutes[key] = pr
+			s.router.Handle(route.Method, route.Path, pr.serve)
+		} else if pr.module != pluginMeta.Module && pr.handler.Load() != nil {
+			s.logger.With(
+				"route", key,
+				"plugin", pluginMeta.Module,
+				"owner", pr.module,
+			).Warn("skipping route for plugin as it is already configured for another plugin")
+			continue
+		}
+		pr.module = pluginMeta.Module
+		pr.handler.Store(&forwarder)
 		s.logger.With(
-			"route", route.Method+" "+route.Path,
+			"route", key,
 			"plugin", pluginMeta.Module,
 		).Debug("configured route for plugin")
-		s.router.Handle(route.Method, route.Path, forwarder)
+	}
+}
+
+// removePluginRoutes stops forwarding requests to the given plugin. Requests
+// to its routes will fail until another plugin configures the same routes.
+func (s *GatewayHTTPServer) removePluginRoutes(pluginMeta meta.PluginMeta) {
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+	for key, pr := range s.pluginRoutes {
+		if pr.module == pluginMeta.Module {
+			pr.handler.Store(nil)
+			s.logger.With(
+				"route", key,
+				"plugin", pluginMeta.Module,
+			).Debug("removed route for plugin")
+		}
 	}
 }
@@ -0,0 +1,258 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	agentv1 "github.com/rancher/opni/pkg/agent"
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/auth/cluster"
+	"github.com/rancher/opni/pkg/ecdh"
+	"github.com/rancher/opni/pkg/keyring"
+	"github.com/rancher/opni/pkg/storage"
+)
+
+// KeyRotator negotiates new shared keys with connected agents. Requests are
+// sent through the delegate server, so that agents connected to other gateway
+// replicas can be reached through the relay.
+//
+// New keys are stored alongside the agent's previous keys until the agent is
+// known to be using them, either because it committed them, or because it
+// authenticated with them when it next connected. The previous keys then
+// expire at the end of a grace period. If the agent instead connects using its
+// previous keys, the new keys are discarded. Expired keys are removed from the
+// cluster's keyring when the agent next connects, or during the next rotation.
+type KeyRotator struct {
+	mu         sync.Mutex
+	inProgress map[string]struct{}
+	requester  AgentRequester
+	broker     storage.KeyringStoreBroker
+	logger     *zap.SugaredLogger
+}
+
+func NewKeyRotator(broker storage.KeyringStoreBroker, requester AgentRequester, lg *zap.SugaredLogger) *KeyRotator {
+	return &KeyRotator{
+		inProgress: make(map[string]struct{}),
+		requester:  requester,
+		broker:     broker,
+		logger:     lg.Named("keyrotation"),
+	}
+}
+
+func (r *KeyRotator) HandleAgentConnection(ctx context.Context, _ agentv1.ClientSet) {
+	id := cluster.StreamAuthorizedID(ctx)
+	used, _ := ctx.Value(cluster.SharedKeysKey).(*keyring.SharedKeys)
+
+	r.mu.Lock()
+	_, rotating := r.inProgress[id]
+	r.mu.Unlock()
+	if rotating {
+		// the rotation will resolve the agent's keys itself
+		return
+	}
+	if err := r.updateKeyring(ctx, &corev1.Reference{Id: id}, used, nil); err != nil {
+		r.logger.With(
+			"id", id,
+			zap.Error(err),
+		).Warn("failed to update keyring")
+	}
+}
+
+// RotateKeys performs a new ECDH key exchange with the agent identified by
+// ref, and stores the resulting keys in the cluster's keyring. The agent's
+// existing keys remain valid for the given grace period, which must be
+// positive, once the agent is known to be using the new keys.
+func (r *KeyRotator) RotateKeys(ctx context.Context, ref *corev1.Reference, gracePeriod time.Duration) error {
+	if gracePeriod <= 0 {
+		return status.Error(codes.InvalidArgument, "grace period must be positive")
+	}
+
+	r.mu.Lock()
+	if _, ok := r.inProgress[ref.GetId()]; ok {
+		r.mu.Unlock()
+		return status.Error(codes.Aborted, "a key rotation is already in progress for this cluster")
+	}
+	r.inProgress[ref.GetId()] = struct{}{}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.inProgress, ref.GetId())
+		r.mu.Unlock()
+	}()
+
+	lg := r.logger.With("id", ref.GetId())
+	client := controlv1.NewKeyRotationClient(&delegatedClientConn{
+		requester: r.requester,
+		target:    ref,
+	})
+	exchangeId := uuid.NewString()
+	ekp := ecdh.NewEphemeralKeyPair()
+	resp, err := client.ExchangeKeys(ctx, &controlv1.KeyExchangeRequest{
+		Id:              exchangeId,
+		ServerPublicKey: ekp.PublicKey,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			return status.Error(codes.NotFound, "agent is not connected")
+		case codes.Unimplemented:
+			return status.Error(codes.FailedPrecondition, "agent does not support key rotation")
+		}
+		return fmt.Errorf("key exchange failed: %w", err)
+	}
+	secret, err := ecdh.DeriveSharedSecret(ekp, ecdh.PeerPublicKey{
+		PublicKey: resp.GetClientPublicKey(),
+		PeerType:  ecdh.PeerTypeClient,
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to derive shared secret: %v", err)
+	}
+
+	store := r.broker.KeyringStore("gateway", ref)
+	current, err := store.Get(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get keyring: %v", err)
+	}
+
+	// Until the agent commits the new keys, it may be using either the new
+	// or the previous keys, so both are kept without changing their expiry.
+	// Keys which have already expired are dropped, and keys left pending by
+	// an earlier rotation are treated as previous keys.
+	now := time.Now()
+	newKeys := keyring.NewSharedKeys(secret)
+	newKeys.PendingGracePeriod = &gracePeriod
+	keys := []any{newKeys}
+	current.ForEach(func(key any) {
+		if sk, ok := key.(*keyring.SharedKeys); ok {
+			if sk.Expired(now) {
+				return
+			}
+			keys = append(keys, &keyring.SharedKeys{
+				ClientKey: sk.ClientKey,
+				ServerKey: sk.ServerKey,
+				ExpiresAt: sk.ExpiresAt,
+			})
+			return
+		}
+		keys = append(keys, key)
+	})
+	if err := store.Put(ctx, keyring.New(keys...)); err != nil {
+		return status.Errorf(codes.Internal, "failed to store keyring: %v", err)
+	}
+
+	if _, err := client.CommitKeys(ctx, &controlv1.KeyCommitRequest{
+		Id: exchangeId,
+	}); err != nil {
+		// The agent may have stored the new keys before the commit failed,
+		// so both generations are kept until it next connects.
+		lg.With(
+			zap.Error(err),
+		).Warn("failed to commit new keys on the agent; keeping the previous and new keys until the agent reconnects")
+		return fmt.Errorf("failed to commit new keys: %w", err)
+	}
+
+	confirmCtx, ca := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ca()
+	if err := r.updateKeyring(confirmCtx, ref, newKeys, newKeys); err != nil {
+		lg.With(
+			zap.Error(err),
+		).Warn("failed to expire previous keys; they will expire when the agent next connects")
+	}
+
+	lg.With(
+		"gracePeriod", gracePeriod.String(),
+	).Info("cluster keys rotated")
+	return nil
+}
+
+// updateKeyring removes expired keys from the cluster's keyring, and resolves
+// a pending key rotation given the keys the agent is known to be using. If
+// the agent is using the pending keys, the pending marker is cleared and the
+// other keys expire at the end of the rotation's grace period; otherwise, the
+// pending keys are discarded. If committed is set, those keys are kept even
+// if they are no longer in the keyring.
+func (r *KeyRotator) updateKeyring(ctx context.Context, ref *corev1.Reference, used, committed *keyring.SharedKeys) error {
+	store := r.broker.KeyringStore("gateway", ref)
+	kr, err := store.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var pending *keyring.SharedKeys
+	kr.ForEach(func(key any) {
+		if sk, ok := key.(*keyring.SharedKeys); ok && sk.PendingGracePeriod != nil && !sk.Expired(now) {
+			pending = sk
+		}
+	})
+	if committed != nil {
+		pending = committed
+	}
+	var expiresAt *time.Time
+	confirmed := pending != nil && used != nil && pending.Equal(used)
+	if confirmed {
+		t := now.Add(*pending.PendingGracePeriod)
+		expiresAt = &t
+	}
+
+	var keys []any
+	pruned, discarded := 0, 0
+	if confirmed {
+		keys = append(keys, &keyring.SharedKeys{
+			ClientKey: pending.ClientKey,
+			ServerKey: pending.ServerKey,
+		})
+	}
+	kr.ForEach(func(key any) {
+		sk, ok := key.(*keyring.SharedKeys)
+		if !ok {
+			keys = append(keys, key)
+			return
+		}
+		switch {
+		case sk.Expired(now):
+			pruned++
+		case pending != nil && sk.Equal(pending):
+			if !confirmed && used != nil {
+				discarded++
+			} else if !confirmed {
+				keys = append(keys, sk)
+			}
+		case confirmed && (sk.ExpiresAt == nil || sk.ExpiresAt.After(*expiresAt)):
+			keys = append(keys, &keyring.SharedKeys{
+				ClientKey: sk.ClientKey,
+				ServerKey: sk.ServerKey,
+				ExpiresAt: expiresAt,
+			})
+		default:
+			keys = append(keys, sk)
+		}
+	})
+	if pruned == 0 && discarded == 0 && !confirmed {
+		return nil
+	}
+
+	lg := r.logger.With("id", ref.GetId())
+	if pruned > 0 {
+		lg.With(
+			"count", pruned,
+		).Info("removing expired keys from keyring")
+	}
+	if discarded > 0 {
+		lg.Warn("agent is using its previous keys; discarding keys from an uncommitted key rotation")
+	}
+	if confirmed && committed == nil {
+		lg.With(
+			"expiresAt", expiresAt.Format(time.RFC3339),
+		).Info("agent is using keys from a pending key rotation; previous keys will expire")
+	}
+	return store.Put(ctx, keyring.New(keys...))
+}
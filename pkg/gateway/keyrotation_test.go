@@ -0,0 +1,197 @@
+package gateway_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/kralicky/totem"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	streamv1 "github.com/rancher/opni/pkg/apis/stream/v1"
+	"github.com/rancher/opni/pkg/auth/cluster"
+	"github.com/rancher/opni/pkg/ecdh"
+	"github.com/rancher/opni/pkg/gateway"
+	"github.com/rancher/opni/pkg/keyring"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/test"
+)
+
+// testKeyRotationAgent performs the agent side of a key exchange, and serves
+// delegated requests for a single agent
+type testKeyRotationAgent struct {
+	target    string
+	commitErr error
+	keys      *keyring.SharedKeys
+}
+
+func (a *testKeyRotationAgent) Request(_ context.Context, req *streamv1.DelegatedMessage) (*totem.RPC, error) {
+	if req.GetTarget().GetId() != a.target {
+		return nil, status.Error(codes.NotFound, "target not found")
+	}
+	var resp proto.Message
+	var err error
+	switch req.GetRequest().GetMethodName() {
+	case "ExchangeKeys":
+		in := &controlv1.KeyExchangeRequest{}
+		Expect(proto.Unmarshal(req.GetRequest().GetRequest(), in)).To(Succeed())
+		ekp := ecdh.NewEphemeralKeyPair()
+		secret, derr := ecdh.DeriveSharedSecret(ekp, ecdh.PeerPublicKey{
+			PublicKey: in.GetServerPublicKey(),
+			PeerType:  ecdh.PeerTypeServer,
+		})
+		Expect(derr).NotTo(HaveOccurred())
+		a.keys = keyring.NewSharedKeys(secret)
+		resp = &controlv1.KeyExchangeResponse{
+			ClientPublicKey: ekp.PublicKey,
+		}
+	case "CommitKeys":
+		resp, err = &emptypb.Empty{}, a.commitErr
+	default:
+		err = status.Error(codes.Unimplemented, "unknown method")
+	}
+	if err != nil {
+		return &totem.RPC{
+			Content: &totem.RPC_Response{
+				Response: &totem.Response{
+					StatusProto: status.Convert(err).Proto(),
+				},
+			},
+		}, nil
+	}
+	result := &totem.Response{
+		StatusProto: status.New(codes.OK, "").Proto(),
+	}
+	result.Response, err = proto.Marshal(resp)
+	Expect(err).NotTo(HaveOccurred())
+	return &totem.RPC{
+		Content: &totem.RPC_Response{
+			Response: result,
+		},
+	}, nil
+}
+
+func sharedKeys(kr keyring.Keyring) []*keyring.SharedKeys {
+	var keys []*keyring.SharedKeys
+	kr.ForEach(func(key any) {
+		if sk, ok := key.(*keyring.SharedKeys); ok {
+			keys = append(keys, sk)
+		}
+	})
+	return keys
+}
+
+var _ = Describe("Key Rotation", Label("unit"), func() {
+	var (
+		ctx      context.Context
+		store    storage.KeyringStore
+		rotator  *gateway.KeyRotator
+		agent    *testKeyRotationAgent
+		original *keyring.SharedKeys
+		ref      = &corev1.Reference{Id: "agent-1"}
+	)
+	connect := func(keys *keyring.SharedKeys) {
+		connCtx := context.WithValue(ctx, cluster.ClusterIDKey, ref.Id)
+		connCtx = context.WithValue(connCtx, cluster.SharedKeysKey, keys)
+		rotator.HandleAgentConnection(connCtx, nil)
+	}
+	BeforeEach(func() {
+		var ca context.CancelFunc
+		ctx, ca = context.WithCancel(context.Background())
+		DeferCleanup(ca)
+		ctrl := gomock.NewController(GinkgoT())
+		broker := test.NewTestKeyringStoreBroker(ctrl)
+		store = broker.KeyringStore("gateway", ref)
+		original = keyring.NewSharedKeys(make([]byte, 64))
+		Expect(store.Put(ctx, keyring.New(original))).To(Succeed())
+
+		agent = &testKeyRotationAgent{target: ref.Id}
+		rotator = gateway.NewKeyRotator(broker, agent, test.Log)
+		Expect(rotator.RotateKeys(ctx, &corev1.Reference{Id: "unknown"}, time.Minute)).
+			To(MatchError(status.Error(codes.NotFound, "agent is not connected")))
+	})
+
+	It("should keep the previous keys until the grace period ends", func() {
+		Expect(rotator.RotateKeys(ctx, ref, time.Minute)).To(Succeed())
+
+		kr, err := store.Get(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		keys := sharedKeys(kr)
+		Expect(keys).To(HaveLen(2))
+		Expect(keys[0].Equal(agent.keys)).To(BeTrue())
+		Expect(keys[0].ExpiresAt).To(BeNil())
+		Expect(keys[0].PendingGracePeriod).To(BeNil())
+		Expect(keys[1].ExpiresAt).NotTo(BeNil())
+		Expect(*keys[1].ExpiresAt).To(BeTemporally("~", time.Now().Add(time.Minute), 5*time.Second))
+	})
+
+	When("the agent fails to commit the new keys", func() {
+		BeforeEach(func() {
+			agent.commitErr = errors.New("commit failed")
+			Expect(rotator.RotateKeys(ctx, ref, time.Minute)).
+				To(MatchError(ContainSubstring("failed to commit new keys")))
+
+			kr, err := store.Get(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			keys := sharedKeys(kr)
+			Expect(keys).To(HaveLen(2))
+			Expect(keys[0].PendingGracePeriod).NotTo(BeNil())
+			Expect(keys[1].ExpiresAt).To(BeNil())
+		})
+
+		It("should expire the previous keys once the agent connects using the new keys", func() {
+			connect(agent.keys)
+
+			kr, err := store.Get(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			keys := sharedKeys(kr)
+			Expect(keys).To(HaveLen(2))
+			Expect(keys[0].Equal(agent.keys)).To(BeTrue())
+			Expect(keys[0].PendingGracePeriod).To(BeNil())
+			Expect(keys[1].Equal(original)).To(BeTrue())
+			Expect(keys[1].ExpiresAt).NotTo(BeNil())
+			Expect(*keys[1].ExpiresAt).To(BeTemporally("~", time.Now().Add(time.Minute), 5*time.Second))
+		})
+
+		It("should discard the new keys once the agent connects using the previous keys", func() {
+			connect(original)
+
+			kr, err := store.Get(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			keys := sharedKeys(kr)
+			Expect(keys).To(HaveLen(1))
+			Expect(keys[0].Equal(original)).To(BeTrue())
+			Expect(keys[0].ExpiresAt).To(BeNil())
+		})
+	})
+
+	It("should reject a grace period which is not positive", func() {
+		Expect(rotator.RotateKeys(ctx, ref, 0)).
+			To(MatchError(status.Error(codes.InvalidArgument, "grace period must be positive")))
+	})
+
+	It("should remove expired keys when the agent connects", func() {
+		expired := time.Now().Add(-time.Minute)
+		Expect(store.Put(ctx, keyring.New(
+			original,
+			&keyring.SharedKeys{
+				ClientKey: make([]byte, 64),
+				ServerKey: make([]byte, 64),
+				ExpiresAt: &expired,
+			},
+		))).To(Succeed())
+
+		connect(original)
+		kr, err := store.Get(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sharedKeys(kr)).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	streamv1 "github.com/rancher/opni/pkg/apis/stream/v1"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+)
+
+// RelayServer serves requests relayed from other gateway replicas to agents
+// connected to this replica.
+type RelayServer struct {
+	conf   *v1beta1.HASpec
+	logger *zap.SugaredLogger
+	server *grpc.Server
+}
+
+func NewRelayServer(
+	conf *v1beta1.HASpec,
+	tlsConfig *tls.Config,
+	delegate *DelegateServer,
+	lg *zap.SugaredLogger,
+) *RelayServer {
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	streamv1.RegisterRelayServer(server, delegate)
+	return &RelayServer{
+		conf:   conf,
+		logger: lg.Named("relay"),
+		server: server,
+	}
+}
+
+func (s *RelayServer) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp4", s.conf.RelayListenAddress)
+	if err != nil {
+		return err
+	}
+	s.logger.With(
+		"address", listener.Addr().String(),
+	).Info("gateway relay server starting")
+
+	errC := lo.Async(func() error {
+		return s.server.Serve(listener)
+	})
+	select {
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	case err := <-errC:
+		return err
+	}
+}
+
+// relayTLSConfigs returns the server and client TLS configs used between
+// replicas. All replicas share the gateway's serving certificate, so clients
+// present it as their client certificate and the server only accepts peers
+// holding the same certificate.
+func relayTLSConfigs(tlsConfig *tls.Config, hostname string) (server *tls.Config, client *tls.Config) {
+	cert := tlsConfig.Certificates[0]
+	server = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], cert.Certificate[0]) {
+				return errors.New("peer is not a gateway replica")
+			}
+			return nil
+		},
+	}
+	client = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      tlsConfig.RootCAs,
+		ServerName:   hostname,
+	}
+	return
+}
+
+// relayAdvertiseAddress returns the address other replicas should use to
+// reach this replica's relay server.
+func relayAdvertiseAddress(conf *v1beta1.HASpec) (string, error) {
+	if conf.AdvertiseAddress != "" {
+		return conf.AdvertiseAddress, nil
+	}
+	_, port, err := net.SplitHostPort(conf.RelayListenAddress)
+	if err != nil {
+		return "", err
+	}
+	host := os.Getenv("POD_IP")
+	if host == "" {
+		host, err = os.Hostname()
+		if err != nil {
+			return "", err
+		}
+	}
+	return net.JoinHostPort(host, port), nil
+}
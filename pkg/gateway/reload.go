@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	capabilityv1 "github.com/rancher/opni/pkg/apis/capability/v1"
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/patch"
+	"github.com/rancher/opni/pkg/plugins"
+	"github.com/rancher/opni/pkg/plugins/meta"
+)
+
+// ReloadablePluginLoader is implemented by plugin loaders which can reload
+// plugins while the gateway is running.
+type ReloadablePluginLoader interface {
+	Reload(ctx context.Context, conf v1beta1.PluginsSpec, scheme meta.Scheme, opts ...plugins.LoadOption) plugins.ReloadResult
+}
+
+// ReloadableManifestSource is implemented by plugin sync servers which can
+// reload the manifest of plugins synced to agents.
+type ReloadableManifestSource interface {
+	ReloadManifest() (*controlv1.PluginManifest, error)
+}
+
+type fileFingerprint struct {
+	size    int64
+	modTime int64
+}
+
+// PluginReloader watches the plugin directory, and reloads plugins when they
+// are added, updated, or removed. Changes are only applied once the contents
+// of the directory have stopped changing between two consecutive polls, so
+// that partially copied binaries are not loaded. After plugins are reloaded,
+// agents whose plugins are outdated are disconnected so that they sync the
+// new plugins (subject to any staged rollout), and all agents are requested
+// to sync their capabilities.
+type PluginReloader struct {
+	conf      v1beta1.PluginsSpec
+	scheme    meta.Scheme
+	loader    ReloadablePluginLoader
+	manifests ReloadableManifestSource
+	rollouts  *PluginRolloutController
+	agents    *AgentPluginEnforcer
+	syncer    capabilityv1.NodeManagerServer
+	logger    *zap.SugaredLogger
+
+	applied map[string]fileFingerprint
+	pending map[string]fileFingerprint
+}
+
+func NewPluginReloader(
+	conf v1beta1.PluginsSpec,
+	scheme meta.Scheme,
+	loader ReloadablePluginLoader,
+	manifests ReloadableManifestSource,
+	rollouts *PluginRolloutController,
+	agents *AgentPluginEnforcer,
+	syncer capabilityv1.NodeManagerServer,
+	lg *zap.SugaredLogger,
+) *PluginReloader {
+	r := &PluginReloader{
+		conf:      conf,
+		scheme:    scheme,
+		loader:    loader,
+		manifests: manifests,
+		rollouts:  rollouts,
+		agents:    agents,
+		syncer:    syncer,
+		logger:    lg.Named("reload"),
+	}
+	r.applied = r.scan()
+	return r
+}
+
+// Run polls the plugin directory until the context is canceled.
+func (r *PluginReloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(r.conf.Reload.PollIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.Poll(ctx)
+	}
+}
+
+// Poll checks the plugin directory for changes, and reloads plugins if the
+// directory has changed and the changes are complete. Returns true if plugins
+// were reloaded.
+func (r *PluginReloader) Poll(ctx context.Context) bool {
+	current := r.scan()
+	switch {
+	case maps.Equal(current, r.applied):
+		r.pending = nil
+		return false
+	case r.pending == nil || !maps.Equal(current, r.pending):
+		r.logger.Debug("plugin directory changed, waiting for changes to complete")
+		r.pending = current
+		return false
+	}
+	r.applied, r.pending = current, nil
+	if err := r.Reload(ctx); err != nil {
+		r.logger.With(
+			zap.Error(err),
+		).Error("failed to reload plugins")
+	}
+	return true
+}
+
+// Reload reloads any changed plugins, then updates agents.
+func (r *PluginReloader) Reload(ctx context.Context) error {
+	result := r.loader.Reload(ctx, r.conf, r.scheme,
+		plugins.WithDrainTimeout(time.Duration(r.conf.Reload.DrainTimeoutSeconds)*time.Second),
+	)
+	r.logger.With(
+		"loaded", len(result.Loaded),
+		"reloaded", len(result.Reloaded),
+		"unloaded", len(result.Unloaded),
+		"failed", len(result.Failed),
+	).Info("reloaded plugins")
+
+	manifest, err := r.manifests.ReloadManifest()
+	if err != nil {
+		return err
+	}
+	if r.rollouts != nil {
+		if err := r.rollouts.Begin(ctx, manifest); err != nil {
+			return err
+		}
+	}
+	if n := r.agents.DisconnectOutdated(); n > 0 {
+		r.logger.With(
+			"agents", n,
+		).Info("disconnected agents with outdated plugins")
+	}
+	if !result.Changed() {
+		return nil
+	}
+	// capability backends may have been replaced; sync all connected agents
+	if _, err := r.syncer.RequestSync(ctx, &capabilityv1.SyncRequest{}); err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	return nil
+}
+
+func (r *PluginReloader) scan() map[string]fileFingerprint {
+	paths, err := filepath.Glob(filepath.Join(r.conf.Dir, plugins.DefaultPluginGlob))
+	if err != nil {
+		panic(err)
+	}
+	paths = append(paths, filepath.Join(r.conf.Dir, patch.SignaturesFilename))
+	fingerprints := make(map[string]fileFingerprint, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		fingerprints[path] = fileFingerprint{
+			size:    info.Size(),
+			modTime: info.ModTime().UnixNano(),
+		}
+	}
+	return fingerprints
+}
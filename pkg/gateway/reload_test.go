@@ -0,0 +1,129 @@
+package gateway_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	capabilityv1 "github.com/rancher/opni/pkg/apis/capability/v1"
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/gateway"
+	"github.com/rancher/opni/pkg/plugins"
+	"github.com/rancher/opni/pkg/plugins/meta"
+	"github.com/rancher/opni/pkg/test"
+)
+
+type testReloadableLoader struct {
+	reloads int
+	result  plugins.ReloadResult
+}
+
+func (l *testReloadableLoader) Reload(context.Context, v1beta1.PluginsSpec, meta.Scheme, ...plugins.LoadOption) plugins.ReloadResult {
+	l.reloads++
+	return l.result
+}
+
+type testManifestSource struct {
+	reloads int
+}
+
+func (s *testManifestSource) ReloadManifest() (*controlv1.PluginManifest, error) {
+	s.reloads++
+	return &controlv1.PluginManifest{}, nil
+}
+
+type testNodeManager struct {
+	capabilityv1.UnsafeNodeManagerServer
+	syncs int
+}
+
+func (m *testNodeManager) RequestSync(context.Context, *capabilityv1.SyncRequest) (*emptypb.Empty, error) {
+	m.syncs++
+	return &emptypb.Empty{}, nil
+}
+
+var _ = Describe("Plugin Reloader", Label("unit"), func() {
+	var (
+		dir       string
+		loader    *testReloadableLoader
+		manifests *testManifestSource
+		syncer    *testNodeManager
+		reloader  *gateway.PluginReloader
+	)
+	writePlugin := func(name string, contents string) {
+		Expect(os.WriteFile(filepath.Join(dir, name), []byte(contents), 0755)).To(Succeed())
+	}
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		writePlugin("plugin_example", "v1")
+		loader = &testReloadableLoader{
+			result: plugins.ReloadResult{
+				Reloaded: []meta.PluginMeta{{Module: "github.com/rancher/opni/plugins/example"}},
+			},
+		}
+		manifests = &testManifestSource{}
+		syncer = &testNodeManager{}
+		conf := v1beta1.PluginsSpec{
+			Dir: dir,
+		}
+		conf.Reload.DrainTimeoutSeconds = 1
+		reloader = gateway.NewPluginReloader(conf, plugins.GatewayScheme, loader, manifests, nil,
+			gateway.NewAgentPluginEnforcer(func(string) *controlv1.PluginManifest { return nil }, test.Log),
+			syncer, test.Log)
+	})
+
+	It("should not reload plugins if the plugin directory has not changed", func() {
+		Expect(reloader.Poll(context.Background())).To(BeFalse())
+		Expect(reloader.Poll(context.Background())).To(BeFalse())
+		Expect(loader.reloads).To(BeZero())
+		Expect(manifests.reloads).To(BeZero())
+	})
+
+	It("should wait for changes to complete before reloading plugins", func() {
+		writePlugin("plugin_example", "v2-partial")
+		Expect(reloader.Poll(context.Background())).To(BeFalse())
+		writePlugin("plugin_example", "v2-complete")
+		Expect(reloader.Poll(context.Background())).To(BeFalse())
+		Expect(loader.reloads).To(BeZero())
+
+		Expect(reloader.Poll(context.Background())).To(BeTrue())
+		Expect(loader.reloads).To(Equal(1))
+		Expect(manifests.reloads).To(Equal(1))
+		Expect(syncer.syncs).To(Equal(1))
+
+		Expect(reloader.Poll(context.Background())).To(BeFalse())
+		Expect(loader.reloads).To(Equal(1))
+	})
+
+	It("should reload plugins when they are added or removed", func() {
+		writePlugin("plugin_new", "v1")
+		Expect(reloader.Poll(context.Background())).To(BeFalse())
+		Expect(reloader.Poll(context.Background())).To(BeTrue())
+
+		Expect(os.Remove(filepath.Join(dir, "plugin_new"))).To(Succeed())
+		Expect(reloader.Poll(context.Background())).To(BeFalse())
+		Expect(reloader.Poll(context.Background())).To(BeTrue())
+		Expect(loader.reloads).To(Equal(2))
+	})
+
+	It("should not reload plugins if unrelated files change", func() {
+		writePlugin("README", "not a plugin")
+		Expect(reloader.Poll(context.Background())).To(BeFalse())
+		Expect(reloader.Poll(context.Background())).To(BeFalse())
+		Expect(loader.reloads).To(BeZero())
+	})
+
+	It("should not request a sync if no plugins were changed", func() {
+		loader.result = plugins.ReloadResult{}
+		writePlugin("plugin_example", "v2")
+		Expect(reloader.Poll(context.Background())).To(BeFalse())
+		Expect(reloader.Poll(context.Background())).To(BeTrue())
+		Expect(manifests.reloads).To(Equal(1))
+		Expect(syncer.syncs).To(BeZero())
+	})
+})
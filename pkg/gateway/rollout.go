@@ -0,0 +1,535 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/auth/cluster"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/health"
+	"github.com/rancher/opni/pkg/patch"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/util"
+)
+
+const (
+	rolloutStatusKey      = "status"
+	rolloutManifestPrefix = "manifests"
+	rolloutPollInterval   = 10 * time.Second
+)
+
+// PluginRolloutController rolls out updated agent plugins in waves. When the
+// gateway starts with plugins that differ from the last plugins which were
+// fully rolled out, clusters are assigned to a canary wave and a number of
+// percentage-based waves. Clusters are only synced the updated plugins once
+// their wave has started. After each wave, the health of all updated clusters
+// is checked; if too many clusters which were healthy before being updated
+// have become unhealthy, the rollout is rolled back and all clusters are
+// synced the previous plugins.
+//
+// The rollout state is kept in a key-value store shared by all gateway
+// replicas. Agents connected to any replica whose plugins do not match their
+// cluster's desired plugins are disconnected, which causes them to restart
+// and sync their plugins again.
+type PluginRolloutController struct {
+	conf     v1beta1.RolloutSpec
+	store    storage.KeyValueStore
+	clusters storage.ClusterStore
+	locks    storage.LockManager
+	status   health.HealthStatusQuerier
+	logger   *zap.SugaredLogger
+
+	mu        sync.RWMutex
+	state     *managementv1.PluginRolloutStatus
+	manifests map[string]*controlv1.PluginManifest
+}
+
+var _ patch.ManifestSelector = (*PluginRolloutController)(nil)
+
+func NewPluginRolloutController(
+	conf v1beta1.RolloutSpec,
+	store storage.KeyValueStore,
+	clusters storage.ClusterStore,
+	locks storage.LockManager,
+	status health.HealthStatusQuerier,
+	lg *zap.SugaredLogger,
+) *PluginRolloutController {
+	return &PluginRolloutController{
+		conf:      conf,
+		store:     store,
+		clusters:  clusters,
+		locks:     locks,
+		status:    status,
+		logger:    lg.Named("rollout"),
+		manifests: make(map[string]*controlv1.PluginManifest),
+	}
+}
+
+// Begin starts a new rollout if the given manifest (the gateway's own
+// plugins) differs from the plugins in the current rollout. If a rollout of
+// the same plugins is already in progress, it is resumed.
+func (c *PluginRolloutController) Begin(ctx context.Context, manifest *controlv1.PluginManifest) error {
+	return c.exclusive(ctx, func() error {
+		if err := c.refresh(ctx); err != nil {
+			return err
+		}
+		digest := manifest.Digest()
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		state := c.state
+		switch {
+		case state == nil:
+			// nothing has been rolled out yet, so there is nothing to compare
+			// the gateway's plugins against
+			c.logger.With(
+				"digest", digest,
+			).Info("recording initial plugin manifest")
+			return c.save(ctx, &managementv1.PluginRolloutStatus{
+				Phase:        managementv1.PluginRolloutPhase_RolloutNone,
+				StableDigest: digest,
+				TargetDigest: digest,
+			}, manifest)
+		case digest == state.TargetDigest, digest == state.StableDigest:
+			// either a rollout of these plugins has already been started, or
+			// another replica is rolling out newer plugins
+			return nil
+		}
+
+		stable := state.StableDigest
+		if state.Phase == managementv1.PluginRolloutPhase_RolloutCompleted {
+			stable = state.TargetDigest
+		}
+		waves, err := c.planWaves(ctx)
+		if err != nil {
+			return err
+		}
+		now := timestamppb.Now()
+		next := &managementv1.PluginRolloutStatus{
+			Phase:        managementv1.PluginRolloutPhase_RolloutInProgress,
+			StableDigest: stable,
+			TargetDigest: digest,
+			Waves:        waves,
+			StartTime:    now,
+			Message:      "rollout started",
+		}
+		if len(waves) == 0 {
+			next.Phase = managementv1.PluginRolloutPhase_RolloutCompleted
+			next.Message = "no clusters to update"
+		} else {
+			c.startWave(waves[0], now)
+		}
+		c.logger.With(
+			"stable", stable,
+			"target", digest,
+			"waves", len(waves),
+		).Info("starting plugin rollout")
+		return c.save(ctx, next, manifest)
+	})
+}
+
+// Advance checks the health of updated clusters if the current wave has been
+// running for long enough, and starts the next wave or rolls back the rollout
+// accordingly.
+func (c *PluginRolloutController) Advance(ctx context.Context) error {
+	return c.exclusive(ctx, func() error {
+		if err := c.refresh(ctx); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.state.GetPhase() != managementv1.PluginRolloutPhase_RolloutInProgress {
+			return nil
+		}
+		next := proto.Clone(c.state).(*managementv1.PluginRolloutStatus)
+		current := next.Waves[next.CurrentWave]
+		now := time.Now()
+		if now.Before(current.StartTime.AsTime().Add(time.Duration(c.conf.WaveIntervalMinutes) * time.Minute)) {
+			return nil
+		}
+
+		// check all clusters updated so far, not only those in the current wave
+		var healthy, degraded int
+		for _, wave := range next.Waves[:next.CurrentWave+1] {
+			wave.Degraded = nil
+			for _, ref := range wave.HealthyAtStart {
+				if !c.isHealthy(ref.GetId()) {
+					wave.Degraded = append(wave.Degraded, ref)
+				}
+			}
+			healthy += len(wave.HealthyAtStart)
+			degraded += len(wave.Degraded)
+		}
+		if degraded > 0 && degraded*100 > c.conf.MaxUnhealthyPercent*healthy {
+			next.Phase = managementv1.PluginRolloutPhase_RolloutRolledBack
+			next.Message = fmt.Sprintf("health check failed after wave %d: %d of %d updated clusters became unhealthy",
+				next.CurrentWave, degraded, healthy)
+			c.logger.With(
+				"target", next.TargetDigest,
+				"degraded", degraded,
+			).Warn("plugin rollout failed health check, rolling back")
+			return c.save(ctx, next)
+		}
+
+		current.CompletionTime = timestamppb.New(now)
+		if int(next.CurrentWave) == len(next.Waves)-1 {
+			next.Phase = managementv1.PluginRolloutPhase_RolloutCompleted
+			next.Message = "all clusters updated"
+			c.logger.With(
+				"target", next.TargetDigest,
+			).Info("plugin rollout completed")
+			return c.save(ctx, next)
+		}
+		next.CurrentWave++
+		c.startWave(next.Waves[next.CurrentWave], timestamppb.New(now))
+		next.Message = fmt.Sprintf("wave %d started", next.CurrentWave)
+		c.logger.With(
+			"target", next.TargetDigest,
+			"wave", next.CurrentWave,
+		).Info("starting next plugin rollout wave")
+		return c.save(ctx, next)
+	})
+}
+
+// Run advances the rollout and disconnects agents with outdated plugins
+// until the context is canceled.
+func (c *PluginRolloutController) Run(ctx context.Context, agents *AgentPluginEnforcer) {
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := c.Advance(ctx); err != nil {
+			c.logger.With(
+				zap.Error(err),
+			).Warn("failed to advance plugin rollout")
+		}
+		agents.DisconnectOutdated()
+	}
+}
+
+// Status returns the status of the current rollout.
+func (c *PluginRolloutController) Status(ctx context.Context) (*managementv1.PluginRolloutStatus, error) {
+	if err := c.refresh(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.state == nil {
+		return &managementv1.PluginRolloutStatus{}, nil
+	}
+	return util.ProtoClone(c.state), nil
+}
+
+// DesiredManifest implements patch.ManifestSelector.
+func (c *PluginRolloutController) DesiredManifest(id string) *controlv1.PluginManifest {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.manifests[c.desiredDigestLocked(id)]
+}
+
+// Manifests implements patch.ManifestSelector.
+func (c *PluginRolloutController) Manifests() []*controlv1.PluginManifest {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var manifests []*controlv1.PluginManifest
+	for _, digest := range lo.Uniq([]string{c.state.GetStableDigest(), c.state.GetTargetDigest()}) {
+		if m, ok := c.manifests[digest]; ok {
+			manifests = append(manifests, m)
+		}
+	}
+	return manifests
+}
+
+func (c *PluginRolloutController) desiredDigestLocked(id string) string {
+	state := c.state
+	switch state.GetPhase() {
+	case managementv1.PluginRolloutPhase_RolloutInProgress:
+		// clusters created after the rollout started were not assigned to a
+		// wave, and are updated along with the final wave
+		wave := len(state.Waves) - 1
+		for i, w := range state.Waves {
+			if slices.ContainsFunc(w.Clusters, func(ref *corev1.Reference) bool {
+				return ref.GetId() == id
+			}) {
+				wave = i
+				break
+			}
+		}
+		if wave <= int(state.CurrentWave) {
+			return state.TargetDigest
+		}
+		return state.StableDigest
+	case managementv1.PluginRolloutPhase_RolloutCompleted:
+		return state.TargetDigest
+	default:
+		return state.GetStableDigest()
+	}
+}
+
+// planWaves assigns all existing clusters to waves. Clusters matching the
+// canary labels are placed in the first wave, and the remaining clusters are
+// divided into waves according to the configured percentages. Clusters
+// created later are treated as part of the final wave.
+func (c *PluginRolloutController) planWaves(ctx context.Context) ([]*managementv1.PluginRolloutWave, error) {
+	clusters, err := c.clusters.ListClusters(ctx, nil, corev1.MatchOptions_Default)
+	if err != nil {
+		return nil, err
+	}
+	var canary, rest []*corev1.Reference
+	for _, cl := range clusters.Items {
+		if len(c.conf.CanaryLabels) > 0 && matchesAll(cl.GetLabels(), c.conf.CanaryLabels) {
+			canary = append(canary, cl.Reference())
+		} else {
+			rest = append(rest, cl.Reference())
+		}
+	}
+	slices.SortFunc(rest, func(a, b *corev1.Reference) bool {
+		return a.GetId() < b.GetId()
+	})
+
+	var waves []*managementv1.PluginRolloutWave
+	if len(canary) > 0 {
+		waves = append(waves, &managementv1.PluginRolloutWave{
+			Canary:   true,
+			Clusters: canary,
+		})
+	}
+	prev := 0
+	for _, percent := range c.conf.Waves {
+		end := (len(rest)*percent + 99) / 100
+		if end > len(rest) {
+			end = len(rest)
+		}
+		if end <= prev {
+			continue
+		}
+		waves = append(waves, &managementv1.PluginRolloutWave{
+			Clusters: rest[prev:end],
+		})
+		prev = end
+	}
+	if prev < len(rest) {
+		waves = append(waves, &managementv1.PluginRolloutWave{
+			Clusters: rest[prev:],
+		})
+	}
+	return waves, nil
+}
+
+// startWave records the start time of the wave, and which of its clusters
+// are currently healthy.
+func (c *PluginRolloutController) startWave(wave *managementv1.PluginRolloutWave, now *timestamppb.Timestamp) {
+	wave.StartTime = now
+	wave.HealthyAtStart = nil
+	for _, ref := range wave.Clusters {
+		if c.isHealthy(ref.GetId()) {
+			wave.HealthyAtStart = append(wave.HealthyAtStart, ref)
+		}
+	}
+}
+
+func (c *PluginRolloutController) isHealthy(id string) bool {
+	hs := c.status.GetHealthStatus(id)
+	return hs.GetStatus().GetConnected() &&
+		hs.GetHealth().GetReady() &&
+		len(hs.GetHealth().GetConditions()) == 0
+}
+
+// exclusive runs fn while holding a lock shared by all gateway replicas.
+func (c *PluginRolloutController) exclusive(ctx context.Context, fn func() error) error {
+	lock := c.locks.Locker("plugin-rollout")
+	if err := lock.Lock(ctx); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	return fn()
+}
+
+// refresh loads the rollout state and any manifests it references from the
+// store, picking up changes made by other replicas.
+func (c *PluginRolloutController) refresh(ctx context.Context) error {
+	data, err := c.store.Get(ctx, rolloutStatusKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	state := &managementv1.PluginRolloutStatus{}
+	if err := proto.Unmarshal(data, state); err != nil {
+		return err
+	}
+	manifests := map[string]*controlv1.PluginManifest{}
+	for _, digest := range lo.Uniq([]string{state.StableDigest, state.TargetDigest}) {
+		c.mu.RLock()
+		m, ok := c.manifests[digest]
+		c.mu.RUnlock()
+		if !ok {
+			data, err := c.store.Get(ctx, path.Join(rolloutManifestPrefix, digest))
+			if err != nil {
+				return fmt.Errorf("failed to load plugin manifest %s: %w", digest, err)
+			}
+			m = &controlv1.PluginManifest{}
+			if err := proto.Unmarshal(data, m); err != nil {
+				return err
+			}
+			m.Sort()
+		}
+		manifests[digest] = m
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+	c.manifests = manifests
+	return nil
+}
+
+// save stores the rollout state, along with any new manifests it references.
+// The caller must hold c.mu.
+func (c *PluginRolloutController) save(ctx context.Context, state *managementv1.PluginRolloutStatus, newManifests ...*controlv1.PluginManifest) error {
+	manifests := map[string]*controlv1.PluginManifest{}
+	for _, m := range newManifests {
+		data, err := proto.Marshal(m)
+		if err != nil {
+			return err
+		}
+		digest := m.Digest()
+		if err := c.store.Put(ctx, path.Join(rolloutManifestPrefix, digest), data); err != nil {
+			return err
+		}
+		manifests[digest] = m
+	}
+	data, err := proto.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := c.store.Put(ctx, rolloutStatusKey, data); err != nil {
+		return err
+	}
+	for _, digest := range lo.Uniq([]string{state.StableDigest, state.TargetDigest}) {
+		if m, ok := c.manifests[digest]; ok {
+			manifests[digest] = m
+		}
+	}
+	c.state = state
+	c.manifests = manifests
+	return nil
+}
+
+func matchesAll(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// AgentPluginEnforcer tracks connected agents which report the digest of
+// their plugins, and disconnects those whose plugins no longer match their
+// desired plugins. When they reconnect, they will be asked to sync their
+// plugins. Agents are checked when plugins are reloaded, and whenever a
+// rollout changes the plugins desired for their cluster.
+type AgentPluginEnforcer struct {
+	desired func(id string) *controlv1.PluginManifest
+	logger  *zap.SugaredLogger
+
+	mu    sync.Mutex
+	conns map[string]*agentConn
+}
+
+type agentConn struct {
+	// the ID passed to the desired manifest lookup
+	selectorId string
+	digest     string
+	cancel     context.CancelFunc
+}
+
+// NewAgentPluginEnforcer returns an enforcer which uses the given function to
+// look up the plugins an agent should have. Agents which do not identify
+// themselves using the controlv1.AgentIDKey metadata key are looked up with
+// an empty ID.
+func NewAgentPluginEnforcer(desired func(id string) *controlv1.PluginManifest, lg *zap.SugaredLogger) *AgentPluginEnforcer {
+	return &AgentPluginEnforcer{
+		desired: desired,
+		logger:  lg,
+		conns:   make(map[string]*agentConn),
+	}
+}
+
+// StreamServerInterceptor tracks connected agents. It must be installed after
+// the cluster auth middleware.
+func (e *AgentPluginEnforcer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, ok := metadata.FromIncomingContext(ss.Context())
+		if !ok || len(md.Get(controlv1.ManifestDigestKey)) == 0 {
+			return handler(srv, ss)
+		}
+		id := cluster.StreamAuthorizedID(ss.Context())
+		ctx, ca := context.WithCancel(ss.Context())
+		defer ca()
+		conn := &agentConn{
+			digest: md.Get(controlv1.ManifestDigestKey)[0],
+			cancel: ca,
+		}
+		if len(md.Get(controlv1.AgentIDKey)) > 0 {
+			conn.selectorId = id
+		}
+		e.mu.Lock()
+		e.conns[id] = conn
+		e.mu.Unlock()
+		defer func() {
+			e.mu.Lock()
+			if e.conns[id] == conn {
+				delete(e.conns, id)
+			}
+			e.mu.Unlock()
+		}()
+		return handler(srv, &util.ServerStreamWithContext{
+			Stream: ss,
+			Ctx:    ctx,
+		})
+	}
+}
+
+// DisconnectOutdated disconnects agents whose plugins do not match their
+// desired plugins. Returns the number of agents disconnected.
+func (e *AgentPluginEnforcer) DisconnectOutdated() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var count int
+	for id, conn := range e.conns {
+		desired := e.desired(conn.selectorId)
+		if desired == nil || desired.Digest() == conn.digest {
+			continue
+		}
+		e.logger.With(
+			"id", id,
+			"desired", desired.Digest(),
+		).Info("disconnecting agent to update its plugins")
+		conn.cancel()
+		delete(e.conns, id)
+		count++
+	}
+	return count
+}
@@ -0,0 +1,295 @@
+package gateway_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/exp/slices"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/auth/cluster"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/gateway"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/test"
+)
+
+type testHealthQuerier map[string]bool
+
+func (q testHealthQuerier) GetHealthStatus(id string) *corev1.HealthStatus {
+	return &corev1.HealthStatus{
+		Status: &corev1.Status{
+			Connected: q[id],
+		},
+		Health: &corev1.Health{
+			Ready: q[id],
+		},
+	}
+}
+
+func (q testHealthQuerier) WatchHealthStatus(context.Context) <-chan *corev1.ClusterHealthStatus {
+	return nil
+}
+
+var _ = Describe("Plugin Rollout Controller", Label("unit"), func() {
+	var (
+		ctx      context.Context
+		kv       storage.KeyValueStore
+		clusters storage.ClusterStore
+		locks    storage.LockManager
+		healthy  testHealthQuerier
+		conf     v1beta1.RolloutSpec
+	)
+
+	manifest := func(digest string) *controlv1.PluginManifest {
+		return &controlv1.PluginManifest{
+			Items: []*controlv1.PluginManifestEntry{
+				{
+					Module:   "github.com/rancher/opni/plugins/example",
+					Filename: "plugin_example",
+					Digest:   digest,
+				},
+			},
+		}
+	}
+	newController := func() *gateway.PluginRolloutController {
+		return gateway.NewPluginRolloutController(conf, kv, clusters, locks, healthy, test.Log)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		ctrl := gomock.NewController(GinkgoT())
+		kv = test.NewTestKeyValueStore(ctrl, slices.Clone[[]byte])
+		clusters = test.NewTestClusterStore(ctrl)
+		locks = storage.NewInMemoryLockManager()
+		healthy = testHealthQuerier{}
+		conf = v1beta1.RolloutSpec{
+			Enabled:             true,
+			CanaryLabels:        map[string]string{"canary": "true"},
+			Waves:               []int{50, 100},
+			WaveIntervalMinutes: 0,
+			MaxUnhealthyPercent: 0,
+		}
+		for i := 0; i < 5; i++ {
+			id := fmt.Sprintf("cluster-%d", i)
+			labels := map[string]string{}
+			if i == 0 {
+				labels["canary"] = "true"
+			}
+			Expect(clusters.CreateCluster(ctx, &corev1.Cluster{
+				Id:       id,
+				Metadata: &corev1.ClusterMetadata{Labels: labels},
+			})).To(Succeed())
+			healthy[id] = true
+		}
+	})
+
+	It("should record the initial manifest as stable", func() {
+		c := newController()
+		stable := manifest("a")
+		Expect(c.Begin(ctx, stable)).To(Succeed())
+
+		status, err := c.Status(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Phase).To(Equal(managementv1.PluginRolloutPhase_RolloutNone))
+		Expect(status.StableDigest).To(Equal(stable.Digest()))
+		Expect(c.DesiredManifest("cluster-1").Digest()).To(Equal(stable.Digest()))
+	})
+
+	When("the gateway's plugins change", func() {
+		var c *gateway.PluginRolloutController
+		var stable, target *controlv1.PluginManifest
+		BeforeEach(func() {
+			stable, target = manifest("a"), manifest("b")
+			c = newController()
+			Expect(c.Begin(ctx, stable)).To(Succeed())
+		})
+
+		It("should start with the canary wave", func() {
+			Expect(c.Begin(ctx, target)).To(Succeed())
+
+			status, err := c.Status(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Phase).To(Equal(managementv1.PluginRolloutPhase_RolloutInProgress))
+			Expect(status.TargetDigest).To(Equal(target.Digest()))
+			Expect(status.Waves).To(HaveLen(3))
+			Expect(status.Waves[0].Canary).To(BeTrue())
+			Expect(status.Waves[0].Clusters).To(ConsistOf(&corev1.Reference{Id: "cluster-0"}))
+			Expect(status.Waves[1].Clusters).To(HaveLen(2))
+			Expect(status.Waves[2].Clusters).To(HaveLen(2))
+
+			Expect(c.DesiredManifest("cluster-0").Digest()).To(Equal(target.Digest()))
+			Expect(c.DesiredManifest("cluster-1").Digest()).To(Equal(stable.Digest()))
+			Expect(c.Manifests()).To(HaveLen(2))
+		})
+
+		It("should not restart a rollout which is already in progress", func() {
+			Expect(c.Begin(ctx, target)).To(Succeed())
+			before, err := c.Status(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			// a second replica with the same plugins
+			Expect(newController().Begin(ctx, target)).To(Succeed())
+			after, err := c.Status(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(after.StartTime.AsTime()).To(Equal(before.StartTime.AsTime()))
+		})
+
+		It("should update all clusters if they remain healthy", func() {
+			Expect(c.Begin(ctx, target)).To(Succeed())
+			for i := 0; i < 3; i++ {
+				Expect(c.Advance(ctx)).To(Succeed())
+			}
+			status, err := c.Status(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Phase).To(Equal(managementv1.PluginRolloutPhase_RolloutCompleted))
+			for _, wave := range status.Waves {
+				Expect(wave.CompletionTime).NotTo(BeNil())
+			}
+			for i := 0; i < 5; i++ {
+				Expect(c.DesiredManifest(fmt.Sprintf("cluster-%d", i)).Digest()).To(Equal(target.Digest()))
+			}
+
+			By("using the rolled out plugins as the stable plugins for the next rollout")
+			next := manifest("c")
+			Expect(c.Begin(ctx, next)).To(Succeed())
+			status, err = c.Status(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.StableDigest).To(Equal(target.Digest()))
+			Expect(c.DesiredManifest("cluster-1").Digest()).To(Equal(target.Digest()))
+		})
+
+		It("should roll back if updated clusters become unhealthy", func() {
+			Expect(c.Begin(ctx, target)).To(Succeed())
+			healthy["cluster-0"] = false
+			Expect(c.Advance(ctx)).To(Succeed())
+
+			status, err := c.Status(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Phase).To(Equal(managementv1.PluginRolloutPhase_RolloutRolledBack))
+			Expect(status.Waves[0].Degraded).To(ConsistOf(&corev1.Reference{Id: "cluster-0"}))
+			Expect(c.DesiredManifest("cluster-0").Digest()).To(Equal(stable.Digest()))
+
+			By("not retrying the failed plugins after a restart")
+			Expect(newController().Begin(ctx, target)).To(Succeed())
+			status, err = c.Status(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Phase).To(Equal(managementv1.PluginRolloutPhase_RolloutRolledBack))
+		})
+
+		It("should update clusters created during the rollout with the final wave", func() {
+			Expect(c.Begin(ctx, target)).To(Succeed())
+			Expect(clusters.CreateCluster(ctx, &corev1.Cluster{Id: "cluster-new"})).To(Succeed())
+			Expect(c.DesiredManifest("cluster-new").Digest()).To(Equal(stable.Digest()))
+
+			Expect(c.Advance(ctx)).To(Succeed())
+			Expect(c.DesiredManifest("cluster-new").Digest()).To(Equal(stable.Digest()))
+			Expect(c.Advance(ctx)).To(Succeed())
+			Expect(c.DesiredManifest("cluster-new").Digest()).To(Equal(target.Digest()))
+
+			Expect(c.Advance(ctx)).To(Succeed())
+			status, err := c.Status(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Phase).To(Equal(managementv1.PluginRolloutPhase_RolloutCompleted))
+			Expect(c.DesiredManifest("cluster-new").Digest()).To(Equal(target.Digest()))
+		})
+
+		It("should ignore clusters which were unhealthy before being updated", func() {
+			healthy["cluster-1"] = false
+			Expect(c.Begin(ctx, target)).To(Succeed())
+			Expect(c.Advance(ctx)).To(Succeed())
+			Expect(c.Advance(ctx)).To(Succeed())
+
+			status, err := c.Status(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.Phase).To(Equal(managementv1.PluginRolloutPhase_RolloutInProgress))
+			Expect(status.CurrentWave).To(BeEquivalentTo(2))
+		})
+	})
+})
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+var _ = Describe("Agent Plugin Enforcer", Label("unit"), func() {
+	var (
+		desired  map[string]*controlv1.PluginManifest
+		enforcer *gateway.AgentPluginEnforcer
+	)
+	manifest := func(digest string) *controlv1.PluginManifest {
+		return &controlv1.PluginManifest{
+			Items: []*controlv1.PluginManifestEntry{
+				{
+					Module:   "github.com/rancher/opni/plugins/example",
+					Filename: "plugin_example",
+					Digest:   digest,
+				},
+			},
+		}
+	}
+	BeforeEach(func() {
+		desired = map[string]*controlv1.PluginManifest{
+			"":        manifest("v1"),
+			"agent-1": manifest("v1"),
+		}
+		enforcer = gateway.NewAgentPluginEnforcer(func(id string) *controlv1.PluginManifest {
+			return desired[id]
+		}, test.Log)
+	})
+	// connect starts a stream for the given agent, which reports the digest of
+	// its plugins. Returns a channel which receives the stream's error.
+	connect := func(id string, md metadata.MD) <-chan error {
+		ctx := context.WithValue(context.Background(), cluster.ClusterIDKey, id)
+		ctx = metadata.NewIncomingContext(ctx, md)
+		errC := make(chan error, 1)
+		go func() {
+			errC <- enforcer.StreamServerInterceptor()(nil, testServerStream{ctx: ctx}, &grpc.StreamServerInfo{}, func(_ any, stream grpc.ServerStream) error {
+				<-stream.Context().Done()
+				return stream.Context().Err()
+			})
+		}()
+		return errC
+	}
+
+	It("should disconnect agents with outdated plugins", func() {
+		errC := connect("agent-1", metadata.Pairs(
+			controlv1.AgentIDKey, "agent-1",
+			controlv1.ManifestDigestKey, manifest("v1").Digest(),
+		))
+
+		By("keeping agents whose plugins are up to date")
+		Consistently(enforcer.DisconnectOutdated).Should(BeZero())
+		Expect(errC).NotTo(Receive())
+
+		By("disconnecting agents when their desired plugins change")
+		desired["agent-1"] = manifest("v2")
+		Eventually(enforcer.DisconnectOutdated).Should(Equal(1))
+		Eventually(errC).Should(Receive(MatchError(context.Canceled)))
+		Expect(enforcer.DisconnectOutdated()).To(BeZero())
+	})
+
+	It("should check agents which do not identify themselves against the gateway's plugins", func() {
+		errC := connect("agent-2", metadata.Pairs(
+			controlv1.ManifestDigestKey, manifest("v1").Digest(),
+		))
+		desired["agent-2"] = manifest("v2")
+		Consistently(enforcer.DisconnectOutdated).Should(BeZero())
+
+		desired[""] = manifest("v2")
+		Eventually(enforcer.DisconnectOutdated).Should(Equal(1))
+		Eventually(errC).Should(Receive(MatchError(context.Canceled)))
+	})
+})
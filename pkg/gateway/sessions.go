@@ -0,0 +1,320 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/rancher/opni/pkg/agent"
+	streamv1 "github.com/rancher/opni/pkg/apis/stream/v1"
+	"github.com/rancher/opni/pkg/auth/cluster"
+	"github.com/rancher/opni/pkg/storage"
+)
+
+const (
+	sessionsPrefix           = "sessions"
+	replicasPrefix           = "replicas"
+	replicaHeartbeatInterval = 10 * time.Second
+	replicaExpiration        = 3 * replicaHeartbeatInterval
+)
+
+// SessionRegistry records which gateway replica holds each agent's stream
+// session, in a key-value store shared by all replicas.
+type SessionRegistry struct {
+	SessionRegistryOptions
+	replicaId    string
+	relayAddress string
+	store        storage.KeyValueStore
+	locks        storage.LockManager
+	logger       *zap.SugaredLogger
+}
+
+type SessionRegistryOptions struct {
+	onExpired func(id string)
+}
+
+type SessionRegistryOption func(*SessionRegistryOptions)
+
+func (o *SessionRegistryOptions) apply(opts ...SessionRegistryOption) {
+	for _, op := range opts {
+		op(o)
+	}
+}
+
+// WithExpiredSessionHandler sets a function to be called with the cluster id
+// of each session removed because the replica holding it stopped responding.
+func WithExpiredSessionHandler(fn func(id string)) SessionRegistryOption {
+	return func(o *SessionRegistryOptions) {
+		o.onExpired = fn
+	}
+}
+
+func NewSessionRegistry(
+	store storage.KeyValueStore,
+	locks storage.LockManager,
+	replicaId string,
+	relayAddress string,
+	lg *zap.SugaredLogger,
+	opts ...SessionRegistryOption,
+) *SessionRegistry {
+	options := SessionRegistryOptions{
+		onExpired: func(string) {},
+	}
+	options.apply(opts...)
+	return &SessionRegistry{
+		SessionRegistryOptions: options,
+		replicaId:              replicaId,
+		relayAddress:           relayAddress,
+		store:                  store,
+		locks:                  locks,
+		logger:                 lg.Named("sessions"),
+	}
+}
+
+func (r *SessionRegistry) ReplicaId() string {
+	return r.replicaId
+}
+
+// Wrap returns a connection handler which registers the agent's session
+// before passing the connection on to the next handler, and removes it once
+// the agent disconnects.
+func (r *SessionRegistry) Wrap(next ConnectionHandler) ConnectionHandler {
+	return ConnectionHandlerFunc(func(ctx context.Context, clientSet agentv1.ClientSet) {
+		id := cluster.StreamAuthorizedID(ctx)
+		lg := r.logger.With("id", id)
+		session, err := r.register(ctx, id)
+		if err != nil {
+			lg.With(
+				zap.Error(err),
+			).Error("failed to register agent session")
+		}
+		next.HandleAgentConnection(ctx, clientSet)
+
+		<-ctx.Done()
+
+		if session == nil {
+			return
+		}
+		if err := r.unregister(id, session.Id); err != nil {
+			lg.With(
+				zap.Error(err),
+			).Warn("failed to remove agent session")
+		}
+	})
+}
+
+// Lookup returns the session for the given cluster, or storage.ErrNotFound if
+// its agent is not connected to any replica.
+func (r *SessionRegistry) Lookup(ctx context.Context, id string) (*streamv1.Session, error) {
+	data, err := r.store.Get(ctx, path.Join(sessionsPrefix, id))
+	if err != nil {
+		return nil, err
+	}
+	session := &streamv1.Session{}
+	if err := proto.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+	}
+	return session, nil
+}
+
+// IsRemote reports whether the given cluster's agent is connected to a
+// different replica.
+func (r *SessionRegistry) IsRemote(id string) bool {
+	ctx, ca := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ca()
+	session, err := r.Lookup(ctx, id)
+	if err != nil {
+		return false
+	}
+	return session.ReplicaId != r.replicaId
+}
+
+// RemoteReplicas returns all other replicas which are currently alive.
+func (r *SessionRegistry) RemoteReplicas(ctx context.Context) ([]*streamv1.Replica, error) {
+	replicas, err := r.listReplicas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var remote []*streamv1.Replica
+	for _, replica := range replicas {
+		if replica.Id != r.replicaId && isAlive(replica) {
+			remote = append(remote, replica)
+		}
+	}
+	return remote, nil
+}
+
+// Run periodically records that this replica is alive, and removes sessions
+// held by replicas which have stopped doing so.
+func (r *SessionRegistry) Run(ctx context.Context) {
+	ticker := time.NewTicker(replicaHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.heartbeat(ctx); err != nil {
+			r.logger.With(
+				zap.Error(err),
+			).Warn("failed to update replica heartbeat")
+		}
+		if err := r.expireSessions(ctx); err != nil {
+			r.logger.With(
+				zap.Error(err),
+			).Warn("failed to remove expired sessions")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *SessionRegistry) register(ctx context.Context, id string) (*streamv1.Session, error) {
+	session := &streamv1.Session{
+		Id:           uuid.NewString(),
+		ReplicaId:    r.replicaId,
+		RelayAddress: r.relayAddress,
+		ConnectedAt:  timestamppb.Now(),
+	}
+	data, err := proto.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	lock := r.locks.Locker(id)
+	if err := lock.Lock(ctx); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	if err := r.store.Put(ctx, path.Join(sessionsPrefix, id), data); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// unregister removes the cluster's session, unless the agent has since
+// connected again (possibly to a different replica) and replaced it.
+func (r *SessionRegistry) unregister(id string, sessionId string) error {
+	ctx, ca := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ca()
+	lock := r.locks.Locker(id)
+	if err := lock.Lock(ctx); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	current, err := r.Lookup(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if current.Id != sessionId {
+		return nil
+	}
+	return r.store.Delete(ctx, path.Join(sessionsPrefix, id))
+}
+
+func (r *SessionRegistry) heartbeat(ctx context.Context) error {
+	data, err := proto.Marshal(&streamv1.Replica{
+		Id:            r.replicaId,
+		RelayAddress:  r.relayAddress,
+		LastHeartbeat: timestamppb.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return r.store.Put(ctx, path.Join(replicasPrefix, r.replicaId), data)
+}
+
+func (r *SessionRegistry) listReplicas(ctx context.Context) ([]*streamv1.Replica, error) {
+	keys, err := r.store.ListKeys(ctx, replicasPrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	replicas := make([]*streamv1.Replica, 0, len(keys))
+	for _, key := range keys {
+		data, err := r.store.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		replica := &streamv1.Replica{}
+		if err := proto.Unmarshal(data, replica); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal replica: %w", err)
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas, nil
+}
+
+func (r *SessionRegistry) expireSessions(ctx context.Context) error {
+	replicas, err := r.listReplicas(ctx)
+	if err != nil {
+		return err
+	}
+	alive := map[string]bool{
+		r.replicaId: true,
+	}
+	for _, replica := range replicas {
+		if isAlive(replica) {
+			alive[replica.Id] = true
+		} else if err := r.store.Delete(ctx, path.Join(replicasPrefix, replica.Id)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+
+	keys, err := r.store.ListKeys(ctx, sessionsPrefix+"/")
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, key := range keys {
+		id := path.Base(key)
+		expired, err := r.expireSession(ctx, id, alive)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("session %s: %w", id, err))
+			continue
+		}
+		if expired {
+			r.logger.With(
+				"id", id,
+			).Warn("removed session held by unresponsive replica")
+			r.onExpired(id)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *SessionRegistry) expireSession(ctx context.Context, id string, alive map[string]bool) (bool, error) {
+	lock := r.locks.Locker(id)
+	if err := lock.Lock(ctx); err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+	session, err := r.Lookup(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if alive[session.ReplicaId] {
+		return false, nil
+	}
+	if err := r.store.Delete(ctx, path.Join(sessionsPrefix, id)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func isAlive(replica *streamv1.Replica) bool {
+	return time.Since(replica.GetLastHeartbeat().AsTime()) < replicaExpiration
+}
@@ -0,0 +1,120 @@
+package gateway_test
+
+import (
+	"context"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/exp/slices"
+
+	agentv1 "github.com/rancher/opni/pkg/agent"
+	"github.com/rancher/opni/pkg/gateway"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/test"
+)
+
+var _ = Describe("Session Registry", Label("unit"), func() {
+	var (
+		kv    storage.KeyValueStore
+		locks storage.LockManager
+	)
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		kv = test.NewTestKeyValueStore(ctrl, slices.Clone[[]byte])
+		locks = storage.NewInMemoryLockManager()
+	})
+
+	newRegistry := func(replicaId string, opts ...gateway.SessionRegistryOption) *gateway.SessionRegistry {
+		return gateway.NewSessionRegistry(kv, locks, replicaId, replicaId+":9091", test.Log, opts...)
+	}
+	// connect simulates an agent connecting to the given registry, and returns
+	// a function which disconnects it.
+	connect := func(r *gateway.SessionRegistry, id string) context.CancelFunc {
+		ctx, ca := context.WithCancel(test.ContextWithAuthorizedID(context.Background(), id))
+		connected := make(chan struct{})
+		go r.Wrap(gateway.ConnectionHandlerFunc(func(context.Context, agentv1.ClientSet) {
+			close(connected)
+		})).HandleAgentConnection(ctx, nil)
+		Eventually(connected).Should(BeClosed())
+		return ca
+	}
+
+	It("should record which replica holds an agent's session", func() {
+		a := newRegistry("a")
+		b := newRegistry("b")
+		disconnect := connect(a, "agent1")
+		defer disconnect()
+
+		session, err := a.Lookup(context.Background(), "agent1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(session.ReplicaId).To(Equal("a"))
+		Expect(session.RelayAddress).To(Equal("a:9091"))
+
+		Expect(a.IsRemote("agent1")).To(BeFalse())
+		Expect(b.IsRemote("agent1")).To(BeTrue())
+		Expect(b.IsRemote("agent2")).To(BeFalse())
+	})
+
+	It("should remove the session when the agent disconnects", func() {
+		a := newRegistry("a")
+		disconnect := connect(a, "agent1")
+		disconnect()
+
+		Eventually(func() error {
+			_, err := a.Lookup(context.Background(), "agent1")
+			return err
+		}).Should(MatchError(storage.ErrNotFound))
+	})
+
+	It("should keep the session if the agent reconnected to another replica", func() {
+		a := newRegistry("a")
+		b := newRegistry("b")
+		disconnectA := connect(a, "agent1")
+		disconnectB := connect(b, "agent1")
+		defer disconnectB()
+		disconnectA()
+
+		Consistently(func() (string, error) {
+			session, err := a.Lookup(context.Background(), "agent1")
+			return session.GetReplicaId(), err
+		}).Should(Equal("b"))
+		Expect(a.IsRemote("agent1")).To(BeTrue())
+	})
+
+	It("should expire sessions held by replicas which stopped responding", func() {
+		a := newRegistry("a")
+		disconnect := connect(a, "agent1")
+		defer disconnect()
+
+		expired := make(chan string, 1)
+		b := newRegistry("b", gateway.WithExpiredSessionHandler(func(id string) {
+			expired <- id
+		}))
+		ctx, ca := context.WithCancel(context.Background())
+		defer ca()
+		go b.Run(ctx)
+
+		Eventually(expired).Should(Receive(Equal("agent1")))
+		_, err := b.Lookup(context.Background(), "agent1")
+		Expect(err).To(MatchError(storage.ErrNotFound))
+	})
+
+	It("should list other live replicas", func() {
+		a := newRegistry("a")
+		b := newRegistry("b")
+		ctx, ca := context.WithCancel(context.Background())
+		defer ca()
+		go a.Run(ctx)
+		go b.Run(ctx)
+
+		Eventually(func() ([]string, error) {
+			replicas, err := a.RemoteReplicas(context.Background())
+			addrs := make([]string, len(replicas))
+			for i, r := range replicas {
+				addrs[i] = r.RelayAddress
+			}
+			return addrs, err
+		}).Should(ConsistOf("b:9091"))
+	})
+})
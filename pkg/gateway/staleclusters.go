@@ -0,0 +1,327 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	capabilityv1 "github.com/rancher/opni/pkg/apis/capability/v1"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/capabilities"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/health"
+	"github.com/rancher/opni/pkg/storage"
+)
+
+const staleClusterCheckInterval = 1 * time.Hour
+
+// StaleClusterCollector enforces the stale cluster policy. Clusters whose
+// agents have been disconnected for longer than the configured period are
+// marked as stale with the stale-since label, which stale cluster alert
+// conditions observe. If deletion is enabled, stale clusters have their
+// capabilities uninstalled once the grace period has elapsed, and are deleted
+// after all capabilities have been removed.
+type StaleClusterCollector struct {
+	conf         v1beta1.StaleClustersSpec
+	storage      storage.Backend
+	capabilities capabilities.BackendStore
+	status       health.HealthStatusQuerier
+	logger       *zap.SugaredLogger
+
+	deleterMu sync.Mutex
+	deleter   func(ctx context.Context, ref *corev1.Reference) error
+}
+
+func NewStaleClusterCollector(
+	conf v1beta1.StaleClustersSpec,
+	storageBackend storage.Backend,
+	capBackendStore capabilities.BackendStore,
+	status health.HealthStatusQuerier,
+	lg *zap.SugaredLogger,
+) *StaleClusterCollector {
+	return &StaleClusterCollector{
+		conf:         conf,
+		storage:      storageBackend,
+		capabilities: capBackendStore,
+		status:       status,
+		logger:       lg.Named("stale-clusters"),
+	}
+}
+
+// SetClusterDeleter sets the function used to delete stale clusters. It is
+// set by the management server, so that clusters are deleted in the same way
+// as by the DeleteCluster API. Stale clusters are not deleted until it is set.
+func (c *StaleClusterCollector) SetClusterDeleter(deleter func(ctx context.Context, ref *corev1.Reference) error) {
+	c.deleterMu.Lock()
+	defer c.deleterMu.Unlock()
+	c.deleter = deleter
+}
+
+func (c *StaleClusterCollector) Enabled() bool {
+	return c.conf.StaleAfterDays > 0
+}
+
+// Run applies the policy periodically until the context is canceled. It
+// does nothing if the policy is not enabled.
+func (c *StaleClusterCollector) Run(ctx context.Context) {
+	if !c.Enabled() {
+		return
+	}
+	ticker := time.NewTicker(staleClusterCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.applyExclusive(ctx); err != nil {
+			c.logger.With(
+				zap.Error(err),
+			).Warn("failed to apply stale cluster policy")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Report returns the actions the policy would take if it were applied now,
+// without making any changes.
+func (c *StaleClusterCollector) Report(ctx context.Context) (*managementv1.StaleClusterReport, error) {
+	now := time.Now()
+	report := &managementv1.StaleClusterReport{
+		Time: timestamppb.New(now),
+	}
+	if !c.Enabled() {
+		return report, nil
+	}
+	clusters, err := c.storage.ListClusters(ctx, nil, corev1.MatchOptions_Default)
+	if err != nil {
+		return nil, err
+	}
+	for _, cluster := range clusters.Items {
+		if item := c.evaluate(cluster, now); item != nil {
+			report.Items = append(report.Items, item)
+		}
+	}
+	return report, nil
+}
+
+// Apply evaluates the policy for all clusters and performs any pending
+// actions.
+func (c *StaleClusterCollector) Apply(ctx context.Context) error {
+	report, err := c.Report(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, item := range report.Items {
+		lg := c.logger.With(
+			"cluster", item.Cluster.GetId(),
+			"lastSeen", item.LastSeen.AsTime(),
+		)
+		var err error
+		switch item.Action {
+		case managementv1.StaleClusterAction_MarkStale:
+			err = c.markStale(ctx, item.Cluster, report.Time.AsTime())
+		case managementv1.StaleClusterAction_UninstallCapabilities:
+			lg.Warn("uninstalling capabilities from stale cluster")
+			err = c.uninstallCapabilities(ctx, item.Cluster)
+		case managementv1.StaleClusterAction_Delete:
+			lg.Warn("deleting stale cluster")
+			err = c.deleteCluster(ctx, item.Cluster)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", item.Cluster.GetId(), err))
+		}
+	}
+
+	if err := c.clearRecovered(ctx, report); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// applyExclusive applies the policy while holding a lock shared by all gateway
+// replicas, so that replicas do not act on the same clusters concurrently.
+func (c *StaleClusterCollector) applyExclusive(ctx context.Context) error {
+	lock := storage.LockManagerFor(c.storage, "gateway").Locker("stale-clusters")
+	if err := lock.Lock(ctx); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	return c.Apply(ctx)
+}
+
+func (c *StaleClusterCollector) evaluate(cluster *corev1.Cluster, now time.Time) *managementv1.StaleCluster {
+	if hs := c.status.GetHealthStatus(cluster.Id); hs.GetStatus().GetConnected() {
+		return nil
+	}
+	lastSeen := cluster.GetCreationTimestamp()
+	if t := cluster.GetMetadata().GetLastKnownConnectionDetails().GetTime(); t != nil {
+		lastSeen = t.AsTime()
+	}
+	if now.Sub(lastSeen) < days(c.conf.StaleAfterDays) {
+		return nil
+	}
+
+	item := &managementv1.StaleCluster{
+		Cluster:  cluster.Reference(),
+		LastSeen: timestamppb.New(lastSeen),
+	}
+	if c.isExempt(cluster) {
+		item.Action = managementv1.StaleClusterAction_Exempt
+		return item
+	}
+	staleSince, ok := staleSinceLabel(cluster)
+	if !ok {
+		item.Action = managementv1.StaleClusterAction_MarkStale
+		staleSince = now
+	} else {
+		item.StaleSince = timestamppb.New(staleSince)
+	}
+	if c.conf.DeleteAfterDays <= 0 {
+		return item
+	}
+	deletionTime := staleSince.Add(days(c.conf.DeleteAfterDays))
+	item.DeletionTime = timestamppb.New(deletionTime)
+	if item.Action == managementv1.StaleClusterAction_None && !now.Before(deletionTime) {
+		if len(pendingCapabilities(cluster)) > 0 {
+			item.Action = managementv1.StaleClusterAction_UninstallCapabilities
+		} else if len(cluster.GetCapabilities()) == 0 {
+			item.Action = managementv1.StaleClusterAction_Delete
+		}
+	}
+	return item
+}
+
+func (c *StaleClusterCollector) isExempt(cluster *corev1.Cluster) bool {
+	labels := cluster.GetLabels()
+	for _, exempt := range c.conf.ExemptLabels {
+		key, value, hasValue := strings.Cut(exempt, "=")
+		v, ok := labels[key]
+		if ok && (!hasValue || v == value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *StaleClusterCollector) markStale(ctx context.Context, ref *corev1.Reference, now time.Time) error {
+	_, err := c.storage.UpdateCluster(ctx, ref, func(cluster *corev1.Cluster) {
+		if cluster.Metadata == nil {
+			cluster.Metadata = &corev1.ClusterMetadata{}
+		}
+		if cluster.Metadata.Labels == nil {
+			cluster.Metadata.Labels = map[string]string{}
+		}
+		cluster.Metadata.Labels[corev1.StaleSinceLabel] = strconv.FormatInt(now.Unix(), 10)
+	})
+	return err
+}
+
+func (c *StaleClusterCollector) uninstallCapabilities(ctx context.Context, ref *corev1.Reference) error {
+	cluster, err := c.storage.GetCluster(ctx, ref)
+	if err != nil {
+		return err
+	}
+	options := capabilityv1.DefaultUninstallOptions{
+		DeleteStoredData: c.conf.DeleteStoredData,
+	}
+	var errs []error
+	for _, name := range pendingCapabilities(cluster) {
+		backend, err := c.capabilities.Get(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := backend.Uninstall(ctx, &capabilityv1.UninstallRequest{
+			Cluster: ref,
+			Options: options.ToStruct(),
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to uninstall capability %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *StaleClusterCollector) deleteCluster(ctx context.Context, ref *corev1.Reference) error {
+	c.deleterMu.Lock()
+	deleter := c.deleter
+	c.deleterMu.Unlock()
+	if deleter == nil {
+		return errors.New("cluster deletion is not available yet")
+	}
+	return deleter(ctx, ref)
+}
+
+// clearRecovered removes the stale label from clusters which are no longer
+// stale, e.g. because their agent has reconnected.
+func (c *StaleClusterCollector) clearRecovered(ctx context.Context, report *managementv1.StaleClusterReport) error {
+	stale := make(map[string]struct{}, len(report.Items))
+	for _, item := range report.Items {
+		if item.Action != managementv1.StaleClusterAction_Exempt {
+			stale[item.Cluster.GetId()] = struct{}{}
+		}
+	}
+	clusters, err := c.storage.ListClusters(ctx, &corev1.LabelSelector{
+		MatchExpressions: []*corev1.LabelSelectorRequirement{
+			{
+				Key:      corev1.StaleSinceLabel,
+				Operator: string(corev1.LabelSelectorOpExists),
+			},
+		},
+	}, corev1.MatchOptions_Default)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, cluster := range clusters.Items {
+		if _, ok := stale[cluster.Id]; ok {
+			continue
+		}
+		c.logger.With(
+			"cluster", cluster.Id,
+		).Info("cluster is no longer stale")
+		if _, err := c.storage.UpdateCluster(ctx, cluster.Reference(), func(cluster *corev1.Cluster) {
+			delete(cluster.Metadata.Labels, corev1.StaleSinceLabel)
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", cluster.Id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func staleSinceLabel(cluster *corev1.Cluster) (time.Time, bool) {
+	value, ok := cluster.GetLabels()[corev1.StaleSinceLabel]
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// pendingCapabilities returns the names of the cluster's capabilities which
+// are not already being uninstalled.
+func pendingCapabilities(cluster *corev1.Cluster) []string {
+	var names []string
+	for _, cap := range cluster.GetCapabilities() {
+		if cap.DeletionTimestamp == nil {
+			names = append(names, cap.Name)
+		}
+	}
+	return names
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
@@ -0,0 +1,225 @@
+package gateway_test
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/capabilities"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/gateway"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/test"
+)
+
+type testStatusQuerier map[string]bool
+
+func (q testStatusQuerier) GetHealthStatus(id string) *corev1.HealthStatus {
+	return &corev1.HealthStatus{
+		Status: &corev1.Status{
+			Connected: q[id],
+		},
+	}
+}
+
+func (q testStatusQuerier) WatchHealthStatus(context.Context) <-chan *corev1.ClusterHealthStatus {
+	return nil
+}
+
+var _ = Describe("Stale Cluster Collector", Label("unit"), func() {
+	const day = 24 * time.Hour
+	var (
+		ctx             context.Context
+		store           *storage.CompositeBackend
+		capBackendStore capabilities.BackendStore
+		connected       testStatusQuerier
+	)
+
+	lastSeen := func(ago time.Duration) *corev1.ClusterMetadata {
+		return &corev1.ClusterMetadata{
+			LastKnownConnectionDetails: &corev1.LastKnownConnectionDetails{
+				Time: timestamppb.New(time.Now().Add(-ago)),
+			},
+		}
+	}
+	staleSince := func(md *corev1.ClusterMetadata, ago time.Duration) *corev1.ClusterMetadata {
+		md.Labels = map[string]string{
+			corev1.StaleSinceLabel: strconv.FormatInt(time.Now().Add(-ago).Unix(), 10),
+		}
+		return md
+	}
+	var deleted []string
+	newCollector := func(conf v1beta1.StaleClustersSpec) *gateway.StaleClusterCollector {
+		c := gateway.NewStaleClusterCollector(conf, store, capBackendStore, connected, test.Log)
+		c.SetClusterDeleter(func(ctx context.Context, ref *corev1.Reference) error {
+			deleted = append(deleted, ref.GetId())
+			return store.DeleteCluster(ctx, ref)
+		})
+		return c
+	}
+	actions := func(report *managementv1.StaleClusterReport) map[string]managementv1.StaleClusterAction {
+		m := map[string]managementv1.StaleClusterAction{}
+		for _, item := range report.Items {
+			m[item.Cluster.Id] = item.Action
+		}
+		return m
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		deleted = nil
+		ctrl := gomock.NewController(GinkgoT())
+		store = &storage.CompositeBackend{}
+		store.Use(test.NewTestClusterStore(ctrl))
+		store.Use(test.NewTestKeyringStoreBroker(ctrl))
+		capBackendStore = capabilities.NewBackendStore(capabilities.ServerInstallerTemplateSpec{}, test.Log)
+		Expect(capBackendStore.Add("test", test.NewTestCapabilityBackend(ctrl, &test.CapabilityInfo{
+			Name:    "test",
+			Storage: store,
+		}))).To(Succeed())
+		connected = testStatusQuerier{}
+
+		for _, c := range []*corev1.Cluster{
+			{Id: "recent", Metadata: lastSeen(1 * day)},
+			{Id: "old", Metadata: lastSeen(10 * day)},
+			{Id: "old-connected", Metadata: lastSeen(10 * day)},
+			{Id: "stale", Metadata: staleSince(lastSeen(20*day), 10*day)},
+			{Id: "stale-recent", Metadata: staleSince(lastSeen(10*day), 1*day)},
+			{Id: "stale-reconnected", Metadata: staleSince(lastSeen(1*day), 1*day)},
+		} {
+			Expect(store.CreateCluster(ctx, c)).To(Succeed())
+		}
+		connected["old-connected"] = true
+	})
+
+	It("should do nothing if the policy is not enabled", func() {
+		c := newCollector(v1beta1.StaleClustersSpec{})
+		report, err := c.Report(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Items).To(BeEmpty())
+		Expect(c.Apply(ctx)).To(Succeed())
+		cluster, err := store.GetCluster(ctx, &corev1.Reference{Id: "old"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster.GetLabels()).NotTo(HaveKey(corev1.StaleSinceLabel))
+	})
+
+	It("should report clusters disconnected for longer than the configured period", func() {
+		c := newCollector(v1beta1.StaleClustersSpec{
+			StaleAfterDays:  7,
+			DeleteAfterDays: 7,
+		})
+		report, err := c.Report(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(actions(report)).To(Equal(map[string]managementv1.StaleClusterAction{
+			"old":          managementv1.StaleClusterAction_MarkStale,
+			"stale":        managementv1.StaleClusterAction_Delete,
+			"stale-recent": managementv1.StaleClusterAction_None,
+		}))
+		for _, item := range report.Items {
+			Expect(item.DeletionTime).NotTo(BeNil())
+		}
+
+		By("not making any changes")
+		clusters, err := store.ListClusters(ctx, nil, corev1.MatchOptions_Default)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clusters.Items).To(HaveLen(6))
+	})
+
+	It("should mark stale clusters and clear the label once they reconnect", func() {
+		c := newCollector(v1beta1.StaleClustersSpec{
+			StaleAfterDays: 7,
+		})
+		Expect(c.Apply(ctx)).To(Succeed())
+
+		cluster, err := store.GetCluster(ctx, &corev1.Reference{Id: "old"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster.GetLabels()).To(HaveKey(corev1.StaleSinceLabel))
+
+		cluster, err = store.GetCluster(ctx, &corev1.Reference{Id: "stale-reconnected"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster.GetLabels()).NotTo(HaveKey(corev1.StaleSinceLabel))
+
+		By("never deleting clusters if deletion is not enabled")
+		_, err = store.GetCluster(ctx, &corev1.Reference{Id: "stale"})
+		Expect(err).NotTo(HaveOccurred())
+
+		report, err := c.Report(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(actions(report)).To(HaveKeyWithValue("old", managementv1.StaleClusterAction_None))
+	})
+
+	It("should not apply the policy to exempt clusters", func() {
+		_, err := store.UpdateCluster(ctx, &corev1.Reference{Id: "stale"}, func(c *corev1.Cluster) {
+			c.Metadata.Labels["keep"] = "true"
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = store.UpdateCluster(ctx, &corev1.Reference{Id: "old"}, func(c *corev1.Cluster) {
+			c.Metadata.Labels = map[string]string{"env": "prod"}
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		c := newCollector(v1beta1.StaleClustersSpec{
+			StaleAfterDays:  7,
+			DeleteAfterDays: 7,
+			ExemptLabels:    []string{"keep", "env=prod"},
+		})
+		Expect(c.Apply(ctx)).To(Succeed())
+
+		cluster, err := store.GetCluster(ctx, &corev1.Reference{Id: "stale"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster.GetLabels()).NotTo(HaveKey(corev1.StaleSinceLabel))
+		cluster, err = store.GetCluster(ctx, &corev1.Reference{Id: "old"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster.GetLabels()).NotTo(HaveKey(corev1.StaleSinceLabel))
+
+		report, err := c.Report(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(actions(report)).To(HaveKeyWithValue("stale", managementv1.StaleClusterAction_Exempt))
+		Expect(actions(report)).To(HaveKeyWithValue("old", managementv1.StaleClusterAction_Exempt))
+	})
+
+	It("should uninstall capabilities before deleting stale clusters", func() {
+		_, err := store.UpdateCluster(ctx, &corev1.Reference{Id: "stale"},
+			storage.NewAddCapabilityMutator[*corev1.Cluster](capabilities.Cluster("test")))
+		Expect(err).NotTo(HaveOccurred())
+
+		c := newCollector(v1beta1.StaleClustersSpec{
+			StaleAfterDays:  7,
+			DeleteAfterDays: 7,
+		})
+		report, err := c.Report(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(actions(report)).To(HaveKeyWithValue("stale", managementv1.StaleClusterAction_UninstallCapabilities))
+
+		Expect(c.Apply(ctx)).To(Succeed())
+		cluster, err := store.GetCluster(ctx, &corev1.Reference{Id: "stale"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster.GetCapabilities()).To(BeEmpty())
+
+		Expect(deleted).To(BeEmpty())
+
+		Expect(c.Apply(ctx)).To(Succeed())
+		Expect(deleted).To(ConsistOf("stale"))
+		_, err = store.GetCluster(ctx, &corev1.Reference{Id: "stale"})
+		Expect(err).To(MatchError(storage.ErrNotFound))
+		_, err = store.GetCluster(ctx, &corev1.Reference{Id: "stale-recent"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should not delete stale clusters until a cluster deleter is set", func() {
+		c := gateway.NewStaleClusterCollector(v1beta1.StaleClustersSpec{
+			StaleAfterDays:  7,
+			DeleteAfterDays: 7,
+		}, store, capBackendStore, connected, test.Log)
+		Expect(c.Apply(ctx)).To(HaveOccurred())
+		_, err := store.GetCluster(ctx, &corev1.Reference{Id: "stale"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -6,7 +6,9 @@ import (
 	"sync"
 
 	"github.com/kralicky/totem"
+	"github.com/samber/lo"
 	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -77,7 +79,10 @@ func (s *StreamServer) Connect(stream streamv1.Stream_ConnectServer) error {
 	}
 	ctx = storage.NewWatchContext(ctx, eventC)
 
-	for _, r := range s.remotes {
+	s.remotesMu.Lock()
+	remotes := slices.Clone(s.remotes)
+	s.remotesMu.Unlock()
+	for _, r := range remotes {
 		streamClient := streamv1.NewStreamClient(r.cc)
 		ctx := cluster.AuthorizedOutgoingContext(ctx)
 		splicedStream, err := streamClient.Connect(ctx)
@@ -152,3 +157,16 @@ func (s *StreamServer) AddRemote(cc *grpc.ClientConn, name string) error {
 	})
 	return nil
 }
+
+// RemoveRemote removes the remote connection with the given name. Agents which
+// connect afterwards will not be connected to the remote stream.
+func (s *StreamServer) RemoveRemote(name string) {
+	s.remotesMu.Lock()
+	defer s.remotesMu.Unlock()
+	s.logger.With(
+		zap.String("name", name),
+	).Debug("removing remote connection")
+	s.remotes = lo.Reject(s.remotes, func(r remote, _ int) bool {
+		return r.name == name
+	})
+}
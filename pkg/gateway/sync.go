@@ -79,6 +79,13 @@ func (f *SyncRequester) RequestSync(ctx context.Context, req *capabilityv1.SyncR
 		}
 	}
 
+	if len(toSync) == 0 {
+		return &emptypb.Empty{}, status.Error(codes.NotFound, "agent is not connected")
+	}
+
+	// if no cluster is specified, all agents are synced, and the last error
+	// (if any) is returned
+	var lastErr error
 	for _, clientSet := range toSync {
 		f.logger.With(
 			"agentId", req.GetCluster().GetId(),
@@ -91,12 +98,10 @@ func (f *SyncRequester) RequestSync(ctx context.Context, req *capabilityv1.SyncR
 			f.logger.With(
 				zap.Error(err),
 			).Warn("sync request failed")
-			return &emptypb.Empty{}, err
+			lastErr = err
 		}
-		return &emptypb.Empty{}, nil
 	}
-
-	return &emptypb.Empty{}, status.Error(codes.NotFound, "agent is not connected")
+	return &emptypb.Empty{}, lastErr
 }
 
 func (f *SyncRequester) runPeriodicSync(ctx context.Context, req *capabilityv1.SyncRequest, period time.Duration, jitter time.Duration) {
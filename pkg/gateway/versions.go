@@ -1,6 +1,9 @@
 package gateway
 
 import (
+	"context"
+	"time"
+
 	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
 	"github.com/rancher/opni/pkg/auth/cluster"
@@ -51,6 +54,17 @@ func NewLastKnownDetailsApplier(storageBackend storage.ClusterStore) func(srv in
 		}); err != nil {
 			return status.Errorf(codes.Internal, "failed to update cluster: %v", err)
 		}
-		return handler(srv, ss)
+		err := handler(srv, ss)
+
+		// best effort: record the time the agent disconnected, so that the last known
+		// connection time reflects when the agent was last seen
+		ctx, ca := context.WithTimeout(context.Background(), 10*time.Second)
+		defer ca()
+		storageBackend.UpdateCluster(ctx, &corev1.Reference{Id: id}, func(cluster *corev1.Cluster) {
+			if cluster.Metadata.LastKnownConnectionDetails != nil {
+				cluster.Metadata.LastKnownConnectionDetails.Time = timestamppb.Now()
+			}
+		})
+		return err
 	}
 }
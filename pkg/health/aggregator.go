@@ -73,6 +73,7 @@ func (h *Aggregator) GetHealth(ctx context.Context, _ *emptypb.Empty) (*corev1.H
 	clientTimestamps := make([]time.Time, len(h.clients))
 	clientConditions := make([][]string, len(h.clients))
 	clientsReady := make([]bool, len(h.clients))
+	clientAnnotations := make([]map[string]string, len(h.clients))
 
 	var wg sync.WaitGroup
 	wg.Add(len(h.clients))
@@ -101,6 +102,7 @@ func (h *Aggregator) GetHealth(ctx context.Context, _ *emptypb.Empty) (*corev1.H
 			}
 			clientConditions[i] = health.Conditions
 			clientsReady[i] = health.Ready
+			clientAnnotations[i] = health.Annotations
 		}(i)
 		i++
 	}
@@ -112,12 +114,17 @@ func (h *Aggregator) GetHealth(ctx context.Context, _ *emptypb.Empty) (*corev1.H
 	}
 	allConditions := lo.Flatten(clientConditions)
 	sort.Strings(allConditions)
+	var annotations map[string]string
+	if len(h.staticAnnotations) > 0 || lo.SomeBy(clientAnnotations, func(a map[string]string) bool { return len(a) > 0 }) {
+		// static annotations take precedence over annotations set by clients
+		annotations = lo.Assign(append(clientAnnotations, h.staticAnnotations)...)
+	}
 	return &corev1.Health{
 		Timestamp: timestamppb.New(lo.MaxBy(clientTimestamps, func(t1, t2 time.Time) bool {
 			return t1.Before(t2)
 		})),
 		Ready:       allClientsReady,
 		Conditions:  allConditions,
-		Annotations: h.staticAnnotations,
+		Annotations: annotations,
 	}, nil
 }
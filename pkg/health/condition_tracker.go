@@ -86,8 +86,11 @@ func (ct *defaultConditionTracker) Set(key string, value ConditionStatus, reason
 	if v, ok := ct.conditions.Load(key); ok {
 		if v != value {
 			lg.Info("condition changed")
+			ct.conditions.Store(key, value)
+			ct.modTime.Store(time.Now())
+			ct.notifyListeners()
 		}
-	} else if v != value {
+	} else {
 		lg.Info("condition set")
 		ct.conditions.Store(key, value)
 		ct.modTime.Store(time.Now())
@@ -0,0 +1,30 @@
+package health_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rancher/opni/pkg/health"
+	"github.com/rancher/opni/pkg/test"
+)
+
+var _ = Describe("Condition Tracker", Label("unit"), func() {
+	It("should track condition changes", func() {
+		ct := health.NewDefaultConditionTracker(test.Log)
+		ct.Clear(health.CondConfigSync)
+		Expect(ct.List()).To(BeEmpty())
+
+		ct.Set("test", health.StatusPending, "")
+		Expect(ct.List()).To(ConsistOf("test Pending"))
+		modTime := ct.LastModified()
+
+		ct.Set("test", health.StatusPending, "")
+		Expect(ct.LastModified()).To(Equal(modTime))
+
+		ct.Set("test", health.StatusFailure, "")
+		Expect(ct.List()).To(ConsistOf("test Failure"))
+
+		ct.Clear("test")
+		Expect(ct.List()).To(BeEmpty())
+	})
+})
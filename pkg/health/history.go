@@ -0,0 +1,305 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/storage"
+)
+
+const defaultHistoryRange = 24 * time.Hour
+
+// History stores a record of changes to agents' connection status and health
+// in a key-value store.
+type History struct {
+	HistoryOptions
+	kv storage.KeyValueStore
+}
+
+type HistoryOptions struct {
+	lg        *zap.SugaredLogger
+	retention time.Duration
+}
+
+type HistoryOption func(*HistoryOptions)
+
+func (o *HistoryOptions) apply(opts ...HistoryOption) {
+	for _, op := range opts {
+		op(o)
+	}
+}
+
+func WithHistoryLogger(lg *zap.SugaredLogger) HistoryOption {
+	return func(o *HistoryOptions) {
+		o.lg = lg
+	}
+}
+
+// WithRetention sets the maximum age of recorded events. Older events are
+// removed by Prune. A value of 0 disables retention.
+func WithRetention(retention time.Duration) HistoryOption {
+	return func(o *HistoryOptions) {
+		o.retention = retention
+	}
+}
+
+func NewHistory(kv storage.KeyValueStore, opts ...HistoryOption) *History {
+	options := HistoryOptions{
+		lg: zap.NewNop().Sugar(),
+	}
+	options.apply(opts...)
+	return &History{
+		HistoryOptions: options,
+		kv:             kv,
+	}
+}
+
+// Keys are of the form <cluster id>/<unix nano timestamp>-<event type>, where
+// the timestamp is zero-padded so that keys sort in chronological order. Since
+// keys do not contain a random component, recording the same event more than
+// once (e.g. from multiple gateway replicas) only stores it once. The event
+// type is part of the key so that connection changes can be counted without
+// reading each event.
+func historyKey(id string, ts time.Time, eventType managementv1.HealthEventType) string {
+	return fmt.Sprintf("%s/%020d-%d", id, ts.UnixNano(), eventType)
+}
+
+func parseHistoryKey(key string) (time.Time, managementv1.HealthEventType, bool) {
+	_, suffix, ok := strings.Cut(key, "/")
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	ts, eventType, ok := strings.Cut(suffix, "-")
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	t, err := strconv.Atoi(eventType)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return time.Unix(0, nanos), managementv1.HealthEventType(t), true
+}
+
+// Record stores an event for the given cluster.
+func (h *History) Record(ctx context.Context, id string, event *managementv1.HealthEvent) error {
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return h.kv.Put(ctx, historyKey(id, event.GetTimestamp().AsTime(), event.GetType()), data)
+}
+
+// keys returns the sorted keys for all events recorded for the given cluster.
+func (h *History) keys(ctx context.Context, id string) ([]string, error) {
+	prefix := id + "/"
+	keys, err := h.kv.ListKeys(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	// some backends match prefixes without the trailing separator
+	n := 0
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			keys[n] = key
+			n++
+		}
+	}
+	keys = keys[:n]
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Events returns the events recorded for the given cluster between since and
+// until (inclusive), oldest first.
+func (h *History) Events(ctx context.Context, id string, since, until time.Time) ([]*managementv1.HealthEvent, error) {
+	keys, err := h.keys(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var events []*managementv1.HealthEvent
+	for _, key := range keys {
+		ts, _, ok := parseHistoryKey(key)
+		if !ok || ts.Before(since) {
+			continue
+		}
+		if ts.After(until) {
+			break
+		}
+		data, err := h.kv.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		event := &managementv1.HealthEvent{}
+		if err := proto.Unmarshal(data, event); err != nil {
+			h.lg.With(
+				zap.Error(err),
+				"key", key,
+			).Warn("skipping malformed health event")
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Disconnects returns the number of times the given cluster's agent has
+// disconnected since the given time.
+func (h *History) Disconnects(ctx context.Context, id string, since time.Time) (int, error) {
+	keys, err := h.keys(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, key := range keys {
+		ts, eventType, ok := parseHistoryKey(key)
+		if ok && !ts.Before(since) && eventType == managementv1.HealthEventType_AgentDisconnected {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Query returns the events recorded for a cluster within the requested time
+// range, and computes the agent's uptime over that range. The agent is
+// assumed to have been disconnected at the start of the range if no earlier
+// connection change was recorded.
+func (h *History) Query(ctx context.Context, req *managementv1.ClusterHealthHistoryRequest) (*managementv1.ClusterHealthHistory, error) {
+	until := time.Now()
+	if req.Until != nil {
+		until = req.Until.AsTime()
+	}
+	since := until.Add(-defaultHistoryRange)
+	if req.Since != nil {
+		since = req.Since.AsTime()
+	}
+	id := req.GetCluster().GetId()
+	keys, err := h.keys(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	history := &managementv1.ClusterHealthHistory{
+		Cluster: req.GetCluster(),
+	}
+	var connected bool
+	var uptime time.Duration
+	cursor := since
+	for _, key := range keys {
+		ts, eventType, ok := parseHistoryKey(key)
+		if !ok || ts.After(until) {
+			continue
+		}
+		var isConnected bool
+		switch eventType {
+		case managementv1.HealthEventType_AgentConnected:
+			isConnected = true
+		case managementv1.HealthEventType_AgentDisconnected:
+			isConnected = false
+		default:
+			continue
+		}
+		if ts.Before(since) {
+			// determines the state at the start of the range
+			connected = isConnected
+			continue
+		}
+		if connected {
+			uptime += ts.Sub(cursor)
+		}
+		if connected && !isConnected {
+			history.Disconnects++
+		}
+		connected, cursor = isConnected, ts
+	}
+	if connected {
+		uptime += until.Sub(cursor)
+	}
+	if total := until.Sub(since); total > 0 {
+		history.UptimePercent = float64(uptime) / float64(total) * 100
+	} else if connected {
+		history.UptimePercent = 100
+	}
+
+	history.Events, err = h.Events(ctx, id, since, until)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// Prune removes events older than the retention period.
+func (h *History) Prune(ctx context.Context) error {
+	if h.retention <= 0 {
+		return nil
+	}
+	keys, err := h.kv.ListKeys(ctx, "")
+	if err != nil {
+		return err
+	}
+	before := time.Now().Add(-h.retention)
+	pruned := 0
+	var errs []error
+	for _, key := range keys {
+		if ts, _, ok := parseHistoryKey(key); ok && ts.Before(before) {
+			if err := h.kv.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				errs = append(errs, err)
+				continue
+			}
+			pruned++
+		}
+	}
+	if pruned > 0 {
+		h.lg.With(
+			"count", pruned,
+		).Debug("removed expired health events")
+	}
+	return errors.Join(errs...)
+}
+
+// NewStatusEvent returns the event corresponding to a change in an agent's
+// connection status.
+func NewStatusEvent(status *corev1.Status) *managementv1.HealthEvent {
+	event := &managementv1.HealthEvent{
+		Timestamp: status.GetTimestamp(),
+		Type:      managementv1.HealthEventType_AgentDisconnected,
+	}
+	if status.GetConnected() {
+		event.Type = managementv1.HealthEventType_AgentConnected
+	}
+	if event.Timestamp == nil {
+		event.Timestamp = timestamppb.Now()
+	}
+	return event
+}
+
+// NewHealthEvent returns the event corresponding to a change in an agent's
+// health.
+func NewHealthEvent(health *corev1.Health) *managementv1.HealthEvent {
+	event := &managementv1.HealthEvent{
+		Timestamp: health.GetTimestamp(),
+		Type:      managementv1.HealthEventType_HealthChanged,
+		Health:    health,
+	}
+	if event.Timestamp == nil || event.Timestamp.AsTime().IsZero() {
+		event.Timestamp = timestamppb.Now()
+	}
+	return event
+}
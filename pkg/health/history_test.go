@@ -0,0 +1,109 @@
+package health_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/health"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/test"
+)
+
+var _ = Describe("History", Label("unit"), func() {
+	var ctx context.Context
+	var kv storage.KeyValueStore
+	var history *health.History
+	start := time.Now().Add(-1 * time.Hour).Truncate(time.Second)
+
+	status := func(id string, offset time.Duration, connected bool) {
+		Expect(history.Record(ctx, id, health.NewStatusEvent(&corev1.Status{
+			Timestamp: timestamppb.New(start.Add(offset)),
+			Connected: connected,
+		}))).To(Succeed())
+	}
+	query := func(id string, since, until time.Duration) *managementv1.ClusterHealthHistory {
+		h, err := history.Query(ctx, &managementv1.ClusterHealthHistoryRequest{
+			Cluster: &corev1.Reference{Id: id},
+			Since:   timestamppb.New(start.Add(since)),
+			Until:   timestamppb.New(start.Add(until)),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return h
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		kv = test.NewTestKeyValueStore(gomock.NewController(GinkgoT()), slices.Clone[[]byte])
+		history = health.NewHistory(kv, health.WithRetention(42*time.Minute))
+
+		// connected for 10 minutes, disconnected for 10 minutes, then connected
+		status("agent-1", 0, true)
+		status("agent-1", 10*time.Minute, false)
+		status("agent-1", 20*time.Minute, true)
+		Expect(history.Record(ctx, "agent-1", health.NewHealthEvent(&corev1.Health{
+			Timestamp:  timestamppb.New(start.Add(25 * time.Minute)),
+			Ready:      false,
+			Conditions: []string{"test condition"},
+		}))).To(Succeed())
+		status("agent-10", 5*time.Minute, true)
+	})
+
+	It("should return events within the requested range", func() {
+		h := query("agent-1", 5*time.Minute, 25*time.Minute)
+		Expect(h.Events).To(HaveLen(3))
+		Expect(h.Events[0].Type).To(Equal(managementv1.HealthEventType_AgentDisconnected))
+		Expect(h.Events[1].Type).To(Equal(managementv1.HealthEventType_AgentConnected))
+		Expect(h.Events[2].Type).To(Equal(managementv1.HealthEventType_HealthChanged))
+		Expect(h.Events[2].Health.Conditions).To(ConsistOf("test condition"))
+		Expect(h.Disconnects).To(BeEquivalentTo(1))
+	})
+
+	It("should compute uptime over the requested range", func() {
+		Expect(query("agent-1", 0, 20*time.Minute).UptimePercent).To(BeNumerically("~", 50))
+		// the agent was connected before the start of the range
+		Expect(query("agent-1", 5*time.Minute, 15*time.Minute).UptimePercent).To(BeNumerically("~", 50))
+		Expect(query("agent-1", 20*time.Minute, 40*time.Minute).UptimePercent).To(BeNumerically("~", 100))
+		Expect(query("agent-1", 12*time.Minute, 18*time.Minute).UptimePercent).To(BeNumerically("~", 0))
+		Expect(query("agent-2", 0, 20*time.Minute).UptimePercent).To(BeNumerically("~", 0))
+	})
+
+	It("should not include events from other clusters", func() {
+		h := query("agent-10", 0, 40*time.Minute)
+		Expect(h.Events).To(HaveLen(1))
+		Expect(h.Disconnects).To(BeZero())
+		Expect(h.UptimePercent).To(BeNumerically("~", 87.5))
+	})
+
+	It("should store duplicate events once", func() {
+		status("agent-1", 10*time.Minute, false)
+		Expect(query("agent-1", 0, 40*time.Minute).Events).To(HaveLen(4))
+	})
+
+	It("should count disconnects", func() {
+		status("agent-1", 30*time.Minute, false)
+		n, err := history.Disconnects(ctx, "agent-1", start)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(2))
+		n, err = history.Disconnects(ctx, "agent-1", start.Add(15*time.Minute))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(1))
+	})
+
+	It("should prune expired events", func() {
+		Expect(history.Prune(ctx)).To(Succeed())
+		h := query("agent-1", 0, 40*time.Minute)
+		Expect(h.Events).To(HaveLen(2))
+		Expect(h.Events[0].Type).To(Equal(managementv1.HealthEventType_AgentConnected))
+		Expect(h.Events[1].Type).To(Equal(managementv1.HealthEventType_HealthChanged))
+		Expect(h.UptimePercent).To(BeNumerically("~", 50))
+		Expect(query("agent-10", 0, 40*time.Minute).Events).To(BeEmpty())
+	})
+})
@@ -0,0 +1,246 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/storage"
+)
+
+const (
+	sharedStatusPrefix = "status"
+	sharedHealthPrefix = "health"
+)
+
+// SessionOwnership reports whether an agent's session is held by another
+// gateway replica.
+type SessionOwnership interface {
+	IsRemote(id string) bool
+}
+
+// SharedUpdater shares health and status updates between gateway replicas
+// through a key-value store. Updates from the local updater are published to
+// the store, and updates published by other replicas are emitted alongside
+// local ones.
+//
+// Updates for an agent whose session is held by another replica are dropped,
+// so that a replica handling a stale connection (e.g. one that has not yet
+// noticed the agent reconnected elsewhere) cannot report the agent as
+// disconnected.
+type SharedUpdater struct {
+	SharedUpdaterOptions
+	local    HealthStatusUpdater
+	store    storage.KeyValueStore
+	sessions SessionOwnership
+
+	statusUpdate chan StatusUpdate
+	healthUpdate chan HealthUpdate
+
+	mu         sync.Mutex
+	lastStatus map[string]time.Time
+	lastHealth map[string]time.Time
+}
+
+type SharedUpdaterOptions struct {
+	lg           *zap.SugaredLogger
+	pollInterval time.Duration
+}
+
+type SharedUpdaterOption func(*SharedUpdaterOptions)
+
+func (o *SharedUpdaterOptions) apply(opts ...SharedUpdaterOption) {
+	for _, op := range opts {
+		op(o)
+	}
+}
+
+func WithSharedUpdaterLogger(lg *zap.SugaredLogger) SharedUpdaterOption {
+	return func(o *SharedUpdaterOptions) {
+		o.lg = lg
+	}
+}
+
+// Interval at which updates from other replicas are read. Defaults to 5s
+func WithSharedPollInterval(interval time.Duration) SharedUpdaterOption {
+	return func(o *SharedUpdaterOptions) {
+		o.pollInterval = interval
+	}
+}
+
+func NewSharedUpdater(
+	local HealthStatusUpdater,
+	store storage.KeyValueStore,
+	sessions SessionOwnership,
+	opts ...SharedUpdaterOption,
+) *SharedUpdater {
+	options := SharedUpdaterOptions{
+		lg:           zap.NewNop().Sugar(),
+		pollInterval: 5 * time.Second,
+	}
+	options.apply(opts...)
+	return &SharedUpdater{
+		SharedUpdaterOptions: options,
+		local:                local,
+		store:                store,
+		sessions:             sessions,
+		statusUpdate:         make(chan StatusUpdate, cap(local.StatusC())),
+		healthUpdate:         make(chan HealthUpdate, cap(local.HealthC())),
+		lastStatus:           make(map[string]time.Time),
+		lastHealth:           make(map[string]time.Time),
+	}
+}
+
+func (u *SharedUpdater) StatusC() chan StatusUpdate {
+	return u.statusUpdate
+}
+
+func (u *SharedUpdater) HealthC() chan HealthUpdate {
+	return u.healthUpdate
+}
+
+// Run forwards local updates and polls for updates from other replicas until
+// the context is canceled or the local updater's channels are closed.
+func (u *SharedUpdater) Run(ctx context.Context) {
+	defer close(u.statusUpdate)
+	defer close(u.healthUpdate)
+
+	ticker := time.NewTicker(u.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-u.local.StatusC():
+			if !ok {
+				return
+			}
+			if u.sessions.IsRemote(update.ID) {
+				u.lg.With("id", update.ID).Debug("dropping status update for agent connected to another replica")
+				continue
+			}
+			u.publish(ctx, path.Join(sharedStatusPrefix, update.ID), update.Status)
+			u.emitStatus(update)
+		case update, ok := <-u.local.HealthC():
+			if !ok {
+				return
+			}
+			if u.sessions.IsRemote(update.ID) {
+				u.lg.With("id", update.ID).Debug("dropping health update for agent connected to another replica")
+				continue
+			}
+			u.publish(ctx, path.Join(sharedHealthPrefix, update.ID), update.Health)
+			u.emitHealth(update)
+		case <-ticker.C:
+			if err := u.poll(ctx); err != nil {
+				u.lg.With(
+					zap.Error(err),
+				).Warn("failed to read shared health status")
+			}
+		}
+	}
+}
+
+// MarkDisconnected publishes a disconnected status for the given agent, for
+// use when the replica holding its session has stopped without reporting the
+// agent as disconnected. The update is emitted on the next poll.
+func (u *SharedUpdater) MarkDisconnected(ctx context.Context, id string) {
+	now := timestamppb.Now()
+	u.publish(ctx, path.Join(sharedHealthPrefix, id), &corev1.Health{
+		Timestamp: now,
+		Ready:     false,
+	})
+	u.publish(ctx, path.Join(sharedStatusPrefix, id), &corev1.Status{
+		Timestamp: now,
+		Connected: false,
+	})
+}
+
+func (u *SharedUpdater) publish(ctx context.Context, key string, msg proto.Message) {
+	data, err := proto.Marshal(msg)
+	if err == nil {
+		err = u.store.Put(ctx, key, data)
+	}
+	if err != nil {
+		u.lg.With(
+			"key", key,
+			zap.Error(err),
+		).Warn("failed to publish health status")
+	}
+}
+
+func (u *SharedUpdater) emitStatus(update StatusUpdate) {
+	if !u.advance(u.lastStatus, update.ID, update.Status.GetTimestamp()) {
+		return
+	}
+	u.statusUpdate <- update
+}
+
+func (u *SharedUpdater) emitHealth(update HealthUpdate) {
+	if !u.advance(u.lastHealth, update.ID, update.Health.GetTimestamp()) {
+		return
+	}
+	u.healthUpdate <- update
+}
+
+// advance records ts as the latest timestamp seen for id, and reports whether
+// it is newer than the previous one.
+func (u *SharedUpdater) advance(last map[string]time.Time, id string, ts *timestamppb.Timestamp) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	t := ts.AsTime()
+	if prev, ok := last[id]; ok && !t.After(prev) {
+		return false
+	}
+	last[id] = t
+	return true
+}
+
+func (u *SharedUpdater) poll(ctx context.Context) error {
+	statusKeys, err := u.store.ListKeys(ctx, sharedStatusPrefix+"/")
+	if err != nil {
+		return err
+	}
+	for _, key := range statusKeys {
+		status := &corev1.Status{}
+		if ok, err := u.get(ctx, key, status); err != nil {
+			return err
+		} else if ok {
+			u.emitStatus(StatusUpdate{ID: path.Base(key), Status: status})
+		}
+	}
+	healthKeys, err := u.store.ListKeys(ctx, sharedHealthPrefix+"/")
+	if err != nil {
+		return err
+	}
+	for _, key := range healthKeys {
+		health := &corev1.Health{}
+		if ok, err := u.get(ctx, key, health); err != nil {
+			return err
+		} else if ok {
+			u.emitHealth(HealthUpdate{ID: path.Base(key), Health: health})
+		}
+	}
+	return nil
+}
+
+func (u *SharedUpdater) get(ctx context.Context, key string, msg proto.Message) (bool, error) {
+	data, err := u.store.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,142 @@
+package health_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/health"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/test"
+)
+
+type testUpdater struct {
+	statusC chan health.StatusUpdate
+	healthC chan health.HealthUpdate
+}
+
+func newTestUpdater() *testUpdater {
+	return &testUpdater{
+		statusC: make(chan health.StatusUpdate, 10),
+		healthC: make(chan health.HealthUpdate, 10),
+	}
+}
+
+func (u *testUpdater) StatusC() chan health.StatusUpdate { return u.statusC }
+func (u *testUpdater) HealthC() chan health.HealthUpdate { return u.healthC }
+
+type testOwnership struct {
+	mu     sync.Mutex
+	remote map[string]bool
+}
+
+func (o *testOwnership) IsRemote(id string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.remote[id]
+}
+
+func (o *testOwnership) setRemote(id string, remote bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.remote[id] = remote
+}
+
+var _ = Describe("Shared Updater", Label("unit"), func() {
+	var (
+		ctx       context.Context
+		kv        storage.KeyValueStore
+		ownership *testOwnership
+	)
+	BeforeEach(func() {
+		var ca context.CancelFunc
+		ctx, ca = context.WithCancel(context.Background())
+		DeferCleanup(ca)
+		ctrl := gomock.NewController(GinkgoT())
+		kv = test.NewTestKeyValueStore(ctrl, slices.Clone[[]byte])
+		ownership = &testOwnership{remote: map[string]bool{}}
+	})
+
+	newUpdater := func(local health.HealthStatusUpdater) *health.SharedUpdater {
+		u := health.NewSharedUpdater(local, kv, ownership,
+			health.WithSharedPollInterval(25*time.Millisecond),
+		)
+		go u.Run(ctx)
+		return u
+	}
+	status := func(id string, connected bool) health.StatusUpdate {
+		return health.StatusUpdate{
+			ID: id,
+			Status: &corev1.Status{
+				Timestamp: timestamppb.Now(),
+				Connected: connected,
+			},
+		}
+	}
+
+	It("should forward updates from the local updater", func() {
+		local := newTestUpdater()
+		u := newUpdater(local)
+
+		local.statusC <- status("agent1", true)
+		local.healthC <- health.HealthUpdate{
+			ID:     "agent1",
+			Health: &corev1.Health{Timestamp: timestamppb.Now(), Ready: true},
+		}
+		Eventually(u.StatusC()).Should(Receive(WithTransform(func(up health.StatusUpdate) bool {
+			return up.Status.Connected
+		}, BeTrue())))
+		Eventually(u.HealthC()).Should(Receive(WithTransform(func(up health.HealthUpdate) bool {
+			return up.Health.Ready
+		}, BeTrue())))
+		Consistently(u.StatusC()).ShouldNot(Receive())
+	})
+
+	It("should emit updates published by other replicas", func() {
+		localA, localB := newTestUpdater(), newTestUpdater()
+		a := newUpdater(localA)
+		b := newUpdater(localB)
+
+		localB.statusC <- status("agent1", true)
+		Eventually(b.StatusC()).Should(Receive())
+		Eventually(a.StatusC()).Should(Receive(WithTransform(func(up health.StatusUpdate) string {
+			return up.ID
+		}, Equal("agent1"))))
+	})
+
+	It("should drop updates for agents connected to another replica", func() {
+		localA, localB := newTestUpdater(), newTestUpdater()
+		a := newUpdater(localA)
+		b := newUpdater(localB)
+
+		// the agent connects to replica b, and replica a notices its old
+		// connection was closed afterwards
+		localB.statusC <- status("agent1", true)
+		Eventually(b.StatusC()).Should(Receive())
+		Eventually(a.StatusC()).Should(Receive())
+		ownership.setRemote("agent1", true)
+		localA.statusC <- status("agent1", false)
+
+		Consistently(a.StatusC()).ShouldNot(Receive())
+		Consistently(b.StatusC()).ShouldNot(Receive())
+	})
+
+	It("should mark agents as disconnected", func() {
+		local := newTestUpdater()
+		u := newUpdater(local)
+		local.statusC <- status("agent1", true)
+		Eventually(u.StatusC()).Should(Receive())
+
+		u.MarkDisconnected(ctx, "agent1")
+		Eventually(u.StatusC()).Should(Receive(WithTransform(func(up health.StatusUpdate) bool {
+			return up.Status.Connected
+		}, BeFalse())))
+	})
+})
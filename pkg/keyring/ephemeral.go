@@ -1,7 +1,9 @@
 package keyring
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"io"
 
 	"github.com/go-playground/validator/v10"
@@ -29,6 +31,18 @@ func (e *EphemeralKey) Validate() error {
 	return nil
 }
 
+// SigningKey returns the ed25519 private key whose seed is the key's secret.
+// The key's usage must be Signing.
+func (e *EphemeralKey) SigningKey() (ed25519.PrivateKey, error) {
+	if e.Usage != Signing {
+		return nil, errors.New("not a signing key")
+	}
+	if len(e.Secret) != ed25519.SeedSize {
+		return nil, errors.New("invalid key length")
+	}
+	return ed25519.NewKeyFromSeed(e.Secret), nil
+}
+
 func LoadEphemeralKey(r io.Reader) (*EphemeralKey, error) {
 	var key EphemeralKey
 	if err := json.NewDecoder(r).Decode(&key); err != nil {
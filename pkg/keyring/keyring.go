@@ -13,10 +13,11 @@ var (
 var allowedKeyTypes = map[reflect.Type]struct{}{}
 
 type completeKeyring struct {
-	SharedKeys   []*SharedKeys   `json:"sharedKeys,omitempty"`
-	PKPKey       []*PKPKey       `json:"pkpKey,omitempty"`
-	CACertsKey   []*CACertsKey   `json:"caCertsKey,omitempty"`
-	EphemeralKey []*EphemeralKey `json:"-"`
+	SharedKeys          []*SharedKeys          `json:"sharedKeys,omitempty"`
+	PKPKey              []*PKPKey              `json:"pkpKey,omitempty"`
+	CACertsKey          []*CACertsKey          `json:"caCertsKey,omitempty"`
+	PendingBootstrapKey []*PendingBootstrapKey `json:"pendingBootstrapKey,omitempty"`
+	EphemeralKey        []*EphemeralKey        `json:"-"`
 }
 
 func init() {
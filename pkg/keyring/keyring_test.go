@@ -1,6 +1,7 @@
 package keyring_test
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/base64"
@@ -244,4 +245,21 @@ var _ = Describe("Keyring", Label("unit"), func() {
 		Expect(err).NotTo(HaveOccurred())
 		Expect(actual).To(Equal(expected))
 	})
+	It("should derive ed25519 keys from signing keys", func() {
+		seed := make([]byte, ed25519.SeedSize)
+		_, err := rand.Read(seed)
+		Expect(err).NotTo(HaveOccurred())
+
+		key := &keyring.EphemeralKey{
+			Usage:  keyring.Signing,
+			Secret: seed,
+		}
+		signingKey, err := key.SigningKey()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signingKey.Equal(ed25519.NewKeyFromSeed(seed))).To(BeTrue())
+
+		key.Usage = keyring.Encryption
+		_, err = key.SigningKey()
+		Expect(err).To(HaveOccurred())
+	})
 })
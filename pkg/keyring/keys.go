@@ -3,6 +3,7 @@ package keyring
 import (
 	"crypto/ed25519"
 	"crypto/x509"
+	"time"
 
 	"github.com/rancher/opni/pkg/pkp"
 	"golang.org/x/exp/slices"
@@ -14,6 +15,32 @@ import (
 type SharedKeys struct {
 	ClientKey ed25519.PrivateKey `json:"clientKey"`
 	ServerKey ed25519.PrivateKey `json:"serverKey"`
+	// If set, the keys are only valid until this time. This is used to keep
+	// previous keys valid for a short time after a key rotation.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// If set, the keys were negotiated by a key rotation which the agent has
+	// not yet been seen using. The agent's other keys remain valid until it
+	// is, after which they expire once this grace period ends.
+	PendingGracePeriod *time.Duration `json:"pendingGracePeriod,omitempty"`
+}
+
+// Expired reports whether the keys have an expiration time that is before
+// the given time.
+func (sk *SharedKeys) Expired(now time.Time) bool {
+	return sk.ExpiresAt != nil && now.After(*sk.ExpiresAt)
+}
+
+// Equal reports whether both shared keys contain the same key pair.
+func (sk *SharedKeys) Equal(other *SharedKeys) bool {
+	return other != nil && sk.ClientKey.Equal(other.ClientKey) && sk.ServerKey.Equal(other.ServerKey)
+}
+
+// PendingBootstrapKey holds the public key sent by an agent when it joined a
+// cluster which requires approval. The agent's shared keys are not negotiated
+// until the cluster is approved and the agent repeats its request using the
+// same public key.
+type PendingBootstrapKey struct {
+	ClientPubKey []byte `json:"clientPubKey"`
 }
 
 type PKPKey struct {
@@ -0,0 +1,25 @@
+package management
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/validation"
+)
+
+func (m *Server) ListAuditEvents(
+	ctx context.Context,
+	in *managementv1.ListAuditEventsRequest,
+) (*managementv1.AuditEventList, error) {
+	if err := validation.Validate(in); err != nil {
+		return nil, err
+	}
+	list, err := m.auditLog.List(ctx, in)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return list, nil
+}
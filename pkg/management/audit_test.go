@@ -0,0 +1,179 @@
+package management_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/auth"
+	authtest "github.com/rancher/opni/pkg/auth/test"
+	"github.com/rancher/opni/pkg/keyring"
+	"github.com/rancher/opni/pkg/management"
+	"github.com/rancher/opni/pkg/plugins"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/validation"
+)
+
+var _ = Describe("Audit Log", Ordered, Label("unit"), func() {
+	var tv *testVars
+	BeforeAll(setupManagementServer(&tv, plugins.NoopLoader, management.WithAuthMiddleware(&authtest.TestAuthMiddleware{
+		Strategy: authtest.AuthStrategyUserIDInAuthHeader,
+	})))
+	BeforeAll(func() {
+		Expect(tv.storageBackend.CreateRole(context.Background(), &corev1.Role{
+			Id: "admin",
+			Rules: []*corev1.PolicyRule{{
+				Verbs:     []string{corev1.Wildcard},
+				Resources: []string{corev1.Wildcard},
+			}},
+		})).To(Succeed())
+		Expect(tv.storageBackend.CreateRoleBinding(context.Background(), &corev1.RoleBinding{
+			Id:       "admin",
+			RoleId:   "admin",
+			Subjects: []string{"alice", "bob"},
+		})).To(Succeed())
+	})
+
+	asUser := func(user string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), auth.AuthorizationKey, user)
+	}
+
+	It("should reject unauthenticated requests", func() {
+		_, err := tv.client.ListAuditEvents(context.Background(), &managementv1.ListAuditEventsRequest{})
+		Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+	})
+
+	It("should record mutating requests", func() {
+		token, err := tv.client.CreateBootstrapToken(asUser("alice"), &managementv1.CreateBootstrapTokenRequest{
+			Ttl: durationpb.New(time.Minute),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tv.client.RevokeBootstrapToken(asUser("bob"), token.Reference())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tv.client.DeleteCluster(asUser("bob"), &corev1.Reference{Id: "does-not-exist"})
+		Expect(err).To(HaveOccurred())
+
+		events, err := tv.client.ListAuditEvents(asUser("alice"), &managementv1.ListAuditEventsRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events.Items).To(HaveLen(3))
+
+		Expect(events.Items[0].Subject).To(Equal("bob"))
+		Expect(events.Items[0].Method).To(Equal("/management.Management/DeleteCluster"))
+		Expect(codes.Code(events.Items[0].Status.GetCode())).To(Equal(codes.NotFound))
+		Expect(events.Items[0].Targets).To(HaveLen(1))
+		Expect(events.Items[0].Targets[0].Id).To(Equal("does-not-exist"))
+
+		Expect(events.Items[1].Subject).To(Equal("bob"))
+		Expect(events.Items[1].Method).To(Equal("/management.Management/RevokeBootstrapToken"))
+		Expect(events.Items[1].Targets[0].Id).To(Equal(token.TokenID))
+
+		Expect(events.Items[2].Subject).To(Equal("alice"))
+		Expect(events.Items[2].Method).To(Equal("/management.Management/CreateBootstrapToken"))
+		Expect(codes.Code(events.Items[2].Status.GetCode())).To(Equal(codes.OK))
+		Expect(events.Items[2].Peer).NotTo(BeEmpty())
+	})
+
+	It("should not record read-only requests", func() {
+		_, err := tv.client.ListBootstrapTokens(asUser("alice"), &emptypb.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+		events, err := tv.client.ListAuditEvents(asUser("alice"), &managementv1.ListAuditEventsRequest{
+			Method: "List",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events.Items).To(BeEmpty())
+	})
+
+	It("should filter events", func() {
+		events, err := tv.client.ListAuditEvents(asUser("alice"), &managementv1.ListAuditEventsRequest{
+			Subject:    "bob",
+			ErrorsOnly: true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events.Items).To(HaveLen(1))
+		Expect(events.Items[0].Method).To(Equal("/management.Management/DeleteCluster"))
+	})
+
+	It("should validate requests", func() {
+		_, err := tv.client.ListAuditEvents(asUser("alice"), &managementv1.ListAuditEventsRequest{
+			Limit: -1,
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(validation.ErrInvalidValue.Error()))
+	})
+})
+
+type testStaleClusterDataSource struct {
+	deleter func(ctx context.Context, ref *corev1.Reference) error
+}
+
+func (s *testStaleClusterDataSource) GetStaleClusterReport(context.Context) (*managementv1.StaleClusterReport, error) {
+	return &managementv1.StaleClusterReport{}, nil
+}
+
+func (s *testStaleClusterDataSource) SetClusterDeleter(deleter func(ctx context.Context, ref *corev1.Reference) error) {
+	s.deleter = deleter
+}
+
+var _ = Describe("Stale Cluster Deletion", Ordered, Label("unit"), func() {
+	var tv *testVars
+	staleClusters := &testStaleClusterDataSource{}
+	BeforeAll(setupManagementServer(&tv, plugins.NoopLoader,
+		management.WithAuthMiddleware(&authtest.TestAuthMiddleware{
+			Strategy: authtest.AuthStrategyUserIDInAuthHeader,
+		}),
+		management.WithStaleClusterDataSource(staleClusters),
+	))
+	BeforeAll(func() {
+		Expect(tv.storageBackend.CreateRole(context.Background(), &corev1.Role{
+			Id: "admin",
+			Rules: []*corev1.PolicyRule{{
+				Verbs:     []string{corev1.Wildcard},
+				Resources: []string{corev1.Wildcard},
+			}},
+		})).To(Succeed())
+		Expect(tv.storageBackend.CreateRoleBinding(context.Background(), &corev1.RoleBinding{
+			Id:       "admin",
+			RoleId:   "admin",
+			Subjects: []string{"alice"},
+		})).To(Succeed())
+	})
+
+	It("should delete clusters in the same way as the DeleteCluster API", func() {
+		ctx := context.Background()
+		cluster := &corev1.Cluster{Id: "stale"}
+		Expect(tv.storageBackend.CreateCluster(ctx, cluster)).To(Succeed())
+		for _, namespace := range []string{"gateway", "gateway-pending"} {
+			kr := keyring.New(&keyring.PendingBootstrapKey{ClientPubKey: make([]byte, 32)})
+			Expect(tv.storageBackend.KeyringStore(namespace, cluster.Reference()).Put(ctx, kr)).To(Succeed())
+		}
+
+		Expect(staleClusters.deleter).NotTo(BeNil())
+		Expect(staleClusters.deleter(ctx, cluster.Reference())).To(Succeed())
+
+		_, err := tv.storageBackend.GetCluster(ctx, cluster.Reference())
+		Expect(err).To(MatchError(storage.ErrNotFound))
+		for _, namespace := range []string{"gateway", "gateway-pending"} {
+			_, err := tv.storageBackend.KeyringStore(namespace, cluster.Reference()).Get(ctx)
+			Expect(err).To(MatchError(storage.ErrNotFound))
+		}
+
+		events, err := tv.client.ListAuditEvents(
+			metadata.AppendToOutgoingContext(ctx, auth.AuthorizationKey, "alice"),
+			&managementv1.ListAuditEventsRequest{},
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events.Items).To(HaveLen(1))
+		Expect(events.Items[0].Subject).To(Equal("system:stale-cluster-policy"))
+		Expect(events.Items[0].Method).To(Equal("/management.Management/DeleteCluster"))
+		Expect(events.Items[0].Targets[0].Id).To(Equal("stale"))
+	})
+})
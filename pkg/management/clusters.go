@@ -4,19 +4,30 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	capabilityv1 "github.com/rancher/opni/pkg/apis/capability/v1"
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
 	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/rbac"
 	"github.com/rancher/opni/pkg/storage"
 	"github.com/rancher/opni/pkg/validation"
 	"github.com/samber/lo"
 	"golang.org/x/exp/maps"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
+const (
+	defaultKeyRotationGracePeriod = 5 * time.Minute
+
+	// The subject recorded in the audit log for clusters deleted by the stale
+	// cluster policy.
+	staleClusterSubject = "system:stale-cluster-policy"
+)
+
 func (m *Server) ListClusters(
 	ctx context.Context,
 	in *managementv1.ListClustersRequest,
@@ -46,11 +57,14 @@ func (m *Server) DeleteCluster(
 	if len(capabilities) > 0 {
 		return nil, status.Error(codes.FailedPrecondition, "cannot delete a cluster with capabilities; uninstall the capabilities first")
 	}
-	// delete the cluster's keyring, if it exists
-	store := m.coreDataSource.StorageBackend().KeyringStore("gateway", cluster.Reference())
-	if err := store.Delete(ctx); err != nil {
-		if !errors.Is(err, storage.ErrNotFound) {
-			return nil, fmt.Errorf("failed to delete keyring store for cluster %s: %w", cluster.Id, err)
+	// delete the cluster's keyring, and its pending keyring if it was never
+	// approved, if they exist
+	for _, namespace := range []string{"gateway", "gateway-pending"} {
+		store := m.coreDataSource.StorageBackend().KeyringStore(namespace, cluster.Reference())
+		if err := store.Delete(ctx); err != nil {
+			if !errors.Is(err, storage.ErrNotFound) {
+				return nil, fmt.Errorf("failed to delete keyring store for cluster %s: %w", cluster.Id, err)
+			}
 		}
 	}
 
@@ -62,6 +76,44 @@ func (m *Server) DeleteCluster(
 	return &emptypb.Empty{}, nil
 }
 
+// deleteStaleCluster deletes a cluster on behalf of the stale cluster policy.
+// The request is recorded in the audit log like a DeleteCluster request made
+// through the API, with staleClusterSubject as the subject.
+func (m *Server) deleteStaleCluster(ctx context.Context, ref *corev1.Reference) error {
+	ctx = rbac.ContextWithAuthorizedUserID(ctx, staleClusterSubject)
+	_, err := m.auditLog.UnaryServerInterceptor(m.resolveMethod)(ctx, ref, &grpc.UnaryServerInfo{
+		Server:     m,
+		FullMethod: "/management.Management/DeleteCluster",
+	}, func(ctx context.Context, req any) (any, error) {
+		return m.DeleteCluster(ctx, req.(*corev1.Reference))
+	})
+	return err
+}
+
+func (m *Server) ApproveCluster(
+	ctx context.Context,
+	ref *corev1.Reference,
+) (*emptypb.Empty, error) {
+	if err := validation.Validate(ref); err != nil {
+		return nil, err
+	}
+	cluster, err := m.resolveCluster(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	// the agent's keys are negotiated when it repeats its bootstrap request
+	// after the cluster has been approved
+	if _, ok := cluster.GetLabels()[corev1.PendingApprovalLabel]; !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "cluster %s is not pending approval", cluster.Id)
+	}
+	if _, err := m.coreDataSource.StorageBackend().UpdateCluster(ctx, cluster.Reference(), func(c *corev1.Cluster) {
+		delete(c.GetMetadata().GetLabels(), corev1.PendingApprovalLabel)
+	}); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update cluster: %v", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
 func (m *Server) GetCluster(
 	ctx context.Context,
 	ref *corev1.Reference,
@@ -148,6 +200,69 @@ func (m *Server) EditCluster(
 	})
 }
 
+func (m *Server) RotateClusterKeys(
+	ctx context.Context,
+	in *managementv1.RotateClusterKeysRequest,
+) (*emptypb.Empty, error) {
+	if m.keyRotationDataSource == nil {
+		return nil, status.Error(codes.Unavailable, "key rotation API not configured")
+	}
+	if err := validation.Validate(in); err != nil {
+		return nil, err
+	}
+	if err := m.ensureReferenceResolved(ctx, in.Cluster); err != nil {
+		return nil, err
+	}
+
+	gracePeriod := defaultKeyRotationGracePeriod
+	if in.GracePeriod != nil {
+		gracePeriod = in.GracePeriod.AsDuration()
+	}
+	if err := m.keyRotationDataSource.RotateClusterKeys(ctx, in.Cluster, gracePeriod); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (m *Server) CollectClusterDiagnostics(
+	in *managementv1.CollectClusterDiagnosticsRequest,
+	stream managementv1.Management_CollectClusterDiagnosticsServer,
+) error {
+	if m.diagnosticsDataSource == nil {
+		return status.Error(codes.Unavailable, "diagnostics API not configured")
+	}
+	if err := validation.Validate(in); err != nil {
+		return err
+	}
+	if err := m.ensureReferenceResolved(stream.Context(), in.Cluster); err != nil {
+		return err
+	}
+	return m.diagnosticsDataSource.CollectClusterDiagnostics(stream.Context(), in.Cluster, in.LogLines, diagnosticsChunkWriter{stream})
+}
+
+type diagnosticsChunkWriter struct {
+	stream managementv1.Management_CollectClusterDiagnosticsServer
+}
+
+func (w diagnosticsChunkWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&managementv1.DiagnosticsChunk{
+		Data: p,
+	}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (m *Server) GetStaleClusterReport(
+	ctx context.Context,
+	_ *emptypb.Empty,
+) (*managementv1.StaleClusterReport, error) {
+	if m.staleClusterDataSource == nil {
+		return nil, status.Error(codes.Unavailable, "stale cluster API not configured")
+	}
+	return m.staleClusterDataSource.GetStaleClusterReport(ctx)
+}
+
 func (m *Server) InstallCapability(
 	ctx context.Context,
 	in *managementv1.CapabilityInstallRequest,
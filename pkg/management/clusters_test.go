@@ -11,6 +11,7 @@ import (
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
 	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
 	"github.com/rancher/opni/pkg/capabilities"
+	"github.com/rancher/opni/pkg/keyring"
 	"github.com/rancher/opni/pkg/management"
 	"github.com/rancher/opni/pkg/plugins"
 	"github.com/rancher/opni/pkg/storage"
@@ -298,4 +299,34 @@ var _ = Describe("Clusters", Ordered, Label("slow"), func() {
 		Expect(err).NotTo(HaveOccurred())
 		Expect(updatedC.Metadata.Labels).To(And(HaveKeyWithValue("foo", "baz"), HaveKeyWithValue("opni.io/test", "1")))
 	})
+	It("should approve clusters pending approval", func() {
+		c := &corev1.Cluster{
+			Id: "pending-1",
+			Metadata: &corev1.ClusterMetadata{
+				Labels: map[string]string{
+					corev1.PendingApprovalLabel: "true",
+				},
+			},
+		}
+		Expect(tv.storageBackend.CreateCluster(context.Background(), c)).To(Succeed())
+		kr := keyring.New(&keyring.PendingBootstrapKey{ClientPubKey: make([]byte, 32)})
+		Expect(tv.storageBackend.KeyringStore("gateway-pending", c.Reference()).Put(context.Background(), kr)).To(Succeed())
+
+		_, err := tv.client.ApproveCluster(context.Background(), c.Reference())
+		Expect(err).NotTo(HaveOccurred())
+
+		updated, err := tv.client.GetCluster(context.Background(), c.Reference())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.GetLabels()).NotTo(HaveKey(corev1.PendingApprovalLabel))
+
+		By("checking that the agent's keys are not negotiated until it bootstraps again")
+		_, err = tv.storageBackend.KeyringStore("gateway", c.Reference()).Get(context.Background())
+		Expect(err).To(MatchError(storage.ErrNotFound))
+		_, err = tv.storageBackend.KeyringStore("gateway-pending", c.Reference()).Get(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		By("checking that approved clusters cannot be approved again")
+		_, err = tv.client.ApproveCluster(context.Background(), c.Reference())
+		Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+	})
 })
@@ -16,6 +16,7 @@ import (
 	"github.com/jhump/protoreflect/grpcreflect"
 	gsync "github.com/kralicky/gpkg/sync"
 	"github.com/kralicky/grpc-gateway/v2/runtime"
+	"github.com/samber/lo"
 	"go.uber.org/zap"
 	"google.golang.org/genproto/googleapis/api/annotations"
 	statuspb "google.golang.org/genproto/googleapis/rpc/status"
@@ -25,6 +26,10 @@ import (
 	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
@@ -62,6 +67,7 @@ type StreamDirector func(ctx context.Context, fullMethodName string) (context.Co
 func (m *Server) configureApiExtensionDirector(ctx context.Context, pl plugins.LoaderInterface) StreamDirector {
 	lg := m.logger
 	methodTable := gsync.Map[string, *UnknownStreamMetadata]{}
+	pluginMethods := gsync.Map[string, []string]{}
 	pl.Hook(hooks.OnLoadMC(func(p types.ManagementAPIExtensionPlugin, md meta.PluginMeta, cc *grpc.ClientConn) {
 		reflectClient := grpcreflect.NewClient(ctx, rpb.NewServerReflectionClient(cc))
 		sds, err := p.Descriptors(ctx, &emptypb.Empty{})
@@ -72,6 +78,10 @@ func (m *Server) configureApiExtensionDirector(ctx context.Context, pl plugins.L
 			).Error("failed to get extension descriptors")
 			return
 		}
+		var methods []string
+		defer func() {
+			pluginMethods.Store(md.Module, methods)
+		}()
 		for _, sd := range sds.Items {
 			lg.Info("got extension descriptor for service " + sd.GetName())
 
@@ -87,12 +97,19 @@ func (m *Server) configureApiExtensionDirector(ctx context.Context, pl plugins.L
 			lg.With(
 				"name", svcName,
 			).Info("loading service")
+			if err := m.registerExtensionMethods(svcDesc); err != nil {
+				lg.With(
+					zap.Error(err),
+					"name", svcName,
+				).Warn("failed to load method descriptors for service; all methods will be audited")
+			}
 			for _, mtd := range svcDesc.GetMethods() {
 				fullName := fmt.Sprintf("/%s/%s", svcName, mtd.GetName())
 				lg.With(
 					"name", fullName,
 				).Info("loading method")
 
+				methods = append(methods, fullName)
 				methodTable.Store(fullName, &UnknownStreamMetadata{
 					Conn:            cc,
 					InputType:       mtd.GetInputType(),
@@ -120,6 +137,7 @@ func (m *Server) configureApiExtensionDirector(ctx context.Context, pl plugins.L
 			client := apiextensions.NewManagementAPIExtensionClient(cc)
 			m.apiExtMu.Lock()
 			m.apiExtensions = append(m.apiExtensions, apiExtension{
+				module:      md.Module,
 				client:      client,
 				clientConn:  cc,
 				serviceDesc: svcDesc,
@@ -128,6 +146,21 @@ func (m *Server) configureApiExtensionDirector(ctx context.Context, pl plugins.L
 			m.apiExtMu.Unlock()
 		}
 	}))
+	pl.Hook(hooks.OnUnloadM(func(_ types.ManagementAPIExtensionPlugin, md meta.PluginMeta) {
+		methods, _ := pluginMethods.LoadAndDelete(md.Module)
+		for _, fullName := range methods {
+			methodTable.Delete(fullName)
+		}
+		m.apiExtMu.Lock()
+		m.apiExtensions = lo.Reject(m.apiExtensions, func(ext apiExtension, _ int) bool {
+			return ext.module == md.Module
+		})
+		m.apiExtMu.Unlock()
+		lg.With(
+			"plugin", md.Module,
+			"methods", len(methods),
+		).Info("removed API extension")
+	}))
 
 	return func(ctx context.Context, fullMethodName string) (context.Context, *UnknownStreamMetadata, error) {
 		if conn, ok := methodTable.Load(fullMethodName); ok {
@@ -139,12 +172,67 @@ func (m *Server) configureApiExtensionDirector(ctx context.Context, pl plugins.L
 	}
 }
 
-func (m *Server) configureHttpApiExtensions(mux *runtime.ServeMux) {
+// resolveMethod looks up the descriptor for a method in the core management
+// API or in a loaded API extension.
+func (m *Server) resolveMethod(fullMethod string) (protoreflect.MethodDescriptor, bool) {
+	name := protoreflect.FullName(strings.Replace(strings.TrimPrefix(fullMethod, "/"), "/", ".", 1))
+	if d, err := protoregistry.GlobalFiles.FindDescriptorByName(name); err == nil {
+		if md, ok := d.(protoreflect.MethodDescriptor); ok {
+			return md, true
+		}
+	}
+	m.extensionMethodMu.RLock()
+	defer m.extensionMethodMu.RUnlock()
+	md, ok := m.extensionMethods[fullMethod]
+	return md, ok
+}
+
+// registerExtensionMethods converts the descriptors of an API extension
+// service (which are obtained via reflection) into protoreflect descriptors,
+// and stores them for later use by resolveMethod.
+func (m *Server) registerExtensionMethods(svcDesc *desc.ServiceDescriptor) error {
+	fds := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]struct{}{}
+	var addFile func(*desc.FileDescriptor)
+	addFile = func(fd *desc.FileDescriptor) {
+		if _, ok := seen[fd.GetName()]; ok {
+			return
+		}
+		seen[fd.GetName()] = struct{}{}
+		for _, dep := range fd.GetDependencies() {
+			addFile(dep)
+		}
+		fds.File = append(fds.File, fd.AsFileDescriptorProto())
+	}
+	addFile(svcDesc.GetFile())
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return err
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(svcDesc.GetFullyQualifiedName()))
+	if err != nil {
+		return err
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a service", svcDesc.GetFullyQualifiedName())
+	}
+	m.extensionMethodMu.Lock()
+	defer m.extensionMethodMu.Unlock()
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		m.extensionMethods[fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())] = md
+	}
+	return nil
+}
+
+func (m *Server) configureHttpApiExtensions(mux *runtime.ServeMux, cc grpcdynamic.Channel) {
 	lg := m.logger
 	m.apiExtMu.RLock()
 	defer m.apiExtMu.RUnlock()
+	stub := grpcdynamic.NewStub(cc)
 	for _, ext := range m.apiExtensions {
-		stub := grpcdynamic.NewStub(ext.clientConn)
 		svcDesc := ext.serviceDesc
 		for _, rule := range ext.httpRules {
 			var method string
@@ -15,8 +15,10 @@ import (
 	"github.com/samber/lo"
 	"go.uber.org/atomic"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/known/emptypb"
 
@@ -49,9 +51,11 @@ var _ = Describe("Extensions", Ordered, Label("slow"), func() {
 	var descriptorLogic func() (*apiextensions.ServiceDescriptorProtoList, error)
 	shouldLoadExt1 := atomic.NewBool(true)
 	shouldLoadExt2 := atomic.NewBool(false)
+	var pl *plugins.PluginLoader
+	var loadExt1 func()
 	JustBeforeEach(func() {
 		tv = &testVars{}
-		pl := plugins.NewPluginLoader()
+		pl = plugins.NewPluginLoader()
 		tv.ctrl = gomock.NewController(GinkgoT())
 		extSrv := mock_ext.NewMockExtServer(tv.ctrl)
 		extSrv.EXPECT().
@@ -130,7 +134,7 @@ var _ = Describe("Extensions", Ordered, Label("slow"), func() {
 
 		// Loading the plugins after installing the hooks ensures LoadOne will block
 		// until all hooks return.
-		if shouldLoadExt1.Load() {
+		loadExt1 = func() {
 			apiextSrv := &apiExtensionSrvImpl{
 				MockManagementAPIExtensionServer: mock_apiextensions.NewMockManagementAPIExtensionServer(tv.ctrl),
 			}
@@ -158,6 +162,9 @@ var _ = Describe("Extensions", Ordered, Label("slow"), func() {
 				Module:     "test1",
 			}, cc)
 		}
+		if shouldLoadExt1.Load() {
+			loadExt1()
+		}
 
 		if shouldLoadExt2.Load() {
 			apiextSrv2 := &apiExtensionSrvImpl{
@@ -215,6 +222,39 @@ var _ = Describe("Extensions", Ordered, Label("slow"), func() {
 		Expect(extensions.Items[0].Rules[7].Http.GetPost()).To(Equal("/baz"))
 
 	})
+	It("should remove API extensions when the plugin is unloaded", func() {
+		cc, err := grpc.Dial(tv.grpcEndpoint,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithBlock(),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		defer cc.Close()
+		client := ext.NewExtClient(cc)
+
+		ctx, ca := context.WithTimeout(context.Background(), 5*time.Second)
+		defer ca()
+		Expect(pl.Unload(ctx, "test1")).To(BeTrue())
+		Expect(pl.Unload(ctx, "test1")).To(BeFalse())
+
+		extensions, err := tv.client.APIExtensions(context.Background(), &emptypb.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(extensions.Items).To(BeEmpty())
+		_, err = client.Foo(context.Background(), &ext.FooRequest{
+			Request: "hello",
+		})
+		Expect(status.Code(err)).To(Equal(codes.Unimplemented))
+
+		By("loading the plugin again")
+		loadExt1()
+		extensions, err = tv.client.APIExtensions(context.Background(), &emptypb.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(extensions.Items).To(HaveLen(1))
+		resp, err := client.Foo(context.Background(), &ext.FooRequest{
+			Request: "hello",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Response).To(Equal("HELLO"))
+	})
 	It("should forward gRPC calls to the plugin", func() {
 		cc, err := grpc.Dial(tv.grpcEndpoint,
 			grpc.WithTransportCredentials(insecure.NewCredentials()),
@@ -25,6 +25,22 @@ func (m *Server) GetClusterHealthStatus(
 	return m.healthStatusDataSource.GetClusterHealthStatus(ref)
 }
 
+func (m *Server) GetClusterHealthHistory(
+	ctx context.Context,
+	in *managementv1.ClusterHealthHistoryRequest,
+) (*managementv1.ClusterHealthHistory, error) {
+	if m.healthHistoryDataSource == nil {
+		return nil, status.Error(codes.Unavailable, "health history API not configured")
+	}
+	if err := validation.Validate(in); err != nil {
+		return nil, err
+	}
+	if err := m.ensureReferenceResolved(ctx, in.Cluster); err != nil {
+		return nil, err
+	}
+	return m.healthHistoryDataSource.GetClusterHealthHistory(ctx, in)
+}
+
 func (m *Server) WatchClusterHealthStatus(
 	_ *emptypb.Empty,
 	stream managementv1.Management_WatchClusterHealthStatusServer,
@@ -0,0 +1,333 @@
+package management
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/audit"
+	"github.com/rancher/opni/pkg/rbac"
+	"github.com/rancher/opni/pkg/util"
+)
+
+type methodPermission struct {
+	verb     string
+	resource string
+	// Path to the field in the request containing a reference to the cluster
+	// the request applies to, if any. The path "." refers to the request
+	// message itself.
+	clusterField string
+	// Path to the field in the request containing the name of the capability
+	// the request applies to, if any.
+	capabilityField string
+	// Set for methods which do not refer to a specific cluster, and only
+	// return data for clusters the subject has access to. See
+	// rbac.Permission.AnyCluster.
+	anyCluster bool
+}
+
+// Management API methods which can be called by any authenticated subject.
+var publicManagementMethods = map[string]struct{}{
+	"CertsInfo":            {},
+	"APIExtensions":        {},
+	"GetDashboardSettings": {},
+}
+
+// Permissions required to call management API methods. Methods which are
+// not listed here or in publicManagementMethods require full access.
+var managementPermissions = map[string]methodPermission{
+	"CreateBootstrapToken":      {verb: corev1.VerbCreate, resource: corev1.ResourceTokens},
+	"RevokeBootstrapToken":      {verb: corev1.VerbDelete, resource: corev1.ResourceTokens},
+	"ListBootstrapTokens":       {verb: corev1.VerbList, resource: corev1.ResourceTokens},
+	"GetBootstrapToken":         {verb: corev1.VerbGet, resource: corev1.ResourceTokens},
+	"ListClusters":              {verb: corev1.VerbList, resource: corev1.ResourceClusters, anyCluster: true},
+	"WatchClusters":             {verb: corev1.VerbList, resource: corev1.ResourceClusters, anyCluster: true},
+	"DeleteCluster":             {verb: corev1.VerbDelete, resource: corev1.ResourceClusters, clusterField: "."},
+	"RotateClusterKeys":         {verb: corev1.VerbUpdate, resource: corev1.ResourceClusters, clusterField: "cluster"},
+	"CollectClusterDiagnostics": {verb: corev1.VerbGet, resource: corev1.ResourceClusters, clusterField: "cluster"},
+	"GetStaleClusterReport":     {verb: corev1.VerbList, resource: corev1.ResourceClusters, anyCluster: true},
+	"GetPluginRolloutStatus":    {verb: corev1.VerbList, resource: corev1.ResourceClusters, anyCluster: true},
+	"GetCluster":                {verb: corev1.VerbGet, resource: corev1.ResourceClusters, clusterField: "."},
+	"GetClusterHealthStatus":    {verb: corev1.VerbGet, resource: corev1.ResourceClusters, clusterField: "."},
+	"GetClusterHealthHistory":   {verb: corev1.VerbGet, resource: corev1.ResourceClusters, clusterField: "cluster"},
+	"WatchClusterHealthStatus":  {verb: corev1.VerbList, resource: corev1.ResourceClusters, anyCluster: true},
+	"EditCluster":               {verb: corev1.VerbUpdate, resource: corev1.ResourceClusters, clusterField: "cluster"},
+	"ApproveCluster":            {verb: corev1.VerbUpdate, resource: corev1.ResourceClusters, clusterField: "."},
+	"CreateRole":                {verb: corev1.VerbCreate, resource: corev1.ResourceRoles},
+	"DeleteRole":                {verb: corev1.VerbDelete, resource: corev1.ResourceRoles},
+	"GetRole":                   {verb: corev1.VerbGet, resource: corev1.ResourceRoles},
+	"ListRoles":                 {verb: corev1.VerbList, resource: corev1.ResourceRoles},
+	"CreateRoleBinding":         {verb: corev1.VerbCreate, resource: corev1.ResourceRoleBindings},
+	"DeleteRoleBinding":         {verb: corev1.VerbDelete, resource: corev1.ResourceRoleBindings},
+	"GetRoleBinding":            {verb: corev1.VerbGet, resource: corev1.ResourceRoleBindings},
+	"ListRoleBindings":          {verb: corev1.VerbList, resource: corev1.ResourceRoleBindings},
+	"SubjectAccess":             {verb: corev1.VerbGet, resource: corev1.ResourceRoleBindings},
+	"GetConfig":                 {verb: corev1.VerbGet, resource: corev1.ResourceConfig},
+	"UpdateConfig":              {verb: corev1.VerbUpdate, resource: corev1.ResourceConfig},
+	"UpdateDashboardSettings":   {verb: corev1.VerbUpdate, resource: corev1.ResourceConfig},
+	"ListCapabilities":          {verb: corev1.VerbList, resource: corev1.ResourceCapabilities, anyCluster: true},
+	"CapabilityInstaller":       {verb: corev1.VerbGet, resource: corev1.ResourceCapabilities, capabilityField: "name", anyCluster: true},
+	"InstallCapability":         {verb: corev1.VerbCreate, resource: corev1.ResourceCapabilities, clusterField: "target.cluster", capabilityField: "name"},
+	"UninstallCapability":       {verb: corev1.VerbDelete, resource: corev1.ResourceCapabilities, clusterField: "target.cluster", capabilityField: "name"},
+	"CapabilityUninstallStatus": {verb: corev1.VerbGet, resource: corev1.ResourceCapabilities, clusterField: "cluster", capabilityField: "name"},
+	"CancelCapabilityUninstall": {verb: corev1.VerbUpdate, resource: corev1.ResourceCapabilities, clusterField: "cluster", capabilityField: "name"},
+	"ListAuditEvents":           {verb: corev1.VerbList, resource: corev1.ResourceAuditEvents},
+}
+
+type extensionScope struct {
+	resource   string
+	capability string
+	// Paths to the fields in the requests of the service which contain the
+	// clusters a request applies to. Fields may contain a cluster ID, a
+	// reference, or a list of either. If unset, defaultClusterFields is used.
+	clusterFields []string
+}
+
+// Fields containing the clusters a request applies to, for services which do
+// not list their own.
+var defaultClusterFields = []string{"cluster", "clusterId"}
+
+// Resource kinds and capability scopes of known API extension services.
+// Methods of other services use the "apiextensions" resource kind.
+//
+// Requests to cluster-scoped services which do not name any clusters require
+// access to all clusters.
+var extensionScopes = map[string]extensionScope{
+	"cortexadmin.CortexAdmin": {
+		resource:      corev1.ResourceCapabilities,
+		capability:    "metrics",
+		clusterFields: []string{"clusterId", "clusterID", "tenant", "tenants"},
+	},
+	"cortexops.CortexOps": {resource: corev1.ResourceCapabilities, capability: "metrics"},
+	// UploadImport streams are authorized using their first message, which
+	// must contain the upload header.
+	"remoteread.RemoteReadGateway": {
+		resource:      corev1.ResourceCapabilities,
+		capability:    "metrics",
+		clusterFields: []string{"clusterId", "clusterIds", "meta.clusterId", "target.meta.clusterId", "header.clusterId"},
+	},
+	// Relabel rules and quotas select clusters by label, so managing them
+	// requires access to all clusters.
+	"relabel.Relabeling":          {resource: corev1.ResourceCapabilities, capability: "metrics"},
+	"quota.IngestQuotas":          {resource: corev1.ResourceCapabilities, capability: "metrics"},
+	"loggingadmin.LoggingAdmin":   {resource: corev1.ResourceCapabilities, capability: "logging"},
+	"loggingadmin.LoggingAdminV2": {resource: corev1.ResourceCapabilities, capability: "logging"},
+	"opensearch.Opensearch": {
+		resource:      corev1.ResourceCapabilities,
+		capability:    "logging",
+		clusterFields: []string{"AuthorizedClusterID"},
+	},
+	"alerting.ops.AlertingAdmin":        {resource: corev1.ResourceCapabilities, capability: "alerting"},
+	"alerting.AlertConditions":          {resource: corev1.ResourceAlertConditions},
+	"alerting.AlertEndpoints":           {resource: corev1.ResourceAlertEndpoints},
+	"alerting.AlertNotifications":       {resource: corev1.ResourceAlertConditions},
+	"slo.SLO":                           {resource: corev1.ResourceSLOs},
+	"orchestrator.TopologyOrchestrator": {resource: corev1.ResourceCapabilities, capability: "topology"},
+}
+
+// Fields containing the clusters a request applies to, for methods whose
+// requests do not use the fields listed for their service.
+var extensionMethodClusterFields = map[string][]string{
+	"cortexops.CortexOps.GetTenantLimits":         {"."},
+	"relabel.Relabeling.GetClusterRelabelConfigs": {"."},
+}
+
+// API extension methods which read metrics, and whose responses cannot be
+// filtered using the metrics label matchers of the subject's roles. Subjects
+// whose access to metrics is restricted by label matchers cannot call them.
+// See rbac.Permission.DenyLabelRestricted.
+var labelRestrictedMethods = map[string]struct{}{
+	"cortexadmin.CortexAdmin.Query":              {},
+	"cortexadmin.CortexAdmin.QueryRange":         {},
+	"cortexadmin.CortexAdmin.GetSeriesMetrics":   {},
+	"cortexadmin.CortexAdmin.GetMetricLabelSets": {},
+	"cortexadmin.CortexAdmin.ExtractRawSeries":   {},
+	"cortexadmin.CortexAdmin.CardinalityReport":  {},
+}
+
+// extensionVerbPrefixes maps method name prefixes to verbs for API extension
+// methods. Methods which don't match any prefix use the get verb if they are
+// read-only, and the update verb otherwise.
+var extensionVerbPrefixes = []struct {
+	prefix string
+	verb   string
+}{
+	{"Get", corev1.VerbGet},
+	{"List", corev1.VerbList},
+	{"Watch", corev1.VerbList},
+	{"Create", corev1.VerbCreate},
+	{"Install", corev1.VerbCreate},
+	{"Add", corev1.VerbCreate},
+	{"Delete", corev1.VerbDelete},
+	{"Uninstall", corev1.VerbDelete},
+	{"Remove", corev1.VerbDelete},
+}
+
+// resolvePermission returns the permission required to call a management API
+// or API extension method.
+func (m *Server) resolvePermission(fullMethod string, req any) (rbac.Permission, bool) {
+	md, ok := m.resolveMethod(fullMethod)
+	if !ok {
+		// Unknown methods will be rejected by the server anyway, but require
+		// full access to avoid leaking information about them.
+		return rbac.Permission{
+			Verb:     corev1.VerbGet,
+			Resource: corev1.Wildcard,
+		}, true
+	}
+	msg := util.ProtoMessage(req, md.Input())
+
+	if md.Parent().FullName() == "management.Management" {
+		if _, ok := publicManagementMethods[string(md.Name())]; ok {
+			return rbac.Permission{}, false
+		}
+		mp, ok := managementPermissions[string(md.Name())]
+		if !ok {
+			return rbac.Permission{
+				Verb:     corev1.VerbGet,
+				Resource: corev1.Wildcard,
+			}, true
+		}
+		return rbac.Permission{
+			Verb:       mp.verb,
+			Resource:   mp.resource,
+			Capability: stringField(msg, mp.capabilityField),
+			Clusters:   clusterFields(msg, mp.clusterField),
+			AnyCluster: mp.anyCluster,
+		}, true
+	}
+
+	if md.Parent().FullName() == "capability.NodeManager" {
+		// only used by agents, which connect to the gateway instead
+		return rbac.Permission{
+			Verb:     corev1.VerbUpdate,
+			Resource: corev1.Wildcard,
+		}, true
+	}
+
+	scope, ok := extensionScopes[string(md.Parent().FullName())]
+	if !ok {
+		scope = extensionScope{resource: corev1.ResourceAPIExtensions}
+	}
+	perm := rbac.Permission{
+		Verb:       extensionMethodVerb(md),
+		Resource:   scope.resource,
+		Capability: scope.capability,
+	}
+	if corev1.IsClusterScopedResource(scope.resource) {
+		fields, ok := extensionMethodClusterFields[string(md.FullName())]
+		if !ok {
+			fields = scope.clusterFields
+		}
+		if len(fields) == 0 {
+			fields = defaultClusterFields
+		}
+		perm.Clusters = clusterFields(msg, fields...)
+	}
+	if _, ok := labelRestrictedMethods[string(md.FullName())]; ok {
+		perm.DenyLabelRestricted = true
+	}
+	return perm, true
+}
+
+func extensionMethodVerb(md protoreflect.MethodDescriptor) string {
+	for _, p := range extensionVerbPrefixes {
+		if strings.HasPrefix(string(md.Name()), p.prefix) {
+			return p.verb
+		}
+	}
+	if audit.IsMutating(md) {
+		return corev1.VerbUpdate
+	}
+	return corev1.VerbGet
+}
+
+// lookupField follows a dot-separated path of field names starting at msg.
+// Only the last field in the path may be a list.
+func lookupField(msg proto.Message, path string) (protoreflect.Value, protoreflect.FieldDescriptor, bool) {
+	if msg == nil || path == "" {
+		return protoreflect.Value{}, nil, false
+	}
+	rm := msg.ProtoReflect()
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := rm.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = rm.Descriptor().Fields().ByJSONName(name)
+		}
+		if fd == nil || fd.IsMap() || !rm.Has(fd) {
+			return protoreflect.Value{}, nil, false
+		}
+		if i == len(names)-1 {
+			return rm.Get(fd), fd, true
+		}
+		if fd.IsList() {
+			return protoreflect.Value{}, nil, false
+		}
+		if fd.Message() == nil {
+			return protoreflect.Value{}, nil, false
+		}
+		rm = rm.Get(fd).Message()
+	}
+	return protoreflect.Value{}, nil, false
+}
+
+func stringField(msg proto.Message, path string) string {
+	v, fd, ok := lookupField(msg, path)
+	if !ok || fd.Kind() != protoreflect.StringKind {
+		return ""
+	}
+	return v.String()
+}
+
+// clusterFields returns references to the clusters named in the given fields
+// of msg. Each field may contain a cluster ID, a message with an "id" field
+// such as a reference or a cluster, or a list of either. The path "." refers
+// to msg itself.
+func clusterFields(msg proto.Message, paths ...string) []*corev1.Reference {
+	var refs []*corev1.Reference
+	add := func(id string) {
+		if id != "" {
+			refs = append(refs, &corev1.Reference{Id: id})
+		}
+	}
+	addValue := func(v protoreflect.Value, fd protoreflect.FieldDescriptor) {
+		switch {
+		case fd.Kind() == protoreflect.StringKind:
+			add(v.String())
+		case fd.Message() != nil:
+			add(messageID(v.Message()))
+		}
+	}
+	for _, path := range paths {
+		if path == "." {
+			if msg != nil {
+				add(messageID(msg.ProtoReflect()))
+			}
+			continue
+		}
+		v, fd, ok := lookupField(msg, path)
+		if !ok {
+			continue
+		}
+		if fd.IsList() {
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				addValue(list.Get(i), fd)
+			}
+			continue
+		}
+		addValue(v, fd)
+	}
+	return refs
+}
+
+func messageID(rm protoreflect.Message) string {
+	idField := rm.Descriptor().Fields().ByName("id")
+	if idField == nil || idField.Kind() != protoreflect.StringKind || idField.IsList() {
+		return ""
+	}
+	return rm.Get(idField).String()
+}
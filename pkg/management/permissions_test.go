@@ -0,0 +1,212 @@
+package management_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/auth"
+	authtest "github.com/rancher/opni/pkg/auth/test"
+	"github.com/rancher/opni/pkg/management"
+	"github.com/rancher/opni/pkg/plugins"
+)
+
+var _ = Describe("Permissions", Ordered, Label("unit"), func() {
+	var tv *testVars
+	BeforeAll(setupManagementServer(&tv, plugins.NoopLoader, management.WithAuthMiddleware(&authtest.TestAuthMiddleware{
+		Strategy: authtest.AuthStrategyUserIDInAuthHeader,
+		Groups: map[string][]string{
+			"dave": {"readers"},
+			"erin": {"auditors", "readers"},
+		},
+	})))
+	BeforeAll(func() {
+		ctx := context.Background()
+		for _, id := range []string{"c1", "c2"} {
+			Expect(tv.storageBackend.CreateCluster(ctx, &corev1.Cluster{Id: id})).To(Succeed())
+		}
+		roles := []*corev1.Role{
+			{
+				Id:         "cluster-reader",
+				ClusterIDs: []string{"c1"},
+				Rules: []*corev1.PolicyRule{{
+					Verbs:     []string{corev1.VerbGet, corev1.VerbList},
+					Resources: []string{corev1.ResourceClusters},
+				}},
+			},
+			{
+				Id: "token-admin",
+				Rules: []*corev1.PolicyRule{{
+					Verbs:     []string{corev1.Wildcard},
+					Resources: []string{corev1.ResourceTokens},
+				}},
+			},
+			{
+				Id: "rbac-viewer",
+				Rules: []*corev1.PolicyRule{{
+					Verbs:     []string{corev1.VerbGet, corev1.VerbList},
+					Resources: []string{corev1.ResourceRoles, corev1.ResourceRoleBindings},
+				}},
+			},
+			{
+				Id: "metrics-operator",
+				Rules: []*corev1.PolicyRule{{
+					Verbs:        []string{corev1.Wildcard},
+					Resources:    []string{corev1.ResourceCapabilities},
+					Capabilities: []string{"metrics"},
+				}},
+			},
+		}
+		for _, role := range roles {
+			Expect(tv.storageBackend.CreateRole(ctx, role)).To(Succeed())
+			Expect(tv.storageBackend.CreateRoleBinding(ctx, &corev1.RoleBinding{
+				Id:       role.Id,
+				RoleId:   role.Id,
+				Subjects: []string{"alice"},
+			})).To(Succeed())
+		}
+		Expect(tv.storageBackend.CreateRoleBinding(ctx, &corev1.RoleBinding{
+			Id:       "bob-cluster-reader",
+			RoleId:   "cluster-reader",
+			Subjects: []string{"bob", "group:readers"},
+		})).To(Succeed())
+		Expect(tv.storageBackend.CreateRoleBinding(ctx, &corev1.RoleBinding{
+			Id:       "auditors-rbac-viewer",
+			RoleId:   "rbac-viewer",
+			Subjects: []string{"group:auditors"},
+		})).To(Succeed())
+	})
+
+	asUser := func(user string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), auth.AuthorizationKey, user)
+	}
+
+	It("should allow any authenticated subject to call public methods", func() {
+		_, err := tv.client.CertsInfo(asUser("carol"), &emptypb.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tv.client.CertsInfo(context.Background(), &emptypb.Empty{})
+		Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+	})
+
+	It("should deny subjects without any role bindings", func() {
+		_, err := tv.client.ListClusters(asUser("carol"), &managementv1.ListClustersRequest{})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		_, err = tv.client.ListRoles(asUser("carol"), &emptypb.Empty{})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+	})
+
+	It("should only list clusters selected by the subject's roles", func() {
+		list, err := tv.client.ListClusters(asUser("bob"), &managementv1.ListClustersRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list.Items).To(HaveLen(1))
+		Expect(list.Items[0].Id).To(Equal("c1"))
+	})
+
+	It("should check access to specific clusters", func() {
+		_, err := tv.client.GetCluster(asUser("bob"), &corev1.Reference{Id: "c1"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tv.client.GetCluster(asUser("bob"), &corev1.Reference{Id: "c2"})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		_, err = tv.client.DeleteCluster(asUser("bob"), &corev1.Reference{Id: "c1"})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+	})
+
+	It("should require access to all clusters if a request does not name one", func() {
+		_, err := tv.client.GetClusterHealthStatus(asUser("bob"), &corev1.Reference{})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+	})
+
+	It("should require access to clusters to view the stale cluster report", func() {
+		_, err := tv.client.GetStaleClusterReport(asUser("carol"), &emptypb.Empty{})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		_, err = tv.client.GetStaleClusterReport(asUser("bob"), &emptypb.Empty{})
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+	})
+
+	It("should check access to non-cluster resources", func() {
+		_, err := tv.client.CreateBootstrapToken(asUser("bob"), &managementv1.CreateBootstrapTokenRequest{
+			Ttl: durationpb.New(time.Minute),
+		})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		_, err = tv.client.CreateBootstrapToken(asUser("alice"), &managementv1.CreateBootstrapTokenRequest{
+			Ttl: durationpb.New(time.Minute),
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should scope capability permissions to specific capabilities", func() {
+		_, err := tv.client.CapabilityUninstallStatus(asUser("alice"), &managementv1.CapabilityStatusRequest{
+			Name:    "logging",
+			Cluster: &corev1.Reference{Id: "c1"},
+		})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		_, err = tv.client.CapabilityUninstallStatus(asUser("bob"), &managementv1.CapabilityStatusRequest{
+			Name:    "metrics",
+			Cluster: &corev1.Reference{Id: "c1"},
+		})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+	})
+
+	It("should require access to role bindings to check subject access", func() {
+		refs, err := tv.client.SubjectAccess(asUser("bob"), &corev1.SubjectAccessRequest{
+			Subject:  "bob",
+			Verb:     corev1.VerbGet,
+			Resource: corev1.ResourceClusters,
+		})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		Expect(refs).To(BeNil())
+	})
+
+	It("should apply role bindings for the subject's groups", func() {
+		list, err := tv.client.ListClusters(asUser("dave"), &managementv1.ListClustersRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list.Items).To(HaveLen(1))
+		Expect(list.Items[0].Id).To(Equal("c1"))
+
+		_, err = tv.client.ListRoleBindings(asUser("dave"), &emptypb.Empty{})
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		_, err = tv.client.ListRoleBindings(asUser("erin"), &emptypb.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should resolve the groups of other subjects when checking access", func() {
+		refs, err := tv.client.SubjectAccess(asUser("erin"), &corev1.SubjectAccessRequest{
+			Subject:  "dave",
+			Verb:     corev1.VerbGet,
+			Resource: corev1.ResourceClusters,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(refs.Items).To(HaveLen(1))
+		Expect(refs.Items[0].Id).To(Equal("c1"))
+
+		refs, err = tv.client.SubjectAccess(asUser("erin"), &corev1.SubjectAccessRequest{
+			Subject:  "frank",
+			Verb:     corev1.VerbGet,
+			Resource: corev1.ResourceClusters,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(refs.Items).To(BeEmpty())
+	})
+
+	It("should serve the plugin socket from a private directory", func() {
+		matches, err := filepath.Glob(filepath.Join(os.TempDir(), "opni-management-*", "management.sock"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matches).NotTo(BeEmpty())
+		for _, socket := range matches {
+			info, err := os.Stat(filepath.Dir(socket))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0700)))
+		}
+	})
+})
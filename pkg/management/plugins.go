@@ -0,0 +1,20 @@
+package management
+
+import (
+	"context"
+
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (m *Server) GetPluginRolloutStatus(
+	ctx context.Context,
+	_ *emptypb.Empty,
+) (*managementv1.PluginRolloutStatus, error) {
+	if m.pluginRolloutDataSource == nil {
+		return nil, status.Error(codes.Unavailable, "plugin rollout API not configured")
+	}
+	return m.pluginRolloutDataSource.GetPluginRolloutStatus(ctx)
+}
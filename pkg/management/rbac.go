@@ -4,7 +4,9 @@ import (
 	"context"
 
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/auth"
 	"github.com/rancher/opni/pkg/validation"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -69,6 +71,16 @@ func (s *Server) SubjectAccess(ctx context.Context, sar *corev1.SubjectAccessReq
 	if err := validation.Validate(sar); err != nil {
 		return nil, err
 	}
+	if len(sar.Groups) == 0 {
+		// If the auth provider knows which groups the subject is a member
+		// of, take them into account as well.
+		if resolver, ok := s.authMiddleware.(auth.GroupResolver); ok {
+			if groups, ok := resolver.UserGroups(sar.Subject); ok {
+				sar = proto.Clone(sar).(*corev1.SubjectAccessRequest)
+				sar.Groups = groups
+			}
+		}
+	}
 	rl, err := s.rbacProvider.SubjectAccess(ctx, sar)
 	return rl, err
 }
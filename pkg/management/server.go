@@ -7,12 +7,16 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/kralicky/grpc-gateway/v2/runtime"
 	"github.com/samber/lo"
@@ -22,11 +26,14 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	capabilityv1 "github.com/rancher/opni/pkg/apis/capability/v1"
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
 	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/audit"
+	"github.com/rancher/opni/pkg/auth"
 	"github.com/rancher/opni/pkg/capabilities"
 	"github.com/rancher/opni/pkg/config"
 	"github.com/rancher/opni/pkg/config/v1beta1"
@@ -61,7 +68,31 @@ type HealthStatusDataSource interface {
 	WatchClusterHealthStatus(ctx context.Context) <-chan *corev1.ClusterHealthStatus
 }
 
+type KeyRotationDataSource interface {
+	RotateClusterKeys(ctx context.Context, ref *corev1.Reference, gracePeriod time.Duration) error
+}
+
+type DiagnosticsDataSource interface {
+	CollectClusterDiagnostics(ctx context.Context, ref *corev1.Reference, logLines int32, w io.Writer) error
+}
+
+type HealthHistoryDataSource interface {
+	GetClusterHealthHistory(ctx context.Context, req *managementv1.ClusterHealthHistoryRequest) (*managementv1.ClusterHealthHistory, error)
+}
+
+type StaleClusterDataSource interface {
+	GetStaleClusterReport(ctx context.Context) (*managementv1.StaleClusterReport, error)
+	// SetClusterDeleter sets the function used to delete stale clusters, so
+	// that they are cleaned up and audited like DeleteCluster requests.
+	SetClusterDeleter(deleter func(ctx context.Context, ref *corev1.Reference) error)
+}
+
+type PluginRolloutDataSource interface {
+	GetPluginRolloutStatus(ctx context.Context) (*managementv1.PluginRolloutStatus, error)
+}
+
 type apiExtension struct {
+	module      string
 	client      apiextensions.ManagementAPIExtensionClient
 	clientConn  *grpc.ClientConn
 	serviceDesc *desc.ServiceDescriptor
@@ -71,23 +102,38 @@ type apiExtension struct {
 type Server struct {
 	managementv1.UnsafeManagementServer
 	managementServerOptions
-	config            *v1beta1.ManagementSpec
-	logger            *zap.SugaredLogger
-	rbacProvider      rbac.Provider
-	coreDataSource    CoreDataSource
-	grpcServer        *grpc.Server
-	dashboardSettings *DashboardSettingsManager
+	config         *v1beta1.ManagementSpec
+	logger         *zap.SugaredLogger
+	rbacProvider   rbac.Provider
+	coreDataSource CoreDataSource
+	grpcServer     *grpc.Server
+	// only used if an auth middleware is configured
+	internalGrpcServer    *grpc.Server
+	internalListenAddress string
+	internalSocketDir     string
+	dashboardSettings     *DashboardSettingsManager
 
 	apiExtMu      sync.RWMutex
 	apiExtensions []apiExtension
+
+	auditLog          *audit.Log
+	extensionMethodMu sync.RWMutex
+	extensionMethods  map[string]protoreflect.MethodDescriptor
 }
 
 var _ managementv1.ManagementServer = (*Server)(nil)
 
 type managementServerOptions struct {
-	lifecycler             config.Lifecycler
-	capabilitiesDataSource CapabilitiesDataSource
-	healthStatusDataSource HealthStatusDataSource
+	lifecycler              config.Lifecycler
+	capabilitiesDataSource  CapabilitiesDataSource
+	healthStatusDataSource  HealthStatusDataSource
+	keyRotationDataSource   KeyRotationDataSource
+	diagnosticsDataSource   DiagnosticsDataSource
+	healthHistoryDataSource HealthHistoryDataSource
+	staleClusterDataSource  StaleClusterDataSource
+	pluginRolloutDataSource PluginRolloutDataSource
+	authMiddleware          auth.Middleware
+	trustedProxies          []string
 }
 
 type ManagementServerOption func(*managementServerOptions)
@@ -116,6 +162,55 @@ func WithHealthStatusDataSource(src HealthStatusDataSource) ManagementServerOpti
 	}
 }
 
+func WithKeyRotationDataSource(src KeyRotationDataSource) ManagementServerOption {
+	return func(o *managementServerOptions) {
+		o.keyRotationDataSource = src
+	}
+}
+
+func WithDiagnosticsDataSource(src DiagnosticsDataSource) ManagementServerOption {
+	return func(o *managementServerOptions) {
+		o.diagnosticsDataSource = src
+	}
+}
+
+func WithHealthHistoryDataSource(src HealthHistoryDataSource) ManagementServerOption {
+	return func(o *managementServerOptions) {
+		o.healthHistoryDataSource = src
+	}
+}
+
+func WithStaleClusterDataSource(src StaleClusterDataSource) ManagementServerOption {
+	return func(o *managementServerOptions) {
+		o.staleClusterDataSource = src
+	}
+}
+
+func WithPluginRolloutDataSource(src PluginRolloutDataSource) ManagementServerOption {
+	return func(o *managementServerOptions) {
+		o.pluginRolloutDataSource = src
+	}
+}
+
+// WithAuthMiddleware configures the middleware used to authenticate
+// management API requests. The middleware must support unary and streaming
+// gRPC requests.
+func WithAuthMiddleware(mw auth.Middleware) ManagementServerOption {
+	return func(o *managementServerOptions) {
+		o.authMiddleware = mw
+	}
+}
+
+// WithTrustedProxies sets the addresses (or CIDRs) of proxies which are
+// trusted to report the client address of management API requests, which is
+// recorded in the audit log. Requests from the management HTTP API are always
+// forwarded from the loopback address.
+func WithTrustedProxies(cidrs ...string) ManagementServerOption {
+	return func(o *managementServerOptions) {
+		o.trustedProxies = cidrs
+	}
+}
+
 func NewServer(
 	ctx context.Context,
 	conf *v1beta1.ManagementSpec,
@@ -137,27 +232,75 @@ func NewServer(
 			kv:     cds.StorageBackend().KeyValueStore("dashboard"),
 			logger: lg,
 		},
+		auditLog: audit.NewLog(cds.StorageBackend().KeyValueStore("audit"),
+			audit.WithRetention(time.Duration(conf.AuditLog.RetentionDays)*24*time.Hour),
+			audit.WithTrustedProxies(append([]string{"127.0.0.1", "::1"}, options.trustedProxies...)...),
+		),
+		extensionMethods: make(map[string]protoreflect.MethodDescriptor),
 	}
 
 	director := m.configureApiExtensionDirector(ctx, pluginLoader)
-	m.grpcServer = grpc.NewServer(
-		grpc.Creds(insecure.NewCredentials()),
-		grpc.UnknownServiceHandler(unknownServiceHandler(director)),
-		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor()),
-		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
-	)
-	managementv1.RegisterManagementServer(m.grpcServer, m)
-	if m.capabilitiesDataSource != nil {
-		capabilityv1.RegisterNodeManagerServer(m.grpcServer, m.capabilitiesDataSource.NodeManagerServer())
+
+	if m.staleClusterDataSource != nil {
+		m.staleClusterDataSource.SetClusterDeleter(m.deleteStaleCluster)
 	}
 
+	streamInterceptors := []grpc.StreamServerInterceptor{otelgrpc.StreamServerInterceptor()}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{otelgrpc.UnaryServerInterceptor()}
+	if m.authMiddleware != nil {
+		if mw, ok := m.authMiddleware.(auth.StreamGRPCMiddleware); ok {
+			streamInterceptors = append(streamInterceptors, mw.StreamServerInterceptor())
+		} else {
+			lg.Warn("auth middleware does not support streaming gRPC requests")
+		}
+		if mw, ok := m.authMiddleware.(auth.UnaryGRPCMiddleware); ok {
+			unaryInterceptors = append(unaryInterceptors, mw.UnaryServerInterceptor())
+		} else {
+			lg.Warn("auth middleware does not support unary gRPC requests")
+		}
+		// Audit events are recorded before authorization, so that denied
+		// requests are recorded as well.
+		authorizer := rbac.NewAuthorizer(m.rbacProvider, m.resolvePermission,
+			rbac.WithAdminSubjects(conf.AdminSubjects...),
+		)
+		streamInterceptors = append(streamInterceptors,
+			m.auditLog.StreamServerInterceptor(m.resolveMethod),
+			authorizer.StreamServerInterceptor(),
+		)
+		unaryInterceptors = append(unaryInterceptors,
+			m.auditLog.UnaryServerInterceptor(m.resolveMethod),
+			authorizer.UnaryServerInterceptor(),
+		)
+
+		// Plugins call each other's API extensions through the management
+		// API, but are unable to authenticate. Their requests are instead
+		// served by a separate server, listening on a socket in a directory
+		// which only the gateway and the plugins it starts can access.
+		m.internalGrpcServer = m.newGrpcServer(director,
+			[]grpc.StreamServerInterceptor{
+				otelgrpc.StreamServerInterceptor(),
+				m.auditLog.StreamServerInterceptor(m.resolveMethod),
+			},
+			[]grpc.UnaryServerInterceptor{
+				otelgrpc.UnaryServerInterceptor(),
+				m.auditLog.UnaryServerInterceptor(m.resolveMethod),
+			},
+		)
+		m.internalSocketDir = filepath.Join(os.TempDir(), "opni-management-"+uuid.NewString())
+		m.internalListenAddress = "unix://" + filepath.Join(m.internalSocketDir, "management.sock")
+	} else {
+		streamInterceptors = append(streamInterceptors, m.auditLog.StreamServerInterceptor(m.resolveMethod))
+		unaryInterceptors = append(unaryInterceptors, m.auditLog.UnaryServerInterceptor(m.resolveMethod))
+	}
+	m.grpcServer = m.newGrpcServer(director, streamInterceptors, unaryInterceptors)
+
 	pluginLoader.Hook(hooks.OnLoadM(func(sp types.SystemPlugin, md meta.PluginMeta) {
 		go sp.ServeManagementAPI(m)
 		if m.capabilitiesDataSource != nil {
 			go sp.ServeNodeManagerServer(m.capabilitiesDataSource.NodeManagerServer())
 		}
 		go func() {
-			if err := sp.ServeAPIExtensions(m.config.GRPCListenAddress); err != nil {
+			if err := sp.ServeAPIExtensions(m.pluginDialAddress()); err != nil {
 				lg.With(
 					zap.String("plugin", md.Module),
 				).Error("failed to serve plugin API extensions")
@@ -168,6 +311,33 @@ func NewServer(
 	return m
 }
 
+func (m *Server) newGrpcServer(
+	director StreamDirector,
+	streamInterceptors []grpc.StreamServerInterceptor,
+	unaryInterceptors []grpc.UnaryServerInterceptor,
+) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.Creds(insecure.NewCredentials()),
+		grpc.UnknownServiceHandler(unknownServiceHandler(director)),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+	)
+	managementv1.RegisterManagementServer(srv, m)
+	if m.capabilitiesDataSource != nil {
+		capabilityv1.RegisterNodeManagerServer(srv, m.capabilitiesDataSource.NodeManagerServer())
+	}
+	return srv
+}
+
+// pluginDialAddress returns the address plugins should use to connect to the
+// management API.
+func (m *Server) pluginDialAddress() string {
+	if m.internalGrpcServer != nil {
+		return m.internalListenAddress
+	}
+	return m.config.GRPCListenAddress
+}
+
 type managementApiServer interface {
 	ServeManagementAPI(managementv1.ManagementServer)
 }
@@ -175,6 +345,8 @@ type managementApiServer interface {
 func (m *Server) ListenAndServe(ctx context.Context) error {
 	ctx, ca := context.WithCancel(ctx)
 
+	go m.auditLog.Run(ctx)
+
 	e1 := lo.Async(func() error {
 		err := m.listenAndServeGrpc(ctx)
 		if err != nil {
@@ -210,12 +382,35 @@ func (m *Server) listenAndServeGrpc(ctx context.Context) error {
 	errC := lo.Async(func() error {
 		return m.grpcServer.Serve(listener)
 	})
+	internalErrC := make(<-chan error)
+	if m.internalGrpcServer != nil {
+		// Mkdir fails if the directory already exists, so it can not have been
+		// created in advance by another user.
+		if err := os.Mkdir(m.internalSocketDir, 0700); err != nil {
+			m.grpcServer.Stop()
+			return fmt.Errorf("failed to create plugin socket directory: %w", err)
+		}
+		defer os.RemoveAll(m.internalSocketDir)
+		internalListener, err := util.NewProtocolListener(m.internalListenAddress)
+		if err != nil {
+			m.grpcServer.Stop()
+			return err
+		}
+		internalErrC = lo.Async(func() error {
+			return m.internalGrpcServer.Serve(internalListener)
+		})
+	}
 	select {
 	case <-ctx.Done():
 		m.grpcServer.Stop()
+		if m.internalGrpcServer != nil {
+			m.internalGrpcServer.Stop()
+		}
 		return ctx.Err()
 	case err := <-errC:
 		return err
+	case err := <-internalErrC:
+		return err
 	}
 }
 
@@ -241,7 +436,18 @@ func (m *Server) listenAndServeHttp(ctx context.Context) error {
 			zap.Error(err),
 		).Panic("failed to register management handler")
 	}
-	m.configureHttpApiExtensions(gwmux)
+	// API extension requests are sent to the management gRPC server rather
+	// than directly to the plugin, so that they are subject to the same
+	// authentication and auditing as requests to the core API.
+	loopback, err := grpc.DialContext(ctx,
+		strings.TrimPrefix(m.config.GRPCListenAddress, "tcp://"),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to dial management gRPC server: %w", err)
+	}
+	defer loopback.Close()
+	m.configureHttpApiExtensions(gwmux, loopback)
 	mux.Handle("/", gwmux)
 	server := &http.Server{
 		Addr:    m.config.HTTPListenAddress,
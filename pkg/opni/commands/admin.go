@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -44,6 +45,7 @@ func BuildCortexAdminCmd() *cobra.Command {
 	cmd.AddCommand(BuildCortexConfigCmd())
 	cmd.AddCommand(BuildClusterStatsCmd())
 	cmd.AddCommand(BuildCortexAdminRulesCmd())
+	cmd.AddCommand(BuildCardinalityCmd())
 
 	return cmd
 }
@@ -57,6 +59,7 @@ func BuildCortexOpsCmd() *cobra.Command {
 	cmd.AddCommand(BuildCortexClusterConfigureCmd())
 	cmd.AddCommand(BuildCortexClusterGetConfigurationCmd())
 	cmd.AddCommand(BuildCortexClusterUninstallCmd())
+	cmd.AddCommand(BuildCortexTenantLimitsCmd())
 
 	return cmd
 }
@@ -69,6 +72,8 @@ func BuildCortexAdminRulesCmd() *cobra.Command {
 	cmd.AddCommand(BuildListRulesCmd())
 	cmd.AddCommand(BuildDeleteRuleGroupsCmd())
 	cmd.AddCommand(BuildLoadRuleGroupsCmd())
+	cmd.AddCommand(BuildLintRuleGroupsCmd())
+	cmd.AddCommand(BuildTestRuleGroupsCmd())
 	return cmd
 }
 
@@ -168,6 +173,107 @@ func BuildLoadRuleGroupsCmd() *cobra.Command {
 	return cmd
 }
 
+func BuildLintRuleGroupsCmd() *cobra.Command {
+	var cluster string
+	var strict bool
+	cmd := &cobra.Command{
+		Use:   "lint <rulegroupfile>",
+		Short: "Check a prometheus rule group file for problems without loading it into Cortex",
+		Long: `Check a prometheus rule group file for problems without loading it into Cortex.
+
+If a cluster is given, metrics used by the rules which have no series in that
+cluster, and are not recorded by another rule in the file, are reported as
+warnings. Exits with a non-zero status if any errors are found, or if any
+warnings are found when --strict is set.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			yamlContent, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			resp, err := adminClient.ValidateRules(cmd.Context(), &cortexadmin.ValidateRulesRequest{
+				YamlContent: yamlContent,
+				ClusterId:   cluster,
+			})
+			if err != nil {
+				return err
+			}
+			var errCount, warnCount int
+			for _, d := range resp.GetDiagnostics() {
+				switch d.GetSeverity() {
+				case cortexadmin.RuleDiagnostic_Error:
+					errCount++
+				case cortexadmin.RuleDiagnostic_Warning:
+					warnCount++
+				}
+				location := ""
+				if d.GetGroup() != "" {
+					location = fmt.Sprintf("group %q: ", d.GetGroup())
+				}
+				if d.GetRule() != "" {
+					location += fmt.Sprintf("rule %q: ", d.GetRule())
+				}
+				fmt.Printf("%s: %s: %s%s\n", args[0], strings.ToLower(d.GetSeverity().String()), location, d.GetMessage())
+			}
+			if errCount > 0 || (strict && warnCount > 0) {
+				return fmt.Errorf("found %d errors and %d warnings", errCount, warnCount)
+			}
+			fmt.Printf("%s: OK (%d warnings)\n", args[0], warnCount)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&cluster, "cluster", "", "check that metrics used by the rules exist in this cluster")
+	cmd.Flags().BoolVar(&strict, "strict", false, "treat warnings as errors")
+	return cmd
+}
+
+func BuildTestRuleGroupsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test <rulegroupfile> <testfile>",
+		Short: "Run unit tests against a prometheus rule group file without loading it into Cortex",
+		Long: `Run unit tests against a prometheus rule group file without loading it into Cortex.
+
+The test file uses the same format as promtool; the rule_files field is ignored.
+See https://prometheus.io/docs/prometheus/latest/configuration/unit_testing_rules/
+for more information. Exits with a non-zero status if any test fails.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rulesYaml, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			testsYaml, err := os.ReadFile(args[1])
+			if err != nil {
+				return err
+			}
+			resp, err := adminClient.TestRules(cmd.Context(), &cortexadmin.TestRulesRequest{
+				RulesYaml: rulesYaml,
+				TestsYaml: testsYaml,
+			})
+			if err != nil {
+				return err
+			}
+			failed := 0
+			for _, result := range resp.GetResults() {
+				if len(result.GetFailures()) == 0 {
+					fmt.Printf("PASS %s\n", result.GetName())
+					continue
+				}
+				failed++
+				fmt.Printf("FAIL %s\n", result.GetName())
+				for _, failure := range result.GetFailures() {
+					fmt.Printf("    %s\n", failure)
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d tests failed", failed, len(resp.GetResults()))
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
 func BuildListRulesCmd() *cobra.Command {
 	var clusters []string
 	var ruleFilter []string
@@ -431,6 +537,43 @@ Modes:
 	return cmd
 }
 
+func BuildCardinalityCmd() *cobra.Command {
+	var limit int32
+	var compareTo time.Duration
+	var outputFormat string
+	cmd := &cobra.Command{
+		Use:   "cardinality <cluster-id>",
+		Short: "Show the metrics and labels with the most series in a cluster",
+		Args:  cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completeClusters(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			report, err := adminClient.CardinalityReport(cmd.Context(), &cortexadmin.CardinalityRequest{
+				Tenant:    args[0],
+				Limit:     limit,
+				CompareTo: durationpb.New(compareTo),
+			})
+			if err != nil {
+				return err
+			}
+			switch outputFormat {
+			case "json":
+				fmt.Println(protojson.Format(report))
+			case "table":
+				fmt.Println(cliutil.RenderCardinalityReport(report))
+			default:
+				return fmt.Errorf("unknown output format: %s", outputFormat)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Int32VarP(&limit, "limit", "n", cortexadmin.DefaultCardinalityLimit, "Number of metrics and labels to show")
+	cmd.Flags().DurationVar(&compareTo, "compare-to", cortexadmin.DefaultCardinalityCompareTo, "How far back to compare series counts against")
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", "table", "Output format (table|json)")
+	return cmd
+}
+
 func BuildClusterStatsCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "list-clusters",
@@ -0,0 +1,180 @@
+//go:build !noplugins
+
+package commands
+
+import (
+	"fmt"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	cliutil "github.com/rancher/opni/pkg/opni/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexops"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/yaml.v3"
+)
+
+func BuildCortexTenantLimitsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "limits",
+		Short: "Manage per-cluster Cortex limits",
+	}
+	cmd.AddCommand(BuildCortexTenantLimitsGetCmd())
+	cmd.AddCommand(BuildCortexTenantLimitsListCmd())
+	cmd.AddCommand(BuildCortexTenantLimitsSetCmd())
+	return cmd
+}
+
+func BuildCortexTenantLimitsGetCmd() *cobra.Command {
+	var outputFormat string
+	cmd := &cobra.Command{
+		Use:   "get <cluster-id>",
+		Short: "Show the limit overrides in effect for a cluster",
+		Args:  cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completeClusters(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			limits, err := opsClient.GetTenantLimits(cmd.Context(), &corev1.Reference{Id: args[0]})
+			if err != nil {
+				return err
+			}
+			switch outputFormat {
+			case "json":
+				fmt.Println(protojson.Format(limits))
+			case "table":
+				fmt.Println(cliutil.RenderTenantLimits(limits))
+			default:
+				return fmt.Errorf("unknown output format: %s", outputFormat)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", "table", "Output format (table|json)")
+	return cmd
+}
+
+func BuildCortexTenantLimitsListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List per-cluster limit overrides and label-selector defaults",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			list, err := opsClient.ListTenantLimits(cmd.Context(), &emptypb.Empty{})
+			if err != nil {
+				return err
+			}
+			fmt.Println(cliutil.RenderTenantLimitsSpecList(list))
+			return nil
+		},
+	}
+	return cmd
+}
+
+func BuildCortexTenantLimitsSetCmd() *cobra.Command {
+	var isDefault bool
+	var selectorLabels []string
+	cmd := &cobra.Command{
+		Use:   "set [--default [--selector <label>=<value> ...]] <id> <limit>=<value> [<limit>=<value>...]",
+		Short: "Set or remove limit overrides",
+		Long: `
+Sets Cortex per-tenant limits for a cluster, using the names from the Cortex
+limits config (for example, ingestion_rate=50000). Set a limit to "-" to
+remove it; removing all limits deletes the overrides.
+
+With --default, the id names a set of defaults which apply to all clusters
+matching the given labels (or all clusters, if no labels are given).
+Per-cluster overrides take precedence over defaults.
+`[1:],
+		Args: cobra.MinimumNArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 && !isDefault {
+				return completeClusters(cmd, args, toComplete)
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pairs, err := cliutil.ParseKeyValuePairs(args[1:])
+			if err != nil {
+				return err
+			}
+			spec := &cortexops.TenantLimitsSpec{
+				Id:     args[0],
+				Limits: &structpb.Struct{Fields: map[string]*structpb.Value{}},
+			}
+			if isDefault {
+				matchLabels, err := cliutil.ParseKeyValuePairs(selectorLabels)
+				if err != nil {
+					return err
+				}
+				spec.Selector = &corev1.ClusterSelector{
+					LabelSelector: &corev1.LabelSelector{
+						MatchLabels: matchLabels,
+					},
+				}
+			}
+
+			// start from the existing limits, if any
+			existing, err := opsClient.ListTenantLimits(cmd.Context(), &emptypb.Empty{})
+			if err != nil {
+				return err
+			}
+			if prev, ok := lo.Find(existing.GetItems(), func(item *cortexops.TenantLimitsSpec) bool {
+				return item.GetId() == spec.GetId() && (item.Selector != nil) == isDefault
+			}); ok {
+				spec.Limits = prev.GetLimits()
+				if isDefault && len(selectorLabels) == 0 {
+					spec.Selector = prev.GetSelector()
+				}
+			}
+
+			for name, value := range pairs {
+				if value == "-" {
+					delete(spec.Limits.Fields, name)
+					continue
+				}
+				// parse values as yaml scalars, so numbers and booleans keep
+				// their types and durations remain strings
+				var parsed any
+				if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+					return fmt.Errorf("invalid value for %s: %w", name, err)
+				}
+				v, err := structpb.NewValue(parsed)
+				if err != nil {
+					return fmt.Errorf("invalid value for %s: %w", name, err)
+				}
+				spec.Limits.Fields[name] = v
+			}
+
+			if _, err := opsClient.SetTenantLimits(cmd.Context(), spec); err != nil {
+				return err
+			}
+			lg.Info("Limits updated")
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&isDefault, "default", false, "Set label-selector defaults instead of per-cluster overrides")
+	cmd.Flags().StringSliceVar(&selectorLabels, "selector", nil, "Cluster labels to match (<label>=<value>), used with --default")
+	return cmd
+}
+
+func init() {
+	clusterDetailsRenderers = append(clusterDetailsRenderers, renderClusterTenantLimits)
+}
+
+// renderClusterTenantLimits shows the cluster's Cortex limit overrides in
+// "opni clusters show".
+func renderClusterTenantLimits(cmd *cobra.Command, cluster *corev1.Cluster) (string, error) {
+	client, err := cortexops.NewClient(cmd.Context(),
+		cortexops.WithListenAddress(managementListenAddress))
+	if err != nil {
+		return "", err
+	}
+	limits, err := client.GetTenantLimits(cmd.Context(), cluster.Reference())
+	if err != nil {
+		return "", err
+	}
+	return cliutil.RenderTenantLimits(limits), nil
+}
@@ -126,6 +126,7 @@ agent remote-write requests to add dynamic authentication.`,
 	agentCmd.Flags().BoolVar(&enableLogging, "logging", false, "enable logging controllers")
 	agentCmd.Flags().BoolVar(&enableEventCollector, "events", false, "enable event collector")
 	agentCmd.Flags().StringVar(&eventOutputEndpoint, "events-output", "http://opni-shipper:2021/log/ingest", "endpoint to post events to")
+	agentCmd.AddCommand(BuildSupportBundleCmd())
 	return agentCmd
 }
 
@@ -128,6 +128,7 @@ func BuildAgentV2Cmd() *cobra.Command {
 	cmd.Flags().StringVar(&logLevel, "log-level", "info", "log level (debug, info, warning, error)")
 	cmd.Flags().BoolVar(&rebootstrap, "re-bootstrap", false, "attempt to re-bootstrap the agent even if it has already been bootstrapped")
 	cmd.Flags().Lookup("re-bootstrap").Hidden = true
+	cmd.AddCommand(BuildSupportBundleCmd())
 	return cmd
 }
 
@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	cliutil "github.com/rancher/opni/pkg/opni/util"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func BuildAuditCmd() *cobra.Command {
+	auditCmd := &cobra.Command{
+		Use:   "audit",
+		Short: "View the management API audit log",
+	}
+	auditCmd.AddCommand(BuildAuditListCmd())
+	ConfigureManagementCommand(auditCmd)
+	return auditCmd
+}
+
+func BuildAuditListCmd() *cobra.Command {
+	var since, until, output string
+	var limit int
+	req := &managementv1.ListAuditEventsRequest{}
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List audit events, newest first",
+		Long: `List audit events, newest first.
+
+The --since and --until flags accept either an RFC3339 timestamp, or a
+duration relative to the current time (e.g. "1h").
+
+Use --output=jsonl to export events as JSON lines.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			now := time.Now()
+			if since != "" {
+				ts, err := parseTimeOrDuration(since, now)
+				if err != nil {
+					return fmt.Errorf("invalid value for --since: %w", err)
+				}
+				req.Since = timestamppb.New(ts)
+			}
+			if until != "" {
+				ts, err := parseTimeOrDuration(until, now)
+				if err != nil {
+					return fmt.Errorf("invalid value for --until: %w", err)
+				}
+				req.Until = timestamppb.New(ts)
+			}
+			list, err := listAuditEvents(cmd, req, limit)
+			if err != nil {
+				return err
+			}
+			switch output {
+			case "table":
+				fmt.Println(cliutil.RenderAuditEventList(list))
+			case "jsonl":
+				for _, event := range list.Items {
+					data, err := protojson.Marshal(event)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(data))
+				}
+			default:
+				return fmt.Errorf("unknown output format %q", output)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&req.Subject, "subject", "", "Only show events for this subject")
+	cmd.Flags().StringVar(&req.Method, "method", "", "Only show events for methods containing this string")
+	cmd.Flags().StringVar(&req.Target, "target", "", "Only show events referencing an object with this ID")
+	cmd.Flags().StringVar(&since, "since", "", "Only show events after this time")
+	cmd.Flags().StringVar(&until, "until", "", "Only show events before this time")
+	cmd.Flags().BoolVar(&req.ErrorsOnly, "errors-only", false, "Only show events for requests which returned an error")
+	cmd.Flags().IntVar(&limit, "limit", 100, "Maximum number of events to show (0 for no limit)")
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "Output format (table|jsonl)")
+	return cmd
+}
+
+// listAuditEvents lists pages of events until limit events have been
+// listed, or all events if limit is 0.
+func listAuditEvents(cmd *cobra.Command, req *managementv1.ListAuditEventsRequest, limit int) (*managementv1.AuditEventList, error) {
+	list := &managementv1.AuditEventList{}
+	for {
+		req.Limit = managementv1.MaxAuditEventsPageSize
+		if remaining := limit - len(list.Items); limit > 0 && remaining < managementv1.MaxAuditEventsPageSize {
+			req.Limit = int32(remaining)
+		}
+		page, err := mgmtClient.ListAuditEvents(cmd.Context(), req)
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, page.Items...)
+		if page.NextPageToken == "" || (limit > 0 && len(list.Items) >= limit) {
+			return list, nil
+		}
+		req.PageToken = page.NextPageToken
+	}
+}
+
+// parseTimeOrDuration parses either an RFC3339 timestamp, or a duration
+// which is subtracted from now.
+func parseTimeOrDuration(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func init() {
+	AddCommandsToGroup(ManagementAPI, BuildAuditCmd())
+}
@@ -3,6 +3,7 @@ package commands
 import (
 	"fmt"
 	"reflect"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
@@ -12,8 +13,14 @@ import (
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// clusterDetailsRenderers render additional sections in "clusters show",
+// such as details provided by plugins.
+var clusterDetailsRenderers []func(cmd *cobra.Command, cluster *corev1.Cluster) (string, error)
+
 func BuildClustersCmd() *cobra.Command {
 	clustersCmd := &cobra.Command{
 		Use:     "clusters",
@@ -23,9 +30,12 @@ func BuildClustersCmd() *cobra.Command {
 	clustersCmd.AddCommand(BuildClustersListCmd())
 	clustersCmd.AddCommand(BuildClustersWatchCmd())
 	clustersCmd.AddCommand(BuildClustersDeleteCmd())
+	clustersCmd.AddCommand(BuildClustersApproveCmd())
 	clustersCmd.AddCommand(BuildClustersLabelCmd())
 	clustersCmd.AddCommand(BuildClustersRenameCmd())
 	clustersCmd.AddCommand(BuildClustersShowCmd())
+	clustersCmd.AddCommand(BuildClustersStaleCmd())
+	clustersCmd.AddCommand(BuildClustersHistoryCmd())
 	ConfigureManagementCommand(clustersCmd)
 	return clustersCmd
 }
@@ -93,6 +103,58 @@ func BuildClustersWatchCmd() *cobra.Command {
 	return cmd
 }
 
+func BuildClustersStaleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stale",
+		Short: "Show clusters affected by the stale cluster policy",
+		Long: `Show clusters whose agents have been disconnected for longer than the
+period configured in the gateway's stale cluster policy, along with the
+action the policy would take for each cluster on its next run.
+This command does not make any changes.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			report, err := mgmtClient.GetStaleClusterReport(cmd.Context(), &emptypb.Empty{})
+			if err != nil {
+				return err
+			}
+			fmt.Println(cliutil.RenderStaleClusterReport(report))
+			return nil
+		},
+	}
+	return cmd
+}
+
+func BuildClustersHistoryCmd() *cobra.Command {
+	var since time.Duration
+	cmd := &cobra.Command{
+		Use:   "history <cluster-id>",
+		Short: "Show a cluster's connection and health history",
+		Long: `Show the recorded connection and health events for a cluster's agent,
+along with the agent's uptime and number of disconnects over the time range.`,
+		Args: cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completeClusters(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			now := time.Now()
+			history, err := mgmtClient.GetClusterHealthHistory(cmd.Context(), &managementv1.ClusterHealthHistoryRequest{
+				Cluster: &corev1.Reference{
+					Id: args[0],
+				},
+				Since: timestamppb.New(now.Add(-since)),
+				Until: timestamppb.New(now),
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println(cliutil.RenderClusterHealthHistory(history))
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&since, "since", 24*time.Hour, "Show events newer than this duration")
+	return cmd
+}
+
 func BuildClustersDeleteCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "delete <cluster-id> [<cluster-id> ...]",
@@ -120,6 +182,32 @@ func BuildClustersDeleteCmd() *cobra.Command {
 	return cmd
 }
 
+func BuildClustersApproveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "approve <cluster-id> [<cluster-id> ...]",
+		Short: "Approve a cluster created with a token that requires approval",
+		Args:  cobra.MinimumNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completeClusters(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, cluster := range args {
+				_, err := mgmtClient.ApproveCluster(cmd.Context(), &corev1.Reference{
+					Id: cluster,
+				})
+				if err != nil {
+					lg.Fatal(err)
+				}
+				lg.With(
+					"id", cluster,
+				).Info("Approved cluster")
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
 func BuildClustersLabelCmd() *cobra.Command {
 	overwrite := false
 	cmd := &cobra.Command{
@@ -260,6 +348,16 @@ func BuildClustersShowCmd() *cobra.Command {
 				fmt.Println(protojson.Format(cluster))
 			case "table":
 				fmt.Println(cliutil.RenderClusterDetails(cluster))
+				for _, render := range clusterDetailsRenderers {
+					details, err := render(cmd, cluster)
+					if err != nil {
+						lg.With(
+							zap.Error(err),
+						).Debug("failed to render cluster details")
+						continue
+					}
+					fmt.Println(details)
+				}
 			}
 			return nil
 		},
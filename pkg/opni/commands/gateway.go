@@ -58,7 +58,7 @@ func BuildGatewayCmd() *cobra.Command {
 			fCancel = cancel
 		}
 
-		machinery.LoadAuthProviders(ctx, objects)
+		authProviders := machinery.LoadAuthProviders(ctx, objects)
 		var gatewayConfig *v1beta1.GatewayConfig
 		found := objects.Visit(
 			func(config *v1beta1.GatewayConfig) {
@@ -99,11 +99,27 @@ func BuildGatewayCmd() *cobra.Command {
 			gateway.WithLifecycler(lifecycler),
 		)
 
-		m := management.NewServer(ctx, &gatewayConfig.Spec.Management, g, pluginLoader,
+		mgmtOptions := []management.ManagementServerOption{
 			management.WithCapabilitiesDataSource(g),
 			management.WithHealthStatusDataSource(g),
+			management.WithKeyRotationDataSource(g),
+			management.WithDiagnosticsDataSource(g),
+			management.WithHealthHistoryDataSource(g),
+			management.WithStaleClusterDataSource(g),
+			management.WithPluginRolloutDataSource(g),
 			management.WithLifecycler(lifecycler),
-		)
+			management.WithTrustedProxies(gatewayConfig.Spec.TrustedProxies...),
+		}
+		if name := gatewayConfig.Spec.Management.AuthProvider; name != "" {
+			mw, ok := authProviders[name]
+			if !ok {
+				lg.With(
+					"name", name,
+				).Fatal("management API auth provider not found")
+			}
+			mgmtOptions = append(mgmtOptions, management.WithAuthMiddleware(mw))
+		}
+		m := management.NewServer(ctx, &gatewayConfig.Spec.Management, g, pluginLoader, mgmtOptions...)
 
 		g.MustRegisterCollector(m)
 
@@ -9,8 +9,12 @@ import (
 	v1 "github.com/rancher/opni/pkg/apis/management/v1"
 	cliutil "github.com/rancher/opni/pkg/opni/util"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
+	"github.com/samber/lo"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"os"
 	"strings"
 	"time"
 )
@@ -85,11 +89,97 @@ func validateClustersExist(ctx context.Context) error {
 	return nil
 }
 
+// targetSecurityFlags holds the auth and TLS flags shared by the add and edit
+// commands.
+type targetSecurityFlags struct {
+	basicAuthUsername  string
+	basicAuthPassword  string
+	bearerToken        string
+	bearerTokenFile    string
+	caCertFile         string
+	certFile           string
+	keyFile            string
+	serverName         string
+	insecureSkipVerify bool
+}
+
+func (f *targetSecurityFlags) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&f.basicAuthUsername, "basic-auth-username", "", "username for basic auth")
+	flags.StringVar(&f.basicAuthPassword, "basic-auth-password", "", "password for basic auth")
+	flags.StringVar(&f.bearerToken, "bearer-token", "", "bearer token to send with requests")
+	flags.StringVar(&f.bearerTokenFile, "bearer-token-file", "", "file containing a bearer token to send with requests")
+	flags.StringVar(&f.caCertFile, "ca-cert", "", "PEM-encoded CA certificate file used to verify the endpoint")
+	flags.StringVar(&f.certFile, "cert", "", "PEM-encoded client certificate file")
+	flags.StringVar(&f.keyFile, "key", "", "PEM-encoded client key file")
+	flags.StringVar(&f.serverName, "server-name", "", "server name used to verify the endpoint's certificate")
+	flags.BoolVar(&f.insecureSkipVerify, "insecure-skip-verify", false, "do not verify the endpoint's certificate")
+}
+
+func (f *targetSecurityFlags) authChanged(flags *pflag.FlagSet) bool {
+	return lo.SomeBy([]string{"basic-auth-username", "basic-auth-password", "bearer-token", "bearer-token-file"}, flags.Changed)
+}
+
+func (f *targetSecurityFlags) tlsChanged(flags *pflag.FlagSet) bool {
+	return lo.SomeBy([]string{"ca-cert", "cert", "key", "server-name", "insecure-skip-verify"}, flags.Changed)
+}
+
+func (f *targetSecurityFlags) auth() (*remoteread.TargetAuth, error) {
+	auth := &remoteread.TargetAuth{
+		BearerToken: f.bearerToken,
+	}
+	if f.bearerTokenFile != "" {
+		data, err := os.ReadFile(f.bearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read bearer token: %w", err)
+		}
+		auth.BearerToken = strings.TrimSpace(string(data))
+	}
+	if f.basicAuthUsername != "" || f.basicAuthPassword != "" {
+		auth.BasicAuth = &remoteread.BasicAuth{
+			Username: f.basicAuthUsername,
+			Password: f.basicAuthPassword,
+		}
+	}
+	return auth, nil
+}
+
+func (f *targetSecurityFlags) tls() (*remoteread.TargetTLS, error) {
+	tlsSpec := &remoteread.TargetTLS{
+		ServerName:         f.serverName,
+		InsecureSkipVerify: f.insecureSkipVerify,
+	}
+	for _, file := range []struct {
+		path  string
+		field *string
+	}{
+		{f.caCertFile, &tlsSpec.CaCertData},
+		{f.certFile, &tlsSpec.CertData},
+		{f.keyFile, &tlsSpec.KeyData},
+	} {
+		if file.path == "" {
+			continue
+		}
+		data, err := os.ReadFile(file.path)
+		if err != nil {
+			return nil, err
+		}
+		*file.field = string(data)
+	}
+	return tlsSpec, nil
+}
+
 func BuildImportAddCmd() *cobra.Command {
+	var security targetSecurityFlags
+
 	cmd := &cobra.Command{
 		Use:   "add <cluster> <name> <endpoint>",
 		Short: "Add a new import target",
-		Args:  cobra.ExactArgs(3),
+		Long: `
+Add a new import target. If the endpoint requires authentication or TLS, the
+credentials are encrypted by the gateway before they are stored, which requires
+an encryption key in the gateway's keyring.
+`[1:],
+		Args: cobra.ExactArgs(3),
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 			if len(args) == 0 {
 				return completeClusters(cmd, args, toComplete)
@@ -115,6 +205,21 @@ func BuildImportAddCmd() *cobra.Command {
 				},
 			}
 
+			if security.authChanged(cmd.Flags()) {
+				auth, err := security.auth()
+				if err != nil {
+					return err
+				}
+				target.Spec.Auth = auth
+			}
+			if security.tlsChanged(cmd.Flags()) {
+				tlsSpec, err := security.tls()
+				if err != nil {
+					return err
+				}
+				target.Spec.Tls = tlsSpec
+			}
+
 			request := &remoteread.TargetAddRequest{
 				Target: target,
 			}
@@ -129,12 +234,17 @@ func BuildImportAddCmd() *cobra.Command {
 		},
 	}
 
+	security.addFlags(cmd.Flags())
+
 	return cmd
 }
 
 func BuildImportEditCmd() *cobra.Command {
 	var newEndpoint string
 	var newName string
+	var security targetSecurityFlags
+	var clearAuth bool
+	var clearTLS bool
 
 	cmd := &cobra.Command{
 		Use:   "edit <cluster> <name>",
@@ -154,7 +264,16 @@ func BuildImportEditCmd() *cobra.Command {
 				return err
 			}
 
-			if newEndpoint == "" && newName == "" {
+			if clearAuth && security.authChanged(cmd.Flags()) {
+				return fmt.Errorf("--clear-auth cannot be used with other auth flags")
+			}
+			if clearTLS && security.tlsChanged(cmd.Flags()) {
+				return fmt.Errorf("--clear-tls cannot be used with other tls flags")
+			}
+			authChanged := clearAuth || security.authChanged(cmd.Flags())
+			tlsChanged := clearTLS || security.tlsChanged(cmd.Flags())
+
+			if newEndpoint == "" && newName == "" && !authChanged && !tlsChanged {
 				lg.Infof("no edits specified, doing nothing")
 			}
 
@@ -169,6 +288,27 @@ func BuildImportEditCmd() *cobra.Command {
 				},
 			}
 
+			switch {
+			case clearAuth:
+				request.TargetDiff.Auth = &remoteread.TargetAuth{}
+			case authChanged:
+				auth, err := security.auth()
+				if err != nil {
+					return err
+				}
+				request.TargetDiff.Auth = auth
+			}
+			switch {
+			case clearTLS:
+				request.TargetDiff.Tls = &remoteread.TargetTLS{}
+			case tlsChanged:
+				tlsSpec, err := security.tls()
+				if err != nil {
+					return err
+				}
+				request.TargetDiff.Tls = tlsSpec
+			}
+
 			_, err := remoteReadClient.EditTarget(cmd.Context(), request)
 
 			if err != nil {
@@ -184,6 +324,11 @@ func BuildImportEditCmd() *cobra.Command {
 
 	cmd.Flags().StringVar(&newName, "name", "", "the new name for the target")
 
+	// auth and tls flags replace all existing auth or tls settings
+	security.addFlags(cmd.Flags())
+	cmd.Flags().BoolVar(&clearAuth, "clear-auth", false, "remove the target's auth settings")
+	cmd.Flags().BoolVar(&clearTLS, "clear-tls", false, "remove the target's tls settings")
+
 	return cmd
 }
 
@@ -266,6 +411,8 @@ func BuildImportStartCmd() *cobra.Command {
 	var labelFilters []string
 	var startTimestampSecs int64
 	var endTimestampSecs int64
+	var chunkDuration time.Duration
+	var maxConcurrency int32
 	var follow bool
 
 	cmd := &cobra.Command{
@@ -281,7 +428,12 @@ func BuildImportStartCmd() *cobra.Command {
 			return nil, cobra.ShellCompDirectiveNoFileComp
 		},
 		Short: "start an import",
-		Args:  cobra.ExactArgs(2),
+		Long: `Start an import of the given time range from the target.
+
+The time range is split into chunks which are imported concurrently. If an
+import is stopped or fails, starting it again with the same time range, filters,
+and chunk duration resumes it from the last completed chunk.`,
+		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := validateClustersExist(cmd.Context()); err != nil {
 				return err
@@ -315,6 +467,10 @@ func BuildImportStartCmd() *cobra.Command {
 					},
 				},
 				Query: query,
+				Options: &remoteread.ImportOptions{
+					ChunkDuration:  durationpb.New(chunkDuration),
+					MaxConcurrency: maxConcurrency,
+				},
 			}
 
 			ctx := cmd.Context()
@@ -339,6 +495,9 @@ func BuildImportStartCmd() *cobra.Command {
 	cmd.Flags().Int64Var(&startTimestampSecs, "start", time.Now().Unix()-int64(time.Hour.Seconds())*3, "start time for the remote read in seconds since epoch")
 	cmd.Flags().Int64Var(&endTimestampSecs, "end", time.Now().Unix(), "start time for the remote read")
 
+	cmd.Flags().DurationVar(&chunkDuration, "chunk-duration", time.Minute, "time range covered by each chunk of the import")
+	cmd.Flags().Int32Var(&maxConcurrency, "max-concurrency", 4, "maximum number of chunks to import at once")
+
 	cmd.Flags().BoolVar(&follow, "follow", false, "follow import progress (the same as calling start then progress immediately)")
 
 	return cmd
@@ -468,6 +627,8 @@ func BuildImportCmd() *cobra.Command {
 	cmd.AddCommand(BuildImportStopCmd())
 	cmd.AddCommand(BuildProgressCmd())
 	cmd.AddCommand(BuildDiscoverCmd())
+	cmd.AddCommand(BuildImportUploadCmd())
+	cmd.AddCommand(BuildImportUploadStatusCmd())
 
 	ConfigureManagementCommand(cmd)
 	ConfigureImportCommand(cmd)
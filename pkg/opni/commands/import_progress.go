@@ -42,6 +42,10 @@ func getProgressAsPercent(progress *remoteread.TargetProgress) float64 {
 		return 0
 	}
 
+	if progress.TotalChunks > 0 {
+		return math.Min(1, float64(progress.CompletedChunks)/float64(progress.TotalChunks))
+	}
+
 	percent := float64(progress.LastReadTimestamp.Seconds-progress.StartTimestamp.Seconds) /
 		float64(progress.EndTimestamp.Seconds-progress.StartTimestamp.Seconds)
 
@@ -57,6 +61,7 @@ type ProgressModel struct {
 
 	err      error
 	lastRead *timestamppb.Timestamp
+	chunks   string
 	state    string
 }
 
@@ -127,6 +132,9 @@ func (model ProgressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		model.percent = getProgressAsPercent(msg.Progress)
 		model.lastRead = msg.Progress.LastReadTimestamp
+		if msg.Progress.GetTotalChunks() > 0 {
+			model.chunks = fmt.Sprintf("%d/%d", msg.Progress.CompletedChunks, msg.Progress.TotalChunks)
+		}
 
 		if model.percent >= 1 {
 			importDone = true
@@ -158,6 +166,10 @@ func (model ProgressModel) View() string {
 		builder.WriteString(paddingStr + "Last Read Timestamp: " + model.lastRead.AsTime().String() + "\n")
 	}
 
+	if model.chunks != "" {
+		builder.WriteString(paddingStr + "Chunks: " + model.chunks + "\n")
+	}
+
 	if model.err != nil {
 		builder.WriteString(paddingStr + "Error: " + model.err.Error() + "\n\n")
 	}
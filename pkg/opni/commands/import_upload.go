@@ -0,0 +1,218 @@
+//go:build !noplugins
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
+)
+
+// size of each chunk sent to the gateway, kept well below the default grpc
+// max message size
+const uploadChunkSize = 1024 * 1024
+
+var uploadFormats = map[string]remoteread.UploadFormat{
+	"auto":        remoteread.UploadFormat_Auto,
+	"tsdb":        remoteread.UploadFormat_TSDBBlocks,
+	"openmetrics": remoteread.UploadFormat_OpenMetrics,
+}
+
+func BuildImportUploadCmd() *cobra.Command {
+	var name string
+	var format string
+	var follow bool
+
+	cmd := &cobra.Command{
+		Use: "upload <cluster> <file>",
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return completeClusters(cmd, args, toComplete)
+			}
+			return nil, cobra.ShellCompDirectiveDefault
+		},
+		Short: "import an offline snapshot of TSDB blocks or OpenMetrics data",
+		Long: `Upload an offline snapshot of metrics to the gateway and import it into a cluster.
+
+The file can be a tar archive (optionally gzip-compressed) of TSDB block
+directories or OpenMetrics files, or a single OpenMetrics file. All samples in
+OpenMetrics files must have timestamps.
+
+TSDB blocks are written directly to Cortex object storage. Samples in OpenMetrics
+files are converted to blocks in the same way, except for samples from the last
+30 minutes, which are imported using remote write. Only samples imported using
+remote write are affected by the cluster's relabel rules and ingestion quotas.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateClustersExist(cmd.Context()); err != nil {
+				return err
+			}
+
+			clusterId := args[0]
+			path := args[1]
+
+			uploadFormat, ok := uploadFormats[format]
+			if !ok {
+				return fmt.Errorf("unknown format %q", format)
+			}
+			if name == "" {
+				name = strings.SplitN(filepath.Base(path), ".", 2)[0]
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			stream, err := remoteReadClient.UploadImport(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&remoteread.UploadImportRequest{
+				Data: &remoteread.UploadImportRequest_Header{
+					Header: &remoteread.UploadHeader{
+						Name:      name,
+						ClusterId: clusterId,
+						Format:    uploadFormat,
+					},
+				},
+			}); err != nil {
+				return sendError(stream, err)
+			}
+
+			buf := make([]byte, uploadChunkSize)
+			for {
+				n, err := f.Read(buf)
+				if n > 0 {
+					if err := stream.Send(&remoteread.UploadImportRequest{
+						Data: &remoteread.UploadImportRequest_Chunk{
+							Chunk: buf[:n],
+						},
+					}); err != nil {
+						return sendError(stream, err)
+					}
+				}
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if _, err := stream.CloseAndRecv(); err != nil {
+				return err
+			}
+
+			lg.Infof("upload %q started", name)
+
+			if follow {
+				return followUploadStatus(cmd.Context(), name)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "name used to check the status of the upload (defaults to the file name)")
+	cmd.Flags().StringVar(&format, "format", "auto", "format of the uploaded file (auto, tsdb, or openmetrics)")
+	cmd.Flags().BoolVar(&follow, "follow", false, "follow the upload status until the import finishes")
+
+	cmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions([]string{"auto", "tsdb", "openmetrics"}, cobra.ShellCompDirectiveNoFileComp))
+
+	return cmd
+}
+
+// sendError returns the error from the server if a stream was closed by the
+// server while sending
+func sendError(stream remoteread.RemoteReadGateway_UploadImportClient, err error) error {
+	if errors.Is(err, io.EOF) {
+		_, err = stream.CloseAndRecv()
+	}
+	return err
+}
+
+func BuildImportUploadStatusCmd() *cobra.Command {
+	var follow bool
+
+	cmd := &cobra.Command{
+		Use:   "upload-status <name>",
+		Short: "show the status of an uploaded snapshot import",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if follow {
+				return followUploadStatus(cmd.Context(), args[0])
+			}
+
+			status, err := remoteReadClient.GetUploadStatus(cmd.Context(), &remoteread.UploadStatusRequest{
+				Name: args[0],
+			})
+			if err != nil {
+				return err
+			}
+			printUploadStatus(status, 0)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&follow, "follow", false, "follow the upload status until the import finishes")
+
+	return cmd
+}
+
+func followUploadStatus(ctx context.Context, name string) error {
+	printedLogs := 0
+	for {
+		status, err := remoteReadClient.GetUploadStatus(ctx, &remoteread.UploadStatusRequest{
+			Name: name,
+		})
+		if err != nil {
+			return err
+		}
+		printedLogs = printUploadStatus(status, printedLogs)
+
+		switch status.State {
+		case remoteread.TargetState_Completed:
+			return nil
+		case remoteread.TargetState_Failed:
+			return fmt.Errorf("import failed: %s", status.Message)
+		case remoteread.TargetState_Canceled:
+			return fmt.Errorf("import was canceled")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// printUploadStatus prints the logs after the given index, followed by the
+// current progress, and returns the number of logs printed so far.
+func printUploadStatus(status *remoteread.UploadStatus, skipLogs int) int {
+	logs := status.GetLogs()
+	for i := skipLogs; i < len(logs); i++ {
+		fmt.Printf("%s [%s] %s\n",
+			logs[i].GetTimestamp().AsTime().Format(time.RFC3339),
+			zapcore.Level(logs[i].GetLevel()).CapitalString(),
+			logs[i].GetMsg(),
+		)
+	}
+	if status.GetTotalItems() > 0 {
+		fmt.Printf("%s: %d/%d items imported\n", strings.ToLower(status.GetState().String()), status.GetCompletedItems(), status.GetTotalItems())
+	} else {
+		fmt.Println(strings.ToLower(status.GetState().String()))
+	}
+	return len(logs)
+}
@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/rancher/opni/apis/v1beta2"
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
 	"github.com/rancher/opni/pkg/config"
 	"github.com/rancher/opni/pkg/config/v1beta1"
 	"github.com/rancher/opni/pkg/logger"
@@ -16,6 +18,7 @@ import (
 	"github.com/rancher/opni/pkg/util"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -26,6 +29,7 @@ func BuildKeyringsCmd() *cobra.Command {
 		Aliases: []string{"keyring"},
 	}
 	cmd.AddCommand(BuildKeyringsGetCmd())
+	cmd.AddCommand(BuildKeyringsRotateCmd())
 	ConfigureManagementCommand(cmd)
 	return cmd
 }
@@ -123,6 +127,37 @@ func BuildKeyringsGetCmd() *cobra.Command {
 	return cmd
 }
 
+func BuildKeyringsRotateCmd() *cobra.Command {
+	var gracePeriod time.Duration
+	cmd := &cobra.Command{
+		Use:   "rotate <cluster-id> [cluster-id]...",
+		Short: "Rotate the shared keys for one or more connected clusters",
+		Long: `Performs a new key exchange with each connected agent and replaces its keyring's
+shared keys. The previous keys remain valid for the duration of the grace period.`,
+		Args: cobra.MinimumNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completeClusters(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, id := range args {
+				_, err := mgmtClient.RotateClusterKeys(cmd.Context(), &managementv1.RotateClusterKeysRequest{
+					Cluster: &corev1.Reference{
+						Id: id,
+					},
+					GracePeriod: durationpb.New(gracePeriod),
+				})
+				if err != nil {
+					return fmt.Errorf("failed to rotate keys for cluster %s: %w", id, err)
+				}
+				lg.Infof("Rotated keys for cluster %s", id)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&gracePeriod, "grace-period", 5*time.Minute, "Duration for which the previous keys will remain valid")
+	return cmd
+}
+
 func init() {
 	AddCommandsToGroup(ManagementAPI, BuildKeyringsCmd())
 }
@@ -0,0 +1,153 @@
+package commands
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rancher/opni/pkg/keyring"
+	cliutil "github.com/rancher/opni/pkg/opni/util"
+	"github.com/rancher/opni/pkg/patch"
+	"github.com/rancher/opni/pkg/pkp"
+	"github.com/rancher/opni/pkg/plugins"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func BuildPluginsCmd() *cobra.Command {
+	pluginsCmd := &cobra.Command{
+		Use:     "plugins",
+		Aliases: []string{"plugin"},
+		Short:   "Manage agent plugins",
+	}
+	pluginsCmd.AddCommand(BuildPluginsRolloutCmd())
+	pluginsCmd.AddCommand(BuildPluginsKeygenCmd())
+	pluginsCmd.AddCommand(BuildPluginsSignCmd())
+	return pluginsCmd
+}
+
+func BuildPluginsRolloutCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollout",
+		Short: "Show the status of the current agent plugin rollout",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			status, err := mgmtClient.GetPluginRolloutStatus(cmd.Context(), &emptypb.Empty{})
+			if err != nil {
+				return err
+			}
+			fmt.Println(cliutil.RenderPluginRolloutStatus(status))
+			return nil
+		},
+	}
+	ConfigureManagementCommand(cmd)
+	return cmd
+}
+
+func BuildPluginsKeygenCmd() *cobra.Command {
+	var keyPath string
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a plugin signing key",
+		Long: `Generate an ed25519 key for signing plugins.
+
+The key is written as an ephemeral signing key, and is used with
+'opni plugins sign'. The printed public key pin can be added to the agent's
+trusted plugin keys (plugins.verification.trustedKeys).`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stat(keyPath); err == nil {
+				return fmt.Errorf("%s already exists", keyPath)
+			}
+			key := &keyring.EphemeralKey{
+				Usage:  keyring.Signing,
+				Secret: make([]byte, ed25519.SeedSize),
+			}
+			if _, err := rand.Read(key.Secret); err != nil {
+				return err
+			}
+			signingKey, err := key.SigningKey()
+			if err != nil {
+				return err
+			}
+			pin, err := pkp.NewForPublicKey(signingKey.Public(), pkp.AlgB2B256)
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(keyPath, data, 0600); err != nil {
+				return err
+			}
+			lg.Infof("wrote signing key to %s", keyPath)
+			fmt.Println(pin.Encode())
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&keyPath, "key", "plugin-signing.key", "Path to write the signing key to")
+	return cmd
+}
+
+func BuildPluginsSignCmd() *cobra.Command {
+	var keyPath, dir string
+	cmd := &cobra.Command{
+		Use:   "sign",
+		Short: "Sign the plugins in a directory",
+		Long: fmt.Sprintf(`Sign all plugins in a directory with a plugin signing key.
+
+Signatures are written to %s in the plugin directory, and are sent
+to agents by the gateway along with the plugins.`, patch.SignaturesFilename),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(keyPath)
+			if err != nil {
+				return err
+			}
+			ekey, err := keyring.LoadEphemeralKey(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("failed to read signing key: %w", err)
+			}
+			key, err := ekey.SigningKey()
+			if err != nil {
+				return fmt.Errorf("failed to read signing key: %w", err)
+			}
+			archive, err := patch.GetFilesystemPlugins(plugins.DiscoveryConfig{
+				Dir:    dir,
+				Logger: lg.Zap(),
+			})
+			if err != nil {
+				return err
+			}
+			if len(archive.Items) == 0 {
+				return errors.New("no plugins found")
+			}
+			fsys := afero.NewOsFs()
+			signatures, err := patch.ReadSignatures(fsys, dir)
+			if err != nil {
+				return err
+			}
+			for _, item := range archive.Items {
+				patch.Sign(key, item.Metadata)
+				signatures.Signatures[item.Metadata.Digest] = item.Metadata.Signature
+				lg.Infof("signed %s (%s)", item.Metadata.Filename, item.Metadata.Module)
+			}
+			return patch.WriteSignatures(fsys, dir, signatures)
+		},
+	}
+	cmd.Flags().StringVar(&keyPath, "key", "", "Path to a signing key generated by 'opni plugins keygen'")
+	cmd.Flags().StringVar(&dir, "dir", "", "Plugin directory")
+	cmd.MarkFlagRequired("key")
+	cmd.MarkFlagRequired("dir")
+	return cmd
+}
+
+func init() {
+	AddCommandsToGroup(ManagementAPI, BuildPluginsCmd())
+}
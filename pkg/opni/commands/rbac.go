@@ -42,10 +42,25 @@ func BuildRoleBindingsCmd() *cobra.Command {
 func BuildRolesCreateCmd() *cobra.Command {
 	var clusterIDs []string
 	var matchLabelsStrings []string
+	var ruleStrings []string
+	var metricsLabelMatchers []string
 	matchLabels := map[string]string{}
+	var rules []*corev1.PolicyRule
 	cmd := &cobra.Command{
-		Use:               "create <role-id>",
-		Short:             "Create a role",
+		Use:   "create <role-id>",
+		Short: "Create a role",
+		Long: `Create a role.
+
+Rules are specified using the syntax "verbs:resources[:capabilities]", where
+each field is a comma-separated list, and "*" matches anything. For example:
+
+  --rule get,list:clusters
+  --rule '*:capabilities:metrics'
+
+Metrics label matchers limit the metrics which can be queried in the selected
+clusters, and are written the same way as in a PromQL selector. For example:
+
+  --metrics-label-matcher 'namespace=~"team-a-.*"'`,
 		Args:              cobra.ExactArgs(1),
 		ValidArgsFunction: cobra.NoFileCompletions,
 		PreRunE: func(cmd *cobra.Command, args []string) error {
@@ -55,6 +70,10 @@ func BuildRolesCreateCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			rules, err = cliutil.ParsePolicyRules(ruleStrings)
+			if err != nil {
+				return err
+			}
 			return nil
 		},
 		Run: func(cmd *cobra.Command, args []string) {
@@ -64,6 +83,8 @@ func BuildRolesCreateCmd() *cobra.Command {
 				MatchLabels: &corev1.LabelSelector{
 					MatchLabels: matchLabels,
 				},
+				Rules:                rules,
+				MetricsLabelMatchers: metricsLabelMatchers,
 			}
 			_, err := mgmtClient.CreateRole(cmd.Context(), role)
 			if err != nil {
@@ -74,6 +95,8 @@ func BuildRolesCreateCmd() *cobra.Command {
 	}
 	cmd.Flags().StringSliceVar(&clusterIDs, "cluster-ids", []string{}, "Explicit cluster IDs to allow")
 	cmd.Flags().StringSliceVar(&matchLabelsStrings, "match-labels", []string{}, "List of key=value cluster labels to match allowed clusters")
+	cmd.Flags().StringArrayVar(&ruleStrings, "rule", []string{}, "Policy rule of the form verbs:resources[:capabilities] (can be repeated)")
+	cmd.Flags().StringArrayVar(&metricsLabelMatchers, "metrics-label-matcher", []string{}, "PromQL label matcher limiting the metrics which can be queried (can be repeated)")
 	return cmd
 }
 
@@ -139,9 +162,13 @@ func BuildRolesListCmd() *cobra.Command {
 
 func BuildRoleBindingsCreateCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "create <rolebinding-id> <role-id> <user-id>...",
+		Use:   "create <rolebinding-id> <role-id> <subject>...",
 		Short: "Create a role binding",
-		Args:  cobra.MinimumNArgs(3),
+		Long: `Create a role binding.
+
+Subjects can be user IDs, or group names prefixed with "group:" (e.g.
+"group:admins") if the auth provider is configured with a groups claim.`,
+		Args: cobra.MinimumNArgs(3),
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 			if len(args) == 1 {
 				return completeRoles(cmd, args, toComplete)
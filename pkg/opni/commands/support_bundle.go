@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+)
+
+func BuildSupportBundleCmd() *cobra.Command {
+	var output string
+	var logLines int32
+	var local bool
+	var agentAddress string
+	cmd := &cobra.Command{
+		Use:   "support-bundle [<cluster-id>]",
+		Short: "Collect a support bundle from an agent",
+		Long: `Requests a support bundle from a connected agent through the gateway. The bundle
+is a gzipped tarball containing the agent's config (with secrets redacted), health
+conditions, plugin manifest, capability status, recent logs (with secrets redacted),
+and goroutine and heap profiles.
+
+With --local, the bundle is requested directly from the agent's HTTP listener
+instead, which works while the agent is disconnected from the gateway. This must
+be run from somewhere the agent's listen address is reachable, such as inside the
+agent pod.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if local {
+				return cobra.MaximumNArgs(1)(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completeClusters(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := "local"
+			if len(args) > 0 {
+				name = args[0]
+			}
+			if output == "" {
+				output = fmt.Sprintf("support-bundle-%s-%s.tar.gz", name, time.Now().Format("20060102-150405"))
+			}
+			var recv func() ([]byte, error)
+			if local {
+				body, err := requestLocalSupportBundle(cmd.Context(), agentAddress, logLines)
+				if err != nil {
+					return err
+				}
+				defer body.Close()
+				buf := make([]byte, 32*1024)
+				recv = func() ([]byte, error) {
+					n, err := body.Read(buf)
+					if n > 0 {
+						return buf[:n], nil
+					}
+					return nil, err
+				}
+			} else {
+				stream, err := mgmtClient.CollectClusterDiagnostics(cmd.Context(), &managementv1.CollectClusterDiagnosticsRequest{
+					Cluster: &corev1.Reference{
+						Id: args[0],
+					},
+					LogLines: logLines,
+				})
+				if err != nil {
+					return err
+				}
+				recv = func() ([]byte, error) {
+					chunk, err := stream.Recv()
+					return chunk.GetData(), err
+				}
+			}
+
+			var out io.Writer
+			if output == "-" {
+				out = cmd.OutOrStdout()
+			} else {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+
+			var size int
+			for {
+				data, err := recv()
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					if output != "-" {
+						os.Remove(output)
+					}
+					return fmt.Errorf("failed to collect support bundle: %w", err)
+				}
+				n, err := out.Write(data)
+				if err != nil {
+					return err
+				}
+				size += n
+			}
+			if output != "-" {
+				lg.Infof("Wrote support bundle for cluster %s to %s (%d bytes)", name, output, size)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output file (defaults to support-bundle-<cluster-id>-<timestamp>.tar.gz; use - for stdout)")
+	cmd.Flags().Int32Var(&logLines, "log-lines", 0, "Maximum number of recent agent log lines to include (default all buffered lines)")
+	cmd.Flags().BoolVar(&local, "local", false, "Collect the bundle directly from the agent instead of through the gateway")
+	cmd.Flags().StringVar(&agentAddress, "agent-address", "localhost:8080", "Address of the agent's HTTP listener, used with --local")
+	ConfigureManagementCommand(cmd)
+	managementPreRun := cmd.PersistentPreRunE
+	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if local {
+			// the management api is not needed
+			return nil
+		}
+		return managementPreRun(cmd, args)
+	}
+	return cmd
+}
+
+func requestLocalSupportBundle(ctx context.Context, address string, logLines int32) (io.ReadCloser, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent address: %w", err)
+	}
+	u.Path = "/debug/support-bundle"
+	u.RawQuery = url.Values{"logLines": []string{strconv.Itoa(int(logLines))}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to contact agent: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("failed to collect support bundle: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
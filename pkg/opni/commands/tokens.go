@@ -29,6 +29,10 @@ func BuildTokensCmd() *cobra.Command {
 func BuildTokensCreateCmd() *cobra.Command {
 	var ttl string
 	var labels []string
+	var maxUsages int64
+	var allowedCIDRs []string
+	var clusterID string
+	var requireApproval bool
 	tokensCreateCmd := &cobra.Command{
 		Use:   "create",
 		Short: "Create a bootstrap token",
@@ -43,8 +47,12 @@ func BuildTokensCreateCmd() *cobra.Command {
 			}
 			t, err := mgmtClient.CreateBootstrapToken(cmd.Context(),
 				&managementv1.CreateBootstrapTokenRequest{
-					Ttl:    durationpb.New(duration),
-					Labels: labelMap,
+					Ttl:                durationpb.New(duration),
+					Labels:             labelMap,
+					MaxUsages:          maxUsages,
+					AllowedSourceCIDRs: allowedCIDRs,
+					ExpectedClusterID:  clusterID,
+					RequireApproval:    requireApproval,
 				})
 			if err != nil {
 				lg.Fatal(err)
@@ -54,6 +62,10 @@ func BuildTokensCreateCmd() *cobra.Command {
 	}
 	tokensCreateCmd.Flags().StringVar(&ttl, "ttl", "300s", "Time to live")
 	tokensCreateCmd.Flags().StringSliceVar(&labels, "labels", []string{}, "Labels which will be auto-applied to any clusters created with this token")
+	tokensCreateCmd.Flags().Int64Var(&maxUsages, "max-usages", 0, "Maximum number of times the token can be used (0 for unlimited)")
+	tokensCreateCmd.Flags().StringSliceVar(&allowedCIDRs, "allowed-cidrs", []string{}, "Only allow the token to be used from addresses in these CIDRs")
+	tokensCreateCmd.Flags().StringVar(&clusterID, "cluster-id", "", "Only allow the token to be used by the agent with this cluster ID")
+	tokensCreateCmd.Flags().BoolVar(&requireApproval, "require-approval", false, "Require clusters created with this token to be approved before their agents can connect")
 	return tokensCreateCmd
 }
 
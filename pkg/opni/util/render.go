@@ -14,6 +14,8 @@ import (
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexadmin"
 	"github.com/samber/lo"
 	"github.com/ttacon/chalk"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func RenderBootstrapToken(token *corev1.BootstrapToken) string {
@@ -25,19 +27,35 @@ func RenderBootstrapToken(token *corev1.BootstrapToken) string {
 func RenderBootstrapTokenList(list *corev1.BootstrapTokenList) string {
 	w := table.NewWriter()
 	w.SetStyle(table.StyleColoredDark)
-	w.AppendHeader(table.Row{"ID", "TOKEN", "TTL", "USAGES", "LABELS"})
+	w.AppendHeader(table.Row{"ID", "TOKEN", "TTL", "USAGES", "LABELS", "RESTRICTIONS"})
 	for _, t := range list.Items {
 		token, err := tokens.FromBootstrapToken(t)
 		if err != nil {
 			return err.Error()
 		}
 
+		md := t.GetMetadata()
+		usages := fmt.Sprint(md.GetUsageCount())
+		if md.GetMaxUsages() > 0 {
+			usages = fmt.Sprintf("%d/%d", md.GetUsageCount(), md.GetMaxUsages())
+		}
+		var restrictions []string
+		if id := md.GetExpectedClusterID(); id != "" {
+			restrictions = append(restrictions, "cluster="+id)
+		}
+		if cidrs := md.GetAllowedSourceCIDRs(); len(cidrs) > 0 {
+			restrictions = append(restrictions, "cidrs="+strings.Join(cidrs, ","))
+		}
+		if md.GetRequireApproval() {
+			restrictions = append(restrictions, "requires approval")
+		}
 		w.AppendRow(table.Row{
 			token.HexID(),
 			token.EncodeHex(),
-			(time.Duration(t.GetMetadata().GetTtl()) * time.Second).String(),
-			t.GetMetadata().GetUsageCount(),
-			strings.Join(JoinKeyValuePairs(t.GetMetadata().GetLabels()), "\n"),
+			(time.Duration(md.GetTtl()) * time.Second).String(),
+			usages,
+			strings.Join(JoinKeyValuePairs(md.GetLabels()), "\n"),
+			strings.Join(restrictions, "\n"),
 		})
 	}
 	return w.Render()
@@ -103,7 +121,24 @@ func RenderRole(role *corev1.Role) string {
 func RenderRoleList(list *corev1.RoleList) string {
 	w := table.NewWriter()
 	w.SetStyle(table.StyleColoredDark)
-	w.AppendHeader(table.Row{"ID", "SELECTOR", "CLUSTER IDS"})
+	header := table.Row{"ID", "SELECTOR", "CLUSTER IDS"}
+	anyRolesHaveRules := false
+	anyRolesHaveMatchers := false
+	for _, role := range list.Items {
+		if len(role.Rules) > 0 {
+			anyRolesHaveRules = true
+		}
+		if len(role.MetricsLabelMatchers) > 0 {
+			anyRolesHaveMatchers = true
+		}
+	}
+	if anyRolesHaveRules {
+		header = append(header, "RULES")
+	}
+	if anyRolesHaveMatchers {
+		header = append(header, "METRICS LABEL MATCHERS")
+	}
+	w.AppendHeader(header)
 	for _, role := range list.Items {
 		clusterIds := strings.Join(role.ClusterIDs, "\n")
 		if len(clusterIds) == 0 {
@@ -113,7 +148,18 @@ func RenderRoleList(list *corev1.RoleList) string {
 		if expressionStr == "" {
 			expressionStr = "(none)"
 		}
-		w.AppendRow(table.Row{role.Id, expressionStr, clusterIds})
+		row := table.Row{role.Id, expressionStr, clusterIds}
+		if anyRolesHaveRules {
+			rules := make([]string, len(role.Rules))
+			for i, rule := range role.Rules {
+				rules[i] = FormatPolicyRule(rule)
+			}
+			row = append(row, strings.Join(rules, "\n"))
+		}
+		if anyRolesHaveMatchers {
+			row = append(row, strings.Join(role.MetricsLabelMatchers, "\n"))
+		}
+		w.AppendRow(row)
 	}
 	return w.Render()
 }
@@ -328,3 +374,141 @@ func RenderClusterDetails(cluster *corev1.Cluster) string {
 	}
 	return w.Render()
 }
+
+func RenderAuditEventList(list *managementv1.AuditEventList) string {
+	w := table.NewWriter()
+	w.SetStyle(table.StyleColoredDark)
+	w.AppendHeader(table.Row{"TIME", "SUBJECT", "METHOD", "TARGETS", "STATUS"})
+	for _, e := range list.Items {
+		targets := lo.Map(e.GetTargets(), func(ref *corev1.Reference, _ int) string {
+			return ref.GetId()
+		})
+		result := codes.Code(e.GetStatus().GetCode()).String()
+		if msg := e.GetStatus().GetMessage(); msg != "" {
+			result = fmt.Sprintf("%s: %s", result, msg)
+		}
+		w.AppendRow(table.Row{
+			e.GetTimestamp().AsTime().Local().Format(time.RFC3339),
+			e.GetSubject(),
+			e.GetMethod(),
+			strings.Join(targets, "\n"),
+			result,
+		})
+	}
+	return w.Render()
+}
+
+func RenderStaleClusterReport(report *managementv1.StaleClusterReport) string {
+	formatTime := func(ts *timestamppb.Timestamp) string {
+		if ts == nil {
+			return "-"
+		}
+		return ts.AsTime().Local().Format(time.RFC3339)
+	}
+	w := table.NewWriter()
+	w.SetStyle(table.StyleColoredDark)
+	w.AppendHeader(table.Row{"ID", "LAST SEEN", "STALE SINCE", "DELETION TIME", "ACTION"})
+	for _, item := range report.Items {
+		w.AppendRow(table.Row{
+			item.GetCluster().GetId(),
+			formatTime(item.GetLastSeen()),
+			formatTime(item.GetStaleSince()),
+			formatTime(item.GetDeletionTime()),
+			item.GetAction().String(),
+		})
+	}
+	return w.Render()
+}
+
+func RenderPluginRolloutStatus(status *managementv1.PluginRolloutStatus) string {
+	formatTime := func(ts *timestamppb.Timestamp) string {
+		if ts == nil {
+			return "-"
+		}
+		return ts.AsTime().Local().Format(time.RFC3339)
+	}
+	shortDigest := func(digest string) string {
+		if len(digest) > 12 {
+			return digest[:12]
+		}
+		if digest == "" {
+			return "-"
+		}
+		return digest
+	}
+	header := table.NewWriter()
+	header.SetStyle(table.StyleColoredDark)
+	header.AppendRows([]table.Row{
+		{"PHASE", status.GetPhase().String()},
+		{"STABLE", shortDigest(status.GetStableDigest())},
+		{"TARGET", shortDigest(status.GetTargetDigest())},
+		{"STARTED", formatTime(status.GetStartTime())},
+	})
+	if msg := status.GetMessage(); msg != "" {
+		header.AppendRow(table.Row{"MESSAGE", msg})
+	}
+	if len(status.GetWaves()) == 0 {
+		return header.Render()
+	}
+
+	w := table.NewWriter()
+	w.SetStyle(table.StyleColoredDark)
+	w.AppendHeader(table.Row{"WAVE", "CLUSTERS", "DEGRADED", "STARTED", "COMPLETED"})
+	for i, wave := range status.GetWaves() {
+		name := fmt.Sprint(i)
+		if wave.GetCanary() {
+			name += " (canary)"
+		}
+		if int32(i) == status.GetCurrentWave() && status.GetPhase() == managementv1.PluginRolloutPhase_RolloutInProgress {
+			name += " *"
+		}
+		degraded := make([]string, 0, len(wave.GetDegraded()))
+		for _, ref := range wave.GetDegraded() {
+			degraded = append(degraded, ref.GetId())
+		}
+		w.AppendRow(table.Row{
+			name,
+			len(wave.GetClusters()),
+			strings.Join(degraded, "\n"),
+			formatTime(wave.GetStartTime()),
+			formatTime(wave.GetCompletionTime()),
+		})
+	}
+	return header.Render() + "\n" + w.Render()
+}
+
+func RenderClusterHealthHistory(history *managementv1.ClusterHealthHistory) string {
+	flappingSince := "-"
+	if ts := history.GetFlappingSince(); ts != nil {
+		flappingSince = ts.AsTime().Local().Format(time.RFC3339)
+	}
+	header := table.NewWriter()
+	header.SetStyle(table.StyleColoredDark)
+	header.AppendRows([]table.Row{
+		{"CLUSTER", history.GetCluster().GetId()},
+		{"UPTIME", fmt.Sprintf("%.2f%%", history.GetUptimePercent())},
+		{"DISCONNECTS", history.GetDisconnects()},
+		{"FLAPPING SINCE", flappingSince},
+	})
+	if len(history.GetEvents()) == 0 {
+		return header.Render()
+	}
+
+	w := table.NewWriter()
+	w.SetStyle(table.StyleColoredDark)
+	w.AppendHeader(table.Row{"TIME", "EVENT", "READY", "CONDITIONS"})
+	for _, event := range history.GetEvents() {
+		ready, conditions := "", ""
+		if event.GetType() == managementv1.HealthEventType_HealthChanged {
+			ready = fmt.Sprint(event.GetHealth().GetReady())
+			conditions = strings.Join(event.GetHealth().GetConditions(), "\n")
+		}
+		w.AppendRow(table.Row{
+			event.GetTimestamp().AsTime().Local().Format(time.RFC3339),
+			event.GetType().String(),
+			ready,
+			conditions,
+		})
+	}
+	return header.Render() + "\n" + w.Render()
+}
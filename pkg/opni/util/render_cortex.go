@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
 	"strings"
+	"time"
 
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/jedib0t/go-pretty/v6/text"
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexadmin"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexops"
 	"github.com/samber/lo"
 	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 func RenderClusterListWithStats(list *corev1.ClusterList, status []*corev1.HealthStatus, stats *cortexadmin.UserIDStatsList) string {
@@ -207,7 +211,7 @@ func servicesByName[T interface {
 func RenderTargetList(list *remoteread.TargetList) string {
 	writer := table.NewWriter()
 	writer.SetStyle(table.StyleColoredDark)
-	writer.AppendHeader(table.Row{"CLUSTER", "NAME", "ENDPOINT", "LAST READ", "STATE", "MESSAGE"})
+	writer.AppendHeader(table.Row{"CLUSTER", "NAME", "ENDPOINT", "AUTH", "TLS", "LAST READ", "CHUNKS", "STATE", "MESSAGE"})
 
 	for _, target := range list.Targets {
 		var state string
@@ -227,12 +231,35 @@ func RenderTargetList(list *remoteread.TargetList) string {
 		}
 
 		// todo: we should be able to accept whatever time format given here as the --start parameter to opni import start
-		var lastRead string
+		var lastRead, chunks string
 		if progress := target.Status.Progress; progress != nil {
 			lastRead = progress.LastReadTimestamp.AsTime().String()
+			if progress.TotalChunks > 0 {
+				chunks = fmt.Sprintf("%d/%d", progress.CompletedChunks, progress.TotalChunks)
+			}
+		}
+
+		var auth string
+		switch {
+		case target.Spec.GetAuth().GetBasicAuth() != nil:
+			auth = "basic"
+		case target.Spec.GetAuth().GetBearerToken() != "":
+			auth = "bearer"
+		}
+
+		var tlsMode string
+		if tlsSpec := target.Spec.GetTls(); tlsSpec != nil {
+			switch {
+			case tlsSpec.GetInsecureSkipVerify():
+				tlsMode = "insecure"
+			case tlsSpec.GetCertData() != "":
+				tlsMode = "mutual"
+			default:
+				tlsMode = "enabled"
+			}
 		}
 
-		row := table.Row{target.Meta.ClusterId, target.Meta.Name, target.Spec.Endpoint, lastRead, state, target.Status.Message}
+		row := table.Row{target.Meta.ClusterId, target.Meta.Name, target.Spec.Endpoint, auth, tlsMode, lastRead, chunks, state, target.Status.Message}
 
 		writer.AppendRow(row)
 	}
@@ -243,12 +270,129 @@ func RenderTargetList(list *remoteread.TargetList) string {
 func RenderDiscoveryEntries(entries []*remoteread.DiscoveryEntry) string {
 	writer := table.NewWriter()
 	writer.SetStyle(table.StyleColoredDark)
-	writer.AppendHeader(table.Row{"CLUSTER", "NAME", "EXTERNAL", "INTERNAL"})
+	writer.AppendHeader(table.Row{"CLUSTER", "NAME", "EXTERNAL", "INTERNAL", "TLS"})
 
 	for _, entry := range entries {
-		row := table.Row{entry.ClusterId, entry.Name, entry.ExternalEndpoint, entry.InternalEndpoint}
+		var tlsMode string
+		switch {
+		case entry.GetTls() == nil:
+		case entry.GetTls().GetCaCertData() != "":
+			tlsMode = "enabled (CA found)"
+		default:
+			tlsMode = "enabled"
+		}
+		row := table.Row{entry.ClusterId, entry.Name, entry.ExternalEndpoint, entry.InternalEndpoint, tlsMode}
 		writer.AppendRow(row)
 	}
 
 	return writer.Render()
 }
+
+func RenderCardinalityReport(report *cortexadmin.CardinalityReportResponse) string {
+	since := report.GetPreviousTimestamp().AsTime().Format(time.RFC3339)
+
+	summary := table.NewWriter()
+	summary.SetStyle(table.StyleColoredDark)
+	summary.SetTitle(fmt.Sprintf("Cardinality: %s", report.GetTenant()))
+	summary.AppendHeader(table.Row{"SERIES", fmt.Sprintf("SERIES AT %s", since), "GROWTH"})
+	summary.AppendRow(table.Row{report.GetNumSeries(), report.GetPreviousNumSeries(), formatGrowth(report.SeriesGrowth())})
+	if report.GetPartial() {
+		summary.SetCaption("Partial report: series were not counted for some metrics")
+	}
+
+	renderMetrics := func(title string, metrics []*cortexadmin.MetricCardinality) string {
+		w := table.NewWriter()
+		w.SetStyle(table.StyleColoredDark)
+		w.SetTitle(title)
+		w.AppendHeader(table.Row{"METRIC", "SERIES", "PREVIOUS", "GROWTH"})
+		for _, m := range metrics {
+			w.AppendRow(table.Row{m.GetMetricName(), m.GetNumSeries(), m.GetPreviousNumSeries(), formatGrowth(m.SeriesGrowth())})
+		}
+		return w.Render()
+	}
+
+	labels := table.NewWriter()
+	labels.SetStyle(table.StyleColoredDark)
+	labels.SetTitle("Top Labels")
+	labels.AppendHeader(table.Row{"LABEL", "VALUES"})
+	for _, l := range report.GetTopLabels() {
+		labels.AppendRow(table.Row{l.GetLabelName(), l.GetNumValues()})
+	}
+
+	return strings.Join([]string{
+		summary.Render(),
+		renderMetrics("Top Metrics", report.GetTopMetrics()),
+		renderMetrics(fmt.Sprintf("Top Growth Since %s", since), report.GetTopGrowth()),
+		labels.Render(),
+	}, "\n\n")
+}
+
+func formatGrowth(growth int64) string {
+	if growth > 0 {
+		return fmt.Sprintf("+%d", growth)
+	}
+	return fmt.Sprint(growth)
+}
+
+func RenderTenantLimits(limits *cortexops.TenantLimits) string {
+	w := table.NewWriter()
+	w.SetStyle(table.StyleColoredDark)
+	w.SetTitle("Cortex Limit Overrides")
+	w.AppendHeader(table.Row{"LIMIT", "VALUE"})
+	fields := limits.GetLimits().GetFields()
+	names := lo.Keys(fields)
+	slices.Sort(names)
+	for _, name := range names {
+		w.AppendRow(table.Row{name, renderLimitValue(fields[name])})
+	}
+	var sources []string
+	sources = append(sources, limits.GetDefaults()...)
+	if limits.GetHasClusterOverrides() {
+		sources = append(sources, "(cluster)")
+	}
+	if len(sources) == 0 {
+		w.SetCaption("No overrides; global limits apply")
+	} else {
+		w.SetCaption("Sources: %s. Limits not listed use global values.", strings.Join(sources, ", "))
+	}
+	return w.Render()
+}
+
+func RenderTenantLimitsSpecList(list *cortexops.TenantLimitsSpecList) string {
+	w := table.NewWriter()
+	w.SetStyle(table.StyleColoredDark)
+	w.AppendHeader(table.Row{"ID", "TYPE", "SELECTOR", "LIMITS"})
+	for _, spec := range list.GetItems() {
+		kind := "cluster"
+		selector := ""
+		if spec.Selector != nil {
+			kind = "default"
+			pairs := []string{}
+			for k, v := range spec.GetSelector().GetLabelSelector().GetMatchLabels() {
+				pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+			}
+			slices.Sort(pairs)
+			selector = strings.Join(pairs, ",")
+			if selector == "" {
+				selector = "(all clusters)"
+			}
+		}
+		fields := spec.GetLimits().GetFields()
+		names := lo.Keys(fields)
+		slices.Sort(names)
+		limits := make([]string, 0, len(names))
+		for _, name := range names {
+			limits = append(limits, fmt.Sprintf("%s=%s", name, renderLimitValue(fields[name])))
+		}
+		w.AppendRow(table.Row{spec.GetId(), kind, selector, strings.Join(limits, "\n")})
+	}
+	return w.Render()
+}
+
+func renderLimitValue(value *structpb.Value) string {
+	data, err := protojson.Marshal(value)
+	if err != nil {
+		return "(invalid)"
+	}
+	return strings.Trim(string(data), `"`)
+}
@@ -0,0 +1,40 @@
+package cliutil
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+)
+
+// Parses strings of the form "verbs:resources[:capabilities]" into policy
+// rules, where each field is a comma-separated list. For example,
+// "get,list:clusters" or "*:capabilities:metrics,logging".
+func ParsePolicyRules(rules []string) ([]*corev1.PolicyRule, error) {
+	parsed := make([]*corev1.PolicyRule, 0, len(rules))
+	for _, rule := range rules {
+		parts := strings.Split(rule, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid syntax: %q", rule)
+		}
+		r := &corev1.PolicyRule{
+			Verbs:     strings.Split(parts[0], ","),
+			Resources: strings.Split(parts[1], ","),
+		}
+		if len(parts) == 3 {
+			r.Capabilities = strings.Split(parts[2], ",")
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+// FormatPolicyRule formats a policy rule using the syntax accepted by
+// ParsePolicyRules.
+func FormatPolicyRule(rule *corev1.PolicyRule) string {
+	s := strings.Join(rule.Verbs, ",") + ":" + strings.Join(rule.Resources, ",")
+	if len(rule.Capabilities) > 0 {
+		s += ":" + strings.Join(rule.Capabilities, ",")
+	}
+	return s
+}
@@ -273,6 +273,9 @@ func (pc *patchClient) doCreate(entry *controlv1.PatchSpec) error {
 		"path", entry.Filename,
 		"size", len(entry.Data),
 	).Infof("writing new plugin")
+	if digest := blake2b.Sum256(entry.Data); hex.EncodeToString(digest[:]) != entry.GetNewDigest() {
+		return unavailableErrf("received invalid contents for plugin %s (checksum mismatch)", entry.Module)
+	}
 	err := pc.fs.WriteFile(entry.Filename, entry.Data, 0755)
 	if err != nil {
 		return osErrf("could not write plugin %s: %v", entry.Filename, err)
@@ -0,0 +1,135 @@
+package patch_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rancher/opni/pkg/patch"
+)
+
+// Plugins benchmarked by BenchmarkPatchEngines. The larger plugins are
+// included since their patch sizes and memory usage are the ones that matter
+// in practice.
+var benchmarkPlugins = []string{
+	"plugin_metrics",
+	"plugin_alerting",
+	"plugin_logging",
+	"plugin_example",
+}
+
+// Benchmarks for the patch engines, using two revisions of each plugin in
+// benchmarkPlugins. To run:
+//
+//	mage build && cp -r bin/plugins /tmp/plugins_old   # at an older commit
+//	mage build && cp -r bin/plugins /tmp/plugins_new   # at a newer commit
+//	OPNI_BENCH_PATCH_OLD=/tmp/plugins_old OPNI_BENCH_PATCH_NEW=/tmp/plugins_new \
+//	  go test ./pkg/patch -run '^$' -bench BenchmarkPatchEngines -benchtime 3x
+//
+// In addition to the time per operation, the patch size and peak heap usage
+// are reported.
+func BenchmarkPatchEngines(b *testing.B) {
+	oldDir, newDir := os.Getenv("OPNI_BENCH_PATCH_OLD"), os.Getenv("OPNI_BENCH_PATCH_NEW")
+	if oldDir == "" || newDir == "" {
+		b.Skip("OPNI_BENCH_PATCH_OLD and OPNI_BENCH_PATCH_NEW must be set to the paths of two plugin directories")
+	}
+
+	engines := []struct {
+		name    string
+		patcher patch.BinaryPatcher
+	}{
+		{"bsdiff", patch.BsdiffPatcher{}},
+		{"zstd", patch.ZstdPatcher{}},
+	}
+	for _, plugin := range benchmarkPlugins {
+		plugin := plugin
+		b.Run(strings.TrimPrefix(plugin, "plugin_"), func(b *testing.B) {
+			oldBin, err := os.ReadFile(filepath.Join(oldDir, plugin))
+			if err != nil {
+				b.Fatal(err)
+			}
+			newBin, err := os.ReadFile(filepath.Join(newDir, plugin))
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, engine := range engines {
+				benchmarkPatchEngine(b, engine.name, engine.patcher, oldBin, newBin)
+			}
+		})
+	}
+}
+
+func benchmarkPatchEngine(b *testing.B, name string, engine patch.BinaryPatcher, oldBin, newBin []byte) {
+	b.Run(name+"/generate", func(b *testing.B) {
+		var patchSize int
+		peak := measurePeakHeap(func() {
+			for i := 0; i < b.N; i++ {
+				out := new(bytes.Buffer)
+				if err := engine.GeneratePatch(bytes.NewReader(oldBin), bytes.NewReader(newBin), out); err != nil {
+					b.Fatal(err)
+				}
+				patchSize = out.Len()
+			}
+		})
+		b.ReportMetric(float64(patchSize), "patch-bytes")
+		b.ReportMetric(float64(patchSize)/float64(len(newBin))*100, "patch-%")
+		b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MiB")
+	})
+	b.Run(name+"/apply", func(b *testing.B) {
+		patchData := new(bytes.Buffer)
+		if err := engine.GeneratePatch(bytes.NewReader(oldBin), bytes.NewReader(newBin), patchData); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		peak := measurePeakHeap(func() {
+			for i := 0; i < b.N; i++ {
+				out := bytes.NewBuffer(make([]byte, 0, len(newBin)))
+				if err := engine.ApplyPatch(bytes.NewReader(oldBin), bytes.NewReader(patchData.Bytes()), out); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MiB")
+	})
+}
+
+// measurePeakHeap runs fn while sampling heap usage, and returns the highest
+// observed heap size above the baseline at the start of the call.
+func measurePeakHeap(fn func()) uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	baseline := ms.HeapAlloc
+
+	var peak atomic.Uint64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		var ms runtime.MemStats
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				runtime.ReadMemStats(&ms)
+				if ms.HeapAlloc > baseline && ms.HeapAlloc-baseline > peak.Load() {
+					peak.Store(ms.HeapAlloc - baseline)
+				}
+			}
+		}
+	}()
+	fn()
+	close(done)
+	wg.Wait()
+	return peak.Load()
+}
@@ -0,0 +1,74 @@
+package patch_test
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rancher/opni/pkg/patch"
+	"github.com/rancher/opni/pkg/test"
+)
+
+var _ = Describe("Patch Engines", Label(test.Unit), func() {
+	DescribeTable("generating and applying patches",
+		func(patcher patch.BinaryPatcher) {
+			for _, plugin := range []string{"test1", "test2"} {
+				oldBin, newBin := testBinaries[plugin]["v1"], testBinaries[plugin]["v2"]
+
+				patchData := new(bytes.Buffer)
+				Expect(patcher.GeneratePatch(bytes.NewReader(oldBin), bytes.NewReader(newBin), patchData)).To(Succeed())
+				Expect(patchData.Len()).To(BeNumerically("<", len(newBin)/2))
+
+				By("detecting the patch format")
+				Expect(patcher.CheckFormat(bytes.NewReader(patchData.Bytes()))).To(BeTrue())
+				detected, ok := patch.NewPatcherFromFormat(bytes.NewReader(patchData.Bytes()))
+				Expect(ok).To(BeTrue())
+				Expect(detected).To(Equal(patcher))
+
+				By("applying the patch")
+				out := new(bytes.Buffer)
+				Expect(patcher.ApplyPatch(bytes.NewReader(oldBin), bytes.NewReader(patchData.Bytes()), out)).To(Succeed())
+				Expect(out.Bytes()).To(Equal(newBin))
+			}
+		},
+		Entry("bsdiff", patch.BsdiffPatcher{}),
+		Entry("zstd", patch.ZstdPatcher{}),
+	)
+
+	It("should not detect unknown patch formats", func() {
+		_, ok := patch.NewPatcherFromFormat(bytes.NewReader([]byte("not a patch")))
+		Expect(ok).To(BeFalse())
+	})
+
+	Context("zstd patches", func() {
+		var oldBin, newBin, patchData []byte
+		BeforeEach(func() {
+			oldBin, newBin = testBinaries["test1"]["v1"], testBinaries["test1"]["v2"]
+			buf := new(bytes.Buffer)
+			Expect(patch.ZstdPatcher{}.GeneratePatch(bytes.NewReader(oldBin), bytes.NewReader(newBin), buf)).To(Succeed())
+			patchData = buf.Bytes()
+		})
+		It("should fail if the patch is truncated", func() {
+			err := patch.ZstdPatcher{}.ApplyPatch(bytes.NewReader(oldBin), bytes.NewReader(patchData[:len(patchData)/2]), new(bytes.Buffer))
+			Expect(err).To(HaveOccurred())
+		})
+		It("should fail if applied to a different binary", func() {
+			err := patch.ZstdPatcher{}.ApplyPatch(bytes.NewReader(oldBin[:len(oldBin)/4]), bytes.NewReader(patchData), new(bytes.Buffer))
+			Expect(err).To(HaveOccurred())
+		})
+		It("should handle empty binaries", func() {
+			buf := new(bytes.Buffer)
+			Expect(patch.ZstdPatcher{}.GeneratePatch(bytes.NewReader(nil), bytes.NewReader(newBin), buf)).To(Succeed())
+			out := new(bytes.Buffer)
+			Expect(patch.ZstdPatcher{}.ApplyPatch(bytes.NewReader(nil), buf, out)).To(Succeed())
+			Expect(out.Bytes()).To(Equal(newBin))
+
+			buf.Reset()
+			out.Reset()
+			Expect(patch.ZstdPatcher{}.GeneratePatch(bytes.NewReader(oldBin), bytes.NewReader(nil), buf)).To(Succeed())
+			Expect(patch.ZstdPatcher{}.ApplyPatch(bytes.NewReader(oldBin), buf, out)).To(Succeed())
+			Expect(out.Len()).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,224 @@
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"runtime"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/exp/slices"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	zstdPatchMagic = "ZSTDPF01"
+
+	// The new binary is split into segments which are compressed
+	// independently, each using a region of the old binary as a raw zstd
+	// dictionary ("patch-from"). This keeps the encoder's match window small
+	// enough to find long-range matches, and allows segments to be encoded
+	// in parallel.
+	zstdPatchSegmentSize = 2 << 20
+	// Size of the region of the old binary before and after the estimated
+	// position of each segment which is used as the dictionary.
+	zstdPatchDictMargin = 4 << 20
+	// Window size must cover the dictionary and the segment itself.
+	zstdPatchWindowSize = 16 << 20
+
+	// Anchors are short sequences of bytes sampled from both binaries, used
+	// to estimate where each segment of the new binary is located in the
+	// old binary.
+	zstdPatchAnchorSize       = 64
+	zstdPatchOldAnchorStride  = 1024
+	zstdPatchNewAnchorStride  = 257
+	zstdPatchMaxSegmentHeader = 3 * binary.MaxVarintLen64
+)
+
+// ZstdPatcher generates patches using zstd compression with the old binary
+// as a dictionary. It is significantly faster and uses less memory than
+// bsdiff for large binaries, at the cost of slightly larger patches.
+//
+// Patch format:
+//
+//	magic     [8]byte ("ZSTDPF01")
+//	size      uvarint (size of the new binary)
+//	segments  uvarint
+//	segments * {
+//	  dictOffset uvarint
+//	  dictLength uvarint
+//	  frameSize  uvarint
+//	  frame      [frameSize]byte
+//	}
+type ZstdPatcher struct{}
+
+type zstdPatchSegment struct {
+	dictOffset, dictLength int
+	frame                  []byte
+}
+
+func (ZstdPatcher) GeneratePatch(old io.Reader, new io.Reader, patchOut io.Writer) error {
+	oldBytes, err := io.ReadAll(old)
+	if err != nil {
+		return err
+	}
+	newBytes, err := io.ReadAll(new)
+	if err != nil {
+		return err
+	}
+
+	seed := maphash.MakeSeed()
+	anchors := make(map[uint64]int, len(oldBytes)/zstdPatchOldAnchorStride+1)
+	for off := 0; off+zstdPatchAnchorSize <= len(oldBytes); off += zstdPatchOldAnchorStride {
+		anchors[maphash.Bytes(seed, oldBytes[off:off+zstdPatchAnchorSize])] = off
+	}
+
+	numSegments := (len(newBytes) + zstdPatchSegmentSize - 1) / zstdPatchSegmentSize
+	segments := make([]zstdPatchSegment, numSegments)
+	var eg errgroup.Group
+	eg.SetLimit(runtime.GOMAXPROCS(0))
+	for i := range segments {
+		i := i
+		start := i * zstdPatchSegmentSize
+		end := start + zstdPatchSegmentSize
+		if end > len(newBytes) {
+			end = len(newBytes)
+		}
+		// Estimate the offset of this segment in the old binary, using the
+		// median distance between matching anchors.
+		var deltas []int
+		for off := start; off+zstdPatchAnchorSize <= end; off += zstdPatchNewAnchorStride {
+			if oldOff, ok := anchors[maphash.Bytes(seed, newBytes[off:off+zstdPatchAnchorSize])]; ok {
+				deltas = append(deltas, oldOff-off)
+			}
+		}
+		var delta int
+		if len(deltas) > 0 {
+			slices.Sort(deltas)
+			delta = deltas[len(deltas)/2]
+		}
+		dictStart := clamp(start+delta-zstdPatchDictMargin, 0, len(oldBytes))
+		dictEnd := clamp(end+delta+zstdPatchDictMargin, dictStart, len(oldBytes))
+		segments[i].dictOffset = dictStart
+		segments[i].dictLength = dictEnd - dictStart
+
+		eg.Go(func() error {
+			encoder, err := zstd.NewWriter(nil,
+				zstd.WithEncoderLevel(zstd.SpeedBestCompression),
+				zstd.WithWindowSize(zstdPatchWindowSize),
+				zstd.WithEncoderConcurrency(1),
+				zstd.WithEncoderDictRaw(1, oldBytes[dictStart:dictEnd]),
+			)
+			if err != nil {
+				return err
+			}
+			defer encoder.Close()
+			segments[i].frame = encoder.EncodeAll(newBytes[start:end], nil)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(patchOut)
+	buf := make([]byte, zstdPatchMaxSegmentHeader)
+	w.WriteString(zstdPatchMagic)
+	w.Write(binary.AppendUvarint(buf[:0], uint64(len(newBytes))))
+	w.Write(binary.AppendUvarint(buf[:0], uint64(len(segments))))
+	for _, seg := range segments {
+		header := binary.AppendUvarint(buf[:0], uint64(seg.dictOffset))
+		header = binary.AppendUvarint(header, uint64(seg.dictLength))
+		header = binary.AppendUvarint(header, uint64(len(seg.frame)))
+		w.Write(header)
+		w.Write(seg.frame)
+	}
+	return w.Flush()
+}
+
+func (ZstdPatcher) ApplyPatch(old io.Reader, patch io.Reader, newOut io.Writer) error {
+	oldBytes, err := io.ReadAll(old)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(patch)
+	magic := make([]byte, len(zstdPatchMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != zstdPatchMagic {
+		return errors.New("invalid zstd patch header")
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("malformed zstd patch: %w", err)
+	}
+	numSegments, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("malformed zstd patch: %w", err)
+	}
+	if numSegments > size/zstdPatchSegmentSize+1 {
+		return errors.New("malformed zstd patch: too many segments")
+	}
+	var written uint64
+	for i := uint64(0); i < numSegments; i++ {
+		var header [3]uint64
+		for j := range header {
+			if header[j], err = binary.ReadUvarint(r); err != nil {
+				return fmt.Errorf("malformed zstd patch: %w", err)
+			}
+		}
+		dictOffset, dictLength, frameSize := header[0], header[1], header[2]
+		if dictOffset > uint64(len(oldBytes)) || dictLength > uint64(len(oldBytes))-dictOffset {
+			return errors.New("malformed zstd patch: dictionary out of range")
+		}
+		if frameSize > zstdPatchWindowSize {
+			return errors.New("malformed zstd patch: segment too large")
+		}
+		frame := make([]byte, frameSize)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return fmt.Errorf("malformed zstd patch: %w", err)
+		}
+		decoder, err := zstd.NewReader(nil,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(zstdPatchWindowSize),
+			zstd.WithDecoderDictRaw(1, oldBytes[dictOffset:dictOffset+dictLength]),
+		)
+		if err != nil {
+			return err
+		}
+		data, err := decoder.DecodeAll(frame, make([]byte, 0, zstdPatchSegmentSize))
+		decoder.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decode zstd patch segment %d: %w", i, err)
+		}
+		if _, err := newOut.Write(data); err != nil {
+			return err
+		}
+		written += uint64(len(data))
+	}
+	if written != size {
+		return fmt.Errorf("zstd patch produced %d bytes, expected %d", written, size)
+	}
+	return nil
+}
+
+func (ZstdPatcher) CheckFormat(reader io.ReaderAt) bool {
+	header := []byte(zstdPatchMagic)
+	buf := make([]byte, len(header))
+	if _, err := reader.ReadAt(buf, 0); err == nil {
+		return bytes.Equal(buf, header)
+	}
+	return false
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
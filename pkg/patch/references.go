@@ -0,0 +1,151 @@
+package patch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+
+	"github.com/rancher/opni/pkg/storage"
+)
+
+const (
+	// How often each replica refreshes the plugins it references in the
+	// shared reference store.
+	referenceRefreshInterval = 1 * time.Hour
+	// References which have not been refreshed within this time are assumed to
+	// belong to replicas which no longer exist, and are removed during garbage
+	// collection.
+	referenceTTL = 24 * time.Hour
+	// Lock held while garbage collecting the cache, and while publishing
+	// references, so that plugins archived by one replica are never removed
+	// by a garbage collection running on another replica.
+	cacheGCLockKey = "patch-cache-gc"
+)
+
+// cacheReferences is stored in the shared reference store for each replica.
+type cacheReferences struct {
+	Digests []string  `json:"digests"`
+	Updated time.Time `json:"updated"`
+}
+
+// publishReferences stores the digests of all plugins this replica may serve
+// in the shared reference store, along with the digests of the given
+// manifest which is about to be archived. Does nothing if there is no shared
+// reference store.
+func (f *FilesystemPluginSyncServer) publishReferences(ctx context.Context, pending ...string) error {
+	if f.references == nil {
+		return nil
+	}
+	lock := f.locks.Locker(cacheGCLockKey)
+	if err := lock.Lock(ctx); err != nil {
+		return fmt.Errorf("failed to acquire cache gc lock: %w", err)
+	}
+	defer lock.Unlock()
+	return f.putReferences(ctx, pending...)
+}
+
+func (f *FilesystemPluginSyncServer) putReferences(ctx context.Context, pending ...string) error {
+	digests := f.localDigests()
+	for _, digest := range pending {
+		digests[digest] = struct{}{}
+	}
+	data, err := json.Marshal(cacheReferences{
+		Digests: maps.Keys(digests),
+		Updated: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return f.references.Put(ctx, f.replicaId, data)
+}
+
+// localDigests returns the digests of all plugins this replica may serve.
+func (f *FilesystemPluginSyncServer) localDigests() map[string]struct{} {
+	digests := map[string]struct{}{}
+	f.manifestMu.RLock()
+	if f.manifest != nil {
+		for digest := range f.manifest.DigestSet() {
+			digests[digest] = struct{}{}
+		}
+	}
+	f.manifestMu.RUnlock()
+	if f.selector != nil {
+		for _, manifest := range f.selector.Manifests() {
+			for digest := range manifest.DigestSet() {
+				digests[digest] = struct{}{}
+			}
+		}
+	}
+	return digests
+}
+
+// sharedDigests returns the digests referenced by all replicas in the shared
+// reference store. Expired references are removed. Must be called while
+// holding the cache gc lock.
+func (f *FilesystemPluginSyncServer) sharedDigests(ctx context.Context) (map[string]struct{}, error) {
+	keys, err := f.references.ListKeys(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	digests := map[string]struct{}{}
+	for _, key := range keys {
+		data, err := f.references.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		var refs cacheReferences
+		if err := json.Unmarshal(data, &refs); err != nil {
+			f.logger.With(
+				"replica", key,
+				zap.Error(err),
+			).Warn("ignoring malformed cache references")
+			continue
+		}
+		if time.Since(refs.Updated) > referenceTTL {
+			f.logger.With(
+				"replica", key,
+				"updated", refs.Updated,
+			).Info("removing expired cache references")
+			if err := f.references.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return nil, err
+			}
+			continue
+		}
+		for _, digest := range refs.Digests {
+			digests[digest] = struct{}{}
+		}
+	}
+	return digests, nil
+}
+
+// RunReferenceUpdates periodically refreshes the plugins this replica
+// references in the shared reference store, so that they are not removed from
+// the cache by other replicas. Blocks until ctx is done. Does nothing if there
+// is no shared reference store.
+func (f *FilesystemPluginSyncServer) RunReferenceUpdates(ctx context.Context) {
+	if f.references == nil {
+		return
+	}
+	ticker := time.NewTicker(referenceRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.publishReferences(ctx); err != nil {
+				f.logger.With(
+					zap.Error(err),
+				).Warn("failed to refresh plugin cache references")
+			}
+		}
+	}
+}
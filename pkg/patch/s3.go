@@ -0,0 +1,399 @@
+package patch
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/klauspost/compress/zstd"
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/storage"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
+)
+
+// Maximum time to wait for another gateway replica to finish generating a
+// patch before giving up.
+const patchLockTimeout = 5 * time.Minute
+
+// S3Cache stores compressed plugins and patches in an S3-compatible bucket.
+// Multiple gateway replicas can share the same cache. Patch generation is
+// coordinated using the given lock manager, so that each patch is only
+// computed once if the lock manager is shared by all replicas.
+type S3Cache struct {
+	CacheMetricsTracker
+	config     v1beta1.S3CacheSpec
+	client     s3iface.S3API
+	locks      storage.LockManager
+	logger     *zap.SugaredLogger
+	cacheGroup singleflight.Group
+	patcher    BinaryPatcher
+}
+
+var _ Cache = (*S3Cache)(nil)
+
+func NewS3Cache(conf v1beta1.S3CacheSpec, patcher BinaryPatcher, locks storage.LockManager, lg *zap.SugaredLogger) (Cache, error) {
+	if conf.BucketName == "" {
+		return nil, errors.New("s3 cache: bucket name is required")
+	}
+	awsConfig := &aws.Config{
+		Region:           aws.String(conf.Region),
+		S3ForcePathStyle: aws.Bool(conf.ForcePathStyle),
+		DisableSSL:       aws.Bool(conf.Insecure),
+	}
+	if conf.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(conf.Endpoint)
+	}
+	if conf.AccessKeyID != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(conf.AccessKeyID, conf.SecretAccessKey, "")
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("s3 cache: %w", err)
+	}
+	cache := &S3Cache{
+		config:  conf,
+		client:  s3.New(sess),
+		locks:   locks,
+		patcher: patcher,
+		logger:  lg,
+		CacheMetricsTracker: NewCacheMetricsTracker(map[string]string{
+			"cache_type": "s3",
+		}),
+	}
+	if err := cache.recomputeStats(); err != nil {
+		return nil, fmt.Errorf("s3 cache: %w", err)
+	}
+	return cache, nil
+}
+
+func (p *S3Cache) Archive(manifest *controlv1.PluginArchive) error {
+	var group errgroup.Group
+	p.logger.Infof("compressing and archiving plugins...")
+	var added int
+	for _, item := range manifest.Items {
+		key := p.key("plugins", item.Metadata.Digest)
+		if exists, err := p.exists(key); err != nil {
+			return err
+		} else if exists {
+			// verify the hash of the existing plugin
+			pluginData, err := p.getCompressed(key)
+			if err == nil {
+				if sum := blake2b.Sum256(pluginData); hex.EncodeToString(sum[:]) == item.Metadata.Digest {
+					// the plugin already exists and its hash matches
+					continue
+				}
+			}
+			p.logger.With(
+				"plugin", item.Metadata.Filename,
+			).Warn("existing cached plugin is corrupted, overwriting")
+		}
+		added++
+
+		item := item
+		group.Go(func() error {
+			compressed, err := compress(item.Data)
+			if err != nil {
+				return err
+			}
+			if err := p.put(key, compressed); err != nil {
+				return err
+			}
+			p.AddToTotalSizeBytes(item.Metadata.Digest, int64(len(compressed)))
+			p.AddToPluginCount(1)
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		p.logger.With(
+			zap.Error(err),
+		).Error("failed to archive one or more plugins")
+		return err
+	}
+	p.logger.Debugf("added %d new plugins to cache", added)
+	return nil
+}
+
+func (p *S3Cache) RequestPatch(oldDigest, newDigest string) ([]byte, error) {
+	key := p.PatchKey(oldDigest, newDigest)
+	objectKey := p.key("patches", key)
+	var isCaller bool
+	patchDataValue, err, shared := p.cacheGroup.Do(key, func() (any, error) {
+		isCaller = true
+		if patchData, err := p.get(objectKey); err == nil {
+			p.CacheHit(oldDigest, newDigest)
+			return patchData, nil
+		} else if !isNotFound(err) {
+			return nil, err
+		}
+
+		// another replica may be generating the same patch; wait for it to
+		// finish, then check again before generating it ourselves
+		ctx, ca := context.WithTimeout(context.Background(), patchLockTimeout)
+		defer ca()
+		lock := p.locks.Locker("patch-cache/" + key)
+		if err := lock.Lock(ctx); err != nil {
+			return nil, fmt.Errorf("failed to acquire patch lock: %w", err)
+		}
+		defer lock.Unlock()
+
+		if patchData, err := p.get(objectKey); err == nil {
+			p.CacheHit(oldDigest, newDigest)
+			return patchData, nil
+		} else if !isNotFound(err) {
+			return nil, err
+		}
+
+		p.CacheMiss(oldDigest, newDigest)
+		lg := p.logger.With(
+			"from", oldDigest,
+			"to", newDigest,
+		)
+		lg.Info("generating patch")
+		start := time.Now()
+		patchData, err := p.generatePatch(oldDigest, newDigest)
+		if err != nil {
+			lg.With(
+				zap.Error(err),
+			).Error("failed to generate patch")
+			return nil, err
+		}
+		lg.With(
+			"took", time.Since(start).String(),
+			"size", len(patchData),
+		).Debug("patch generated")
+		// patches are already compressed by the patch engine
+		if err := p.put(objectKey, patchData); err != nil {
+			lg.With(
+				zap.Error(err),
+			).Error("failed to upload patch")
+			return nil, err
+		}
+		p.IncPatchCalcSecsTotal(oldDigest, newDigest, float64(time.Since(start).Seconds()))
+		p.AddToTotalSizeBytes(key, int64(len(patchData)))
+		p.AddToPatchCount(1)
+		return patchData, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared && !isCaller {
+		p.CacheHit(oldDigest, newDigest)
+	}
+	return patchDataValue.([]byte), nil
+}
+
+func (*S3Cache) PatchKey(oldDigest, newDigest string) string {
+	return fmt.Sprintf("%s-to-%s", oldDigest, newDigest)
+}
+
+func (p *S3Cache) GetPlugin(hash string) ([]byte, error) {
+	pluginData, err := p.getCompressed(p.key("plugins", hash))
+	if err != nil {
+		return nil, err
+	}
+	sum := blake2b.Sum256(pluginData)
+	if hex.EncodeToString(sum[:]) != hash {
+		defer p.Clean(hash)
+		p.logger.With(
+			"hash", hash,
+		).Error("plugin corrupted: hash mismatch")
+		return nil, fmt.Errorf("plugin corrupted: hash mismatch")
+	}
+	return pluginData, nil
+}
+
+func (p *S3Cache) ListDigests() ([]string, error) {
+	objects, err := p.list("plugins")
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		hashes = append(hashes, path.Base(aws.StringValue(obj.Key)))
+	}
+	return hashes, nil
+}
+
+func (p *S3Cache) Clean(hashes ...string) {
+	if len(hashes) == 0 {
+		return
+	}
+	var toRemove []string
+	for _, hash := range hashes {
+		toRemove = append(toRemove, p.key("plugins", hash))
+	}
+	// remove any patches that reference these plugins
+	if patches, err := p.list("patches"); err == nil {
+		for _, obj := range patches {
+			name := path.Base(aws.StringValue(obj.Key))
+			for _, hash := range hashes {
+				if strings.HasPrefix(name, hash+"-to-") || strings.HasSuffix(name, "-to-"+hash) {
+					toRemove = append(toRemove, aws.StringValue(obj.Key))
+					break
+				}
+			}
+		}
+	} else {
+		p.logger.With(
+			zap.Error(err),
+		).Warn("failed to list patches")
+	}
+
+	var removed int
+	for _, key := range toRemove {
+		if _, err := p.client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(p.config.BucketName),
+			Key:    aws.String(key),
+		}); err == nil {
+			removed++
+		}
+	}
+	if removed > 0 {
+		p.logger.Infof("cleaned %d unreachable objects", removed)
+	}
+	if err := p.recomputeStats(); err != nil {
+		p.logger.With(
+			zap.Error(err),
+		).Warn("failed to recompute cache stats")
+	}
+}
+
+func (p *S3Cache) generatePatch(oldDigest, newDigest string) ([]byte, error) {
+	oldBin, err := p.GetPlugin(oldDigest)
+	if err != nil {
+		return nil, err
+	}
+	newBin, err := p.GetPlugin(newDigest)
+	if err != nil {
+		return nil, err
+	}
+	out := new(bytes.Buffer)
+	if err := p.patcher.GeneratePatch(bytes.NewReader(oldBin), bytes.NewReader(newBin), out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (p *S3Cache) key(parts ...string) string {
+	return path.Join(append([]string{p.config.Prefix}, parts...)...)
+}
+
+func (p *S3Cache) exists(key string) (bool, error) {
+	_, err := p.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(p.config.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (p *S3Cache) put(key string, data []byte) error {
+	_, err := p.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(p.config.BucketName),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (p *S3Cache) get(key string) ([]byte, error) {
+	out, err := p.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(p.config.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (p *S3Cache) getCompressed(key string) ([]byte, error) {
+	data, err := p.get(key)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	return decoder.DecodeAll(data, nil)
+}
+
+func (p *S3Cache) list(dir string) ([]*s3.Object, error) {
+	var objects []*s3.Object
+	err := p.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(p.config.BucketName),
+		Prefix: aws.String(p.key(dir) + "/"),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		objects = append(objects, page.Contents...)
+		return true
+	})
+	return objects, err
+}
+
+func (p *S3Cache) recomputeStats() error {
+	plugins, err := p.list("plugins")
+	if err != nil {
+		return err
+	}
+	patches, err := p.list("patches")
+	if err != nil {
+		return err
+	}
+	for _, obj := range append(plugins, patches...) {
+		p.SetTotalSizeBytes(path.Base(aws.StringValue(obj.Key)), aws.Int64Value(obj.Size))
+	}
+	p.SetPluginCount(int64(len(plugins)))
+	p.SetPatchCount(int64(len(patches)))
+	return nil
+}
+
+func compress(data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	encoder, err := zstd.NewWriter(buf, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := encoder.Write(data); err != nil {
+		encoder.Close()
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isNotFound(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package patch_test
+
+import (
+	"io/fs"
+	"path"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/afero"
+
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/patch"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/test"
+)
+
+func newS3CacheSpec(fsys afero.Fs) v1beta1.S3CacheSpec {
+	srv := test.NewTestS3Server(fsys)
+	DeferCleanup(srv.Close)
+	return v1beta1.S3CacheSpec{
+		Endpoint:        srv.URL,
+		Region:          "us-east-1",
+		BucketName:      "opni",
+		Prefix:          "cache",
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+		Insecure:        true,
+		ForcePathStyle:  true,
+	}
+}
+
+func init() {
+	BuildCacheTestSuite("S3 Cache", func() TestCache {
+		fsys := afero.NewMemMapFs()
+		cache, err := patch.NewS3Cache(newS3CacheSpec(fsys), patch.BsdiffPatcher{}, storage.NewInMemoryLockManager(), test.Log)
+		Expect(err).NotTo(HaveOccurred())
+		return newTestCache(cache, CacheTestSuiteOptions{
+			TestOpenSavedPluginFunc: func(hash string, mode int) (afero.File, error) {
+				return fsys.OpenFile(path.Join("/opni", "cache", "plugins", hash), mode, 0666)
+			},
+			TestStatPatchFunc: func(from, to string) (fs.FileInfo, error) {
+				return fsys.Stat(path.Join("/opni", "cache", "patches", cache.PatchKey(from, to)))
+			},
+			TestRemovePatchFunc: func(from, to string) error {
+				return fsys.Remove(path.Join("/opni", "cache", "patches", cache.PatchKey(from, to)))
+			},
+		})
+	})
+}
+
+var _ = Describe("S3 Cache", Label("unit"), func() {
+	When("multiple gateways share the same bucket", func() {
+		var caches []patch.Cache
+		BeforeEach(func() {
+			conf := newS3CacheSpec(afero.NewMemMapFs())
+			locks := storage.NewInMemoryLockManager()
+			caches = nil
+			for i := 0; i < 3; i++ {
+				cache, err := patch.NewS3Cache(conf, patch.BsdiffPatcher{}, locks, test.Log)
+				Expect(err).NotTo(HaveOccurred())
+				caches = append(caches, cache)
+			}
+		})
+		It("should share archived plugins", func() {
+			Expect(caches[0].Archive(v1Manifest)).To(Succeed())
+
+			digests, err := caches[1].ListDigests()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(digests).To(ConsistOf(v1Manifest.Items[0].Metadata.Digest, v1Manifest.Items[1].Metadata.Digest))
+
+			data, err := caches[2].GetPlugin(v1Manifest.Items[0].Metadata.Digest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(Equal(v1Manifest.Items[0].Data))
+		})
+		It("should only generate each patch once", func() {
+			Expect(caches[0].Archive(v1Manifest)).To(Succeed())
+			Expect(caches[1].Archive(v2Manifest)).To(Succeed())
+
+			from, to := v1Manifest.Items[0].Metadata.Digest, v2Manifest.Items[0].Metadata.Digest
+			var wg sync.WaitGroup
+			for _, cache := range caches {
+				cache := cache
+				for i := 0; i < 10; i++ {
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						defer wg.Done()
+						patch, err := cache.RequestPatch(from, to)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(patch).To(Equal(test1v1tov2Patch.Bytes()))
+					}()
+				}
+			}
+			wg.Wait()
+
+			var misses, hits int64
+			for _, cache := range caches {
+				misses += cache.MetricsSnapshot().CacheMisses
+				hits += cache.MetricsSnapshot().CacheHits
+			}
+			Expect(misses).To(BeEquivalentTo(1))
+			Expect(hits).To(BeEquivalentTo(29))
+		})
+	})
+	It("should require a bucket name", func() {
+		_, err := patch.NewS3Cache(v1beta1.S3CacheSpec{}, patch.BsdiffPatcher{}, storage.NewInMemoryLockManager(), test.Log)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -33,13 +33,29 @@ type FilesystemPluginSyncServer struct {
 	logger           *zap.SugaredLogger
 	config           v1beta1.PluginsSpec
 	loadMetadataOnce sync.Once
+	manifestMu       sync.RWMutex
 	manifest         *controlv1.PluginManifest
 	patchCache       Cache
 }
 
+// ManifestSelector chooses the plugins synced to individual agents, allowing
+// updated plugins to be rolled out gradually.
+type ManifestSelector interface {
+	// DesiredManifest returns the manifest the agent for the given cluster
+	// should have, or nil if it should have the gateway's own plugins.
+	DesiredManifest(id string) *controlv1.PluginManifest
+	// Manifests returns all manifests which may be returned by
+	// DesiredManifest. Plugins in these manifests are kept in the cache.
+	Manifests() []*controlv1.PluginManifest
+}
+
 type SyncServerOptions struct {
-	filters []plugins.Filter
-	fsys    afero.Fs
+	filters    []plugins.Filter
+	fsys       afero.Fs
+	selector   ManifestSelector
+	locks      storage.LockManager
+	references storage.KeyValueStore
+	replicaId  string
 }
 
 type SyncServerOption func(*SyncServerOptions)
@@ -62,13 +78,46 @@ func WithFs(fsys afero.Fs) SyncServerOption {
 	}
 }
 
+// WithLockManager sets the lock manager used to coordinate patch generation
+// between gateway replicas sharing a cache. Defaults to an in-memory lock
+// manager.
+func WithLockManager(locks storage.LockManager) SyncServerOption {
+	return func(o *SyncServerOptions) {
+		o.locks = locks
+	}
+}
+
+// WithSharedReferences configures the sync server to record the plugins it
+// references in the given store, which must be shared by all replicas using
+// the same cache. Garbage collection then keeps plugins referenced by any
+// replica, rather than only those referenced by this replica. The lock
+// manager must also be shared by all replicas.
+func WithSharedReferences(store storage.KeyValueStore, replicaId string) SyncServerOption {
+	return func(o *SyncServerOptions) {
+		o.references = store
+		o.replicaId = replicaId
+	}
+}
+
+// WithManifestSelector configures the sync server to use the given selector
+// to choose the plugins synced to agents which sync their plugins using the
+// authenticated SyncAgentPluginManifest method, or which connect with the
+// controlv1.AgentIDKey metadata key set. In both cases the agent's
+// authenticated ID is passed to the selector.
+func WithManifestSelector(selector ManifestSelector) SyncServerOption {
+	return func(o *SyncServerOptions) {
+		o.selector = selector
+	}
+}
+
 func NewFilesystemPluginSyncServer(
 	cfg v1beta1.PluginsSpec,
 	lg *zap.SugaredLogger,
 	opts ...SyncServerOption,
 ) (*FilesystemPluginSyncServer, error) {
 	options := SyncServerOptions{
-		fsys: afero.NewOsFs(),
+		fsys:  afero.NewOsFs(),
+		locks: storage.NewInMemoryLockManager(),
 	}
 	options.apply(opts...)
 
@@ -76,6 +125,8 @@ func NewFilesystemPluginSyncServer(
 	switch cfg.Cache.PatchEngine {
 	case v1beta1.PatchEngineBsdiff:
 		patchEngine = BsdiffPatcher{}
+	case v1beta1.PatchEngineZstd:
+		patchEngine = ZstdPatcher{}
 	default:
 		return nil, fmt.Errorf("unknown patch engine: %s", cfg.Cache.PatchEngine)
 	}
@@ -88,6 +139,12 @@ func NewFilesystemPluginSyncServer(
 		if err != nil {
 			return nil, err
 		}
+	case v1beta1.CacheBackendS3:
+		var err error
+		cache, err = NewS3Cache(cfg.Cache.S3, patchEngine, options.locks, lg.Named("cache"))
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("unknown cache backend: %s", cfg.Cache.Backend)
 	}
@@ -100,12 +157,34 @@ func NewFilesystemPluginSyncServer(
 	}, nil
 }
 
+// RunGarbageCollection removes plugins and patches from the cache which are
+// not referenced by any cluster, or by any manifest which may be synced to
+// agents. If shared references are configured, plugins referenced by other
+// replicas are also kept, and only one replica collects garbage at a time.
 func (f *FilesystemPluginSyncServer) RunGarbageCollection(ctx context.Context, store storage.ClusterStore) error {
 	clusters, err := store.ListClusters(ctx, &corev1.LabelSelector{}, 0)
 	if err != nil {
 		return err
 	}
-	digestsToKeep := f.getPluginManifest().DigestSet()
+	f.getPluginManifest()
+	digestsToKeep := f.localDigests()
+	if f.references != nil {
+		lock := f.locks.Locker(cacheGCLockKey)
+		if err := lock.Lock(ctx); err != nil {
+			return fmt.Errorf("failed to acquire cache gc lock: %w", err)
+		}
+		defer lock.Unlock()
+		if err := f.putReferences(ctx); err != nil {
+			return fmt.Errorf("failed to publish cache references: %w", err)
+		}
+		shared, err := f.sharedDigests(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read cache references: %w", err)
+		}
+		for digest := range shared {
+			digestsToKeep[digest] = struct{}{}
+		}
+	}
 	for _, cluster := range clusters.Items {
 		versions := cluster.GetMetadata().GetLastKnownConnectionDetails().GetPluginVersions()
 		for _, v := range versions {
@@ -129,6 +208,8 @@ func (f *FilesystemPluginSyncServer) RunGarbageCollection(ctx context.Context, s
 
 func (f *FilesystemPluginSyncServer) getPluginManifest() *controlv1.PluginManifest {
 	f.loadMetadataOnce.Do(f.loadPluginManifest)
+	f.manifestMu.RLock()
+	defer f.manifestMu.RUnlock()
 	return f.manifest
 }
 
@@ -136,6 +217,32 @@ func (f *FilesystemPluginSyncServer) loadPluginManifest() {
 	if f.manifest != nil {
 		panic("bug: tried to call loadPluginManifest twice")
 	}
+	manifest, err := f.readPluginManifest()
+	if err != nil {
+		panic(err)
+	}
+	f.manifest = manifest
+}
+
+// ReloadManifest reads the plugins from the plugin directory again, and
+// archives any new plugins in the cache. Agents which connect afterwards will
+// be synced the new plugins. Returns the new manifest.
+func (f *FilesystemPluginSyncServer) ReloadManifest() (*controlv1.PluginManifest, error) {
+	f.loadMetadataOnce.Do(f.loadPluginManifest)
+	manifest, err := f.readPluginManifest()
+	if err != nil {
+		return nil, err
+	}
+	f.manifestMu.Lock()
+	f.manifest = manifest
+	f.manifestMu.Unlock()
+	f.logger.With(
+		"digest", manifest.Digest(),
+	).Info("reloaded plugin manifest")
+	return manifest, nil
+}
+
+func (f *FilesystemPluginSyncServer) readPluginManifest() (*controlv1.PluginManifest, error) {
 	md, err := GetFilesystemPlugins(plugins.DiscoveryConfig{
 		Dir:        f.config.Dir,
 		Fs:         f.fsys,
@@ -144,14 +251,57 @@ func (f *FilesystemPluginSyncServer) loadPluginManifest() {
 		QueryModes: len(f.filters) > 0,
 	})
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	signatures, err := ReadSignatures(f.fsys, f.config.Dir)
+	if err != nil {
+		f.logger.With(
+			zap.Error(err),
+		).Error("failed to read plugin signatures")
+	} else {
+		var numSigned int
+		for _, item := range md.Items {
+			if sig, ok := signatures.Signatures[item.Metadata.Digest]; ok {
+				item.Metadata.Signature = sig
+				numSigned++
+			}
+		}
+		f.logger.With(
+			"signed", numSigned,
+			"total", len(md.Items),
+		).Debug("loaded plugin signatures")
+	}
+	// publish the new plugins before archiving them, so that a garbage
+	// collection running on another replica does not remove them
+	ctx, ca := context.WithTimeout(context.Background(), patchLockTimeout)
+	defer ca()
+	pending := make([]string, 0, len(md.Items))
+	for _, item := range md.Items {
+		pending = append(pending, item.Metadata.Digest)
+	}
+	if err := f.publishReferences(ctx, pending...); err != nil {
+		return nil, fmt.Errorf("failed to publish cache references: %w", err)
 	}
 	if err := f.patchCache.Archive(md); err != nil {
-		panic(fmt.Sprintf("failed to archive plugin manifest: %v", err))
+		return nil, fmt.Errorf("failed to archive plugin manifest: %w", err)
+	}
+	return md.ToManifest(), nil
+}
+
+// DesiredManifest returns the manifest the agent for the given cluster should
+// have. Agents which do not identify themselves (id is empty) always receive
+// the gateway's own plugins.
+func (f *FilesystemPluginSyncServer) DesiredManifest(id string) *controlv1.PluginManifest {
+	if f.selector != nil && id != "" {
+		if manifest := f.selector.DesiredManifest(id); manifest != nil {
+			return manifest
+		}
 	}
-	f.manifest = md.ToManifest()
+	return f.getPluginManifest()
 }
 
+// SyncPluginManifest is not authenticated, so callers always receive the
+// gateway's own plugins.
 func (f *FilesystemPluginSyncServer) SyncPluginManifest(
 	ctx context.Context,
 	theirManifest *controlv1.PluginManifest,
@@ -160,7 +310,33 @@ func (f *FilesystemPluginSyncServer) SyncPluginManifest(
 	if err := theirManifest.Validate(); err != nil {
 		return nil, err
 	}
-	ourManifest := f.getPluginManifest()
+	return f.syncPluginManifest(ctx, f.getPluginManifest(), theirManifest)
+}
+
+// SyncAgentPluginManifest is called by agents on startup. The stream is
+// authenticated by the cluster auth middleware, so the agent receives the
+// plugins chosen for its authenticated ID.
+func (f *FilesystemPluginSyncServer) SyncAgentPluginManifest(stream controlv1.PluginSync_SyncAgentPluginManifestServer) error {
+	id := cluster.StreamAuthorizedID(stream.Context())
+	theirManifest, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if err := theirManifest.Validate(); err != nil {
+		return err
+	}
+	results, err := f.syncPluginManifest(stream.Context(), f.DesiredManifest(id), theirManifest)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(results)
+}
+
+func (f *FilesystemPluginSyncServer) syncPluginManifest(
+	ctx context.Context,
+	ourManifest *controlv1.PluginManifest,
+	theirManifest *controlv1.PluginManifest,
+) (*controlv1.SyncResults, error) {
 	archive := LeftJoinOn(ourManifest, theirManifest)
 
 	errg, _ := errgroup.WithContext(ctx)
@@ -238,11 +414,15 @@ func (f *FilesystemPluginSyncServer) StreamServerInterceptor() grpc.StreamServer
 
 		//for now, plugin manifest validation is voluntary, but this may change in the future
 		md, ok := metadata.FromIncomingContext(stream.Context())
+		desired := f.getPluginManifest()
+		if ok && len(md.Get(controlv1.AgentIDKey)) > 0 {
+			desired = f.DesiredManifest(id)
+		}
 		if ok {
 			values := md.Get(controlv1.ManifestDigestKey)
 			if len(values) > 0 {
 				digest := values[0]
-				if f.getPluginManifest().Digest() != digest {
+				if desired.Digest() != digest {
 					f.logger.With(
 						"id", id,
 					).Info("agent plugins are out of date; requesting update")
@@ -253,7 +433,7 @@ func (f *FilesystemPluginSyncServer) StreamServerInterceptor() grpc.StreamServer
 
 		return handler(srv, &util.ServerStreamWithContext{
 			Stream: stream,
-			Ctx:    context.WithValue(stream.Context(), manifestMetadataKey, f.getPluginManifest()),
+			Ctx:    context.WithValue(stream.Context(), manifestMetadataKey, desired),
 		})
 	}
 }
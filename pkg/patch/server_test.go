@@ -18,11 +18,14 @@ import (
 	"github.com/rancher/opni/pkg/auth/cluster"
 	"github.com/rancher/opni/pkg/config/v1beta1"
 	"github.com/rancher/opni/pkg/patch"
+	"github.com/rancher/opni/pkg/storage"
 	"github.com/rancher/opni/pkg/test"
 	"github.com/rancher/opni/pkg/test/testgrpc"
 	"github.com/rancher/opni/pkg/test/testutil"
 	"github.com/rancher/opni/pkg/util"
+	"github.com/samber/lo"
 	"github.com/spf13/afero"
+	"golang.org/x/exp/slices"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
@@ -476,3 +479,195 @@ var _ = Describe("Filesystem Sync Server", Ordered, Label(test.Unit, test.Slow),
 		})
 	})
 })
+
+var _ = Describe("Reloading the plugin manifest", Label(test.Unit), func() {
+	var srv *patch.FilesystemPluginSyncServer
+	var fsys afero.Afero
+	pluginsDir := "/plugins"
+	BeforeEach(func() {
+		fsys = afero.Afero{Fs: test.NewModeAwareMemFs()}
+		Expect(fsys.MkdirAll(pluginsDir, 0755)).To(Succeed())
+		Expect(fsys.WriteFile(filepath.Join(pluginsDir, "plugin_test1"), testBinaries["test1"]["v1"], 0644)).To(Succeed())
+		Expect(fsys.WriteFile(filepath.Join(pluginsDir, "plugin_test2"), testBinaries["test2"]["v1"], 0644)).To(Succeed())
+		var err error
+		srv, err = patch.NewFilesystemPluginSyncServer(v1beta1.PluginsSpec{
+			Dir: pluginsDir,
+			Cache: v1beta1.CacheSpec{
+				PatchEngine: v1beta1.PatchEngineBsdiff,
+				Backend:     v1beta1.CacheBackendFilesystem,
+				Filesystem: v1beta1.FilesystemCacheSpec{
+					Dir: "/cache",
+				},
+			},
+		}, test.Log, patch.WithFs(fsys))
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("should update the manifest and archive the new plugins", func() {
+		manifest, err := srv.GetPluginManifest(context.Background(), &emptypb.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.Items[0].Digest).To(Equal(v1Manifest.Items[0].Metadata.Digest))
+
+		Expect(fsys.WriteFile(filepath.Join(pluginsDir, "plugin_test1"), testBinaries["test1"]["v2"], 0644)).To(Succeed())
+		reloaded, err := srv.ReloadManifest()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reloaded.Items[0].Digest).To(Equal(v2Manifest.Items[0].Metadata.Digest))
+		Expect(reloaded.Items[1].Digest).To(Equal(v1Manifest.Items[1].Metadata.Digest))
+
+		manifest, err = srv.GetPluginManifest(context.Background(), &emptypb.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.Digest()).To(Equal(reloaded.Digest()))
+
+		items, err := fsys.ReadDir(filepath.Join("/cache", "plugins"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(items).To(HaveLen(3))
+	})
+})
+
+type testManifestSelector map[string]*controlv1.PluginManifest
+
+func (s testManifestSelector) DesiredManifest(id string) *controlv1.PluginManifest {
+	return s[id]
+}
+
+func (s testManifestSelector) Manifests() []*controlv1.PluginManifest {
+	return nil
+}
+
+type testSyncStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	manifest *controlv1.PluginManifest
+	results  *controlv1.SyncResults
+}
+
+func (s *testSyncStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testSyncStream) Recv() (*controlv1.PluginManifest, error) {
+	return s.manifest, nil
+}
+
+func (s *testSyncStream) SendAndClose(results *controlv1.SyncResults) error {
+	s.results = results
+	return nil
+}
+
+var _ = Describe("Selecting plugins for agents", Label(test.Unit), func() {
+	var srv *patch.FilesystemPluginSyncServer
+	var manifest *controlv1.PluginManifest
+	// agent-1 should have no plugins
+	selected := &controlv1.PluginManifest{}
+	BeforeEach(func() {
+		fsys := afero.Afero{Fs: test.NewModeAwareMemFs()}
+		Expect(fsys.MkdirAll("/plugins", 0755)).To(Succeed())
+		Expect(fsys.WriteFile("/plugins/plugin_test1", testBinaries["test1"]["v1"], 0644)).To(Succeed())
+		var err error
+		srv, err = patch.NewFilesystemPluginSyncServer(v1beta1.PluginsSpec{
+			Dir: "/plugins",
+			Cache: v1beta1.CacheSpec{
+				PatchEngine: v1beta1.PatchEngineBsdiff,
+				Backend:     v1beta1.CacheBackendFilesystem,
+				Filesystem: v1beta1.FilesystemCacheSpec{
+					Dir: "/cache",
+				},
+			},
+		}, test.Log, patch.WithFs(fsys), patch.WithManifestSelector(testManifestSelector{
+			"agent-1": selected,
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		manifest, err = srv.GetPluginManifest(context.Background(), &emptypb.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("should use the authenticated agent ID", func() {
+		stream := &testSyncStream{
+			ctx:      context.WithValue(context.Background(), cluster.ClusterIDKey, "agent-1"),
+			manifest: manifest,
+		}
+		Expect(srv.SyncAgentPluginManifest(stream)).To(Succeed())
+		Expect(stream.results.DesiredState.Digest()).To(Equal(selected.Digest()))
+		Expect(stream.results.RequiredPatches.Items).To(HaveLen(1))
+		Expect(stream.results.RequiredPatches.Items[0].Op).To(Equal(controlv1.PatchOp_Remove))
+
+		stream.ctx = context.WithValue(context.Background(), cluster.ClusterIDKey, "agent-2")
+		Expect(srv.SyncAgentPluginManifest(stream)).To(Succeed())
+		Expect(stream.results.DesiredState.Digest()).To(Equal(manifest.Digest()))
+	})
+	It("should ignore unauthenticated agent IDs", func() {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(controlv1.AgentIDKey, "agent-1"))
+		results, err := srv.SyncPluginManifest(ctx, manifest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results.DesiredState.Digest()).To(Equal(manifest.Digest()))
+		Expect(results.RequiredPatches.Items).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Sharing a plugin cache between replicas", Label(test.Unit), func() {
+	var fsys afero.Afero
+	var references storage.KeyValueStore
+	var locks storage.LockManager
+	newReplica := func(id string, binary []byte) *patch.FilesystemPluginSyncServer {
+		pluginsDir := "/plugins-" + id
+		Expect(fsys.MkdirAll(pluginsDir, 0755)).To(Succeed())
+		Expect(fsys.WriteFile(filepath.Join(pluginsDir, "plugin_test1"), binary, 0644)).To(Succeed())
+		srv, err := patch.NewFilesystemPluginSyncServer(v1beta1.PluginsSpec{
+			Dir: pluginsDir,
+			Cache: v1beta1.CacheSpec{
+				PatchEngine: v1beta1.PatchEngineBsdiff,
+				Backend:     v1beta1.CacheBackendFilesystem,
+				Filesystem: v1beta1.FilesystemCacheSpec{
+					Dir: "/cache",
+				},
+			},
+		}, test.Log,
+			patch.WithFs(fsys),
+			patch.WithLockManager(locks),
+			patch.WithSharedReferences(references, id),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = srv.GetPluginManifest(context.Background(), &emptypb.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+		return srv
+	}
+	BeforeEach(func() {
+		fsys = afero.Afero{Fs: test.NewModeAwareMemFs()}
+		references = test.NewTestKeyValueStore(ctrl, slices.Clone[[]byte])
+		locks = storage.NewInMemoryLockManager()
+	})
+	It("should keep plugins referenced by any replica", func() {
+		replica1 := newReplica("replica-1", testBinaries["test1"]["v1"])
+		replica2 := newReplica("replica-2", testBinaries["test1"]["v2"])
+
+		Expect(replica1.RunGarbageCollection(context.Background(), test.NewTestClusterStore(ctrl))).To(Succeed())
+		Expect(replica2.RunGarbageCollection(context.Background(), test.NewTestClusterStore(ctrl))).To(Succeed())
+
+		items, err := fsys.ReadDir(filepath.Join("/cache", "plugins"))
+		Expect(err).NotTo(HaveOccurred())
+		names := lo.Map(items, func(item fs.FileInfo, _ int) string { return item.Name() })
+		Expect(names).To(ConsistOf(v1Manifest.Items[0].Metadata.Digest, v2Manifest.Items[0].Metadata.Digest))
+	})
+	It("should remove plugins which are no longer referenced by any replica", func() {
+		replica1 := newReplica("replica-1", testBinaries["test1"]["v1"])
+		replica2 := newReplica("replica-2", testBinaries["test1"]["v1"])
+
+		Expect(fsys.WriteFile(filepath.Join("/plugins-replica-1", "plugin_test1"), testBinaries["test1"]["v2"], 0644)).To(Succeed())
+		_, err := replica1.ReloadManifest()
+		Expect(err).NotTo(HaveOccurred())
+
+		By("keeping the old plugin while replica-2 still references it")
+		Expect(replica1.RunGarbageCollection(context.Background(), test.NewTestClusterStore(ctrl))).To(Succeed())
+		items, err := fsys.ReadDir(filepath.Join("/cache", "plugins"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(items).To(HaveLen(2))
+
+		By("removing the old plugin once no replica references it")
+		Expect(fsys.WriteFile(filepath.Join("/plugins-replica-2", "plugin_test1"), testBinaries["test1"]["v2"], 0644)).To(Succeed())
+		_, err = replica2.ReloadManifest()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(replica2.RunGarbageCollection(context.Background(), test.NewTestClusterStore(ctrl))).To(Succeed())
+		items, err = fsys.ReadDir(filepath.Join("/cache", "plugins"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(items).To(HaveLen(1))
+		Expect(items[0].Name()).To(Equal(v2Manifest.Items[0].Metadata.Digest))
+	})
+})
@@ -0,0 +1,186 @@
+package patch
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/pkp"
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+// SignaturesFilename is the name of the file in the plugin directory which
+// contains signatures for the plugins in that directory. It is written by
+// 'opni plugins sign' and read by the gateway when loading its plugins.
+const SignaturesFilename = "plugin-signatures.json"
+
+const signaturePrefix = "opni-plugin-signature-v1\n"
+
+var (
+	ErrMissingSignature = errors.New("plugin is not signed")
+	ErrInvalidSignature = errors.New("plugin signature is not valid for any trusted key")
+)
+
+// SignatureFile maps plugin digests to signatures.
+type SignatureFile struct {
+	Signatures map[string][]byte `json:"signatures"`
+}
+
+func signedData(entry *controlv1.PluginManifestEntry) []byte {
+	return []byte(signaturePrefix + entry.GetModule() + "\n" + entry.GetDigest())
+}
+
+// Sign signs the module name and digest of the entry, and stores the
+// signature in the entry. The signature is prefixed with the public key of
+// the signing key, which verifiers check against their trusted key pins.
+func Sign(key ed25519.PrivateKey, entry *controlv1.PluginManifestEntry) {
+	entry.Signature = append(slices.Clone(key.Public().(ed25519.PublicKey)), ed25519.Sign(key, signedData(entry))...)
+}
+
+// ReadSignatures reads the signature file in the given plugin directory. If
+// the file does not exist, an empty signature file is returned.
+func ReadSignatures(fsys afero.Fs, dir string) (*SignatureFile, error) {
+	data, err := afero.ReadFile(fsys, filepath.Join(dir, SignaturesFilename))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &SignatureFile{Signatures: map[string][]byte{}}, nil
+		}
+		return nil, err
+	}
+	sf := &SignatureFile{}
+	if err := json.Unmarshal(data, sf); err != nil {
+		return nil, fmt.Errorf("malformed signature file: %w", err)
+	}
+	if sf.Signatures == nil {
+		sf.Signatures = map[string][]byte{}
+	}
+	return sf, nil
+}
+
+// WriteSignatures writes the signature file to the given plugin directory.
+func WriteSignatures(fsys afero.Fs, dir string, sf *SignatureFile) error {
+	data, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(fsys, filepath.Join(dir, SignaturesFilename), data, 0644)
+}
+
+// PluginVerifier checks plugin signatures according to a trust policy.
+type PluginVerifier struct {
+	policy v1beta1.PluginTrustPolicy
+	pins   []*pkp.PublicKeyPin
+	lg     *zap.SugaredLogger
+}
+
+func NewPluginVerifier(conf v1beta1.PluginVerificationSpec, lg *zap.SugaredLogger) (*PluginVerifier, error) {
+	v := &PluginVerifier{
+		policy: conf.Policy,
+		lg:     lg,
+	}
+	switch conf.Policy {
+	case "", v1beta1.PluginTrustPolicyNone:
+		v.policy = v1beta1.PluginTrustPolicyNone
+		return v, nil
+	case v1beta1.PluginTrustPolicyWarn, v1beta1.PluginTrustPolicyEnforce:
+	default:
+		return nil, fmt.Errorf("unknown plugin trust policy: %s", conf.Policy)
+	}
+	for i, data := range conf.TrustedKeys {
+		pin, err := pkp.DecodePin(data)
+		if err == nil {
+			err = pin.Validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted key at index %d: %w", i, err)
+		}
+		v.pins = append(v.pins, pin)
+	}
+	if len(v.pins) == 0 && v.policy == v1beta1.PluginTrustPolicyEnforce {
+		return nil, errors.New("no trusted keys configured for plugin verification")
+	}
+	return v, nil
+}
+
+// Enabled reports whether plugin signatures are checked.
+func (v *PluginVerifier) Enabled() bool {
+	return v.policy != v1beta1.PluginTrustPolicyNone
+}
+
+// VerifyEntry checks that the entry has a valid signature from a trusted key.
+func (v *PluginVerifier) VerifyEntry(entry *controlv1.PluginManifestEntry) error {
+	signature := entry.GetSignature()
+	if len(signature) == 0 {
+		return ErrMissingSignature
+	}
+	if len(signature) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	key := ed25519.PublicKey(signature[:ed25519.PublicKeySize])
+	if !v.trusts(key) || !ed25519.Verify(key, signedData(entry), signature[ed25519.PublicKeySize:]) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// trusts reports whether the key matches any of the trusted key pins.
+func (v *PluginVerifier) trusts(key ed25519.PublicKey) bool {
+	for _, trusted := range v.pins {
+		pin, err := pkp.NewForPublicKey(key, trusted.Algorithm)
+		if err == nil && pin.Equal(trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signatures of all plugins in the desired manifest, and
+// that every patch results in one of those plugins. This must be called
+// before applying the patches. Because plugins are only loaded if their
+// contents match the digests in the desired manifest, this ensures that only
+// signed plugins are executed.
+//
+// Depending on the trust policy, verification failures are either returned
+// as an error, or only logged.
+func (v *PluginVerifier) Verify(desired *controlv1.PluginManifest, patches *controlv1.PatchList) error {
+	if !v.Enabled() {
+		return nil
+	}
+	var errs []error
+	trusted := map[string]struct{}{}
+	for _, entry := range desired.GetItems() {
+		if err := v.VerifyEntry(entry); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", entry.GetModule(), entry.GetFilename(), err))
+			continue
+		}
+		trusted[entry.GetDigest()] = struct{}{}
+	}
+	for _, p := range patches.GetItems() {
+		switch p.GetOp() {
+		case controlv1.PatchOp_Create, controlv1.PatchOp_Update, controlv1.PatchOp_Rename:
+		default:
+			continue
+		}
+		if _, ok := trusted[p.GetNewDigest()]; !ok {
+			errs = append(errs, fmt.Errorf("%s (%s): patch does not produce a trusted plugin", p.GetModule(), p.GetFilename()))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	err := errors.Join(errs...)
+	if v.policy == v1beta1.PluginTrustPolicyWarn {
+		v.lg.With(
+			zap.Error(err),
+		).Warn("plugin signature verification failed (ignored due to trust policy)")
+		return nil
+	}
+	return fmt.Errorf("plugin signature verification failed: %w", err)
+}
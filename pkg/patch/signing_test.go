@@ -0,0 +1,170 @@
+package patch_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/afero"
+
+	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/patch"
+	"github.com/rancher/opni/pkg/pkp"
+	"github.com/rancher/opni/pkg/test"
+)
+
+var _ = Describe("Plugin Signatures", Label(test.Unit), func() {
+	var trustedKey, untrustedKey ed25519.PrivateKey
+	var trustedPub string
+
+	encodePin := func(key ed25519.PrivateKey) string {
+		pin, err := pkp.NewForPublicKey(key.Public(), pkp.AlgB2B256)
+		Expect(err).NotTo(HaveOccurred())
+		return pin.Encode()
+	}
+	entry := func(plugin string, version string) *controlv1.PluginManifestEntry {
+		return &controlv1.PluginManifestEntry{
+			Module:   testModules[plugin],
+			Filename: "plugin_" + plugin,
+			Digest:   testBinaryDigests[plugin][version],
+		}
+	}
+	signed := func(key ed25519.PrivateKey, e *controlv1.PluginManifestEntry) *controlv1.PluginManifestEntry {
+		patch.Sign(key, e)
+		return e
+	}
+	newVerifier := func(policy v1beta1.PluginTrustPolicy) *patch.PluginVerifier {
+		v, err := patch.NewPluginVerifier(v1beta1.PluginVerificationSpec{
+			Policy:      policy,
+			TrustedKeys: []string{trustedPub},
+		}, test.Log)
+		Expect(err).NotTo(HaveOccurred())
+		return v
+	}
+
+	BeforeEach(func() {
+		var err error
+		_, trustedKey, err = ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		_, untrustedKey, err = ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		trustedPub = encodePin(trustedKey)
+	})
+
+	It("should verify entries signed by a trusted key", func() {
+		v := newVerifier(v1beta1.PluginTrustPolicyEnforce)
+		Expect(v.VerifyEntry(signed(trustedKey, entry("test1", "v1")))).To(Succeed())
+		Expect(v.VerifyEntry(entry("test1", "v1"))).To(MatchError(patch.ErrMissingSignature))
+		Expect(v.VerifyEntry(signed(untrustedKey, entry("test1", "v1")))).To(MatchError(patch.ErrInvalidSignature))
+	})
+
+	It("should reject entries whose digest or module was changed after signing", func() {
+		v := newVerifier(v1beta1.PluginTrustPolicyEnforce)
+		e := signed(trustedKey, entry("test1", "v1"))
+		e.Digest = testBinaryDigests["test1"]["v2"]
+		Expect(v.VerifyEntry(e)).To(MatchError(patch.ErrInvalidSignature))
+
+		e = signed(trustedKey, entry("test1", "v1"))
+		e.Module = test2Module
+		Expect(v.VerifyEntry(e)).To(MatchError(patch.ErrInvalidSignature))
+	})
+
+	When("the trust policy is enforce", func() {
+		It("should accept signed manifests and matching patches", func() {
+			v := newVerifier(v1beta1.PluginTrustPolicyEnforce)
+			manifest := &controlv1.PluginManifest{
+				Items: []*controlv1.PluginManifestEntry{
+					signed(trustedKey, entry("test1", "v2")),
+					signed(trustedKey, entry("test2", "v1")),
+				},
+			}
+			Expect(v.Verify(manifest, &controlv1.PatchList{
+				Items: []*controlv1.PatchSpec{
+					op(update, "test1", v1, v2),
+					op(create, "test2", v1),
+				},
+			})).To(Succeed())
+		})
+		It("should refuse unsigned plugins", func() {
+			v := newVerifier(v1beta1.PluginTrustPolicyEnforce)
+			manifest := &controlv1.PluginManifest{
+				Items: []*controlv1.PluginManifestEntry{
+					signed(trustedKey, entry("test1", "v1")),
+					entry("test2", "v1"),
+				},
+			}
+			Expect(v.Verify(manifest, &controlv1.PatchList{})).To(MatchError(patch.ErrMissingSignature))
+		})
+		It("should refuse patches which do not produce a signed plugin", func() {
+			v := newVerifier(v1beta1.PluginTrustPolicyEnforce)
+			manifest := &controlv1.PluginManifest{
+				Items: []*controlv1.PluginManifestEntry{
+					signed(trustedKey, entry("test1", "v1")),
+				},
+			}
+			Expect(v.Verify(manifest, &controlv1.PatchList{
+				Items: []*controlv1.PatchSpec{
+					op(update, "test1", v1, v2),
+				},
+			})).To(MatchError(ContainSubstring("patch does not produce a trusted plugin")))
+		})
+		It("should reject invalid trusted key pins", func() {
+			_, err := patch.NewPluginVerifier(v1beta1.PluginVerificationSpec{
+				Policy:      v1beta1.PluginTrustPolicyEnforce,
+				TrustedKeys: []string{"b2b256:invalid"},
+			}, test.Log)
+			Expect(err).To(HaveOccurred())
+		})
+		It("should require at least one trusted key", func() {
+			_, err := patch.NewPluginVerifier(v1beta1.PluginVerificationSpec{
+				Policy: v1beta1.PluginTrustPolicyEnforce,
+			}, test.Log)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	When("the trust policy is warn or none", func() {
+		It("should accept unsigned plugins", func() {
+			manifest := &controlv1.PluginManifest{
+				Items: []*controlv1.PluginManifestEntry{
+					entry("test1", "v2"),
+				},
+			}
+			patches := &controlv1.PatchList{
+				Items: []*controlv1.PatchSpec{
+					op(update, "test1", v1, v2),
+				},
+			}
+			Expect(newVerifier(v1beta1.PluginTrustPolicyWarn).Verify(manifest, patches)).To(Succeed())
+			Expect(newVerifier(v1beta1.PluginTrustPolicyNone).Verify(manifest, patches)).To(Succeed())
+			Expect(newVerifier("").Verify(manifest, patches)).To(Succeed())
+			Expect(newVerifier("").Enabled()).To(BeFalse())
+		})
+	})
+
+	It("should reject unknown trust policies", func() {
+		_, err := patch.NewPluginVerifier(v1beta1.PluginVerificationSpec{
+			Policy: "foo",
+		}, test.Log)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should read and write signature files", func() {
+		fsys := afero.NewMemMapFs()
+		Expect(fsys.MkdirAll("/plugins", 0755)).To(Succeed())
+
+		sf, err := patch.ReadSignatures(fsys, "/plugins")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sf.Signatures).To(BeEmpty())
+
+		e := signed(trustedKey, entry("test1", "v1"))
+		sf.Signatures[e.Digest] = e.Signature
+		Expect(patch.WriteSignatures(fsys, "/plugins", sf)).To(Succeed())
+
+		sf, err = patch.ReadSignatures(fsys, "/plugins")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sf.Signatures).To(HaveKeyWithValue(e.Digest, e.Signature))
+	})
+})
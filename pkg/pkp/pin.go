@@ -1,6 +1,7 @@
 package pkp
 
 import (
+	"crypto"
 	"crypto/sha256"
 	"crypto/subtle"
 	"crypto/x509"
@@ -85,6 +86,17 @@ func New(cert *x509.Certificate, alg Alg) (*PublicKeyPin, error) {
 	}
 }
 
+// NewForPublicKey returns a pin for the given public key. The fingerprint is
+// computed in the same way as for certificates, over the key's DER-encoded
+// SubjectPublicKeyInfo.
+func NewForPublicKey(pub crypto.PublicKey, alg Alg) (*PublicKeyPin, error) {
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return New(&x509.Certificate{RawSubjectPublicKeyInfo: spki}, alg)
+}
+
 func DecodePin(pin string) (*PublicKeyPin, error) {
 	parts := strings.Split(pin, ":")
 	if len(parts) == 1 {
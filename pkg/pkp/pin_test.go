@@ -56,6 +56,17 @@ var _ = Describe("Public Key Pinning", Label("unit"), func() {
 			}
 		}
 	})
+	It("should compute public key fingerprints in the same way as certificate fingerprints", func() {
+		cert, err := util.ParsePEMEncodedCert(test.TestData("root_ca.crt"))
+		Expect(err).NotTo(HaveOccurred())
+		for _, alg := range []pkp.Alg{pkp.AlgSHA256, pkp.AlgB2B256} {
+			expected, err := pkp.New(cert, alg)
+			Expect(err).NotTo(HaveOccurred())
+			computed, err := pkp.NewForPublicKey(cert.PublicKey, alg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(computed.Equal(expected)).To(BeTrue())
+		}
+	})
 	It("should correctly deep-copy", func() {
 		certData := test.TestData("root_ca.crt")
 		cert, err := util.ParsePEMEncodedCert(certData)
@@ -12,7 +12,9 @@
 // found in pkg/plugins/types.
 //
 // Load hooks will be invoked exactly once per plugin, per hook, in a separate
-// goroutine. All load hooks for a particular event are run in parallel.
+// goroutine. All load hooks for a particular event are run in parallel. If a
+// plugin is unloaded and loaded again (see PluginUnloadHook), load hooks will
+// be invoked again for the new instance of the plugin.
 //
 // Load hooks should not block for an extended period of time. When a plugin
 // is loaded, it will block until all hooks have completed (returned). Blocking
@@ -20,6 +22,18 @@
 // can be registered during other hook callbacks, but take care to avoid
 // deadlocks.
 //
+// # PluginUnloadHook
+//
+// This hook is invoked whenever a plugin is unloaded by the plugin loader,
+// which occurs when a plugin is removed or replaced while the gateway is
+// running. It is invoked before the plugin is stopped, and should be used to
+// remove anything registered in the corresponding load hook, so that no new
+// requests are sent to the plugin.
+//
+// Use the OnUnload* methods to construct a new PluginUnloadHook. Unload hooks
+// are invoked at most once per plugin, per hook, and the plugin will not be
+// stopped until all unload hooks have completed.
+//
 // # LoadingCompletedHook
 //
 // This hook is invoked after all plugins have been loaded, which occurs when
@@ -11,8 +11,16 @@ type PluginLoadHook interface {
 	Invoke(t any, md meta.PluginMeta, conn *grpc.ClientConn) (done chan struct{})
 }
 
+// ResettableHook is implemented by load hooks which are only invoked once per
+// plugin. When a plugin is unloaded, the plugin loader resets these hooks so
+// that they will be invoked again if the plugin is loaded again.
+type ResettableHook interface {
+	Reset(md meta.PluginMeta)
+}
+
 type onLoadHook[T any] struct {
 	callback onLoadCallback[T]
+	invoked  *gsync.Map[string, bool]
 }
 
 func (onLoadHook[T]) ShouldInvoke(t any) bool {
@@ -24,11 +32,17 @@ func (h onLoadHook[T]) Invoke(t any, md meta.PluginMeta, cc *grpc.ClientConn) ch
 	c := make(chan struct{})
 	go func(t T) {
 		defer close(c)
-		h.callback(t, md, cc)
+		if _, loaded := h.invoked.LoadOrStore(md.Module, true); !loaded {
+			h.callback(t, md, cc)
+		}
 	}(t.(T))
 	return c
 }
 
+func (h onLoadHook[T]) Reset(md meta.PluginMeta) {
+	h.invoked.Delete(md.Module)
+}
+
 type onLoadCallback[T any] func(t T, md meta.PluginMeta, conn *grpc.ClientConn)
 
 // Invokes the provided callback function when the plugin of type T is loaded.
@@ -47,12 +61,8 @@ func OnLoadM[T any](fn func(T, meta.PluginMeta)) PluginLoadHook {
 
 // Like OnLoadM[T], but adds the grpc client connection to the callback.
 func OnLoadMC[T any](fn func(T, meta.PluginMeta, *grpc.ClientConn)) PluginLoadHook {
-	once := gsync.Map[string, bool]{}
 	return &onLoadHook[T]{
-		callback: func(t T, md meta.PluginMeta, cc *grpc.ClientConn) {
-			if _, loaded := once.LoadOrStore(md.Module, true); !loaded {
-				fn(t, md, cc)
-			}
-		},
+		callback: fn,
+		invoked:  &gsync.Map[string, bool]{},
 	}
 }
@@ -0,0 +1,43 @@
+package hooks
+
+import (
+	"github.com/rancher/opni/pkg/plugins/meta"
+)
+
+type PluginUnloadHook interface {
+	ShouldInvoke(t any) bool
+	Invoke(t any, md meta.PluginMeta) (done chan struct{})
+}
+
+type onUnloadHook[T any] struct {
+	callback func(T, meta.PluginMeta)
+}
+
+func (onUnloadHook[T]) ShouldInvoke(t any) bool {
+	_, ok := t.(T)
+	return ok
+}
+
+func (h onUnloadHook[T]) Invoke(t any, md meta.PluginMeta) chan struct{} {
+	c := make(chan struct{})
+	go func(t T) {
+		defer close(c)
+		h.callback(t, md)
+	}(t.(T))
+	return c
+}
+
+// Invokes the provided callback function when the plugin of type T is
+// unloaded, before the plugin is stopped.
+func OnUnload[T any](fn func(T)) PluginUnloadHook {
+	return OnUnloadM(func(t T, _ meta.PluginMeta) {
+		fn(t)
+	})
+}
+
+// Like OnUnload[T], but adds plugin metadata to the callback.
+func OnUnloadM[T any](fn func(T, meta.PluginMeta)) PluginUnloadHook {
+	return &onUnloadHook[T]{
+		callback: fn,
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
 
 	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
 	"github.com/rancher/opni/pkg/config/v1beta1"
@@ -41,9 +42,12 @@ func (noopLoader) Hook(h any) {
 var NoopLoader = noopLoader{}
 
 type activePlugin struct {
-	md     meta.PluginMeta
-	client *plugin.GRPCClient
-	raw    any
+	md       meta.PluginMeta
+	digest   string
+	client   *plugin.GRPCClient
+	process  *plugin.Client
+	inflight *inflightTracker
+	raw      any
 }
 
 type hook[T any] struct {
@@ -56,7 +60,9 @@ type PluginLoader struct {
 
 	hooksMu        sync.RWMutex
 	pluginsMu      sync.RWMutex
+	reloadMu       sync.Mutex
 	loadHooks      []hook[hooks.PluginLoadHook]
+	unloadHooks    []hook[hooks.PluginUnloadHook]
 	completedHooks []hook[hooks.LoadingCompletedHook]
 	activePlugins  []activePlugin
 	completed      *atomic.Bool
@@ -96,13 +102,8 @@ func NewPluginLoader(opts ...PluginLoaderOption) *PluginLoader {
 }
 
 func (p *PluginLoader) Hook(h any) {
-	if p.completed.Load() {
-		p.hooksMu.RLock()
-		defer p.hooksMu.RUnlock()
-	} else {
-		p.hooksMu.Lock()
-		defer p.hooksMu.Unlock()
-	}
+	p.hooksMu.Lock()
+	defer p.hooksMu.Unlock()
 	// save the caller so we can log it later if needed
 	_, file, line, _ := runtime.Caller(1)
 	caller := fmt.Sprintf("%s:%d", file, line)
@@ -122,6 +123,11 @@ func (p *PluginLoader) Hook(h any) {
 				h.Invoke(ap.raw, ap.md, ap.client.Conn)
 			}
 		}
+	case hooks.PluginUnloadHook:
+		p.unloadHooks = append(p.unloadHooks, hook[hooks.PluginUnloadHook]{
+			hook:   h,
+			caller: caller,
+		})
 	case hooks.LoadingCompletedHook:
 		p.completedHooks = append(p.completedHooks, hook[hooks.LoadingCompletedHook]{
 			hook:   h,
@@ -137,30 +143,64 @@ func (p *PluginLoader) Hook(h any) {
 
 // LoadOne loads a single plugin. It invokes PluginLoadHooks for the type of
 // the plugin being loaded and will block until all load hooks have completed.
+// Plugins can be loaded individually after loading has completed, for example
+// to replace a plugin which was unloaded.
 func (p *PluginLoader) LoadOne(ctx context.Context, md meta.PluginMeta, cc *plugin.ClientConfig) {
-	p.ensureNotCompleted()
-	tracer := otel.Tracer("pluginloader")
-	tc, span := tracer.Start(ctx, "LoadOne",
+	tc, span := otel.Tracer("pluginloader").Start(ctx, "LoadOne",
 		trace.WithAttributes(attribute.String("plugin", md.Module)))
 	defer span.End()
 
+	p.logger.With(
+		zap.String("plugin", md.Module),
+	).Info("loading plugin")
+
+	started, err := p.start(md, cc)
+	if err != nil {
+		p.logger.With(
+			zap.String("plugin", md.Module),
+			zap.Error(err),
+		).Error("failed to load plugin")
+		return
+	}
+	p.activate(tc, started)
+}
+
+// start starts the plugin process, and dispenses each implementation in the
+// scheme which the plugin implements. No hooks are invoked, so the plugin does
+// not receive any requests until it is activated. All returned plugins share
+// the same process. If the plugin fails to start, or does not implement any
+// interfaces in the scheme, the process is stopped and an error is returned.
+func (p *PluginLoader) start(md meta.PluginMeta, cc *plugin.ClientConfig) ([]activePlugin, error) {
 	lg := p.logger.With(
 		zap.String("plugin", md.Module),
 	)
-	lg.Info("loading plugin")
+
+	var digest string
+	if cc.Reattach == nil {
+		var err error
+		if digest, err = fileDigest(md.BinaryPath); err != nil {
+			lg.With(
+				zap.Error(err),
+			).Warn("failed to compute plugin digest; plugin will not be reloaded if it changes")
+		}
+	}
+	inflight := &inflightTracker{}
+	cc.GRPCDialOptions = append(cc.GRPCDialOptions, inflight.dialOptions()...)
 
 	client := plugin.NewClient(cc)
 	rpcClient, err := client.Client()
 	if err != nil {
-		lg.With(
-			zap.Error(err),
-		).Error("failed to load plugin")
-		return
+		client.Kill()
+		return nil, err
+	}
+	if err := rpcClient.Ping(); err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("plugin is not responding: %w", err)
 	}
 	lg.With(
 		"interfaces", lo.Keys(cc.Plugins),
 	).Debug("checking if plugin implements any interfaces in the scheme")
-	wg := &sync.WaitGroup{}
+	var started []activePlugin
 	for id := range cc.Plugins {
 		raw, err := rpcClient.Dispense(id)
 		if err != nil {
@@ -174,50 +214,160 @@ func (p *PluginLoader) LoadOne(ctx context.Context, md meta.PluginMeta, cc *plug
 			"id", id,
 		).Debug("implementation found")
 		if c, ok := rpcClient.(*plugin.GRPCClient); ok {
-			p.hooksMu.RLock()
-			numHooks := len(p.loadHooks)
-			if numHooks > 0 {
-				lg.Debugf("invoking load hooks (%d)", numHooks)
-			}
-			for _, h := range p.loadHooks {
-				if h.hook.ShouldInvoke(raw) {
-					wg.Add(1)
-					h := h
-					go func() {
-						_, span := tracer.Start(tc, "PluginLoadHook",
-							trace.WithAttributes(attribute.String("caller", h.caller)))
-						defer span.End()
-						defer wg.Done()
-						done := h.hook.Invoke(raw, md, c.Conn)
-						select {
-						case <-done:
-						case <-time.After(time.Second * 5):
-							lg.With(
-								"id", id,
-								"caller", h.caller,
-							).Warn("hook is taking longer than expected to complete")
-							<-done
-						}
-					}()
-				}
+			started = append(started, activePlugin{
+				md:       md,
+				digest:   digest,
+				client:   c,
+				process:  client,
+				inflight: inflight,
+				raw:      raw,
+			})
+		}
+	}
+	if len(started) == 0 {
+		client.Kill()
+		return nil, fmt.Errorf("plugin does not implement any interfaces in the scheme")
+	}
+	return started, nil
+}
+
+// activate invokes load hooks for each of the started plugins, and adds them
+// to the active plugins. Blocks until all load hooks have completed.
+func (p *PluginLoader) activate(ctx context.Context, started []activePlugin) {
+	tracer := otel.Tracer("pluginloader")
+	wg := &sync.WaitGroup{}
+	for _, ap := range started {
+		ap := ap
+		lg := p.logger.With(
+			zap.String("plugin", ap.md.Module),
+		)
+		p.hooksMu.RLock()
+		loadHooks := slices.Clone(p.loadHooks)
+		p.hooksMu.RUnlock()
+		if len(loadHooks) > 0 {
+			lg.Debugf("invoking load hooks (%d)", len(loadHooks))
+		}
+		for _, h := range loadHooks {
+			if h.hook.ShouldInvoke(ap.raw) {
+				wg.Add(1)
+				h := h
+				go func() {
+					_, span := tracer.Start(ctx, "PluginLoadHook",
+						trace.WithAttributes(attribute.String("caller", h.caller)))
+					defer span.End()
+					defer wg.Done()
+					waitForHook(lg, h.caller, h.hook.Invoke(ap.raw, ap.md, ap.client.Conn))
+				}()
 			}
-			p.hooksMu.RUnlock()
+		}
 
-			p.pluginsMu.Lock()
-			p.activePlugins = append(p.activePlugins, activePlugin{
-				md:     md,
-				client: c,
-				raw:    raw,
-			})
-			p.pluginsMu.Unlock()
+		p.pluginsMu.Lock()
+		p.activePlugins = append(p.activePlugins, ap)
+		p.pluginsMu.Unlock()
+	}
+	wg.Wait()
+}
+
+// Unload stops the plugin with the given module name. PluginUnloadHooks are
+// invoked first, so that no new requests are sent to the plugin. The plugin
+// is then given until ctx is done for in-flight requests to complete before
+// it is stopped. Load hooks which have already been invoked for the plugin
+// will be invoked again if the plugin is loaded again. Returns false if the
+// plugin is not loaded.
+func (p *PluginLoader) Unload(ctx context.Context, module string) bool {
+	unloading := p.deactivate(module)
+	if len(unloading) == 0 {
+		return false
+	}
+	p.stop(ctx, unloading[0])
+	return true
+}
+
+// deactivate removes the plugins with the given module name from the active
+// plugins, and invokes unload hooks for them. Load hooks are reset, so that
+// they will be invoked for the next plugin activated with the same module
+// name. Returns the removed plugins, which all share the same process.
+func (p *PluginLoader) deactivate(module string) []activePlugin {
+	p.pluginsMu.Lock()
+	var unloading []activePlugin
+	p.activePlugins = lo.Filter(p.activePlugins, func(ap activePlugin, _ int) bool {
+		if ap.md.Module == module {
+			unloading = append(unloading, ap)
+			return false
+		}
+		return true
+	})
+	p.pluginsMu.Unlock()
+	if len(unloading) == 0 {
+		return nil
+	}
+
+	md := unloading[0].md
+	lg := p.logger.With(
+		zap.String("plugin", md.Module),
+	)
+	lg.Info("unloading plugin")
+
+	p.hooksMu.RLock()
+	loadHooks := slices.Clone(p.loadHooks)
+	unloadHooks := slices.Clone(p.unloadHooks)
+	p.hooksMu.RUnlock()
+
+	wg := &sync.WaitGroup{}
+	for _, h := range unloadHooks {
+		h := h
+		for _, ap := range unloading {
+			if !h.hook.ShouldInvoke(ap.raw) {
+				continue
+			}
+			ap := ap
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				waitForHook(lg, h.caller, h.hook.Invoke(ap.raw, md))
+			}()
+			break
 		}
 	}
 	wg.Wait()
+	for _, h := range loadHooks {
+		if r, ok := h.hook.(hooks.ResettableHook); ok {
+			r.Reset(md)
+		}
+	}
+	return unloading
+}
+
+// stop gives the plugin until ctx is done for in-flight requests to complete,
+// then stops the plugin process.
+func (p *PluginLoader) stop(ctx context.Context, ap activePlugin) {
+	lg := p.logger.With(
+		zap.String("plugin", ap.md.Module),
+	)
+	if err := ap.inflight.wait(ctx); err != nil {
+		lg.With(
+			"requests", ap.inflight.count.Load(),
+		).Warn("timed out waiting for in-flight requests to complete, stopping plugin")
+	}
+	ap.process.Kill()
+	lg.Info("plugin unloaded")
+}
+
+func waitForHook(lg *zap.SugaredLogger, caller string, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		lg.With(
+			"caller", caller,
+		).Warn("hook is taking longer than expected to complete")
+		<-done
+	}
 }
 
 type LoadOptions struct {
 	manifest      *controlv1.PluginManifest
 	clientOptions []ClientOption
+	drainTimeout  time.Duration
 }
 
 type LoadOption func(*LoadOptions)
@@ -244,27 +394,49 @@ func WithManifest(manifest *controlv1.PluginManifest) LoadOption {
 	}
 }
 
+// WithDrainTimeout sets the maximum amount of time Reload() will wait for
+// in-flight requests to complete before stopping a plugin which is being
+// replaced or removed. Defaults to 30 seconds.
+func WithDrainTimeout(timeout time.Duration) LoadOption {
+	return func(o *LoadOptions) {
+		o.drainTimeout = timeout
+	}
+}
+
+// clientConfig returns the client config for the given plugin, or false if
+// the plugin should not be loaded.
+func (o *LoadOptions) clientConfig(md meta.PluginMeta, scheme meta.Scheme, lg *zap.SugaredLogger) (*plugin.ClientConfig, bool) {
+	clientOpts := slices.Clone(o.clientOptions)
+	if o.manifest != nil {
+		entry, ok := lo.Find(o.manifest.Items, func(entry *controlv1.PluginManifestEntry) bool {
+			return entry.Module == md.Module
+		})
+		if !ok {
+			lg.With(
+				"module", md.Module,
+				"path", md.BinaryPath,
+			).Warn("plugin is not present in manifest, skipping")
+			return nil, false
+		}
+		clientOpts = append(clientOpts, WithSecureConfig(&plugin.SecureConfig{
+			Checksum: entry.DigestBytes(),
+			Hash:     entry.DigestHash(),
+		}))
+	}
+	return ClientConfig(md, scheme, clientOpts...), true
+}
+
 // LoadPlugins loads a set of plugins defined by the plugin configuration.
 // This function loads plugins in parallel and does not block. It will invoke
 // LoadingCompletedHooks once all plugins have been loaded. Once this function
-// is called, it is unsafe to call LoadPlugins() or LoadOne() again for this
-// plugin loader, although new hooks can still be added and will be invoked
-// immediately according to the current state of the plugin loader.
+// is called, it is unsafe to call LoadPlugins() again for this plugin loader,
+// although new hooks can still be added and will be invoked immediately
+// according to the current state of the plugin loader. Use Reload() to pick
+// up changes to the plugins afterwards.
 func (p *PluginLoader) LoadPlugins(ctx context.Context, conf v1beta1.PluginsSpec, scheme meta.Scheme, opts ...LoadOption) {
 	options := LoadOptions{}
 	options.apply(opts...)
 
-	verifyManifest := (options.manifest != nil)
-	secureConfigs := make(map[string]*plugin.SecureConfig)
-	if verifyManifest {
-		for _, entry := range options.manifest.Items {
-			secureConfigs[entry.Module] = &plugin.SecureConfig{
-				Checksum: entry.DigestBytes(),
-				Hash:     entry.DigestHash(),
-			}
-		}
-	}
-
 	tc, span := otel.Tracer("pluginloader").Start(ctx, "LoadPlugins")
 
 	wg := &sync.WaitGroup{}
@@ -277,19 +449,10 @@ func (p *PluginLoader) LoadPlugins(ctx context.Context, conf v1beta1.PluginsSpec
 
 	for _, md := range plugins {
 		md := md
-		clientOpts := options.clientOptions
-		if verifyManifest {
-			if secureConfig, ok := secureConfigs[md.Module]; ok {
-				clientOpts = append(clientOpts, WithSecureConfig(secureConfig))
-			} else {
-				p.logger.With(
-					"module", md.Module,
-					"path", md.BinaryPath,
-				).Warn("plugin is not present in manifest, skipping")
-				continue
-			}
+		cc, ok := options.clientConfig(md, scheme, p.logger)
+		if !ok {
+			continue
 		}
-		cc := ClientConfig(md, scheme, clientOpts...)
 
 		wg.Add(1)
 		go func() {
@@ -0,0 +1,210 @@
+package plugins
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-plugin"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/blake2b"
+	"google.golang.org/grpc"
+
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/plugins/meta"
+)
+
+const defaultDrainTimeout = 30 * time.Second
+
+// ReloadResult describes the plugins which were changed by Reload().
+type ReloadResult struct {
+	// Plugins which were not previously loaded
+	Loaded []meta.PluginMeta
+	// Plugins whose binaries changed, and were replaced
+	Reloaded []meta.PluginMeta
+	// Plugins which were removed from the plugin directory
+	Unloaded []meta.PluginMeta
+	// Plugins whose new binaries failed to start. The previous version of
+	// each plugin, if any, continues to serve requests.
+	Failed []meta.PluginMeta
+}
+
+// Changed returns true if any plugins were loaded, reloaded, or unloaded.
+func (r ReloadResult) Changed() bool {
+	return len(r.Loaded)+len(r.Reloaded)+len(r.Unloaded) > 0
+}
+
+// Reload compares the plugins in the plugin directory to the active plugins.
+// New plugins are loaded, plugins whose binaries have changed are replaced,
+// and plugins which have been removed are unloaded. A replacement plugin is
+// started before the previous version is unloaded; if it fails to start, the
+// previous version is kept. Once it has started, hooks are swapped over to the
+// new version and the previous version is drained and stopped. Plugins
+// which were not loaded from a binary in the plugin directory (e.g. using a
+// reattach config) are never reloaded. Reload can only be called after
+// loading has completed, and blocks until all changes have been applied.
+func (p *PluginLoader) Reload(ctx context.Context, conf v1beta1.PluginsSpec, scheme meta.Scheme, opts ...LoadOption) ReloadResult {
+	if !p.completed.Load() {
+		panic("bug: Reload called before loading completed")
+	}
+	options := LoadOptions{
+		drainTimeout: defaultDrainTimeout,
+	}
+	options.apply(opts...)
+
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	active := map[string]activePlugin{}
+	p.pluginsMu.RLock()
+	for _, ap := range p.activePlugins {
+		active[ap.md.Module] = ap
+	}
+	p.pluginsMu.RUnlock()
+
+	dc := DiscoveryConfig{
+		Dir:    conf.Dir,
+		Logger: p.logger,
+	}
+	var result ReloadResult
+	discovered := map[string]struct{}{}
+	for _, md := range dc.Discover() {
+		discovered[md.Module] = struct{}{}
+		ap, isActive := active[md.Module]
+		if isActive {
+			if ap.digest == "" {
+				continue
+			}
+			digest, err := fileDigest(md.BinaryPath)
+			if err != nil {
+				p.logger.With(
+					zap.Error(err),
+					"plugin", md.Module,
+				).Warn("failed to compute plugin digest, skipping")
+				continue
+			}
+			if digest == ap.digest {
+				continue
+			}
+		}
+		cc, ok := options.clientConfig(md, scheme, p.logger)
+		if !ok {
+			continue
+		}
+		if err := p.replace(ctx, md, cc, options.drainTimeout); err != nil {
+			lg := p.logger.With(
+				zap.Error(err),
+				"plugin", md.Module,
+			)
+			if isActive {
+				lg.Error("failed to start new plugin version, the previous version will continue to run")
+			} else {
+				lg.Error("failed to load plugin")
+			}
+			result.Failed = append(result.Failed, md)
+			continue
+		}
+		if isActive {
+			result.Reloaded = append(result.Reloaded, md)
+		} else {
+			result.Loaded = append(result.Loaded, md)
+		}
+	}
+	for module, ap := range active {
+		if _, ok := discovered[module]; ok || ap.digest == "" {
+			continue
+		}
+		p.drain(ctx, module, options.drainTimeout)
+		result.Unloaded = append(result.Unloaded, ap.md)
+	}
+	return result
+}
+
+// replace starts the plugin, then swaps it in for the active plugin with the
+// same module name, if any. Unload hooks for the previous version are invoked
+// immediately before load hooks for the new version, since registrations are
+// keyed by module name. The previous version is then drained and stopped.
+// If the new plugin fails to start, the active plugin is left untouched.
+func (p *PluginLoader) replace(ctx context.Context, md meta.PluginMeta, cc *plugin.ClientConfig, drainTimeout time.Duration) error {
+	started, err := p.start(md, cc)
+	if err != nil {
+		return err
+	}
+	previous := p.deactivate(md.Module)
+	p.activate(ctx, started)
+	if len(previous) > 0 {
+		ctx, ca := context.WithTimeout(ctx, drainTimeout)
+		defer ca()
+		p.stop(ctx, previous[0])
+	}
+	return nil
+}
+
+func (p *PluginLoader) drain(ctx context.Context, module string, timeout time.Duration) {
+	ctx, ca := context.WithTimeout(ctx, timeout)
+	defer ca()
+	p.Unload(ctx, module)
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash, _ := blake2b.New256(nil)
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Calls to the system service are made once when a plugin is loaded, and
+// last for the lifetime of the plugin, so they are not considered in-flight
+// requests.
+const systemServicePrefix = "/system.System/"
+
+// inflightTracker counts the unary requests in flight to a plugin, so that
+// they can be allowed to complete before the plugin is stopped. Streams are
+// not tracked, since they are typically long-lived; they are closed when the
+// plugin is stopped.
+type inflightTracker struct {
+	count atomic.Int64
+}
+
+func (t *inflightTracker) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(
+			ctx context.Context,
+			method string,
+			req, reply any,
+			cc *grpc.ClientConn,
+			invoker grpc.UnaryInvoker,
+			opts ...grpc.CallOption,
+		) error {
+			if !strings.HasPrefix(method, systemServicePrefix) {
+				t.count.Inc()
+				defer t.count.Dec()
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+	}
+}
+
+// wait blocks until there are no requests in flight, or ctx is done.
+func (t *inflightTracker) wait(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for t.count.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
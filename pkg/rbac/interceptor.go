@@ -0,0 +1,328 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+)
+
+// Permission describes the access required to call an API method.
+type Permission struct {
+	Verb       string
+	Resource   string
+	Capability string
+	// The clusters the request applies to. The subject must be allowed to
+	// access all of them.
+	Clusters []*corev1.Reference
+	// If true, a request which does not name any clusters is allowed if the
+	// subject has access to any cluster. This must only be set for methods
+	// which do not return data belonging to specific clusters, or whose
+	// responses are filtered (see ClusterFilterer). Otherwise, such requests
+	// require access to all clusters.
+	AnyCluster bool
+	// If true, subjects whose access to metrics in any of the clusters the
+	// request applies to is restricted by label matchers are denied. This
+	// must be set for methods which read metrics, and whose responses cannot
+	// be filtered using the matchers. If the request does not name any
+	// clusters, the subject must not be restricted in any cluster.
+	DenyLabelRestricted bool
+}
+
+// PermissionResolver returns the permission required to call the given
+// method with the given request. If it returns false, the method can be
+// called by any authenticated subject. The request may be nil if it is not
+// yet known.
+type PermissionResolver func(fullMethod string, req any) (Permission, bool)
+
+// Authorizer enforces role-based access control for gRPC servers. It must be
+// installed after an auth middleware which stores the authenticated user ID
+// in the request context.
+type Authorizer struct {
+	AuthorizerOptions
+	provider Provider
+	resolve  PermissionResolver
+}
+
+type AuthorizerOptions struct {
+	adminSubjects map[string]struct{}
+}
+
+type AuthorizerOption func(*AuthorizerOptions)
+
+func (o *AuthorizerOptions) apply(opts ...AuthorizerOption) {
+	for _, op := range opts {
+		op(o)
+	}
+}
+
+// WithAdminSubjects sets a list of subjects which are allowed to call any
+// method, regardless of their role bindings.
+func WithAdminSubjects(subjects ...string) AuthorizerOption {
+	return func(o *AuthorizerOptions) {
+		for _, s := range subjects {
+			o.adminSubjects[s] = struct{}{}
+		}
+	}
+}
+
+func NewAuthorizer(provider Provider, resolve PermissionResolver, opts ...AuthorizerOption) *Authorizer {
+	options := AuthorizerOptions{
+		adminSubjects: map[string]struct{}{},
+	}
+	options.apply(opts...)
+	return &Authorizer{
+		AuthorizerOptions: options,
+		provider:          provider,
+		resolve:           resolve,
+	}
+}
+
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		allowed, err := a.authorize(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if list, ok := resp.(*corev1.ClusterList); ok {
+			return allowed.filterClusterList(list), nil
+		}
+		if f, ok := resp.(ClusterFilterer); ok && !allowed.all {
+			f.FilterClusters(allowed.contains)
+		}
+		return resp, nil
+	}
+}
+
+// StreamServerInterceptor returns an interceptor which checks permissions
+// when the first request message is received (or before the first response
+// message is sent, if the client does not send any messages). Response
+// messages referring to clusters the subject does not have access to are
+// dropped.
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &authorizedServerStream{
+			ServerStream: ss,
+			authorizer:   a,
+			method:       info.FullMethod,
+		})
+	}
+}
+
+func (a *Authorizer) authorize(ctx context.Context, method string, req any) (allowedClusters, error) {
+	perm, ok := a.resolve(method, req)
+	if !ok {
+		if _, ok := AuthorizedUserIDFromContext(ctx); !ok {
+			return allowedClusters{}, status.Error(codes.Unauthenticated, "unauthenticated")
+		}
+		return allowedClusters{all: true}, nil
+	}
+	allowed, err := a.check(ctx, perm)
+	if err != nil {
+		return allowedClusters{}, err
+	}
+	switch {
+	case len(perm.Clusters) > 0:
+		for _, cluster := range perm.Clusters {
+			if !allowed.contains(cluster.GetId()) {
+				return allowedClusters{}, permissionDenied(perm, cluster)
+			}
+		}
+	case perm.AnyCluster:
+		if !allowed.any() {
+			return allowedClusters{}, permissionDenied(perm, nil)
+		}
+	default:
+		if !allowed.all {
+			return allowedClusters{}, permissionDenied(perm, nil)
+		}
+	}
+	if perm.DenyLabelRestricted {
+		if err := a.checkLabelRestrictions(ctx, perm); err != nil {
+			return allowedClusters{}, err
+		}
+	}
+	return allowed, nil
+}
+
+// checkLabelRestrictions returns an error if the subject's access to metrics
+// in the clusters named by the permission is restricted by label matchers.
+func (a *Authorizer) checkLabelRestrictions(ctx context.Context, perm Permission) error {
+	lp, ok := a.provider.(LabelMatcherProvider)
+	if !ok {
+		return nil
+	}
+	subject, _ := AuthorizedUserIDFromContext(ctx)
+	if _, ok := a.adminSubjects[subject]; ok {
+		return nil
+	}
+	matchers, err := lp.MetricsLabelMatchers(ctx, &corev1.SubjectAccessRequest{
+		Subject: subject,
+		Groups:  AuthorizedGroupsFromContext(ctx),
+	})
+	if err != nil {
+		if errors.Is(err, ErrUnsupportedMatcherUnion) {
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+		return status.Errorf(codes.Internal, "failed to check permissions: %v", err)
+	}
+	if len(perm.Clusters) == 0 && len(matchers) > 0 {
+		return status.Error(codes.PermissionDenied, "not allowed for subjects with restricted metrics access")
+	}
+	for _, cluster := range perm.Clusters {
+		if _, ok := matchers[cluster.GetId()]; ok {
+			return status.Errorf(codes.PermissionDenied, "not allowed for subjects with restricted metrics access in cluster %q", cluster.GetId())
+		}
+	}
+	return nil
+}
+
+func (a *Authorizer) check(ctx context.Context, perm Permission) (allowedClusters, error) {
+	subject, ok := AuthorizedUserIDFromContext(ctx)
+	if !ok {
+		return allowedClusters{}, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+	if _, ok := a.adminSubjects[subject]; ok {
+		return allowedClusters{all: true}, nil
+	}
+	var cluster *corev1.Reference
+	if len(perm.Clusters) == 1 {
+		cluster = perm.Clusters[0]
+	}
+	refs, err := a.provider.SubjectAccess(ctx, &corev1.SubjectAccessRequest{
+		Subject:    subject,
+		Verb:       perm.Verb,
+		Resource:   perm.Resource,
+		Capability: perm.Capability,
+		Cluster:    cluster,
+		Groups:     AuthorizedGroupsFromContext(ctx),
+	})
+	if err != nil {
+		return allowedClusters{}, status.Errorf(codes.Internal, "failed to check permissions: %v", err)
+	}
+	allowed := allowedClusters{
+		ids: make(map[string]struct{}, len(refs.GetItems())),
+	}
+	for _, ref := range refs.GetItems() {
+		if ref.GetId() == corev1.Wildcard {
+			allowed.all = true
+		}
+		allowed.ids[ref.GetId()] = struct{}{}
+	}
+	return allowed, nil
+}
+
+func permissionDenied(perm Permission, cluster *corev1.Reference) error {
+	if cluster != nil {
+		return status.Errorf(codes.PermissionDenied, "not allowed to %s %s in cluster %q", perm.Verb, perm.Resource, cluster.GetId())
+	}
+	return status.Errorf(codes.PermissionDenied, "not allowed to %s %s", perm.Verb, perm.Resource)
+}
+
+// ClusterFilterer can be implemented by response messages which contain
+// items referring to several clusters. Items referring to clusters the
+// subject does not have access to are removed before the response is sent.
+type ClusterFilterer interface {
+	FilterClusters(allowed func(id string) bool)
+}
+
+type allowedClusters struct {
+	all bool
+	ids map[string]struct{}
+}
+
+func (a allowedClusters) any() bool {
+	return a.all || len(a.ids) > 0
+}
+
+func (a allowedClusters) contains(id string) bool {
+	if a.all {
+		return true
+	}
+	_, ok := a.ids[id]
+	return ok
+}
+
+func (a allowedClusters) filterClusterList(list *corev1.ClusterList) *corev1.ClusterList {
+	if a.all {
+		return list
+	}
+	filtered := &corev1.ClusterList{}
+	for _, c := range list.Items {
+		if a.contains(c.GetId()) {
+			filtered.Items = append(filtered.Items, c)
+		}
+	}
+	return filtered
+}
+
+// allowsMessage returns false if msg refers to a cluster which is not
+// allowed. Messages which do not contain a "cluster" field are allowed.
+func (a allowedClusters) allowsMessage(msg any) bool {
+	if a.all {
+		return true
+	}
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return true
+	}
+	rm := m.ProtoReflect()
+	fd := rm.Descriptor().Fields().ByName("cluster")
+	if fd == nil || fd.Message() == nil || !rm.Has(fd) {
+		return true
+	}
+	cluster := rm.Get(fd).Message()
+	idField := cluster.Descriptor().Fields().ByName("id")
+	if idField == nil || idField.Kind() != protoreflect.StringKind {
+		return true
+	}
+	return a.contains(cluster.Get(idField).String())
+}
+
+type authorizedServerStream struct {
+	grpc.ServerStream
+	authorizer *Authorizer
+	method     string
+
+	once    sync.Once
+	allowed allowedClusters
+	err     error
+}
+
+func (s *authorizedServerStream) authorize(req any) error {
+	s.once.Do(func() {
+		s.allowed, s.err = s.authorizer.authorize(s.Context(), s.method, req)
+	})
+	return s.err
+}
+
+func (s *authorizedServerStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.authorize(m)
+}
+
+func (s *authorizedServerStream) SendMsg(m any) error {
+	if err := s.authorize(nil); err != nil {
+		return err
+	}
+	if list, ok := m.(*corev1.ClusterList); ok {
+		m = s.allowed.filterClusterList(list)
+	}
+	if !s.allowed.allowsMessage(m) {
+		return nil
+	}
+	return s.ServerStream.SendMsg(m)
+}
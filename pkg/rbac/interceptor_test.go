@@ -0,0 +1,294 @@
+package rbac_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/rbac"
+)
+
+// testAccessProvider allows each subject to perform the listed
+// "verb/resource" actions on the listed clusters.
+type testAccessProvider map[string]struct {
+	actions  []string
+	clusters []string
+}
+
+func (p testAccessProvider) SubjectAccess(_ context.Context, req *corev1.SubjectAccessRequest) (*corev1.ReferenceList, error) {
+	access, ok := p[req.Subject]
+	if !ok {
+		return &corev1.ReferenceList{}, nil
+	}
+	allowed := false
+	for _, action := range access.actions {
+		if action == req.Verb+"/"+req.Resource {
+			allowed = true
+		}
+	}
+	list := &corev1.ReferenceList{}
+	if !allowed {
+		return list, nil
+	}
+	for _, id := range access.clusters {
+		if req.Cluster == nil || req.Cluster.Id == id || id == corev1.Wildcard {
+			list.Items = append(list.Items, &corev1.Reference{Id: id})
+		}
+	}
+	return list, nil
+}
+
+// testLabelMatcherProvider restricts the listed subjects' access to metrics
+// in the listed clusters.
+type testLabelMatcherProvider struct {
+	testAccessProvider
+	matchers map[string]rbac.ClusterLabelMatchers
+}
+
+func (p testLabelMatcherProvider) MetricsLabelMatchers(_ context.Context, req *corev1.SubjectAccessRequest) (rbac.ClusterLabelMatchers, error) {
+	return p.matchers[req.Subject], nil
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	recv []any
+	sent []any
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testServerStream) RecvMsg(m any) error {
+	proto.Merge(m.(proto.Message), s.recv[0].(proto.Message))
+	s.recv = s.recv[1:]
+	return nil
+}
+
+func (s *testServerStream) SendMsg(m any) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+var _ = Describe("Authorizer", Label("unit"), func() {
+	provider := testAccessProvider{
+		"user1": {
+			actions:  []string{"list/clusters", "get/clusters"},
+			clusters: []string{"c1", "c2"},
+		},
+		"user2": {
+			actions:  []string{"list/clusters", "create/tokens"},
+			clusters: []string{"*"},
+		},
+	}
+	resolve := func(fullMethod string, req any) (rbac.Permission, bool) {
+		switch fullMethod {
+		case "/test/ListClusters", "/test/StaleClusterReport":
+			return rbac.Permission{Verb: "list", Resource: "clusters", AnyCluster: true}, true
+		case "/test/GetCluster", "/test/GetClusters":
+			perm := rbac.Permission{Verb: "get", Resource: "clusters"}
+			switch req := req.(type) {
+			case *corev1.Reference:
+				perm.Clusters = []*corev1.Reference{req}
+			case *corev1.ReferenceList:
+				perm.Clusters = req.Items
+			}
+			return perm, true
+		case "/test/CreateToken":
+			return rbac.Permission{Verb: "create", Resource: "tokens"}, true
+		default:
+			return rbac.Permission{}, false
+		}
+	}
+	clusterList := func() *corev1.ClusterList {
+		return &corev1.ClusterList{
+			Items: []*corev1.Cluster{{Id: "c1"}, {Id: "c2"}, {Id: "c3"}},
+		}
+	}
+	ctxFor := func(subject string) context.Context {
+		return rbac.ContextWithAuthorizedUserID(context.Background(), subject)
+	}
+
+	var authorizer *rbac.Authorizer
+	BeforeEach(func() {
+		authorizer = rbac.NewAuthorizer(provider, resolve, rbac.WithAdminSubjects("admin"))
+	})
+
+	Context("unary calls", func() {
+		call := func(ctx context.Context, method string, req any) (any, error) {
+			return authorizer.UnaryServerInterceptor()(ctx, req, &grpc.UnaryServerInfo{
+				FullMethod: method,
+			}, func(ctx context.Context, req any) (any, error) {
+				switch method {
+				case "/test/ListClusters":
+					return clusterList(), nil
+				case "/test/StaleClusterReport":
+					return &managementv1.StaleClusterReport{
+						Items: []*managementv1.StaleCluster{
+							{Cluster: &corev1.Reference{Id: "c1"}},
+							{Cluster: &corev1.Reference{Id: "c3"}},
+						},
+					}, nil
+				}
+				return req, nil
+			})
+		}
+		It("should reject unauthenticated requests", func() {
+			_, err := call(context.Background(), "/test/ListClusters", nil)
+			Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+			_, err = call(context.Background(), "/test/Other", nil)
+			Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+		})
+		It("should allow methods which do not require a permission", func() {
+			_, err := call(ctxFor("user3"), "/test/Other", nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should deny subjects without a matching rule", func() {
+			_, err := call(ctxFor("user3"), "/test/ListClusters", nil)
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			_, err = call(ctxFor("user1"), "/test/CreateToken", nil)
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+		It("should allow subjects with a matching rule", func() {
+			_, err := call(ctxFor("user2"), "/test/CreateToken", nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should check access to specific clusters", func() {
+			_, err := call(ctxFor("user1"), "/test/GetCluster", &corev1.Reference{Id: "c1"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = call(ctxFor("user1"), "/test/GetCluster", &corev1.Reference{Id: "c3"})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+		It("should require access to every cluster a request names", func() {
+			_, err := call(ctxFor("user1"), "/test/GetClusters", &corev1.ReferenceList{
+				Items: []*corev1.Reference{{Id: "c1"}, {Id: "c2"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = call(ctxFor("user1"), "/test/GetClusters", &corev1.ReferenceList{
+				Items: []*corev1.Reference{{Id: "c1"}, {Id: "c3"}},
+			})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+		It("should require access to all clusters if a request names none", func() {
+			_, err := call(ctxFor("user1"), "/test/GetClusters", &corev1.ReferenceList{})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			_, err = call(ctxFor("admin"), "/test/GetClusters", &corev1.ReferenceList{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should filter cluster lists", func() {
+			resp, err := call(ctxFor("user1"), "/test/ListClusters", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.(*corev1.ClusterList).Items).To(HaveLen(2))
+
+			resp, err = call(ctxFor("user2"), "/test/ListClusters", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.(*corev1.ClusterList).Items).To(HaveLen(3))
+		})
+		It("should filter responses which implement ClusterFilterer", func() {
+			resp, err := call(ctxFor("user1"), "/test/StaleClusterReport", nil)
+			Expect(err).NotTo(HaveOccurred())
+			items := resp.(*managementv1.StaleClusterReport).Items
+			Expect(items).To(HaveLen(1))
+			Expect(items[0].Cluster.Id).To(Equal("c1"))
+		})
+		It("should allow admin subjects to call any method", func() {
+			_, err := call(ctxFor("admin"), "/test/CreateToken", nil)
+			Expect(err).NotTo(HaveOccurred())
+			resp, err := call(ctxFor("admin"), "/test/ListClusters", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.(*corev1.ClusterList).Items).To(HaveLen(3))
+		})
+	})
+
+	Context("label restrictions", func() {
+		BeforeEach(func() {
+			authorizer = rbac.NewAuthorizer(testLabelMatcherProvider{
+				testAccessProvider: provider,
+				matchers: map[string]rbac.ClusterLabelMatchers{
+					"user1": {"c2": {labels.MustNewMatcher(labels.MatchEqual, "namespace", "ns1")}},
+					"user2": {"c1": {labels.MustNewMatcher(labels.MatchEqual, "namespace", "ns1")}},
+				},
+			}, func(fullMethod string, req any) (rbac.Permission, bool) {
+				perm, ok := resolve(fullMethod, req)
+				perm.DenyLabelRestricted = true
+				return perm, ok
+			}, rbac.WithAdminSubjects("admin"))
+		})
+		call := func(ctx context.Context, method string, req any) error {
+			_, err := authorizer.UnaryServerInterceptor()(ctx, req, &grpc.UnaryServerInfo{
+				FullMethod: method,
+			}, func(ctx context.Context, req any) (any, error) {
+				return req, nil
+			})
+			return err
+		}
+		It("should deny subjects restricted in a cluster the request names", func() {
+			Expect(call(ctxFor("user1"), "/test/GetCluster", &corev1.Reference{Id: "c1"})).To(Succeed())
+			err := call(ctxFor("user1"), "/test/GetCluster", &corev1.Reference{Id: "c2"})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			err = call(ctxFor("user1"), "/test/GetClusters", &corev1.ReferenceList{
+				Items: []*corev1.Reference{{Id: "c1"}, {Id: "c2"}},
+			})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+		It("should deny subjects restricted in any cluster if the request names none", func() {
+			err := call(ctxFor("user2"), "/test/CreateToken", nil)
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(call(ctxFor("admin"), "/test/CreateToken", nil)).To(Succeed())
+		})
+	})
+
+	Context("streaming calls", func() {
+		It("should authorize using the first received message", func() {
+			ss := &testServerStream{
+				ctx:  ctxFor("user1"),
+				recv: []any{&corev1.Reference{Id: "c3"}},
+			}
+			err := authorizer.StreamServerInterceptor()(nil, ss, &grpc.StreamServerInfo{
+				FullMethod: "/test/GetCluster",
+			}, func(_ any, stream grpc.ServerStream) error {
+				return stream.RecvMsg(&corev1.Reference{})
+			})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+		It("should authorize before sending if no message was received", func() {
+			ss := &testServerStream{ctx: ctxFor("user3")}
+			err := authorizer.StreamServerInterceptor()(nil, ss, &grpc.StreamServerInfo{
+				FullMethod: "/test/ListClusters",
+			}, func(_ any, stream grpc.ServerStream) error {
+				return stream.SendMsg(clusterList())
+			})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(ss.sent).To(BeEmpty())
+		})
+		It("should drop messages for clusters the subject cannot access", func() {
+			ss := &testServerStream{ctx: ctxFor("user1")}
+			err := authorizer.StreamServerInterceptor()(nil, ss, &grpc.StreamServerInfo{
+				FullMethod: "/test/ListClusters",
+			}, func(_ any, stream grpc.ServerStream) error {
+				for _, id := range []string{"c1", "c3", "c2"} {
+					if err := stream.SendMsg(&corev1.ClusterHealthStatus{
+						Cluster: &corev1.Reference{Id: id},
+					}); err != nil {
+						return err
+					}
+				}
+				return stream.SendMsg(clusterList())
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ss.sent).To(HaveLen(3))
+			Expect(ss.sent[0].(*corev1.ClusterHealthStatus).Cluster.Id).To(Equal("c1"))
+			Expect(ss.sent[1].(*corev1.ClusterHealthStatus).Cluster.Id).To(Equal("c2"))
+			Expect(ss.sent[2].(*corev1.ClusterList).Items).To(HaveLen(2))
+		})
+	})
+})
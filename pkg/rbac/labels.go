@@ -0,0 +1,113 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/model/labels"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+)
+
+const (
+	AuthorizedLabelMatchersKey = "authorized_label_matchers"
+)
+
+// ClusterLabelMatchers maps cluster IDs to the label matchers which every
+// series read from that cluster must match. Clusters which are not in the map
+// can be read without restrictions.
+type ClusterLabelMatchers map[string][]*labels.Matcher
+
+// LabelMatcherProvider can be implemented by a Provider which supports
+// restricting access to metrics within a cluster. If the provider passed to
+// NewMiddleware implements this interface, the middleware will also store the
+// label matchers for the authorized clusters in the request context.
+type LabelMatcherProvider interface {
+	MetricsLabelMatchers(context.Context, *corev1.SubjectAccessRequest) (ClusterLabelMatchers, error)
+}
+
+// AuthorizedLabelMatchers returns the label matchers which apply to the
+// authorized clusters, as set by the rbac middleware. If the subject can read
+// all series in all authorized clusters, the result is empty.
+func AuthorizedLabelMatchers(c *gin.Context) ClusterLabelMatchers {
+	value, ok := c.Get(AuthorizedLabelMatchersKey)
+	if !ok {
+		return nil
+	}
+	return value.(ClusterLabelMatchers)
+}
+
+// ErrUnsupportedMatcherUnion is returned when a subject's roles grant access
+// to different sets of series in the same cluster, and the union of the sets
+// cannot be expressed as a single set of matchers.
+var ErrUnsupportedMatcherUnion = errors.New("metrics label matchers granted by different roles cannot be combined")
+
+// UnionLabelMatchers combines several sets of matchers, each granted by a
+// different role, into a single set of matchers which can be added to a
+// selector. A selector can only express the union of the sets if they are all
+// identical, or if each set consists of a single equality or regex matcher on
+// the same label, in which case the matchers are merged into one regex.
+// Otherwise, ErrUnsupportedMatcherUnion is returned.
+func UnionLabelMatchers(sets [][]*labels.Matcher) ([]*labels.Matcher, error) {
+	unique := map[string][]*labels.Matcher{}
+	for _, set := range sets {
+		unique[MatchersString(set)] = set
+	}
+	keys := make([]string, 0, len(unique))
+	for k := range unique {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) == 1 {
+		return unique[keys[0]], nil
+	}
+
+	if merged, ok := mergeRegexMatchers(keys, unique); ok {
+		return []*labels.Matcher{merged}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedMatcherUnion, strings.Join(keys, ", "))
+}
+
+func mergeRegexMatchers(keys []string, sets map[string][]*labels.Matcher) (*labels.Matcher, bool) {
+	var name string
+	alternatives := make([]string, 0, len(keys))
+	for _, k := range keys {
+		set := sets[k]
+		if len(set) != 1 {
+			return nil, false
+		}
+		m := set[0]
+		if name != "" && m.Name != name {
+			return nil, false
+		}
+		name = m.Name
+		switch m.Type {
+		case labels.MatchEqual:
+			alternatives = append(alternatives, regexp.QuoteMeta(m.Value))
+		case labels.MatchRegexp:
+			alternatives = append(alternatives, "(?:"+m.Value+")")
+		default:
+			return nil, false
+		}
+	}
+	merged, err := labels.NewMatcher(labels.MatchRegexp, name, strings.Join(alternatives, "|"))
+	if err != nil {
+		return nil, false
+	}
+	return merged, true
+}
+
+// MatchersString formats a set of matchers the same way as inside a PromQL
+// selector, in a stable order.
+func MatchersString(matchers []*labels.Matcher) string {
+	strs := make([]string, len(matchers))
+	for i, m := range matchers {
+		strs[i] = m.String()
+	}
+	sort.Strings(strs)
+	return "{" + strings.Join(strs, ",") + "}"
+}
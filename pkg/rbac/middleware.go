@@ -2,6 +2,7 @@ package rbac
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -25,6 +26,7 @@ func (m *middleware) Handle(c *gin.Context) {
 	}
 	clusters, err := m.provider.SubjectAccess(context.Background(), &corev1.SubjectAccessRequest{
 		Subject: userID,
+		Groups:  AuthorizedGroups(c),
 	})
 	if err != nil {
 		c.AbortWithStatus(http.StatusUnauthorized)
@@ -38,6 +40,27 @@ func (m *middleware) Handle(c *gin.Context) {
 	for i, cluster := range clusters.Items {
 		ids[i] = cluster.Id
 	}
+	if lp, ok := m.provider.(LabelMatcherProvider); ok {
+		matchers, err := lp.MetricsLabelMatchers(context.Background(), &corev1.SubjectAccessRequest{
+			Subject: userID,
+			Groups:  AuthorizedGroups(c),
+		})
+		if err != nil {
+			if errors.Is(err, ErrUnsupportedMatcherUnion) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"status":    "error",
+					"errorType": "forbidden",
+					"error":     err.Error(),
+				})
+				return
+			}
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(matchers) > 0 {
+			c.Set(AuthorizedLabelMatchersKey, matchers)
+		}
+	}
 	c.Request.Header.Set(m.codec.Key(), m.codec.Encode(ids))
 	c.Set(AuthorizedClusterIDsKey, ids)
 }
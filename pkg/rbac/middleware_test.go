@@ -12,6 +12,7 @@ import (
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/model/labels"
 	"go.uber.org/atomic"
 
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
@@ -128,4 +129,59 @@ var _ = Describe("Middleware", Label("unit"), func() {
 			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
 		}
 	})
+	It("should set label matchers if the provider supports them", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		mockProvider := mock_rbac.NewMockProvider(ctrl)
+		mockProvider.EXPECT().
+			SubjectAccess(gomock.Any(), gomock.Any()).
+			Return(&corev1.ReferenceList{
+				Items: []*corev1.Reference{{Id: "tenant1"}, {Id: "tenant2"}},
+			}, nil).
+			AnyTimes()
+		provider := &labelMatcherProvider{
+			Provider: mockProvider,
+			matchers: rbac.ClusterLabelMatchers{
+				"tenant1": {labels.MustNewMatcher(labels.MatchEqual, "namespace", "a")},
+			},
+		}
+
+		app := gin.New()
+		app.Use(func(c *gin.Context) {
+			c.Set(rbac.UserIDKey, "user1")
+		})
+		app.Use(rbac.NewMiddleware(provider, util.NewDelimiterCodec("foo", "|")))
+		var matchers rbac.ClusterLabelMatchers
+		app.GET("/", func(c *gin.Context) {
+			matchers = rbac.AuthorizedLabelMatchers(c)
+			c.Status(http.StatusOK)
+		})
+
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(matchers).To(Equal(provider.matchers))
+
+		By("failing closed if the matchers cannot be looked up")
+		provider.err = errors.New("error")
+		recorder = httptest.NewRecorder()
+		app.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+
+		By("rejecting subjects whose roles cannot be combined")
+		provider.err = fmt.Errorf("cluster %q: %w", "tenant1", rbac.ErrUnsupportedMatcherUnion)
+		recorder = httptest.NewRecorder()
+		app.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		Expect(recorder.Body.String()).To(ContainSubstring("cannot be combined"))
+	})
 })
+
+type labelMatcherProvider struct {
+	rbac.Provider
+	matchers rbac.ClusterLabelMatchers
+	err      error
+}
+
+func (p *labelMatcherProvider) MetricsLabelMatchers(context.Context, *corev1.SubjectAccessRequest) (rbac.ClusterLabelMatchers, error) {
+	return p.matchers, p.err
+}
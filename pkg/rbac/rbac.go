@@ -9,6 +9,15 @@ import (
 
 const (
 	UserIDKey = "rbac_user_id"
+	GroupsKey = "rbac_groups"
+)
+
+type userIDContextKeyType struct{}
+type groupsContextKeyType struct{}
+
+var (
+	userIDContextKey userIDContextKeyType
+	groupsContextKey groupsContextKeyType
 )
 
 type Provider interface {
@@ -22,3 +31,38 @@ func AuthorizedUserID(c *gin.Context) (string, bool) {
 	}
 	return userId.(string), true
 }
+
+// ContextWithAuthorizedUserID returns a copy of ctx containing the given
+// user ID. This is the grpc equivalent of setting UserIDKey in a gin context.
+func ContextWithAuthorizedUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDContextKey, userID)
+}
+
+// AuthorizedUserIDFromContext returns the user ID previously stored in ctx
+// by an auth middleware, if any.
+func AuthorizedUserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userIDContextKey).(string)
+	return userID, ok
+}
+
+func AuthorizedGroups(c *gin.Context) []string {
+	groups, exists := c.Get(GroupsKey)
+	if !exists {
+		return nil
+	}
+	return groups.([]string)
+}
+
+// ContextWithAuthorizedGroups returns a copy of ctx containing the groups the
+// authorized user is a member of. This is the grpc equivalent of setting
+// GroupsKey in a gin context.
+func ContextWithAuthorizedGroups(ctx context.Context, groups []string) context.Context {
+	return context.WithValue(ctx, groupsContextKey, groups)
+}
+
+// AuthorizedGroupsFromContext returns the groups previously stored in ctx by
+// an auth middleware, if any.
+func AuthorizedGroupsFromContext(ctx context.Context) []string {
+	groups, _ := ctx.Value(groupsContextKey).([]string)
+	return groups
+}
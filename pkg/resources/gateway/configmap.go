@@ -49,6 +49,8 @@ func (r *Reconciler) configMap() (resources.Resource, string, error) {
 					ClientCert: "/run/cortex/certs/client/tls.crt",
 					ClientKey:  "/run/cortex/certs/client/tls.key",
 				},
+				RemoteWriteBuffer: r.gw.Spec.RemoteWriteBuffer,
+				Import:            r.gw.Spec.MetricsImport,
 			},
 			AuthProvider: string(r.gw.Spec.Auth.Provider),
 			Certs: cfgv1beta1.CertsSpec{
@@ -70,6 +72,9 @@ func (r *Reconciler) configMap() (resources.Resource, string, error) {
 				ControllerClusterPort: r.gw.Spec.Alerting.ClusterPort,
 				ConfigMap:             "alertmanager-config",
 			},
+			StaleClusters: r.gw.Spec.StaleClusters,
+			HA:            r.gw.Spec.HA,
+			HealthHistory: r.gw.Spec.HealthHistory,
 		},
 	}
 	gatewayConf.Spec.SetDefaults()
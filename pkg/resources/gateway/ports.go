@@ -82,6 +82,25 @@ func (r *Reconciler) internalContainerPorts() ([]corev1.ContainerPort, error) {
 			Protocol:      corev1.ProtocolTCP,
 		})
 	}
+	if r.gw.Spec.HA.Enabled {
+		addr := r.gw.Spec.HA.RelayListenAddress
+		if addr == "" {
+			addr = ":9091"
+		}
+		parts := strings.Split(addr, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid relay listen address %q", addr)
+		}
+		portNum, err := strconv.ParseInt(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relay listen address %q", addr)
+		}
+		ports = append(ports, corev1.ContainerPort{
+			Name:          "relay",
+			ContainerPort: int32(portNum),
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
 	return ports, nil
 }
 
@@ -73,55 +73,16 @@ func bucketHttpConfig(spec *storagev1.HTTPConfig) bucket_http.Config {
 	}
 }
 
-func valueOrDefault[T any](t *T) (_ T) {
-	if t == nil {
-		return
-	}
-	return *t
-}
-
-type overrideMarshaler[T kyamlv3.Marshaler] struct {
-	fn func(T) (interface{}, error)
-}
-
-func (m *overrideMarshaler[T]) MarshalYAML(v interface{}) (interface{}, error) {
-	return m.fn(v.(T))
-}
+// BucketConfig returns the Cortex bucket config for the given storage spec.
+// The filesystem backend always uses the bucket directory in the Cortex pods.
+func BucketConfig(spec *storagev1.StorageSpec) bucket.Config {
+	s3Spec := valueOrDefault(spec.GetS3())
+	gcsSpec := valueOrDefault(spec.GetGcs())
+	azureSpec := valueOrDefault(spec.GetAzure())
+	swiftSpec := valueOrDefault(spec.GetSwift())
 
-func newOverrideMarshaler[T kyamlv3.Marshaler](fn func(T) (interface{}, error)) *overrideMarshaler[T] {
-	return &overrideMarshaler[T]{
-		fn: fn,
-	}
-}
-
-func (r *Reconciler) config() (resources.Resource, error) {
-	if !r.mc.Spec.Cortex.Enabled {
-		return resources.Absent(&corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "cortex",
-				Namespace: r.mc.Namespace,
-				Labels:    cortexAppLabel,
-			},
-		}), nil
-	}
-
-	if r.mc.Spec.Cortex.Storage == nil {
-		r.logger.Warn("No cortex storage configured; using volatile EmptyDir storage. It is recommended to configure a storage backend.")
-		r.mc.Spec.Cortex.Storage = &storagev1.StorageSpec{
-			Backend: storagev1.Filesystem,
-			Filesystem: &storagev1.FilesystemStorageSpec{
-				Directory: "/data",
-			},
-		}
-	}
-
-	s3Spec := valueOrDefault(r.mc.Spec.Cortex.Storage.GetS3())
-	gcsSpec := valueOrDefault(r.mc.Spec.Cortex.Storage.GetGcs())
-	azureSpec := valueOrDefault(r.mc.Spec.Cortex.Storage.GetAzure())
-	swiftSpec := valueOrDefault(r.mc.Spec.Cortex.Storage.GetSwift())
-
-	storageConfig := bucket.Config{
-		Backend: string(r.mc.Spec.Cortex.Storage.GetBackend()),
+	return bucket.Config{
+		Backend: string(spec.GetBackend()),
 		S3: s3.Config{
 			Endpoint:   s3Spec.GetEndpoint(),
 			Region:     s3Spec.GetRegion(),
@@ -181,6 +142,51 @@ func (r *Reconciler) config() (resources.Resource, error) {
 			Directory: "/data/bucket",
 		},
 	}
+}
+
+func valueOrDefault[T any](t *T) (_ T) {
+	if t == nil {
+		return
+	}
+	return *t
+}
+
+type overrideMarshaler[T kyamlv3.Marshaler] struct {
+	fn func(T) (interface{}, error)
+}
+
+func (m *overrideMarshaler[T]) MarshalYAML(v interface{}) (interface{}, error) {
+	return m.fn(v.(T))
+}
+
+func newOverrideMarshaler[T kyamlv3.Marshaler](fn func(T) (interface{}, error)) *overrideMarshaler[T] {
+	return &overrideMarshaler[T]{
+		fn: fn,
+	}
+}
+
+func (r *Reconciler) config() (resources.Resource, error) {
+	if !r.mc.Spec.Cortex.Enabled {
+		return resources.Absent(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cortex",
+				Namespace: r.mc.Namespace,
+				Labels:    cortexAppLabel,
+			},
+		}), nil
+	}
+
+	if r.mc.Spec.Cortex.Storage == nil {
+		r.logger.Warn("No cortex storage configured; using volatile EmptyDir storage. It is recommended to configure a storage backend.")
+		r.mc.Spec.Cortex.Storage = &storagev1.StorageSpec{
+			Backend: storagev1.Filesystem,
+			Filesystem: &storagev1.FilesystemStorageSpec{
+				Directory: "/data",
+			},
+		}
+	}
+
+	storageConfig := BucketConfig(r.mc.Spec.Cortex.Storage)
 	logLevel := logging.Level{}
 	level := r.mc.Spec.Cortex.LogLevel
 	if level == "" {
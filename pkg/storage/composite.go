@@ -6,9 +6,14 @@ type CompositeBackend struct {
 	RBACStore
 	KeyringStoreBroker
 	KeyValueStoreBroker
+
+	lockManagerBroker LockManagerBroker
 }
 
-var _ Backend = (*CompositeBackend)(nil)
+var (
+	_ Backend           = (*CompositeBackend)(nil)
+	_ LockManagerBroker = (*CompositeBackend)(nil)
+)
 
 func (cb *CompositeBackend) Use(store any) {
 	if ts, ok := store.(TokenStore); ok {
@@ -26,6 +31,18 @@ func (cb *CompositeBackend) Use(store any) {
 	if kv, ok := store.(KeyValueStoreBroker); ok {
 		cb.KeyValueStoreBroker = kv
 	}
+	if lm, ok := store.(LockManagerBroker); ok {
+		cb.lockManagerBroker = lm
+	}
+}
+
+// LockManager implements LockManagerBroker. Returns nil if none of the stores
+// in use support distributed locks.
+func (cb CompositeBackend) LockManager(namespace string) LockManager {
+	if cb.lockManagerBroker == nil {
+		return nil
+	}
+	return cb.lockManagerBroker.LockManager(namespace)
 }
 
 func (cb *CompositeBackend) IsValid() bool {
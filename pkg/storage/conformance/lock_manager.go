@@ -0,0 +1,90 @@
+package conformance
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/util/future"
+)
+
+func LockManagerTestSuite[T storage.LockManagerBroker](
+	tsF future.Future[T],
+) func() {
+	return func() {
+		var lm storage.LockManager
+		BeforeAll(func() {
+			lm = tsF.Get().LockManager("test")
+		})
+		It("should acquire and release locks", func() {
+			lock := lm.Locker("foo")
+			Expect(lock.Lock(context.Background())).To(Succeed())
+			Expect(lock.Unlock()).To(Succeed())
+		})
+		It("should return an error when unlocking a lock that is not held", func() {
+			lock := lm.Locker("foo")
+			Expect(lock.Unlock()).To(MatchError(storage.ErrLockNotHeld))
+		})
+		It("should block while the lock is held by another locker", func() {
+			l1 := lm.Locker("foo")
+			l2 := lm.Locker("foo")
+			Expect(l1.Lock(context.Background())).To(Succeed())
+
+			ctx, ca := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer ca()
+			Expect(l2.Lock(ctx)).To(MatchError(context.DeadlineExceeded))
+
+			acquired := make(chan error, 1)
+			go func() {
+				acquired <- l2.Lock(context.Background())
+			}()
+			Consistently(acquired, 500*time.Millisecond).ShouldNot(Receive())
+			Expect(l1.Unlock()).To(Succeed())
+			Eventually(acquired, 5*time.Second).Should(Receive(BeNil()))
+			Expect(l2.Unlock()).To(Succeed())
+		})
+		It("should not block lockers for other keys", func() {
+			l1 := lm.Locker("foo")
+			l2 := lm.Locker("bar")
+			Expect(l1.Lock(context.Background())).To(Succeed())
+			ctx, ca := context.WithTimeout(context.Background(), 5*time.Second)
+			defer ca()
+			Expect(l2.Lock(ctx)).To(Succeed())
+			Expect(l1.Unlock()).To(Succeed())
+			Expect(l2.Unlock()).To(Succeed())
+		})
+		It("should provide mutual exclusion between many lockers", func() {
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			counter := 0
+			held := 0
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					lock := lm.Locker("counter")
+					Expect(lock.Lock(context.Background())).To(Succeed())
+					mu.Lock()
+					held++
+					Expect(held).To(Equal(1))
+					mu.Unlock()
+
+					time.Sleep(10 * time.Millisecond)
+
+					mu.Lock()
+					held--
+					counter++
+					mu.Unlock()
+					Expect(lock.Unlock()).To(Succeed())
+				}()
+			}
+			wg.Wait()
+			Expect(counter).To(Equal(10))
+		})
+	}
+}
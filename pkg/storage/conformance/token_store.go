@@ -12,6 +12,7 @@ import (
 	"github.com/rancher/opni/pkg/storage"
 	"github.com/rancher/opni/pkg/test/testutil"
 	"github.com/rancher/opni/pkg/util/future"
+	"github.com/rancher/opni/pkg/validation"
 )
 
 func TokenStoreTestSuite[T storage.TokenStore](
@@ -103,6 +104,15 @@ func TokenStoreTestSuite[T storage.TokenStore](
 			list, err = ts.ListTokens(context.Background())
 			Expect(err).NotTo(HaveOccurred())
 			Expect(list).To(HaveLen(3))
+
+			_, err = ts.CreateToken(context.Background(), time.Hour, storage.WithAllowedSourceCIDRs(
+				[]string{"10.0.0.0/8", "not-a-cidr"},
+			))
+			Expect(err).To(MatchError(validation.ErrInvalidValue))
+
+			list, err = ts.ListTokens(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list).To(HaveLen(3))
 		})
 		When("deleting a token", func() {
 			When("the token exists", func() {
@@ -19,15 +19,14 @@ import (
 func (c *CRDStore) CreateToken(ctx context.Context, ttl time.Duration, opts ...storage.TokenCreateOption) (*corev1.BootstrapToken, error) {
 	options := storage.NewTokenCreateOptions()
 	options.Apply(opts...)
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
 
 	token := tokens.NewToken().ToBootstrapToken()
-	token.Metadata = &corev1.BootstrapTokenMetadata{
-		LeaseID:      -1,
-		Ttl:          int64(ttl.Seconds()),
-		UsageCount:   0,
-		Labels:       options.Labels,
-		Capabilities: options.Capabilities,
-	}
+	token.Metadata = options.Metadata()
+	token.Metadata.LeaseID = -1
+	token.Metadata.Ttl = int64(ttl.Seconds())
 	err := c.client.Create(ctx, &corev1beta1.BootstrapToken{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      token.TokenID,
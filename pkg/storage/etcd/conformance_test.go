@@ -9,6 +9,9 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/machinery"
+	"github.com/rancher/opni/pkg/storage"
 	"github.com/rancher/opni/pkg/storage/conformance"
 	"github.com/rancher/opni/pkg/storage/etcd"
 	"github.com/rancher/opni/pkg/test"
@@ -22,6 +25,8 @@ func TestEtcd(t *testing.T) {
 
 var store = future.New[*etcd.EtcdStore]()
 
+var storageSpec = future.New[*v1beta1.StorageSpec]()
+
 var _ = BeforeSuite(func() {
 	env := test.Environment{
 		TestBin: "../../../testbin/bin",
@@ -39,6 +44,11 @@ var _ = BeforeSuite(func() {
 		etcd.WithPrefix("test"),
 	))
 
+	storageSpec.Set(&v1beta1.StorageSpec{
+		Type: v1beta1.StorageTypeEtcd,
+		Etcd: env.EtcdConfig(),
+	})
+
 	DeferCleanup(env.Stop)
 })
 
@@ -47,3 +57,14 @@ var _ = Describe("Cluster Store", Ordered, Label("integration", "slow"), conform
 var _ = Describe("RBAC Store", Ordered, Label("integration", "slow"), conformance.RBACStoreTestSuite(store))
 var _ = Describe("Keyring Store", Ordered, Label("integration", "slow"), conformance.KeyringStoreTestSuite(store))
 var _ = Describe("KV Store", Ordered, Label("integration", "slow"), conformance.KeyValueStoreTestSuite(store))
+var _ = Describe("Lock Manager", Ordered, Label("integration", "slow"), conformance.LockManagerTestSuite(store))
+
+var _ = Describe("Configured Backend", Label("integration", "slow"), func() {
+	It("should use the store's distributed lock manager", func() {
+		backend, err := machinery.ConfigureStorageBackend(context.Background(), storageSpec.Get())
+		Expect(err).NotTo(HaveOccurred())
+		lm, err := storage.DistributedLockManagerFor(backend, "test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lm).To(BeAssignableToTypeOf(store.Get().LockManager("test")))
+	})
+})
@@ -0,0 +1,78 @@
+//go:build !noetcd
+
+package etcd
+
+import (
+	"context"
+	"path"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/rancher/opni/pkg/storage"
+)
+
+// Locks are held for the lifetime of a lease, which is kept alive by the
+// client. If the client is disconnected, its locks are released after the
+// lease expires.
+const lockLeaseTTLSeconds = 15
+
+func (e *EtcdStore) LockManager(namespace string) storage.LockManager {
+	return &etcdLockManager{
+		client: e.Client,
+		prefix: path.Join(e.Prefix, "locks", namespace),
+	}
+}
+
+type etcdLockManager struct {
+	client *clientv3.Client
+	prefix string
+}
+
+func (m *etcdLockManager) Locker(key string) storage.Lock {
+	return &etcdLock{
+		client: m.client,
+		key:    path.Join(m.prefix, key),
+	}
+}
+
+type etcdLock struct {
+	client *clientv3.Client
+	key    string
+
+	mu      sync.Mutex
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+}
+
+func (l *etcdLock) Lock(ctx context.Context) error {
+	session, err := concurrency.NewSession(l.client, concurrency.WithTTL(lockLeaseTTLSeconds))
+	if err != nil {
+		return err
+	}
+	mutex := concurrency.NewMutex(session, l.key)
+	if err := mutex.Lock(ctx); err != nil {
+		session.Close()
+		return err
+	}
+	l.mu.Lock()
+	l.session = session
+	l.mutex = mutex
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *etcdLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.session == nil {
+		return storage.ErrLockNotHeld
+	}
+	defer func() {
+		l.session.Close()
+		l.session = nil
+		l.mutex = nil
+	}()
+	return l.mutex.Unlock(l.client.Ctx())
+}
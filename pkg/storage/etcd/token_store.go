@@ -21,18 +21,17 @@ import (
 func (e *EtcdStore) CreateToken(ctx context.Context, ttl time.Duration, opts ...storage.TokenCreateOption) (*corev1.BootstrapToken, error) {
 	options := storage.NewTokenCreateOptions()
 	options.Apply(opts...)
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
 
 	lease, err := e.Client.Grant(ctx, int64(ttl.Seconds()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create lease: %w", err)
 	}
 	token := tokens.NewToken().ToBootstrapToken()
-	token.Metadata = &corev1.BootstrapTokenMetadata{
-		LeaseID:      int64(lease.ID),
-		UsageCount:   0,
-		Labels:       options.Labels,
-		Capabilities: options.Capabilities,
-	}
+	token.Metadata = options.Metadata()
+	token.Metadata.LeaseID = int64(lease.ID)
 	data, err := protojson.Marshal(token)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal token: %w", err)
@@ -7,6 +7,9 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/machinery"
+	"github.com/rancher/opni/pkg/storage"
 	"github.com/rancher/opni/pkg/storage/conformance"
 	"github.com/rancher/opni/pkg/storage/jetstream"
 	"github.com/rancher/opni/pkg/test"
@@ -20,6 +23,8 @@ func TestJetStream(t *testing.T) {
 
 var store = future.New[*jetstream.JetStreamStore]()
 
+var storageSpec = future.New[*v1beta1.StorageSpec]()
+
 var _ = BeforeSuite(func() {
 	env := test.Environment{
 		TestBin: "../../../testbin/bin",
@@ -37,6 +42,11 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	store.Set(s)
 
+	storageSpec.Set(&v1beta1.StorageSpec{
+		Type:      v1beta1.StorageTypeJetStream,
+		JetStream: env.JetStreamConfig(),
+	})
+
 	DeferCleanup(env.Stop)
 })
 
@@ -45,3 +55,14 @@ var _ = Describe("Cluster Store", Ordered, Label("integration", "slow"), conform
 var _ = Describe("RBAC Store", Ordered, Label("integration", "slow"), conformance.RBACStoreTestSuite(store))
 var _ = Describe("Keyring Store", Ordered, Label("integration", "slow"), conformance.KeyringStoreTestSuite(store))
 var _ = Describe("KV Store", Ordered, Label("integration", "slow"), conformance.KeyValueStoreTestSuite(store))
+var _ = Describe("Lock Manager", Ordered, Label("integration", "slow"), conformance.LockManagerTestSuite(store))
+
+var _ = Describe("Configured Backend", Label("integration", "slow"), func() {
+	It("should use the store's distributed lock manager", func() {
+		backend, err := machinery.ConfigureStorageBackend(context.Background(), storageSpec.Get())
+		Expect(err).NotTo(HaveOccurred())
+		lm, err := storage.DistributedLockManagerFor(backend, "test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lm).To(BeAssignableToTypeOf(store.Get().LockManager("test")))
+	})
+})
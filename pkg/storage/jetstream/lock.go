@@ -0,0 +1,142 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+
+	"github.com/rancher/opni/pkg/storage"
+)
+
+const (
+	locksBucket = "locks"
+	// Lock entries expire if they are not refreshed within this period, which
+	// releases locks held by clients that have gone away.
+	lockTTL           = 15 * time.Second
+	lockRefreshPeriod = lockTTL / 3
+	lockRetryPeriod   = 250 * time.Millisecond
+)
+
+func (s *JetStreamStore) LockManager(namespace string) storage.LockManager {
+	namespace = strings.ReplaceAll(strings.ReplaceAll(namespace, "/", "-"), ".", "_")
+	bucketName := fmt.Sprintf("%s-%s-%s", s.BucketPrefix, locksBucket, namespace)
+	kv, err := s.js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:   bucketName,
+		Storage:  nats.MemoryStorage,
+		History:  1,
+		TTL:      lockTTL,
+		Replicas: 1,
+	})
+	if err != nil {
+		s.logger.With(
+			"bucket", bucketName,
+			zap.Error(err),
+		).Panic("failed to create bucket")
+	}
+	return &jetstreamLockManager{
+		kv:     kv,
+		logger: s.logger,
+	}
+}
+
+type jetstreamLockManager struct {
+	kv     nats.KeyValue
+	logger *zap.SugaredLogger
+}
+
+func (m *jetstreamLockManager) Locker(key string) storage.Lock {
+	return &jetstreamLock{
+		kv:     m.kv,
+		key:    key,
+		logger: m.logger,
+	}
+}
+
+type jetstreamLock struct {
+	kv     nats.KeyValue
+	key    string
+	logger *zap.SugaredLogger
+
+	mu       sync.Mutex
+	revision uint64
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func (l *jetstreamLock) Lock(ctx context.Context) error {
+	owner := []byte(uuid.NewString())
+	for {
+		rev, err := l.kv.Create(l.key, owner)
+		if err == nil {
+			l.hold(owner, rev)
+			return nil
+		}
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetryPeriod):
+		}
+	}
+}
+
+func (l *jetstreamLock) hold(owner []byte, rev uint64) {
+	ctx, ca := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	l.mu.Lock()
+	l.revision = rev
+	l.cancel = ca
+	l.done = done
+	l.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockRefreshPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			l.mu.Lock()
+			rev, err := l.kv.Update(l.key, owner, l.revision)
+			if err != nil {
+				l.mu.Unlock()
+				l.logger.With(
+					"key", l.key,
+					zap.Error(err),
+				).Warn("failed to refresh lock")
+				continue
+			}
+			l.revision = rev
+			l.mu.Unlock()
+		}
+	}()
+}
+
+func (l *jetstreamLock) Unlock() error {
+	l.mu.Lock()
+	if l.cancel == nil {
+		l.mu.Unlock()
+		return storage.ErrLockNotHeld
+	}
+	l.cancel()
+	done := l.done
+	l.cancel = nil
+	l.mu.Unlock()
+	<-done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.kv.Delete(l.key, nats.LastRevision(l.revision))
+}
@@ -18,15 +18,14 @@ import (
 func (s *JetStreamStore) CreateToken(_ context.Context, ttl time.Duration, opts ...storage.TokenCreateOption) (*corev1.BootstrapToken, error) {
 	options := storage.NewTokenCreateOptions()
 	options.Apply(opts...)
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
 
 	token := tokens.NewToken().ToBootstrapToken()
-	token.Metadata = &corev1.BootstrapTokenMetadata{
-		LeaseID:      -1,
-		Ttl:          int64(ttl.Seconds()),
-		UsageCount:   0,
-		Labels:       options.Labels,
-		Capabilities: options.Capabilities,
-	}
+	token.Metadata = options.Metadata()
+	token.Metadata.LeaseID = -1
+	token.Metadata.Ttl = int64(ttl.Seconds())
 	data, err := protojson.Marshal(token)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal token: %w", err)
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrLockNotHeld = errors.New("lock is not held")
+
+// Lock is a mutual exclusion lock identified by a key. Locks obtained from a
+// shared storage backend are held across all clients of that backend, and
+// can be used to coordinate multiple gateway replicas.
+type Lock interface {
+	// Lock blocks until the lock is acquired, or returns an error if the
+	// context is canceled first.
+	Lock(ctx context.Context) error
+	// Unlock releases the lock. Returns ErrLockNotHeld if the lock is not
+	// currently held by this Lock.
+	Unlock() error
+}
+
+type LockManager interface {
+	// Locker returns a lock for the given key. Separate calls with the same
+	// key return separate Lock instances which exclude each other.
+	Locker(key string) Lock
+}
+
+// LockManagerBroker is implemented by storage backends which support
+// distributed locks.
+type LockManagerBroker interface {
+	// LockManager returns a lock manager for the given namespace, or nil if
+	// distributed locks are not supported.
+	LockManager(namespace string) LockManager
+}
+
+var ErrDistributedLocksUnsupported = errors.New("storage backend does not support distributed locks")
+
+var (
+	inMemoryLockManagersMu sync.Mutex
+	inMemoryLockManagers   = map[string]LockManager{}
+)
+
+// DistributedLockManagerFor returns the backend's lock manager for the given
+// namespace, or ErrDistributedLocksUnsupported if the backend does not support
+// distributed locks.
+func DistributedLockManagerFor(backend any, namespace string) (LockManager, error) {
+	if broker, ok := backend.(LockManagerBroker); ok {
+		if lm := broker.LockManager(namespace); lm != nil {
+			return lm, nil
+		}
+	}
+	return nil, ErrDistributedLocksUnsupported
+}
+
+// LockManagerFor returns the backend's lock manager for the given namespace
+// if the backend supports distributed locks. Otherwise, it returns a lock
+// manager which only coordinates callers in the current process. Callers
+// which require locks to be held across gateway replicas should use
+// DistributedLockManagerFor instead.
+func LockManagerFor(backend any, namespace string) LockManager {
+	if lm, err := DistributedLockManagerFor(backend, namespace); err == nil {
+		return lm
+	}
+	inMemoryLockManagersMu.Lock()
+	defer inMemoryLockManagersMu.Unlock()
+	if lm, ok := inMemoryLockManagers[namespace]; ok {
+		return lm
+	}
+	lm := NewInMemoryLockManager()
+	inMemoryLockManagers[namespace] = lm
+	return lm
+}
+
+type inMemoryLockManager struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+// NewInMemoryLockManager returns a lock manager whose locks are only shared
+// within the current process.
+func NewInMemoryLockManager() LockManager {
+	return &inMemoryLockManager{
+		locks: map[string]chan struct{}{},
+	}
+}
+
+func (m *inMemoryLockManager) Locker(key string) Lock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch, ok := m.locks[key]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		m.locks[key] = ch
+	}
+	return &inMemoryLock{ch: ch}
+}
+
+type inMemoryLock struct {
+	mu   sync.Mutex
+	ch   chan struct{}
+	held bool
+}
+
+func (l *inMemoryLock) Lock(ctx context.Context) error {
+	select {
+	case l.ch <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	l.held = true
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *inMemoryLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held {
+		return ErrLockNotHeld
+	}
+	l.held = false
+	<-l.ch
+	return nil
+}
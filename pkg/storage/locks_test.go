@@ -0,0 +1,54 @@
+package storage_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/storage/conformance"
+	"github.com/rancher/opni/pkg/util/future"
+)
+
+type inMemoryLockManagerBroker struct{}
+
+func (inMemoryLockManagerBroker) LockManager(namespace string) storage.LockManager {
+	return storage.LockManagerFor(nil, namespace)
+}
+
+type testLockManagerBroker struct {
+	lm storage.LockManager
+}
+
+func (b testLockManagerBroker) LockManager(string) storage.LockManager {
+	return b.lm
+}
+
+var _ = Describe("In-Memory Lock Manager", Ordered, Label("unit"),
+	conformance.LockManagerTestSuite(future.Instant(inMemoryLockManagerBroker{})))
+
+var _ = Describe("LockManagerFor", Label("unit"), func() {
+	It("should use the backend's lock manager if it supports locks", func() {
+		lm := storage.NewInMemoryLockManager()
+		Expect(storage.LockManagerFor(testLockManagerBroker{lm: lm}, "test")).To(BeIdenticalTo(lm))
+	})
+	It("should use the lock manager of a composite backend's store", func() {
+		lm := storage.NewInMemoryLockManager()
+		backend := storage.CompositeBackend{}
+		backend.Use(testLockManagerBroker{lm: lm})
+		Expect(storage.LockManagerFor(backend, "test")).To(BeIdenticalTo(lm))
+		Expect(storage.LockManagerFor(&backend, "test")).To(BeIdenticalTo(lm))
+		distributed, err := storage.DistributedLockManagerFor(backend, "test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(distributed).To(BeIdenticalTo(lm))
+	})
+	It("should report composite backends without distributed locks", func() {
+		backend := storage.CompositeBackend{}
+		_, err := storage.DistributedLockManagerFor(backend, "test")
+		Expect(err).To(MatchError(storage.ErrDistributedLocksUnsupported))
+		Expect(storage.LockManagerFor(backend, "test")).To(BeIdenticalTo(storage.LockManagerFor(nil, "test")))
+	})
+	It("should share in-memory lock managers by namespace otherwise", func() {
+		Expect(storage.LockManagerFor(nil, "a")).To(BeIdenticalTo(storage.LockManagerFor(struct{}{}, "a")))
+		Expect(storage.LockManagerFor(nil, "a")).NotTo(BeIdenticalTo(storage.LockManagerFor(nil, "b")))
+	})
+})
@@ -18,6 +18,29 @@ func NewIncrementUsageCountMutator() MutatorFunc[*corev1.BootstrapToken] {
 	}
 }
 
+// NewLimitedIncrementUsageCountMutator increments the usage count of a token
+// only if it has not reached its maximum usages, and sets *incremented to
+// report whether it did. Token stores apply mutators to the latest version of
+// the token and only write it if it has not changed since, retrying
+// otherwise, so concurrent updates cannot exceed the usage limit.
+func NewLimitedIncrementUsageCountMutator(incremented *bool) MutatorFunc[*corev1.BootstrapToken] {
+	return func(obj *corev1.BootstrapToken) {
+		max := obj.GetMetadata().GetMaxUsages()
+		*incremented = max <= 0 || obj.GetMetadata().GetUsageCount() < max
+		if *incremented {
+			obj.Metadata.UsageCount++
+		}
+	}
+}
+
+func NewDecrementUsageCountMutator() MutatorFunc[*corev1.BootstrapToken] {
+	return func(obj *corev1.BootstrapToken) {
+		if obj.Metadata.UsageCount > 0 {
+			obj.Metadata.UsageCount--
+		}
+	}
+}
+
 func NewAddCapabilityMutator[O corev1.MetadataAccessor[T], T corev1.Capability[T]](capability T) MutatorFunc[O] {
 	return func(obj O) {
 		exists := false
@@ -1,10 +1,20 @@
 package storage
 
-import corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+import (
+	"fmt"
+	"net/netip"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/validation"
+)
 
 type TokenCreateOptions struct {
-	Labels       map[string]string
-	Capabilities []*corev1.TokenCapability
+	Labels             map[string]string
+	Capabilities       []*corev1.TokenCapability
+	MaxUsages          int64
+	AllowedSourceCIDRs []string
+	ExpectedClusterID  string
+	RequireApproval    bool
 }
 
 func NewTokenCreateOptions() TokenCreateOptions {
@@ -34,6 +44,58 @@ func WithCapabilities(capabilities []*corev1.TokenCapability) TokenCreateOption
 	}
 }
 
+// Limits the number of times the token can be used. 0 means unlimited.
+func WithMaxUsages(maxUsages int64) TokenCreateOption {
+	return func(o *TokenCreateOptions) {
+		o.MaxUsages = maxUsages
+	}
+}
+
+// Restricts the source addresses the token can be used from.
+func WithAllowedSourceCIDRs(cidrs []string) TokenCreateOption {
+	return func(o *TokenCreateOptions) {
+		o.AllowedSourceCIDRs = cidrs
+	}
+}
+
+// Restricts the token to agents with the given cluster ID.
+func WithExpectedClusterID(id string) TokenCreateOption {
+	return func(o *TokenCreateOptions) {
+		o.ExpectedClusterID = id
+	}
+}
+
+// Requires clusters created with the token to be approved before their
+// agents can connect.
+func WithRequireApproval(requireApproval bool) TokenCreateOption {
+	return func(o *TokenCreateOptions) {
+		o.RequireApproval = requireApproval
+	}
+}
+
+// Validate checks that the options can be used to create a token. Token stores
+// must call it before creating a token.
+func (o TokenCreateOptions) Validate() error {
+	for _, cidr := range o.AllowedSourceCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("%w (allowedSourceCIDRs): %s", validation.ErrInvalidValue, err.Error())
+		}
+	}
+	return nil
+}
+
+// Metadata returns token metadata containing the options.
+func (o TokenCreateOptions) Metadata() *corev1.BootstrapTokenMetadata {
+	return &corev1.BootstrapTokenMetadata{
+		Labels:             o.Labels,
+		Capabilities:       o.Capabilities,
+		MaxUsages:          o.MaxUsages,
+		AllowedSourceCIDRs: o.AllowedSourceCIDRs,
+		ExpectedClusterID:  o.ExpectedClusterID,
+		RequireApproval:    o.RequireApproval,
+	}
+}
+
 type AlertFilterOptions struct {
 	Labels map[string]string
 	Range  *corev1.TimeRange
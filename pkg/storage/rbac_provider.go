@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strings"
 
+	"github.com/prometheus/prometheus/model/labels"
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
 	"github.com/rancher/opni/pkg/logger"
 	"github.com/rancher/opni/pkg/rbac"
@@ -16,6 +18,8 @@ type rbacProvider struct {
 	logger *zap.SugaredLogger
 }
 
+var _ rbac.LabelMatcherProvider = (*rbacProvider)(nil)
+
 func NewRBACProvider(store SubjectAccessCapableStore) rbac.Provider {
 	return &rbacProvider{
 		store:  store,
@@ -27,19 +31,161 @@ func (p *rbacProvider) SubjectAccess(
 	ctx context.Context,
 	req *corev1.SubjectAccessRequest,
 ) (*corev1.ReferenceList, error) {
-	// Look up all role bindings which exist for this user, then look up the roles
-	// referenced by those role bindings. Aggregate the resulting tenant IDs from
-	// the roles and filter out any duplicates.
+	roles, err := p.boundRoles(ctx, req.Subject, req.Groups)
+	if err != nil {
+		return nil, err
+	}
+	if req.Verb != "" {
+		return p.resourceAccess(ctx, roles, req)
+	}
+
+	// Aggregate the tenant IDs from the roles bound to the subject and filter
+	// out any duplicates.
+	allowedClusters := map[string]struct{}{}
+	for _, role := range roles {
+		if err := p.addSelectedClusters(ctx, role, allowedClusters); err != nil {
+			return nil, err
+		}
+	}
+	return sortedReferenceList(allowedClusters), nil
+}
+
+// MetricsLabelMatchers returns the label matchers which limit the series the
+// subject can read in each cluster it has access to. Roles are ORed together,
+// so a cluster is unrestricted if any role selecting it has no matchers. If
+// the matchers of several roles selecting the same cluster cannot be combined,
+// an error wrapping rbac.ErrUnsupportedMatcherUnion is returned.
+func (p *rbacProvider) MetricsLabelMatchers(
+	ctx context.Context,
+	req *corev1.SubjectAccessRequest,
+) (rbac.ClusterLabelMatchers, error) {
+	roles, err := p.boundRoles(ctx, req.Subject, req.Groups)
+	if err != nil {
+		return nil, err
+	}
+	unrestricted := map[string]struct{}{}
+	sets := map[string][][]*labels.Matcher{}
+	for _, role := range roles {
+		matchers, err := role.ParseMetricsLabelMatchers()
+		if err != nil {
+			return nil, fmt.Errorf("role %q: %w", role.Id, err)
+		}
+		clusters := map[string]struct{}{}
+		if err := p.addSelectedClusters(ctx, role, clusters); err != nil {
+			return nil, err
+		}
+		for id := range clusters {
+			if len(matchers) == 0 {
+				unrestricted[id] = struct{}{}
+			} else {
+				sets[id] = append(sets[id], matchers)
+			}
+		}
+	}
+	result := rbac.ClusterLabelMatchers{}
+	for id, clusterSets := range sets {
+		if _, ok := unrestricted[id]; ok {
+			continue
+		}
+		matchers, err := rbac.UnionLabelMatchers(clusterSets)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q: %w", id, err)
+		}
+		result[id] = matchers
+	}
+	return result, nil
+}
+
+// addSelectedClusters adds the IDs of the clusters selected by the role, either
+// explicitly or by its label selector, to the given set.
+func (p *rbacProvider) addSelectedClusters(
+	ctx context.Context,
+	role *corev1.Role,
+	ids map[string]struct{},
+) error {
+	for _, clusterID := range role.ClusterIDs {
+		ids[clusterID] = struct{}{}
+	}
+	filteredList, err := p.store.ListClusters(ctx, role.MatchLabels,
+		corev1.MatchOptions_EmptySelectorMatchesNone)
+	if err != nil {
+		return fmt.Errorf("failed to list clusters: %w", err)
+	}
+	for _, cluster := range filteredList.Items {
+		ids[cluster.Id] = struct{}{}
+	}
+	return nil
+}
+
+// resourceAccess answers whether the subject is allowed to perform the
+// requested verb on the requested resource. The result contains the IDs of
+// the clusters on which the action is allowed, or a single wildcard reference
+// if the action is allowed on all clusters (or the resource is not
+// cluster-scoped). If a cluster is given in the request, the result will
+// contain only that cluster if the action is allowed.
+func (p *rbacProvider) resourceAccess(
+	ctx context.Context,
+	roles []*corev1.Role,
+	req *corev1.SubjectAccessRequest,
+) (*corev1.ReferenceList, error) {
+	allowedClusters := map[string]struct{}{}
+	allClusters := false
+	for _, role := range roles {
+		if !role.Allows(req.Verb, req.Resource, req.Capability) {
+			continue
+		}
+		if !corev1.IsClusterScopedResource(req.Resource) {
+			return &corev1.ReferenceList{
+				Items: []*corev1.Reference{{Id: corev1.Wildcard}},
+			}, nil
+		}
+		if !role.SelectsClusters() {
+			allClusters = true
+			break
+		}
+		if err := p.addSelectedClusters(ctx, role, allowedClusters); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Cluster != nil {
+		if _, ok := allowedClusters[req.Cluster.Id]; ok || allClusters {
+			return &corev1.ReferenceList{
+				Items: []*corev1.Reference{{Id: req.Cluster.Id}},
+			}, nil
+		}
+		return &corev1.ReferenceList{}, nil
+	}
+	if allClusters {
+		return &corev1.ReferenceList{
+			Items: []*corev1.Reference{{Id: corev1.Wildcard}},
+		}, nil
+	}
+	return sortedReferenceList(allowedClusters), nil
+}
+
+// boundRoles looks up all role bindings which exist for the subject or any of
+// the given groups, and returns the roles referenced by those role bindings.
+// All applicable roles are ORed together by the caller.
+func (p *rbacProvider) boundRoles(ctx context.Context, subject string, groups []string) ([]*corev1.Role, error) {
 	rbs, err := p.store.ListRoleBindings(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list role bindings: %w", err)
 	}
-	allowedClusters := map[string]struct{}{}
-	// All applicable role bindings for this user are ORed together
+	subjects := map[string]struct{}{}
+	// user IDs which look like group subjects are not allowed to match
+	// group role bindings directly
+	if !strings.HasPrefix(subject, corev1.GroupSubjectPrefix) {
+		subjects[subject] = struct{}{}
+	}
+	for _, group := range groups {
+		subjects[corev1.GroupSubject(group)] = struct{}{}
+	}
+	var roles []*corev1.Role
 	for _, roleBinding := range rbs.Items {
 		appliesToUser := false
 		for _, s := range roleBinding.Subjects {
-			if s == req.Subject {
+			if _, ok := subjects[s]; ok {
 				appliesToUser = true
 			}
 		}
@@ -63,25 +209,16 @@ func (p *rbacProvider) SubjectAccess(
 			).Warn("error looking up role")
 			continue
 		}
-		// Add explicitly-allowed clusters to the list
-		for _, clusterID := range role.ClusterIDs {
-			allowedClusters[clusterID] = struct{}{}
-		}
-
-		// Add any clusters to the list which match the role's label selector
-		filteredList, err := p.store.ListClusters(ctx, role.MatchLabels,
-			corev1.MatchOptions_EmptySelectorMatchesNone)
-		if err != nil {
-			return nil, fmt.Errorf("failed to list clusters: %w", err)
-		}
-		for _, cluster := range filteredList.Items {
-			allowedClusters[cluster.Id] = struct{}{}
-		}
+		roles = append(roles, role)
 	}
-	sortedReferences := make([]*corev1.Reference, 0, len(allowedClusters))
-	for clusterID := range allowedClusters {
+	return roles, nil
+}
+
+func sortedReferenceList(ids map[string]struct{}) *corev1.ReferenceList {
+	sortedReferences := make([]*corev1.Reference, 0, len(ids))
+	for id := range ids {
 		sortedReferences = append(sortedReferences, &corev1.Reference{
-			Id: clusterID,
+			Id: id,
 		})
 	}
 	sort.Slice(sortedReferences, func(i, j int) bool {
@@ -89,5 +226,5 @@ func (p *rbacProvider) SubjectAccess(
 	})
 	return &corev1.ReferenceList{
 		Items: sortedReferences,
-	}, nil
+	}
 }
@@ -7,6 +7,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/rbac"
 	"github.com/rancher/opni/pkg/storage"
 	"github.com/rancher/opni/pkg/test"
 )
@@ -41,11 +42,70 @@ var _ = Describe("RBAC Provider", Ordered, Label("unit"), func() {
 		Entry("1 role with 2 selectors", rbacs(role("r1", matchExprs("foo Exists", "bar Exists")), rb("rb1", "r1", "u1")), "u1", "c5"),
 	}
 
+	resourceEntries := []TableEntry{
+		Entry("no rules", rbacs(role("r1", "c1"), rb("rb1", "r1", "u1")), accessRequest("get", "clusters"), "u1"),
+		Entry("matching rule", rbacs(role("r1", "c1", rule("get", "clusters")), rb("rb1", "r1", "u1")), accessRequest("get", "clusters"), "u1", "c1"),
+		Entry("non-matching verb", rbacs(role("r1", "c1", rule("list", "clusters")), rb("rb1", "r1", "u1")), accessRequest("get", "clusters"), "u1"),
+		Entry("non-matching resource", rbacs(role("r1", "c1", rule("get", "tokens")), rb("rb1", "r1", "u1")), accessRequest("get", "clusters"), "u1"),
+		Entry("wildcard verb", rbacs(role("r1", "c1", rule("*", "clusters")), rb("rb1", "r1", "u1")), accessRequest("delete", "clusters"), "u1", "c1"),
+		Entry("wildcard resource", rbacs(role("r1", "c1", rule("get", "*")), rb("rb1", "r1", "u1")), accessRequest("get", "clusters"), "u1", "c1"),
+		Entry("multiple verbs", rbacs(role("r1", "c1", rule("get,list", "clusters")), rb("rb1", "r1", "u1")), accessRequest("list", "clusters"), "u1", "c1"),
+		Entry("role with no cluster", rbacs(role("r1", rule("get", "clusters")), rb("rb1", "r1", "u1")), accessRequest("get", "clusters"), "u1", "*"),
+		Entry("role with selector", rbacs(role("r1", matchExprs("foo Exists"), rule("get", "clusters")), rb("rb1", "r1", "u1")), accessRequest("get", "clusters"), "u1", "c2", "c3", "c5"),
+		Entry("2 roles/1 matching", rbacs(role("r1", "c1", rule("get", "clusters")), role("r2", "c2", rule("get", "tokens")), rb("rb1", "r1", "u1"), rb("rb2", "r2", "u1")), accessRequest("get", "clusters"), "u1", "c1"),
+		Entry("2 roles/2 matching", rbacs(role("r1", "c1", rule("get", "clusters")), role("r2", "c2", rule("*", "*")), rb("rb1", "r1", "u1"), rb("rb2", "r2", "u1")), accessRequest("get", "clusters"), "u1", "c1", "c2"),
+		Entry("global resource", rbacs(role("r1", "c1", rule("create", "tokens")), rb("rb1", "r1", "u1")), accessRequest("create", "tokens"), "u1", "*"),
+		Entry("capability rule", rbacs(role("r1", "c1", rule("get", "capabilities", "metrics")), rb("rb1", "r1", "u1")), accessRequest("get", "capabilities", "metrics"), "u1", "c1"),
+		Entry("capability rule/wrong capability", rbacs(role("r1", "c1", rule("get", "capabilities", "metrics")), rb("rb1", "r1", "u1")), accessRequest("get", "capabilities", "logging"), "u1"),
+		Entry("capability rule/no capability", rbacs(role("r1", "c1", rule("get", "capabilities", "metrics")), rb("rb1", "r1", "u1")), accessRequest("get", "capabilities"), "u1"),
+		Entry("unscoped rule/capability", rbacs(role("r1", "c1", rule("get", "capabilities")), rb("rb1", "r1", "u1")), accessRequest("get", "capabilities", "logging"), "u1", "c1"),
+		Entry("specific cluster/allowed", rbacs(role("r1", "c1", "c2", rule("get", "clusters")), rb("rb1", "r1", "u1")), accessRequest("get", "clusters", "", "c2"), "u1", "c2"),
+		Entry("specific cluster/denied", rbacs(role("r1", "c1", rule("get", "clusters")), rb("rb1", "r1", "u1")), accessRequest("get", "clusters", "", "c2"), "u1"),
+		Entry("specific cluster/all clusters", rbacs(role("r1", rule("get", "clusters")), rb("rb1", "r1", "u1")), accessRequest("get", "clusters", "", "c3"), "u1", "c3"),
+		Entry("tainted binding", rbacs(role("r1", "c1", rule("get", "clusters")), rb("rb1", "r2", "u1")), accessRequest("get", "clusters"), "u1"),
+	}
+
+	groupEntries := []TableEntry{
+		Entry("user binding", rbacs(role("r1", "c1"), rb("rb1", "r1", "u1")), "u1", []string{"g1"}, "c1"),
+		Entry("group binding", rbacs(role("r1", "c1"), rb("rb1", "r1", "group:g1")), "u1", []string{"g1"}, "c1"),
+		Entry("group binding/not a member", rbacs(role("r1", "c1"), rb("rb1", "r1", "group:g1")), "u1", []string{"g2"}),
+		Entry("group binding/no groups", rbacs(role("r1", "c1"), rb("rb1", "r1", "group:g1")), "u1", nil),
+		Entry("user and group bindings", rbacs(role("r1", "c1"), role("r2", "c2"), rb("rb1", "r1", "u1"), rb("rb2", "r2", "group:g1")), "u1", []string{"g1"}, "c1", "c2"),
+		Entry("multiple groups", rbacs(role("r1", "c1"), role("r2", "c2"), rb("rb1", "r1", "group:g1"), rb("rb2", "r2", "group:g2")), "u1", []string{"g1", "g2"}, "c1", "c2"),
+		Entry("group name used as user id", rbacs(role("r1", "c1"), rb("rb1", "r1", "g1")), "u1", []string{"g1"}),
+		Entry("user id with group prefix", rbacs(role("r1", "c1"), rb("rb1", "r1", "group:g1")), "group:g1", nil),
+	}
+
+	matcherEntries := []TableEntry{
+		Entry("no matchers", rbacs(role("r1", "c1"), rb("rb1", "r1", "u1")), "u1", map[string]string{}),
+		Entry("1 role", rbacs(role("r1", "c1", "c2", metricsMatchers{`namespace="a"`}), rb("rb1", "r1", "u1")), "u1", map[string]string{
+			"c1": `{namespace="a"}`,
+			"c2": `{namespace="a"}`,
+		}),
+		Entry("1 role/selector", rbacs(role("r1", matchLabels("foo", "bar"), metricsMatchers{`namespace="a"`, `job!="x"`}), rb("rb1", "r1", "u1")), "u1", map[string]string{
+			"c2": `{job!="x",namespace="a"}`,
+		}),
+		Entry("2 roles/different clusters", rbacs(role("r1", "c1", metricsMatchers{`namespace="a"`}), role("r2", "c2", metricsMatchers{`namespace="b"`}), rb("rb1", "r1", "u1"), rb("rb2", "r2", "u1")), "u1", map[string]string{
+			"c1": `{namespace="a"}`,
+			"c2": `{namespace="b"}`,
+		}),
+		Entry("2 roles/same cluster/mergeable", rbacs(role("r1", "c1", metricsMatchers{`namespace="a.b"`}), role("r2", "c1", metricsMatchers{`namespace=~"team-.*"`}), rb("rb1", "r1", "u1"), rb("rb2", "r2", "u1")), "u1", map[string]string{
+			"c1": `{namespace=~"a\\.b|(?:team-.*)"}`,
+		}),
+		Entry("2 roles/same matchers", rbacs(role("r1", "c1", metricsMatchers{`namespace="a"`}), role("r2", "c1", metricsMatchers{`namespace="a"`}), rb("rb1", "r1", "u1"), rb("rb2", "r2", "u1")), "u1", map[string]string{
+			"c1": `{namespace="a"}`,
+		}),
+		Entry("2 roles/1 unrestricted", rbacs(role("r1", "c1", "c2", metricsMatchers{`namespace="a"`}), role("r2", "c1"), rb("rb1", "r1", "u1"), rb("rb2", "r2", "u1")), "u1", map[string]string{
+			"c2": `{namespace="a"}`,
+		}),
+		Entry("role with no binding", rbacs(role("r1", "c1", metricsMatchers{`namespace="a"`})), "u1", map[string]string{}),
+	}
+
 	var ctrl *gomock.Controller
 	BeforeAll(func() {
 		ctrl = gomock.NewController(GinkgoT())
 	})
-	DescribeTable("Subject Access", func(objects rbacObjects, subject string, expected ...string) {
+	newProvider := func(objects rbacObjects) rbac.Provider {
 		rbacStore = test.NewTestRBACStore(ctrl)
 		clusterStore := test.NewTestClusterStore(ctrl)
 		for _, cluster := range clusters {
@@ -68,14 +128,77 @@ var _ = Describe("RBAC Provider", Ordered, Label("unit"), func() {
 			err := rbacStore.CreateRoleBinding(context.Background(), obj())
 			Expect(err).NotTo(HaveOccurred())
 		}
+		return provider
+	}
+	DescribeTable("Subject Access", func(objects rbacObjects, subject string, expected ...string) {
+		provider := newProvider(objects)
 		refs, err := provider.SubjectAccess(context.Background(), &corev1.SubjectAccessRequest{
 			Subject: subject,
 		})
 		Expect(err).NotTo(HaveOccurred())
-		ids := make([]string, len(refs.Items))
-		for i, ref := range refs.Items {
-			ids[i] = ref.Id
-		}
-		Expect(ids).To(Equal(expected))
+		Expect(referenceIds(refs)).To(Equal(expected))
 	}, entries)
+	DescribeTable("Resource Access", func(objects rbacObjects, sar *corev1.SubjectAccessRequest, subject string, expected ...string) {
+		provider := newProvider(objects)
+		sar.Subject = subject
+		refs, err := provider.SubjectAccess(context.Background(), sar)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(referenceIds(refs)).To(Equal(expected))
+	}, resourceEntries)
+	DescribeTable("Group Access", func(objects rbacObjects, subject string, groups []string, expected ...string) {
+		provider := newProvider(objects)
+		refs, err := provider.SubjectAccess(context.Background(), &corev1.SubjectAccessRequest{
+			Subject: subject,
+			Groups:  groups,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(referenceIds(refs)).To(Equal(expected))
+	}, groupEntries)
+	DescribeTable("Metrics Label Matchers", func(objects rbacObjects, subject string, expected map[string]string) {
+		provider := newProvider(objects).(rbac.LabelMatcherProvider)
+		matchers, err := provider.MetricsLabelMatchers(context.Background(), &corev1.SubjectAccessRequest{
+			Subject: subject,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		actual := map[string]string{}
+		for id, m := range matchers {
+			actual[id] = rbac.MatchersString(m)
+		}
+		Expect(actual).To(Equal(expected))
+	}, matcherEntries)
+	It("should reject roles whose metrics label matchers cannot be combined", func() {
+		for _, objects := range []rbacObjects{
+			rbacs(role("r1", "c1", metricsMatchers{`namespace="a"`}), role("r2", "c1", metricsMatchers{`job="b"`}), rb("rb1", "r1", "u1"), rb("rb2", "r2", "u1")),
+			rbacs(role("r1", "c1", metricsMatchers{`namespace="a"`, `job="b"`}), role("r2", "c1", metricsMatchers{`namespace="b"`}), rb("rb1", "r1", "u1"), rb("rb2", "r2", "u1")),
+			rbacs(role("r1", "c1", metricsMatchers{`namespace="a"`}), role("r2", "c1", metricsMatchers{`namespace!="b"`}), rb("rb1", "r1", "u1"), rb("rb2", "r2", "u1")),
+		} {
+			provider := newProvider(objects).(rbac.LabelMatcherProvider)
+			_, err := provider.MetricsLabelMatchers(context.Background(), &corev1.SubjectAccessRequest{
+				Subject: "u1",
+			})
+			Expect(err).To(MatchError(rbac.ErrUnsupportedMatcherUnion))
+		}
+	})
 })
+
+func accessRequest(verb, resource string, capabilityAndCluster ...string) *corev1.SubjectAccessRequest {
+	sar := &corev1.SubjectAccessRequest{
+		Verb:     verb,
+		Resource: resource,
+	}
+	if len(capabilityAndCluster) > 0 {
+		sar.Capability = capabilityAndCluster[0]
+	}
+	if len(capabilityAndCluster) > 1 {
+		sar.Cluster = &corev1.Reference{Id: capabilityAndCluster[1]}
+	}
+	return sar
+}
+
+func referenceIds(refs *corev1.ReferenceList) []string {
+	ids := make([]string, len(refs.Items))
+	for i, ref := range refs.Items {
+		ids[i] = ref.Id
+	}
+	return ids
+}
@@ -101,6 +101,16 @@ func rbacs(objects ...interface{}) rbacObjects {
 	return objs
 }
 
+func rule(verbs, resources string, capabilities ...string) *corev1.PolicyRule {
+	return &corev1.PolicyRule{
+		Verbs:        strings.Split(verbs, ","),
+		Resources:    strings.Split(resources, ","),
+		Capabilities: capabilities,
+	}
+}
+
+type metricsMatchers []string
+
 func role(id string, clusterIdOrSelector ...interface{}) func() *corev1.Role {
 	return func() *corev1.Role {
 		r := &corev1.Role{
@@ -114,6 +124,10 @@ func role(id string, clusterIdOrSelector ...interface{}) func() *corev1.Role {
 				r.ClusterIDs = append(r.ClusterIDs, v...)
 			case *corev1.LabelSelector:
 				r.MatchLabels = v
+			case *corev1.PolicyRule:
+				r.Rules = append(r.Rules, v)
+			case metricsMatchers:
+				r.MetricsLabelMatchers = append(r.MetricsLabelMatchers, v...)
 			}
 		}
 		return r
@@ -1324,6 +1324,11 @@ func (e *Environment) NewGatewayConfig() *v1beta1.GatewayConfig {
 				WebListenAddress:  fmt.Sprintf("127.0.0.1:%d", e.ports.ManagementWeb),
 			},
 			AuthProvider: "test",
+			// all test agents bootstrap from the same address
+			Bootstrap: v1beta1.BootstrapServerSpec{
+				RequestsPerMinute: 6000,
+				Burst:             100,
+			},
 			Certs: v1beta1.CertsSpec{
 				CACertData:      caCertData,
 				ServingCertData: servingCertData,
@@ -1550,7 +1555,13 @@ func (e *Environment) startGateway() {
 	m := management.NewServer(e.ctx, &e.gatewayConfig.Spec.Management, g, pluginLoader,
 		management.WithCapabilitiesDataSource(g),
 		management.WithHealthStatusDataSource(g),
+		management.WithKeyRotationDataSource(g),
+		management.WithDiagnosticsDataSource(g),
+		management.WithHealthHistoryDataSource(g),
+		management.WithStaleClusterDataSource(g),
+		management.WithPluginRolloutDataSource(g),
 		management.WithLifecycler(lifecycler),
+		management.WithTrustedProxies(e.gatewayConfig.Spec.TrustedProxies...),
 	)
 
 	pluginLoader.Hook(hooks.OnLoadingCompleted(func(numLoaded int) {
@@ -689,15 +689,14 @@ func NewTestTokenStore(ctx context.Context, ctrl *gomock.Controller) storage.Tok
 			defer mu.Unlock()
 			options := storage.NewTokenCreateOptions()
 			options.Apply(opts...)
+			if err := options.Validate(); err != nil {
+				return nil, err
+			}
 			t := tokens.NewToken().ToBootstrapToken()
 			lease := leaseStore.New(t.TokenID, ttl)
-			t.Metadata = &corev1.BootstrapTokenMetadata{
-				LeaseID:      int64(lease.ID),
-				Ttl:          int64(ttl),
-				UsageCount:   0,
-				Labels:       options.Labels,
-				Capabilities: options.Capabilities,
-			}
+			t.Metadata = options.Metadata()
+			t.Metadata.LeaseID = int64(lease.ID)
+			t.Metadata.Ttl = int64(ttl)
 			tks[t.TokenID] = t
 			return t, nil
 		}).
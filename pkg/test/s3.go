@@ -0,0 +1,140 @@
+package test
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// NewTestS3Server starts a minimal S3-compatible server which stores objects
+// in the given filesystem, at /<bucket>/<key>. Only path-style requests and
+// the basic object operations (put, get, head, delete, and ListObjectsV2
+// without pagination) are supported. Requests are not authenticated.
+func NewTestS3Server(fsys afero.Fs) *httptest.Server {
+	return httptest.NewServer(&testS3Handler{fs: afero.Afero{Fs: fsys}})
+}
+
+type testS3Handler struct {
+	fs afero.Afero
+}
+
+type testS3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+type testS3Object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+type testS3ListResult struct {
+	XMLName     xml.Name       `xml:"ListBucketResult"`
+	Name        string         `xml:"Name"`
+	Prefix      string         `xml:"Prefix"`
+	KeyCount    int            `xml:"KeyCount"`
+	IsTruncated bool           `xml:"IsTruncated"`
+	Contents    []testS3Object `xml:"Contents"`
+}
+
+func (h *testS3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		h.writeError(w, http.StatusBadRequest, "InvalidRequest", "missing bucket")
+		return
+	}
+	if key == "" {
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+			h.list(w, bucket, r.URL.Query().Get("prefix"))
+			return
+		}
+		h.writeError(w, http.StatusNotImplemented, "NotImplemented", "unsupported bucket operation")
+		return
+	}
+	filename := path.Join("/", bucket, key)
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		if err := h.fs.MkdirAll(path.Dir(filename), 0777); err != nil {
+			h.writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		if err := h.fs.WriteFile(filename, data, 0666); err != nil {
+			h.writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		data, err := h.fs.ReadFile(filename)
+		if err != nil {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			h.writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		if err := h.fs.Remove(filename); err != nil && !os.IsNotExist(err) {
+			h.writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		h.writeError(w, http.StatusNotImplemented, "NotImplemented", "unsupported object operation")
+	}
+}
+
+func (h *testS3Handler) list(w http.ResponseWriter, bucket, prefix string) {
+	result := testS3ListResult{
+		Name:   bucket,
+		Prefix: prefix,
+	}
+	root := path.Join("/", bucket)
+	h.fs.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		key := strings.TrimPrefix(p, root+"/")
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, testS3Object{
+				Key:  key,
+				Size: info.Size(),
+			})
+		}
+		return nil
+	})
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return result.Contents[i].Key < result.Contents[j].Key
+	})
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (h *testS3Handler) writeError(w http.ResponseWriter, code int, s3Code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	xml.NewEncoder(w).Encode(testS3Error{
+		Code:    s3Code,
+		Message: msg,
+	})
+}
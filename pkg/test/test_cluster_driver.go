@@ -28,10 +28,13 @@ import (
 	alerting_drivers "github.com/rancher/opni/plugins/alerting/pkg/alerting/drivers"
 	"github.com/rancher/opni/plugins/alerting/pkg/apis/alertops"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexops"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/filesystem"
 	"golang.org/x/exp/slices"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
 	"gopkg.in/yaml.v2"
 )
 
@@ -70,8 +73,12 @@ type TestEnvMetricsClusterDriver struct {
 	cortexCtx    context.Context
 	cortexCancel context.CancelFunc
 
-	Env           *Environment
-	Configuration *cortexops.ClusterConfiguration
+	Env                  *Environment
+	Configuration        *cortexops.ClusterConfiguration
+	TenantLimitOverrides map[string]*structpb.Struct
+	// If set, returned by NewBucketClient instead of the test environment's
+	// cortex storage bucket.
+	Bucket objstore.Bucket
 }
 
 func NewTestEnvMetricsClusterDriver(env *Environment) *TestEnvMetricsClusterDriver {
@@ -188,6 +195,26 @@ func (d *TestEnvMetricsClusterDriver) UninstallCluster(context.Context, *emptypb
 	return &emptypb.Empty{}, nil
 }
 
+func (d *TestEnvMetricsClusterDriver) SetTenantLimitOverrides(_ context.Context, overrides map[string]*structpb.Struct) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.TenantLimitOverrides = overrides
+	return nil
+}
+
+func (d *TestEnvMetricsClusterDriver) NewBucketClient(context.Context) (objstore.Bucket, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.Bucket != nil {
+		return d.Bucket, nil
+	}
+	if d.Env == nil {
+		return nil, status.Error(codes.Unimplemented, "no test environment")
+	}
+	return filesystem.NewBucket(path.Join(d.Env.tempDir, "cortex", "blocks"))
+}
+
 type TestEnvAlertingClusterDriver struct {
 	env              *Environment
 	managedInstances []AlertingServerUnit
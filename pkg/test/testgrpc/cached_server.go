@@ -1,13 +1,12 @@
 package testgrpc
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"sync/atomic"
 	"time"
 
-	"gopkg.in/square/go-jose.v2/json"
-
 	"github.com/rancher/opni/pkg/util"
 )
 
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// PeerAddr returns the address of the peer which sent the request.
+func PeerAddr(ctx context.Context) (netip.Addr, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return netip.Addr{}, false
+	}
+	addrPort, err := netip.ParseAddrPort(p.Addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addrPort.Addr().Unmap(), true
+}
+
+// ClientAddr returns the address of the client which sent the request. If the
+// peer is one of the trusted proxies, the client address is taken from the
+// X-Forwarded-For (or X-Real-IP) request metadata: the rightmost address
+// which is not itself a trusted proxy. Otherwise, forwarding metadata is
+// ignored, since it can be set by any client.
+func ClientAddr(ctx context.Context, trustedProxies []netip.Prefix) (netip.Addr, bool) {
+	addr, ok := PeerAddr(ctx)
+	if !ok || !PrefixesContain(trustedProxies, addr) {
+		return addr, ok
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var forwarded []string
+	for _, value := range md.Get("x-forwarded-for") {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+	if len(forwarded) == 0 {
+		forwarded = md.Get("x-real-ip")
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !PrefixesContain(trustedProxies, addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// ParsePrefixes parses a list of CIDRs or single addresses. Invalid entries
+// are ignored.
+func ParsePrefixes(cidrs []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, cidr := range cidrs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(cidr); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		}
+	}
+	return prefixes
+}
+
+// PrefixesContain reports whether any of the prefixes contains addr.
+func PrefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -110,3 +110,19 @@ func Wait6[T, U, V, W, X, Y any](f1 Future[T], f2 Future[U], f3 Future[V], f4 Fu
 func Wait7[T, U, V, W, X, Y, Z any](f1 Future[T], f2 Future[U], f3 Future[V], f4 Future[W], f5 Future[X], f6 Future[Y], f7 Future[Z], callback func(T, U, V, W, X, Y, Z)) {
 	go func() { callback(f1.Get(), f2.Get(), f3.Get(), f4.Get(), f5.Get(), f6.Get(), f7.Get()) }()
 }
+
+func Wait8[T, U, V, W, X, Y, Z, A any](f1 Future[T], f2 Future[U], f3 Future[V], f4 Future[W], f5 Future[X], f6 Future[Y], f7 Future[Z], f8 Future[A], callback func(T, U, V, W, X, Y, Z, A)) {
+	go func() { callback(f1.Get(), f2.Get(), f3.Get(), f4.Get(), f5.Get(), f6.Get(), f7.Get(), f8.Get()) }()
+}
+
+func Wait9[T, U, V, W, X, Y, Z, A, B any](f1 Future[T], f2 Future[U], f3 Future[V], f4 Future[W], f5 Future[X], f6 Future[Y], f7 Future[Z], f8 Future[A], f9 Future[B], callback func(T, U, V, W, X, Y, Z, A, B)) {
+	go func() {
+		callback(f1.Get(), f2.Get(), f3.Get(), f4.Get(), f5.Get(), f6.Get(), f7.Get(), f8.Get(), f9.Get())
+	}()
+}
+
+func Wait10[T, U, V, W, X, Y, Z, A, B, C any](f1 Future[T], f2 Future[U], f3 Future[V], f4 Future[W], f5 Future[X], f6 Future[Y], f7 Future[Z], f8 Future[A], f9 Future[B], f10 Future[C], callback func(T, U, V, W, X, Y, Z, A, B, C)) {
+	go func() {
+		callback(f1.Get(), f2.Get(), f3.Get(), f4.Get(), f5.Get(), f6.Get(), f7.Get(), f8.Get(), f9.Get(), f10.Get())
+	}()
+}
@@ -11,6 +11,9 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 type ServicePackInterface interface {
@@ -120,3 +123,35 @@ func HashStrings(strings []string) string {
 func HashString(s string) uint64 {
 	return xxh3.HashString(s)
 }
+
+type marshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// ProtoMessage converts a gRPC request or response message to a
+// proto.Message. Messages for API extension methods are dynamic messages
+// which do not implement the v2 proto API; these are converted using the
+// given descriptor, if any. Returns nil if the message cannot be converted.
+func ProtoMessage(msg any, desc protoreflect.MessageDescriptor) proto.Message {
+	switch msg := msg.(type) {
+	case nil:
+		return nil
+	case proto.Message:
+		return msg
+	case marshaler:
+		if desc == nil {
+			return nil
+		}
+		data, err := msg.Marshal()
+		if err != nil {
+			return nil
+		}
+		m := dynamicpb.NewMessage(desc)
+		if err := proto.Unmarshal(data, m); err != nil {
+			return nil
+		}
+		return m
+	default:
+		return nil
+	}
+}
@@ -24,6 +24,7 @@ var (
 
 	labelNameRegex   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-_./]{0,63}$`)
 	labelValueRegex  = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-_.]{0,63}$`)
+	nameRegex        = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-_.]{0,63}$`)
 	idRegex          = regexp.MustCompile(`^[a-zA-Z0-9-_.\(\)]{1,128}$`)
 	subjectNameRegex = regexp.MustCompile(`^[^\\*"'\s]{1,256}$`)
 )
@@ -58,6 +59,13 @@ func ValidateLabelValue(value string) error {
 	return nil
 }
 
+func ValidateName(name string) error {
+	if !nameRegex.MatchString(name) {
+		return ErrInvalidName
+	}
+	return nil
+}
+
 func ValidateID(id string) error {
 	if !idRegex.MatchString(id) {
 		return ErrInvalidID
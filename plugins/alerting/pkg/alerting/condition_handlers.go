@@ -6,6 +6,7 @@ package alerting
 
 import (
 	"context"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,7 +18,9 @@ import (
 	"github.com/rancher/opni/pkg/alerting/metrics"
 	"github.com/rancher/opni/plugins/alerting/pkg/alerting/messaging"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexadmin"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/quota"
 	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 
 	"github.com/rancher/opni/pkg/alerting/shared"
@@ -51,6 +54,20 @@ func (p *Plugin) setupCondition(
 		}
 		return &corev1.Reference{Id: newConditionId}, nil
 	}
+	if req.GetAlertType().GetIngestionQuota() != nil {
+		err := p.handleIngestionQuotaAlertCreation(ctx, req, newConditionId, req.GetName(), req.Namespace())
+		if err != nil {
+			return nil, err
+		}
+		return &corev1.Reference{Id: newConditionId}, nil
+	}
+	if req.GetAlertType().GetStaleCluster() != nil {
+		err := p.handleStaleClusterAlertCreation(ctx, req, newConditionId, req.GetName(), req.Namespace())
+		if err != nil {
+			return nil, err
+		}
+		return &corev1.Reference{Id: newConditionId}, nil
+	}
 	if req.GetAlertType().GetKubeState() != nil {
 		err := p.handleKubeAlertCreation(ctx, req, newConditionId, req.Name)
 		if err != nil {
@@ -106,6 +123,18 @@ func (p *Plugin) deleteCondition(ctx context.Context, _ *zap.SugaredLogger, req
 		p.storageClientSet.Get().States().Delete(ctx, id)
 		return nil
 	}
+	if r := req.AlertType.GetIngestionQuota(); r != nil {
+		p.msgNode.RemoveConfigListener(id)
+		p.storageClientSet.Get().Incidents().Delete(ctx, id)
+		p.storageClientSet.Get().States().Delete(ctx, id)
+		return nil
+	}
+	if r := req.AlertType.GetStaleCluster(); r != nil {
+		p.msgNode.RemoveConfigListener(id)
+		p.storageClientSet.Get().Incidents().Delete(ctx, id)
+		p.storageClientSet.Get().States().Delete(ctx, id)
+		return nil
+	}
 	if r, _ := handleSwitchCortexRules(req.AlertType); r != nil {
 		_, err := p.adminClient.Get().DeleteRule(ctx, &cortexadmin.DeleteRuleRequest{
 			ClusterId: r.Id,
@@ -179,6 +208,34 @@ func (p *Plugin) handleMonitoringBackendAlertCreation(
 	return nil
 }
 
+func (p *Plugin) handleIngestionQuotaAlertCreation(
+	_ context.Context,
+	k *alertingv1.AlertCondition,
+	newConditionId string,
+	conditionName string,
+	namespace string,
+) error {
+	err := p.onIngestionQuotaCreate(newConditionId, conditionName, namespace, k)
+	if err != nil {
+		p.Logger.Errorf("failed to create ingestion quota condition %s", err)
+	}
+	return nil
+}
+
+func (p *Plugin) handleStaleClusterAlertCreation(
+	_ context.Context,
+	k *alertingv1.AlertCondition,
+	newConditionId string,
+	conditionName string,
+	namespace string,
+) error {
+	err := p.onStaleClusterCreate(newConditionId, conditionName, namespace, k)
+	if err != nil {
+		p.Logger.Errorf("failed to create stale cluster condition %s", err)
+	}
+	return nil
+}
+
 func (p *Plugin) handleKubeAlertCreation(ctx context.Context, cond *alertingv1.AlertCondition, newId, alertName string) error {
 	k := cond.GetAlertType().GetKubeState()
 	baseKubeRule, err := metrics.NewKubeStateRule(
@@ -707,3 +764,182 @@ func (p *Plugin) onCortexClusterStatusCreate(conditionId, conditionName, namespa
 	})
 	return nil
 }
+
+// reduceIngestionQuotaUsage reports the cluster as unhealthy if usage of any
+// of the tracked limits is at or above the threshold. If no limits are
+// specified, all limits set for the cluster are tracked.
+func reduceIngestionQuotaUsage(limits []string, threshold float64, usage *quota.ClusterUsage) (healthy bool, ts *timestamppb.Timestamp) {
+	if usage == nil {
+		return true, timestamppb.Now()
+	}
+	ts = usage.GetTimestamp()
+	if ts == nil {
+		ts = timestamppb.Now()
+	}
+	for limit, ratio := range usage.UsageRatios() {
+		if len(limits) > 0 && !slices.Contains(limits, limit) {
+			continue
+		}
+		if ratio >= threshold {
+			return false, ts
+		}
+	}
+	return true, ts
+}
+
+func (p *Plugin) onIngestionQuotaCreate(conditionId, conditionName, namespace string, condition *alertingv1.AlertCondition) error {
+	lg := p.Logger.With("onIngestionQuotaCreate", conditionId)
+	q := condition.GetAlertType().GetIngestionQuota()
+	lg.Debugf("received condition update: %v", condition)
+	jsCtx, cancel := context.WithCancel(p.Ctx)
+	lg.Debugf("Creating ingestion quota condition with timeout %s", q.GetFor().AsDuration())
+
+	evaluator := NewInternalConditionEvaluator(
+		&internalConditionMetadata{
+			conditionId:        conditionId,
+			conditionName:      conditionName,
+			lg:                 lg,
+			clusterId:          q.GetClusterId().GetId(),
+			alertmanagerlabels: map[string]string{},
+		},
+		&internalConditionContext{
+			parentCtx:        p.Ctx,
+			evaluationCtx:    jsCtx,
+			evaluateInterval: time.Minute,
+			cancelEvaluation: cancel,
+			evaluateDuration: q.GetFor().AsDuration(),
+		},
+		&internalConditionStorage{
+			js:               p.js.Get(),
+			durableConsumer:  nil,
+			streamSubject:    NewIngestionQuotaSubject(q.GetClusterId().GetId()),
+			storageClientSet: p.storageClientSet.Get(),
+			msgCh:            make(chan *nats.Msg, 32),
+		},
+		&internalConditionState{},
+		&internalConditionHooks[*quota.ClusterUsage]{
+			healthOnMessage: func(u *quota.ClusterUsage) (healthy bool, ts *timestamppb.Timestamp) {
+				return reduceIngestionQuotaUsage(q.GetLimits(), q.GetThreshold(), u)
+			},
+			triggerHook: func(ctx context.Context, conditionId string, labels, annotations map[string]string) {
+				_, _ = p.TriggerAlerts(ctx, &alertingv1.TriggerAlertsRequest{
+					ConditionId:   &corev1.Reference{Id: conditionId},
+					ConditionName: conditionName,
+					Namespace:     namespace,
+					Labels:        condition.GetRoutingLabels(),
+					Annotations:   condition.GetRoutingAnnotations(),
+				})
+			},
+			resolveHook: func(ctx context.Context, conditionId string, labels, annotations map[string]string) {
+				lg.Debug("resolve ingestion quota condition")
+				_, _ = p.ResolveAlerts(ctx, &alertingv1.ResolveAlertsRequest{
+					ConditionId:   &corev1.Reference{Id: conditionId},
+					ConditionName: conditionName,
+					Namespace:     namespace,
+					Labels:        condition.GetRoutingLabels(),
+					Annotations:   condition.GetRoutingAnnotations(),
+				})
+			},
+		},
+	)
+	// handles re-entrant conditions
+	evaluator.CalculateInitialState()
+	go func() {
+		defer cancel() // cancel parent context, if we return (non-recoverable)
+		evaluator.SubscriberLoop()
+	}()
+	// spawn a watcher for the incidents
+	go func() {
+		evaluator.EvaluateLoop()
+	}()
+	p.msgNode.AddSystemConfigListener(conditionId, messaging.EvaluatorContext{
+		Ctx:    evaluator.evaluationCtx,
+		Cancel: evaluator.cancelEvaluation,
+	})
+	return nil
+}
+
+// reduceStaleCluster reports the cluster as unhealthy while it is marked as
+// stale by the gateway's stale cluster policy.
+func reduceStaleCluster(cluster *corev1.Cluster) (healthy bool, ts *timestamppb.Timestamp) {
+	value, ok := cluster.GetLabels()[corev1.StaleSinceLabel]
+	if !ok {
+		return true, timestamppb.Now()
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return false, timestamppb.New(time.Unix(seconds, 0))
+	}
+	return false, timestamppb.Now()
+}
+
+func (p *Plugin) onStaleClusterCreate(conditionId, conditionName, namespace string, condition *alertingv1.AlertCondition) error {
+	lg := p.Logger.With("onStaleClusterCreate", conditionId)
+	sc := condition.GetAlertType().GetStaleCluster()
+	lg.Debugf("received condition update: %v", condition)
+	jsCtx, cancel := context.WithCancel(p.Ctx)
+	lg.Debug("Creating stale cluster condition")
+
+	evaluator := NewInternalConditionEvaluator(
+		&internalConditionMetadata{
+			conditionId:        conditionId,
+			conditionName:      conditionName,
+			lg:                 lg,
+			clusterId:          sc.GetClusterId().GetId(),
+			alertmanagerlabels: map[string]string{},
+		},
+		&internalConditionContext{
+			parentCtx:        p.Ctx,
+			evaluationCtx:    jsCtx,
+			evaluateInterval: time.Minute,
+			cancelEvaluation: cancel,
+			// the stale cluster policy already waits for the configured number
+			// of days before marking a cluster as stale
+			evaluateDuration: 0,
+		},
+		&internalConditionStorage{
+			js:               p.js.Get(),
+			durableConsumer:  nil,
+			streamSubject:    NewStaleClusterSubject(sc.GetClusterId().GetId()),
+			storageClientSet: p.storageClientSet.Get(),
+			msgCh:            make(chan *nats.Msg, 32),
+		},
+		&internalConditionState{},
+		&internalConditionHooks[*corev1.Cluster]{
+			healthOnMessage: reduceStaleCluster,
+			triggerHook: func(ctx context.Context, conditionId string, labels, annotations map[string]string) {
+				_, _ = p.TriggerAlerts(ctx, &alertingv1.TriggerAlertsRequest{
+					ConditionId:   &corev1.Reference{Id: conditionId},
+					ConditionName: conditionName,
+					Namespace:     namespace,
+					Labels:        condition.GetRoutingLabels(),
+					Annotations:   condition.GetRoutingAnnotations(),
+				})
+			},
+			resolveHook: func(ctx context.Context, conditionId string, labels, annotations map[string]string) {
+				lg.Debug("resolve stale cluster condition")
+				_, _ = p.ResolveAlerts(ctx, &alertingv1.ResolveAlertsRequest{
+					ConditionId:   &corev1.Reference{Id: conditionId},
+					ConditionName: conditionName,
+					Namespace:     namespace,
+					Labels:        condition.GetRoutingLabels(),
+					Annotations:   condition.GetRoutingAnnotations(),
+				})
+			},
+		},
+	)
+	// handles re-entrant conditions
+	evaluator.CalculateInitialState()
+	go func() {
+		defer cancel() // cancel parent context, if we return (non-recoverable)
+		evaluator.SubscriberLoop()
+	}()
+	// spawn a watcher for the incidents
+	go func() {
+		evaluator.EvaluateLoop()
+	}()
+	p.msgNode.AddSystemConfigListener(conditionId, messaging.EvaluatorContext{
+		Ctx:    evaluator.evaluationCtx,
+		Cancel: evaluator.cancelEvaluation,
+	})
+	return nil
+}
@@ -47,6 +47,10 @@ func handleChoicesByType(
 		return p.fetchPrometheusQueryInfo(ctx)
 	case alertingv1.AlertType_MonitoringBackend:
 		return p.fetchMonitoringBackendInfo(ctx)
+	case alertingv1.AlertType_IngestionQuota:
+		return p.fetchIngestionQuotaInfo(ctx)
+	case alertingv1.AlertType_StaleCluster:
+		return p.fetchStaleClusterInfo(ctx)
 	default:
 		return nil, shared.AlertingErrNotImplemented
 	}
@@ -684,3 +688,54 @@ func (p *Plugin) fetchMonitoringBackendInfo(ctx context.Context) (*alertingv1.Li
 		},
 	}, nil
 }
+
+func (p *Plugin) fetchIngestionQuotaInfo(ctx context.Context) (*alertingv1.ListAlertTypeDetails, error) {
+	ctxca, ca := context.WithTimeout(ctx, time.Second*3)
+	defer ca()
+	quotaClient, err := p.quotaClient.GetContext(ctxca)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire ingest quotas client %s", err)
+	}
+	usage, err := quotaClient.AllClusterUsage(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ingestion quota usage %s", err)
+	}
+	clusters := []string{}
+	for _, item := range usage.GetItems() {
+		if !item.GetLimits().IsEmpty() {
+			clusters = append(clusters, item.GetClusterId())
+		}
+	}
+	return &alertingv1.ListAlertTypeDetails{
+		Type: &alertingv1.ListAlertTypeDetails_IngestionQuota{
+			IngestionQuota: &alertingv1.ListAlertConditionIngestionQuota{
+				Clusters: clusters,
+				Limits:   shared.IngestionQuotaLimits,
+			},
+		},
+	}, nil
+}
+
+func (p *Plugin) fetchStaleClusterInfo(ctx context.Context) (*alertingv1.ListAlertTypeDetails, error) {
+	ctxCa, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	mgmtClient, err := p.mgmtClient.GetContext(ctxCa)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := mgmtClient.ListClusters(ctxCa, &managementv1.ListClustersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	resStaleCluster := &alertingv1.ListAlertConditionStaleCluster{
+		Clusters: []string{},
+	}
+	for _, cl := range clusters.Items {
+		resStaleCluster.Clusters = append(resStaleCluster.Clusters, cl.Id)
+	}
+	return &alertingv1.ListAlertTypeDetails{
+		Type: &alertingv1.ListAlertTypeDetails_StaleCluster{
+			StaleCluster: resStaleCluster,
+		},
+	}, nil
+}
@@ -53,6 +53,9 @@ func (p *Plugin) newClusterWatcherHooks(ctx context.Context, ingressStream *nats
 	if err != nil {
 		panic(err)
 	}
+	if err := natsutil.NewPersistentStream(p.js.Get(), NewStaleClusterStream()); err != nil {
+		panic(err)
+	}
 	cw := NewDefaultClusterWatcherHooks[*managementv1.WatchEvent](ctx)
 	cw.RegisterEvent(
 		createClusterEvent,
@@ -80,6 +83,17 @@ func (p *Plugin) newClusterWatcherHooks(ctx context.Context, ingressStream *nats
 		func(ctx context.Context, event *managementv1.WatchEvent) error {
 			return p.onDeleteClusterCapabilityHook(ctx, event.Cluster.Id)
 		},
+		func(ctx context.Context, event *managementv1.WatchEvent) error {
+			return p.onDeleteClusterStaleClusterHook(ctx, event.Cluster.Id)
+		},
+	)
+	cw.RegisterEvent(
+		func(event *managementv1.WatchEvent) bool {
+			return !deleteClusterEvent(event)
+		},
+		func(ctx context.Context, event *managementv1.WatchEvent) error {
+			return p.publishStaleClusterStatus(event.Cluster)
+		},
 	)
 	return cw
 }
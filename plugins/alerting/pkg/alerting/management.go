@@ -17,6 +17,7 @@ import (
 	"github.com/rancher/opni/pkg/health"
 	"github.com/rancher/opni/plugins/alerting/pkg/alerting/drivers"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexadmin"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/quota"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -171,6 +172,73 @@ func (p *Plugin) watchCortexClusterStatus() {
 	}
 }
 
+// blocking
+func (p *Plugin) watchIngestionQuotaUsage() {
+	lg := p.Logger.With("watcher", "ingestion-quota-usage")
+	err := natsutil.NewPersistentStream(p.js.Get(), NewIngestionQuotaStream())
+	if err != nil {
+		panic(err)
+	}
+	var quotaClient quota.IngestQuotasClient
+	for {
+		ctxca, ca := context.WithTimeout(p.Ctx, 5*time.Second)
+		acquiredClient, err := p.quotaClient.GetContext(ctxca)
+		ca()
+		if err != nil {
+			lg.Warn("could not acquire ingest quotas client within timeout, retrying...")
+		} else {
+			quotaClient = acquiredClient
+			break
+		}
+	}
+
+	ticker := time.NewTicker(60 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.Ctx.Done():
+			lg.Debug("closing ingestion quota usage watcher...")
+			return
+		case <-ticker.C:
+			usage, err := quotaClient.AllClusterUsage(p.Ctx, &emptypb.Empty{})
+			if err != nil {
+				if status.Code(err) == codes.Unavailable {
+					lg.Debug("ingestion quota usage unavailable : metrics backend not ready")
+				} else {
+					lg.Warnf("failed to get ingestion quota usage : %v", err)
+				}
+				continue
+			}
+			for _, item := range usage.GetItems() {
+				usageData, err := json.Marshal(item)
+				if err != nil {
+					lg.Errorf("failed to marshal ingestion quota usage: %s", err)
+					continue
+				}
+				if _, err := p.js.Get().PublishAsync(NewIngestionQuotaSubject(item.GetClusterId()), usageData); err != nil {
+					lg.Errorf("failed to publish ingestion quota usage : %s", err)
+				}
+			}
+		}
+	}
+}
+
+// publishStaleClusterStatus publishes the cluster to its stale cluster
+// subject, so that stale cluster conditions observe when the gateway's stale
+// cluster policy marks or unmarks the cluster as stale
+func (p *Plugin) publishStaleClusterStatus(cluster *corev1.Cluster) error {
+	clusterData, err := json.Marshal(cluster)
+	if err != nil {
+		p.Logger.Errorf("failed to marshal cluster: %s", err)
+		return err
+	}
+	if _, err := p.js.Get().PublishAsync(NewStaleClusterSubject(cluster.GetId()), clusterData); err != nil {
+		p.Logger.Errorf("failed to publish stale cluster status : %s", err)
+		return err
+	}
+	return nil
+}
+
 // blocking
 func (p *Plugin) watchGlobalCluster(
 	client managementv1.ManagementClient,
@@ -50,3 +50,31 @@ func NewCortexStatusStream() *nats.StreamConfig {
 func NewCortexStatusSubject() string {
 	return fmt.Sprintf("%s.%s", shared.CortexStatusStream, "cortex")
 }
+
+func NewIngestionQuotaStream() *nats.StreamConfig {
+	return &nats.StreamConfig{
+		Name:      shared.IngestionQuotaStream,
+		Subjects:  []string{shared.IngestionQuotaStreamSubjects},
+		Retention: nats.LimitsPolicy,
+		MaxAge:    1 * time.Hour,
+		MaxBytes:  1 * 1024 * 50, //50KB
+	}
+}
+
+func NewIngestionQuotaSubject(clusterId string) string {
+	return fmt.Sprintf("%s.%s", shared.IngestionQuotaStream, clusterId)
+}
+
+func NewStaleClusterStream() *nats.StreamConfig {
+	return &nats.StreamConfig{
+		Name:      shared.StaleClusterStream,
+		Subjects:  []string{shared.StaleClusterStreamSubjects},
+		Retention: nats.LimitsPolicy,
+		MaxAge:    1 * time.Hour,
+		MaxBytes:  1 * 1024 * 50, //50KB
+	}
+}
+
+func NewStaleClusterSubject(clusterId string) string {
+	return fmt.Sprintf("%s.%s", shared.StaleClusterStream, clusterId)
+}
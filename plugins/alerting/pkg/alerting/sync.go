@@ -198,3 +198,30 @@ func (p *Plugin) onDeleteClusterCapabilityHook(ctx context.Context, clusterId st
 	wg.Wait()
 	return nil
 }
+
+func (p *Plugin) onDeleteClusterStaleClusterHook(ctx context.Context, clusterId string) error {
+	conditions, err := p.storageClientSet.Get().Conditions().List(p.Ctx, opts.WithUnredacted())
+	if err != nil {
+		p.Logger.Errorf("failed to list conditions from storage : %s", err)
+	}
+	var wg sync.WaitGroup
+	for _, cond := range conditions {
+		cond := cond
+		if sc := cond.GetAlertType().GetStaleCluster(); sc != nil {
+			if sc.GetClusterId().GetId() == clusterId {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err = p.DeleteAlertCondition(ctx, &corev1.Reference{
+						Id: cond.Id,
+					})
+					if err != nil {
+						p.Logger.Errorf("failed to delete condition %s : %s", cond.Id, err)
+					}
+				}()
+			}
+		}
+	}
+	wg.Wait()
+	return nil
+}
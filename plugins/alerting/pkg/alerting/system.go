@@ -10,6 +10,7 @@ import (
 	"github.com/rancher/opni/pkg/alerting/storage/broker_init"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexadmin"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexops"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/quota"
 
 	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
 	"github.com/rancher/opni/pkg/config/v1beta1"
@@ -89,15 +90,17 @@ func (p *Plugin) UseManagementAPI(client managementv1.ManagementClient) {
 
 func (p *Plugin) UseWatchers(client managementv1.ManagementClient) {
 	cw := p.newClusterWatcherHooks(p.Ctx, NewAgentStream())
-	clusterCrud, clusterHealthStatus, cortexBackendStatus :=
+	clusterCrud, clusterHealthStatus, cortexBackendStatus, ingestionQuotaUsage :=
 		func() { p.watchGlobalCluster(client, cw) },
 		func() { p.watchGlobalClusterHealthStatus(client, NewAgentStream()) },
-		func() { p.watchCortexClusterStatus() }
+		func() { p.watchCortexClusterStatus() },
+		func() { p.watchIngestionQuotaUsage() }
 
 	p.globalWatchers = NewSimpleInternalConditionWatcher(
 		clusterCrud,
 		clusterHealthStatus,
 		cortexBackendStatus,
+		ingestionQuotaUsage,
 	)
 	p.globalWatchers.WatchEvents()
 }
@@ -163,4 +166,10 @@ func (p *Plugin) UseAPIExtensions(intf system.ExtensionClientInterface) {
 		os.Exit(1)
 	}
 	p.cortexOpsClient.Set(cortexops.NewCortexOpsClient(ccCortexOps))
+	ccQuotas, err := intf.GetClientConn(p.Ctx, "IngestQuotas")
+	if err != nil {
+		p.Logger.With("err", err).Error("failed to get ingest quotas client")
+		os.Exit(1)
+	}
+	p.quotaClient.Set(quota.NewIngestQuotasClient(ccQuotas))
 }
@@ -0,0 +1,285 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/node"
+)
+
+const (
+	defaultRemoteWriteBufferDir     = "/var/lib/opni-agent/remote-write-buffer"
+	defaultRemoteWriteBufferMaxSize = 512 << 20
+	defaultRemoteWriteBufferMaxAge  = 2 * time.Hour
+
+	bufferFileExt = ".payload"
+)
+
+var ErrBufferFull = errors.New("remote write buffer is full")
+
+// RemoteWriteBuffer is an on-disk queue of remote-write payloads. Each payload
+// is stored in its own file, named after the time it was received, so that
+// payloads can be replayed in order after the agent restarts.
+type RemoteWriteBuffer struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	logger  *zap.SugaredLogger
+
+	mu      sync.Mutex
+	entries []bufferEntry
+	size    int64
+	lastSeq int64
+	full    bool
+	modTime time.Time
+	notify  chan struct{}
+}
+
+type bufferEntry struct {
+	seq  int64
+	size int64
+}
+
+func (e bufferEntry) filename() string {
+	return fmt.Sprintf("%020d%s", e.seq, bufferFileExt)
+}
+
+func (e bufferEntry) received() time.Time {
+	return time.Unix(0, e.seq)
+}
+
+// NewRemoteWriteBuffer creates a buffer from the given spec, loading any
+// payloads which were already stored in the buffer's directory.
+func NewRemoteWriteBuffer(spec *node.RemoteWriteBufferSpec, lg *zap.SugaredLogger) (*RemoteWriteBuffer, error) {
+	b := &RemoteWriteBuffer{
+		dir:     spec.GetDir(),
+		maxSize: spec.GetMaxSizeBytes(),
+		maxAge:  spec.GetMaxAge().AsDuration(),
+		logger:  lg,
+		notify:  make(chan struct{}, 1),
+	}
+	if b.dir == "" {
+		b.dir = defaultRemoteWriteBufferDir
+	}
+	if b.maxSize <= 0 {
+		b.maxSize = defaultRemoteWriteBufferMaxSize
+	}
+	if b.maxAge <= 0 {
+		b.maxAge = defaultRemoteWriteBufferMaxAge
+	}
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create remote write buffer directory: %w", err)
+	}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *RemoteWriteBuffer) load() error {
+	dirEntries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read remote write buffer directory: %w", err)
+	}
+	for _, de := range dirEntries {
+		name := de.Name()
+		if de.IsDir() {
+			continue
+		}
+		if !strings.HasSuffix(name, bufferFileExt) {
+			// left over from an incomplete write
+			os.Remove(filepath.Join(b.dir, name))
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, bufferFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			return err
+		}
+		b.entries = append(b.entries, bufferEntry{seq: seq, size: info.Size()})
+		b.size += info.Size()
+	}
+	sort.Slice(b.entries, func(i, j int) bool {
+		return b.entries[i].seq < b.entries[j].seq
+	})
+	if len(b.entries) > 0 {
+		b.lastSeq = b.entries[len(b.entries)-1].seq
+		b.logger.With(
+			"payloads", len(b.entries),
+			"bytes", b.size,
+		).Info("loaded buffered remote write payloads")
+		b.signal()
+	}
+	return nil
+}
+
+// Push appends a payload to the buffer. It returns ErrBufferFull if the
+// payload would cause the buffer to exceed its maximum size.
+func (b *RemoteWriteBuffer) Push(data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireLocked(time.Now())
+	if b.size+int64(len(data)) > b.maxSize {
+		b.full = true
+		return ErrBufferFull
+	}
+
+	// sequence numbers are timestamps, but must be strictly increasing
+	seq := time.Now().UnixNano()
+	if seq <= b.lastSeq {
+		seq = b.lastSeq + 1
+	}
+	entry := bufferEntry{seq: seq, size: int64(len(data))}
+	path := filepath.Join(b.dir, entry.filename())
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	b.entries = append(b.entries, entry)
+	b.size += entry.size
+	b.lastSeq = seq
+	b.modTime = time.Now()
+	b.signal()
+	return nil
+}
+
+// Replay sends buffered payloads to the given push function, oldest first,
+// removing each payload once it has been sent. If push returns an error,
+// replay stops and the payload remains at the front of the buffer. Replay
+// must not be called concurrently.
+func (b *RemoteWriteBuffer) Replay(ctx context.Context, push func(context.Context, []byte) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.expireLocked(time.Now())
+		if len(b.entries) == 0 {
+			b.mu.Unlock()
+			return nil
+		}
+		entry := b.entries[0]
+		b.mu.Unlock()
+
+		data, err := os.ReadFile(filepath.Join(b.dir, entry.filename()))
+		if err != nil {
+			b.logger.With(
+				zap.Error(err),
+				"payload", entry.filename(),
+			).Warn("discarding unreadable buffered payload")
+		} else if err := push(ctx, data); err != nil {
+			return err
+		}
+
+		// the entry may have expired while it was being pushed
+		b.mu.Lock()
+		b.removeSeqLocked(entry.seq)
+		b.mu.Unlock()
+	}
+}
+
+// Notify returns a channel which receives a value when payloads are added
+// to the buffer.
+func (b *RemoteWriteBuffer) Notify() <-chan struct{} {
+	return b.notify
+}
+
+// Stats returns the number of buffered payloads, their total size in bytes,
+// and whether any payloads have been rejected since the buffer last had
+// space available.
+func (b *RemoteWriteBuffer) Stats() (count int, size int64, full bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries), b.size, b.full
+}
+
+// LastModified returns the time payloads were last added to or removed from
+// the buffer.
+func (b *RemoteWriteBuffer) LastModified() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.modTime
+}
+
+// Len returns the number of buffered payloads.
+func (b *RemoteWriteBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+func (b *RemoteWriteBuffer) signal() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+// expireLocked discards payloads older than the maximum age.
+func (b *RemoteWriteBuffer) expireLocked(now time.Time) {
+	n := 0
+	for n < len(b.entries) && now.Sub(b.entries[n].received()) > b.maxAge {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	b.logger.With(
+		"payloads", n,
+		"maxAge", b.maxAge,
+	).Warn("discarding expired buffered payloads")
+	b.removeLocked(n)
+}
+
+// removeLocked removes the first n entries from the buffer.
+func (b *RemoteWriteBuffer) removeLocked(n int) {
+	for _, entry := range b.entries[:n] {
+		b.deleteLocked(entry)
+	}
+	b.entries = b.entries[n:]
+	b.full = false
+	b.modTime = time.Now()
+}
+
+// removeSeqLocked removes the entry with the given sequence number from the
+// buffer, if it is still present.
+func (b *RemoteWriteBuffer) removeSeqLocked(seq int64) {
+	i := sort.Search(len(b.entries), func(i int) bool {
+		return b.entries[i].seq >= seq
+	})
+	if i == len(b.entries) || b.entries[i].seq != seq {
+		return
+	}
+	b.deleteLocked(b.entries[i])
+	b.entries = append(b.entries[:i], b.entries[i+1:]...)
+	b.full = false
+	b.modTime = time.Now()
+}
+
+func (b *RemoteWriteBuffer) deleteLocked(entry bufferEntry) {
+	if err := os.Remove(filepath.Join(b.dir, entry.filename())); err != nil && !errors.Is(err, os.ErrNotExist) {
+		b.logger.With(
+			zap.Error(err),
+			"payload", entry.filename(),
+		).Warn("failed to remove buffered payload")
+	}
+	b.size -= entry.size
+}
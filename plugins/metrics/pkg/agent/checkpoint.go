@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultImportCheckpointDir is where import checkpoints are stored, so that
+// imports can be resumed after the agent restarts. The agent chart mounts a
+// subdirectory of the agent's persistent volume here.
+const DefaultImportCheckpointDir = "/var/lib/opni-agent/import-checkpoints"
+
+// importCheckpoint records the number of contiguous chunks of an import which
+// have been written, starting from the beginning of the import.
+type importCheckpoint struct {
+	// Key identifies the import the checkpoint was recorded for. A checkpoint
+	// is only used to resume an import with a matching key.
+	Key             string `json:"key"`
+	CompletedChunks int64  `json:"completedChunks"`
+}
+
+// checkpointKey identifies an import by the target endpoint, the query, and
+// the chunk duration. If any of these change, previously completed chunks no
+// longer line up with the new import and it must start from the beginning.
+func checkpointKey(run *TargetRunMetadata) (string, error) {
+	query, err := proto.MarshalOptions{Deterministic: true}.Marshal(run.Query)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write([]byte(run.Target.GetSpec().GetEndpoint()))
+	hash.Write(query)
+	hash.Write(binary.BigEndian.AppendUint64(nil, uint64(run.ChunkDurationMillis)))
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// checkpointStore keeps import checkpoints in memory and, if a directory is
+// configured, persists them to disk as one json file per target.
+type checkpointStore struct {
+	dir string
+
+	mu    sync.Mutex
+	inner map[string]*importCheckpoint
+}
+
+func newCheckpointStore(dir string) *checkpointStore {
+	return &checkpointStore{
+		dir:   dir,
+		inner: make(map[string]*importCheckpoint),
+	}
+}
+
+func (s *checkpointStore) path(name string) string {
+	return filepath.Join(s.dir, url.PathEscape(name)+".json")
+}
+
+func (s *checkpointStore) Get(name string) (*importCheckpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if checkpoint, ok := s.inner[name]; ok {
+		return checkpoint, nil
+	}
+	if s.dir == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(s.path(name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read import checkpoint: %w", err)
+	}
+	checkpoint := &importCheckpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse import checkpoint: %w", err)
+	}
+	s.inner[name] = checkpoint
+	return checkpoint, nil
+}
+
+func (s *checkpointStore) Put(name string, checkpoint *importCheckpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inner[name] = checkpoint
+	if s.dir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first so a partially written checkpoint is
+	// never read back
+	tmp := s.path(name) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write import checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, s.path(name)); err != nil {
+		return fmt.Errorf("failed to write import checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (s *checkpointStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inner, name)
+	if s.dir == "" {
+		return nil
+	}
+
+	if err := os.Remove(s.path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete import checkpoint: %w", err)
+	}
+	return nil
+}
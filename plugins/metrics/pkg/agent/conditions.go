@@ -3,4 +3,12 @@ package agent
 const (
 	CondRemoteWrite = "Remote Write"
 	CondRuleSync    = "Rule Sync"
+
+	CondRemoteWriteBuffer = "Remote Write Buffer"
+)
+
+const (
+	// Health annotations reporting the depth of the remote write buffer
+	AnnotationRemoteWriteBufferPayloads = "metrics.opni.io/remote-write-buffer-payloads"
+	AnnotationRemoteWriteBufferBytes    = "metrics.opni.io/remote-write-buffer-bytes"
 )
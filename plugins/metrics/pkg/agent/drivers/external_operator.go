@@ -394,11 +394,50 @@ func (d *ExternalPromOperatorDriver) DiscoverPrometheuses(ctx context.Context, n
 	}
 
 	return lo.Map(list.Items, func(prom *monitoringcoreosv1.Prometheus, _ int) *remoteread.DiscoveryEntry {
+		internalEndpoint := fmt.Sprintf("%s.%s.svc.cluster.local", prom.Name, prom.Namespace)
 		return &remoteread.DiscoveryEntry{
 			Name:             prom.Name,
 			ClusterId:        "", // populated by the gateway
 			ExternalEndpoint: prom.Spec.ExternalURL,
-			InternalEndpoint: fmt.Sprintf("%s.%s.svc.cluster.local", prom.Name, prom.Namespace),
+			InternalEndpoint: internalEndpoint,
+			Tls:              d.discoverWebTLS(ctx, prom, internalEndpoint),
 		}
 	}), nil
 }
+
+// discoverWebTLS returns the TLS settings needed to connect to a Prometheus
+// with TLS enabled on its web server. The CA certificate is filled in if the
+// server certificate is stored alongside a "ca.crt" key, as is the case for
+// certificates issued by cert-manager.
+func (d *ExternalPromOperatorDriver) discoverWebTLS(ctx context.Context, prom *monitoringcoreosv1.Prometheus, serverName string) *remoteread.TargetTLS {
+	if prom.Spec.Web == nil || prom.Spec.Web.TLSConfig == nil {
+		return nil
+	}
+	tlsSpec := &remoteread.TargetTLS{
+		ServerName: serverName,
+	}
+	cert := prom.Spec.Web.TLSConfig.Cert
+	switch {
+	case cert.Secret != nil:
+		secret := &corev1.Secret{}
+		if err := d.k8sClient.Get(ctx, client.ObjectKey{Namespace: prom.Namespace, Name: cert.Secret.Name}, secret); err != nil {
+			d.logger.With(
+				"prometheus", prom.Name,
+				"error", err,
+			).Debug("could not look up prometheus web tls certificate")
+			break
+		}
+		tlsSpec.CaCertData = string(secret.Data["ca.crt"])
+	case cert.ConfigMap != nil:
+		cm := &corev1.ConfigMap{}
+		if err := d.k8sClient.Get(ctx, client.ObjectKey{Namespace: prom.Namespace, Name: cert.ConfigMap.Name}, cm); err != nil {
+			d.logger.With(
+				"prometheus", prom.Name,
+				"error", err,
+			).Debug("could not look up prometheus web tls certificate")
+			break
+		}
+		tlsSpec.CaCertData = cm.Data["ca.crt"]
+	}
+	return tlsSpec
+}
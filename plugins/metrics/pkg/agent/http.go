@@ -1,10 +1,14 @@
 package agent
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"sync/atomic"
 
@@ -12,14 +16,19 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/valyala/bytebufferpool"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/rancher/opni/pkg/clients"
 	"github.com/rancher/opni/pkg/health"
 	"github.com/rancher/opni/pkg/plugins/apis/apiextensions"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/node"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/remotewrite"
 )
 
+const bufferReplayRetryInterval = 5 * time.Second
+
 type HttpServer struct {
 	apiextensions.UnsafeHTTPAPIExtensionServer
 
@@ -31,6 +40,11 @@ type HttpServer struct {
 	conditions health.ConditionTracker
 
 	enabled atomic.Bool
+
+	bufferMu   sync.Mutex
+	buffer     *RemoteWriteBuffer
+	bufferSpec *node.RemoteWriteBufferSpec
+	stopReplay context.CancelFunc
 }
 
 func NewHttpServer(ct health.ConditionTracker, lg *zap.SugaredLogger) *HttpServer {
@@ -66,37 +80,65 @@ func (s *HttpServer) handleMetricPushRequest(c *gin.Context) {
 		c.Status(http.StatusServiceUnavailable)
 		return
 	}
+
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	if _, err := buf.ReadFrom(c.Request.Body); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	buffer := s.getRemoteWriteBuffer()
+	if buffer != nil && buffer.Len() > 0 {
+		// payloads must be sent in order, so new payloads are queued behind
+		// any buffered payloads until the buffer has been drained
+		s.bufferPayload(c, buffer, buf.B)
+		return
+	}
+
 	s.remoteWriteClientMu.RLock()
 	defer s.remoteWriteClientMu.RUnlock()
 	if s.remoteWriteClient == nil {
+		if buffer != nil {
+			s.bufferPayload(c, buffer, buf.B)
+			return
+		}
 		c.Status(http.StatusServiceUnavailable)
 		return
 	}
 	ok := s.remoteWriteClient.Use(func(rwc remotewrite.RemoteWriteClient) {
 		if rwc == nil {
 			s.conditions.Set(CondRemoteWrite, health.StatusPending, "gateway not connected")
+			if buffer != nil {
+				s.bufferPayload(c, buffer, buf.B)
+				return
+			}
 			c.Error(errors.New("gateway not connected"))
 			c.String(http.StatusServiceUnavailable, "gateway not connected")
 			return
 		}
 
-		buf := bytebufferpool.Get()
-		if _, err := buf.ReadFrom(c.Request.Body); err != nil {
-			c.Status(http.StatusInternalServerError)
-			return
-		}
 		_, err := rwc.Push(c.Request.Context(), &remotewrite.Payload{
 			Contents: buf.B,
 		})
-		bytebufferpool.Put(buf)
 
 		var respCode int
 		if err != nil {
 			stat := status.Convert(err)
-			// check if statusCode is a valid HTTP status code
-			if stat.Code() >= 100 && stat.Code() <= 599 {
+			if buffer != nil && stat.Code() == codes.Unavailable {
+				s.conditions.Set(CondRemoteWrite, health.StatusPending, stat.Message())
+				s.bufferPayload(c, buffer, buf.B)
+				return
+			}
+			switch {
+			case stat.Code() == codes.ResourceExhausted:
+				// an ingestion quota was exceeded; prometheus will back off and
+				// retry on 429
+				respCode = http.StatusTooManyRequests
+			case stat.Code() >= 100 && stat.Code() <= 599:
+				// check if statusCode is a valid HTTP status code
 				respCode = int(stat.Code())
-			} else {
+			default:
 				respCode = http.StatusServiceUnavailable
 			}
 			// As a special case, status code 400 may indicate a success.
@@ -107,10 +149,7 @@ func (s *HttpServer) handleMetricPushRequest(c *gin.Context) {
 			// will be cleared as if the request succeeded.
 			message := stat.Message()
 			if respCode == http.StatusBadRequest {
-				if strings.Contains(message, "out of bounds") ||
-					strings.Contains(message, "out of order sample") ||
-					strings.Contains(message, "duplicate sample for timestamp") ||
-					strings.Contains(message, "exemplars not ingested because series not already present") {
+				if isSoftError(message) {
 					{
 						s.conditions.Clear(CondRemoteWrite)
 						c.Error(errors.New("soft error (request succeeded): " + message))
@@ -130,6 +169,179 @@ func (s *HttpServer) handleMetricPushRequest(c *gin.Context) {
 	})
 
 	if !ok {
+		if buffer != nil {
+			s.bufferPayload(c, buffer, buf.B)
+			return
+		}
 		c.Status(http.StatusServiceUnavailable)
 	}
 }
+
+func isSoftError(message string) bool {
+	return strings.Contains(message, "out of bounds") ||
+		strings.Contains(message, "out of order sample") ||
+		strings.Contains(message, "duplicate sample for timestamp") ||
+		strings.Contains(message, "exemplars not ingested because series not already present")
+}
+
+// SetRemoteWriteBuffer enables, reconfigures, or disables (if spec is nil or
+// not enabled) the on-disk buffer for payloads received while the gateway is
+// unreachable. Buffered payloads are replayed in the background until the
+// context is canceled.
+func (s *HttpServer) SetRemoteWriteBuffer(ctx context.Context, spec *node.RemoteWriteBufferSpec) {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+
+	if !spec.GetEnabled() {
+		spec = nil
+	}
+	if proto.Equal(spec, s.bufferSpec) {
+		return
+	}
+	if s.stopReplay != nil {
+		s.stopReplay()
+		s.stopReplay = nil
+	}
+	s.buffer = nil
+	s.bufferSpec = spec
+	s.conditions.Clear(CondRemoteWriteBuffer)
+	if spec == nil {
+		return
+	}
+
+	buffer, err := NewRemoteWriteBuffer(spec, s.logger.Named("buffer"))
+	if err != nil {
+		s.logger.With(
+			zap.Error(err),
+		).Error("failed to initialize remote write buffer")
+		s.conditions.Set(CondRemoteWriteBuffer, health.StatusFailure, err.Error())
+		return
+	}
+	s.buffer = buffer
+	replayCtx, ca := context.WithCancel(ctx)
+	s.stopReplay = ca
+	go s.runReplay(replayCtx, buffer)
+}
+
+func (s *HttpServer) getRemoteWriteBuffer() *RemoteWriteBuffer {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+	return s.buffer
+}
+
+// HealthAnnotations reports the number and total size of buffered payloads,
+// and the time they last changed. It returns nil if buffering is disabled.
+func (s *HttpServer) HealthAnnotations() (map[string]string, time.Time) {
+	buffer := s.getRemoteWriteBuffer()
+	if buffer == nil {
+		return nil, time.Time{}
+	}
+	count, size, _ := buffer.Stats()
+	return map[string]string{
+		AnnotationRemoteWriteBufferPayloads: strconv.Itoa(count),
+		AnnotationRemoteWriteBufferBytes:    strconv.FormatInt(size, 10),
+	}, buffer.LastModified()
+}
+
+func (s *HttpServer) bufferPayload(c *gin.Context, buffer *RemoteWriteBuffer, data []byte) {
+	err := buffer.Push(data)
+	s.updateBufferCondition(buffer)
+	switch {
+	case errors.Is(err, ErrBufferFull):
+		// prometheus will retry the request
+		c.String(http.StatusServiceUnavailable, err.Error())
+	case err != nil:
+		s.logger.With(
+			zap.Error(err),
+		).Error("failed to buffer remote write payload")
+		c.Error(err)
+		c.String(http.StatusInternalServerError, err.Error())
+	default:
+		c.Status(http.StatusOK)
+	}
+}
+
+func (s *HttpServer) updateBufferCondition(buffer *RemoteWriteBuffer) {
+	count, size, full := buffer.Stats()
+	switch {
+	case full:
+		s.conditions.Set(CondRemoteWriteBuffer, health.StatusFailure,
+			fmt.Sprintf("buffer is full (%d payloads, %d bytes); new payloads are being rejected", count, size))
+	case count > 0:
+		s.conditions.Set(CondRemoteWriteBuffer, health.StatusPending,
+			fmt.Sprintf("%d payloads (%d bytes) waiting to be sent", count, size))
+	default:
+		s.conditions.Clear(CondRemoteWriteBuffer)
+	}
+}
+
+// runReplay sends buffered payloads to the gateway whenever the buffer is
+// not empty, one at a time, waiting between attempts while the gateway is
+// unreachable.
+func (s *HttpServer) runReplay(ctx context.Context, buffer *RemoteWriteBuffer) {
+	retry := time.NewTicker(bufferReplayRetryInterval)
+	defer retry.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-buffer.Notify():
+		case <-retry.C:
+		}
+		if buffer.Len() == 0 {
+			continue
+		}
+		err := buffer.Replay(ctx, s.replayPayload)
+		s.updateBufferCondition(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.With(
+				zap.Error(err),
+			).Debug("failed to replay buffered payloads, will retry")
+			// wait for the next retry instead of retrying on each new payload
+			select {
+			case <-ctx.Done():
+				return
+			case <-retry.C:
+			}
+			continue
+		}
+		s.conditions.Clear(CondRemoteWrite)
+	}
+}
+
+func (s *HttpServer) replayPayload(ctx context.Context, data []byte) error {
+	s.remoteWriteClientMu.RLock()
+	defer s.remoteWriteClientMu.RUnlock()
+	err := errors.New("gateway not connected")
+	if s.remoteWriteClient == nil {
+		return err
+	}
+	s.remoteWriteClient.Use(func(rwc remotewrite.RemoteWriteClient) {
+		if rwc == nil {
+			return
+		}
+		_, err = rwc.Push(ctx, &remotewrite.Payload{
+			Contents: data,
+		})
+	})
+	if err == nil {
+		return nil
+	}
+	stat := status.Convert(err)
+	code := int(stat.Code())
+	if code >= 400 && code < 500 && code != http.StatusTooManyRequests {
+		// the payload will never be accepted, so it should not block the
+		// remaining payloads
+		if code != http.StatusBadRequest || !isSoftError(stat.Message()) {
+			s.logger.With(
+				"code", code,
+				"message", stat.Message(),
+			).Warn("discarding buffered payload rejected by the gateway")
+		}
+		return nil
+	}
+	return err
+}
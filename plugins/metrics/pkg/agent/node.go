@@ -8,9 +8,11 @@ import (
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/remotewrite"
 	"github.com/samber/lo"
 	"net/http"
+	"os"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	capabilityv1 "github.com/rancher/opni/pkg/apis/capability/v1"
 	controlv1 "github.com/rancher/opni/pkg/apis/control/v1"
@@ -57,13 +59,25 @@ type MetricsNode struct {
 
 	nodeDriverMu sync.RWMutex
 	nodeDriver   drivers.MetricsNodeDriver
+
+	healthAnnotatorMu sync.RWMutex
+	healthAnnotator   func() (map[string]string, time.Time)
 }
 
 func NewMetricsNode(ct health.ConditionTracker, lg *zap.SugaredLogger) *MetricsNode {
+	var runnerOptions []TargetRunnerOption
+	if err := os.MkdirAll(DefaultImportCheckpointDir, 0700); err != nil {
+		lg.With(
+			zap.Error(err),
+		).Warn("import checkpoints will not be persisted; imports will not resume after the agent restarts")
+	} else {
+		runnerOptions = append(runnerOptions, WithCheckpointDir(DefaultImportCheckpointDir))
+	}
+
 	node := &MetricsNode{
 		logger:       lg,
 		conditions:   ct,
-		targetRunner: NewTargetRunner(lg),
+		targetRunner: NewTargetRunner(lg, runnerOptions...),
 	}
 	node.conditions.AddListener(node.sendHealthUpdate)
 	node.targetRunner.SetRemoteReaderClient(NewRemoteReader(&http.Client{}))
@@ -173,22 +187,43 @@ func (m *MetricsNode) GetHealth(_ context.Context, _ *emptypb.Empty) (*corev1.He
 	defer m.configMu.RUnlock()
 
 	conditions := m.conditions.List()
+	lastModified := m.conditions.LastModified()
+
+	var annotations map[string]string
+	m.healthAnnotatorMu.RLock()
+	if m.healthAnnotator != nil {
+		var modTime time.Time
+		annotations, modTime = m.healthAnnotator()
+		if modTime.After(lastModified) {
+			lastModified = modTime
+		}
+	}
+	m.healthAnnotatorMu.RUnlock()
 
 	sort.Strings(conditions)
 	return &corev1.Health{
-		Ready:      len(conditions) == 0,
-		Conditions: conditions,
-		Timestamp:  timestamppb.New(m.conditions.LastModified()),
+		Ready:       len(conditions) == 0,
+		Conditions:  conditions,
+		Timestamp:   timestamppb.New(lastModified),
+		Annotations: annotations,
 	}, nil
 }
 
+// SetHealthAnnotator sets a function which provides additional annotations
+// to include in the node's health, along with the time they last changed.
+func (m *MetricsNode) SetHealthAnnotator(fn func() (map[string]string, time.Time)) {
+	m.healthAnnotatorMu.Lock()
+	defer m.healthAnnotatorMu.Unlock()
+	m.healthAnnotator = fn
+}
+
 // Start Implements remoteread.RemoteReadServer
 
 func (m *MetricsNode) Start(_ context.Context, request *remoteread.StartReadRequest) (*emptypb.Empty, error) {
 	m.targetRunnerMu.Lock()
 	defer m.targetRunnerMu.Unlock()
 
-	if err := m.targetRunner.Start(request.Target, request.Query); err != nil {
+	if err := m.targetRunner.Start(request.Target, request.Query, request.Options); err != nil {
 		return nil, err
 	}
 
@@ -55,6 +55,7 @@ func NewPlugin(ctx context.Context) *Plugin {
 	}
 
 	p.node.AddConfigListener(drivers.NewListenerFunc(ctx, p.onConfigUpdated))
+	p.node.SetHealthAnnotator(p.httpServer.HealthAnnotations)
 
 	return p
 }
@@ -91,6 +92,12 @@ func (p *Plugin) onConfigUpdated(cfg *node.MetricsCapabilityConfig) {
 	case !currentlyRunning && !shouldRun:
 		p.logger.Debug("rule sync is disabled")
 	}
+
+	if shouldRun {
+		p.httpServer.SetRemoteWriteBuffer(p.ctx, cfg.GetSpec().GetRemoteWriteBuffer())
+	} else {
+		p.httpServer.SetRemoteWriteBuffer(p.ctx, nil)
+	}
 }
 
 func Scheme(ctx context.Context) meta.Scheme {
@@ -3,7 +3,9 @@ package agent
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"sync"
 
 	// todo: needed instead of google.golang.org/protobuf/proto since prometheus Messages are built with it
 	"github.com/golang/protobuf/proto"
@@ -11,25 +13,68 @@ import (
 	"github.com/golang/snappy"
 	"github.com/prometheus/common/version"
 	"github.com/prometheus/prometheus/prompb"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
+	protov2 "google.golang.org/protobuf/proto"
 	"io"
 	"net/http"
 )
 
 type RemoteReader interface {
-	Read(ctx context.Context, endpoint string, request *prompb.ReadRequest) (*prompb.ReadResponse, error)
+	Read(ctx context.Context, target *remoteread.TargetSpec, request *prompb.ReadRequest) (*prompb.ReadResponse, error)
 }
 
+// NewRemoteReader returns a RemoteReader which uses the given client for
+// targets without TLS settings. Targets with TLS settings use a client with
+// the same configuration, but with a transport using the target's TLS config.
 func NewRemoteReader(prometheusClient *http.Client) RemoteReader {
 	return &remoteReader{
 		prometheusClient: prometheusClient,
+		tlsClients:       map[[sha256.Size]byte]*http.Client{},
 	}
 }
 
 type remoteReader struct {
 	prometheusClient *http.Client
+
+	tlsClientsMu sync.Mutex
+	// keyed by the hash of the target's TLS settings
+	tlsClients map[[sha256.Size]byte]*http.Client
 }
 
-func (client *remoteReader) Read(ctx context.Context, endpoint string, readRequest *prompb.ReadRequest) (*prompb.ReadResponse, error) {
+func (client *remoteReader) httpClient(target *remoteread.TargetSpec) (*http.Client, error) {
+	if target.GetTls() == nil {
+		return client.prometheusClient, nil
+	}
+	data, err := protov2.MarshalOptions{Deterministic: true}.Marshal(target.GetTls())
+	if err != nil {
+		return nil, err
+	}
+	key := sha256.Sum256(data)
+
+	client.tlsClientsMu.Lock()
+	defer client.tlsClientsMu.Unlock()
+	if c, ok := client.tlsClients[key]; ok {
+		return c, nil
+	}
+	tlsConfig, err := target.GetTls().TLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid tls config: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	c := *client.prometheusClient
+	c.Transport = transport
+	client.tlsClients[key] = &c
+	return &c, nil
+}
+
+func (client *remoteReader) Read(ctx context.Context, target *remoteread.TargetSpec, readRequest *prompb.ReadRequest) (*prompb.ReadResponse, error) {
+	endpoint := target.GetEndpoint()
+	prometheusClient, err := client.httpClient(target)
+	if err != nil {
+		return nil, err
+	}
+
 	uncompressedData, err := proto.Marshal(readRequest)
 	if err != nil {
 		return nil, fmt.Errorf("unable to marshal remote read readRequest: %w", err)
@@ -48,9 +93,17 @@ func (client *remoteReader) Read(ctx context.Context, endpoint string, readReque
 	request.Header.Set("User-Agent", "Prometheus/xx")
 	request.Header.Set("X-Prometheus-Remote-Read-Version", fmt.Sprintf("Prometheus/%s", version.Version))
 
+	if auth := target.GetAuth(); auth != nil {
+		if basicAuth := auth.GetBasicAuth(); basicAuth != nil {
+			request.SetBasicAuth(basicAuth.GetUsername(), basicAuth.GetPassword())
+		} else if auth.GetBearerToken() != "" {
+			request.Header.Set("Authorization", "Bearer "+auth.GetBearerToken())
+		}
+	}
+
 	request = request.WithContext(ctx)
 
-	response, err := client.prometheusClient.Do(request)
+	response, err := prometheusClient.Do(request)
 	if err != nil {
 		return nil, fmt.Errorf("could not get response from rmeote read: %w", err)
 	}
@@ -16,6 +16,7 @@ import (
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/remotewrite"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"strings"
@@ -23,8 +24,33 @@ import (
 	"time"
 )
 
+// TimeDeltaMillis is the default duration of each import chunk.
 var TimeDeltaMillis = time.Minute.Milliseconds()
 
+// DefaultImportConcurrency is the default number of chunks imported at once.
+const DefaultImportConcurrency = 4
+
+type importChunk struct {
+	start, end int64
+}
+
+// splitImportChunks splits the range [start, end) into chunks of the given
+// duration in milliseconds. The last chunk may be shorter than the others.
+func splitImportChunks(start, end, chunkMillis int64) []importChunk {
+	var chunks []importChunk
+	for chunkStart := start; chunkStart < end; chunkStart += chunkMillis {
+		chunkEnd := chunkStart + chunkMillis
+		if chunkEnd > end {
+			chunkEnd = end
+		}
+		chunks = append(chunks, importChunk{
+			start: chunkStart,
+			end:   chunkEnd,
+		})
+	}
+	return chunks
+}
+
 func toLabelMatchers(rrLabelMatchers []*remoteread.LabelMatcher) []*prompb.LabelMatcher {
 	pbLabelMatchers := make([]*prompb.LabelMatcher, 0, len(rrLabelMatchers))
 
@@ -78,6 +104,9 @@ func getMessageFromTaskLogs(logs []*corev1.LogEntry) string {
 type TargetRunMetadata struct {
 	Target *remoteread.Target
 	Query  *remoteread.Query
+
+	ChunkDurationMillis int64
+	MaxConcurrency      int
 }
 
 type targetStore struct {
@@ -124,6 +153,8 @@ func (store *targetStore) ListKeys(_ context.Context, prefix string) ([]string,
 }
 
 type taskRunner struct {
+	checkpoints *checkpointStore
+
 	remoteWriteClient clients.Locker[remotewrite.RemoteWriteClient]
 
 	remoteReaderMu sync.RWMutex
@@ -145,87 +176,192 @@ func (tr *taskRunner) OnTaskPending(_ context.Context, _ task.ActiveTask) error
 	return nil
 }
 
-func (tr *taskRunner) OnTaskRunning(_ context.Context, activeTask task.ActiveTask) error {
+func (tr *taskRunner) OnTaskRunning(ctx context.Context, activeTask task.ActiveTask) error {
 	run := &TargetRunMetadata{}
 	activeTask.LoadTaskMetadata(run)
 
-	labelMatchers := toLabelMatchers(run.Query.Matchers)
+	if run.ChunkDurationMillis <= 0 {
+		run.ChunkDurationMillis = TimeDeltaMillis
+	}
+	if run.MaxConcurrency <= 0 {
+		run.MaxConcurrency = DefaultImportConcurrency
+	}
 
-	importEnd := run.Query.EndTimestamp.AsTime().UnixMilli()
-	nextStart := run.Query.StartTimestamp.AsTime().UnixMilli()
-	nextEnd := nextStart
+	chunks := splitImportChunks(
+		run.Query.StartTimestamp.AsTime().UnixMilli(),
+		run.Query.EndTimestamp.AsTime().UnixMilli(),
+		run.ChunkDurationMillis,
+	)
 
-	progress := &corev1.Progress{
-		Current: 0,
-		Total:   uint64(importEnd - nextStart),
+	key, err := checkpointKey(run)
+	if err != nil {
+		activeTask.AddLogEntry(zapcore.ErrorLevel, fmt.Sprintf("failed to compute import checkpoint key: %s", err))
+		return fmt.Errorf("failed to compute import checkpoint key: %w", err)
+	}
+
+	var completed int64
+	if checkpoint, err := tr.checkpoints.Get(activeTask.TaskId()); err != nil {
+		activeTask.AddLogEntry(zapcore.WarnLevel, fmt.Sprintf("ignoring import checkpoint: %s", err))
+	} else if checkpoint != nil && checkpoint.Key == key && checkpoint.CompletedChunks <= int64(len(chunks)) {
+		completed = checkpoint.CompletedChunks
+		activeTask.AddLogEntry(zapcore.InfoLevel, fmt.Sprintf("resuming import from chunk %d of %d", completed+1, len(chunks)))
 	}
 
-	activeTask.SetProgress(progress)
+	activeTask.SetProgress(&corev1.Progress{
+		Current: uint64(completed),
+		Total:   uint64(len(chunks)),
+	})
 
-	for nextStart < importEnd {
-		nextStart = nextEnd
-		nextEnd = nextStart + TimeDeltaMillis
+	labelMatchers := toLabelMatchers(run.Query.Matchers)
 
-		if nextStart >= importEnd {
-			break
+	markDone := func(i int64) {
+		activeTask.SetProgress(&corev1.Progress{
+			Current: uint64(i + 1),
+			Total:   uint64(len(chunks)),
+		})
+		if err := tr.checkpoints.Put(activeTask.TaskId(), &importCheckpoint{
+			Key:             key,
+			CompletedChunks: i + 1,
+		}); err != nil {
+			activeTask.AddLogEntry(zapcore.WarnLevel, err.Error())
 		}
+	}
 
-		if nextEnd >= importEnd {
-			nextEnd = importEnd
+	// Chunks are read concurrently, but pushed one at a time in order, since
+	// remote write rejects samples older than the latest sample of their
+	// series. Each pending read is sent to the pusher in chunk order; at most
+	// MaxConcurrency chunks are read but not yet pushed at any time.
+	type readResult struct {
+		resp *prompb.ReadResponse
+		err  error
+	}
+	eg, egCtx := errgroup.WithContext(ctx)
+	pending := make(chan chan readResult, run.MaxConcurrency-1)
+	eg.Go(func() error {
+		defer close(pending)
+		for i := completed; i < int64(len(chunks)); i++ {
+			result := make(chan readResult, 1)
+			select {
+			case pending <- result:
+			case <-egCtx.Done():
+				return nil
+			}
+			chunk := chunks[i]
+			go func() {
+				resp, err := tr.readChunk(egCtx, run.Target, labelMatchers, chunk)
+				result <- readResult{resp: resp, err: err}
+			}()
+		}
+		return nil
+	})
+	eg.Go(func() error {
+		i := completed
+		for result := range pending {
+			var read readResult
+			select {
+			case read = <-result:
+			case <-egCtx.Done():
+				return egCtx.Err()
+			}
+			if read.err != nil {
+				return read.err
+			}
+			if err := tr.pushChunk(egCtx, read.resp, activeTask); err != nil {
+				return err
+			}
+			markDone(i)
+			i++
 		}
+		return nil
+	})
 
-		readRequest := &prompb.ReadRequest{
-			Queries: []*prompb.Query{
-				{
-					StartTimestampMs: nextStart,
-					EndTimestampMs:   nextEnd,
-					Matchers:         labelMatchers,
-				},
-			},
+	if err := eg.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
+		// errors are logged here rather than in each chunk, since chunks
+		// which are canceled after the first failure would otherwise
+		// replace the original error in the target status
+		activeTask.AddLogEntry(zapcore.ErrorLevel, err.Error())
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
-		tr.remoteReaderMu.Lock()
-		readResponse, err := tr.remoteReader.Read(context.Background(), run.Target.Spec.Endpoint, readRequest)
-		tr.remoteReaderMu.Unlock()
+	if err := tr.checkpoints.Delete(activeTask.TaskId()); err != nil {
+		activeTask.AddLogEntry(zapcore.WarnLevel, err.Error())
+	}
 
-		if err != nil {
-			activeTask.AddLogEntry(zapcore.ErrorLevel, fmt.Sprintf("failed to read from target endpoint: %s", err))
-			return fmt.Errorf("failed to read from target endpoint: %w", err)
-		}
+	return nil
+}
 
-		for _, result := range readResponse.Results {
-			if len(result.Timeseries) == 0 {
-				continue
-			}
+// readChunk reads a single chunk from the target.
+func (tr *taskRunner) readChunk(
+	ctx context.Context,
+	target *remoteread.Target,
+	labelMatchers []*prompb.LabelMatcher,
+	chunk importChunk,
+) (*prompb.ReadResponse, error) {
+	readRequest := &prompb.ReadRequest{
+		Queries: []*prompb.Query{
+			{
+				StartTimestampMs: chunk.start,
+				EndTimestampMs:   chunk.end,
+				Matchers:         labelMatchers,
+			},
+		},
+	}
 
-			writeRequest := prompb.WriteRequest{
-				Timeseries: dereferenceResultTimeseries(result.Timeseries),
-			}
+	tr.remoteReaderMu.RLock()
+	remoteReader := tr.remoteReader
+	tr.remoteReaderMu.RUnlock()
 
-			uncompressed, err := proto.Marshal(&writeRequest)
-			if err != nil {
-				activeTask.AddLogEntry(zapcore.ErrorLevel, fmt.Sprintf("failed to uncompress data from target endpoint: %s", err))
-				return fmt.Errorf("failed to uncompress data from target endpoint: %w", err)
-			}
+	readResponse, err := remoteReader.Read(ctx, target.Spec, readRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from target endpoint: %w", err)
+	}
+	return readResponse, nil
+}
 
-			compressed := snappy.Encode(nil, uncompressed)
+// pushChunk pushes the results of reading a single chunk to remote write.
+func (tr *taskRunner) pushChunk(
+	ctx context.Context,
+	readResponse *prompb.ReadResponse,
+	activeTask task.ActiveTask,
+) error {
+	for _, result := range readResponse.Results {
+		if len(result.Timeseries) == 0 {
+			continue
+		}
 
-			payload := &remotewrite.Payload{
-				Contents: compressed,
-			}
+		writeRequest := prompb.WriteRequest{
+			Timeseries: dereferenceResultTimeseries(result.Timeseries),
+		}
+
+		uncompressed, err := proto.Marshal(&writeRequest)
+		if err != nil {
+			return fmt.Errorf("failed to uncompress data from target endpoint: %w", err)
+		}
 
-			tr.remoteWriteClient.Use(func(remoteWriteClient remotewrite.RemoteWriteClient) {
-				if _, err := remoteWriteClient.Push(context.Background(), payload); err != nil {
-					activeTask.AddLogEntry(zapcore.ErrorLevel, fmt.Sprintf("failed to push to remote write: %s", err))
-					return
-				}
+		compressed := snappy.Encode(nil, uncompressed)
 
-				activeTask.AddLogEntry(zapcore.DebugLevel, fmt.Sprintf("pushed %d bytes to remote write", len(payload.Contents)))
-			})
+		payload := &remotewrite.Payload{
+			Contents: compressed,
+		}
 
-			progress.Current = uint64(nextEnd)
-			activeTask.SetProgress(progress)
+		var pushErr error
+		ok := tr.remoteWriteClient.Use(func(remoteWriteClient remotewrite.RemoteWriteClient) {
+			_, pushErr = remoteWriteClient.Push(ctx, payload)
+		})
+		if !ok {
+			return fmt.Errorf("failed to push to remote write: remote write client is not available")
 		}
+		if pushErr != nil {
+			return fmt.Errorf("failed to push to remote write: %w", pushErr)
+		}
+
+		activeTask.AddLogEntry(zapcore.DebugLevel, fmt.Sprintf("pushed %d bytes to remote write", len(payload.Contents)))
 	}
 
 	return nil
@@ -243,7 +379,7 @@ func (tr *taskRunner) OnTaskCompleted(_ context.Context, activeTask task.ActiveT
 }
 
 type TargetRunner interface {
-	Start(target *remoteread.Target, query *remoteread.Query) error
+	Start(target *remoteread.Target, query *remoteread.Query, options *remoteread.ImportOptions) error
 	Stop(name string) error
 	GetStatus(name string) (*remoteread.TargetStatus, error)
 	SetRemoteWriteClient(client clients.Locker[remotewrite.RemoteWriteClient])
@@ -261,12 +397,38 @@ type taskingTargetRunner struct {
 	remoteWriteClient clients.Locker[remotewrite.RemoteWriteClient]
 }
 
-func NewTargetRunner(logger *zap.SugaredLogger) TargetRunner {
+type TargetRunnerOptions struct {
+	checkpointDir string
+}
+
+type TargetRunnerOption func(*TargetRunnerOptions)
+
+func (o *TargetRunnerOptions) apply(opts ...TargetRunnerOption) {
+	for _, op := range opts {
+		op(o)
+	}
+}
+
+// WithCheckpointDir persists import checkpoints to the given directory, so
+// that imports can be resumed after the agent restarts. If not set,
+// checkpoints are only kept in memory.
+func WithCheckpointDir(dir string) TargetRunnerOption {
+	return func(o *TargetRunnerOptions) {
+		o.checkpointDir = dir
+	}
+}
+
+func NewTargetRunner(logger *zap.SugaredLogger, opts ...TargetRunnerOption) TargetRunner {
+	options := TargetRunnerOptions{}
+	options.apply(opts...)
+
 	store := &targetStore{
 		inner: make(map[string]*corev1.TaskStatus),
 	}
 
-	runner := &taskRunner{}
+	runner := &taskRunner{
+		checkpoints: newCheckpointStore(options.checkpointDir),
+	}
 
 	controller, err := task.NewController(context.Background(), "target-runner", store, runner)
 	if err != nil {
@@ -280,7 +442,11 @@ func NewTargetRunner(logger *zap.SugaredLogger) TargetRunner {
 	}
 }
 
-func (runner *taskingTargetRunner) Start(target *remoteread.Target, query *remoteread.Query) error {
+func (runner *taskingTargetRunner) Start(target *remoteread.Target, query *remoteread.Query, options *remoteread.ImportOptions) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
 	if status, err := runner.controller.TaskStatus(target.Meta.Name); err != nil {
 		if !strings.Contains(err.Error(), "not found") {
 			return fmt.Errorf("error checking for target status: %s", err)
@@ -295,8 +461,10 @@ func (runner *taskingTargetRunner) Start(target *remoteread.Target, query *remot
 	}
 
 	if err := runner.controller.LaunchTask(target.Meta.Name, task.WithMetadata(TargetRunMetadata{
-		Target: target,
-		Query:  query,
+		Target:              target,
+		Query:               query,
+		ChunkDurationMillis: options.ChunkDurationMillis(TimeDeltaMillis),
+		MaxConcurrency:      options.Concurrency(DefaultImportConcurrency),
 	})); err != nil {
 		return fmt.Errorf("could not run target: %w", err)
 	}
@@ -351,10 +519,15 @@ func (runner *taskingTargetRunner) GetStatus(name string) (*remoteread.TargetSta
 	if taskStatus.Progress == nil {
 		statusProgress.LastReadTimestamp = statusProgress.StartTimestamp
 	} else {
-		statusProgress.LastReadTimestamp = &timestamppb.Timestamp{
-			// progress is stored in milliseconds, so we need to convert
-			Seconds: statusProgress.StartTimestamp.Seconds + int64(taskStatus.Progress.Current/1000),
+		// progress is stored as the number of contiguous chunks completed
+		statusProgress.CompletedChunks = int64(taskStatus.Progress.Current)
+		statusProgress.TotalChunks = int64(taskStatus.Progress.Total)
+
+		lastRead := taskMetadata.Query.StartTimestamp.AsTime().Add(time.Duration(statusProgress.CompletedChunks*taskMetadata.ChunkDurationMillis) * time.Millisecond)
+		if end := taskMetadata.Query.EndTimestamp.AsTime(); lastRead.After(end) {
+			lastRead = end
 		}
+		statusProgress.LastReadTimestamp = timestamppb.New(lastRead)
 	}
 
 	var state remoteread.TargetState
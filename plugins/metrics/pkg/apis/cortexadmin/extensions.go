@@ -2,10 +2,12 @@ package cortexadmin
 
 import (
 	"regexp"
+	"time"
 
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/rancher/opni/pkg/validation"
 	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func (in *LoadRuleRequest) Validate() error {
@@ -99,7 +101,7 @@ func (l *ListRulesRequest) Validate() error {
 			}
 			if hf != v1.RuleHealthGood && hf != v1.RuleHealthBad && hf != v1.RuleHealthUnknown {
 				return validation.Errorf(
-					"unsupported healthFilter, should be one of : %s", hf, v1.RuleHealthGood, v1.RuleHealthBad, v1.RuleHealthUnknown,
+					"unsupported healthFilter %s, should be one of %s, %s, %s", hf, v1.RuleHealthGood, v1.RuleHealthBad, v1.RuleHealthUnknown,
 				)
 			}
 		}
@@ -220,3 +222,63 @@ func (l *ListRulesRequest) MatchesRuleType(ruleType string) bool {
 	}
 	return len(l.RuleType) == 0 || slices.Contains(l.RuleType, ruleType)
 }
+
+const (
+	DefaultCardinalityLimit     = 10
+	DefaultCardinalityCompareTo = 24 * time.Hour
+)
+
+func (in *CardinalityRequest) Validate() error {
+	if in.Tenant == "" {
+		return validation.Error("tenant is required")
+	}
+	if in.Limit < 0 {
+		return validation.Error("limit must not be negative")
+	} else if in.Limit == 0 {
+		in.Limit = DefaultCardinalityLimit
+	}
+	if in.CompareTo == nil {
+		in.CompareTo = durationpb.New(DefaultCardinalityCompareTo)
+	} else if in.CompareTo.AsDuration() <= 0 {
+		return validation.Error("compareTo must be positive")
+	}
+	return nil
+}
+
+// SeriesGrowth returns the change in the number of series since the previous
+// snapshot.
+func (in *CardinalityReportResponse) SeriesGrowth() int64 {
+	return int64(in.GetNumSeries()) - int64(in.GetPreviousNumSeries())
+}
+
+// SeriesGrowth returns the change in the number of series for the metric
+// since the previous snapshot.
+func (in *MetricCardinality) SeriesGrowth() int64 {
+	return int64(in.GetNumSeries()) - int64(in.GetPreviousNumSeries())
+}
+
+func (in *ValidateRulesRequest) Validate() error {
+	if len(in.YamlContent) == 0 {
+		return validation.Error("yamlContent is required")
+	}
+	return nil
+}
+
+// MaxRuleTestFileSize is the maximum size of each file in a TestRulesRequest.
+const MaxRuleTestFileSize = 1 << 20 // 1 MiB
+
+func (in *TestRulesRequest) Validate() error {
+	if len(in.RulesYaml) == 0 {
+		return validation.Error("rulesYaml is required")
+	}
+	if len(in.RulesYaml) > MaxRuleTestFileSize {
+		return validation.Errorf("rulesYaml must not be larger than %d bytes", MaxRuleTestFileSize)
+	}
+	if len(in.TestsYaml) == 0 {
+		return validation.Error("testsYaml is required")
+	}
+	if len(in.TestsYaml) > MaxRuleTestFileSize {
+		return validation.Errorf("testsYaml must not be larger than %d bytes", MaxRuleTestFileSize)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package cortexops
+
+import (
+	cortexvalidation "github.com/cortexproject/cortex/pkg/util/validation"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/rancher/opni/pkg/validation"
+)
+
+func (in *TenantLimitsSpec) Validate() error {
+	if err := validation.ValidateID(in.GetId()); err != nil {
+		return err
+	}
+	if len(in.GetLimits().GetFields()) > 0 {
+		if _, err := ParseLimits(in.GetLimits()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseLimits validates per-tenant limit overrides against the Cortex limits
+// schema. Limits which are not set are left at their zero values in the
+// returned config.
+func ParseLimits(limits *structpb.Struct) (*cortexvalidation.Limits, error) {
+	data, err := protojson.Marshal(limits)
+	if err != nil {
+		return nil, validation.Errorf("invalid limits: %v", err)
+	}
+	// Limits.UnmarshalJSON rejects unknown fields
+	parsed := &cortexvalidation.Limits{}
+	if err := parsed.UnmarshalJSON(data); err != nil {
+		return nil, validation.Errorf("invalid limits: %v", err)
+	}
+	// opni always configures the distributor to shard by all labels
+	if err := parsed.Validate(true); err != nil {
+		return nil, validation.Errorf("invalid limits: %v", err)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,80 @@
+package quota
+
+import (
+	"github.com/rancher/opni/pkg/validation"
+)
+
+// Names of the limits which can be set in a quota.
+const (
+	SamplesPerSecond = "samplesPerSecond"
+	BytesPerSecond   = "bytesPerSecond"
+	ActiveSeries     = "activeSeries"
+)
+
+var LimitNames = []string{SamplesPerSecond, BytesPerSecond, ActiveSeries}
+
+// MaxActiveSeries is the largest active series limit which can be set. The
+// gateway keeps track of each active series of clusters with an active
+// series limit, so the limit also bounds the memory used to enforce it.
+const MaxActiveSeries = 1_000_000
+
+func (in *Quota) Validate() error {
+	if err := validation.ValidateID(in.GetId()); err != nil {
+		return err
+	}
+	limits := in.GetLimits()
+	if limits.GetSamplesPerSecond() < 0 || limits.GetBytesPerSecond() < 0 {
+		return validation.Error("limits must not be negative")
+	}
+	if limits.GetActiveSeries() > MaxActiveSeries {
+		return validation.Errorf("active series limit must not exceed %d", MaxActiveSeries)
+	}
+	if limits.GetSamplesPerSecond() == 0 && limits.GetBytesPerSecond() == 0 && limits.GetActiveSeries() == 0 {
+		return validation.Error("at least one limit must be set")
+	}
+	return nil
+}
+
+// Merge returns the lowest of each limit set in l and other.
+func (l *Limits) Merge(other *Limits) *Limits {
+	return &Limits{
+		SamplesPerSecond: minNonZero(l.GetSamplesPerSecond(), other.GetSamplesPerSecond()),
+		BytesPerSecond:   minNonZero(l.GetBytesPerSecond(), other.GetBytesPerSecond()),
+		ActiveSeries:     minNonZero(l.GetActiveSeries(), other.GetActiveSeries()),
+	}
+}
+
+// IsEmpty returns true if no limits are set.
+func (l *Limits) IsEmpty() bool {
+	return l.GetSamplesPerSecond() == 0 && l.GetBytesPerSecond() == 0 && l.GetActiveSeries() == 0
+}
+
+// UsageRatios returns the fraction of each enforced limit which is in use,
+// keyed by limit name.
+func (u *ClusterUsage) UsageRatios() map[string]float64 {
+	ratios := map[string]float64{}
+	limits := u.GetLimits()
+	if limit := limits.GetSamplesPerSecond(); limit > 0 {
+		ratios[SamplesPerSecond] = u.GetSamplesPerSecond() / limit
+	}
+	if limit := limits.GetBytesPerSecond(); limit > 0 {
+		ratios[BytesPerSecond] = u.GetBytesPerSecond() / limit
+	}
+	if limit := limits.GetActiveSeries(); limit > 0 {
+		ratios[ActiveSeries] = float64(u.GetActiveSeries()) / float64(limit)
+	}
+	return ratios
+}
+
+func minNonZero[T float64 | uint64](a, b T) T {
+	switch {
+	case a == 0:
+		return b
+	case b == 0:
+		return a
+	case a < b:
+		return a
+	default:
+		return b
+	}
+}
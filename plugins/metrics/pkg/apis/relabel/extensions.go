@@ -0,0 +1,72 @@
+package relabel
+
+import (
+	"fmt"
+	"strings"
+
+	promrelabel "github.com/prometheus/prometheus/model/relabel"
+	"github.com/rancher/opni/pkg/validation"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ClusterIDPlaceholder   = "${__cluster_id__}"
+	ClusterNamePlaceholder = "${__cluster_name__}"
+)
+
+func (in *Rule) Validate() error {
+	if err := validation.ValidateID(in.GetId()); err != nil {
+		return err
+	}
+	if len(in.GetRelabelConfigs()) == 0 {
+		return validation.Error("relabelConfigs is required")
+	}
+	for i, conf := range in.GetRelabelConfigs() {
+		if _, err := conf.Compile("", ""); err != nil {
+			return validation.Errorf("relabelConfigs[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Compile converts the config to a Prometheus relabel config, applying the
+// Prometheus defaults to unset fields and substituting the cluster
+// placeholders in the replacement.
+func (in *RelabelConfig) Compile(clusterId, clusterName string) (*promrelabel.Config, error) {
+	// The Prometheus relabel config only applies its defaults and validation
+	// when unmarshaled from yaml.
+	fields := map[string]any{}
+	if len(in.GetSourceLabels()) > 0 {
+		fields["source_labels"] = in.GetSourceLabels()
+	}
+	if in.Separator != nil {
+		fields["separator"] = in.GetSeparator()
+	}
+	if in.Regex != nil {
+		fields["regex"] = in.GetRegex()
+	}
+	if in.GetModulus() != 0 {
+		fields["modulus"] = in.GetModulus()
+	}
+	if in.GetTargetLabel() != "" {
+		fields["target_label"] = in.GetTargetLabel()
+	}
+	if in.Replacement != nil {
+		fields["replacement"] = strings.NewReplacer(
+			ClusterIDPlaceholder, clusterId,
+			ClusterNamePlaceholder, clusterName,
+		).Replace(in.GetReplacement())
+	}
+	if in.GetAction() != "" {
+		fields["action"] = in.GetAction()
+	}
+	data, err := yaml.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	conf := &promrelabel.Config{}
+	if err := yaml.UnmarshalStrict(data, conf); err != nil {
+		return nil, fmt.Errorf("invalid relabel config: %w", err)
+	}
+	return conf, nil
+}
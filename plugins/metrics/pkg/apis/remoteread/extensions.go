@@ -0,0 +1,186 @@
+package remoteread
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/rancher/opni/pkg/validation"
+)
+
+const Redacted = "***"
+
+func (in *TargetSpec) Validate() error {
+	if in.GetEndpoint() == "" {
+		return validation.Error("endpoint is required")
+	}
+	u, err := url.Parse(in.GetEndpoint())
+	if err != nil {
+		return validation.Errorf("invalid endpoint: %v", err)
+	}
+	if in.GetAuth() != nil {
+		if err := in.GetAuth().Validate(); err != nil {
+			return err
+		}
+	}
+	if in.GetTls() != nil {
+		if u.Scheme != "https" {
+			return validation.Error("tls settings require an https endpoint")
+		}
+		if err := in.GetTls().Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *TargetAuth) Validate() error {
+	if in.GetBasicAuth() != nil && in.GetBearerToken() != "" {
+		return validation.Error("only one of basic auth or bearer token can be set")
+	}
+	if in.GetBasicAuth() != nil && in.GetBasicAuth().GetUsername() == "" {
+		return validation.Error("basic auth username is required")
+	}
+	return nil
+}
+
+func (in *TargetTLS) Validate() error {
+	if (in.GetCertData() == "") != (in.GetKeyData() == "") {
+		return validation.Error("client certificate and key must be set together")
+	}
+	// secrets may be redacted when editing an existing target, in which case
+	// the full config is validated once the secrets have been restored
+	if in.GetKeyData() == Redacted {
+		return nil
+	}
+	if _, err := in.TLSConfig(); err != nil {
+		return validation.Error(err.Error())
+	}
+	return nil
+}
+
+// TLSConfig builds a client tls config from the target's TLS settings.
+func (in *TargetTLS) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         in.GetServerName(),
+		InsecureSkipVerify: in.GetInsecureSkipVerify(),
+	}
+	if in.GetCaCertData() != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(in.GetCaCertData())) {
+			return nil, fmt.Errorf("no valid certificates found in CA data")
+		}
+		config.RootCAs = pool
+	}
+	if in.GetCertData() != "" {
+		cert, err := tls.X509KeyPair([]byte(in.GetCertData()), []byte(in.GetKeyData()))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// SecretFields returns pointers to each secret in the spec which is set.
+func (in *TargetSpec) SecretFields() []*string {
+	var fields []*string
+	if auth := in.GetAuth(); auth != nil {
+		if auth.GetBasicAuth().GetPassword() != "" {
+			fields = append(fields, &auth.BasicAuth.Password)
+		}
+		if auth.GetBearerToken() != "" {
+			fields = append(fields, &auth.BearerToken)
+		}
+	}
+	if t := in.GetTls(); t != nil && t.GetKeyData() != "" {
+		fields = append(fields, &t.KeyData)
+	}
+	return fields
+}
+
+func (in *Target) RedactSecrets() {
+	for _, field := range in.GetSpec().SecretFields() {
+		*field = Redacted
+	}
+}
+
+func (in *TargetAuth) UnredactSecrets(unredacted *TargetAuth) error {
+	if in.GetBasicAuth().GetPassword() == Redacted {
+		if unredacted.GetBasicAuth().GetPassword() == "" {
+			return status.Error(codes.FailedPrecondition, "no basic auth password provided")
+		}
+		in.BasicAuth.Password = unredacted.BasicAuth.Password
+	}
+	if in.GetBearerToken() == Redacted {
+		if unredacted.GetBearerToken() == "" {
+			return status.Error(codes.FailedPrecondition, "no bearer token provided")
+		}
+		in.BearerToken = unredacted.BearerToken
+	}
+	return nil
+}
+
+func (in *TargetTLS) UnredactSecrets(unredacted *TargetTLS) error {
+	if in.GetKeyData() == Redacted {
+		if unredacted.GetKeyData() == "" {
+			return status.Error(codes.FailedPrecondition, "no client key provided")
+		}
+		in.KeyData = unredacted.KeyData
+	}
+	return nil
+}
+
+func (in *ImportOptions) Validate() error {
+	if in.GetChunkDuration() != nil {
+		if err := in.GetChunkDuration().CheckValid(); err != nil {
+			return validation.Errorf("invalid chunk duration: %v", err)
+		}
+		if in.GetChunkDuration().AsDuration() < time.Second {
+			return validation.Error("chunk duration must be at least 1s")
+		}
+	}
+	if in.GetMaxConcurrency() < 0 {
+		return validation.Error("max concurrency must not be negative")
+	}
+	return nil
+}
+
+// ChunkDurationMillis returns the chunk duration in milliseconds, or the
+// given default if it is not set.
+func (in *ImportOptions) ChunkDurationMillis(defaultMillis int64) int64 {
+	if in.GetChunkDuration() == nil {
+		return defaultMillis
+	}
+	return in.GetChunkDuration().AsDuration().Milliseconds()
+}
+
+// Concurrency returns the max concurrency, or the given default if it is not
+// set.
+func (in *ImportOptions) Concurrency(defaultConcurrency int) int {
+	if in.GetMaxConcurrency() == 0 {
+		return defaultConcurrency
+	}
+	return int(in.GetMaxConcurrency())
+}
+
+func (in *UploadHeader) Validate() error {
+	if in.GetName() == "" {
+		return validation.Error("name is required")
+	}
+	if err := validation.ValidateID(in.GetName()); err != nil {
+		return validation.Errorf("invalid name: %v", err)
+	}
+	if in.GetClusterId() == "" {
+		return validation.Error("cluster id is required")
+	}
+	if _, ok := UploadFormat_name[int32(in.GetFormat())]; !ok {
+		return validation.Errorf("unknown upload format %d", in.GetFormat())
+	}
+	return nil
+}
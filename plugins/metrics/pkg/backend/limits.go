@@ -0,0 +1,270 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/validation"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexops"
+)
+
+const (
+	tenantLimitsPrefix         = "tenant-limits/"
+	tenantLimitsClustersPrefix = tenantLimitsPrefix + "clusters/"
+	tenantLimitsDefaultsPrefix = tenantLimitsPrefix + "defaults/"
+
+	// How long to wait before restarting the cluster watch if it fails.
+	tenantLimitsWatchRetryInterval = 10 * time.Second
+)
+
+func tenantLimitsKey(spec *cortexops.TenantLimitsSpec) string {
+	if spec.Selector != nil {
+		return tenantLimitsDefaultsPrefix + spec.GetId()
+	}
+	return tenantLimitsClustersPrefix + spec.GetId()
+}
+
+func (m *MetricsBackend) GetTenantLimits(ctx context.Context, ref *corev1.Reference) (*cortexops.TenantLimits, error) {
+	m.WaitForInit()
+
+	if err := validation.Validate(ref); err != nil {
+		return nil, err
+	}
+	cluster, err := m.StorageBackend.GetCluster(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	specs, err := m.listTenantLimits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return effectiveTenantLimits(cluster, specs), nil
+}
+
+func (m *MetricsBackend) ListTenantLimits(ctx context.Context, _ *emptypb.Empty) (*cortexops.TenantLimitsSpecList, error) {
+	m.WaitForInit()
+
+	specs, err := m.listTenantLimits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &cortexops.TenantLimitsSpecList{
+		Items: specs,
+	}, nil
+}
+
+func (m *MetricsBackend) SetTenantLimits(ctx context.Context, spec *cortexops.TenantLimitsSpec) (*emptypb.Empty, error) {
+	m.WaitForInit()
+
+	if err := validation.Validate(spec); err != nil {
+		return nil, err
+	}
+
+	m.tenantLimitsMu.Lock()
+	defer m.tenantLimitsMu.Unlock()
+
+	if len(spec.GetLimits().GetFields()) == 0 {
+		if err := m.TenantLimitsStore.Delete(ctx, tenantLimitsKey(spec)); err != nil {
+			return nil, err
+		}
+	} else {
+		if spec.Selector == nil {
+			if _, err := m.StorageBackend.GetCluster(ctx, &corev1.Reference{Id: spec.GetId()}); err != nil {
+				return nil, err
+			}
+		}
+		if err := m.TenantLimitsStore.Put(ctx, tenantLimitsKey(spec), spec); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.applyTenantLimits(ctx); err != nil {
+		return nil, fmt.Errorf("limits were saved, but could not be applied: %w", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// applyTenantLimits computes the overrides for every cluster and writes them
+// to the Cortex runtime config. Label-selector defaults are evaluated against
+// the current cluster labels each time this is called; watchClusters calls it
+// whenever cluster labels change.
+func (m *MetricsBackend) applyTenantLimits(ctx context.Context) error {
+	specs, err := m.listTenantLimits(ctx)
+	if err != nil {
+		return err
+	}
+	clusters, err := m.StorageBackend.ListClusters(ctx, &corev1.LabelSelector{}, corev1.MatchOptions_Default)
+	if err != nil {
+		return err
+	}
+	overrides := map[string]*structpb.Struct{}
+	for _, cluster := range clusters.GetItems() {
+		limits := effectiveTenantLimits(cluster, specs)
+		if len(limits.GetLimits().GetFields()) > 0 {
+			overrides[cluster.GetId()] = limits.GetLimits()
+		}
+	}
+	return m.ClusterDriver.SetTenantLimitOverrides(ctx, overrides)
+}
+
+// reapplyTenantLimits applies tenant limits after a change to the set of
+// clusters or their labels. Errors are logged.
+func (m *MetricsBackend) reapplyTenantLimits(ctx context.Context) {
+	m.tenantLimitsMu.Lock()
+	defer m.tenantLimitsMu.Unlock()
+	if err := m.applyTenantLimits(ctx); err != nil && status.Code(err) != codes.Unimplemented {
+		m.Logger.With(
+			zap.Error(err),
+		).Warn("failed to apply tenant limits")
+	}
+}
+
+// deleteClusterTenantLimits removes the stored overrides for a cluster which
+// has been deleted.
+func (m *MetricsBackend) deleteClusterTenantLimits(ctx context.Context, id string) error {
+	m.tenantLimitsMu.Lock()
+	defer m.tenantLimitsMu.Unlock()
+	err := m.TenantLimitsStore.Delete(ctx, tenantLimitsClustersPrefix+id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) && status.Code(err) != codes.NotFound {
+		return err
+	}
+	return nil
+}
+
+// watchClusters keeps the applied tenant limits up to date as clusters are
+// created, relabeled, or deleted, so that label-selector defaults follow the
+// current cluster labels. Overrides for deleted clusters are removed. Blocks
+// until ctx is done, restarting the watch if it fails.
+func (m *MetricsBackend) watchClusters(ctx context.Context) {
+	for {
+		err := m.watchClustersOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		m.Logger.With(
+			zap.Error(err),
+		).Warn("cluster watch for tenant limits failed, retrying")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(tenantLimitsWatchRetryInterval):
+		}
+	}
+}
+
+func (m *MetricsBackend) watchClustersOnce(ctx context.Context) error {
+	ctx, ca := context.WithCancel(ctx)
+	defer ca()
+
+	clusters, err := m.MgmtClient.ListClusters(ctx, &managementv1.ListClustersRequest{})
+	if err != nil {
+		return err
+	}
+	clusterLabels := map[string]map[string]string{}
+	known := &corev1.ReferenceList{}
+	for _, cluster := range clusters.GetItems() {
+		clusterLabels[cluster.GetId()] = maps.Clone(cluster.GetLabels())
+		known.Items = append(known.Items, cluster.Reference())
+	}
+	stream, err := m.MgmtClient.WatchClusters(ctx, &managementv1.WatchClustersRequest{
+		KnownClusters: known,
+	})
+	if err != nil {
+		return err
+	}
+	// clusters may have changed while the watch was not running
+	m.reapplyTenantLimits(ctx)
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		cluster := event.GetCluster()
+		switch event.GetType() {
+		case managementv1.WatchEventType_Created, managementv1.WatchEventType_Updated:
+			if prev, ok := clusterLabels[cluster.GetId()]; ok && maps.Equal(prev, cluster.GetLabels()) {
+				continue
+			}
+			clusterLabels[cluster.GetId()] = maps.Clone(cluster.GetLabels())
+		case managementv1.WatchEventType_Deleted:
+			delete(clusterLabels, cluster.GetId())
+			if err := m.deleteClusterTenantLimits(ctx, cluster.GetId()); err != nil {
+				m.Logger.With(
+					zap.Error(err),
+					"cluster", cluster.GetId(),
+				).Warn("failed to delete tenant limits for deleted cluster")
+			}
+		}
+		m.reapplyTenantLimits(ctx)
+	}
+}
+
+// listTenantLimits returns all stored specs, with label-selector defaults
+// first, each group ordered by id.
+func (m *MetricsBackend) listTenantLimits(ctx context.Context) ([]*cortexops.TenantLimitsSpec, error) {
+	keys, err := m.TenantLimitsStore.ListKeys(ctx, tenantLimitsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		di, dj := strings.HasPrefix(keys[i], tenantLimitsDefaultsPrefix), strings.HasPrefix(keys[j], tenantLimitsDefaultsPrefix)
+		if di != dj {
+			return di
+		}
+		return keys[i] < keys[j]
+	})
+	specs := make([]*cortexops.TenantLimitsSpec, 0, len(keys))
+	for _, key := range keys {
+		spec, err := m.TenantLimitsStore.Get(ctx, key)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get tenant limits %q: %w", strings.TrimPrefix(key, tenantLimitsPrefix), err)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// effectiveTenantLimits merges the label-selector defaults matching the
+// cluster with the cluster's own overrides. specs must be ordered as returned
+// by listTenantLimits.
+func effectiveTenantLimits(cluster *corev1.Cluster, specs []*cortexops.TenantLimitsSpec) *cortexops.TenantLimits {
+	limits := &cortexops.TenantLimits{
+		ClusterId: cluster.GetId(),
+		Limits:    &structpb.Struct{Fields: map[string]*structpb.Value{}},
+	}
+	for _, spec := range specs {
+		if spec.Selector != nil {
+			if !storage.NewSelectorPredicate[*corev1.Cluster](spec.GetSelector())(cluster) {
+				continue
+			}
+			limits.Defaults = append(limits.Defaults, spec.GetId())
+		} else {
+			if spec.GetId() != cluster.GetId() {
+				continue
+			}
+			limits.HasClusterOverrides = true
+		}
+		for name, value := range spec.GetLimits().GetFields() {
+			limits.Limits.Fields[name] = proto.Clone(value).(*structpb.Value)
+		}
+	}
+	return limits
+}
@@ -14,10 +14,12 @@ import (
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	capabilityv1 "github.com/rancher/opni/pkg/apis/capability/v1"
@@ -27,6 +29,7 @@ import (
 	"github.com/rancher/opni/pkg/capabilities"
 	"github.com/rancher/opni/pkg/capabilities/wellknown"
 	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/keyring"
 	"github.com/rancher/opni/pkg/machinery/uninstall"
 	"github.com/rancher/opni/pkg/storage"
 	"github.com/rancher/opni/pkg/task"
@@ -50,9 +53,13 @@ type MetricsBackend struct {
 	desiredNodeSpecMu sync.RWMutex
 	desiredNodeSpec   map[string]*node.MetricsCapabilitySpec
 
-	// the stored remoteread.Target should never have their status populated
+	// guards RemoteReadTargetStore; stored targets never have their status
+	// populated, and their secrets are encrypted
 	remoteReadTargetMu sync.RWMutex
-	remoteReadTargets  map[string]*remoteread.Target
+	secretCipher       *secretCipher
+
+	// serializes writes of tenant limit overrides to the runtime config
+	tenantLimitsMu sync.Mutex
 
 	util.Initializer
 }
@@ -62,14 +69,25 @@ var _ cortexops.CortexOpsServer = (*MetricsBackend)(nil)
 var _ remoteread.RemoteReadGatewayServer = (*MetricsBackend)(nil)
 
 type MetricsBackendConfig struct {
-	Logger              *zap.SugaredLogger                                         `validate:"required"`
-	StorageBackend      storage.Backend                                            `validate:"required"`
-	MgmtClient          managementv1.ManagementClient                              `validate:"required"`
-	NodeManagerClient   capabilityv1.NodeManagerClient                             `validate:"required"`
-	UninstallController *task.Controller                                           `validate:"required"`
-	ClusterDriver       drivers.ClusterDriver                                      `validate:"required"`
-	Delegate            streamext.StreamDelegate[remoteread.RemoteReadAgentClient] `validate:"required"`
-	RemoteWriteClient   *cortex.RemoteWriteForwarder                               `validate:"required"`
+	PluginContext         context.Context                                            `validate:"required"`
+	Logger                *zap.SugaredLogger                                         `validate:"required"`
+	StorageBackend        storage.Backend                                            `validate:"required"`
+	MgmtClient            managementv1.ManagementClient                              `validate:"required"`
+	NodeManagerClient     capabilityv1.NodeManagerClient                             `validate:"required"`
+	UninstallController   *task.Controller                                           `validate:"required"`
+	UploadController      *task.Controller                                           `validate:"required"`
+	ClusterDriver         drivers.ClusterDriver                                      `validate:"required"`
+	Delegate              streamext.StreamDelegate[remoteread.RemoteReadAgentClient] `validate:"required"`
+	RemoteWriteClient     *cortex.RemoteWriteForwarder                               `validate:"required"`
+	TenantLimitsStore     storage.KeyValueStoreT[*cortexops.TenantLimitsSpec]        `validate:"required"`
+	RemoteReadTargetStore storage.KeyValueStoreT[*remoteread.Target]                 `validate:"required"`
+	RemoteWriteBuffer     v1beta1.RemoteWriteBufferSpec
+	// Maximum size in bytes of snapshots uploaded using UploadImport.
+	// Defaults to DefaultMaxUploadSize.
+	MaxUploadSize int64
+	// Keys used to encrypt stored secrets. Only keys with the encryption
+	// usage type are used.
+	SecretKeys []*keyring.EphemeralKey
 }
 
 func (m *MetricsBackend) Initialize(conf MetricsBackendConfig) {
@@ -80,7 +98,12 @@ func (m *MetricsBackend) Initialize(conf MetricsBackendConfig) {
 		m.MetricsBackendConfig = conf
 		m.nodeStatus = make(map[string]*capabilityv1.NodeCapabilityStatus)
 		m.desiredNodeSpec = make(map[string]*node.MetricsCapabilitySpec)
-		m.remoteReadTargets = make(map[string]*remoteread.Target)
+		cipher, err := newSecretCipher(conf.SecretKeys)
+		if err != nil {
+			panic(err)
+		}
+		m.secretCipher = cipher
+		go m.watchClusters(conf.PluginContext)
 	})
 }
 
@@ -146,6 +169,9 @@ func (m *MetricsBackend) Install(ctx context.Context, req *capabilityv1.InstallR
 
 	m.requestNodeSync(ctx, req.Cluster)
 
+	// apply any label-selector default limits matching the new cluster
+	m.reapplyTenantLimits(ctx)
+
 	if warningErr != nil {
 		return &capabilityv1.InstallResponse{
 			Status:  capabilityv1.InstallResponseStatus_Warning,
@@ -363,10 +389,27 @@ func (m *MetricsBackend) Sync(ctx context.Context, req *node.SyncRequest) (*node
 			Prometheus: &node.PrometheusSpec{
 				DeploymentStrategy: "externalPromOperator",
 			},
+			RemoteWriteBuffer: m.remoteWriteBufferSpec(),
 		},
 	}), nil
 }
 
+func (m *MetricsBackend) remoteWriteBufferSpec() *node.RemoteWriteBufferSpec {
+	conf := m.RemoteWriteBuffer
+	if !conf.Enabled {
+		return nil
+	}
+	spec := &node.RemoteWriteBufferSpec{
+		Enabled:      true,
+		Dir:          conf.Dir,
+		MaxSizeBytes: int64(conf.MaxSizeMiB) << 20,
+	}
+	if conf.MaxAgeMinutes > 0 {
+		spec.MaxAge = durationpb.New(time.Duration(conf.MaxAgeMinutes) * time.Minute)
+	}
+	return spec
+}
+
 // the calling function must have exclusive ownership of both old and new
 func buildResponse(old, new *node.MetricsCapabilityConfig) *node.SyncResponse {
 	if cmp.Equal(old, new, protocmp.Transform()) {
@@ -435,28 +478,60 @@ func getIdFromTargetMeta(meta *remoteread.TargetMeta) string {
 	return fmt.Sprintf("%s/%s", meta.ClusterId, meta.Name)
 }
 
-func (m *MetricsBackend) AddTarget(_ context.Context, request *remoteread.TargetAddRequest) (*emptypb.Empty, error) {
+const remoteReadTargetsPrefix = "targets/"
+
+func remoteReadTargetKey(targetId string) string {
+	return remoteReadTargetsPrefix + targetId
+}
+
+// putRemoteReadTarget stores a copy of the target with its secrets encrypted
+// and its status cleared. Callers must hold remoteReadTargetMu.
+func (m *MetricsBackend) putRemoteReadTarget(ctx context.Context, target *remoteread.Target) error {
+	target = util.ProtoClone(target)
+	target.Status = nil
+	if err := m.secretCipher.Encrypt(target.GetSpec().SecretFields()); err != nil {
+		return err
+	}
+	return m.RemoteReadTargetStore.Put(ctx, remoteReadTargetKey(getIdFromTargetMeta(target.Meta)), target)
+}
+
+// getRemoteReadTarget returns the stored target with its secrets decrypted.
+func (m *MetricsBackend) getRemoteReadTarget(ctx context.Context, targetId string) (*remoteread.Target, error) {
+	target, err := m.RemoteReadTargetStore.Get(ctx, remoteReadTargetKey(targetId))
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, targetDoesNotExistError(targetId)
+		}
+		return nil, err
+	}
+	if err := m.secretCipher.Decrypt(target.GetSpec().SecretFields()); err != nil {
+		return nil, status.Errorf(status.Code(err), "failed to decrypt secrets for target '%s': %s", targetId, status.Convert(err).Message())
+	}
+	return target, nil
+}
+
+func (m *MetricsBackend) AddTarget(ctx context.Context, request *remoteread.TargetAddRequest) (*emptypb.Empty, error) {
 	m.WaitForInit()
 
+	if err := request.GetTarget().GetSpec().Validate(); err != nil {
+		return nil, err
+	}
+
 	m.remoteReadTargetMu.Lock()
 	defer m.remoteReadTargetMu.Unlock()
 
 	targetId := getIdFromTargetMeta(request.Target.Meta)
 
-	if _, found := m.remoteReadTargets[targetId]; found {
+	if _, err := m.RemoteReadTargetStore.Get(ctx, remoteReadTargetKey(targetId)); err == nil {
 		return nil, targetAlreadyExistsError(targetId)
+	} else if status.Code(err) != codes.NotFound {
+		return nil, err
 	}
 
-	if request.Target.Status == nil {
-		request.Target.Status = &remoteread.TargetStatus{
-			Progress: &remoteread.TargetProgress{},
-			Message:  "",
-			State:    remoteread.TargetState_Running,
-		}
+	if err := m.putRemoteReadTarget(ctx, request.Target); err != nil {
+		return nil, err
 	}
 
-	m.remoteReadTargets[targetId] = request.Target
-
 	m.Logger.With(
 		"cluster", request.Target.Meta.ClusterId,
 		"target", request.Target.Meta.Name,
@@ -487,27 +562,58 @@ func (m *MetricsBackend) EditTarget(ctx context.Context, request *remoteread.Tar
 	diff := request.TargetDiff
 	targetId := getIdFromTargetMeta(request.Meta)
 
-	target, found := m.remoteReadTargets[targetId]
-	if !found {
-		return nil, targetDoesNotExistError(targetId)
+	target, err := m.getRemoteReadTarget(ctx, targetId)
+	if err != nil {
+		return nil, err
 	}
 
 	if diff.Name != "" {
 		target.Meta.Name = diff.Name
 		newTargetId := getIdFromTargetMeta(target.Meta)
 
-		if _, found := m.remoteReadTargets[newTargetId]; found {
+		if _, err := m.RemoteReadTargetStore.Get(ctx, remoteReadTargetKey(newTargetId)); err == nil {
 			return nil, targetAlreadyExistsError(diff.Name)
 		}
-
-		delete(m.remoteReadTargets, targetId)
-		m.remoteReadTargets[newTargetId] = target
 	}
 
 	if diff.Endpoint != "" {
 		target.Spec.Endpoint = diff.Endpoint
 	}
 
+	if diff.Auth != nil {
+		if err := diff.Auth.UnredactSecrets(target.Spec.GetAuth()); err != nil {
+			return nil, err
+		}
+		target.Spec.Auth = diff.Auth
+		if diff.Auth.GetBasicAuth() == nil && diff.Auth.GetBearerToken() == "" {
+			target.Spec.Auth = nil
+		}
+	}
+
+	if diff.Tls != nil {
+		if err := diff.Tls.UnredactSecrets(target.Spec.GetTls()); err != nil {
+			return nil, err
+		}
+		target.Spec.Tls = diff.Tls
+		if proto.Equal(diff.Tls, &remoteread.TargetTLS{}) {
+			target.Spec.Tls = nil
+		}
+	}
+
+	if err := target.Spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := m.putRemoteReadTarget(ctx, target); err != nil {
+		return nil, err
+	}
+
+	if newTargetId := getIdFromTargetMeta(target.Meta); newTargetId != targetId {
+		if err := m.RemoteReadTargetStore.Delete(ctx, remoteReadTargetKey(targetId)); err != nil {
+			return nil, err
+		}
+	}
+
 	m.Logger.With(
 		"cluster", request.Meta.ClusterId,
 		"target", request.Meta.Name,
@@ -537,12 +643,13 @@ func (m *MetricsBackend) RemoveTarget(ctx context.Context, request *remoteread.T
 
 	targetId := getIdFromTargetMeta(request.Meta)
 
-	if _, found := m.remoteReadTargets[targetId]; !found {
-		return nil, targetDoesNotExistError(request.Meta.Name)
+	if err := m.RemoteReadTargetStore.Delete(ctx, remoteReadTargetKey(targetId)); err != nil {
+		if util.StatusCode(err) == codes.NotFound {
+			return nil, targetDoesNotExistError(request.Meta.Name)
+		}
+		return nil, err
 	}
 
-	delete(m.remoteReadTargets, targetId)
-
 	m.Logger.With(
 		"cluster", request.Meta.ClusterId,
 		"target", request.Meta.Name,
@@ -552,42 +659,60 @@ func (m *MetricsBackend) RemoveTarget(ctx context.Context, request *remoteread.T
 	return &emptypb.Empty{}, nil
 }
 
+// ListTargets returns the stored targets with their current status. Secrets
+// are always redacted.
 func (m *MetricsBackend) ListTargets(ctx context.Context, request *remoteread.TargetListRequest) (*remoteread.TargetList, error) {
 	m.WaitForInit()
 
-	m.remoteReadTargetMu.RLock()
-	defer m.remoteReadTargetMu.RUnlock()
+	prefix := remoteReadTargetsPrefix
+	if request.ClusterId != "" {
+		prefix = remoteReadTargetKey(request.ClusterId + "/")
+	}
 
-	inner := make([]*remoteread.Target, 0, len(m.remoteReadTargets))
-	innerMu := sync.RWMutex{}
+	m.remoteReadTargetMu.RLock()
+	keys, err := m.RemoteReadTargetStore.ListKeys(ctx, prefix)
+	if err != nil {
+		m.remoteReadTargetMu.RUnlock()
+		return nil, err
+	}
+	targets := make([]*remoteread.Target, 0, len(keys))
+	for _, key := range keys {
+		target, err := m.RemoteReadTargetStore.Get(ctx, key)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			m.remoteReadTargetMu.RUnlock()
+			return nil, err
+		}
+		target.RedactSecrets()
+		targets = append(targets, target)
+	}
+	m.remoteReadTargetMu.RUnlock()
 
 	eg, ctx := errgroup.WithContext(ctx)
-	for _, target := range m.remoteReadTargets {
-		if request.ClusterId == "" || request.ClusterId == target.Meta.ClusterId {
-			target := target
-			eg.Go(func() error {
-				newStatus, err := m.GetTargetStatus(ctx, &remoteread.TargetStatusRequest{Meta: target.Meta})
-				if err != nil {
-					m.Logger.Infof("could not get newStatus for target '%s/%s': %s", target.Meta.ClusterId, target.Meta.Name, err)
-					newStatus.State = remoteread.TargetState_Unknown
+	for _, target := range targets {
+		target := target
+		eg.Go(func() error {
+			newStatus, err := m.GetTargetStatus(ctx, &remoteread.TargetStatusRequest{Meta: target.Meta})
+			if err != nil {
+				m.Logger.Infof("could not get newStatus for target '%s/%s': %s", target.Meta.ClusterId, target.Meta.Name, err)
+				newStatus = &remoteread.TargetStatus{
+					State: remoteread.TargetState_Unknown,
 				}
+			}
 
-				target.Status = newStatus
-
-				innerMu.Lock()
-				inner = append(inner, target)
-				innerMu.Unlock()
+			target.Status = newStatus
 
-				return nil
-			})
-		}
+			return nil
+		})
 	}
 
 	if err := eg.Wait(); err != nil {
 		m.Logger.Errorf("error waiting for status to update: %s", err)
 	}
 
-	list := &remoteread.TargetList{Targets: inner}
+	list := &remoteread.TargetList{Targets: targets}
 
 	return list, nil
 }
@@ -598,9 +723,13 @@ func (m *MetricsBackend) GetTargetStatus(ctx context.Context, request *remoterea
 	targetId := getIdFromTargetMeta(request.Meta)
 
 	m.remoteReadTargetMu.RLock()
-	defer m.remoteReadTargetMu.RUnlock()
-	if _, found := m.remoteReadTargets[targetId]; !found {
-		return nil, fmt.Errorf("target '%s/%s' does not exist", request.Meta.ClusterId, request.Meta.Name)
+	_, err := m.RemoteReadTargetStore.Get(ctx, remoteReadTargetKey(targetId))
+	m.remoteReadTargetMu.RUnlock()
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("target '%s/%s' does not exist", request.Meta.ClusterId, request.Meta.Name)
+		}
+		return nil, err
 	}
 
 	newStatus, err := m.Delegate.WithTarget(&corev1.Reference{Id: request.Meta.ClusterId}).GetTargetStatus(ctx, request)
@@ -632,6 +761,10 @@ func (m *MetricsBackend) Start(ctx context.Context, request *remoteread.StartRea
 		return nil, fmt.Errorf("encountered nil delegate")
 	}
 
+	if err := request.GetOptions().Validate(); err != nil {
+		return nil, err
+	}
+
 	m.remoteReadTargetMu.RLock()
 	defer m.remoteReadTargetMu.RUnlock()
 
@@ -639,14 +772,14 @@ func (m *MetricsBackend) Start(ctx context.Context, request *remoteread.StartRea
 
 	// agent needs the full target but cli will ony have access to remoteread.TargetMeta values (clusterId, name, etc)
 	// so we need to replace the naive request target
-	target, found := m.remoteReadTargets[targetId]
-	if !found {
-		return nil, targetDoesNotExistError(targetId)
+	target, err := m.getRemoteReadTarget(ctx, targetId)
+	if err != nil {
+		return nil, err
 	}
 
 	request.Target = target
 
-	_, err := m.Delegate.WithTarget(&corev1.Reference{Id: request.Target.Meta.ClusterId}).Start(ctx, request)
+	_, err = m.Delegate.WithTarget(&corev1.Reference{Id: request.Target.Meta.ClusterId}).Start(ctx, request)
 
 	if err != nil {
 		m.Logger.With(
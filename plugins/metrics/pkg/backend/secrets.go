@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/rancher/opni/pkg/keyring"
+)
+
+const encryptedSecretPrefix = "enc:"
+
+// secretCipher encrypts secrets before they are written to the key-value
+// store, using the encryption keys from the gateway's ephemeral keyring.
+// Secrets are always encrypted with the first key; all keys are tried when
+// decrypting, so that keys can be rotated by adding a new key first.
+type secretCipher struct {
+	aeads []cipher.AEAD
+}
+
+func newSecretCipher(keys []*keyring.EphemeralKey) (*secretCipher, error) {
+	c := &secretCipher{}
+	for _, key := range keys {
+		if key.Usage != keyring.Encryption {
+			continue
+		}
+		aead, err := chacha20poly1305.NewX(key.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %w", err)
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// Encrypt replaces each secret with its encrypted form.
+func (c *secretCipher) Encrypt(secrets []*string) error {
+	if len(secrets) == 0 {
+		return nil
+	}
+	if len(c.aeads) == 0 {
+		return status.Error(codes.FailedPrecondition, "an encryption key must be configured in the gateway keyring to store secrets")
+	}
+	aead := c.aeads[0]
+	for _, secret := range secrets {
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(*secret)+aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		sealed := aead.Seal(nonce, nonce, []byte(*secret), nil)
+		*secret = encryptedSecretPrefix + base64.StdEncoding.EncodeToString(sealed)
+	}
+	return nil
+}
+
+// Decrypt replaces each encrypted secret with its plaintext.
+func (c *secretCipher) Decrypt(secrets []*string) error {
+	for _, secret := range secrets {
+		if !strings.HasPrefix(*secret, encryptedSecretPrefix) {
+			return fmt.Errorf("secret is not encrypted")
+		}
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(*secret, encryptedSecretPrefix))
+		if err != nil {
+			return fmt.Errorf("malformed secret: %w", err)
+		}
+		var plaintext []byte
+		opened := false
+		for _, aead := range c.aeads {
+			if len(sealed) < aead.NonceSize() {
+				continue
+			}
+			nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+			if plaintext, err = aead.Open(nil, nonce, ciphertext, nil); err == nil {
+				opened = true
+				break
+			}
+		}
+		if !opened {
+			return status.Error(codes.FailedPrecondition, "failed to decrypt secret; was the encryption key removed from the gateway keyring?")
+		}
+		*secret = string(plaintext)
+	}
+	return nil
+}
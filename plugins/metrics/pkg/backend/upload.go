@@ -0,0 +1,183 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/task"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
+	"github.com/rancher/opni/plugins/metrics/pkg/cortex"
+	"github.com/thanos-io/objstore"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// DefaultMaxUploadSize is the maximum size of an uploaded snapshot, if not
+// set in the backend config.
+const DefaultMaxUploadSize = 8 << 30
+
+// UploadImport stores an uploaded snapshot in the Cortex blocks bucket, where
+// it can be read by whichever gateway replica runs the import task, and starts
+// the import.
+func (m *MetricsBackend) UploadImport(stream remoteread.RemoteReadGateway_UploadImportServer) error {
+	m.WaitForInit()
+
+	ctx := stream.Context()
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	header := req.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "the first message must contain the upload header")
+	}
+	if err := header.Validate(); err != nil {
+		return err
+	}
+	if _, err := m.MgmtClient.GetCluster(ctx, &corev1.Reference{Id: header.ClusterId}); err != nil {
+		return err
+	}
+
+	if stat, err := m.UploadController.TaskStatus(header.Name); err == nil {
+		switch stat.GetState() {
+		case task.StatePending, task.StateRunning:
+			return status.Errorf(codes.AlreadyExists, "upload %q is already being imported", header.Name)
+		}
+	} else if util.StatusCode(err) != codes.NotFound {
+		return status.Errorf(codes.Internal, "failed to get upload status: %v", err)
+	}
+
+	bkt, err := m.ClusterDriver.NewBucketClient(ctx)
+	if err != nil {
+		return status.Errorf(codes.FailedPrecondition, "importing uploaded metrics requires cortex object storage which is accessible from the gateway: %v", err)
+	}
+
+	maxSize := m.MaxUploadSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxUploadSize
+	}
+	object := cortex.UploadObjectName(header.ClusterId, header.Name)
+	pr, pw := io.Pipe()
+	uploadErr := make(chan error, 1)
+	go func() {
+		err := bkt.Upload(ctx, object, pr)
+		// unblocks receiveUpload if the upload failed before reading everything
+		pr.CloseWithError(err)
+		uploadErr <- err
+	}()
+	err = receiveUpload(stream, pw, maxSize)
+	pw.CloseWithError(err)
+	if uerr := <-uploadErr; err == nil && uerr != nil {
+		err = status.Errorf(codes.Internal, "failed to store upload: %v", uerr)
+	}
+	if err != nil {
+		m.deleteUpload(bkt, object)
+		return err
+	}
+
+	if err := m.UploadController.LaunchTask(header.Name, task.WithMetadata(cortex.BackfillMetadata{
+		ClusterId: header.ClusterId,
+		Format:    header.Format,
+		Object:    object,
+	})); err != nil {
+		m.deleteUpload(bkt, object)
+		return status.Errorf(codes.Internal, "failed to start import: %v", err)
+	}
+
+	m.Logger.With(
+		"cluster", header.ClusterId,
+		"upload", header.Name,
+	).Info("upload import started")
+
+	return stream.SendAndClose(&emptypb.Empty{})
+}
+
+// receiveUpload writes the chunks of an upload to w, and fails if more than
+// maxSize bytes are received.
+func receiveUpload(stream remoteread.RemoteReadGateway_UploadImportServer, w io.Writer, maxSize int64) error {
+	var size int64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if req.GetHeader() != nil {
+			return status.Error(codes.InvalidArgument, "only the first message can contain the upload header")
+		}
+		size += int64(len(req.GetChunk()))
+		if size > maxSize {
+			return status.Errorf(codes.ResourceExhausted, "upload exceeds the maximum size of %d bytes", maxSize)
+		}
+		if _, err := w.Write(req.GetChunk()); err != nil {
+			return status.Errorf(codes.Internal, "failed to store upload: %v", err)
+		}
+	}
+}
+
+func (m *MetricsBackend) deleteUpload(bkt objstore.Bucket, object string) {
+	// the stream context may already be canceled
+	ctx, ca := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ca()
+	if err := bkt.Delete(ctx, object); err != nil && !bkt.IsObjNotFoundErr(err) {
+		m.Logger.With(
+			zap.Error(err),
+			"object", object,
+		).Warn("failed to remove uploaded snapshot")
+	}
+}
+
+func (m *MetricsBackend) GetUploadStatus(_ context.Context, request *remoteread.UploadStatusRequest) (*remoteread.UploadStatus, error) {
+	m.WaitForInit()
+
+	stat, err := m.UploadController.TaskStatus(request.GetName())
+	if err != nil {
+		if util.StatusCode(err) == codes.NotFound {
+			return nil, status.Errorf(codes.NotFound, "upload %q not found", request.GetName())
+		}
+		m.Logger.With(
+			zap.Error(err),
+			"upload", request.GetName(),
+		).Error("failed to get upload status")
+		return nil, err
+	}
+
+	var state remoteread.TargetState
+	switch stat.GetState() {
+	case task.StatePending, task.StateRunning:
+		state = remoteread.TargetState_Running
+	case task.StateCompleted:
+		state = remoteread.TargetState_Completed
+	case task.StateFailed:
+		state = remoteread.TargetState_Failed
+	case task.StateCanceled:
+		state = remoteread.TargetState_Canceled
+	default:
+		state = remoteread.TargetState_Unknown
+	}
+
+	var message string
+	for i := len(stat.GetLogs()) - 1; i >= 0; i-- {
+		if stat.Logs[i].GetLevel() == int32(zapcore.ErrorLevel) {
+			message = stat.Logs[i].GetMsg()
+			break
+		}
+	}
+
+	return &remoteread.UploadStatus{
+		State:          state,
+		Message:        message,
+		CompletedItems: int64(stat.GetProgress().GetCurrent()),
+		TotalItems:     int64(stat.GetProgress().GetTotal()),
+		Logs:           stat.GetLogs(),
+	}, nil
+}
@@ -75,6 +75,11 @@ type rawPromJSONData struct {
 
 func (a *MultiTenantRuleAggregator) Handle(c *gin.Context) {
 	ids := rbac.AuthorizedClusterIDs(c)
+	restrictions := rbac.AuthorizedLabelMatchers(c)
+	if len(restrictions) > 0 && c.Request.Method != http.MethodGet {
+		abortForbidden(c, fmt.Errorf("subjects with restricted metrics access cannot modify rules"))
+		return
+	}
 	a.logger.With(
 		"request", c.FullPath(),
 	).Debugf("aggregating query over %d tenants", len(ids))
@@ -131,8 +136,19 @@ func (a *MultiTenantRuleAggregator) Handle(c *gin.Context) {
 			return
 		}
 
+		// rules whose expressions are not restricted by the subject's label
+		// restrictions for this cluster are removed from the response
+		matchers, restricted := restrictions[id]
+
 		switch a.format {
 		case NamespaceKeyedYAML:
+			if restricted {
+				body, err = filterRuleGroupsYAML(body, matchers)
+				if err != nil {
+					c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("error parsing cortex response: %s", err))
+					return
+				}
+			}
 			// we can simply concatenate the responses
 			buf.Write(body)
 		case PrometheusRuleGroupsJSON:
@@ -152,6 +168,17 @@ func (a *MultiTenantRuleAggregator) Handle(c *gin.Context) {
 			}
 			for _, group := range rawData.Data.Groups {
 				raw, _ := group.MarshalJSON()
+				if restricted {
+					var ok bool
+					raw, ok, err = filterRuleGroupJSON(raw, matchers)
+					if err != nil {
+						c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("error parsing cortex response: %s", err))
+						return
+					}
+					if !ok {
+						continue
+					}
+				}
 				if groupCount > 0 {
 					buf.WriteString(",")
 				}
@@ -109,9 +109,12 @@ func (p *HttpApiServer) configureAlertmanager(router *gin.Engine, f *forwarders,
 		}
 		return
 	}
-	router.Any("/api/prom/alertmanager", m.Auth, m.RBAC, orgIdLimiter, f.Alertmanager)
-	router.Any("/api/v1/alerts", m.Auth, m.RBAC, orgIdLimiter, f.Alertmanager)
-	router.Any("/multitenant_alertmanager", m.Auth, m.RBAC, orgIdLimiter, f.Alertmanager)
+	// alerts cannot be filtered by label, so subjects with restricted metrics
+	// access cannot use the alertmanager apis
+	var le LabelEnforcer
+	router.Any("/api/prom/alertmanager", m.Auth, m.RBAC, le.DenyRestricted, orgIdLimiter, f.Alertmanager)
+	router.Any("/api/v1/alerts", m.Auth, m.RBAC, le.DenyRestricted, orgIdLimiter, f.Alertmanager)
+	router.Any("/multitenant_alertmanager", m.Auth, m.RBAC, le.DenyRestricted, orgIdLimiter, f.Alertmanager)
 }
 
 func (p *HttpApiServer) configureRuler(router *gin.Engine, f *forwarders, m *middlewares) {
@@ -120,8 +123,9 @@ func (p *HttpApiServer) configureRuler(router *gin.Engine, f *forwarders, m *mid
 	router.GET("/prometheus/api/v1/rules", m.Auth, m.RBAC, jsonAggregator.Handle)
 	router.GET("/api/prom/api/v1/rules", m.Auth, m.RBAC, jsonAggregator.Handle)
 
-	router.GET("/prometheus/api/v1/alerts", m.Auth, m.RBAC, f.Ruler)
-	router.GET("/api/prom/api/v1/alerts", m.Auth, m.RBAC, f.Ruler)
+	var le LabelEnforcer
+	router.GET("/prometheus/api/v1/alerts", m.Auth, m.RBAC, le.DenyRestricted, f.Ruler)
+	router.GET("/api/prom/api/v1/alerts", m.Auth, m.RBAC, le.DenyRestricted, f.Ruler)
 
 	yamlAggregator := NewMultiTenantRuleAggregator(
 		p.ManagementClient, p.CortexClientSet.HTTP(), orgIDCodec, NamespaceKeyedYAML)
@@ -130,23 +134,25 @@ func (p *HttpApiServer) configureRuler(router *gin.Engine, f *forwarders, m *mid
 }
 
 func (p *HttpApiServer) configureQueryFrontend(router *gin.Engine, f *forwarders, m *middlewares) {
+	var le LabelEnforcer
 	for _, group := range []*gin.RouterGroup{
 		router.Group("/prometheus/api/v1", m.Auth, m.RBAC),
 		router.Group("/api/prom/api/v1", m.Auth, m.RBAC),
 	} {
-		group.POST("/read", f.QueryFrontend)
-		group.GET("/query", f.QueryFrontend)
-		group.POST("/query", f.QueryFrontend)
-		group.GET("/query_range", f.QueryFrontend)
-		group.POST("/query_range", f.QueryFrontend)
-		group.GET("/query_exemplars", f.QueryFrontend)
-		group.POST("/query_exemplars", f.QueryFrontend)
-		group.GET("/labels", f.QueryFrontend)
-		group.POST("/labels", f.QueryFrontend)
-		group.GET("/label/:name/values", f.QueryFrontend)
-		group.GET("/series", f.QueryFrontend)
-		group.POST("/series", f.QueryFrontend)
-		group.DELETE("/series", f.QueryFrontend)
-		group.GET("/metadata", f.QueryFrontend)
+		group.POST("/read", le.RemoteRead, f.QueryFrontend)
+		group.GET("/query", le.Query, f.QueryFrontend)
+		group.POST("/query", le.Query, f.QueryFrontend)
+		group.GET("/query_range", le.Query, f.QueryFrontend)
+		group.POST("/query_range", le.Query, f.QueryFrontend)
+		group.GET("/query_exemplars", le.Query, f.QueryFrontend)
+		group.POST("/query_exemplars", le.Query, f.QueryFrontend)
+		group.GET("/labels", le.Selectors, f.QueryFrontend)
+		group.POST("/labels", le.Selectors, f.QueryFrontend)
+		group.GET("/label/:name/values", le.Selectors, f.QueryFrontend)
+		group.GET("/series", le.Selectors, f.QueryFrontend)
+		group.POST("/series", le.Selectors, f.QueryFrontend)
+		group.DELETE("/series", le.DenyRestricted, f.QueryFrontend)
+		// metadata is keyed by metric name, and cannot be filtered by label
+		group.GET("/metadata", le.DenyRestricted, f.QueryFrontend)
 	}
 }
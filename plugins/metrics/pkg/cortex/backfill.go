@@ -0,0 +1,541 @@
+package cortex
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	kitlog "github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/tsdb"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/auth/cluster"
+	"github.com/rancher/opni/pkg/task"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remotewrite"
+	metricsutil "github.com/rancher/opni/plugins/metrics/pkg/util"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/thanos/pkg/block"
+	blockmetadata "github.com/thanos-io/thanos/pkg/block/metadata"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// DefaultMaxExtractedSize is the maximum total size of the files extracted
+	// from an upload.
+	DefaultMaxExtractedSize = 32 << 30
+
+	// Samples newer than this are imported using remote write, and older
+	// samples are converted to blocks. Cortex ingesters reject samples older
+	// than half of the head block range (1h by default) behind the newest
+	// sample of a tenant, so this leaves some margin.
+	BackfillHeadWindow = 30 * time.Minute
+
+	// maximum number of samples sent in a single remote write request, or
+	// appended to a block before committing
+	backfillSamplesPerRequest = 10000
+
+	// maximum size of the OpenMetrics data parsed at once. Files are parsed in
+	// batches of whole lines, so that they are never read into memory at once.
+	openMetricsBatchSize = 1 << 20
+
+	backfillBlockSource blockmetadata.SourceType = "opni-import"
+
+	// uploads are staged under the tenant's prefix in the blocks bucket, in a
+	// directory which is ignored by Cortex since it is not a block ID
+	backfillUploadPrefix = "opni-uploads"
+)
+
+// BackfillMetadata is the metadata stored with each backfill task.
+type BackfillMetadata struct {
+	ClusterId string
+	Format    remoteread.UploadFormat
+	// Name of the uploaded snapshot in the Cortex blocks bucket, which is
+	// accessible from every gateway replica
+	Object string
+}
+
+// UploadObjectName returns the name of the object an upload is staged in
+// until it has been imported.
+func UploadObjectName(clusterId, name string) string {
+	return path.Join(clusterId, backfillUploadPrefix, name)
+}
+
+// BackfillTaskRunner imports uploaded snapshots of TSDB blocks or OpenMetrics
+// files into a cluster's tenant. Blocks are written directly to Cortex object
+// storage, since Cortex does not accept out-of-order samples through remote
+// write. Samples in OpenMetrics files are converted to blocks, except for
+// samples newer than BackfillHeadWindow, which are written through the remote
+// write forwarder so that the cluster's relabel rules and ingestion quotas
+// apply.
+type BackfillTaskRunner struct {
+	BackfillTaskRunnerConfig
+
+	util.Initializer
+}
+
+type BackfillTaskRunnerConfig struct {
+	RemoteWriter remotewrite.RemoteWriteServer `validate:"required"`
+	// Returns a client for the Cortex blocks bucket. Uploads are staged in
+	// this bucket, so imports fail if it cannot be accessed.
+	NewBucketClient func(context.Context) (objstore.Bucket, error) `validate:"required"`
+	Logger          *zap.SugaredLogger                             `validate:"required"`
+	// Maximum total size of the files extracted from an upload. Defaults to
+	// DefaultMaxExtractedSize.
+	MaxExtractedSize int64
+}
+
+func (r *BackfillTaskRunner) Initialize(conf BackfillTaskRunnerConfig) {
+	r.InitOnce(func() {
+		if err := metricsutil.Validate.Struct(conf); err != nil {
+			panic(err)
+		}
+		if conf.MaxExtractedSize <= 0 {
+			conf.MaxExtractedSize = DefaultMaxExtractedSize
+		}
+		r.BackfillTaskRunnerConfig = conf
+	})
+}
+
+func (r *BackfillTaskRunner) OnTaskPending(_ context.Context, _ task.ActiveTask) error {
+	return nil
+}
+
+func (r *BackfillTaskRunner) OnTaskRunning(ctx context.Context, ti task.ActiveTask) error {
+	r.WaitForInit()
+
+	var md BackfillMetadata
+	ti.LoadTaskMetadata(&md)
+
+	if err := r.run(ctx, ti, md); err != nil {
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			return ctx.Err()
+		}
+		ti.AddLogEntry(zapcore.ErrorLevel, err.Error())
+		return err
+	}
+	return nil
+}
+
+func (r *BackfillTaskRunner) run(ctx context.Context, ti task.ActiveTask, md BackfillMetadata) error {
+	bkt, err := r.NewBucketClient(ctx)
+	if err != nil {
+		return fmt.Errorf("cortex object storage is not accessible from the gateway: %w", err)
+	}
+
+	workDir, err := os.MkdirTemp("", "opni-backfill-*")
+	if err != nil {
+		return fmt.Errorf("failed to create working directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	rc, err := bkt.Get(ctx, md.Object)
+	if err != nil {
+		if bkt.IsObjNotFoundErr(err) {
+			return fmt.Errorf("uploaded data is no longer available, and must be uploaded again")
+		}
+		return fmt.Errorf("failed to read uploaded data: %w", err)
+	}
+	items, err := extractUpload(rc, md.Format, filepath.Join(workDir, "upload"), r.MaxExtractedSize)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+
+	// if the task was interrupted (e.g. by a gateway restart), skip the items
+	// which were already imported
+	var start int
+	if progress := ti.GetProgress(); progress != nil && progress.Total == uint64(len(items)) {
+		start = int(progress.Current)
+	}
+	ti.SetProgress(&corev1.Progress{
+		Current: uint64(start),
+		Total:   uint64(len(items)),
+	})
+
+	minRemoteWriteTime := time.Now().Add(-BackfillHeadWindow).UnixMilli()
+	for i := start; i < len(items); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		item := items[i]
+		var msg string
+		switch item.kind {
+		case backfillItemBlock:
+			err = uploadBlock(ctx, bkt, md.ClusterId, item.path)
+			msg = fmt.Sprintf("imported %s using block upload", item.name)
+		case backfillItemOpenMetrics:
+			var stats openMetricsImportStats
+			stats, err = r.importOpenMetrics(ctx, bkt, md.ClusterId, item.path, filepath.Join(workDir, "blocks"), minRemoteWriteTime)
+			msg = fmt.Sprintf("imported %s (%d blocks uploaded, %d samples written using remote write)", item.name, stats.blocks, stats.remoteWriteSamples)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %w", item.name, err)
+		}
+		ti.AddLogEntry(zapcore.InfoLevel, msg)
+		ti.SetProgress(&corev1.Progress{
+			Current: uint64(i + 1),
+			Total:   uint64(len(items)),
+		})
+	}
+	return nil
+}
+
+func (r *BackfillTaskRunner) OnTaskCompleted(ctx context.Context, ti task.ActiveTask, state task.State, _ ...any) {
+	var md BackfillMetadata
+	ti.LoadTaskMetadata(&md)
+
+	switch state {
+	case task.StateCompleted:
+		ti.AddLogEntry(zapcore.InfoLevel, "completed")
+	case task.StateFailed:
+		// errors are logged in OnTaskRunning
+	case task.StateCanceled:
+		ti.AddLogEntry(zapcore.WarnLevel, "canceled")
+	}
+
+	lg := r.Logger.With(
+		"object", md.Object,
+	)
+	bkt, err := r.NewBucketClient(ctx)
+	if err != nil {
+		lg.With(zap.Error(err)).Warn("failed to remove uploaded snapshot")
+		return
+	}
+	if err := bkt.Delete(ctx, md.Object); err != nil && !bkt.IsObjNotFoundErr(err) {
+		lg.With(zap.Error(err)).Warn("failed to remove uploaded snapshot")
+	}
+}
+
+// uploadBlock writes a block to the tenant's prefix in the Cortex blocks
+// bucket. The block is labeled with the tenant ID in the same way as blocks
+// shipped by the ingesters.
+func uploadBlock(ctx context.Context, bkt objstore.Bucket, clusterId string, dir string) error {
+	meta, err := blockmetadata.ReadFromDir(dir)
+	if err != nil {
+		return err
+	}
+	meta.Thanos.Labels = map[string]string{
+		cortex_tsdb.TenantIDExternalLabel: clusterId,
+	}
+	meta.Thanos.Source = backfillBlockSource
+	if err := meta.WriteToDir(kitlog.NewNopLogger(), dir); err != nil {
+		return err
+	}
+	return block.Upload(ctx, kitlog.NewNopLogger(), bucket.NewPrefixedBucketClient(bkt, clusterId), dir, blockmetadata.NoneFunc)
+}
+
+type openMetricsImportStats struct {
+	blocks             int
+	remoteWriteSamples int
+}
+
+// importOpenMetrics converts the samples in an OpenMetrics file which are
+// older than minRemoteWriteTime to blocks of the default block duration, in
+// the same way as `promtool tsdb create-blocks-from openmetrics`, and uploads
+// them. The file is read once for each block, so that only the samples of a
+// single block are held in memory. Newer samples are written using remote
+// write.
+func (r *BackfillTaskRunner) importOpenMetrics(
+	ctx context.Context,
+	bkt objstore.Bucket,
+	clusterId string,
+	filename string,
+	blocksDir string,
+	minRemoteWriteTime int64,
+) (stats openMetricsImportStats, _ error) {
+	mint, maxt := int64(math.MaxInt64), int64(math.MinInt64)
+	err := readOpenMetricsFile(filename, func(_ labels.Labels, t int64, _ float64) error {
+		if t >= minRemoteWriteTime {
+			stats.remoteWriteSamples++
+			return nil
+		}
+		if t < mint {
+			mint = t
+		}
+		if t > maxt {
+			maxt = t
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if mint <= maxt {
+		blockDuration := tsdb.DefaultBlockDuration
+		nextSampleTs := mint
+		for t := blockDuration * (mint / blockDuration); t <= maxt; t += blockDuration {
+			if err := ctx.Err(); err != nil {
+				return stats, err
+			}
+			tsUpper := t + blockDuration
+			if tsUpper > minRemoteWriteTime {
+				tsUpper = minRemoteWriteTime
+			}
+			if nextSampleTs >= minRemoteWriteTime {
+				break
+			}
+			if nextSampleTs >= tsUpper {
+				// no samples in this block's time range
+				continue
+			}
+			dir, err := createBlockFromOpenMetrics(ctx, filename, blocksDir, t, tsUpper, &nextSampleTs)
+			if err != nil {
+				return stats, err
+			}
+			if dir == "" {
+				continue
+			}
+			err = uploadBlock(ctx, bkt, clusterId, dir)
+			os.RemoveAll(dir)
+			if err != nil {
+				return stats, err
+			}
+			stats.blocks++
+		}
+	}
+
+	if stats.remoteWriteSamples > 0 {
+		w := r.newBatchWriter(ctx, clusterId)
+		if err := readOpenMetricsFile(filename, func(lset labels.Labels, t int64, v float64) error {
+			if t < minRemoteWriteTime {
+				return nil
+			}
+			return w.Append(lset, t, v)
+		}); err != nil {
+			return stats, err
+		}
+		if err := w.Flush(); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// createBlockFromOpenMetrics writes the samples in an OpenMetrics file within [mint, maxt)
+// to a new block in dir, and returns the block's directory. If there are no
+// samples in the time range, an empty string is returned. nextSampleTs is set
+// to the earliest sample at or after maxt, if it is earlier than its current
+// value.
+func createBlockFromOpenMetrics(ctx context.Context, filename string, dir string, mint, maxt int64, nextSampleTs *int64) (_ string, retErr error) {
+	// the block writer only accepts samples which are at most half of its
+	// block range older than the newest sample appended so far, so a range
+	// twice as large as the block is used
+	w, err := tsdb.NewBlockWriter(kitlog.NewNopLogger(), dir, 2*tsdb.DefaultBlockDuration)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := w.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+
+	app := w.Appender(ctx)
+	var samples int
+	*nextSampleTs = math.MaxInt64
+	err = readOpenMetricsFile(filename, func(lset labels.Labels, t int64, v float64) error {
+		if t < mint {
+			return nil
+		}
+		if t >= maxt {
+			if t < *nextSampleTs {
+				*nextSampleTs = t
+			}
+			return nil
+		}
+		if _, err := app.Append(0, lset, t, v); err != nil {
+			return fmt.Errorf("failed to append sample: %w", err)
+		}
+		samples++
+		if samples%backfillSamplesPerRequest == 0 {
+			if err := app.Commit(); err != nil {
+				return err
+			}
+			app = w.Appender(ctx)
+		}
+		return nil
+	})
+	if err != nil {
+		app.Rollback()
+		return "", err
+	}
+	if err := app.Commit(); err != nil {
+		return "", err
+	}
+	id, err := w.Flush(ctx)
+	if err != nil {
+		if errors.Is(err, tsdb.ErrNoSeriesAppended) {
+			return "", nil
+		}
+		return "", err
+	}
+	return filepath.Join(dir, id.String()), nil
+}
+
+func readOpenMetricsFile(filename string, fn func(labels.Labels, int64, float64) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return parseOpenMetrics(f, fn)
+}
+
+// parseOpenMetrics calls fn for each sample in OpenMetrics text data. Every
+// sample must have a timestamp. The data is parsed in batches of lines, each
+// of which is terminated with "# EOF" and starts with the last TYPE line seen,
+// so that the parser never needs the whole input.
+func parseOpenMetrics(r io.Reader, fn func(labels.Labels, int64, float64) error) error {
+	br := bufio.NewReader(r)
+	var batch, typeLine, line []byte
+	flush := func() error {
+		batch = append(batch, "# EOF\n"...)
+		if err := parseOpenMetricsBatch(batch, fn); err != nil {
+			return err
+		}
+		batch = append(batch[:0], typeLine...)
+		return nil
+	}
+	for {
+		var err error
+		line, err = readLine(br, line[:0])
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if string(bytes.TrimSpace(line)) == "# EOF" {
+			if err := flush(); err != nil {
+				return err
+			}
+			if _, err := br.Peek(1); !errors.Is(err, io.EOF) {
+				return errors.New("invalid openmetrics data: unexpected data after # EOF")
+			}
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			return errors.New("invalid openmetrics data: data does not end with # EOF")
+		}
+		if len(batch)+len(line) > openMetricsBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if bytes.HasPrefix(line, []byte("# TYPE ")) {
+			typeLine = append(typeLine[:0], line...)
+		}
+		batch = append(batch, line...)
+	}
+}
+
+// readLine appends the next line from br, including the newline, to buf.
+// Lines longer than openMetricsBatchSize are rejected.
+func readLine(br *bufio.Reader, buf []byte) ([]byte, error) {
+	for {
+		frag, err := br.ReadSlice('\n')
+		buf = append(buf, frag...)
+		if len(buf) > openMetricsBatchSize {
+			return nil, fmt.Errorf("invalid openmetrics data: line exceeds %d bytes", openMetricsBatchSize)
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return buf, err
+		}
+	}
+}
+
+func parseOpenMetricsBatch(data []byte, fn func(labels.Labels, int64, float64) error) error {
+	parser := textparse.NewOpenMetricsParser(data)
+	for {
+		entry, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid openmetrics data: %w", err)
+		}
+		if entry != textparse.EntrySeries {
+			continue
+		}
+		series, ts, v := parser.Series()
+		if ts == nil {
+			return fmt.Errorf("invalid openmetrics data: sample %q has no timestamp", series)
+		}
+		var lset labels.Labels
+		parser.Metric(&lset)
+		if err := fn(lset, *ts, v); err != nil {
+			return err
+		}
+	}
+}
+
+type batchWriter struct {
+	ctx    context.Context
+	writer remotewrite.RemoteWriteServer
+
+	series     []prompb.TimeSeries
+	lastLabels labels.Labels
+	samples    int
+}
+
+func (r *BackfillTaskRunner) newBatchWriter(ctx context.Context, clusterId string) *batchWriter {
+	return &batchWriter{
+		// the remote write forwarder reads the cluster ID from incoming
+		// metadata, as set by the cluster auth middleware for agents
+		ctx:    metadata.NewIncomingContext(ctx, metadata.Pairs(string(cluster.ClusterIDKey), clusterId)),
+		writer: r.RemoteWriter,
+	}
+}
+
+func (w *batchWriter) Append(lset labels.Labels, t int64, v float64) error {
+	if len(w.series) == 0 || !labels.Equal(w.lastLabels, lset) {
+		promLabels := make([]prompb.Label, 0, len(lset))
+		for _, l := range lset {
+			promLabels = append(promLabels, prompb.Label{Name: l.Name, Value: l.Value})
+		}
+		w.series = append(w.series, prompb.TimeSeries{Labels: promLabels})
+		w.lastLabels = lset
+	}
+	last := &w.series[len(w.series)-1]
+	last.Samples = append(last.Samples, prompb.Sample{Timestamp: t, Value: v})
+	w.samples++
+	if w.samples >= backfillSamplesPerRequest {
+		return w.Flush()
+	}
+	return nil
+}
+
+func (w *batchWriter) Flush() error {
+	if w.samples == 0 {
+		return nil
+	}
+	wr := prompb.WriteRequest{Timeseries: w.series}
+	data, err := wr.Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := w.writer.Push(w.ctx, &remotewrite.Payload{
+		Contents: snappy.Encode(nil, data),
+	}); err != nil {
+		return err
+	}
+	w.series = nil
+	w.lastLabels = nil
+	w.samples = 0
+	return nil
+}
@@ -0,0 +1,254 @@
+package cortex
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/rancher/opni/pkg/validation"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
+)
+
+type backfillItemKind int
+
+const (
+	backfillItemBlock backfillItemKind = iota
+	backfillItemOpenMetrics
+)
+
+// maximum number of files extracted from an upload
+const maxExtractedFiles = 100000
+
+// openMetricsExtensions are the file extensions recognized as OpenMetrics
+// files when the upload format is detected automatically.
+var openMetricsExtensions = []string{".om", ".txt", ".prom", ".openmetrics"}
+
+type backfillItem struct {
+	kind backfillItemKind
+	// name of the item relative to the root of the upload
+	name string
+	path string
+	size int64
+}
+
+// extractLimits bounds the total size and number of files extracted from an
+// upload, since compressed archives can expand to many times their size.
+type extractLimits struct {
+	remainingBytes int64
+	remainingFiles int
+	maxBytes       int64
+}
+
+// extractUpload extracts an uploaded snapshot into dir, and validates each
+// block or OpenMetrics file found in it. The upload can either be a tar
+// archive, or a single OpenMetrics file; both may be gzip-compressed. At most
+// maxSize bytes are extracted. Items are returned in a stable order, so that
+// an interrupted import can skip the items it has already imported.
+func extractUpload(upload io.Reader, format remoteread.UploadFormat, dir string, maxSize int64) ([]backfillItem, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	limits := &extractLimits{
+		remainingBytes: maxSize,
+		remainingFiles: maxExtractedFiles,
+		maxBytes:       maxSize,
+	}
+
+	r := bufio.NewReader(upload)
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, validation.Errorf("invalid gzip data: %v", err)
+		}
+		defer gz.Close()
+		r = bufio.NewReader(gz)
+	}
+
+	if header, _ := r.Peek(262); len(header) < 262 || string(header[257:262]) != "ustar" {
+		if format == remoteread.UploadFormat_TSDBBlocks {
+			return nil, validation.Error("TSDB blocks must be uploaded as a tar archive")
+		}
+		name := "upload.om"
+		if err := limits.writeFile(filepath.Join(dir, name), r); err != nil {
+			return nil, err
+		}
+		return validateItems([]backfillItem{{
+			kind: backfillItemOpenMetrics,
+			name: name,
+			path: filepath.Join(dir, name),
+		}})
+	}
+
+	if err := extractTar(tar.NewReader(r), dir, limits); err != nil {
+		return nil, err
+	}
+
+	var items []backfillItem
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(dir, path)
+		if d.IsDir() {
+			if _, err := os.Stat(filepath.Join(path, "meta.json")); err != nil {
+				return nil
+			}
+			size, err := dirSize(path)
+			if err != nil {
+				return err
+			}
+			items = append(items, backfillItem{
+				kind: backfillItemBlock,
+				name: name,
+				path: path,
+				size: size,
+			})
+			return filepath.SkipDir
+		}
+		if format == remoteread.UploadFormat_OpenMetrics ||
+			(format == remoteread.UploadFormat_Auto && hasOpenMetricsExtension(name)) {
+			items = append(items, backfillItem{
+				kind: backfillItemOpenMetrics,
+				name: name,
+				path: path,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, validation.Error("no TSDB blocks or OpenMetrics files were found in the upload")
+	}
+	for _, item := range items {
+		switch {
+		case format == remoteread.UploadFormat_TSDBBlocks && item.kind != backfillItemBlock,
+			format == remoteread.UploadFormat_OpenMetrics && item.kind != backfillItemOpenMetrics:
+			return nil, validation.Errorf("%s does not match the upload format %s", item.name, format)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].name < items[j].name
+	})
+	return validateItems(items)
+}
+
+func validateItems(items []backfillItem) ([]backfillItem, error) {
+	for _, item := range items {
+		switch item.kind {
+		case backfillItemBlock:
+			b, err := tsdb.OpenBlock(nil, item.path, nil)
+			if err != nil {
+				return nil, validation.Errorf("invalid block %s: %v", item.name, err)
+			}
+			id := b.Meta().ULID.String()
+			b.Close()
+			// block directories are uploaded under their ID
+			if filepath.Base(item.path) != id {
+				return nil, validation.Errorf("invalid block %s: directory name does not match the block ID %s", item.name, id)
+			}
+		case backfillItemOpenMetrics:
+			if err := readOpenMetricsFile(item.path, func(labels.Labels, int64, float64) error {
+				return nil
+			}); err != nil {
+				return nil, validation.Errorf("%s: %v", item.name, err)
+			}
+		}
+	}
+	return items, nil
+}
+
+func extractTar(tr *tar.Reader, dir string, limits *extractLimits) error {
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return validation.Errorf("invalid tar archive: %v", err)
+		}
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return validation.Errorf("invalid path in tar archive: %s", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return err
+			}
+			if err := limits.writeFile(path, tr); err != nil {
+				return err
+			}
+		default:
+			// links and other special files are not needed for blocks or
+			// metrics files, and are skipped
+		}
+	}
+}
+
+func (l *extractLimits) writeFile(path string, r io.Reader) error {
+	if l.remainingFiles <= 0 {
+		return validation.Errorf("the upload contains more than %d files", maxExtractedFiles)
+	}
+	l.remainingFiles--
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	// copy one byte more than the remaining size to detect oversized uploads
+	n, err := io.Copy(f, io.LimitReader(r, l.remainingBytes+1))
+	l.remainingBytes -= n
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if l.remainingBytes < 0 {
+		f.Close()
+		return validation.Errorf("the extracted upload exceeds the maximum size of %d bytes", l.maxBytes)
+	}
+	return f.Close()
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func hasOpenMetricsExtension(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range openMetricsExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,323 @@
+package cortex
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/tidwall/gjson"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/rancher/opni/pkg/metrics/unmarshal"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexadmin"
+)
+
+const (
+	// Maximum number of concurrent label value requests made while building a
+	// cardinality report.
+	cardinalityLabelConcurrency = 8
+	// Maximum number of concurrent series count queries made while building a
+	// cardinality report.
+	cardinalityQueryConcurrency = 4
+	// Number of metric names whose series are counted by a single query.
+	cardinalityMetricBatchSize = 100
+	// Maximum number of metric names whose series are counted in a single
+	// report. Metrics beyond this limit are omitted from the report.
+	maxCardinalityMetrics = 10000
+)
+
+// CardinalityReport summarizes which metrics and labels contribute the most
+// series in a tenant. The version of Cortex in use does not expose a
+// cardinality or TSDB status API, so series counts are obtained with PromQL
+// through the query frontend, and label cardinality from the label values API.
+//
+// To avoid a single query which touches every series in the tenant, metric
+// names are listed first and their series are counted in batches. At most
+// maxCardinalityMetrics metrics are counted, and batches which fail are
+// skipped; in either case the report is marked as partial.
+func (p *CortexAdminServer) CardinalityReport(ctx context.Context, in *cortexadmin.CardinalityRequest) (*cortexadmin.CardinalityReportResponse, error) {
+	if !p.Initialized() {
+		return nil, util.StatusError(codes.Unavailable)
+	}
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	lg := p.Logger.With(
+		"tenant", in.Tenant,
+	)
+	lg.Debug("building cardinality report")
+
+	now := time.Now()
+	compareTo := in.CompareTo.AsDuration()
+
+	names, err := p.metricNames(ctx, in.Tenant)
+	if err != nil {
+		lg.With(
+			"error", err,
+		).Error("failed to list metric names")
+		return nil, err
+	}
+	partial := false
+	if len(names) > maxCardinalityMetrics {
+		lg.With(
+			"metrics", len(names),
+			"limit", maxCardinalityMetrics,
+		).Warn("tenant has too many metrics, only counting series for some of them")
+		names = names[:maxCardinalityMetrics]
+		partial = true
+	}
+
+	var current, previous, labelValues map[string]uint64
+	var currentComplete, previousComplete bool
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() (err error) {
+		current, currentComplete, err = p.seriesCountsByMetric(ctx, in.Tenant, names, 0)
+		return
+	})
+	eg.Go(func() (err error) {
+		previous, previousComplete, err = p.seriesCountsByMetric(ctx, in.Tenant, names, compareTo)
+		return
+	})
+	eg.Go(func() (err error) {
+		labelValues, err = p.labelValueCounts(ctx, in.Tenant)
+		return
+	})
+	if err := eg.Wait(); err != nil {
+		lg.With(
+			"error", err,
+		).Error("failed to build cardinality report")
+		return nil, err
+	}
+
+	report := &cortexadmin.CardinalityReportResponse{
+		Tenant:            in.Tenant,
+		Timestamp:         timestamppb.New(now),
+		PreviousTimestamp: timestamppb.New(now.Add(-compareTo)),
+		Partial:           partial || !currentComplete || !previousComplete,
+	}
+	metrics := make([]*cortexadmin.MetricCardinality, 0, len(current))
+	for name, count := range current {
+		report.NumSeries += count
+		metrics = append(metrics, &cortexadmin.MetricCardinality{
+			MetricName:        name,
+			NumSeries:         count,
+			PreviousNumSeries: previous[name],
+		})
+	}
+	for _, count := range previous {
+		report.PreviousNumSeries += count
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].NumSeries != metrics[j].NumSeries {
+			return metrics[i].NumSeries > metrics[j].NumSeries
+		}
+		return metrics[i].MetricName < metrics[j].MetricName
+	})
+	report.TopMetrics = truncate(metrics, in.Limit)
+
+	growth := make([]*cortexadmin.MetricCardinality, 0, len(metrics))
+	for _, m := range metrics {
+		if m.SeriesGrowth() > 0 {
+			growth = append(growth, m)
+		}
+	}
+	sort.SliceStable(growth, func(i, j int) bool {
+		return growth[i].SeriesGrowth() > growth[j].SeriesGrowth()
+	})
+	report.TopGrowth = truncate(growth, in.Limit)
+
+	labels := make([]*cortexadmin.LabelCardinality, 0, len(labelValues))
+	for name, count := range labelValues {
+		labels = append(labels, &cortexadmin.LabelCardinality{
+			LabelName: name,
+			NumValues: count,
+		})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].NumValues != labels[j].NumValues {
+			return labels[i].NumValues > labels[j].NumValues
+		}
+		return labels[i].LabelName < labels[j].LabelName
+	})
+	report.TopLabels = truncate(labels, in.Limit)
+
+	return report, nil
+}
+
+// metricNames returns the sorted names of all metrics in the tenant.
+func (p *CortexAdminServer) metricNames(ctx context.Context, tenant string) ([]string, error) {
+	resp, err := p.getCortexLabelValues(ctx, &cortexadmin.LabelRequest{Tenant: tenant}, model.MetricNameLabel)
+	if err != nil {
+		return nil, err
+	}
+	values, err := readDataArray(resp)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(values))
+	for _, value := range values {
+		names = append(names, value.String())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// seriesCountsByMetric returns the number of series for each of the given
+// metric names in the tenant, as of the given offset from the current time.
+// Series are counted in batches of metric names; batches which fail are logged
+// and skipped, in which case the returned counts are incomplete.
+func (p *CortexAdminServer) seriesCountsByMetric(
+	ctx context.Context,
+	tenant string,
+	names []string,
+	offset time.Duration,
+) (_ map[string]uint64, complete bool, _ error) {
+	var mu sync.Mutex
+	counts := make(map[string]uint64, len(names))
+	complete = true
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(cardinalityQueryConcurrency)
+	for i := 0; i < len(names); i += cardinalityMetricBatchSize {
+		batch := names[i:]
+		if len(batch) > cardinalityMetricBatchSize {
+			batch = batch[:cardinalityMetricBatchSize]
+		}
+		eg.Go(func() error {
+			vector, err := p.countSeries(ctx, tenant, batch, offset)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				p.Logger.With(
+					"tenant", tenant,
+					"metrics", len(batch),
+					"error", err,
+				).Warn("failed to count series, omitting metrics from cardinality report")
+				mu.Lock()
+				complete = false
+				mu.Unlock()
+				return nil
+			}
+			mu.Lock()
+			for _, sample := range vector {
+				counts[string(sample.Metric[model.MetricNameLabel])] = uint64(sample.Value)
+			}
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, false, err
+	}
+	return counts, complete, nil
+}
+
+// countSeries queries the number of series for each of the given metric names
+// in the tenant, as of the given offset from the current time.
+func (p *CortexAdminServer) countSeries(ctx context.Context, tenant string, names []string, offset time.Duration) (model.Vector, error) {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	selector := fmt.Sprintf(`{__name__=~%s}`, strconv.Quote(strings.Join(quoted, "|")))
+	if offset > 0 {
+		selector = fmt.Sprintf("%s offset %s", selector, model.Duration(offset))
+	}
+	resp, err := p.Query(ctx, &cortexadmin.QueryRequest{
+		Tenants: []string{tenant},
+		Query:   fmt.Sprintf("count by (__name__) (%s)", selector),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, err := unmarshal.UnmarshalPrometheusResponse(resp.GetData())
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal series counts: %w", err)
+	}
+	vector, err := result.GetVector()
+	if err != nil {
+		return nil, err
+	}
+	return *vector, nil
+}
+
+// labelValueCounts returns the number of distinct values of each label name
+// in the tenant, excluding the metric name.
+func (p *CortexAdminServer) labelValueCounts(ctx context.Context, tenant string) (map[string]uint64, error) {
+	reqUrl := fmt.Sprintf(
+		"https://%s/prometheus/api/v1/labels",
+		p.Config.Cortex.QueryFrontend.HTTPAddress,
+	)
+	resp, err := p.proxyCortexToPrometheus(ctx, tenant, "GET", reqUrl, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	names, err := readDataArray(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	counts := make(map[string]uint64, len(names))
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(cardinalityLabelConcurrency)
+	for _, name := range names {
+		name := name.String()
+		if name == model.MetricNameLabel {
+			continue
+		}
+		eg.Go(func() error {
+			resp, err := p.getCortexLabelValues(ctx, &cortexadmin.LabelRequest{Tenant: tenant}, name)
+			if err != nil {
+				return err
+			}
+			values, err := readDataArray(resp)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			counts[name] = uint64(len(values))
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func readDataArray(resp *http.Response) ([]gjson.Result, error) {
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if !gjson.ValidBytes(b) {
+		return nil, fmt.Errorf("invalid json in response")
+	}
+	result := gjson.GetBytes(b, "data")
+	if !result.Exists() {
+		return nil, fmt.Errorf("no data in cortex response")
+	}
+	return result.Array(), nil
+}
+
+func truncate[T any](items []T, limit int32) []T {
+	if len(items) > int(limit) {
+		return items[:limit]
+	}
+	return items
+}
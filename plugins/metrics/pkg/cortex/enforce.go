@@ -0,0 +1,349 @@
+package cortex
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/rancher/opni/pkg/rbac"
+	"gopkg.in/yaml.v3"
+)
+
+// LabelEnforcer limits the series which can be read by subjects whose roles
+// restrict access to metrics within a cluster. Each handler must be installed
+// after the rbac middleware, and rewrites the request so that every selector
+// it contains also matches the label matchers for the authorized clusters.
+type LabelEnforcer struct{}
+
+// requestMatchers returns the label matchers to add to selectors in the
+// request, or nil if the request is not restricted. A request spanning several
+// clusters can only be rewritten if the same matchers apply to all restricted
+// clusters; the matchers are also applied to any unrestricted clusters.
+func (LabelEnforcer) requestMatchers(c *gin.Context) ([]*labels.Matcher, error) {
+	restrictions := rbac.AuthorizedLabelMatchers(c)
+	if len(restrictions) == 0 {
+		return nil, nil
+	}
+	var matchers []*labels.Matcher
+	var key string
+	for _, id := range rbac.AuthorizedClusterIDs(c) {
+		clusterMatchers, ok := restrictions[id]
+		if !ok {
+			continue
+		}
+		clusterKey := rbac.MatchersString(clusterMatchers)
+		if matchers == nil {
+			matchers, key = clusterMatchers, clusterKey
+			continue
+		}
+		if clusterKey != key {
+			return nil, fmt.Errorf("cannot query clusters with different label restrictions (%s, %s) in the same request", key, clusterKey)
+		}
+	}
+	return matchers, nil
+}
+
+func abortForbidden(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"status":    "error",
+		"errorType": "forbidden",
+		"error":     err.Error(),
+	})
+}
+
+func abortBadData(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+		"status":    "error",
+		"errorType": "bad_data",
+		"error":     err.Error(),
+	})
+}
+
+// Query rewrites the "query" parameter of instant, range, and exemplar queries.
+func (e LabelEnforcer) Query(c *gin.Context) {
+	matchers, err := e.requestMatchers(c)
+	if err != nil {
+		abortForbidden(c, err)
+		return
+	}
+	if matchers == nil {
+		return
+	}
+	if err := rewriteParams(c, func(params url.Values) error {
+		for i, query := range params["query"] {
+			rewritten, err := enforceQuery(query, matchers)
+			if err != nil {
+				return err
+			}
+			params["query"][i] = rewritten
+		}
+		return nil
+	}); err != nil {
+		abortBadData(c, err)
+	}
+}
+
+// Selectors rewrites the "match[]" parameters of the series, labels, and
+// label values endpoints. If the request has no selectors, a selector
+// containing only the enforced matchers is added.
+func (e LabelEnforcer) Selectors(c *gin.Context) {
+	matchers, err := e.requestMatchers(c)
+	if err != nil {
+		abortForbidden(c, err)
+		return
+	}
+	if matchers == nil {
+		return
+	}
+	found := false
+	if err := rewriteParams(c, func(params url.Values) error {
+		for i, selector := range params["match[]"] {
+			found = true
+			rewritten, err := enforceSelector(selector, matchers)
+			if err != nil {
+				return err
+			}
+			params["match[]"][i] = rewritten
+		}
+		return nil
+	}); err != nil {
+		abortBadData(c, err)
+		return
+	}
+	if !found {
+		query := c.Request.URL.Query()
+		query.Set("match[]", (&parser.VectorSelector{LabelMatchers: matchers}).String())
+		c.Request.URL.RawQuery = query.Encode()
+	}
+}
+
+// RemoteRead adds the enforced matchers to each query in a remote read request.
+func (e LabelEnforcer) RemoteRead(c *gin.Context) {
+	matchers, err := e.requestMatchers(c)
+	if err != nil {
+		abortForbidden(c, err)
+		return
+	}
+	if matchers == nil {
+		return
+	}
+	compressed, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		abortBadData(c, err)
+		return
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		abortBadData(c, fmt.Errorf("invalid remote read request: %w", err))
+		return
+	}
+	req := &prompb.ReadRequest{}
+	if err := req.Unmarshal(data); err != nil {
+		abortBadData(c, fmt.Errorf("invalid remote read request: %w", err))
+		return
+	}
+	for _, q := range req.Queries {
+		for _, m := range matchers {
+			q.Matchers = append(q.Matchers, &prompb.LabelMatcher{
+				Type:  prompb.LabelMatcher_Type(m.Type),
+				Name:  m.Name,
+				Value: m.Value,
+			})
+		}
+	}
+	data, err = req.Marshal()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	setRequestBody(c.Request, snappy.Encode(nil, data))
+}
+
+// DenyRestricted rejects requests from subjects whose access to metrics is
+// restricted, for endpoints whose responses cannot be filtered.
+func (LabelEnforcer) DenyRestricted(c *gin.Context) {
+	if len(rbac.AuthorizedLabelMatchers(c)) > 0 {
+		abortForbidden(c, fmt.Errorf("not allowed for subjects with restricted metrics access"))
+	}
+}
+
+// rewriteParams calls fn with the request's query parameters, and for
+// url-encoded POST requests, with its form parameters, and updates the request
+// with the modified parameters.
+func rewriteParams(c *gin.Context, fn func(url.Values) error) error {
+	query := c.Request.URL.Query()
+	if err := fn(query); err != nil {
+		return err
+	}
+	c.Request.URL.RawQuery = query.Encode()
+
+	if c.Request.Method != http.MethodPost || c.Request.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		setRequestBody(c.Request, body)
+		return nil
+	}
+	// form parameters in any other encoding would bypass the rewrite
+	if c.ContentType() != "application/x-www-form-urlencoded" {
+		return fmt.Errorf("unsupported content type %q", c.ContentType())
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return err
+	}
+	if err := fn(form); err != nil {
+		return err
+	}
+	setRequestBody(c.Request, []byte(form.Encode()))
+	return nil
+}
+
+func setRequestBody(req *http.Request, body []byte) {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// enforceQuery adds the given matchers to every selector in a PromQL query.
+func enforceQuery(query string, matchers []*labels.Matcher) (string, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return "", err
+	}
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		if vs, ok := node.(*parser.VectorSelector); ok {
+			vs.LabelMatchers = append(vs.LabelMatchers, matchers...)
+		}
+		return nil
+	})
+	return expr.String(), nil
+}
+
+// enforceSelector adds the given matchers to a series selector.
+func enforceSelector(selector string, matchers []*labels.Matcher) (string, error) {
+	parsed, err := parser.ParseMetricSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	return (&parser.VectorSelector{
+		LabelMatchers: append(parsed, matchers...),
+	}).String(), nil
+}
+
+// exprRestricted returns true if every selector in the PromQL expression
+// already includes all of the given matchers, so that the expression can only
+// read series which the matchers allow. A rule's own labels do not limit the
+// series its expression reads, so rules are only visible to restricted
+// subjects if their expression is restricted.
+func exprRestricted(expr string, matchers []*labels.Matcher) bool {
+	parsed, err := parser.ParseExpr(expr)
+	if err != nil {
+		return false
+	}
+	restricted := true
+	parser.Inspect(parsed, func(node parser.Node, _ []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		present := make(map[string]struct{}, len(vs.LabelMatchers))
+		for _, m := range vs.LabelMatchers {
+			present[m.String()] = struct{}{}
+		}
+		for _, m := range matchers {
+			if _, ok := present[m.String()]; !ok {
+				restricted = false
+				return errors.New("unrestricted selector")
+			}
+		}
+		return nil
+	})
+	return restricted
+}
+
+// filterRuleGroupJSON removes rules whose expressions are not restricted by
+// the given matchers from a rule group returned by the Prometheus rules API.
+// Fields which are not needed to filter the group are passed through
+// unchanged. Returns false if no rules are left.
+func filterRuleGroupJSON(raw json.RawMessage, matchers []*labels.Matcher) (json.RawMessage, bool, error) {
+	var group map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &group); err != nil {
+		return nil, false, err
+	}
+	var rules []map[string]json.RawMessage
+	if err := json.Unmarshal(group["rules"], &rules); err != nil {
+		return nil, false, err
+	}
+	var err error
+	filteredRules := make([]map[string]json.RawMessage, 0, len(rules))
+	for _, rule := range rules {
+		var query string
+		if err := unmarshalOptional(rule["query"], &query); err != nil {
+			return nil, false, err
+		}
+		if exprRestricted(query, matchers) {
+			filteredRules = append(filteredRules, rule)
+		}
+	}
+	if len(filteredRules) == 0 {
+		return nil, false, nil
+	}
+	if group["rules"], err = json.Marshal(filteredRules); err != nil {
+		return nil, false, err
+	}
+	filtered, err := json.Marshal(group)
+	return filtered, true, err
+}
+
+func unmarshalOptional(raw json.RawMessage, v any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// filterRuleGroupsYAML removes rules whose expressions are not restricted by
+// the given matchers from namespace-keyed rule groups returned by the ruler
+// config API.
+func filterRuleGroupsYAML(body []byte, matchers []*labels.Matcher) ([]byte, error) {
+	var namespaces map[string][]rulefmt.RuleGroup
+	if err := yaml.Unmarshal(body, &namespaces); err != nil {
+		return nil, err
+	}
+	filtered := map[string][]rulefmt.RuleGroup{}
+	for namespace, groups := range namespaces {
+		for _, group := range groups {
+			rules := make([]rulefmt.RuleNode, 0, len(group.Rules))
+			for _, rule := range group.Rules {
+				if exprRestricted(rule.Expr.Value, matchers) {
+					rules = append(rules, rule)
+				}
+			}
+			if len(rules) == 0 {
+				continue
+			}
+			group.Rules = rules
+			filtered[namespace] = append(filtered[namespace], group)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, nil
+	}
+	return yaml.Marshal(filtered)
+}
@@ -23,6 +23,24 @@ var (
 		Name:      "remote_write_requests_total",
 		Help:      "Total number of remote write requests forwarded to Cortex",
 	}, []string{"cluster_id", "code", "code_text"})
+	mDroppedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "opni",
+		Subsystem: "gateway",
+		Name:      "remote_write_dropped_series_total",
+		Help:      "Total number of series dropped by relabel rules by cluster ID",
+	}, []string{"cluster_id"})
+	mQuotaRejectedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "opni",
+		Subsystem: "gateway",
+		Name:      "remote_write_quota_rejected_requests_total",
+		Help:      "Total number of remote write requests rejected because an ingestion quota was exceeded",
+	}, []string{"cluster_id", "limit"})
+	mQuotaDroppedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "opni",
+		Subsystem: "gateway",
+		Name:      "remote_write_quota_dropped_series_total",
+		Help:      "Total number of series dropped from remote write requests because the active series limit was reached",
+	}, []string{"cluster_id"})
 )
 
 func Collectors() []prometheus.Collector {
@@ -30,5 +48,8 @@ func Collectors() []prometheus.Collector {
 		mIngestBytesTotal,
 		mIngestBytesByID,
 		mRemoteWriteRequests,
+		mDroppedSeries,
+		mQuotaRejectedRequests,
+		mQuotaDroppedSeries,
 	}
 }
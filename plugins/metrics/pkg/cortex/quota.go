@@ -0,0 +1,472 @@
+package cortex
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cortexmath "github.com/cortexproject/cortex/pkg/util/math"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/pkg/validation"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/quota"
+	metricsutil "github.com/rancher/opni/plugins/metrics/pkg/util"
+)
+
+const (
+	quotaPrefix = "quotas/"
+
+	// Rate limits allow bursts of up to this many seconds' worth of samples
+	// or bytes, so that a single remote write request is never larger than
+	// the burst size for reasonable limits.
+	quotaBurstSeconds = 10
+
+	// Series which have not been seen for this long no longer count towards
+	// the active series limit.
+	activeSeriesIdleTimeout = 10 * time.Minute
+
+	defaultUsageUpdateInterval = 15 * time.Second
+	usageRateAlpha             = 0.2
+
+	// Cached limits are refreshed periodically so that changes to cluster
+	// labels are picked up by label-selected quotas.
+	quotaCacheTTL = 1 * time.Minute
+)
+
+// QuotaEnforcer stores ingestion quotas and enforces them on metrics received
+// from downstream clusters. Sample and active series usage is only tracked
+// for clusters with a corresponding limit, since counting them requires
+// decoding each write request. Clusters which have not sent metrics for a
+// while are forgotten.
+//
+// Usage and rate limits are tracked in memory, per gateway replica, and are
+// not shared between replicas. Limits apply as configured while an agent
+// stays connected to the same replica, since it sends all of its metrics
+// through that replica. When an agent reconnects to a different replica, that
+// replica tracks the cluster's usage from zero, so the cluster may briefly
+// exceed its limits. AllClusterUsage only reports the usage seen by the
+// replica which serves the request.
+type QuotaEnforcer struct {
+	quota.UnsafeIngestQuotasServer
+	QuotaEnforcerConfig
+
+	util.Initializer
+
+	mu       sync.Mutex
+	clusters map[string]*clusterQuotaState
+}
+
+var _ quota.IngestQuotasServer = (*QuotaEnforcer)(nil)
+
+type QuotaEnforcerConfig struct {
+	PluginContext context.Context                      `validate:"required"`
+	Store         storage.KeyValueStoreT[*quota.Quota] `validate:"required"`
+	ClusterStore  storage.ClusterStore                 `validate:"required"`
+	Logger        *zap.SugaredLogger                   `validate:"required"`
+
+	// How often usage rates are updated. Defaults to 15 seconds.
+	UsageUpdateInterval time.Duration
+}
+
+type clusterQuotaState struct {
+	mu            sync.Mutex
+	limits        *quota.Limits
+	limitsExpire  time.Time
+	samplesLimit  *rate.Limiter
+	bytesLimit    *rate.Limiter
+	samplesRate   *cortexmath.EwmaRate
+	bytesRate     *cortexmath.EwmaRate
+	series        map[uint64]time.Time
+	rejected      uint64
+	droppedSeries uint64
+	trackedSeries bool
+	lastAdmitted  time.Time
+}
+
+func (q *QuotaEnforcer) Initialize(conf QuotaEnforcerConfig) {
+	q.InitOnce(func() {
+		if err := metricsutil.Validate.Struct(conf); err != nil {
+			panic(err)
+		}
+		if conf.UsageUpdateInterval <= 0 {
+			conf.UsageUpdateInterval = defaultUsageUpdateInterval
+		}
+		q.QuotaEnforcerConfig = conf
+		q.clusters = map[string]*clusterQuotaState{}
+		go q.run()
+	})
+}
+
+func (q *QuotaEnforcer) run() {
+	ticker := time.NewTicker(q.UsageUpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.PluginContext.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			q.mu.Lock()
+			for id, state := range q.clusters {
+				if idle := state.tick(now); idle {
+					delete(q.clusters, id)
+				}
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+func (q *QuotaEnforcer) ListQuotas(ctx context.Context, _ *emptypb.Empty) (*quota.QuotaList, error) {
+	q.WaitForInit()
+
+	quotas, err := q.listQuotas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &quota.QuotaList{
+		Items: quotas,
+	}, nil
+}
+
+func (q *QuotaEnforcer) GetQuota(ctx context.Context, ref *corev1.Reference) (*quota.Quota, error) {
+	q.WaitForInit()
+
+	if err := validation.Validate(ref); err != nil {
+		return nil, err
+	}
+	return q.Store.Get(ctx, quotaPrefix+ref.GetId())
+}
+
+func (q *QuotaEnforcer) PutQuota(ctx context.Context, in *quota.Quota) (*emptypb.Empty, error) {
+	q.WaitForInit()
+
+	if err := validation.Validate(in); err != nil {
+		return nil, err
+	}
+	if err := q.Store.Put(ctx, quotaPrefix+in.GetId(), in); err != nil {
+		return nil, err
+	}
+	q.invalidateLimits()
+	return &emptypb.Empty{}, nil
+}
+
+func (q *QuotaEnforcer) DeleteQuota(ctx context.Context, ref *corev1.Reference) (*emptypb.Empty, error) {
+	q.WaitForInit()
+
+	if err := validation.Validate(ref); err != nil {
+		return nil, err
+	}
+	if err := q.Store.Delete(ctx, quotaPrefix+ref.GetId()); err != nil {
+		return nil, err
+	}
+	q.invalidateLimits()
+	return &emptypb.Empty{}, nil
+}
+
+func (q *QuotaEnforcer) AllClusterUsage(_ context.Context, _ *emptypb.Empty) (*quota.ClusterUsageList, error) {
+	q.WaitForInit()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	list := &quota.ClusterUsageList{
+		Items: make([]*quota.ClusterUsage, 0, len(q.clusters)),
+	}
+	for id, state := range q.clusters {
+		list.Items = append(list.Items, state.usage(id))
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].GetClusterId() < list.Items[j].GetClusterId()
+	})
+	return list, nil
+}
+
+// LimitsFor returns the limits which apply to the given cluster.
+func (q *QuotaEnforcer) LimitsFor(ctx context.Context, clusterId string) (*quota.Limits, error) {
+	if !q.Initialized() {
+		return nil, util.StatusError(codes.Unavailable)
+	}
+	state := q.state(clusterId)
+	state.mu.Lock()
+	limits, expire := state.limits, state.limitsExpire
+	state.mu.Unlock()
+	if limits != nil && time.Now().Before(expire) {
+		return limits, nil
+	}
+
+	cluster, err := q.ClusterStore.GetCluster(ctx, &corev1.Reference{Id: clusterId})
+	if err != nil {
+		return nil, err
+	}
+	quotas, err := q.listQuotas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	limits = &quota.Limits{}
+	for _, qt := range quotas {
+		selector := qt.GetSelector()
+		if selector == nil {
+			selector = &corev1.ClusterSelector{}
+		}
+		if storage.NewSelectorPredicate[*corev1.Cluster](selector)(cluster) {
+			limits = limits.Merge(qt.GetLimits())
+		}
+	}
+
+	state.mu.Lock()
+	state.setLimits(limits)
+	state.limitsExpire = time.Now().Add(quotaCacheTTL)
+	state.mu.Unlock()
+	return limits, nil
+}
+
+// Admit records a write request of the given compressed size against the
+// cluster's limits. The decoded request is required if the limits include a
+// samples or active series limit. If the active series limit is reached,
+// series which are not already active are removed from the request, and the
+// number of series removed is returned; the caller must re-encode the request
+// if any were removed. If all series would be removed, or any other limit
+// would be exceeded, an error with code ResourceExhausted is returned.
+func (q *QuotaEnforcer) Admit(clusterId string, limits *quota.Limits, size int, wr *prompb.WriteRequest) (int, error) {
+	state := q.state(clusterId)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	dropped, err := state.admit(time.Now(), limits, size, wr)
+	if dropped > 0 {
+		state.droppedSeries += uint64(dropped)
+		mQuotaDroppedSeries.WithLabelValues(clusterId).Add(float64(dropped))
+	}
+	if err != nil {
+		state.rejected++
+		mQuotaRejectedRequests.WithLabelValues(clusterId, err.limit).Inc()
+		return 0, status.Errorf(codes.ResourceExhausted,
+			"ingestion quota exceeded for cluster %s: %s", clusterId, err.message)
+	}
+	return dropped, nil
+}
+
+func (q *QuotaEnforcer) state(clusterId string) *clusterQuotaState {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	state, ok := q.clusters[clusterId]
+	if !ok {
+		state = &clusterQuotaState{
+			samplesLimit: newLimiter(float64(rate.Inf)),
+			bytesLimit:   newLimiter(float64(rate.Inf)),
+			samplesRate:  cortexmath.NewEWMARate(usageRateAlpha, q.UsageUpdateInterval),
+			bytesRate:    cortexmath.NewEWMARate(usageRateAlpha, q.UsageUpdateInterval),
+			series:       map[uint64]time.Time{},
+		}
+		q.clusters[clusterId] = state
+	}
+	return state
+}
+
+func (q *QuotaEnforcer) invalidateLimits() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, state := range q.clusters {
+		state.mu.Lock()
+		state.limitsExpire = time.Time{}
+		state.mu.Unlock()
+	}
+}
+
+func (q *QuotaEnforcer) listQuotas(ctx context.Context) ([]*quota.Quota, error) {
+	keys, err := q.Store.ListKeys(ctx, quotaPrefix)
+	if err != nil {
+		return nil, err
+	}
+	quotas := make([]*quota.Quota, 0, len(keys))
+	for _, key := range keys {
+		qt, err := q.Store.Get(ctx, key)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get quota %q: %w", strings.TrimPrefix(key, quotaPrefix), err)
+		}
+		quotas = append(quotas, qt)
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		return quotas[i].GetId() < quotas[j].GetId()
+	})
+	return quotas, nil
+}
+
+type quotaError struct {
+	limit   string
+	message string
+}
+
+func (s *clusterQuotaState) setLimits(limits *quota.Limits) {
+	s.limits = limits
+	s.samplesLimit = updateLimiter(s.samplesLimit, limits.GetSamplesPerSecond())
+	s.bytesLimit = updateLimiter(s.bytesLimit, limits.GetBytesPerSecond())
+	if limits.GetActiveSeries() == 0 && s.trackedSeries {
+		s.series = map[uint64]time.Time{}
+		s.trackedSeries = false
+	}
+}
+
+// updateLimiter returns a new limiter if the limit has changed, or the
+// existing limiter otherwise.
+func updateLimiter(l *rate.Limiter, limit float64) *rate.Limiter {
+	if limit <= 0 {
+		limit = float64(rate.Inf)
+	}
+	if l.Limit() == rate.Limit(limit) {
+		return l
+	}
+	return newLimiter(limit)
+}
+
+func newLimiter(limit float64) *rate.Limiter {
+	if rate.Limit(limit) == rate.Inf {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	burst := int(limit * quotaBurstSeconds)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(limit), burst)
+}
+
+func (s *clusterQuotaState) admit(now time.Time, limits *quota.Limits, size int, wr *prompb.WriteRequest) (int, *quotaError) {
+	s.lastAdmitted = now
+	var newSeries []uint64
+	var dropped int
+	if limit := limits.GetActiveSeries(); limit > 0 && wr != nil {
+		if limit > quota.MaxActiveSeries {
+			limit = quota.MaxActiveSeries
+		}
+		s.trackedSeries = true
+		available := 0
+		if active := uint64(len(s.series)); active < limit {
+			available = int(limit - active)
+		}
+		seen := map[uint64]struct{}{}
+		kept := wr.Timeseries[:0]
+		for _, ts := range wr.Timeseries {
+			h := seriesHash(ts.Labels)
+			if _, ok := s.series[h]; ok {
+				s.series[h] = now
+				kept = append(kept, ts)
+				continue
+			}
+			if _, ok := seen[h]; ok {
+				kept = append(kept, ts)
+				continue
+			}
+			if len(newSeries) >= available {
+				dropped++
+				continue
+			}
+			seen[h] = struct{}{}
+			newSeries = append(newSeries, h)
+			kept = append(kept, ts)
+		}
+		wr.Timeseries = kept
+		if dropped > 0 && len(wr.Timeseries) == 0 && len(wr.Metadata) == 0 {
+			return dropped, &quotaError{
+				limit:   quota.ActiveSeries,
+				message: fmt.Sprintf("active series limit is %d", limit),
+			}
+		}
+	}
+
+	samples := 0
+	if wr != nil {
+		for _, ts := range wr.Timeseries {
+			samples += len(ts.Samples) + len(ts.Histograms)
+		}
+	}
+	var reservation *rate.Reservation
+	if limit := limits.GetSamplesPerSecond(); limit > 0 && wr != nil {
+		reservation = s.samplesLimit.ReserveN(now, samples)
+		if !reservation.OK() || reservation.DelayFrom(now) > 0 {
+			reservation.CancelAt(now)
+			return dropped, &quotaError{
+				limit:   quota.SamplesPerSecond,
+				message: fmt.Sprintf("samples per second limit is %v", limit),
+			}
+		}
+	}
+	if limit := limits.GetBytesPerSecond(); limit > 0 {
+		if r := s.bytesLimit.ReserveN(now, size); !r.OK() || r.DelayFrom(now) > 0 {
+			r.CancelAt(now)
+			if reservation != nil {
+				reservation.CancelAt(now)
+			}
+			return dropped, &quotaError{
+				limit:   quota.BytesPerSecond,
+				message: fmt.Sprintf("bytes per second limit is %v", limit),
+			}
+		}
+	}
+
+	for _, h := range newSeries {
+		s.series[h] = now
+	}
+	s.samplesRate.Add(int64(samples))
+	s.bytesRate.Add(int64(size))
+	return dropped, nil
+}
+
+// tick updates usage rates and removes idle series. Returns true if the
+// cluster has not sent any metrics for long enough that its state can be
+// discarded.
+func (s *clusterQuotaState) tick(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samplesRate.Tick()
+	s.bytesRate.Tick()
+	for h, lastSeen := range s.series {
+		if now.Sub(lastSeen) > activeSeriesIdleTimeout {
+			delete(s.series, h)
+		}
+	}
+	return len(s.series) == 0 && now.Sub(s.lastAdmitted) > activeSeriesIdleTimeout
+}
+
+func (s *clusterQuotaState) usage(clusterId string) *quota.ClusterUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limits := s.limits
+	if limits == nil {
+		limits = &quota.Limits{}
+	}
+	return &quota.ClusterUsage{
+		ClusterId:        clusterId,
+		Limits:           limits,
+		SamplesPerSecond: s.samplesRate.Rate(),
+		BytesPerSecond:   s.bytesRate.Rate(),
+		ActiveSeries:     uint64(len(s.series)),
+		RejectedRequests: s.rejected,
+		RejectedSeries:   s.droppedSeries,
+		Timestamp:        timestamppb.Now(),
+	}
+}
+
+func seriesHash(lbls []prompb.Label) uint64 {
+	ls := make(labels.Labels, 0, len(lbls))
+	for _, l := range lbls {
+		ls = append(ls, labels.Label{Name: l.Name, Value: l.Value})
+	}
+	sort.Sort(ls)
+	return ls.Hash()
+}
@@ -0,0 +1,253 @@
+package cortex
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	promrelabel "github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/pkg/validation"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/relabel"
+	metricsutil "github.com/rancher/opni/plugins/metrics/pkg/util"
+)
+
+const (
+	relabelRulePrefix = "relabel/rules/"
+
+	// Cached configs are refreshed periodically so that changes to cluster
+	// labels are picked up by label-selected rules.
+	relabelCacheTTL = 1 * time.Minute
+)
+
+// Relabeler stores relabel rules and applies them to metrics received from
+// downstream clusters.
+type Relabeler struct {
+	relabel.UnsafeRelabelingServer
+	RelabelerConfig
+
+	util.Initializer
+
+	cacheMu sync.Mutex
+	cache   map[string]cachedRelabelConfigs
+}
+
+var _ relabel.RelabelingServer = (*Relabeler)(nil)
+
+type RelabelerConfig struct {
+	Store        storage.KeyValueStoreT[*relabel.Rule] `validate:"required"`
+	ClusterStore storage.ClusterStore                  `validate:"required"`
+	Logger       *zap.SugaredLogger                    `validate:"required"`
+}
+
+type cachedRelabelConfigs struct {
+	configs []*promrelabel.Config
+	expires time.Time
+}
+
+func (r *Relabeler) Initialize(conf RelabelerConfig) {
+	r.InitOnce(func() {
+		if err := metricsutil.Validate.Struct(conf); err != nil {
+			panic(err)
+		}
+		r.RelabelerConfig = conf
+		r.cache = map[string]cachedRelabelConfigs{}
+	})
+}
+
+func (r *Relabeler) ListRules(ctx context.Context, _ *emptypb.Empty) (*relabel.RuleList, error) {
+	r.WaitForInit()
+
+	rules, err := r.listRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &relabel.RuleList{
+		Items: rules,
+	}, nil
+}
+
+func (r *Relabeler) GetRule(ctx context.Context, ref *corev1.Reference) (*relabel.Rule, error) {
+	r.WaitForInit()
+
+	if err := validation.Validate(ref); err != nil {
+		return nil, err
+	}
+	return r.Store.Get(ctx, relabelRulePrefix+ref.GetId())
+}
+
+func (r *Relabeler) PutRule(ctx context.Context, rule *relabel.Rule) (*emptypb.Empty, error) {
+	r.WaitForInit()
+
+	if err := validation.Validate(rule); err != nil {
+		return nil, err
+	}
+	if err := r.Store.Put(ctx, relabelRulePrefix+rule.GetId(), rule); err != nil {
+		return nil, err
+	}
+	r.invalidateCache()
+	return &emptypb.Empty{}, nil
+}
+
+func (r *Relabeler) DeleteRule(ctx context.Context, ref *corev1.Reference) (*emptypb.Empty, error) {
+	r.WaitForInit()
+
+	if err := validation.Validate(ref); err != nil {
+		return nil, err
+	}
+	if err := r.Store.Delete(ctx, relabelRulePrefix+ref.GetId()); err != nil {
+		return nil, err
+	}
+	r.invalidateCache()
+	return &emptypb.Empty{}, nil
+}
+
+func (r *Relabeler) GetClusterRelabelConfigs(ctx context.Context, ref *corev1.Reference) (*relabel.RelabelConfigList, error) {
+	r.WaitForInit()
+
+	if err := validation.Validate(ref); err != nil {
+		return nil, err
+	}
+	cluster, err := r.ClusterStore.GetCluster(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := r.listRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := &relabel.RelabelConfigList{}
+	for _, rule := range matchingRules(rules, cluster) {
+		list.Items = append(list.Items, rule.GetRelabelConfigs()...)
+	}
+	return list, nil
+}
+
+// ConfigsFor returns the compiled relabel configs which apply to the given
+// cluster, in the order in which they should be applied.
+func (r *Relabeler) ConfigsFor(ctx context.Context, clusterId string) ([]*promrelabel.Config, error) {
+	if !r.Initialized() {
+		return nil, util.StatusError(codes.Unavailable)
+	}
+	r.cacheMu.Lock()
+	cached, ok := r.cache[clusterId]
+	r.cacheMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.configs, nil
+	}
+
+	cluster, err := r.ClusterStore.GetCluster(ctx, &corev1.Reference{Id: clusterId})
+	if err != nil {
+		return nil, err
+	}
+	rules, err := r.listRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var configs []*promrelabel.Config
+	for _, rule := range matchingRules(rules, cluster) {
+		for i, conf := range rule.GetRelabelConfigs() {
+			compiled, err := conf.Compile(clusterId, cluster.GetLabels()[corev1.NameLabel])
+			if err != nil {
+				// rules are validated when they are stored, so this should not
+				// normally happen; skip the config rather than rejecting metrics
+				r.Logger.With(
+					zap.Error(err),
+					"rule", rule.GetId(),
+					"index", i,
+				).Warn("skipping invalid relabel config")
+				continue
+			}
+			configs = append(configs, compiled)
+		}
+	}
+
+	r.cacheMu.Lock()
+	r.cache[clusterId] = cachedRelabelConfigs{
+		configs: configs,
+		expires: time.Now().Add(relabelCacheTTL),
+	}
+	r.cacheMu.Unlock()
+	return configs, nil
+}
+
+func (r *Relabeler) invalidateCache() {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+	r.cache = map[string]cachedRelabelConfigs{}
+}
+
+func (r *Relabeler) listRules(ctx context.Context) ([]*relabel.Rule, error) {
+	keys, err := r.Store.ListKeys(ctx, relabelRulePrefix)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]*relabel.Rule, 0, len(keys))
+	for _, key := range keys {
+		rule, err := r.Store.Get(ctx, key)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get relabel rule %q: %w", strings.TrimPrefix(key, relabelRulePrefix), err)
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].GetId() < rules[j].GetId()
+	})
+	return rules, nil
+}
+
+func matchingRules(rules []*relabel.Rule, cluster *corev1.Cluster) []*relabel.Rule {
+	var matched []*relabel.Rule
+	for _, rule := range rules {
+		selector := rule.GetSelector()
+		if selector == nil {
+			selector = &corev1.ClusterSelector{}
+		}
+		if storage.NewSelectorPredicate[*corev1.Cluster](selector)(cluster) {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
+
+// RelabelWriteRequest applies the relabel configs to each series in the
+// request, removing series which are dropped. It returns the number of
+// series which were dropped.
+func RelabelWriteRequest(req *prompb.WriteRequest, configs []*promrelabel.Config) int {
+	dropped := 0
+	kept := req.Timeseries[:0]
+	for _, ts := range req.Timeseries {
+		lbls := make(labels.Labels, 0, len(ts.Labels))
+		for _, l := range ts.Labels {
+			lbls = append(lbls, labels.Label{Name: l.Name, Value: l.Value})
+		}
+		sort.Sort(lbls)
+		lbls = promrelabel.Process(lbls, configs...)
+		if len(lbls) == 0 {
+			dropped++
+			continue
+		}
+		ts.Labels = make([]prompb.Label, 0, len(lbls))
+		for _, l := range lbls {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+		}
+		kept = append(kept, ts)
+	}
+	req.Timeseries = kept
+	return dropped
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
 	"github.com/rancher/opni/pkg/auth/cluster"
 	"github.com/rancher/opni/pkg/config/v1beta1"
 	"github.com/rancher/opni/pkg/util"
@@ -35,6 +37,8 @@ type RemoteWriteForwarderConfig struct {
 	CortexClientSet ClientSet                  `validate:"required"`
 	Config          *v1beta1.GatewayConfigSpec `validate:"required"`
 	Logger          *zap.SugaredLogger         `validate:"required"`
+	Relabeler       *Relabeler                 `validate:"required"`
+	Quotas          *QuotaEnforcer             `validate:"required"`
 }
 
 func (f *RemoteWriteForwarder) Initialize(conf RemoteWriteForwarderConfig) {
@@ -81,9 +85,18 @@ func (f *RemoteWriteForwarder) Push(ctx context.Context, payload *remotewrite.Pa
 			lg.Error("error pushing metrics to cortex")
 		}
 	}()
+	contents, err := f.processPayload(ctx, clusterId, payload.Contents)
+	if err != nil {
+		return nil, err
+	}
+	if contents == nil {
+		// all series were dropped
+		return &emptypb.Empty{}, nil
+	}
+
 	url := fmt.Sprintf("https://%s/api/v1/push", f.Config.Cortex.Distributor.HTTPAddress)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url,
-		bytes.NewReader(payload.Contents))
+		bytes.NewReader(contents))
 	if err != nil {
 		return nil, err
 	}
@@ -106,6 +119,63 @@ func (f *RemoteWriteForwarder) Push(ctx context.Context, payload *remotewrite.Pa
 	return &emptypb.Empty{}, nil
 }
 
+// processPayload applies the cluster's relabel configs and ingestion quotas
+// to a snappy-compressed write request. The payload is only decoded if there
+// are relabel configs or limits which require it. Series which would exceed
+// the active series limit are removed. If every series and all metadata in
+// the request are dropped by relabel configs, nil is returned.
+func (f *RemoteWriteForwarder) processPayload(ctx context.Context, clusterId string, contents []byte) ([]byte, error) {
+	configs, err := f.Relabeler.ConfigsFor(ctx, clusterId)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := f.Quotas.LimitsFor(ctx, clusterId)
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 && limits.GetSamplesPerSecond() == 0 && limits.GetActiveSeries() == 0 {
+		if _, err := f.Quotas.Admit(clusterId, limits, len(contents), nil); err != nil {
+			return nil, err
+		}
+		return contents, nil
+	}
+
+	data, err := snappy.Decode(nil, contents)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to decompress write request: %v", err)
+	}
+	var wr prompb.WriteRequest
+	if err := wr.Unmarshal(data); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to unmarshal write request: %v", err)
+	}
+	if len(configs) > 0 {
+		if dropped := RelabelWriteRequest(&wr, configs); dropped > 0 {
+			mDroppedSeries.WithLabelValues(clusterId).Add(float64(dropped))
+		}
+		if len(wr.Timeseries) == 0 && len(wr.Metadata) == 0 {
+			return nil, nil
+		}
+		data, err = wr.Marshal()
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to marshal write request: %v", err)
+		}
+		contents = snappy.Encode(nil, data)
+	}
+	dropped, err := f.Quotas.Admit(clusterId, limits, len(contents), &wr)
+	if err != nil {
+		return nil, err
+	}
+	if dropped > 0 {
+		// series over the active series limit were removed
+		data, err = wr.Marshal()
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to marshal write request: %v", err)
+		}
+		contents = snappy.Encode(nil, data)
+	}
+	return contents, nil
+}
+
 func (f *RemoteWriteForwarder) SyncRules(ctx context.Context, payload *remotewrite.Payload) (_ *emptypb.Empty, syncErr error) {
 	if !f.Initialized() {
 		return nil, util.StatusError(codes.Unavailable)
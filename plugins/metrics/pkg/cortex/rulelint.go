@@ -0,0 +1,160 @@
+package cortex
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql/parser"
+	"google.golang.org/grpc/codes"
+
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexadmin"
+)
+
+// ValidateRules lints a rule group file without loading it into Cortex. Metric
+// names are read from the label values API rather than GetSeriesMetrics, since
+// the latter only lists the series of a single job.
+func (p *CortexAdminServer) ValidateRules(ctx context.Context, in *cortexadmin.ValidateRulesRequest) (*cortexadmin.ValidateRulesResponse, error) {
+	if !p.Initialized() {
+		return nil, util.StatusError(codes.Unavailable)
+	}
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+
+	var knownMetrics map[string]struct{}
+	if in.ClusterId != "" {
+		resp, err := p.getCortexLabelValues(ctx, &cortexadmin.LabelRequest{Tenant: in.ClusterId}, model.MetricNameLabel)
+		if err != nil {
+			p.Logger.With(
+				"cluster", in.ClusterId,
+				"error", err,
+			).Error("failed to list metric names")
+			return nil, err
+		}
+		names, err := readDataArray(resp)
+		if err != nil {
+			return nil, err
+		}
+		knownMetrics = make(map[string]struct{}, len(names))
+		for _, name := range names {
+			knownMetrics[name.String()] = struct{}{}
+		}
+	}
+
+	return &cortexadmin.ValidateRulesResponse{
+		Diagnostics: LintRuleGroups(in.YamlContent, knownMetrics),
+	}, nil
+}
+
+// LintRuleGroups parses a rule group file and returns the problems found in
+// it. Parse errors are reported as errors, in which case no other checks are
+// run. If knownMetrics is not nil, metrics selected by a rule which are not in
+// knownMetrics and are not recorded by any rule in the file are reported as
+// warnings.
+func LintRuleGroups(content []byte, knownMetrics map[string]struct{}) []*cortexadmin.RuleDiagnostic {
+	groups, errs := rulefmt.Parse(content)
+	if len(errs) > 0 {
+		diagnostics := make([]*cortexadmin.RuleDiagnostic, 0, len(errs))
+		for _, err := range errs {
+			diagnostics = append(diagnostics, &cortexadmin.RuleDiagnostic{
+				Severity: cortexadmin.RuleDiagnostic_Error,
+				Message:  err.Error(),
+			})
+		}
+		return diagnostics
+	}
+
+	recorded := map[string]struct{}{
+		"ALERTS":           {},
+		"ALERTS_FOR_STATE": {},
+	}
+	for _, group := range groups.Groups {
+		for _, rule := range group.Rules {
+			if rule.Record.Value != "" {
+				recorded[rule.Record.Value] = struct{}{}
+			}
+		}
+	}
+
+	diagnostics := []*cortexadmin.RuleDiagnostic{}
+	for _, group := range groups.Groups {
+		seen := map[string]struct{}{}
+		for _, rule := range group.Rules {
+			name := ruleName(rule)
+			key := name + labels.FromMap(rule.Labels).String()
+			if _, ok := seen[key]; ok {
+				diagnostics = append(diagnostics, &cortexadmin.RuleDiagnostic{
+					Severity: cortexadmin.RuleDiagnostic_Warning,
+					Group:    group.Name,
+					Rule:     name,
+					Message:  "duplicate rule with the same name and labels",
+				})
+			}
+			seen[key] = struct{}{}
+
+			if knownMetrics == nil {
+				continue
+			}
+			// the expression was already validated by rulefmt.Parse
+			expr, err := parser.ParseExpr(rule.Expr.Value)
+			if err != nil {
+				continue
+			}
+			for _, metric := range selectedMetrics(expr) {
+				if _, ok := recorded[metric]; ok {
+					continue
+				}
+				if _, ok := knownMetrics[metric]; ok {
+					continue
+				}
+				diagnostics = append(diagnostics, &cortexadmin.RuleDiagnostic{
+					Severity: cortexadmin.RuleDiagnostic_Warning,
+					Group:    group.Name,
+					Rule:     name,
+					Message:  fmt.Sprintf("unknown metric %q", metric),
+				})
+			}
+		}
+	}
+	return diagnostics
+}
+
+func ruleName(rule rulefmt.RuleNode) string {
+	if rule.Alert.Value != "" {
+		return rule.Alert.Value
+	}
+	return rule.Record.Value
+}
+
+// selectedMetrics returns the sorted names of metrics selected by name in an
+// expression. Selectors which only match names by regex are ignored.
+func selectedMetrics(expr parser.Expr) []string {
+	names := map[string]struct{}{}
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		if vs.Name != "" {
+			names[vs.Name] = struct{}{}
+			return nil
+		}
+		for _, m := range vs.LabelMatchers {
+			if m.Name == model.MetricNameLabel && m.Type == labels.MatchEqual {
+				names[m.Value] = struct{}{}
+			}
+		}
+		return nil
+	})
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,532 @@
+package cortex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/tsdb"
+	"golang.org/x/exp/slices"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexadmin"
+)
+
+const (
+	defaultRuleTestInterval = model.Duration(time.Minute)
+
+	// Limits applied to a single TestRules request, across all test groups.
+	// Input series are expanded in memory and every evaluation step runs every
+	// rule, so both are bounded before any test group is run.
+	maxRuleTestInputSamples = 1_000_000
+	maxRuleTestEvalSteps    = 100_000
+	ruleTestTimeout         = 30 * time.Second
+)
+
+// TestRules runs unit tests against a rule group file without loading it into
+// Cortex. Test failures are reported in the response; an error is only
+// returned if either file cannot be parsed.
+func (p *CortexAdminServer) TestRules(ctx context.Context, in *cortexadmin.TestRulesRequest) (*cortexadmin.TestRulesResponse, error) {
+	if !p.Initialized() {
+		return nil, util.StatusError(codes.Unavailable)
+	}
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+
+	results, err := RunRuleTests(ctx, in.RulesYaml, in.TestsYaml)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, status.Error(codes.DeadlineExceeded, err.Error())
+		}
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &cortexadmin.TestRulesResponse{
+		Results: results,
+	}, nil
+}
+
+// The unit test file format is the same as the one used by
+// `promtool test rules`, except that rules are not loaded from rule_files.
+// See https://prometheus.io/docs/prometheus/latest/configuration/unit_testing_rules/
+type ruleTestFile struct {
+	RuleFiles          []string        `yaml:"rule_files,omitempty"`
+	EvaluationInterval model.Duration  `yaml:"evaluation_interval,omitempty"`
+	GroupEvalOrder     []string        `yaml:"group_eval_order,omitempty"`
+	Tests              []ruleTestGroup `yaml:"tests"`
+}
+
+type ruleTestGroup struct {
+	Interval        model.Duration       `yaml:"interval,omitempty"`
+	InputSeries     []ruleTestSeries     `yaml:"input_series"`
+	AlertRuleTests  []alertRuleTestCase  `yaml:"alert_rule_test,omitempty"`
+	PromqlExprTests []promqlExprTestCase `yaml:"promql_expr_test,omitempty"`
+	ExternalLabels  labels.Labels        `yaml:"external_labels,omitempty"`
+	ExternalURL     string               `yaml:"external_url,omitempty"`
+	TestGroupName   string               `yaml:"name,omitempty"`
+}
+
+type ruleTestSeries struct {
+	Series string `yaml:"series"`
+	Values string `yaml:"values"`
+}
+
+type alertRuleTestCase struct {
+	EvalTime  model.Duration  `yaml:"eval_time"`
+	Alertname string          `yaml:"alertname"`
+	ExpAlerts []expectedAlert `yaml:"exp_alerts"`
+}
+
+type expectedAlert struct {
+	ExpLabels      map[string]string `yaml:"exp_labels"`
+	ExpAnnotations map[string]string `yaml:"exp_annotations"`
+}
+
+type promqlExprTestCase struct {
+	Expr       string           `yaml:"expr"`
+	EvalTime   model.Duration   `yaml:"eval_time"`
+	ExpSamples []expectedSample `yaml:"exp_samples"`
+}
+
+type expectedSample struct {
+	Labels string  `yaml:"labels"`
+	Value  float64 `yaml:"value"`
+}
+
+type inputSample struct {
+	lset labels.Labels
+	t    int64
+	v    float64
+}
+
+// RunRuleTests runs promtool-style unit tests against the rule groups in
+// rulesYaml, returning one result per test group in testsYaml. Each test group
+// is run against its own temporary TSDB. As with promtool, every rule group is
+// evaluated at each evaluation interval, regardless of the group's interval.
+// Tests which would expand to too many input samples or evaluation steps are
+// rejected before running, and all test groups must complete within
+// ruleTestTimeout.
+func RunRuleTests(ctx context.Context, rulesYaml, testsYaml []byte) ([]*cortexadmin.RuleTestResult, error) {
+	groups, errs := rulefmt.Parse(rulesYaml)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid rules: %w", errors.Join(errs...))
+	}
+
+	var testFile ruleTestFile
+	decoder := yaml.NewDecoder(bytes.NewReader(testsYaml))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&testFile); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid tests: %w", err)
+	}
+	if testFile.EvaluationInterval == 0 {
+		testFile.EvaluationInterval = defaultRuleTestInterval
+	}
+	ordered, err := orderRuleGroups(groups.Groups, testFile.GroupEvalOrder)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tests: %w", err)
+	}
+	if err := testFile.checkLimits(); err != nil {
+		return nil, fmt.Errorf("invalid tests: %w", err)
+	}
+
+	ctx, ca := context.WithTimeout(ctx, ruleTestTimeout)
+	defer ca()
+	results := make([]*cortexadmin.RuleTestResult, 0, len(testFile.Tests))
+	for i, tg := range testFile.Tests {
+		name := tg.TestGroupName
+		if name == "" {
+			name = fmt.Sprintf("test %d", i+1)
+		}
+		results = append(results, &cortexadmin.RuleTestResult{
+			Name:     name,
+			Failures: tg.run(ctx, ordered, time.Duration(testFile.EvaluationInterval)),
+		})
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("rule tests did not complete within %s: %w", ruleTestTimeout, err)
+		}
+	}
+	return results, nil
+}
+
+// checkLimits estimates the number of input samples and evaluation steps in
+// all test groups without expanding the input series.
+func (f *ruleTestFile) checkLimits() error {
+	evalInterval := time.Duration(f.EvaluationInterval)
+	var samples, steps int64
+	for _, tg := range f.Tests {
+		for _, s := range tg.InputSeries {
+			samples += seriesValueCount(s.Values)
+			if samples > maxRuleTestInputSamples {
+				return fmt.Errorf("input series must not contain more than %d samples", maxRuleTestInputSamples)
+			}
+		}
+		steps += int64(tg.maxEvalTime()/evalInterval) + 1
+		if steps > maxRuleTestEvalSteps {
+			return fmt.Errorf("tests must not require more than %d evaluation steps (eval_time / evaluation_interval)", maxRuleTestEvalSteps)
+		}
+	}
+	return nil
+}
+
+// seriesValueCount returns an upper bound on the number of values in an
+// input series, counting each expanding notation ('a+bxn', '_xn') as n+1
+// values.
+func seriesValueCount(values string) int64 {
+	var count int64
+	for _, field := range strings.Fields(values) {
+		idx := strings.LastIndexByte(field, 'x')
+		if idx < 0 {
+			count++
+			continue
+		}
+		n, err := strconv.ParseInt(field[idx+1:], 10, 64)
+		if err != nil || n < 0 {
+			// not an expansion; invalid values are reported by the parser
+			count++
+			continue
+		}
+		if n >= maxRuleTestInputSamples {
+			// avoid overflow; any such series exceeds the limit
+			return maxRuleTestInputSamples + 1
+		}
+		count += n + 1
+	}
+	return count
+}
+
+func orderRuleGroups(groups []rulefmt.RuleGroup, order []string) ([]rulefmt.RuleGroup, error) {
+	if len(order) == 0 {
+		return groups, nil
+	}
+	if len(order) != len(groups) {
+		return nil, fmt.Errorf("group_eval_order must list all %d rule groups", len(groups))
+	}
+	ordered := make([]rulefmt.RuleGroup, 0, len(groups))
+	for _, name := range order {
+		idx := slices.IndexFunc(groups, func(g rulefmt.RuleGroup) bool {
+			return g.Name == name
+		})
+		if idx < 0 {
+			return nil, fmt.Errorf("group_eval_order: unknown rule group %q", name)
+		}
+		ordered = append(ordered, groups[idx])
+	}
+	return ordered, nil
+}
+
+// run evaluates the rule groups from time zero until the last eval time of
+// any test case, and returns the failures of each test case.
+func (tg *ruleTestGroup) run(ctx context.Context, ruleGroups []rulefmt.RuleGroup, evalInterval time.Duration) []string {
+	if tg.Interval == 0 {
+		tg.Interval = defaultRuleTestInterval
+	}
+	inputs, err := tg.inputSamples()
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	dir, err := os.MkdirTemp("", "opni-rule-test-")
+	if err != nil {
+		return []string{err.Error()}
+	}
+	defer os.RemoveAll(dir)
+	db, err := tsdb.Open(dir, kitlog.NewNopLogger(), nil, tsdb.DefaultOptions(), nil)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	defer db.Close()
+
+	engine := promql.NewEngine(promql.EngineOpts{
+		Logger:     kitlog.NewNopLogger(),
+		MaxSamples: 50000000,
+		Timeout:    ruleTestTimeout,
+		NoStepSubqueryIntervalFn: func(int64) int64 {
+			return evalInterval.Milliseconds()
+		},
+		EnableAtModifier:     true,
+		EnableNegativeOffset: true,
+	})
+	externalURL, err := url.Parse(tg.ExternalURL)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid external_url: %v", err)}
+	}
+	opts := &rules.ManagerOptions{
+		ExternalURL: externalURL,
+		QueryFunc:   rules.EngineQueryFunc(engine, db),
+		NotifyFunc:  func(context.Context, string, ...*rules.Alert) {},
+		Context:     ctx,
+		Appendable:  db,
+		Queryable:   db,
+		Logger:      kitlog.NewNopLogger(),
+	}
+	groups, err := tg.newRuleGroups(ruleGroups, evalInterval, opts)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	maxEvalTime := tg.maxEvalTime()
+
+	// input samples are appended in time order as evaluation progresses, so
+	// that samples written by rules are never out of order
+	next := 0
+	appendUntil := func(t int64) error {
+		app := db.Appender(ctx)
+		for ; next < len(inputs) && inputs[next].t <= t; next++ {
+			if _, err := app.Append(0, inputs[next].lset, inputs[next].t, inputs[next].v); err != nil {
+				app.Rollback()
+				return err
+			}
+		}
+		return app.Commit()
+	}
+
+	var failures []string
+	for ts := time.Duration(0); ts <= maxEvalTime; ts += evalInterval {
+		if err := ctx.Err(); err != nil {
+			return append(failures, fmt.Sprintf("time %s: %v", model.Duration(ts), err))
+		}
+		if err := appendUntil(ts.Milliseconds()); err != nil {
+			return append(failures, err.Error())
+		}
+		for _, g := range groups {
+			g.Eval(ctx, time.UnixMilli(ts.Milliseconds()))
+			for _, r := range g.Rules() {
+				if err := r.LastError(); err != nil {
+					return append(failures, fmt.Sprintf("group %q, rule %q, time %s: %v", g.Name(), r.Name(), model.Duration(ts), err))
+				}
+			}
+		}
+
+		// test cases are checked against the last evaluation at or before
+		// their eval time
+		for _, tc := range tg.AlertRuleTests {
+			if d := time.Duration(tc.EvalTime); d >= ts && d < ts+evalInterval {
+				if failure := tc.check(groups); failure != "" {
+					failures = append(failures, failure)
+				}
+			}
+		}
+		for _, tc := range tg.PromqlExprTests {
+			if d := time.Duration(tc.EvalTime); d >= ts && d < ts+evalInterval {
+				if err := appendUntil(d.Milliseconds()); err != nil {
+					return append(failures, err.Error())
+				}
+				if failure := tc.check(ctx, engine, db); failure != "" {
+					failures = append(failures, failure)
+				}
+			}
+		}
+	}
+	return failures
+}
+
+// maxEvalTime returns the latest eval time of any test case in the group.
+func (tg *ruleTestGroup) maxEvalTime() time.Duration {
+	var maxEvalTime time.Duration
+	for _, tc := range tg.AlertRuleTests {
+		if d := time.Duration(tc.EvalTime); d > maxEvalTime {
+			maxEvalTime = d
+		}
+	}
+	for _, tc := range tg.PromqlExprTests {
+		if d := time.Duration(tc.EvalTime); d > maxEvalTime {
+			maxEvalTime = d
+		}
+	}
+	return maxEvalTime
+}
+
+func (tg *ruleTestGroup) inputSamples() ([]inputSample, error) {
+	var samples []inputSample
+	interval := time.Duration(tg.Interval).Milliseconds()
+	for _, s := range tg.InputSeries {
+		lset, values, err := parser.ParseSeriesDesc(s.Series + " " + s.Values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input series %q: %w", s.Series, err)
+		}
+		for i, v := range values {
+			if v.Omitted {
+				continue
+			}
+			samples = append(samples, inputSample{
+				lset: lset,
+				t:    int64(i) * interval,
+				v:    v.Value,
+			})
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].t < samples[j].t
+	})
+	return samples, nil
+}
+
+func (tg *ruleTestGroup) newRuleGroups(ruleGroups []rulefmt.RuleGroup, evalInterval time.Duration, opts *rules.ManagerOptions) ([]*rules.Group, error) {
+	groups := make([]*rules.Group, 0, len(ruleGroups))
+	for _, rg := range ruleGroups {
+		groupRules := make([]rules.Rule, 0, len(rg.Rules))
+		for _, r := range rg.Rules {
+			expr, err := parser.ParseExpr(r.Expr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("group %q: %w", rg.Name, err)
+			}
+			if r.Alert.Value != "" {
+				groupRules = append(groupRules, rules.NewAlertingRule(
+					r.Alert.Value,
+					expr,
+					time.Duration(r.For),
+					labels.FromMap(r.Labels),
+					labels.FromMap(r.Annotations),
+					tg.ExternalLabels,
+					tg.ExternalURL,
+					true,
+					opts.Logger,
+				))
+			} else {
+				groupRules = append(groupRules, rules.NewRecordingRule(
+					r.Record.Value,
+					expr,
+					labels.FromMap(r.Labels),
+				))
+			}
+		}
+		interval := evalInterval
+		if rg.Interval != 0 {
+			interval = time.Duration(rg.Interval)
+		}
+		groups = append(groups, rules.NewGroup(rules.GroupOptions{
+			Name:     rg.Name,
+			File:     "rules.yaml",
+			Interval: interval,
+			Limit:    rg.Limit,
+			Rules:    groupRules,
+			Opts:     opts,
+		}))
+	}
+	return groups, nil
+}
+
+func (tc *alertRuleTestCase) check(groups []*rules.Group) string {
+	var got []string
+	for _, g := range groups {
+		for _, ar := range g.AlertingRules() {
+			if ar.Name() != tc.Alertname {
+				continue
+			}
+			for _, a := range ar.ActiveAlerts() {
+				if a.State == rules.StateFiring {
+					got = append(got, formatAlert(a.Labels, a.Annotations))
+				}
+			}
+		}
+	}
+	exp := make([]string, 0, len(tc.ExpAlerts))
+	for _, a := range tc.ExpAlerts {
+		lset := labels.FromMap(a.ExpLabels)
+		lset = labels.NewBuilder(lset).Set(labels.AlertName, tc.Alertname).Labels(nil)
+		exp = append(exp, formatAlert(lset, labels.FromMap(a.ExpAnnotations)))
+	}
+	sort.Strings(got)
+	sort.Strings(exp)
+	if slices.Equal(got, exp) {
+		return ""
+	}
+	return fmt.Sprintf("alertname: %s, time: %s, exp: [%s], got: [%s]",
+		tc.Alertname, tc.EvalTime, strings.Join(exp, ", "), strings.Join(got, ", "))
+}
+
+func formatAlert(lset, annotations labels.Labels) string {
+	return fmt.Sprintf("labels: %s, annotations: %s", lset.String(), annotations.String())
+}
+
+func (tc *promqlExprTestCase) check(ctx context.Context, engine *promql.Engine, db *tsdb.DB) string {
+	evalTime := time.UnixMilli(time.Duration(tc.EvalTime).Milliseconds())
+	failure := func(format string, args ...any) string {
+		return fmt.Sprintf("expr: %q, time: %s, ", tc.Expr, tc.EvalTime) + fmt.Sprintf(format, args...)
+	}
+
+	q, err := engine.NewInstantQuery(db, nil, tc.Expr, evalTime)
+	if err != nil {
+		return failure("%v", err)
+	}
+	defer q.Close()
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		return failure("%v", res.Err)
+	}
+
+	var got []promql.Sample
+	switch v := res.Value.(type) {
+	case promql.Vector:
+		got = v
+	case promql.Scalar:
+		got = []promql.Sample{{Point: promql.Point{T: v.T, V: v.V}, Metric: labels.EmptyLabels()}}
+	default:
+		return failure("expression type %s is not supported", res.Value.Type())
+	}
+
+	exp := make([]promql.Sample, 0, len(tc.ExpSamples))
+	for _, s := range tc.ExpSamples {
+		lset := labels.EmptyLabels()
+		if s.Labels != "" {
+			lset, err = parser.ParseMetric(s.Labels)
+			if err != nil {
+				return failure("invalid labels %q: %v", s.Labels, err)
+			}
+		}
+		exp = append(exp, promql.Sample{Point: promql.Point{V: s.Value}, Metric: lset})
+	}
+
+	byLabels := func(samples []promql.Sample) func(i, j int) bool {
+		return func(i, j int) bool {
+			return labels.Compare(samples[i].Metric, samples[j].Metric) < 0
+		}
+	}
+	sort.Slice(got, byLabels(got))
+	sort.Slice(exp, byLabels(exp))
+	equal := len(got) == len(exp)
+	for i := 0; equal && i < len(got); i++ {
+		equal = labels.Equal(got[i].Metric, exp[i].Metric) && almostEqual(got[i].V, exp[i].V)
+	}
+	if equal {
+		return ""
+	}
+	return failure("exp: %s, got: %s", formatSamples(exp), formatSamples(got))
+}
+
+func formatSamples(samples []promql.Sample) string {
+	strs := make([]string, len(samples))
+	for i, s := range samples {
+		strs[i] = fmt.Sprintf("%s %g", s.Metric.String(), s.V)
+	}
+	return "[" + strings.Join(strs, ", ") + "]"
+}
+
+func almostEqual(a, b float64) bool {
+	if math.IsNaN(a) && math.IsNaN(b) {
+		return true
+	}
+	if a == b {
+		return true
+	}
+	return math.Abs(a-b)/math.Max(math.Abs(a), math.Abs(b)) < 1e-6
+}
@@ -1,16 +1,35 @@
 package drivers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexops"
+	"github.com/thanos-io/objstore"
 )
 
 type ClusterDriver interface {
-	cortexops.CortexOpsServer
+	GetClusterConfiguration(context.Context, *emptypb.Empty) (*cortexops.ClusterConfiguration, error)
+	ConfigureCluster(context.Context, *cortexops.ClusterConfiguration) (*emptypb.Empty, error)
+	GetClusterStatus(context.Context, *emptypb.Empty) (*cortexops.InstallStatus, error)
+	UninstallCluster(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	// SetTenantLimitOverrides replaces all per-tenant limit overrides in the
+	// Cortex runtime config. Overrides are keyed by tenant (cluster) ID, and
+	// use the field names from the Cortex limits config.
+	SetTenantLimitOverrides(context.Context, map[string]*structpb.Struct) error
+	// NewBucketClient returns a client for the object storage bucket Cortex
+	// stores blocks in. Blocks for a tenant are stored under the tenant ID.
+	// If the bucket cannot be accessed by the gateway, an error with code
+	// FailedPrecondition or Unimplemented is returned.
+	NewBucketClient(context.Context) (objstore.Bucket, error)
 	// Unique name of the driver
 	Name() string
 	// ShouldDisableNode is called during node sync for nodes which otherwise
@@ -90,3 +109,11 @@ func (d *NoopClusterDriver) ShouldDisableNode(*corev1.Reference) error {
 	// the noop driver will never forcefully disable a node
 	return nil
 }
+
+func (d *NoopClusterDriver) SetTenantLimitOverrides(context.Context, map[string]*structpb.Struct) error {
+	return status.Error(codes.Unimplemented, "method SetTenantLimitOverrides not implemented")
+}
+
+func (d *NoopClusterDriver) NewBucketClient(context.Context) (objstore.Bucket, error) {
+	return nil, status.Error(codes.Unimplemented, "method NewBucketClient not implemented")
+}
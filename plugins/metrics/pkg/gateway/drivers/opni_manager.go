@@ -8,15 +8,23 @@ import (
 	"time"
 
 	"github.com/banzaicloud/k8s-objectmatcher/patch"
+	"github.com/cortexproject/cortex/pkg/storage/bucket"
+	kitlog "github.com/go-kit/log"
 	"github.com/rancher/opni/apis"
 	corev1beta1 "github.com/rancher/opni/apis/core/v1beta1"
 	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	storagev1 "github.com/rancher/opni/pkg/apis/storage/v1"
+	"github.com/rancher/opni/pkg/resources/monitoring/cortex"
 	"github.com/rancher/opni/pkg/util/k8sutil"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexops"
 	"github.com/samber/lo"
+	"github.com/thanos-io/objstore"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/yaml.v3"
 	v1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -295,3 +303,110 @@ func (k *OpniManager) ShouldDisableNode(_ *corev1.Reference) error {
 		return nil
 	}
 }
+
+const (
+	runtimeConfigName = "cortex-runtime-config"
+	runtimeConfigKey  = "runtime_config.yaml"
+)
+
+func (k *OpniManager) SetTenantLimitOverrides(ctx context.Context, overrides map[string]*structpb.Struct) error {
+	tenants := map[string]*yaml.Node{}
+	for id, limits := range overrides {
+		node, err := structToYAMLNode(limits)
+		if err != nil {
+			return fmt.Errorf("failed to encode limits for tenant %s: %w", id, err)
+		}
+		tenants[id] = node
+	}
+
+	return retry.OnError(retry.DefaultBackoff, k8serrors.IsConflict, func() error {
+		cm := &v1.ConfigMap{}
+		err := k.k8sClient.Get(ctx, types.NamespacedName{
+			Namespace: k.monitoringCluster.Namespace,
+			Name:      runtimeConfigName,
+		}, cm)
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				return status.Error(codes.FailedPrecondition, "cortex runtime config not found; is the cortex cluster installed?")
+			}
+			return fmt.Errorf("failed to get cortex runtime config: %w", err)
+		}
+
+		// preserve any other runtime config settings
+		runtimeConfig := map[string]any{}
+		if err := yaml.Unmarshal([]byte(cm.Data[runtimeConfigKey]), &runtimeConfig); err != nil {
+			return fmt.Errorf("failed to parse existing cortex runtime config: %w", err)
+		}
+		if runtimeConfig == nil {
+			runtimeConfig = map[string]any{}
+		}
+		if len(tenants) > 0 {
+			runtimeConfig["overrides"] = tenants
+		} else {
+			delete(runtimeConfig, "overrides")
+		}
+		data, err := yaml.Marshal(runtimeConfig)
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[runtimeConfigKey] = string(data)
+		return k.k8sClient.Update(ctx, cm)
+	})
+}
+
+// structToYAMLNode converts limits to a yaml node. Numbers in a Struct are
+// always doubles, so the limits are round-tripped through json to preserve
+// integer formatting, which Cortex requires when decoding integer limits.
+func structToYAMLNode(limits *structpb.Struct) (*yaml.Node, error) {
+	data, err := protojson.Marshal(limits)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	node := doc.Content[0]
+	// json is decoded using flow style with quoted keys; use block style and
+	// plain keys for readability. String values stay quoted so they are not
+	// reinterpreted as numbers or booleans.
+	clearYAMLStyle(node)
+	return node, nil
+}
+
+func clearYAMLStyle(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode && node.Kind != yaml.SequenceNode {
+		return
+	}
+	node.Style = 0
+	for i, child := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			child.Style = 0
+			continue
+		}
+		clearYAMLStyle(child)
+	}
+}
+
+func (k *OpniManager) NewBucketClient(ctx context.Context) (objstore.Bucket, error) {
+	mc := k.newMonitoringCluster()
+	if err := k.k8sClient.Get(ctx, client.ObjectKeyFromObject(mc), mc); err != nil {
+		return nil, fmt.Errorf("failed to get monitoring cluster: %w", err)
+	}
+	spec := mc.Spec.Cortex.Storage
+	if spec == nil || spec.GetBackend() == storagev1.Filesystem {
+		// filesystem storage is local to the cortex pods
+		return nil, status.Error(codes.FailedPrecondition, "cortex storage is not accessible from the gateway when using the filesystem backend")
+	}
+	bkt, err := bucket.NewClient(ctx, cortex.BucketConfig(spec), "opni-gateway", kitlog.NewNopLogger(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bucket client: %w", err)
+	}
+	return bkt, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/rancher/opni/pkg/auth"
 	"github.com/rancher/opni/pkg/config/v1beta1"
 	"github.com/rancher/opni/pkg/logger"
+	"github.com/rancher/opni/pkg/machinery"
 	"github.com/rancher/opni/pkg/metrics/collector"
 	httpext "github.com/rancher/opni/pkg/plugins/apis/apiextensions/http"
 	managementext "github.com/rancher/opni/pkg/plugins/apis/apiextensions/management"
@@ -22,10 +23,13 @@ import (
 	"github.com/rancher/opni/pkg/util/future"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexadmin"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexops"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/quota"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/relabel"
 	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
 	"github.com/rancher/opni/plugins/metrics/pkg/backend"
 	"github.com/rancher/opni/plugins/metrics/pkg/cortex"
 	"github.com/rancher/opni/plugins/metrics/pkg/gateway/drivers"
+	"github.com/spf13/afero"
 	"go.uber.org/zap"
 )
 
@@ -40,8 +44,11 @@ type Plugin struct {
 	cortexAdmin       cortex.CortexAdminServer
 	cortexHttp        cortex.HttpApiServer
 	cortexRemoteWrite cortex.RemoteWriteForwarder
+	relabeler         cortex.Relabeler
+	quotas            cortex.QuotaEnforcer
 	metrics           backend.MetricsBackend
 	uninstallRunner   cortex.UninstallTaskRunner
+	backfillRunner    cortex.BackfillTaskRunner
 
 	config              future.Future[*v1beta1.GatewayConfig]
 	authMw              future.Future[map[string]auth.Middleware]
@@ -51,8 +58,13 @@ type Plugin struct {
 	cortexTlsConfig     future.Future[*tls.Config]
 	cortexClientSet     future.Future[cortex.ClientSet]
 	uninstallController future.Future[*task.Controller]
+	uploadController    future.Future[*task.Controller]
 	clusterDriver       future.Future[drivers.ClusterDriver]
 	delegate            future.Future[streamext.StreamDelegate[remoteread.RemoteReadAgentClient]]
+	relabelRuleStore    future.Future[storage.KeyValueStoreT[*relabel.Rule]]
+	quotaStore          future.Future[storage.KeyValueStoreT[*quota.Quota]]
+	tenantLimitsStore   future.Future[storage.KeyValueStoreT[*cortexops.TenantLimitsSpec]]
+	remoteReadStore     future.Future[storage.KeyValueStoreT[*remoteread.Target]]
 }
 
 func NewPlugin(ctx context.Context) *Plugin {
@@ -71,8 +83,13 @@ func NewPlugin(ctx context.Context) *Plugin {
 		cortexTlsConfig:     future.New[*tls.Config](),
 		cortexClientSet:     future.New[cortex.ClientSet](),
 		uninstallController: future.New[*task.Controller](),
+		uploadController:    future.New[*task.Controller](),
 		clusterDriver:       future.New[drivers.ClusterDriver](),
 		delegate:            future.New[streamext.StreamDelegate[remoteread.RemoteReadAgentClient]](),
+		relabelRuleStore:    future.New[storage.KeyValueStoreT[*relabel.Rule]](),
+		quotaStore:          future.New[storage.KeyValueStoreT[*quota.Quota]](),
+		tenantLimitsStore:   future.New[storage.KeyValueStoreT[*cortexops.TenantLimitsSpec]](),
+		remoteReadStore:     future.New[storage.KeyValueStoreT[*remoteread.Target]](),
 	}
 
 	future.Wait2(p.cortexClientSet, p.config,
@@ -90,6 +107,27 @@ func NewPlugin(ctx context.Context) *Plugin {
 				CortexClientSet: cortexClientSet,
 				Config:          &config.Spec,
 				Logger:          p.logger.Named("cortex-rw"),
+				Relabeler:       &p.relabeler,
+				Quotas:          &p.quotas,
+			})
+		})
+
+	future.Wait2(p.relabelRuleStore, p.storageBackend,
+		func(relabelRuleStore storage.KeyValueStoreT[*relabel.Rule], storageBackend storage.Backend) {
+			p.relabeler.Initialize(cortex.RelabelerConfig{
+				Store:        relabelRuleStore,
+				ClusterStore: storageBackend,
+				Logger:       p.logger.Named("relabel"),
+			})
+		})
+
+	future.Wait2(p.quotaStore, p.storageBackend,
+		func(quotaStore storage.KeyValueStoreT[*quota.Quota], storageBackend storage.Backend) {
+			p.quotas.Initialize(cortex.QuotaEnforcerConfig{
+				PluginContext: p.ctx,
+				Store:         quotaStore,
+				ClusterStore:  storageBackend,
+				Logger:        p.logger.Named("quotas"),
 			})
 		})
 
@@ -102,24 +140,53 @@ func NewPlugin(ctx context.Context) *Plugin {
 			})
 		})
 
-	future.Wait6(p.storageBackend, p.mgmtClient, p.nodeManagerClient, p.uninstallController, p.clusterDriver, p.delegate,
+	future.Wait2(p.clusterDriver, p.config,
+		func(clusterDriver drivers.ClusterDriver, config *v1beta1.GatewayConfig) {
+			p.backfillRunner.Initialize(cortex.BackfillTaskRunnerConfig{
+				RemoteWriter:     &p.cortexRemoteWrite,
+				NewBucketClient:  clusterDriver.NewBucketClient,
+				Logger:           p.logger.Named("backfill"),
+				MaxExtractedSize: int64(config.Spec.Cortex.Import.MaxExtractedSizeMiB) << 20,
+			})
+		})
+
+	future.Wait10(p.storageBackend, p.mgmtClient, p.nodeManagerClient, p.uninstallController, p.uploadController, p.clusterDriver, p.delegate, p.config, p.tenantLimitsStore, p.remoteReadStore,
 		func(
 			storageBackend storage.Backend,
 			mgmtClient managementv1.ManagementClient,
 			nodeManagerClient capabilityv1.NodeManagerClient,
 			uninstallController *task.Controller,
+			uploadController *task.Controller,
 			clusterDriver drivers.ClusterDriver,
 			delegate streamext.StreamDelegate[remoteread.RemoteReadAgentClient],
+			config *v1beta1.GatewayConfig,
+			tenantLimitsStore storage.KeyValueStoreT[*cortexops.TenantLimitsSpec],
+			remoteReadStore storage.KeyValueStoreT[*remoteread.Target],
 		) {
+			secretKeys, err := machinery.LoadEphemeralKeys(afero.Afero{
+				Fs: afero.NewOsFs(),
+			}, config.Spec.Keyring.EphemeralKeyDirs...)
+			if err != nil {
+				p.logger.With(
+					zap.Error(err),
+				).Warn("error loading ephemeral keys")
+			}
 			p.metrics.Initialize(backend.MetricsBackendConfig{
-				Logger:              p.logger.Named("metrics-backend"),
-				StorageBackend:      storageBackend,
-				MgmtClient:          mgmtClient,
-				NodeManagerClient:   nodeManagerClient,
-				UninstallController: uninstallController,
-				ClusterDriver:       clusterDriver,
-				RemoteWriteClient:   &p.cortexRemoteWrite,
-				Delegate:            delegate,
+				PluginContext:         p.ctx,
+				Logger:                p.logger.Named("metrics-backend"),
+				StorageBackend:        storageBackend,
+				MgmtClient:            mgmtClient,
+				NodeManagerClient:     nodeManagerClient,
+				UninstallController:   uninstallController,
+				UploadController:      uploadController,
+				ClusterDriver:         clusterDriver,
+				RemoteWriteClient:     &p.cortexRemoteWrite,
+				Delegate:              delegate,
+				TenantLimitsStore:     tenantLimitsStore,
+				RemoteReadTargetStore: remoteReadStore,
+				RemoteWriteBuffer:     config.Spec.Cortex.RemoteWriteBuffer,
+				MaxUploadSize:         int64(config.Spec.Cortex.Import.MaxUploadSizeMiB) << 20,
+				SecretKeys:            secretKeys,
 			})
 		})
 
@@ -156,6 +223,8 @@ func Scheme(ctx context.Context) meta.Scheme {
 		util.PackService(&cortexadmin.CortexAdmin_ServiceDesc, &p.cortexAdmin),
 		util.PackService(&cortexops.CortexOps_ServiceDesc, &p.metrics),
 		util.PackService(&remoteread.RemoteReadGateway_ServiceDesc, &p.metrics),
+		util.PackService(&relabel.Relabeling_ServiceDesc, &p.relabeler),
+		util.PackService(&quota.IngestQuotas_ServiceDesc, &p.quotas),
 	))
 	scheme.Add(capability.CapabilityBackendPluginID, capability.NewPlugin(&p.metrics))
 	scheme.Add(metrics.MetricsPluginID, metrics.NewPlugin(p))
@@ -15,6 +15,10 @@ import (
 	"github.com/rancher/opni/pkg/machinery"
 	"github.com/rancher/opni/pkg/plugins/apis/system"
 	"github.com/rancher/opni/pkg/task"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexops"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/quota"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/relabel"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
 	"github.com/rancher/opni/plugins/metrics/pkg/cortex"
 )
 
@@ -77,5 +81,17 @@ func (p *Plugin) UseKeyValueStore(client system.KeyValueStoreClient) {
 		os.Exit(1)
 	}
 	p.uninstallController.Set(ctrl)
+	uploadCtrl, err := task.NewController(p.ctx, "backfill", system.NewKVStoreClient[*corev1.TaskStatus](client), &p.backfillRunner)
+	if err != nil {
+		p.logger.With(
+			zap.Error(err),
+		).Error("failed to create task controller")
+		os.Exit(1)
+	}
+	p.uploadController.Set(uploadCtrl)
+	p.relabelRuleStore.Set(system.NewKVStoreClient[*relabel.Rule](client))
+	p.quotaStore.Set(system.NewKVStoreClient[*quota.Quota](client))
+	p.tenantLimitsStore.Set(system.NewKVStoreClient[*cortexops.TenantLimitsSpec](client))
+	p.remoteReadStore.Set(system.NewKVStoreClient[*remoteread.Target](client))
 	<-p.ctx.Done()
 }
@@ -0,0 +1,418 @@
+package backfill_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/snappy"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-io/objstore"
+	blockmetadata "github.com/thanos-io/thanos/pkg/block/metadata"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/auth/cluster"
+	"github.com/rancher/opni/pkg/logger"
+	"github.com/rancher/opni/pkg/task"
+	"github.com/rancher/opni/pkg/test"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remotewrite"
+	"github.com/rancher/opni/plugins/metrics/pkg/cortex"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64                      { return s.t }
+func (s sample) V() float64                    { return s.v }
+func (s sample) H() *histogram.Histogram       { return nil }
+func (s sample) FH() *histogram.FloatHistogram { return nil }
+func (s sample) Type() chunkenc.ValueType      { return chunkenc.ValFloat }
+
+// mockRemoteWriter records the samples written for each cluster
+type mockRemoteWriter struct {
+	remotewrite.UnsafeRemoteWriteServer
+
+	mu      sync.Mutex
+	samples map[string]int
+}
+
+func (w *mockRemoteWriter) Push(ctx context.Context, payload *remotewrite.Payload) (*emptypb.Empty, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ids := md.Get(string(cluster.ClusterIDKey))
+	if len(ids) != 1 {
+		return nil, fmt.Errorf("missing cluster id")
+	}
+	data, err := snappy.Decode(nil, payload.Contents)
+	if err != nil {
+		return nil, err
+	}
+	wr := &prompb.WriteRequest{}
+	if err := wr.Unmarshal(data); err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ts := range wr.Timeseries {
+		w.samples[ids[0]] += len(ts.Samples)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (w *mockRemoteWriter) SyncRules(context.Context, *remotewrite.Payload) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (w *mockRemoteWriter) Samples(clusterId string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.samples[clusterId]
+}
+
+// createBlock writes a block with the given number of series, each with 10
+// samples, and returns its directory.
+func createBlock(dir string, numSeries int) string {
+	series := make([]storage.Series, 0, numSeries)
+	for i := 0; i < numSeries; i++ {
+		samples := make([]tsdbutil.Sample, 0, 10)
+		for j := int64(0); j < 10; j++ {
+			samples = append(samples, sample{t: j * 1000, v: float64(j)})
+		}
+		series = append(series, storage.NewListSeries(labels.FromStrings(
+			labels.MetricName, "test_metric",
+			"series", fmt.Sprint(i),
+		), samples))
+	}
+	blockDir, err := tsdb.CreateBlock(series, dir, 0, kitlog.NewNopLogger())
+	Expect(err).NotTo(HaveOccurred())
+	return blockDir
+}
+
+// writeArchive writes the contents of dir to a gzipped tar archive.
+func writeArchive(dir string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	Expect(filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(dir, path)
+		if name == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})).To(Succeed())
+	Expect(tw.Close()).To(Succeed())
+	Expect(gz.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+const openMetricsData = `# TYPE test_metric gauge
+test_metric{series="a"} 1 1000
+test_metric{series="a"} 2 2000
+test_metric{series="b"} 3 1000
+# EOF
+`
+
+// returns the IDs of the blocks uploaded for a tenant
+func uploadedBlocks(bucket *objstore.InMemBucket, tenant string) []string {
+	var ids []string
+	for name := range bucket.Objects() {
+		dir, file := path.Split(strings.TrimPrefix(name, tenant+"/"))
+		if file == "meta.json" && !strings.Contains(name, "opni-uploads") {
+			ids = append(ids, strings.TrimSuffix(dir, "/"))
+		}
+	}
+	return ids
+}
+
+var _ = Describe("Backfill", Ordered, Label("unit"), func() {
+	var (
+		controller *task.Controller
+		writer     *mockRemoteWriter
+		bucket     *objstore.InMemBucket
+		bucketErr  error
+		uploadDir  string
+		taskCount  int
+	)
+
+	BeforeAll(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		runner := &cortex.BackfillTaskRunner{}
+		runner.Initialize(cortex.BackfillTaskRunnerConfig{
+			RemoteWriter: &lazyRemoteWriter{get: func() *mockRemoteWriter { return writer }},
+			NewBucketClient: func(context.Context) (objstore.Bucket, error) {
+				if bucketErr != nil {
+					return nil, bucketErr
+				}
+				return bucket, nil
+			},
+			Logger:           logger.NewPluginLogger().Named("backfill"),
+			MaxExtractedSize: 4 << 20,
+		})
+		var err error
+		controller, err = task.NewController(context.Background(), "backfill",
+			test.NewTestKeyValueStore(ctrl, util.ProtoClone[*corev1.TaskStatus]), runner)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	BeforeEach(func() {
+		writer = &mockRemoteWriter{samples: map[string]int{}}
+		bucket = objstore.NewInMemBucket()
+		bucketErr = nil
+		uploadDir = GinkgoT().TempDir()
+	})
+
+	// stores the upload in the bucket and imports it
+	run := func(clusterId string, format remoteread.UploadFormat, data []byte) *corev1.TaskStatus {
+		taskCount++
+		id := fmt.Sprintf("backfill-%d", taskCount)
+		object := cortex.UploadObjectName(clusterId, id)
+		Expect(bucket.Upload(context.Background(), object, bytes.NewReader(data))).To(Succeed())
+
+		endState := make(chan task.State, 1)
+		Expect(controller.LaunchTask(id, task.WithMetadata(cortex.BackfillMetadata{
+			ClusterId: clusterId,
+			Format:    format,
+			Object:    object,
+		}), task.WithStateCallback(endState))).To(Succeed())
+		Eventually(endState).Should(Receive())
+		status, err := controller.TaskStatus(id)
+		Expect(err).NotTo(HaveOccurred())
+
+		// the upload is removed once the import finishes
+		Expect(bucket.Objects()).NotTo(HaveKey(object))
+		return status
+	}
+
+	// returns the last error logged by the task
+	lastLog := func(status *corev1.TaskStatus) string {
+		var msg string
+		for _, entry := range status.GetLogs() {
+			if entry.GetLevel() == int32(zapcore.ErrorLevel) {
+				msg = entry.GetMsg()
+			}
+		}
+		return msg
+	}
+
+	When("importing TSDB blocks", func() {
+		var archive []byte
+		var blockId string
+
+		BeforeEach(func() {
+			blockDir := createBlock(filepath.Join(uploadDir, "blocks"), 5)
+			blockId = filepath.Base(blockDir)
+			archive = writeArchive(filepath.Join(uploadDir, "blocks"))
+		})
+
+		It("should upload blocks to the tenant's object storage", func() {
+			status := run("cluster-1", remoteread.UploadFormat_TSDBBlocks, archive)
+			Expect(status.State).To(Equal(task.StateCompleted), lastLog(status))
+			Expect(status.Progress.Current).To(BeEquivalentTo(1))
+			Expect(status.Progress.Total).To(BeEquivalentTo(1))
+			Expect(writer.Samples("cluster-1")).To(BeZero())
+
+			data, ok := bucket.Objects()[fmt.Sprintf("cluster-1/%s/meta.json", blockId)]
+			Expect(ok).To(BeTrue())
+			meta, err := blockmetadata.Read(io.NopCloser(bytes.NewReader(data)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(meta.Thanos.Labels).To(Equal(map[string]string{"__org_id__": "cluster-1"}))
+			Expect(bucket.Objects()).To(HaveKey(fmt.Sprintf("cluster-1/%s/index", blockId)))
+		})
+
+		It("should fail if object storage is unavailable", func() {
+			Expect(bucket.Upload(context.Background(), "x", bytes.NewReader(nil))).To(Succeed())
+			bucketErr = errors.New("unavailable")
+			taskCount++
+			id := fmt.Sprintf("backfill-%d", taskCount)
+			endState := make(chan task.State, 1)
+			Expect(controller.LaunchTask(id, task.WithMetadata(cortex.BackfillMetadata{
+				ClusterId: "cluster-1",
+				Object:    cortex.UploadObjectName("cluster-1", id),
+			}), task.WithStateCallback(endState))).To(Succeed())
+			Eventually(endState).Should(Receive(Equal(task.StateFailed)))
+			status, err := controller.TaskStatus(id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastLog(status)).To(ContainSubstring("object storage is not accessible"))
+			Expect(writer.Samples("cluster-1")).To(BeZero())
+		})
+
+		It("should reject blocks when importing OpenMetrics files", func() {
+			status := run("cluster-1", remoteread.UploadFormat_OpenMetrics, archive)
+			Expect(status.State).To(Equal(task.StateFailed))
+			Expect(writer.Samples("cluster-1")).To(BeZero())
+			Expect(uploadedBlocks(bucket, "cluster-1")).To(BeEmpty())
+		})
+	})
+
+	When("importing OpenMetrics files", func() {
+		It("should convert old samples to blocks", func() {
+			status := run("cluster-2", remoteread.UploadFormat_Auto, []byte(openMetricsData))
+			Expect(status.State).To(Equal(task.StateCompleted), lastLog(status))
+			Expect(writer.Samples("cluster-2")).To(BeZero())
+
+			ids := uploadedBlocks(bucket, "cluster-2")
+			Expect(ids).To(HaveLen(1))
+			meta, err := blockmetadata.Read(io.NopCloser(bytes.NewReader(bucket.Objects()[fmt.Sprintf("cluster-2/%s/meta.json", ids[0])])))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(meta.Stats.NumSamples).To(BeEquivalentTo(3))
+			Expect(meta.Stats.NumSeries).To(BeEquivalentTo(2))
+			// openmetrics timestamps are in seconds
+			Expect(meta.MinTime).To(BeEquivalentTo(1000 * 1000))
+			Expect(meta.Thanos.Labels).To(Equal(map[string]string{"__org_id__": "cluster-2"}))
+		})
+
+		It("should split samples into blocks of the default block duration", func() {
+			hour := int64(time.Hour.Seconds())
+			data := fmt.Sprintf(`# TYPE test_metric gauge
+test_metric{series="a"} 1 %d
+test_metric{series="a"} 2 %d
+test_metric{series="a"} 3 %d
+# EOF
+`, hour, 3*hour, 30*hour)
+			status := run("cluster-2", remoteread.UploadFormat_OpenMetrics, []byte(data))
+			Expect(status.State).To(Equal(task.StateCompleted), lastLog(status))
+			Expect(uploadedBlocks(bucket, "cluster-2")).To(HaveLen(3))
+		})
+
+		It("should write recent samples using remote write", func() {
+			now := time.Now().Unix()
+			var buf strings.Builder
+			buf.WriteString("# TYPE test_metric gauge\n")
+			fmt.Fprintf(&buf, "test_metric{series=\"old\"} 1 %d\n", now-int64(2*time.Hour.Seconds()))
+			// large enough to be parsed in multiple batches
+			for i := 0; i < 50000; i++ {
+				fmt.Fprintf(&buf, "test_metric{series=\"new\"} %d %d.%03d\n", i, now-600+int64(i/1000), i%1000)
+			}
+			buf.WriteString("# EOF\n")
+
+			status := run("cluster-2", remoteread.UploadFormat_Auto, []byte(buf.String()))
+			Expect(status.State).To(Equal(task.StateCompleted), lastLog(status))
+			Expect(writer.Samples("cluster-2")).To(Equal(50000))
+			Expect(uploadedBlocks(bucket, "cluster-2")).To(HaveLen(1))
+		})
+
+		It("should import files in an archive alongside blocks", func() {
+			dir := filepath.Join(uploadDir, "data")
+			blockId := filepath.Base(createBlock(dir, 2))
+			Expect(os.WriteFile(filepath.Join(dir, "metrics.om"), []byte(openMetricsData), 0600)).To(Succeed())
+
+			status := run("cluster-2", remoteread.UploadFormat_Auto, writeArchive(dir))
+			Expect(status.State).To(Equal(task.StateCompleted), lastLog(status))
+			Expect(status.Progress.Total).To(BeEquivalentTo(2))
+			Expect(uploadedBlocks(bucket, "cluster-2")).To(HaveLen(2))
+			Expect(uploadedBlocks(bucket, "cluster-2")).To(ContainElement(blockId))
+		})
+
+		It("should fail if a sample has no timestamp", func() {
+			status := run("cluster-2", remoteread.UploadFormat_Auto, []byte(strings.Replace(openMetricsData, " 1 1000", " 1", 1)))
+			Expect(status.State).To(Equal(task.StateFailed))
+			Expect(lastLog(status)).To(ContainSubstring("has no timestamp"))
+			Expect(uploadedBlocks(bucket, "cluster-2")).To(BeEmpty())
+		})
+
+		It("should fail if the data does not end with # EOF", func() {
+			status := run("cluster-2", remoteread.UploadFormat_Auto, []byte(strings.TrimSuffix(openMetricsData, "# EOF\n")))
+			Expect(status.State).To(Equal(task.StateFailed))
+			Expect(lastLog(status)).To(ContainSubstring("does not end with # EOF"))
+		})
+	})
+
+	It("should fail if the upload is not a valid archive", func() {
+		status := run("cluster-1", remoteread.UploadFormat_TSDBBlocks, []byte("not a tar archive"))
+		Expect(status.State).To(Equal(task.StateFailed))
+		Expect(lastLog(status)).To(ContainSubstring("tar archive"))
+	})
+
+	It("should fail if the extracted upload is too large", func() {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write(make([]byte, 5<<20))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gz.Close()).To(Succeed())
+		Expect(buf.Len()).To(BeNumerically("<", 1<<20))
+
+		status := run("cluster-1", remoteread.UploadFormat_OpenMetrics, buf.Bytes())
+		Expect(status.State).To(Equal(task.StateFailed))
+		Expect(lastLog(status)).To(ContainSubstring("exceeds the maximum size of 4194304 bytes"))
+	})
+
+	It("should fail if the upload is no longer available", func() {
+		taskCount++
+		id := fmt.Sprintf("backfill-%d", taskCount)
+		endState := make(chan task.State, 1)
+		Expect(controller.LaunchTask(id, task.WithMetadata(cortex.BackfillMetadata{
+			ClusterId: "cluster-1",
+			Object:    cortex.UploadObjectName("cluster-1", id),
+		}), task.WithStateCallback(endState))).To(Succeed())
+		Eventually(endState).Should(Receive(Equal(task.StateFailed)))
+		status, err := controller.TaskStatus(id)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lastLog(status)).To(ContainSubstring("must be uploaded again"))
+	})
+})
+
+// lazyRemoteWriter forwards to the writer for the current test, since the
+// runner is only initialized once
+type lazyRemoteWriter struct {
+	remotewrite.UnsafeRemoteWriteServer
+	get func() *mockRemoteWriter
+}
+
+func (w *lazyRemoteWriter) Push(ctx context.Context, payload *remotewrite.Payload) (*emptypb.Empty, error) {
+	return w.get().Push(ctx, payload)
+}
+
+func (w *lazyRemoteWriter) SyncRules(ctx context.Context, payload *remotewrite.Payload) (*emptypb.Empty, error) {
+	return w.get().SyncRules(ctx, payload)
+}
@@ -0,0 +1,238 @@
+package buffer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/rancher/opni/pkg/clients"
+	"github.com/rancher/opni/pkg/health"
+	"github.com/rancher/opni/pkg/test"
+	"github.com/rancher/opni/plugins/metrics/pkg/agent"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/node"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remotewrite"
+)
+
+type testRemoteWriteClient struct {
+	mu        sync.Mutex
+	connected bool
+	payloads  []string
+}
+
+func (c *testRemoteWriteClient) Push(_ context.Context, in *remotewrite.Payload, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return nil, status.Error(codes.Unavailable, "stream closed")
+	}
+	c.payloads = append(c.payloads, string(in.Contents))
+	return &emptypb.Empty{}, nil
+}
+
+func (c *testRemoteWriteClient) SyncRules(context.Context, *remotewrite.Payload, ...grpc.CallOption) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (c *testRemoteWriteClient) setConnected(connected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = connected
+}
+
+func (c *testRemoteWriteClient) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.payloads...)
+}
+
+var _ = Describe("Remote Write Buffer", Label("unit"), func() {
+	var dir string
+	newBuffer := func(maxSize int64, maxAge time.Duration) *agent.RemoteWriteBuffer {
+		b, err := agent.NewRemoteWriteBuffer(&node.RemoteWriteBufferSpec{
+			Enabled:      true,
+			Dir:          dir,
+			MaxSizeBytes: maxSize,
+			MaxAge:       durationpb.New(maxAge),
+		}, test.Log)
+		Expect(err).NotTo(HaveOccurred())
+		return b
+	}
+	replayAll := func(b *agent.RemoteWriteBuffer) []string {
+		var replayed []string
+		Expect(b.Replay(context.Background(), func(_ context.Context, data []byte) error {
+			replayed = append(replayed, string(data))
+			return nil
+		})).To(Succeed())
+		return replayed
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	It("should replay payloads in order", func() {
+		b := newBuffer(0, 0)
+		for i := 0; i < 10; i++ {
+			Expect(b.Push([]byte(fmt.Sprint(i)))).To(Succeed())
+		}
+		count, size, full := b.Stats()
+		Expect(count).To(Equal(10))
+		Expect(size).To(BeEquivalentTo(10))
+		Expect(full).To(BeFalse())
+		Expect(replayAll(b)).To(Equal([]string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}))
+		Expect(b.Len()).To(BeZero())
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("should stop replaying when a payload fails", func() {
+		b := newBuffer(0, 0)
+		for i := 0; i < 3; i++ {
+			Expect(b.Push([]byte(fmt.Sprint(i)))).To(Succeed())
+		}
+		var replayed []string
+		err := b.Replay(context.Background(), func(_ context.Context, data []byte) error {
+			if string(data) == "1" {
+				return errors.New("unavailable")
+			}
+			replayed = append(replayed, string(data))
+			return nil
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(replayed).To(Equal([]string{"0"}))
+		Expect(replayAll(b)).To(Equal([]string{"1", "2"}))
+	})
+
+	It("should reject payloads once the buffer is full", func() {
+		b := newBuffer(10, 0)
+		Expect(b.Push(bytes.Repeat([]byte("x"), 6))).To(Succeed())
+		Expect(b.Push(bytes.Repeat([]byte("y"), 6))).To(MatchError(agent.ErrBufferFull))
+		_, _, full := b.Stats()
+		Expect(full).To(BeTrue())
+		Expect(b.Push(bytes.Repeat([]byte("z"), 4))).To(Succeed())
+		Expect(replayAll(b)).To(Equal([]string{"xxxxxx", "zzzz"}))
+		_, _, full = b.Stats()
+		Expect(full).To(BeFalse())
+	})
+
+	It("should discard expired payloads", func() {
+		b := newBuffer(0, 50*time.Millisecond)
+		Expect(b.Push([]byte("old"))).To(Succeed())
+		time.Sleep(100 * time.Millisecond)
+		Expect(b.Push([]byte("new"))).To(Succeed())
+		Expect(replayAll(b)).To(Equal([]string{"new"}))
+	})
+
+	It("should keep payloads pushed while an expired payload was replayed", func() {
+		b := newBuffer(0, 50*time.Millisecond)
+		Expect(b.Push([]byte("a"))).To(Succeed())
+		Expect(b.Push([]byte("b"))).To(Succeed())
+		var replayed []string
+		Expect(b.Replay(context.Background(), func(_ context.Context, data []byte) error {
+			if string(data) == "a" {
+				// "a" and "b" expire, and are discarded by the next push
+				time.Sleep(100 * time.Millisecond)
+				Expect(b.Push([]byte("c"))).To(Succeed())
+			}
+			replayed = append(replayed, string(data))
+			return nil
+		})).To(Succeed())
+		Expect(replayed).To(Equal([]string{"a", "c"}))
+		Expect(b.Len()).To(BeZero())
+	})
+
+	It("should load payloads stored by a previous buffer", func() {
+		b := newBuffer(0, 0)
+		Expect(b.Push([]byte("a"))).To(Succeed())
+		Expect(b.Push([]byte("b"))).To(Succeed())
+		// simulate an incomplete write
+		Expect(os.WriteFile(filepath.Join(dir, "99999999999999999999.payload.tmp"), []byte("c"), 0o600)).To(Succeed())
+
+		b = newBuffer(0, 0)
+		Expect(b.Len()).To(Equal(2))
+		Eventually(b.Notify()).Should(Receive())
+		Expect(b.Push([]byte("d"))).To(Succeed())
+		Expect(replayAll(b)).To(Equal([]string{"a", "b", "d"}))
+	})
+})
+
+var _ = Describe("HTTP Server Buffering", Label("unit"), func() {
+	var (
+		router     *gin.Engine
+		server     *agent.HttpServer
+		client     *testRemoteWriteClient
+		conditions health.ConditionTracker
+	)
+	push := func(contents string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/agent/push", bytes.NewBufferString(contents))
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		conditions = health.NewDefaultConditionTracker(test.Log)
+		server = agent.NewHttpServer(conditions, test.Log)
+		client = &testRemoteWriteClient{}
+		server.SetRemoteWriteClient(clients.NewLocker(nil, func(grpc.ClientConnInterface) remotewrite.RemoteWriteClient {
+			return client
+		}))
+		server.SetEnabled(true)
+		server.SetRemoteWriteBuffer(ctx, &node.RemoteWriteBufferSpec{
+			Enabled: true,
+			Dir:     GinkgoT().TempDir(),
+		})
+		router = gin.New()
+		server.ConfigureRoutes(router)
+	})
+
+	It("should send payloads directly while connected", func() {
+		client.setConnected(true)
+		Expect(push("a")).To(Equal(http.StatusOK))
+		Expect(client.received()).To(Equal([]string{"a"}))
+		annotations, _ := server.HealthAnnotations()
+		Expect(annotations).To(HaveKeyWithValue(agent.AnnotationRemoteWriteBufferPayloads, "0"))
+	})
+
+	It("should buffer payloads while disconnected and replay them in order", func() {
+		for _, p := range []string{"a", "b", "c"} {
+			Expect(push(p)).To(Equal(http.StatusOK))
+		}
+		Expect(client.received()).To(BeEmpty())
+		annotations, _ := server.HealthAnnotations()
+		Expect(annotations).To(HaveKeyWithValue(agent.AnnotationRemoteWriteBufferPayloads, "3"))
+		Expect(annotations).To(HaveKeyWithValue(agent.AnnotationRemoteWriteBufferBytes, "3"))
+		Expect(conditions.List()).To(ContainElement(agent.CondRemoteWriteBuffer + " Pending"))
+
+		client.setConnected(true)
+		Expect(push("d")).To(Equal(http.StatusOK))
+		Eventually(client.received, 10*time.Second).Should(Equal([]string{"a", "b", "c", "d"}))
+		Eventually(conditions.List).ShouldNot(ContainElement(HavePrefix(agent.CondRemoteWriteBuffer)))
+	})
+
+	It("should stop buffering when disabled", func() {
+		server.SetRemoteWriteBuffer(context.Background(), nil)
+		Expect(push("a")).To(Equal(http.StatusServiceUnavailable))
+		annotations, _ := server.HealthAnnotations()
+		Expect(annotations).To(BeNil())
+	})
+})
@@ -0,0 +1,224 @@
+package cardinality_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/test"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexadmin"
+	"github.com/rancher/opni/plugins/metrics/pkg/cortex"
+)
+
+type fakeClientSet struct {
+	cortex.ClientSet
+	client *http.Client
+}
+
+func (f *fakeClientSet) HTTP(...cortex.HTTPClientOption) *http.Client {
+	return f.client
+}
+
+var queriedNames = regexp.MustCompile(`__name__=~"([^"]*)"`)
+
+// vector returns the counts of the metrics selected by the query
+func vector(query string, counts map[string]int) map[string]any {
+	selected := map[string]bool{}
+	if m := queriedNames.FindStringSubmatch(query); m != nil {
+		for _, name := range strings.Split(m[1], "|") {
+			selected[name] = true
+		}
+	}
+	result := []any{}
+	for name, count := range counts {
+		if !selected[name] {
+			continue
+		}
+		result = append(result, map[string]any{
+			"metric": map[string]string{"__name__": name},
+			"value":  []any{1, fmt.Sprint(count)},
+		})
+	}
+	return map[string]any{
+		"resultType": "vector",
+		"result":     result,
+	}
+}
+
+var _ = Describe("Cardinality Report", Ordered, Label("unit"), func() {
+	var server *cortex.CortexAdminServer
+	var mu sync.Mutex
+	var queries []string
+	var labelValues map[string][]string
+	var extraSeries map[string]int
+	var failingMetric string
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		labelValues = map[string][]string{
+			"__name__": {"http_requests_total", "up", "node_cpu_seconds_total", "removed_metric"},
+			"pod":      {"a", "b", "c", "d", "e"},
+			"job":      {"node", "app"},
+			"cpu":      {"0", "1", "2"},
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/prometheus/api/v1/query", func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Header.Get("X-Scope-OrgID")).To(Equal("cluster-1"))
+			Expect(r.ParseForm()).To(Succeed())
+			query := r.Form.Get("query")
+			mu.Lock()
+			queries = append(queries, query)
+			mu.Unlock()
+			if failingMetric != "" && strings.Contains(query, failingMetric) {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			var counts map[string]int
+			if strings.Contains(query, "offset") {
+				counts = map[string]int{
+					"http_requests_total":    10,
+					"up":                     2,
+					"node_cpu_seconds_total": 40,
+					"removed_metric":         5,
+				}
+			} else {
+				counts = map[string]int{
+					"http_requests_total":    50,
+					"up":                     2,
+					"node_cpu_seconds_total": 48,
+				}
+			}
+			for name, count := range extraSeries {
+				counts[name] = count
+			}
+			data := vector(query, counts)
+			json.NewEncoder(w).Encode(map[string]any{"status": "success", "data": data})
+		})
+		mux.HandleFunc("/prometheus/api/v1/labels", func(w http.ResponseWriter, r *http.Request) {
+			names := []string{}
+			for name := range labelValues {
+				names = append(names, name)
+			}
+			json.NewEncoder(w).Encode(map[string]any{"status": "success", "data": names})
+		})
+		mux.HandleFunc("/prometheus/api/v1/label/", func(w http.ResponseWriter, r *http.Request) {
+			name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/prometheus/api/v1/label/"), "/values")
+			json.NewEncoder(w).Encode(map[string]any{"status": "success", "data": labelValues[name]})
+		})
+		srv := httptest.NewTLSServer(mux)
+		DeferCleanup(srv.Close)
+
+		server = &cortex.CortexAdminServer{}
+		server.Initialize(cortex.CortexAdminServerConfig{
+			CortexClientSet: &fakeClientSet{client: srv.Client()},
+			Config: &v1beta1.GatewayConfigSpec{
+				Cortex: v1beta1.CortexSpec{
+					QueryFrontend: v1beta1.QueryFrontendSpec{
+						HTTPAddress: strings.TrimPrefix(srv.URL, "https://"),
+					},
+				},
+			},
+			Logger: test.Log,
+		})
+	})
+
+	It("should validate requests", func() {
+		_, err := server.CardinalityReport(ctx, &cortexadmin.CardinalityRequest{})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+		_, err = server.CardinalityReport(ctx, &cortexadmin.CardinalityRequest{
+			Tenant:    "cluster-1",
+			CompareTo: durationpb.New(-time.Hour),
+		})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	})
+
+	It("should report the top metrics, growth, and labels", func() {
+		report, err := server.CardinalityReport(ctx, &cortexadmin.CardinalityRequest{
+			Tenant: "cluster-1",
+			Limit:  2,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(queries).To(ContainElement(ContainSubstring("offset 1d")))
+		Expect(report.GetPreviousTimestamp().AsTime()).To(BeTemporally("~", time.Now().Add(-24*time.Hour), time.Minute))
+
+		Expect(report.GetNumSeries()).To(BeEquivalentTo(100))
+		Expect(report.GetPreviousNumSeries()).To(BeEquivalentTo(57))
+		Expect(report.SeriesGrowth()).To(BeEquivalentTo(43))
+
+		Expect(report.GetTopMetrics()).To(HaveLen(2))
+		Expect(report.GetTopMetrics()[0].GetMetricName()).To(Equal("http_requests_total"))
+		Expect(report.GetTopMetrics()[1].GetMetricName()).To(Equal("node_cpu_seconds_total"))
+
+		Expect(report.GetTopGrowth()).To(HaveLen(2))
+		Expect(report.GetTopGrowth()[0].GetMetricName()).To(Equal("http_requests_total"))
+		Expect(report.GetTopGrowth()[0].SeriesGrowth()).To(BeEquivalentTo(40))
+		Expect(report.GetTopGrowth()[1].GetMetricName()).To(Equal("node_cpu_seconds_total"))
+		Expect(report.GetTopGrowth()[1].SeriesGrowth()).To(BeEquivalentTo(8))
+
+		Expect(report.GetTopLabels()).To(HaveLen(2))
+		Expect(report.GetTopLabels()[0].GetLabelName()).To(Equal("pod"))
+		Expect(report.GetTopLabels()[0].GetNumValues()).To(BeEquivalentTo(5))
+		Expect(report.GetTopLabels()[1].GetLabelName()).To(Equal("cpu"))
+		Expect(report.GetPartial()).To(BeFalse())
+	})
+
+	It("should count series in batches of metric names", func() {
+		extraSeries = map[string]int{}
+		for i := 0; i < 250; i++ {
+			name := fmt.Sprintf("extra_metric_%03d", i)
+			extraSeries[name] = 1
+			labelValues["__name__"] = append(labelValues["__name__"], name)
+		}
+		mu.Lock()
+		queries = nil
+		mu.Unlock()
+
+		report, err := server.CardinalityReport(ctx, &cortexadmin.CardinalityRequest{
+			Tenant: "cluster-1",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// 254 metric names, counted in 3 batches for each snapshot
+		Expect(queries).To(HaveLen(6))
+		for _, query := range queries {
+			Expect(query).NotTo(ContainSubstring(`".+"`))
+		}
+		Expect(report.GetNumSeries()).To(BeEquivalentTo(350))
+		Expect(report.GetPreviousNumSeries()).To(BeEquivalentTo(307))
+		Expect(report.GetPartial()).To(BeFalse())
+	})
+
+	It("should mark the report as partial if some series counts fail", func() {
+		failingMetric = "extra_metric_000"
+		DeferCleanup(func() {
+			failingMetric = ""
+		})
+
+		report, err := server.CardinalityReport(ctx, &cortexadmin.CardinalityRequest{
+			Tenant: "cluster-1",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// the first batch of 100 metrics is omitted from both snapshots
+		Expect(report.GetNumSeries()).To(BeEquivalentTo(250))
+		Expect(report.GetPreviousNumSeries()).To(BeEquivalentTo(207))
+		Expect(report.GetTopMetrics()[0].GetMetricName()).To(Equal("http_requests_total"))
+		Expect(report.GetPartial()).To(BeTrue())
+	})
+})
@@ -0,0 +1,312 @@
+package enforce_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/snappy"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/rancher/opni/pkg/rbac"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/cortex"
+)
+
+var namespaceA = []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "namespace", "a")}
+
+// capturedRequest holds the parts of a request forwarded to cortex
+type capturedRequest struct {
+	query url.Values
+	body  []byte
+}
+
+var _ = Describe("Label Enforcement", Label("unit"), func() {
+	var (
+		app          *gin.Engine
+		captured     *capturedRequest
+		clusterIDs   []string
+		restrictions rbac.ClusterLabelMatchers
+	)
+
+	BeforeEach(func() {
+		captured = nil
+		clusterIDs = []string{"c1"}
+		restrictions = rbac.ClusterLabelMatchers{"c1": namespaceA}
+
+		var le cortex.LabelEnforcer
+		app = gin.New()
+		app.Use(func(c *gin.Context) {
+			c.Set(rbac.AuthorizedClusterIDsKey, clusterIDs)
+			if len(restrictions) > 0 {
+				c.Set(rbac.AuthorizedLabelMatchersKey, restrictions)
+			}
+		})
+		capture := func(c *gin.Context) {
+			body, _ := io.ReadAll(c.Request.Body)
+			captured = &capturedRequest{
+				query: c.Request.URL.Query(),
+				body:  body,
+			}
+			c.Status(http.StatusOK)
+		}
+		app.GET("/query", le.Query, capture)
+		app.POST("/query", le.Query, capture)
+		app.GET("/series", le.Selectors, capture)
+		app.GET("/labels", le.Selectors, capture)
+		app.POST("/read", le.RemoteRead, capture)
+		app.DELETE("/series", le.DenyRestricted, capture)
+	})
+
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	get := func(path string, params url.Values) *httptest.ResponseRecorder {
+		return do(httptest.NewRequest(http.MethodGet, path+"?"+params.Encode(), nil))
+	}
+
+	Context("queries", func() {
+		It("should add the matchers to every selector", func() {
+			resp := get("/query", url.Values{
+				"query": {`sum(rate(http_requests_total{job="x"}[5m])) / on() group_left up`},
+			})
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(captured.query.Get("query")).To(Equal(
+				`sum(rate(http_requests_total{job="x",namespace="a"}[5m])) / on () group_left () up{namespace="a"}`))
+		})
+
+		It("should keep existing matchers on the same label", func() {
+			resp := get("/query", url.Values{
+				"query": {`up{namespace="b"}`},
+			})
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(captured.query.Get("query")).To(Equal(`up{namespace="a",namespace="b"}`))
+		})
+
+		It("should rewrite queries in url-encoded forms", func() {
+			form := url.Values{"query": {`up`}, "time": {"1"}}
+			req := httptest.NewRequest(http.MethodPost, "/query?query=down", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			resp := do(req)
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(captured.query.Get("query")).To(Equal(`down{namespace="a"}`))
+
+			body, err := url.ParseQuery(string(captured.body))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(body.Get("query")).To(Equal(`up{namespace="a"}`))
+			Expect(body.Get("time")).To(Equal("1"))
+		})
+
+		It("should reject forms in other encodings", func() {
+			req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"up"}`))
+			req.Header.Set("Content-Type", "application/json")
+			resp := do(req)
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			Expect(captured).To(BeNil())
+		})
+
+		It("should reject invalid queries", func() {
+			resp := get("/query", url.Values{"query": {`sum(`}})
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			Expect(captured).To(BeNil())
+		})
+
+		It("should not modify queries from unrestricted subjects", func() {
+			restrictions = nil
+			resp := get("/query", url.Values{"query": {`up`}})
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(captured.query.Get("query")).To(Equal(`up`))
+		})
+
+		It("should apply the matchers to unrestricted clusters in the same request", func() {
+			clusterIDs = []string{"c1", "c2"}
+			resp := get("/query", url.Values{"query": {`up`}})
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(captured.query.Get("query")).To(Equal(`up{namespace="a"}`))
+		})
+
+		It("should reject requests spanning clusters with different restrictions", func() {
+			clusterIDs = []string{"c1", "c2"}
+			restrictions = rbac.ClusterLabelMatchers{
+				"c1": namespaceA,
+				"c2": {labels.MustNewMatcher(labels.MatchEqual, "namespace", "b")},
+			}
+			resp := get("/query", url.Values{"query": {`up`}})
+			Expect(resp.Code).To(Equal(http.StatusForbidden))
+			Expect(captured).To(BeNil())
+		})
+	})
+
+	Context("series selectors", func() {
+		It("should add the matchers to each selector", func() {
+			resp := get("/series", url.Values{"match[]": {`up`, `{job="x"}`}})
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(captured.query["match[]"]).To(Equal([]string{
+				`{__name__="up",namespace="a"}`,
+				`{job="x",namespace="a"}`,
+			}))
+		})
+
+		It("should add a selector if there are none", func() {
+			resp := get("/labels", url.Values{})
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(captured.query["match[]"]).To(Equal([]string{`{namespace="a"}`}))
+		})
+	})
+
+	It("should add the matchers to remote read queries", func() {
+		readReq := &prompb.ReadRequest{
+			Queries: []*prompb.Query{{
+				Matchers: []*prompb.LabelMatcher{
+					{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+				},
+			}},
+		}
+		data, err := readReq.Marshal()
+		Expect(err).NotTo(HaveOccurred())
+
+		resp := do(httptest.NewRequest(http.MethodPost, "/read", bytes.NewReader(snappy.Encode(nil, data))))
+		Expect(resp.Code).To(Equal(http.StatusOK))
+
+		decoded, err := snappy.Decode(nil, captured.body)
+		Expect(err).NotTo(HaveOccurred())
+		rewritten := &prompb.ReadRequest{}
+		Expect(rewritten.Unmarshal(decoded)).To(Succeed())
+		Expect(rewritten.Queries[0].Matchers).To(ConsistOf(
+			&prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+			&prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: "namespace", Value: "a"},
+		))
+	})
+
+	It("should deny endpoints which cannot be filtered", func() {
+		resp := do(httptest.NewRequest(http.MethodDelete, "/series", nil))
+		Expect(resp.Code).To(Equal(http.StatusForbidden))
+
+		restrictions = nil
+		resp = do(httptest.NewRequest(http.MethodDelete, "/series", nil))
+		Expect(resp.Code).To(Equal(http.StatusOK))
+	})
+})
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var _ = Describe("Rule Aggregation", Label("unit"), func() {
+	codec := util.NewDelimiterCodec("X-Scope-OrgID", "|")
+
+	// responses from cortex, keyed by tenant ID
+	var responses map[string]string
+
+	newApp := func(format cortex.DataFormat) *gin.Engine {
+		client := &http.Client{
+			Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(responses[req.Header.Get(codec.Key())])),
+				}, nil
+			}),
+		}
+		aggregator := cortex.NewMultiTenantRuleAggregator(nil, client, codec, format)
+		app := gin.New()
+		app.Use(func(c *gin.Context) {
+			// server requests cannot be sent by a client as-is
+			c.Request.RequestURI = ""
+			c.Set(rbac.AuthorizedClusterIDsKey, []string{"c1", "c2"})
+			c.Set(rbac.AuthorizedLabelMatchersKey, rbac.ClusterLabelMatchers{"c1": namespaceA})
+		})
+		app.Any("/rules", aggregator.Handle)
+		return app
+	}
+
+	It("should filter rules in restricted clusters by their expressions", func() {
+		responses = map[string]string{
+			"c1": `{"status":"success","data":{"groups":[
+				{"name":"g1","file":"c1","rules":[
+					{"name":"r1","type":"alerting","query":"sum(rate(http_requests_total{namespace=\"a\"}[5m])) > 1","alerts":[
+						{"labels":{"pod":"p1"}}
+					]},
+					{"name":"r2","type":"recording","query":"up","labels":{"namespace":"a"}},
+					{"name":"r3","type":"recording","query":"up{namespace=\"a\"} / down"}
+				]},
+				{"name":"g2","file":"c1","rules":[
+					{"name":"r4","type":"recording","query":"up{namespace=~\"a\"}"}
+				]}
+			]}}`,
+			"c2": `{"status":"success","data":{"groups":[
+				{"name":"g3","file":"c2","rules":[
+					{"name":"r5","type":"recording","query":"up"}
+				]}
+			]}}`,
+		}
+		app := newApp(cortex.PrometheusRuleGroupsJSON)
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/rules", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		var resp struct {
+			Data struct {
+				Groups []struct {
+					Name  string `json:"name"`
+					Rules []struct {
+						Name   string `json:"name"`
+						Alerts []struct {
+							Labels map[string]string `json:"labels"`
+						} `json:"alerts"`
+					} `json:"rules"`
+				} `json:"groups"`
+			} `json:"data"`
+		}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp.Data.Groups).To(HaveLen(2))
+		Expect(resp.Data.Groups[0].Name).To(Equal("g1"))
+		// rule labels do not restrict the series read by the expression
+		Expect(resp.Data.Groups[0].Rules).To(HaveLen(1))
+		Expect(resp.Data.Groups[0].Rules[0].Name).To(Equal("r1"))
+		Expect(resp.Data.Groups[0].Rules[0].Alerts).To(HaveLen(1))
+		// the unrestricted cluster is not filtered
+		Expect(resp.Data.Groups[1].Name).To(Equal("g3"))
+	})
+
+	It("should filter rule configs in restricted clusters by their expressions", func() {
+		responses = map[string]string{
+			"c1": `ns1:
+  - name: g1
+    rules:
+      - record: r1
+        expr: up{namespace="a"}
+      - record: r2
+        expr: up
+        labels:
+          namespace: a
+`,
+			"c2": "",
+		}
+		app := newApp(cortex.NamespaceKeyedYAML)
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/rules", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(ContainSubstring("record: r1"))
+		Expect(recorder.Body.String()).NotTo(ContainSubstring("record: r2"))
+	})
+
+	It("should not allow restricted subjects to modify rules", func() {
+		app := newApp(cortex.NamespaceKeyedYAML)
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/rules", strings.NewReader("name: g1")))
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+	})
+})
@@ -0,0 +1,260 @@
+package _import
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/rancher/opni/pkg/clients"
+	"github.com/rancher/opni/pkg/logger"
+	"github.com/rancher/opni/pkg/test"
+	"github.com/rancher/opni/plugins/metrics/pkg/agent"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remotewrite"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// chunkReader records the time range of each read, and fails reads starting
+// at failAt.
+type chunkReader struct {
+	mu       sync.Mutex
+	ranges   [][2]int64
+	inFlight int
+	maxSeen  int
+
+	failAt int64
+	// if set, earlier chunks take longer to read than later ones
+	slowStart bool
+}
+
+func (reader *chunkReader) Read(_ context.Context, _ *remoteread.TargetSpec, readRequest *prompb.ReadRequest) (*prompb.ReadResponse, error) {
+	query := readRequest.Queries[0]
+
+	reader.mu.Lock()
+	reader.ranges = append(reader.ranges, [2]int64{query.StartTimestampMs, query.EndTimestampMs})
+	reader.inFlight++
+	if reader.inFlight > reader.maxSeen {
+		reader.maxSeen = reader.inFlight
+	}
+	reader.mu.Unlock()
+
+	delay := 10 * time.Millisecond
+	if reader.slowStart {
+		delay += time.Duration(10-query.StartTimestampMs/agent.TimeDeltaMillis) * 5 * time.Millisecond
+	}
+	time.Sleep(delay)
+
+	reader.mu.Lock()
+	reader.inFlight--
+	reader.mu.Unlock()
+
+	if reader.failAt >= 0 && query.StartTimestampMs == reader.failAt {
+		return nil, fmt.Errorf("failed")
+	}
+
+	return &prompb.ReadResponse{
+		Results: []*prompb.QueryResult{
+			{
+				Timeseries: []*prompb.TimeSeries{
+					{
+						Samples: []prompb.Sample{{Value: 1, Timestamp: query.StartTimestampMs}},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func (reader *chunkReader) Ranges() [][2]int64 {
+	reader.mu.Lock()
+	defer reader.mu.Unlock()
+	return append([][2]int64{}, reader.ranges...)
+}
+
+var _ = Describe("Chunked Imports", Label(test.Unit), func() {
+	var (
+		checkpointDir string
+		writerClient  *mockRemoteWriteClient
+
+		target = &remoteread.Target{
+			Meta: &remoteread.TargetMeta{
+				Name:      "test",
+				ClusterId: "00000-00000",
+			},
+			Spec: &remoteread.TargetSpec{
+				Endpoint: "http://127.0.0.1:9090/api/v1/read",
+			},
+		}
+
+		query = &remoteread.Query{
+			StartTimestamp: &timestamppb.Timestamp{},
+			EndTimestamp: &timestamppb.Timestamp{
+				Seconds: 5 * agent.TimeDeltaMillis / time.Second.Milliseconds(),
+			},
+		}
+	)
+
+	newRunner := func(reader agent.RemoteReader) agent.TargetRunner {
+		runner := agent.NewTargetRunner(logger.NewPluginLogger().Named("test-runner"), agent.WithCheckpointDir(checkpointDir))
+		runner.SetRemoteWriteClient(clients.NewLocker(nil, func(grpc.ClientConnInterface) remotewrite.RemoteWriteClient {
+			return writerClient
+		}))
+		runner.SetRemoteReaderClient(reader)
+		return runner
+	}
+
+	waitForState := func(runner agent.TargetRunner, state remoteread.TargetState) *remoteread.TargetStatus {
+		var status *remoteread.TargetStatus
+		Eventually(func() remoteread.TargetState {
+			status, _ = runner.GetStatus(target.Meta.Name)
+			return status.State
+		}).Should(Equal(state))
+		return status
+	}
+
+	BeforeEach(func() {
+		checkpointDir = GinkgoT().TempDir()
+		writerClient = &mockRemoteWriteClient{}
+	})
+
+	It("should split the import into chunks", func() {
+		reader := &chunkReader{failAt: -1}
+		runner := newRunner(reader)
+
+		Expect(runner.Start(target, query, &remoteread.ImportOptions{MaxConcurrency: 2})).To(Succeed())
+		status := waitForState(runner, remoteread.TargetState_Completed)
+
+		Expect(status.Progress.CompletedChunks).To(BeEquivalentTo(5))
+		Expect(status.Progress.TotalChunks).To(BeEquivalentTo(5))
+		Expect(status.Progress.LastReadTimestamp.AsTime()).To(Equal(query.EndTimestamp.AsTime()))
+
+		expected := make([]any, 0, 5)
+		for i := int64(0); i < 5; i++ {
+			expected = append(expected, [2]int64{i * agent.TimeDeltaMillis, (i + 1) * agent.TimeDeltaMillis})
+		}
+		Expect(reader.Ranges()).To(ConsistOf(expected...))
+		Expect(reader.maxSeen).To(BeNumerically("<=", 2))
+		Expect(writerClient.Payloads).To(HaveLen(5))
+	})
+
+	It("should push chunks in order", func() {
+		reader := &chunkReader{failAt: -1, slowStart: true}
+		runner := newRunner(reader)
+
+		Expect(runner.Start(target, query, &remoteread.ImportOptions{MaxConcurrency: 5})).To(Succeed())
+		waitForState(runner, remoteread.TargetState_Completed)
+
+		Expect(reader.maxSeen).To(BeNumerically(">", 1))
+		Expect(writerClient.Payloads).To(HaveLen(5))
+		for i, payload := range writerClient.Payloads {
+			uncompressed, err := snappy.Decode(nil, payload.Contents)
+			Expect(err).NotTo(HaveOccurred())
+			writeRequest := &prompb.WriteRequest{}
+			Expect(writeRequest.Unmarshal(uncompressed)).To(Succeed())
+			Expect(writeRequest.Timeseries[0].Samples[0].Timestamp).To(Equal(int64(i) * agent.TimeDeltaMillis))
+		}
+	})
+
+	It("should truncate the last chunk to the end of the import", func() {
+		reader := &chunkReader{failAt: -1}
+		runner := newRunner(reader)
+
+		Expect(runner.Start(target, query, &remoteread.ImportOptions{
+			ChunkDuration: durationpb.New(2 * time.Minute),
+		})).To(Succeed())
+		status := waitForState(runner, remoteread.TargetState_Completed)
+
+		Expect(status.Progress.TotalChunks).To(BeEquivalentTo(3))
+		Expect(reader.Ranges()).To(ContainElement([2]int64{4 * agent.TimeDeltaMillis, 5 * agent.TimeDeltaMillis}))
+	})
+
+	It("should reject invalid options", func() {
+		runner := newRunner(&chunkReader{failAt: -1})
+
+		Expect(runner.Start(target, query, &remoteread.ImportOptions{
+			ChunkDuration: durationpb.New(time.Millisecond),
+		})).NotTo(Succeed())
+		Expect(runner.Start(target, query, &remoteread.ImportOptions{
+			MaxConcurrency: -1,
+		})).NotTo(Succeed())
+	})
+
+	When("an import fails", func() {
+		var failedRunner agent.TargetRunner
+
+		BeforeEach(func() {
+			failedRunner = newRunner(&chunkReader{failAt: 2 * agent.TimeDeltaMillis})
+
+			Expect(failedRunner.Start(target, query, &remoteread.ImportOptions{MaxConcurrency: 1})).To(Succeed())
+			status := waitForState(failedRunner, remoteread.TargetState_Failed)
+
+			Expect(status.Message).To(Equal("failed to read from target endpoint: failed"))
+			Expect(status.Progress.CompletedChunks).To(BeEquivalentTo(2))
+			Expect(status.Progress.LastReadTimestamp.AsTime()).To(Equal(time.UnixMilli(2 * agent.TimeDeltaMillis).UTC()))
+		})
+
+		It("should resume from the last completed chunk after a restart", func() {
+			// a new runner with the same checkpoint dir simulates an agent restart
+			reader := &chunkReader{failAt: -1}
+			runner := newRunner(reader)
+
+			Expect(runner.Start(target, query, &remoteread.ImportOptions{MaxConcurrency: 1})).To(Succeed())
+			status := waitForState(runner, remoteread.TargetState_Completed)
+
+			Expect(status.Progress.CompletedChunks).To(BeEquivalentTo(5))
+			Expect(reader.Ranges()).To(Equal([][2]int64{
+				{2 * agent.TimeDeltaMillis, 3 * agent.TimeDeltaMillis},
+				{3 * agent.TimeDeltaMillis, 4 * agent.TimeDeltaMillis},
+				{4 * agent.TimeDeltaMillis, 5 * agent.TimeDeltaMillis},
+			}))
+
+			entries, err := os.ReadDir(checkpointDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+
+		It("should start over if the chunk duration changes", func() {
+			reader := &chunkReader{failAt: -1}
+			runner := newRunner(reader)
+
+			Expect(runner.Start(target, query, &remoteread.ImportOptions{
+				ChunkDuration: durationpb.New(30 * time.Second),
+			})).To(Succeed())
+			status := waitForState(runner, remoteread.TargetState_Completed)
+
+			Expect(status.Progress.TotalChunks).To(BeEquivalentTo(10))
+			Expect(reader.Ranges()).To(HaveLen(10))
+		})
+
+		It("should start over if the query changes", func() {
+			reader := &chunkReader{failAt: -1}
+			runner := newRunner(reader)
+
+			changed := &remoteread.Query{
+				StartTimestamp: query.StartTimestamp,
+				EndTimestamp:   query.EndTimestamp,
+				Matchers: []*remoteread.LabelMatcher{
+					{Type: remoteread.LabelMatcher_Equal, Name: "job", Value: "test"},
+				},
+			}
+			Expect(runner.Start(target, changed, nil)).To(Succeed())
+			waitForState(runner, remoteread.TargetState_Completed)
+
+			Expect(reader.Ranges()).To(HaveLen(5))
+		})
+
+		It("should persist the checkpoint", func() {
+			_, err := os.Stat(filepath.Join(checkpointDir, target.Meta.Name+".json"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
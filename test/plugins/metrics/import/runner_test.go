@@ -109,7 +109,7 @@ var _ = Describe("Target Runner", Ordered, Label(test.Unit), func() {
 		It("should fail", func() {
 			runner.SetRemoteReaderClient(failingReader)
 
-			err := runner.Start(target, query)
+			err := runner.Start(target, query, nil)
 			Expect(err).NotTo(HaveOccurred())
 
 			var status *remoteread.TargetStatus
@@ -138,7 +138,7 @@ var _ = Describe("Target Runner", Ordered, Label(test.Unit), func() {
 		It("should succeed", func() {
 			runner.SetRemoteReaderClient(newRespondingReader())
 
-			err := runner.Start(target, query)
+			err := runner.Start(target, query, nil)
 			Expect(err).NotTo(HaveOccurred())
 
 			var status *remoteread.TargetStatus
@@ -170,7 +170,7 @@ var _ = Describe("Target Runner", Ordered, Label(test.Unit), func() {
 		It("should complete", func() {
 			runner.SetRemoteReaderClient(newRespondingReader())
 
-			err := runner.Start(target, query)
+			err := runner.Start(target, query, nil)
 			Expect(err).NotTo(HaveOccurred())
 
 			var status *remoteread.TargetStatus
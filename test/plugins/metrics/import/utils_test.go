@@ -35,7 +35,7 @@ type mockRemoteReader struct {
 	i         int
 }
 
-func (reader *mockRemoteReader) Read(ctx context.Context, endpoint string, readRequest *prompb.ReadRequest) (*prompb.ReadResponse, error) {
+func (reader *mockRemoteReader) Read(ctx context.Context, target *remoteread.TargetSpec, readRequest *prompb.ReadRequest) (*prompb.ReadResponse, error) {
 	if reader.Error != nil {
 		return nil, reader.Error
 	}
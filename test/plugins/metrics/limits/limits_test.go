@@ -0,0 +1,389 @@
+package limits_test
+
+import (
+	"context"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	capabilityv1 "github.com/rancher/opni/pkg/apis/capability/v1"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	streamext "github.com/rancher/opni/pkg/plugins/apis/apiextensions/stream"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/task"
+	"github.com/rancher/opni/pkg/test"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexops"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/quota"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/relabel"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
+	"github.com/rancher/opni/plugins/metrics/pkg/backend"
+	"github.com/rancher/opni/plugins/metrics/pkg/cortex"
+	"github.com/rancher/opni/plugins/metrics/pkg/gateway/drivers"
+)
+
+// stubMgmtClient serves clusters from a cluster store
+type stubMgmtClient struct {
+	managementv1.ManagementClient
+	clusters storage.ClusterStore
+}
+
+func (c *stubMgmtClient) ListClusters(ctx context.Context, _ *managementv1.ListClustersRequest, _ ...grpc.CallOption) (*corev1.ClusterList, error) {
+	return c.clusters.ListClusters(ctx, &corev1.LabelSelector{}, corev1.MatchOptions_Default)
+}
+
+func (c *stubMgmtClient) WatchClusters(ctx context.Context, in *managementv1.WatchClustersRequest, _ ...grpc.CallOption) (managementv1.Management_WatchClustersClient, error) {
+	var known []*corev1.Cluster
+	for _, ref := range in.GetKnownClusters().GetItems() {
+		cluster, err := c.clusters.GetCluster(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		known = append(known, cluster)
+	}
+	events, err := c.clusters.WatchClusters(ctx, known)
+	if err != nil {
+		return nil, err
+	}
+	return &stubWatchClustersClient{ctx: ctx, events: events}, nil
+}
+
+type stubWatchClustersClient struct {
+	grpc.ClientStream
+	ctx    context.Context
+	events <-chan storage.WatchEvent[*corev1.Cluster]
+}
+
+func (s *stubWatchClustersClient) Recv() (*managementv1.WatchEvent, error) {
+	select {
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	case event := <-s.events:
+		switch event.EventType {
+		case storage.WatchEventCreate:
+			return &managementv1.WatchEvent{Cluster: event.Current, Type: managementv1.WatchEventType_Created}, nil
+		case storage.WatchEventUpdate:
+			return &managementv1.WatchEvent{Cluster: event.Current, Type: managementv1.WatchEventType_Updated}, nil
+		default:
+			return &managementv1.WatchEvent{Cluster: event.Previous, Type: managementv1.WatchEventType_Deleted}, nil
+		}
+	}
+}
+
+type stubNodeManagerClient struct {
+	capabilityv1.NodeManagerClient
+}
+
+type stubClientSet struct {
+	cortex.ClientSet
+}
+
+type stubDelegate struct {
+	streamext.StreamDelegate[remoteread.RemoteReadAgentClient]
+}
+
+func mustStruct(m map[string]any) *structpb.Struct {
+	s, err := structpb.NewStruct(m)
+	Expect(err).NotTo(HaveOccurred())
+	return s
+}
+
+var _ = Describe("Tenant Limits", Label("unit"), func() {
+	Context("validation", func() {
+		It("should accept known limits", func() {
+			limits, err := cortexops.ParseLimits(mustStruct(map[string]any{
+				"ingestion_rate":             50000,
+				"max_global_series_per_user": 100000,
+				"max_query_lookback":         "30d",
+			}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limits.IngestionRate).To(BeEquivalentTo(50000))
+			Expect(limits.MaxGlobalSeriesPerUser).To(Equal(100000))
+		})
+		It("should reject unknown limits", func() {
+			_, err := cortexops.ParseLimits(mustStruct(map[string]any{
+				"not_a_limit": 1,
+			}))
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+		It("should reject limits with the wrong type", func() {
+			_, err := cortexops.ParseLimits(mustStruct(map[string]any{
+				"max_global_series_per_user": "lots",
+			}))
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+		It("should require an id", func() {
+			err := (&cortexops.TenantLimitsSpec{}).Validate()
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+	})
+
+	Context("backend", Ordered, func() {
+		var mb *backend.MetricsBackend
+		var driver *test.TestEnvMetricsClusterDriver
+		var storageBackend storage.Backend
+		var ctx context.Context
+
+		BeforeAll(func() {
+			var ca context.CancelFunc
+			ctx, ca = context.WithCancel(context.Background())
+			DeferCleanup(ca)
+			ctrl := gomock.NewController(GinkgoT())
+			storageBackend = test.NewTestStorageBackend(ctx, ctrl)
+			driver = test.NewTestEnvMetricsClusterDriver(nil)
+			mb = &backend.MetricsBackend{}
+			mb.Initialize(backend.MetricsBackendConfig{
+				PluginContext:       ctx,
+				Logger:              test.Log,
+				StorageBackend:      storageBackend,
+				MgmtClient:          &stubMgmtClient{clusters: storageBackend},
+				NodeManagerClient:   &stubNodeManagerClient{},
+				UninstallController: &task.Controller{},
+				UploadController:    &task.Controller{},
+				ClusterDriver:       driver,
+				Delegate:            &stubDelegate{},
+				RemoteWriteClient: &cortex.RemoteWriteForwarder{
+					RemoteWriteForwarderConfig: cortex.RemoteWriteForwarderConfig{
+						CortexClientSet: &stubClientSet{},
+						Config:          &v1beta1.GatewayConfigSpec{},
+						Logger:          test.Log,
+						Relabeler: &cortex.Relabeler{
+							RelabelerConfig: cortex.RelabelerConfig{
+								Store:        test.NewTestKeyValueStore(ctrl, util.ProtoClone[*relabel.Rule]),
+								ClusterStore: storageBackend,
+								Logger:       test.Log,
+							},
+						},
+						Quotas: &cortex.QuotaEnforcer{
+							QuotaEnforcerConfig: cortex.QuotaEnforcerConfig{
+								PluginContext: ctx,
+								Store:         test.NewTestKeyValueStore(ctrl, util.ProtoClone[*quota.Quota]),
+								ClusterStore:  storageBackend,
+								Logger:        test.Log,
+							},
+						},
+					},
+				},
+				TenantLimitsStore:     test.NewTestKeyValueStore(ctrl, util.ProtoClone[*cortexops.TenantLimitsSpec]),
+				RemoteReadTargetStore: test.NewTestKeyValueStore(ctrl, util.ProtoClone[*remoteread.Target]),
+			})
+
+			for _, cluster := range []*corev1.Cluster{
+				{Id: "cluster-1", Metadata: &corev1.ClusterMetadata{Labels: map[string]string{"env": "prod"}}},
+				{Id: "cluster-2", Metadata: &corev1.ClusterMetadata{Labels: map[string]string{"env": "dev"}}},
+			} {
+				Expect(storageBackend.CreateCluster(ctx, cluster)).To(Succeed())
+			}
+		})
+
+		It("should reject overrides for unknown clusters", func() {
+			_, err := mb.SetTenantLimits(ctx, &cortexops.TenantLimitsSpec{
+				Id:     "missing",
+				Limits: mustStruct(map[string]any{"ingestion_rate": 100}),
+			})
+			Expect(status.Code(err)).To(Equal(codes.NotFound))
+		})
+
+		It("should reject invalid limits", func() {
+			_, err := mb.SetTenantLimits(ctx, &cortexops.TenantLimitsSpec{
+				Id:     "cluster-1",
+				Limits: mustStruct(map[string]any{"not_a_limit": 100}),
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+
+		It("should merge defaults with per-cluster overrides", func() {
+			_, err := mb.SetTenantLimits(ctx, &cortexops.TenantLimitsSpec{
+				Id: "prod",
+				Selector: &corev1.ClusterSelector{
+					LabelSelector: &corev1.LabelSelector{
+						MatchLabels: map[string]string{"env": "prod"},
+					},
+				},
+				Limits: mustStruct(map[string]any{
+					"ingestion_rate":             50000,
+					"max_global_series_per_user": 200000,
+				}),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = mb.SetTenantLimits(ctx, &cortexops.TenantLimitsSpec{
+				Id:     "cluster-1",
+				Limits: mustStruct(map[string]any{"ingestion_rate": 75000}),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			limits, err := mb.GetTenantLimits(ctx, &corev1.Reference{Id: "cluster-1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limits.GetDefaults()).To(ConsistOf("prod"))
+			Expect(limits.GetHasClusterOverrides()).To(BeTrue())
+			Expect(limits.GetLimits().AsMap()).To(Equal(map[string]any{
+				"ingestion_rate":             float64(75000),
+				"max_global_series_per_user": float64(200000),
+			}))
+
+			limits, err = mb.GetTenantLimits(ctx, &corev1.Reference{Id: "cluster-2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limits.GetDefaults()).To(BeEmpty())
+			Expect(limits.GetHasClusterOverrides()).To(BeFalse())
+			Expect(limits.GetLimits().GetFields()).To(BeEmpty())
+
+			list, err := mb.ListTenantLimits(ctx, &emptypb.Empty{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.GetItems()).To(HaveLen(2))
+			Expect(list.GetItems()[0].GetId()).To(Equal("prod"))
+			Expect(list.GetItems()[1].GetId()).To(Equal("cluster-1"))
+
+			Expect(driver.TenantLimitOverrides).To(HaveLen(1))
+			Expect(driver.TenantLimitOverrides).To(HaveKey("cluster-1"))
+			Expect(driver.TenantLimitOverrides["cluster-1"].AsMap()).To(HaveKeyWithValue("ingestion_rate", float64(75000)))
+		})
+
+		It("should delete specs with no limits", func() {
+			_, err := mb.SetTenantLimits(ctx, &cortexops.TenantLimitsSpec{
+				Id: "cluster-1",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			limits, err := mb.GetTenantLimits(ctx, &corev1.Reference{Id: "cluster-1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limits.GetHasClusterOverrides()).To(BeFalse())
+			Expect(limits.GetLimits().AsMap()).To(HaveKeyWithValue("ingestion_rate", float64(50000)))
+
+			_, err = mb.SetTenantLimits(ctx, &cortexops.TenantLimitsSpec{
+				Id:       "prod",
+				Selector: &corev1.ClusterSelector{},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			list, err := mb.ListTenantLimits(ctx, &emptypb.Empty{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.GetItems()).To(BeEmpty())
+			Expect(driver.TenantLimitOverrides).To(BeEmpty())
+		})
+
+		It("should reapply defaults when cluster labels change", func() {
+			_, err := mb.SetTenantLimits(ctx, &cortexops.TenantLimitsSpec{
+				Id: "prod",
+				Selector: &corev1.ClusterSelector{
+					LabelSelector: &corev1.LabelSelector{
+						MatchLabels: map[string]string{"env": "prod"},
+					},
+				},
+				Limits: mustStruct(map[string]any{"ingestion_rate": 50000}),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(driver.TenantLimitOverrides).NotTo(HaveKey("cluster-2"))
+
+			// the test cluster store only reports updates which change the
+			// resource version
+			_, err = storageBackend.UpdateCluster(ctx, &corev1.Reference{Id: "cluster-2"}, func(c *corev1.Cluster) {
+				c.Metadata.Labels["env"] = "prod"
+				c.SetResourceVersion("2")
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() map[string]*structpb.Struct {
+				return driver.TenantLimitOverrides
+			}).Should(HaveKey("cluster-2"))
+
+			_, err = storageBackend.UpdateCluster(ctx, &corev1.Reference{Id: "cluster-2"}, func(c *corev1.Cluster) {
+				c.Metadata.Labels["env"] = "dev"
+				c.SetResourceVersion("3")
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() map[string]*structpb.Struct {
+				return driver.TenantLimitOverrides
+			}).ShouldNot(HaveKey("cluster-2"))
+		})
+
+		It("should remove overrides for deleted clusters", func() {
+			_, err := mb.SetTenantLimits(ctx, &cortexops.TenantLimitsSpec{
+				Id:     "cluster-2",
+				Limits: mustStruct(map[string]any{"ingestion_rate": 1000}),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(driver.TenantLimitOverrides).To(HaveKey("cluster-2"))
+
+			Expect(storageBackend.DeleteCluster(ctx, &corev1.Reference{Id: "cluster-2"})).To(Succeed())
+			Eventually(func() map[string]*structpb.Struct {
+				return driver.TenantLimitOverrides
+			}).ShouldNot(HaveKey("cluster-2"))
+			Eventually(func() []*cortexops.TenantLimitsSpec {
+				list, err := mb.ListTenantLimits(ctx, &emptypb.Empty{})
+				Expect(err).NotTo(HaveOccurred())
+				return list.GetItems()
+			}).Should(HaveLen(1))
+		})
+	})
+
+	Context("runtime config", func() {
+		It("should write overrides to the cortex runtime config", func() {
+			ctx := context.Background()
+			cm := &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "cortex-runtime-config",
+					Namespace: "opni",
+				},
+				Data: map[string]string{
+					"runtime_config.yaml": "multi_kv_config:\n  primary: etcd\n",
+				},
+			}
+			k8sClient := fake.NewClientBuilder().WithObjects(cm).Build()
+			driver, err := drivers.NewOpniManagerClusterDriver(
+				drivers.WithK8sClient(k8sClient),
+				drivers.WithMonitoringCluster(types.NamespacedName{Namespace: "opni", Name: "opni"}),
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(driver.SetTenantLimitOverrides(ctx, map[string]*structpb.Struct{
+				"cluster-1": mustStruct(map[string]any{
+					"ingestion_rate":             50000,
+					"max_global_series_per_user": 200000,
+				}),
+			})).To(Succeed())
+
+			updated := &v1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "opni", Name: "cortex-runtime-config"}, updated)).To(Succeed())
+			data := updated.Data["runtime_config.yaml"]
+			Expect(data).To(ContainSubstring("max_global_series_per_user: 200000\n"))
+
+			runtimeConfig := map[string]any{}
+			Expect(yaml.Unmarshal([]byte(data), &runtimeConfig)).To(Succeed())
+			Expect(runtimeConfig).To(HaveKey("multi_kv_config"))
+			Expect(runtimeConfig["overrides"]).To(Equal(map[string]any{
+				"cluster-1": map[string]any{
+					"ingestion_rate":             50000,
+					"max_global_series_per_user": 200000,
+				},
+			}))
+
+			Expect(driver.SetTenantLimitOverrides(ctx, nil)).To(Succeed())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "opni", Name: "cortex-runtime-config"}, updated)).To(Succeed())
+			Expect(updated.Data["runtime_config.yaml"]).NotTo(ContainSubstring("overrides"))
+			Expect(updated.Data["runtime_config.yaml"]).To(ContainSubstring("multi_kv_config"))
+		})
+
+		It("should fail if cortex is not installed", func() {
+			driver, err := drivers.NewOpniManagerClusterDriver(
+				drivers.WithK8sClient(fake.NewClientBuilder().Build()),
+				drivers.WithMonitoringCluster(types.NamespacedName{Namespace: "opni", Name: "opni"}),
+			)
+			Expect(err).NotTo(HaveOccurred())
+			err = driver.SetTenantLimitOverrides(context.Background(), map[string]*structpb.Struct{})
+			Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+		})
+	})
+})
@@ -0,0 +1,250 @@
+package quota_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/clients"
+	"github.com/rancher/opni/pkg/health"
+	"github.com/rancher/opni/pkg/test"
+	"github.com/rancher/opni/pkg/test/testutil"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/agent"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/quota"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remotewrite"
+	"github.com/rancher/opni/plugins/metrics/pkg/cortex"
+)
+
+func writeRequest(numSeries, samplesPerSeries int) *prompb.WriteRequest {
+	wr := &prompb.WriteRequest{}
+	for i := 0; i < numSeries; i++ {
+		ts := prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "test_metric"},
+				{Name: "series", Value: fmt.Sprint(i)},
+			},
+		}
+		for j := 0; j < samplesPerSeries; j++ {
+			ts.Samples = append(ts.Samples, prompb.Sample{Value: 1, Timestamp: int64(j)})
+		}
+		wr.Timeseries = append(wr.Timeseries, ts)
+	}
+	return wr
+}
+
+var _ = Describe("Quota Enforcer", Ordered, Label("unit"), func() {
+	var enforcer *cortex.QuotaEnforcer
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		clusterStore := test.NewTestClusterStore(ctrl)
+		for id, env := range map[string]string{"cluster-1": "prod", "cluster-2": "dev", "cluster-3": "dev"} {
+			Expect(clusterStore.CreateCluster(ctx, &corev1.Cluster{
+				Id: id,
+				Metadata: &corev1.ClusterMetadata{
+					Labels: map[string]string{"env": env},
+				},
+			})).To(Succeed())
+		}
+		pluginCtx, cancel := context.WithCancel(ctx)
+		DeferCleanup(cancel)
+
+		enforcer = &cortex.QuotaEnforcer{}
+		enforcer.Initialize(cortex.QuotaEnforcerConfig{
+			PluginContext:       pluginCtx,
+			Store:               test.NewTestKeyValueStore(ctrl, util.ProtoClone[*quota.Quota]),
+			ClusterStore:        clusterStore,
+			Logger:              test.Log,
+			UsageUpdateInterval: 100 * time.Millisecond,
+		})
+	})
+
+	When("storing quotas", func() {
+		It("should reject invalid quotas", func() {
+			_, err := enforcer.PutQuota(ctx, &quota.Quota{
+				Id:     "empty",
+				Limits: &quota.Limits{},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+			_, err = enforcer.PutQuota(ctx, &quota.Quota{
+				Id: "negative",
+				Limits: &quota.Limits{
+					SamplesPerSecond: -1,
+				},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+			_, err = enforcer.PutQuota(ctx, &quota.Quota{
+				Id: "unbounded",
+				Limits: &quota.Limits{
+					ActiveSeries: quota.MaxActiveSeries + 1,
+				},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+		It("should apply the lowest matching limits", func() {
+			_, err := enforcer.PutQuota(ctx, &quota.Quota{
+				Id: "default",
+				Limits: &quota.Limits{
+					SamplesPerSecond: 1000,
+					ActiveSeries:     100,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = enforcer.PutQuota(ctx, &quota.Quota{
+				Id: "dev",
+				Selector: &corev1.ClusterSelector{
+					LabelSelector: &corev1.LabelSelector{
+						MatchLabels: map[string]string{"env": "dev"},
+					},
+				},
+				Limits: &quota.Limits{
+					SamplesPerSecond: 10,
+					BytesPerSecond:   1000,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			list, err := enforcer.ListQuotas(ctx, &emptypb.Empty{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(2))
+
+			limits, err := enforcer.LimitsFor(ctx, "cluster-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limits).To(testutil.ProtoEqual(&quota.Limits{
+				SamplesPerSecond: 1000,
+				ActiveSeries:     100,
+			}))
+
+			limits, err = enforcer.LimitsFor(ctx, "cluster-2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limits).To(testutil.ProtoEqual(&quota.Limits{
+				SamplesPerSecond: 10,
+				BytesPerSecond:   1000,
+				ActiveSeries:     100,
+			}))
+		})
+	})
+
+	When("admitting write requests", func() {
+		It("should enforce the active series limit", func() {
+			limits, err := enforcer.LimitsFor(ctx, "cluster-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(enforcer.Admit("cluster-1", limits, 100, writeRequest(80, 1))).To(BeZero())
+			// existing series do not count towards the limit again
+			Expect(enforcer.Admit("cluster-1", limits, 100, writeRequest(80, 1))).To(BeZero())
+
+			By("dropping only the series over the limit")
+			wr := writeRequest(101, 1)
+			dropped, err := enforcer.Admit("cluster-1", limits, 100, wr)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dropped).To(Equal(1))
+			Expect(wr.Timeseries).To(HaveLen(100))
+			Expect(wr.Timeseries[99].Labels).To(ContainElement(prompb.Label{Name: "series", Value: "99"}))
+
+			By("rejecting requests which only contain new series")
+			wr = &prompb.WriteRequest{Timeseries: writeRequest(101, 1).Timeseries[100:]}
+			_, err = enforcer.Admit("cluster-1", limits, 100, wr)
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			Expect(status.Convert(err).Message()).To(ContainSubstring("active series"))
+		})
+		It("should enforce the samples per second limit", func() {
+			limits, err := enforcer.LimitsFor(ctx, "cluster-2")
+			Expect(err).NotTo(HaveOccurred())
+			// the burst size is 10 seconds' worth of samples
+			Expect(enforcer.Admit("cluster-2", limits, 10, writeRequest(10, 10))).To(BeZero())
+			_, err = enforcer.Admit("cluster-2", limits, 10, writeRequest(10, 1))
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			Expect(status.Convert(err).Message()).To(ContainSubstring("samples per second"))
+		})
+		It("should enforce the bytes per second limit", func() {
+			limits, err := enforcer.LimitsFor(ctx, "cluster-3")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(enforcer.Admit("cluster-3", limits, 10000, writeRequest(1, 1))).To(BeZero())
+			_, err = enforcer.Admit("cluster-3", limits, 100, writeRequest(1, 1))
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			Expect(status.Convert(err).Message()).To(ContainSubstring("bytes per second"))
+		})
+		It("should report usage for each cluster", func() {
+			var usage *quota.ClusterUsageList
+			Eventually(func() float64 {
+				var err error
+				usage, err = enforcer.AllClusterUsage(ctx, &emptypb.Empty{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usage.Items).To(HaveLen(3))
+				return usage.Items[0].GetSamplesPerSecond()
+			}).Should(BeNumerically(">", 0))
+
+			cluster1 := usage.Items[0]
+			Expect(cluster1.GetClusterId()).To(Equal("cluster-1"))
+			Expect(cluster1.GetActiveSeries()).To(BeEquivalentTo(100))
+			Expect(cluster1.GetRejectedRequests()).To(BeEquivalentTo(1))
+			Expect(cluster1.GetRejectedSeries()).To(BeEquivalentTo(2))
+			Expect(cluster1.UsageRatios()).To(HaveKeyWithValue(quota.ActiveSeries, 1.0))
+			Expect(cluster1.UsageRatios()).NotTo(HaveKey(quota.BytesPerSecond))
+
+			cluster2 := usage.Items[1]
+			Expect(cluster2.GetClusterId()).To(Equal("cluster-2"))
+			Expect(cluster2.GetRejectedRequests()).To(BeEquivalentTo(1))
+		})
+		It("should stop enforcing deleted quotas", func() {
+			_, err := enforcer.DeleteQuota(ctx, &corev1.Reference{Id: "dev"})
+			Expect(err).NotTo(HaveOccurred())
+
+			limits, err := enforcer.LimitsFor(ctx, "cluster-3")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limits.GetBytesPerSecond()).To(BeZero())
+			Expect(enforcer.Admit("cluster-3", limits, 100000, writeRequest(1, 1))).To(BeZero())
+		})
+	})
+})
+
+type rejectingRemoteWriteClient struct {
+	err error
+}
+
+func (c *rejectingRemoteWriteClient) Push(context.Context, *remotewrite.Payload, ...grpc.CallOption) (*emptypb.Empty, error) {
+	return nil, c.err
+}
+
+func (c *rejectingRemoteWriteClient) SyncRules(context.Context, *remotewrite.Payload, ...grpc.CallOption) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+var _ = Describe("Agent Quota Handling", Label("unit"), func() {
+	It("should return 429 and set the remote write condition", func() {
+		conditions := health.NewDefaultConditionTracker(test.Log)
+		server := agent.NewHttpServer(conditions, test.Log)
+		client := &rejectingRemoteWriteClient{
+			err: status.Error(codes.ResourceExhausted, "ingestion quota exceeded for cluster foo"),
+		}
+		server.SetRemoteWriteClient(clients.NewLocker(nil, func(grpc.ClientConnInterface) remotewrite.RemoteWriteClient {
+			return client
+		}))
+		server.SetEnabled(true)
+		router := gin.New()
+		server.ConfigureRoutes(router)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/agent/push", bytes.NewBufferString("a")))
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(conditions.List()).To(ContainElement(agent.CondRemoteWrite + " Failure"))
+	})
+})
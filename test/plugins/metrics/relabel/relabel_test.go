@@ -0,0 +1,177 @@
+package relabel_test
+
+import (
+	"context"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/samber/lo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	"github.com/rancher/opni/pkg/test"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/relabel"
+	"github.com/rancher/opni/plugins/metrics/pkg/cortex"
+)
+
+func series(lbls ...string) prompb.TimeSeries {
+	ts := prompb.TimeSeries{
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}
+	for i := 0; i < len(lbls); i += 2 {
+		ts.Labels = append(ts.Labels, prompb.Label{Name: lbls[i], Value: lbls[i+1]})
+	}
+	return ts
+}
+
+var _ = Describe("Relabeler", Ordered, Label("unit"), func() {
+	var relabeler *cortex.Relabeler
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		clusterStore := test.NewTestClusterStore(ctrl)
+		Expect(clusterStore.CreateCluster(ctx, &corev1.Cluster{
+			Id: "cluster-1",
+			Metadata: &corev1.ClusterMetadata{
+				Labels: map[string]string{
+					corev1.NameLabel: "prod",
+					"env":            "prod",
+				},
+			},
+		})).To(Succeed())
+		Expect(clusterStore.CreateCluster(ctx, &corev1.Cluster{
+			Id: "cluster-2",
+			Metadata: &corev1.ClusterMetadata{
+				Labels: map[string]string{
+					"env": "dev",
+				},
+			},
+		})).To(Succeed())
+
+		relabeler = &cortex.Relabeler{}
+		relabeler.Initialize(cortex.RelabelerConfig{
+			Store:        test.NewTestKeyValueStore(ctrl, util.ProtoClone[*relabel.Rule]),
+			ClusterStore: clusterStore,
+			Logger:       test.Log,
+		})
+	})
+
+	When("storing rules", func() {
+		It("should reject invalid rules", func() {
+			_, err := relabeler.PutRule(ctx, &relabel.Rule{
+				Id: "invalid",
+				RelabelConfigs: []*relabel.RelabelConfig{
+					{Action: "hashmod", TargetLabel: "shard"},
+				},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+			_, err = relabeler.PutRule(ctx, &relabel.Rule{
+				Id: "invalid",
+				RelabelConfigs: []*relabel.RelabelConfig{
+					{Action: "unknown"},
+				},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+			_, err = relabeler.PutRule(ctx, &relabel.Rule{
+				Id: "empty",
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+		It("should store valid rules", func() {
+			_, err := relabeler.PutRule(ctx, &relabel.Rule{
+				Id: "20-drop-pod-uid",
+				RelabelConfigs: []*relabel.RelabelConfig{
+					{Action: "labeldrop", Regex: lo.ToPtr("pod_uid")},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = relabeler.PutRule(ctx, &relabel.Rule{
+				Id: "10-prod",
+				Selector: &corev1.ClusterSelector{
+					LabelSelector: &corev1.LabelSelector{
+						MatchLabels: map[string]string{"env": "prod"},
+					},
+				},
+				RelabelConfigs: []*relabel.RelabelConfig{
+					{
+						SourceLabels: []string{"__name__"},
+						Regex:        lo.ToPtr("expensive_.*"),
+						Action:       "drop",
+					},
+					{
+						TargetLabel: "cluster_name",
+						Replacement: lo.ToPtr(relabel.ClusterNamePlaceholder),
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			list, err := relabeler.ListRules(ctx, &emptypb.Empty{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(2))
+			Expect(list.Items[0].GetId()).To(Equal("10-prod"))
+			Expect(list.Items[1].GetId()).To(Equal("20-drop-pod-uid"))
+		})
+		It("should list the configs which apply to a cluster", func() {
+			configs, err := relabeler.GetClusterRelabelConfigs(ctx, &corev1.Reference{Id: "cluster-1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configs.Items).To(HaveLen(3))
+
+			configs, err = relabeler.GetClusterRelabelConfigs(ctx, &corev1.Reference{Id: "cluster-2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configs.Items).To(HaveLen(1))
+			Expect(configs.Items[0].GetAction()).To(Equal("labeldrop"))
+		})
+	})
+
+	When("relabeling write requests", func() {
+		It("should apply the configs for each cluster", func() {
+			newRequest := func() *prompb.WriteRequest {
+				return &prompb.WriteRequest{
+					Timeseries: []prompb.TimeSeries{
+						series("__name__", "expensive_metric", "pod_uid", "abc"),
+						series("__name__", "up", "pod_uid", "abc", "job", "test"),
+					},
+				}
+			}
+
+			configs, err := relabeler.ConfigsFor(ctx, "cluster-1")
+			Expect(err).NotTo(HaveOccurred())
+			req := newRequest()
+			Expect(cortex.RelabelWriteRequest(req, configs)).To(Equal(1))
+			Expect(req.Timeseries).To(HaveLen(1))
+			Expect(req.Timeseries[0].Labels).To(ConsistOf(
+				prompb.Label{Name: "__name__", Value: "up"},
+				prompb.Label{Name: "cluster_name", Value: "prod"},
+				prompb.Label{Name: "job", Value: "test"},
+			))
+			Expect(req.Timeseries[0].Samples).To(HaveLen(1))
+
+			configs, err = relabeler.ConfigsFor(ctx, "cluster-2")
+			Expect(err).NotTo(HaveOccurred())
+			req = newRequest()
+			Expect(cortex.RelabelWriteRequest(req, configs)).To(Equal(0))
+			Expect(req.Timeseries).To(HaveLen(2))
+			for _, ts := range req.Timeseries {
+				Expect(ts.Labels).NotTo(ContainElement(HaveField("Name", "pod_uid")))
+			}
+		})
+		It("should pick up rule changes", func() {
+			_, err := relabeler.DeleteRule(ctx, &corev1.Reference{Id: "20-drop-pod-uid"})
+			Expect(err).NotTo(HaveOccurred())
+
+			configs, err := relabeler.ConfigsFor(ctx, "cluster-2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configs).To(BeEmpty())
+		})
+	})
+})
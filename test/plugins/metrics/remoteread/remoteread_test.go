@@ -0,0 +1,348 @@
+package remoteread_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	capabilityv1 "github.com/rancher/opni/pkg/apis/capability/v1"
+	corev1 "github.com/rancher/opni/pkg/apis/core/v1"
+	managementv1 "github.com/rancher/opni/pkg/apis/management/v1"
+	"github.com/rancher/opni/pkg/config/v1beta1"
+	"github.com/rancher/opni/pkg/keyring"
+	streamext "github.com/rancher/opni/pkg/plugins/apis/apiextensions/stream"
+	"github.com/rancher/opni/pkg/storage"
+	"github.com/rancher/opni/pkg/task"
+	"github.com/rancher/opni/pkg/test"
+	"github.com/rancher/opni/pkg/util"
+	"github.com/rancher/opni/plugins/metrics/pkg/agent"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexops"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/quota"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/relabel"
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/remoteread"
+	"github.com/rancher/opni/plugins/metrics/pkg/backend"
+	"github.com/rancher/opni/plugins/metrics/pkg/cortex"
+)
+
+type stubMgmtClient struct {
+	managementv1.ManagementClient
+}
+
+func (stubMgmtClient) ListClusters(context.Context, *managementv1.ListClustersRequest, ...grpc.CallOption) (*corev1.ClusterList, error) {
+	return nil, status.Error(codes.Unimplemented, "not implemented")
+}
+
+type stubNodeManagerClient struct {
+	capabilityv1.NodeManagerClient
+}
+
+type stubClientSet struct {
+	cortex.ClientSet
+}
+
+type stubAgent struct {
+	remoteread.RemoteReadAgentClient
+	started []*remoteread.StartReadRequest
+}
+
+func (a *stubAgent) GetTargetStatus(context.Context, *remoteread.TargetStatusRequest, ...grpc.CallOption) (*remoteread.TargetStatus, error) {
+	return &remoteread.TargetStatus{State: remoteread.TargetState_NotRunning}, nil
+}
+
+func (a *stubAgent) Start(_ context.Context, in *remoteread.StartReadRequest, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	a.started = append(a.started, in)
+	return &emptypb.Empty{}, nil
+}
+
+type stubDelegate struct {
+	agent *stubAgent
+}
+
+func (d *stubDelegate) WithTarget(*corev1.Reference) remoteread.RemoteReadAgentClient {
+	return d.agent
+}
+
+func (d *stubDelegate) WithBroadcastSelector(*corev1.ClusterSelector, streamext.Aggregator) remoteread.RemoteReadAgentClient {
+	return d.agent
+}
+
+func newEncryptionKey() *keyring.EphemeralKey {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	Expect(err).NotTo(HaveOccurred())
+	return &keyring.EphemeralKey{
+		Usage:  keyring.Encryption,
+		Secret: secret,
+	}
+}
+
+func newMetricsBackend(ctx context.Context, ctrl *gomock.Controller, store storage.KeyValueStoreT[*remoteread.Target], agent *stubAgent, keys ...*keyring.EphemeralKey) *backend.MetricsBackend {
+	storageBackend := test.NewTestStorageBackend(ctx, ctrl)
+	mb := &backend.MetricsBackend{}
+	mb.Initialize(backend.MetricsBackendConfig{
+		PluginContext:       ctx,
+		Logger:              test.Log,
+		StorageBackend:      storageBackend,
+		MgmtClient:          &stubMgmtClient{},
+		NodeManagerClient:   &stubNodeManagerClient{},
+		UninstallController: &task.Controller{},
+		UploadController:    &task.Controller{},
+		ClusterDriver:       test.NewTestEnvMetricsClusterDriver(nil),
+		Delegate:            &stubDelegate{agent: agent},
+		RemoteWriteClient: &cortex.RemoteWriteForwarder{
+			RemoteWriteForwarderConfig: cortex.RemoteWriteForwarderConfig{
+				CortexClientSet: &stubClientSet{},
+				Config:          &v1beta1.GatewayConfigSpec{},
+				Logger:          test.Log,
+				Relabeler: &cortex.Relabeler{
+					RelabelerConfig: cortex.RelabelerConfig{
+						Store:        test.NewTestKeyValueStore(ctrl, util.ProtoClone[*relabel.Rule]),
+						ClusterStore: storageBackend,
+						Logger:       test.Log,
+					},
+				},
+				Quotas: &cortex.QuotaEnforcer{
+					QuotaEnforcerConfig: cortex.QuotaEnforcerConfig{
+						PluginContext: ctx,
+						Store:         test.NewTestKeyValueStore(ctrl, util.ProtoClone[*quota.Quota]),
+						ClusterStore:  storageBackend,
+						Logger:        test.Log,
+					},
+				},
+			},
+		},
+		TenantLimitsStore:     test.NewTestKeyValueStore(ctrl, util.ProtoClone[*cortexops.TenantLimitsSpec]),
+		RemoteReadTargetStore: store,
+		SecretKeys:            keys,
+	})
+	return mb
+}
+
+var _ = Describe("Remote Read Targets", Label("unit"), func() {
+	ctx := context.Background()
+
+	Context("validation", func() {
+		It("should require an endpoint", func() {
+			Expect(status.Code((&remoteread.TargetSpec{}).Validate())).To(Equal(codes.InvalidArgument))
+		})
+		It("should not allow both basic auth and a bearer token", func() {
+			err := (&remoteread.TargetSpec{
+				Endpoint: "http://prometheus/api/v1/read",
+				Auth: &remoteread.TargetAuth{
+					BasicAuth:   &remoteread.BasicAuth{Username: "user", Password: "pass"},
+					BearerToken: "token",
+				},
+			}).Validate()
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+		It("should require an https endpoint for tls settings", func() {
+			err := (&remoteread.TargetSpec{
+				Endpoint: "http://prometheus/api/v1/read",
+				Tls:      &remoteread.TargetTLS{InsecureSkipVerify: true},
+			}).Validate()
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+		It("should reject invalid certificates", func() {
+			err := (&remoteread.TargetSpec{
+				Endpoint: "https://prometheus/api/v1/read",
+				Tls:      &remoteread.TargetTLS{CaCertData: "not a cert"},
+			}).Validate()
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+			err = (&remoteread.TargetSpec{
+				Endpoint: "https://prometheus/api/v1/read",
+				Tls:      &remoteread.TargetTLS{CertData: "cert"},
+			}).Validate()
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+	})
+
+	Context("secrets", func() {
+		var ctrl *gomock.Controller
+		var store storage.KeyValueStoreT[*remoteread.Target]
+		var stub *stubAgent
+		meta := &remoteread.TargetMeta{ClusterId: "cluster-1", Name: "prom"}
+		newTarget := func() *remoteread.Target {
+			return &remoteread.Target{
+				Meta: meta,
+				Spec: &remoteread.TargetSpec{
+					Endpoint: "https://prometheus/api/v1/read",
+					Auth: &remoteread.TargetAuth{
+						BasicAuth: &remoteread.BasicAuth{Username: "user", Password: "hunter2"},
+					},
+					Tls: &remoteread.TargetTLS{
+						ServerName: "prometheus",
+					},
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			ctrl = gomock.NewController(GinkgoT())
+			store = test.NewTestKeyValueStore(ctrl, util.ProtoClone[*remoteread.Target])
+			stub = &stubAgent{}
+		})
+
+		It("should require an encryption key to store secrets", func() {
+			mb := newMetricsBackend(ctx, ctrl, store, stub)
+			_, err := mb.AddTarget(ctx, &remoteread.TargetAddRequest{Target: newTarget()})
+			Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+
+			target := newTarget()
+			target.Spec.Auth = nil
+			_, err = mb.AddTarget(ctx, &remoteread.TargetAddRequest{Target: target})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should encrypt, redact, and restore secrets", func() {
+			mb := newMetricsBackend(ctx, ctrl, store, stub, newEncryptionKey())
+			_, err := mb.AddTarget(ctx, &remoteread.TargetAddRequest{Target: newTarget()})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("encrypting stored secrets")
+			keys, err := store.ListKeys(ctx, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(HaveLen(1))
+			stored, err := store.Get(ctx, keys[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored.Spec.Auth.BasicAuth.Username).To(Equal("user"))
+			Expect(stored.Spec.Auth.BasicAuth.Password).To(HavePrefix("enc:"))
+			Expect(stored.Spec.Auth.BasicAuth.Password).NotTo(ContainSubstring("hunter2"))
+
+			By("redacting listed secrets")
+			list, err := mb.ListTargets(ctx, &remoteread.TargetListRequest{ClusterId: "cluster-1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Targets).To(HaveLen(1))
+			Expect(list.Targets[0].Spec.Auth.BasicAuth.Password).To(Equal(remoteread.Redacted))
+			Expect(list.Targets[0].Status.State).To(Equal(remoteread.TargetState_NotRunning))
+
+			list, err = mb.ListTargets(ctx, &remoteread.TargetListRequest{ClusterId: "cluster-2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Targets).To(BeEmpty())
+
+			By("keeping redacted secrets when editing")
+			_, err = mb.EditTarget(ctx, &remoteread.TargetEditRequest{
+				Meta: meta,
+				TargetDiff: &remoteread.TargetDiff{
+					Name: "prom2",
+					Auth: &remoteread.TargetAuth{
+						BasicAuth: &remoteread.BasicAuth{Username: "admin", Password: remoteread.Redacted},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("sending decrypted secrets to the agent")
+			_, err = mb.Start(ctx, &remoteread.StartReadRequest{
+				Target: &remoteread.Target{
+					Meta: &remoteread.TargetMeta{ClusterId: "cluster-1", Name: "prom2"},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stub.started).To(HaveLen(1))
+			spec := stub.started[0].Target.Spec
+			Expect(spec.Auth.BasicAuth.Username).To(Equal("admin"))
+			Expect(spec.Auth.BasicAuth.Password).To(Equal("hunter2"))
+			Expect(spec.Tls.ServerName).To(Equal("prometheus"))
+
+			By("removing auth settings")
+			_, err = mb.EditTarget(ctx, &remoteread.TargetEditRequest{
+				Meta: &remoteread.TargetMeta{ClusterId: "cluster-1", Name: "prom2"},
+				TargetDiff: &remoteread.TargetDiff{
+					Auth: &remoteread.TargetAuth{},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			list, err = mb.ListTargets(ctx, &remoteread.TargetListRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Targets).To(HaveLen(1))
+			Expect(list.Targets[0].Meta.Name).To(Equal("prom2"))
+			Expect(list.Targets[0].Spec.Auth).To(BeNil())
+		})
+
+		It("should decrypt secrets with any configured key", func() {
+			oldKey := newEncryptionKey()
+			mb := newMetricsBackend(ctx, ctrl, store, stub, oldKey)
+			_, err := mb.AddTarget(ctx, &remoteread.TargetAddRequest{Target: newTarget()})
+			Expect(err).NotTo(HaveOccurred())
+
+			rotated := newMetricsBackend(ctx, ctrl, store, stub, newEncryptionKey(), oldKey)
+			_, err = rotated.Start(ctx, &remoteread.StartReadRequest{Target: &remoteread.Target{Meta: meta}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stub.started[0].Target.Spec.Auth.BasicAuth.Password).To(Equal("hunter2"))
+
+			removed := newMetricsBackend(ctx, ctrl, store, stub, newEncryptionKey())
+			_, err = removed.Start(ctx, &remoteread.StartReadRequest{Target: &remoteread.Target{Meta: meta}})
+			Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+		})
+	})
+
+	Context("remote reader", func() {
+		It("should use the target's auth and tls settings", func() {
+			var authHeader string
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authHeader = r.Header.Get("Authorization")
+				data, err := proto.Marshal(&prompb.ReadResponse{
+					Results: []*prompb.QueryResult{{}},
+				})
+				if err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				io.Copy(w, bytes.NewReader(snappy.Encode(nil, data)))
+			}))
+			DeferCleanup(srv.Close)
+
+			caCert := pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: srv.Certificate().Raw,
+			})
+
+			reader := agent.NewRemoteReader(&http.Client{})
+			request := &prompb.ReadRequest{Queries: []*prompb.Query{{}}}
+
+			By("failing to verify the server without a CA")
+			_, err := reader.Read(ctx, &remoteread.TargetSpec{
+				Endpoint: srv.URL,
+			}, request)
+			Expect(err).To(HaveOccurred())
+
+			By("sending a bearer token")
+			resp, err := reader.Read(ctx, &remoteread.TargetSpec{
+				Endpoint: srv.URL,
+				Auth:     &remoteread.TargetAuth{BearerToken: "token"},
+				Tls:      &remoteread.TargetTLS{CaCertData: string(caCert)},
+			}, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Results).To(HaveLen(1))
+			Expect(authHeader).To(Equal("Bearer token"))
+
+			By("sending basic auth credentials")
+			_, err = reader.Read(ctx, &remoteread.TargetSpec{
+				Endpoint: srv.URL,
+				Auth: &remoteread.TargetAuth{
+					BasicAuth: &remoteread.BasicAuth{Username: "user", Password: "pass"},
+				},
+				Tls: &remoteread.TargetTLS{InsecureSkipVerify: true},
+			}, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.HasPrefix(authHeader, "Basic ")).To(BeTrue())
+		})
+	})
+})
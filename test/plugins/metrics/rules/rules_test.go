@@ -0,0 +1,239 @@
+package rules_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/rancher/opni/plugins/metrics/pkg/apis/cortexadmin"
+	"github.com/rancher/opni/plugins/metrics/pkg/cortex"
+)
+
+const ruleGroups = `
+groups:
+  - name: example
+    rules:
+      - record: job:http_requests:rate1m
+        expr: sum by (job) (rate(http_requests_total[1m]))
+      - alert: HighRequestRate
+        expr: job:http_requests:rate1m > 1
+        for: 2m
+        labels:
+          severity: warning
+        annotations:
+          summary: "{{ $labels.job }} is serving {{ $value }} requests per second"
+`
+
+const passingTests = `
+evaluation_interval: 1m
+tests:
+  - name: high request rate
+    interval: 1m
+    input_series:
+      - series: 'http_requests_total{job="api", instance="a"}'
+        values: '0+120x10'
+      - series: 'http_requests_total{job="api", instance="b"}'
+        values: '0+60x10'
+    alert_rule_test:
+      - eval_time: 1m
+        alertname: HighRequestRate
+        exp_alerts: []
+      - eval_time: 5m
+        alertname: HighRequestRate
+        exp_alerts:
+          - exp_labels:
+              severity: warning
+              job: api
+            exp_annotations:
+              summary: api is serving 3 requests per second
+    promql_expr_test:
+      - expr: job:http_requests:rate1m
+        eval_time: 5m
+        exp_samples:
+          - labels: 'job:http_requests:rate1m{job="api"}'
+            value: 3
+      - expr: scalar(job:http_requests:rate1m)
+        eval_time: 5m
+        exp_samples:
+          - value: 3
+`
+
+var _ = Describe("Rules", Label("unit"), func() {
+	Context("LintRuleGroups", func() {
+		It("should report parse errors", func() {
+			diagnostics := cortex.LintRuleGroups([]byte(`
+groups:
+  - name: example
+    rules:
+      - record: job:up
+        expr: sum by (job) (up
+`), nil)
+			Expect(diagnostics).To(HaveLen(1))
+			Expect(diagnostics[0].Severity).To(Equal(cortexadmin.RuleDiagnostic_Error))
+			Expect(diagnostics[0].Message).To(ContainSubstring("could not parse expression"))
+		})
+		It("should report duplicate rules", func() {
+			diagnostics := cortex.LintRuleGroups([]byte(`
+groups:
+  - name: example
+    rules:
+      - record: job:up
+        expr: sum by (job) (up)
+      - record: job:up
+        expr: count by (job) (up)
+      - record: job:up
+        expr: sum by (job) (up)
+        labels:
+          source: other
+`), nil)
+			Expect(diagnostics).To(HaveLen(1))
+			Expect(diagnostics[0].Severity).To(Equal(cortexadmin.RuleDiagnostic_Warning))
+			Expect(diagnostics[0].Group).To(Equal("example"))
+			Expect(diagnostics[0].Rule).To(Equal("job:up"))
+			Expect(diagnostics[0].Message).To(ContainSubstring("duplicate"))
+		})
+		It("should report unknown metrics if the known metrics are given", func() {
+			content := []byte(`
+groups:
+  - name: example
+    rules:
+      - record: job:up
+        expr: sum by (job) (up)
+      - alert: Down
+        expr: job:up == 0 or absent({__name__="node_load1"}) or ALERTS{alertname="Other"}
+      - alert: Restarts
+        expr: increase(kube_pod_container_status_restarts_total[5m]) > 0 and {__name__=~"node_.*"}
+`)
+			Expect(cortex.LintRuleGroups(content, nil)).To(BeEmpty())
+
+			diagnostics := cortex.LintRuleGroups(content, map[string]struct{}{
+				"up": {},
+			})
+			Expect(diagnostics).To(HaveLen(2))
+			Expect(diagnostics[0].Rule).To(Equal("Down"))
+			Expect(diagnostics[0].Message).To(Equal(`unknown metric "node_load1"`))
+			Expect(diagnostics[1].Rule).To(Equal("Restarts"))
+			Expect(diagnostics[1].Message).To(Equal(`unknown metric "kube_pod_container_status_restarts_total"`))
+		})
+	})
+
+	Context("RunRuleTests", func() {
+		It("should pass tests matching the rule output", func() {
+			results, err := cortex.RunRuleTests(context.Background(), []byte(ruleGroups), []byte(passingTests))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Name).To(Equal("high request rate"))
+			Expect(results[0].Failures).To(BeEmpty())
+		})
+		It("should report failures for unexpected alerts and samples", func() {
+			results, err := cortex.RunRuleTests(context.Background(), []byte(ruleGroups), []byte(`
+tests:
+  - input_series:
+      - series: 'http_requests_total{job="api"}'
+        values: '0+120x10'
+    alert_rule_test:
+      - eval_time: 2m
+        alertname: HighRequestRate
+        exp_alerts:
+          - exp_labels:
+              severity: warning
+              job: api
+    promql_expr_test:
+      - expr: job:http_requests:rate1m
+        eval_time: 2m
+        exp_samples:
+          - labels: 'job:http_requests:rate1m{job="api"}'
+            value: 1
+  - input_series:
+      - series: 'http_requests_total{job="api"}'
+        values: '0 _ 0 0 0'
+    alert_rule_test:
+      - eval_time: 4m
+        alertname: HighRequestRate
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Name).To(Equal("test 1"))
+			Expect(results[0].Failures).To(HaveLen(2))
+			// the alert is still pending at 2m
+			Expect(results[0].Failures[0]).To(HavePrefix("alertname: HighRequestRate, time: 2m"))
+			Expect(results[0].Failures[0]).To(HaveSuffix("got: []"))
+			Expect(results[0].Failures[1]).To(HavePrefix(`expr: "job:http_requests:rate1m", time: 2m`))
+			Expect(results[0].Failures[1]).To(ContainSubstring(`got: [{__name__="job:http_requests:rate1m", job="api"} 2]`))
+			Expect(results[1].Name).To(Equal("test 2"))
+			Expect(results[1].Failures).To(BeEmpty())
+		})
+		It("should reject invalid files", func() {
+			_, err := cortex.RunRuleTests(context.Background(), []byte("groups: [{name: a, rules: [{record: b}]}]"), []byte(passingTests))
+			Expect(err).To(MatchError(ContainSubstring("invalid rules")))
+
+			_, err = cortex.RunRuleTests(context.Background(), []byte(ruleGroups), []byte(`
+tests:
+  - input_series: []
+    alert_rules_test: []
+`))
+			Expect(err).To(MatchError(ContainSubstring("field alert_rules_test not found")))
+
+			_, err = cortex.RunRuleTests(context.Background(), []byte(ruleGroups), []byte(`
+group_eval_order: [other]
+tests: []
+`))
+			Expect(err).To(MatchError(ContainSubstring(`unknown rule group "other"`)))
+		})
+		It("should report invalid input series as failures", func() {
+			results, err := cortex.RunRuleTests(context.Background(), []byte(ruleGroups), []byte(`
+tests:
+  - input_series:
+      - series: 'http_requests_total{job="api"'
+        values: '1 2 3'
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Failures).To(ConsistOf(ContainSubstring("invalid input series")))
+		})
+		It("should reject tests which exceed the input sample or evaluation step limits", func() {
+			_, err := cortex.RunRuleTests(context.Background(), []byte(ruleGroups), []byte(`
+tests:
+  - input_series:
+      - series: 'http_requests_total{job="api"}'
+        values: '0+1x600000'
+      - series: 'http_requests_total{job="web"}'
+        values: '0+1x600000'
+`))
+			Expect(err).To(MatchError(ContainSubstring("input series must not contain more than 1000000 samples")))
+
+			_, err = cortex.RunRuleTests(context.Background(), []byte(ruleGroups), []byte(`
+evaluation_interval: 1ms
+tests:
+  - input_series: []
+    promql_expr_test:
+      - expr: vector(1)
+        eval_time: 1h
+        exp_samples: []
+`))
+			Expect(err).To(MatchError(ContainSubstring("must not require more than 100000 evaluation steps")))
+		})
+		It("should reject oversized requests", func() {
+			large := make([]byte, cortexadmin.MaxRuleTestFileSize+1)
+			Expect((&cortexadmin.TestRulesRequest{
+				RulesYaml: large,
+				TestsYaml: []byte(passingTests),
+			}).Validate()).To(MatchError(ContainSubstring("rulesYaml must not be larger than")))
+			Expect((&cortexadmin.TestRulesRequest{
+				RulesYaml: []byte(ruleGroups),
+				TestsYaml: large,
+			}).Validate()).To(MatchError(ContainSubstring("testsYaml must not be larger than")))
+			Expect((&cortexadmin.TestRulesRequest{
+				RulesYaml: []byte(ruleGroups),
+				TestsYaml: []byte(passingTests),
+			}).Validate()).To(Succeed())
+		})
+		It("should stop running tests when the deadline is exceeded", func() {
+			ctx, ca := context.WithTimeout(context.Background(), 0)
+			defer ca()
+			_, err := cortex.RunRuleTests(ctx, []byte(ruleGroups), []byte(passingTests))
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+})